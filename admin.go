@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway"
+)
+
+// adminClient talks to the admin API of a running matterbridge.
+type adminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newAdminClient(cfg config.Config) (*adminClient, error) {
+	general := cfg.BridgeValues().General
+	if general.AdminListen == "" {
+		return nil, errors.New("AdminListen is not set in the [general] section of the configuration")
+	}
+	host, port, err := net.SplitHostPort(general.AdminListen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AdminListen %q: %w", general.AdminListen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return &adminClient{
+		baseURL: "http://" + net.JoinHostPort(host, port),
+		token:   general.AdminToken,
+		client:  &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// send sends a request to the admin API, returning the status code and body
+// of the response.
+func (c *adminClient) send(method string, path string) (int, []byte, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, nil) //nolint:noctx
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// do sends a request to the admin API and decodes its JSON response in result.
+func (c *adminClient) do(method string, path string, result interface{}) error {
+	status, body, err := c.send(method, path)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return errors.New(apiErr.Message)
+		}
+		return fmt.Errorf("admin API returned %d %s", status, http.StatusText(status))
+	}
+	return json.Unmarshal(body, result)
+}
+
+// runHealthcheck checks the health of the running matterbridge, for container
+// health checks. It returns an error when a bridge isn't connected, and
+// succeeds when the admin API isn't enabled as there is nothing to check.
+func runHealthcheck(cfg config.Config, out io.Writer) error {
+	if cfg.BridgeValues().General.AdminListen == "" {
+		fmt.Fprintln(out, "AdminListen is not set, the health of matterbridge can't be checked")
+		return nil
+	}
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+	status, body, err := client.send(http.MethodGet, "/healthz")
+	if err != nil {
+		return err
+	}
+	var health gateway.Health
+	if err := json.Unmarshal(body, &health); err != nil {
+		return fmt.Errorf("invalid health response (%d %s): %w", status, http.StatusText(status), err)
+	}
+	for _, br := range health.Bridges {
+		if br.State == gateway.BridgeConnected {
+			continue
+		}
+		line := fmt.Sprintf("%s is %s since %s", br.Account, br.State, br.Since.Format(time.DateTime))
+		if br.LastError != "" {
+			line += fmt.Sprintf(" (%d failed attempts: %s)", br.Attempts, br.LastError)
+		}
+		fmt.Fprintln(out, line)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("matterbridge is %s", health.Status)
+	}
+	fmt.Fprintf(out, "matterbridge is %s\n", health.Status)
+	return nil
+}
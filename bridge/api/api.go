@@ -3,26 +3,51 @@ package api
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/olahol/melody"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/mitchellh/mapstructure"
+	"github.com/rs/xid"
 	ring "github.com/zfjagann/golang-ring"
 )
 
+// postedSize is the number of messages posted to the API which can be edited
+// or deleted, like the message cache of the gateways.
+const postedSize = 5000
+
+const (
+	// websocketMaxMessageSize is the largest message accepted on the
+	// websockets, the files are posted to /api/message
+	websocketMaxMessageSize = 64 * 1024
+	// slowSessionKey flags the websocket sessions closed for not reading
+	// their messages, see closeSlowSession
+	slowSessionKey = "slow"
+)
+
 type API struct {
 	Messages ring.Ring
 	sync.RWMutex
 	*bridge.Config
 	mrouter *melody.Melody
+	// posted holds the gateway of the messages posted to the API by their ID
+	posted *lru.Cache
+	// envelope encrypts the messages when a key is configured
+	envelope *envelope
+	// heartbeats returns the heartbeats of the bridges, see SetHeartbeats
+	heartbeats func() []bridge.Heartbeat
 }
 
 type Message struct {
@@ -35,45 +60,41 @@ type Message struct {
 
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &API{Config: cfg}
+	b.posted, _ = lru.New(postedSize)
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
 
-	b.mrouter = melody.New()
-	b.mrouter.HandleMessage(func(s *melody.Session, msg []byte) {
-		message := config.Message{}
-		err := json.Unmarshal(msg, &message)
-		if err != nil {
-			b.Log.Errorf("failed to decode message from byte[] '%s'", string(msg))
-			return
-		}
-		b.handleWebsocketMessage(message, s)
-	})
-	b.mrouter.HandleConnect(func(session *melody.Session) {
-		greet := b.getGreeting()
-		data, err := json.Marshal(greet)
-		if err != nil {
-			b.Log.Errorf("failed to encode message '%v'", greet)
-			return
-		}
-		err = session.Write(data)
-		if err != nil {
-			b.Log.Errorf("failed to write message '%s'", string(data))
-			return
-		}
-		// TODO: send message history buffer from `b.Messages` here
-	})
+	b.mrouter = b.newWebsocket()
 
 	b.Messages = ring.Ring{}
 	if b.GetInt("Buffer") != 0 {
 		b.Messages.SetCapacity(b.GetInt("Buffer"))
 	}
 	if b.GetString("Token") != "" {
-		e.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
-			return key == b.GetString("Token"), nil
+		// the websocket clients of the browsers can't set headers
+		e.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+			KeyLookup: "header:" + echo.HeaderAuthorization + ",query:token",
+			Validator: func(key string, c echo.Context) (bool, error) {
+				return key == b.GetString("Token"), nil
+			},
 		}))
 	}
 
+	env, err := newEnvelope(b.GetString("SharedKey"), b.GetString("PrivateKey"), b.GetString("PeerPublicKey"))
+	if err != nil {
+		b.Log.Fatalf("Invalid encryption settings: %s", err)
+	}
+	b.envelope = env
+	if env != nil && b.Store != nil {
+		if err := env.keepNonces(b.Store, nonceNamespace+b.Account); err != nil {
+			b.Log.Errorf("Restoring the nonces of the encrypted messages failed, the messages sealed before the start are refused: %s", err)
+		}
+	}
+	if env != nil && env.publicKey != "" {
+		b.Log.Infof("Messages are encrypted, the PeerPublicKey of this instance is %s", env.publicKey)
+	}
+
 	// Set RemoteNickFormat to a sane default
 	if !b.IsKeySet("RemoteNickFormat") {
 		b.Log.Debugln("RemoteNickFormat is unset, defaulting to \"{NICK}\"")
@@ -81,10 +102,13 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	}
 
 	e.GET("/api/health", b.handleHealthcheck)
+	e.GET("/api/heartbeats", b.handleHeartbeats)
 	e.GET("/api/messages", b.handleMessages)
 	e.GET("/api/stream", b.handleStream)
 	e.GET("/api/websocket", b.handleWebsocket)
 	e.POST("/api/message", b.handlePostMessage)
+	e.PUT("/api/message/:id", b.handleEditMessage)
+	e.DELETE("/api/message/:id", b.handleDeleteMessage)
 	go func() {
 		if b.GetString("BindAddress") == "" {
 			b.Log.Fatalf("No BindAddress configured.")
@@ -114,6 +138,14 @@ func (b *API) Send(msg config.Message) (string, error) {
 	if msg.Event == config.EventMsgDelete {
 		return "", nil
 	}
+	b.loadSpooledFiles(&msg)
+	if b.envelope != nil {
+		sealed, err := b.envelope.seal(msg)
+		if err != nil {
+			return "", err
+		}
+		msg = sealed
+	}
 	b.Log.Debugf("enqueueing message from %s on ring buffer", msg.Username)
 	b.Messages.Enqueue(msg)
 
@@ -121,25 +153,192 @@ func (b *API) Send(msg config.Message) (string, error) {
 	if err != nil {
 		b.Log.Errorf("failed to encode message  '%s'", msg)
 	}
-	_ = b.mrouter.Broadcast(data)
+	_ = b.mrouter.BroadcastFilter(data, activeSession)
 	return "", nil
 }
 
+// newWebsocket returns the handler of /api/websocket, which keeps
+// WebsocketBuffer messages for each connection and pings them every
+// WebsocketPingInterval seconds.
+func (b *API) newWebsocket() *melody.Melody {
+	m := melody.New()
+	m.Config.MaxMessageSize = websocketMaxMessageSize
+	if buffer := b.GetInt("WebsocketBuffer"); buffer > 0 {
+		m.Config.MessageBufferSize = buffer
+	}
+	if interval := b.GetInt("WebsocketPingInterval"); interval > 0 {
+		m.Config.PingPeriod = time.Duration(interval) * time.Second
+		m.Config.PongWait = m.Config.PingPeriod * 10 / 9
+	}
+	m.HandleError(func(s *melody.Session, err error) {
+		if errors.Is(err, melody.ErrMessageBufferFull) {
+			b.closeSlowSession(s)
+		}
+	})
+	m.HandleMessage(func(s *melody.Session, msg []byte) {
+		message := config.Message{}
+		err := json.Unmarshal(msg, &message)
+		if err != nil {
+			b.Log.Errorf("failed to decode message from byte[] '%s'", string(msg))
+			return
+		}
+		b.handleWebsocketMessage(message, s)
+	})
+	m.HandleConnect(func(session *melody.Session) {
+		greet := b.getGreeting()
+		data, err := json.Marshal(greet)
+		if err != nil {
+			b.Log.Errorf("failed to encode message '%v'", greet)
+			return
+		}
+		err = session.Write(data)
+		if err != nil {
+			b.Log.Errorf("failed to write message '%s'", string(data))
+			return
+		}
+		// TODO: send message history buffer from `b.Messages` here
+	})
+	return m
+}
+
+// activeSession returns false for the websocket sessions being closed by
+// closeSlowSession.
+func activeSession(s *melody.Session) bool {
+	_, slow := s.Get(slowSessionKey)
+	return !slow
+}
+
+// closeSlowSession closes the connection of a websocket client which doesn't
+// read its messages as fast as they come: its buffer is full and the next
+// messages would be lost, so it is told to reconnect and catch up with
+// /api/messages instead. The bridge never waits for the clients.
+func (b *API) closeSlowSession(s *melody.Session) {
+	if !activeSession(s) {
+		return
+	}
+	s.Set(slowSessionKey, true)
+	b.Log.Warnf("Closing the websocket of %s, its buffer of %d messages is full", s.RemoteAddr(), b.mrouter.Config.MessageBufferSize)
+	// the close message can't wait behind the full buffer, and the control
+	// messages can be written alongside melody, without holding its
+	// broadcasts
+	conn := s.WebsocketConnection()
+	deadline := time.Now().Add(b.mrouter.Config.WriteWait)
+	go func() {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), deadline)
+		_ = conn.Close()
+	}()
+}
+
+// loadSpooledFiles replaces the spooled files of msg by their data, as the
+// messages outlive the spool files in the ring buffer. The Extra of msg is
+// shared with the other bridges, so it is copied.
+func (b *API) loadSpooledFiles(msg *config.Message) {
+	files := make([]interface{}, len(msg.Extra["file"]))
+	spooled := false
+	for i, f := range msg.Extra["file"] {
+		files[i] = f
+		fi, ok := f.(config.FileInfo)
+		if !ok || fi.Path == "" {
+			continue
+		}
+		spooled = true
+		data, err := fi.Bytes()
+		if err != nil {
+			b.Log.Errorf("reading %s failed: %s", fi.Name, err)
+		}
+		fi.Data, fi.Path = &data, ""
+		files[i] = fi
+	}
+	if !spooled {
+		return
+	}
+
+	extra := make(map[string][]interface{}, len(msg.Extra))
+	for k, v := range msg.Extra {
+		extra[k] = v
+	}
+	extra["file"] = files
+	msg.Extra = extra
+}
+
 func (b *API) handleHealthcheck(c echo.Context) error {
 	return c.String(http.StatusOK, "OK")
 }
 
+// SetHeartbeats implements bridge.HeartbeatReporter.
+func (b *API) SetHeartbeats(heartbeats func() []bridge.Heartbeat) {
+	b.Lock()
+	b.heartbeats = heartbeats
+	b.Unlock()
+}
+
+func (b *API) handleHeartbeats(c echo.Context) error {
+	b.RLock()
+	heartbeats := b.heartbeats
+	b.RUnlock()
+	if heartbeats == nil {
+		return c.JSONPretty(http.StatusOK, []bridge.Heartbeat{}, " ")
+	}
+	return c.JSONPretty(http.StatusOK, heartbeats(), " ")
+}
+
+// handlePostMessage relays a message posted as JSON, or as a multipart form
+// with its files, to the gateway. The message gets a new ID, returned in the
+// response, which edits it with handleEditMessage and deletes it with
+// handleDeleteMessage.
 func (b *API) handlePostMessage(c echo.Context) error {
+	return b.postMessage(c, "")
+}
+
+// handleEditMessage edits a message posted to the API on the other bridges,
+// on the gateway it was posted to.
+func (b *API) handleEditMessage(c echo.Context) error {
+	return b.postMessage(c, c.Param("id"))
+}
+
+// postMessage relays the message of the request, a new one when id is empty
+// or the edit of the message posted with id.
+func (b *API) postMessage(c echo.Context, id string) error {
 	message := config.Message{}
-	if err := c.Bind(&message); err != nil {
+	multipart := strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm)
+	if multipart {
+		message = formMessage(c)
+	} else if err := c.Bind(&message); err != nil {
 		return err
 	}
+	if b.envelope != nil {
+		// the fields and files of the forms aren't covered by the encryption
+		if multipart {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "multipart messages can't be encrypted, post the sealed message as JSON")
+		}
+		inner, err := b.envelope.open(message)
+		if err != nil {
+			b.Log.Warnf("Refusing message: %s", err)
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		message = inner
+	}
 	// these values are fixed
 	message.Channel = "api"
 	message.Protocol = "api"
 	message.Account = b.Account
-	message.ID = ""
 	message.Timestamp = time.Now()
+	message.ID = xid.New().String()
+	if id != "" {
+		gateway, ok := b.posted.Get(id)
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "unknown message id "+id)
+		}
+		message.ID = id
+		message.Gateway = gateway.(string)
+	}
+
+	if multipart {
+		if err := b.addFormFiles(c, &message); err != nil {
+			return err
+		}
+		return b.relay(c, message)
+	}
 
 	var (
 		fm map[string]interface{}
@@ -168,8 +367,102 @@ func (b *API) handlePostMessage(c echo.Context) error {
 			return err
 		}
 		fi.Data = &data
+		// only the files spooled by matterbridge are read from the disk
+		fi.Path = ""
+		helper.SpoolFile(b.Log, &fi, b.General)
 		message.Extra["file"][i] = fi
 	}
+	return b.relay(c, message)
+}
+
+// formMessage returns the message of the fields of a multipart form.
+func formMessage(c echo.Context) config.Message {
+	return config.Message{
+		Text:     c.FormValue("text"),
+		Username: c.FormValue("username"),
+		UserID:   c.FormValue("userid"),
+		Avatar:   c.FormValue("avatar"),
+		Event:    c.FormValue("event"),
+		Gateway:  c.FormValue("gateway"),
+		ParentID: c.FormValue("parent_id"),
+	}
+}
+
+// addFormFiles adds the files of the "file" fields of a multipart form to
+// message, with the files too large or blacklisted reported like the other
+// bridges do.
+func (b *API) addFormFiles(c echo.Context, message *config.Message) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if message.Extra == nil {
+		message.Extra = make(map[string][]interface{})
+	}
+	for _, header := range form.File["file"] {
+		if err := helper.HandleDownloadSize(b.Log, message, header.Filename, header.Size, b.General); err != nil {
+			b.Log.Warn(err)
+			continue
+		}
+		f, err := header.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		helper.HandleDownloadData(b.Log, message, header.Filename, "", "", &data, b.General)
+	}
+	return nil
+}
+
+// handleDeleteMessage deletes a message posted to the API from the other
+// bridges. With encryption, the request must post the delete event of the
+// message sealed.
+func (b *API) handleDeleteMessage(c echo.Context) error {
+	id := c.Param("id")
+	if b.envelope != nil {
+		sealed := config.Message{}
+		if err := c.Bind(&sealed); err != nil {
+			return err
+		}
+		inner, err := b.envelope.open(sealed)
+		if err != nil {
+			b.Log.Warnf("Refusing delete: %s", err)
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		// the sealed delete can't be replayed for another message
+		if inner.Event != config.EventMsgDelete || inner.ID != id {
+			return echo.NewHTTPError(http.StatusForbidden, "the sealed message isn't the delete of message "+id)
+		}
+	}
+	gateway, ok := b.posted.Get(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown message id "+id)
+	}
+	message := config.Message{
+		Text:      config.EventMsgDelete,
+		Channel:   "api",
+		Account:   b.Account,
+		Event:     config.EventMsgDelete,
+		Protocol:  "api",
+		Gateway:   gateway.(string),
+		Timestamp: time.Now(),
+		ID:        id,
+	}
+	b.posted.Remove(id)
+	b.Log.Debugf("Sending delete of %s on %s to gateway", id, "api")
+	b.Remote <- message
+	return c.JSON(http.StatusOK, message)
+}
+
+// relay sends message to the gateway and answers with it, with its ID.
+func (b *API) relay(c echo.Context, message config.Message) error {
+	if message.Event != config.EventMsgDelete {
+		b.posted.Add(message.ID, message.Gateway)
+	}
 	b.Log.Debugf("Sending message from %s on %s to gateway", message.Username, "api")
 	b.Remote <- message
 	return c.JSON(http.StatusOK, message)
@@ -217,18 +510,37 @@ func (b *API) handleStream(c echo.Context) error {
 }
 
 func (b *API) handleWebsocketMessage(message config.Message, s *melody.Session) {
+	if b.envelope != nil {
+		inner, err := b.envelope.open(message)
+		if err != nil {
+			b.Log.Warnf("Refusing websocket message: %s", err)
+			return
+		}
+		message = inner
+	}
 	message.Channel = "api"
 	message.Protocol = "api"
 	message.Account = b.Account
 	message.ID = ""
 	message.Timestamp = time.Now()
 
-	data, err := json.Marshal(message)
+	loopback := message
+	if b.envelope != nil {
+		sealed, err := b.envelope.seal(message)
+		if err != nil {
+			b.Log.Errorf("failed to encrypt message for loopback: %s", err)
+			return
+		}
+		loopback = sealed
+	}
+	data, err := json.Marshal(loopback)
 	if err != nil {
 		b.Log.Errorf("failed to encode message for loopback '%v'", message)
 		return
 	}
-	_ = b.mrouter.BroadcastOthers(data, s)
+	_ = b.mrouter.BroadcastFilter(data, func(other *melody.Session) bool {
+		return other != s && activeSession(other)
+	})
 
 	b.Log.Debugf("Sending websocket message from %s on %s to gateway", message.Username, "api")
 	b.Remote <- message
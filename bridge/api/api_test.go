@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/labstack/echo/v4"
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI() *API {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[api.test]\n"))
+	b := &API{Config: &bridge.Config{
+		Bridge: &bridge.Bridge{
+			Account: "api.test",
+			Config:  cfg,
+			Log:     logrus.NewEntry(logger),
+			General: &config.Protocol{MediaDownloadSize: 10},
+		},
+		Remote: make(chan config.Message, 1),
+	}}
+	b.posted, _ = lru.New(postedSize)
+	return b
+}
+
+// serve calls handler with the request, returning the status and the message
+// answered.
+func serve(t *testing.T, handler echo.HandlerFunc, req *http.Request, id string) (int, config.Message) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	var message config.Message
+	if err := handler(c); err != nil {
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		return httpErr.Code, message
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &message))
+	return rec.Code, message
+}
+
+func jsonRequest(method string, body string) *http.Request {
+	req := httptest.NewRequest(method, "/api/message", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+func TestPostMessageEditDelete(t *testing.T) {
+	b := newTestAPI()
+
+	status, posted := serve(t, b.handlePostMessage, jsonRequest(http.MethodPost, `{"text":"hello","username":"alice","gateway":"main"}`), "")
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, posted.ID)
+	msg := <-b.Remote
+	assert.Equal(t, posted.ID, msg.ID)
+	assert.Equal(t, "hello", msg.Text)
+
+	// the ID of a posted message is replaced, like before IDs were returned
+	status, other := serve(t, b.handlePostMessage, jsonRequest(http.MethodPost, `{"text":"hi","gateway":"main","id":"unknown"}`), "")
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, "unknown", other.ID)
+	assert.NotEqual(t, posted.ID, other.ID)
+	<-b.Remote
+
+	// the ID returned edits the message, on the gateway it was posted to
+	status, edited := serve(t, b.handleEditMessage, jsonRequest(http.MethodPut, `{"text":"hello!","gateway":"other"}`), posted.ID)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, posted.ID, edited.ID)
+	msg = <-b.Remote
+	assert.Equal(t, "hello!", msg.Text)
+	assert.Equal(t, "main", msg.Gateway)
+
+	status, _ = serve(t, b.handleEditMessage, jsonRequest(http.MethodPut, `{"text":"hi","gateway":"main"}`), "unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, deleted := serve(t, b.handleDeleteMessage, httptest.NewRequest(http.MethodDelete, "/api/message/"+posted.ID, nil), posted.ID)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, config.EventMsgDelete, deleted.Event)
+	msg = <-b.Remote
+	assert.Equal(t, posted.ID, msg.ID)
+	assert.Equal(t, "main", msg.Gateway)
+
+	status, _ = serve(t, b.handleDeleteMessage, httptest.NewRequest(http.MethodDelete, "/api/message/"+posted.ID, nil), posted.ID)
+	assert.Equal(t, http.StatusNotFound, status, "a message is deleted once")
+}
+
+func TestDeleteMessageSealed(t *testing.T) {
+	b := newTestAPI()
+	key := randomKey(t)
+	var err error
+	b.envelope, err = newEnvelope(key, "", "")
+	require.NoError(t, err)
+	peer, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+	b.posted.Add("1", "main")
+	b.posted.Add("2", "main")
+	sealedDelete := func(id string) string {
+		sealed, err := peer.seal(config.Message{Event: config.EventMsgDelete, ID: id})
+		require.NoError(t, err)
+		data, err := json.Marshal(sealed)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// the deletes are refused unencrypted, or sealed for another message
+	status, _ := serve(t, b.handleDeleteMessage, httptest.NewRequest(http.MethodDelete, "/api/message/1", nil), "1")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = serve(t, b.handleDeleteMessage, jsonRequest(http.MethodDelete, sealedDelete("2")), "1")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Empty(t, b.Remote)
+
+	status, deleted := serve(t, b.handleDeleteMessage, jsonRequest(http.MethodDelete, sealedDelete("1")), "1")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "1", deleted.ID)
+	assert.Equal(t, config.EventMsgDelete, (<-b.Remote).Event)
+}
+
+func TestPostMessageMultipart(t *testing.T) {
+	b := newTestAPI()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("text", "a file"))
+	require.NoError(t, form.WriteField("gateway", "main"))
+	part, err := form.CreateFormFile("file", "hello.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("hello"))
+	require.NoError(t, err)
+	part, err = form.CreateFormFile("file", "large.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("more than ten bytes"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/message", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	status, posted := serve(t, b.handlePostMessage, req, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, posted.ID)
+
+	msg := <-b.Remote
+	assert.Equal(t, "a file", msg.Text)
+	require.Len(t, msg.Extra["file"], 1)
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, "hello.txt", fi.Name)
+	assert.Equal(t, "hello", string(*fi.Data))
+	require.Len(t, msg.Extra[config.EventFileFailureSize], 1, "the files larger than MediaDownloadSize are reported")
+
+	// the forms aren't encrypted, they are refused when the messages must be
+	b.envelope, err = newEnvelope(base64.StdEncoding.EncodeToString(make([]byte, keySize)), "", "")
+	require.NoError(t, err)
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("text", "a file"))
+	require.NoError(t, form.Close())
+	req = httptest.NewRequest(http.MethodPost, "/api/message", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	status, _ = serve(t, b.handlePostMessage, req, "")
+	assert.Equal(t, http.StatusUnsupportedMediaType, status)
+}
+
+func TestSendSpooledFile(t *testing.T) {
+	b := newTestAPI()
+	b.mrouter = b.newWebsocket()
+	key := randomKey(t)
+	var err error
+	b.envelope, err = newEnvelope(key, "", "")
+	require.NoError(t, err)
+	receiver, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "spooled")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o600))
+	_, err = b.Send(config.Message{Text: "a file", Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "hello.txt", Path: path}}}})
+	require.NoError(t, err)
+
+	// the spooled files are read before the message is encrypted
+	opened, err := receiver.open(b.Messages.Dequeue().(config.Message))
+	require.NoError(t, err)
+	file := opened.Extra["file"][0].(map[string]interface{})
+	assert.Equal(t, "hello.txt", file["Name"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello")), file["Data"])
+}
+
+func TestWebsocket(t *testing.T) {
+	b := newTestAPI()
+	b.SetInt("WebsocketBuffer", 1)
+	b.mrouter = b.newWebsocket()
+	b.mrouter.Config.WriteWait = 100 * time.Millisecond
+	e := echo.New()
+	e.GET("/api/websocket", b.handleWebsocket)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/websocket"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var greeting config.Message
+	require.NoError(t, conn.ReadJSON(&greeting))
+	assert.Equal(t, config.EventAPIConnected, greeting.Event)
+
+	// the messages are received and sent on the same socket
+	require.NoError(t, conn.WriteJSON(config.Message{Text: "hello", Username: "alice", Gateway: "main"}))
+	msg := <-b.Remote
+	assert.Equal(t, "hello", msg.Text)
+	assert.Equal(t, "api.test", msg.Account)
+	_, err = b.Send(config.Message{Text: "hi", Username: "bob"})
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "hi", msg.Text)
+
+	// a client which doesn't read is disconnected instead of holding the
+	// bridge
+	text := strings.Repeat("x", websocketMaxMessageSize)
+	for i := 0; i < 1000 && b.mrouter.Len() > 0; i++ {
+		_, err = b.Send(config.Message{Text: text})
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return b.mrouter.Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	keySize   = 32
+	nonceSize = 24
+	// maxMessageAge is how old, or how far in the future, the messages can be
+	// sealed. Their nonces are remembered as long to refuse replayed messages,
+	// in the StorageBackend to cover restarts, see keepNonces.
+	maxMessageAge = 5 * time.Minute
+	// nonceNamespace is the prefix of the namespaces of the nonces in the
+	// Store, followed by the account.
+	nonceNamespace = "api-nonces/"
+)
+
+var (
+	errNotEncrypted = errors.New("message is not encrypted")
+	errDecrypt      = errors.New("message could not be decrypted, check the keys of both instances")
+	errReplayed     = errors.New("message was already received")
+	errStale        = errors.New("message is too old or in the future, check the clocks of both instances")
+	errBeforeStart  = errors.New("message was sealed before this instance started, set StorageBackend to accept it")
+	errReflected    = errors.New("message was sent by this instance")
+)
+
+// sealedMessage is the encrypted content of an api_encrypted message, which
+// authenticates its sender and when it was sealed along with the message.
+type sealedMessage struct {
+	Sender  string         `json:"sender"`
+	Sealed  time.Time      `json:"sealed"`
+	Message config.Message `json:"message"`
+}
+
+// seenNonce is a nonce remembered until it expires.
+type seenNonce struct {
+	nonce   [nonceSize]byte
+	expires time.Time
+}
+
+// envelope encrypts and authenticates the messages exchanged with a paired
+// matterbridge instance, with NaCl secretbox when both share SharedKey, or
+// NaCl box with the PrivateKey of each instance and the PeerPublicKey of the
+// other.
+type envelope struct {
+	key        *[keySize]byte
+	sharedMode bool
+	// publicKey is the public key of PrivateKey, to configure the peer with
+	publicKey string
+	// sender identifies the messages sealed by this instance: its public key,
+	// or a random ID with SharedKey. peer is the public key of the other
+	// instance, empty with SharedKey.
+	sender string
+	peer   string
+
+	// seen holds the nonces of the messages sealed and received for
+	// maxMessageAge, in seenOrder
+	mu        sync.Mutex
+	seen      map[[nonceSize]byte]struct{}
+	seenOrder []seenNonce
+	// store keeps the nonces of seen in namespace across restarts. Without
+	// it, the messages sealed before started are refused, as they may have
+	// been received before the restart.
+	store     storage.Store
+	namespace string
+	started   time.Time
+}
+
+// newEnvelope returns the envelope configured for the bridge, or nil when no
+// key is configured.
+func newEnvelope(sharedKey, privateKey, peerPublicKey string) (*envelope, error) {
+	switch {
+	case sharedKey != "" && (privateKey != "" || peerPublicKey != ""):
+		return nil, errors.New("SharedKey can't be used with PrivateKey and PeerPublicKey")
+	case sharedKey != "":
+		key, err := decodeKey("SharedKey", sharedKey)
+		if err != nil {
+			return nil, err
+		}
+		// both instances have the same key, they tell their messages apart
+		// with a random ID
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		return &envelope{
+			key:        key,
+			sharedMode: true,
+			sender:     base64.StdEncoding.EncodeToString(id),
+			seen:       make(map[[nonceSize]byte]struct{}),
+			started:    time.Now(),
+		}, nil
+	case privateKey == "" && peerPublicKey == "":
+		return nil, nil
+	case privateKey == "" || peerPublicKey == "":
+		return nil, errors.New("PrivateKey and PeerPublicKey must both be set")
+	}
+
+	private, err := decodeKey("PrivateKey", privateKey)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := decodeKey("PeerPublicKey", peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PrivateKey: %w", err)
+	}
+
+	e := &envelope{
+		key:       new([keySize]byte),
+		publicKey: base64.StdEncoding.EncodeToString(public),
+		peer:      base64.StdEncoding.EncodeToString(peer[:]),
+		seen:      make(map[[nonceSize]byte]struct{}),
+		started:   time.Now(),
+	}
+	e.sender = e.publicKey
+	box.Precompute(e.key, peer, private)
+	return e, nil
+}
+
+func decodeKey(name, value string) (*[keySize]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) != keySize {
+		return nil, fmt.Errorf("%s must be %d bytes encoded in base64, eg. the output of `openssl rand -base64 %d`", name, keySize, keySize)
+	}
+	key := new([keySize]byte)
+	copy(key[:], data)
+	return key, nil
+}
+
+// seal returns msg encrypted in an api_encrypted message. Its nonce is
+// remembered so that the message isn't accepted if sent back to this instance.
+func (e *envelope) seal(msg config.Message) (config.Message, error) {
+	return e.sealAt(msg, time.Now())
+}
+
+func (e *envelope) sealAt(msg config.Message, now time.Time) (config.Message, error) {
+	data, err := json.Marshal(sealedMessage{Sender: e.sender, Sealed: now, Message: msg})
+	if err != nil {
+		return config.Message{}, err
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return config.Message{}, err
+	}
+	e.markSeen(nonce, now)
+	var sealed []byte
+	if e.sharedMode {
+		sealed = secretbox.Seal(nonce[:], data, &nonce, e.key)
+	} else {
+		sealed = box.SealAfterPrecomputation(nonce[:], data, &nonce, e.key)
+	}
+
+	return config.Message{
+		Event:     config.EventAPIEncrypted,
+		Text:      base64.StdEncoding.EncodeToString(sealed),
+		Timestamp: msg.Timestamp,
+	}, nil
+}
+
+// open returns the message encrypted in msg, which must be an api_encrypted
+// message sealed by the peer less than maxMessageAge ago, and which wasn't
+// received before.
+func (e *envelope) open(msg config.Message) (config.Message, error) {
+	if msg.Event != config.EventAPIEncrypted {
+		return config.Message{}, errNotEncrypted
+	}
+	sealed, err := base64.StdEncoding.DecodeString(msg.Text)
+	if err != nil || len(sealed) < nonceSize {
+		return config.Message{}, errDecrypt
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	var (
+		data []byte
+		ok   bool
+	)
+	if e.sharedMode {
+		data, ok = secretbox.Open(nil, sealed[nonceSize:], &nonce, e.key)
+	} else {
+		data, ok = box.OpenAfterPrecomputation(nil, sealed[nonceSize:], &nonce, e.key)
+	}
+	if !ok {
+		return config.Message{}, errDecrypt
+	}
+
+	var inner sealedMessage
+	if err := json.Unmarshal(data, &inner); err != nil {
+		return config.Message{}, fmt.Errorf("invalid encrypted message: %w", err)
+	}
+	// the key is the same both ways, so the messages of this instance can be
+	// sent back to it
+	switch {
+	case inner.Sender == e.sender:
+		return config.Message{}, errReflected
+	case e.peer != "" && inner.Sender != e.peer:
+		return config.Message{}, errDecrypt
+	}
+	if age := time.Since(inner.Sealed); age > maxMessageAge || age < -maxMessageAge {
+		return config.Message{}, errStale
+	}
+	if e.store == nil && inner.Sealed.Before(e.started) {
+		return config.Message{}, errBeforeStart
+	}
+	if !e.markSeen(nonce, inner.Sealed) {
+		return config.Message{}, errReplayed
+	}
+	return inner.Message, nil
+}
+
+// markSeen records the nonce of a message sealed at sealed, returning false if
+// it was already seen. The nonces are forgotten once their messages are too
+// old to be accepted.
+func (e *envelope) markSeen(nonce [nonceSize]byte, sealed time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for len(e.seenOrder) > 0 && e.seenOrder[0].expires.Before(now) {
+		delete(e.seen, e.seenOrder[0].nonce)
+		if e.store != nil {
+			// a failure only leaves an expired nonce, skipped on load
+			_ = e.store.Delete(e.namespace, nonceKey(e.seenOrder[0].nonce))
+		}
+		e.seenOrder = e.seenOrder[1:]
+	}
+	if _, ok := e.seen[nonce]; ok {
+		return false
+	}
+	expires := sealed.Add(maxMessageAge)
+	if e.store != nil {
+		// the message is refused rather than replayable after a restart
+		if err := e.store.Put(e.namespace, nonceKey(nonce), []byte(strconv.FormatInt(expires.UnixNano(), 10))); err != nil {
+			return false
+		}
+	}
+	e.seen[nonce] = struct{}{}
+	e.insertSeen(seenNonce{nonce: nonce, expires: expires})
+	return true
+}
+
+// insertSeen adds n to seenOrder, which is sorted by expiry: the messages
+// aren't received in the order they were sealed.
+func (e *envelope) insertSeen(n seenNonce) {
+	i := len(e.seenOrder)
+	for i > 0 && e.seenOrder[i-1].expires.After(n.expires) {
+		i--
+	}
+	e.seenOrder = append(e.seenOrder, seenNonce{})
+	copy(e.seenOrder[i+1:], e.seenOrder[i:])
+	e.seenOrder[i] = n
+}
+
+// keepNonces keeps the nonces of the messages sealed and received in
+// namespace of store, and restores those which haven't expired yet, so that
+// the messages received before a restart can't be replayed after it.
+func (e *envelope) keepNonces(store storage.Store, namespace string) error {
+	keys, err := store.Keys(namespace)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		data, err := store.Get(namespace, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		nonce, ok := parseNonceKey(key)
+		expires, perr := strconv.ParseInt(string(data), 10, 64)
+		if err != nil || !ok || perr != nil || time.Unix(0, expires).Before(now) {
+			if err := store.Delete(namespace, key); err != nil {
+				return err
+			}
+			continue
+		}
+		e.seen[nonce] = struct{}{}
+		e.insertSeen(seenNonce{nonce: nonce, expires: time.Unix(0, expires)})
+	}
+	e.store = store
+	e.namespace = namespace
+	return nil
+}
+
+func nonceKey(nonce [nonceSize]byte) string {
+	return base64.RawURLEncoding.EncodeToString(nonce[:])
+}
+
+func parseNonceKey(key string) ([nonceSize]byte, bool) {
+	var nonce [nonceSize]byte
+	data, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(data) != nonceSize {
+		return nonce, false
+	}
+	copy(nonce[:], data)
+	return nonce, true
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomKey(t *testing.T) string {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestNewEnvelope(t *testing.T) {
+	env, err := newEnvelope("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, env)
+
+	_, err = newEnvelope("not a key", "", "")
+	assert.Error(t, err)
+	_, err = newEnvelope(randomKey(t), randomKey(t), randomKey(t))
+	assert.Error(t, err)
+	_, err = newEnvelope("", randomKey(t), "")
+	assert.Error(t, err)
+}
+
+func TestEnvelopeSharedKey(t *testing.T) {
+	key := randomKey(t)
+	sender, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+	receiver, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+
+	data := []byte("attachment")
+	msg := config.Message{
+		Text: "secret", Username: "user", Gateway: "gw",
+		Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "a.txt", Data: &data}}},
+	}
+	sealed, err := sender.seal(msg)
+	require.NoError(t, err)
+	assert.Equal(t, config.EventAPIEncrypted, sealed.Event)
+	assert.NotContains(t, sealed.Text, "secret")
+
+	opened, err := receiver.open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", opened.Text)
+	assert.Equal(t, "user", opened.Username)
+	assert.Equal(t, "gw", opened.Gateway)
+	// files are decoded like those of unencrypted messages
+	file := opened.Extra["file"][0].(map[string]interface{})
+	assert.Equal(t, base64.StdEncoding.EncodeToString(data), file["Data"])
+
+	_, err = receiver.open(sealed)
+	assert.ErrorIs(t, err, errReplayed)
+	// the messages of an instance aren't accepted when sent back to it
+	sealed, err = sender.seal(msg)
+	require.NoError(t, err)
+	_, err = sender.open(sealed)
+	assert.ErrorIs(t, err, errReflected)
+	// nor when too old or in the future
+	for _, at := range []time.Time{time.Now().Add(-2 * maxMessageAge), time.Now().Add(2 * maxMessageAge)} {
+		sealed, err = sender.sealAt(msg, at)
+		require.NoError(t, err)
+		_, err = receiver.open(sealed)
+		assert.ErrorIs(t, err, errStale)
+	}
+
+	_, err = receiver.open(config.Message{Text: "plain"})
+	assert.ErrorIs(t, err, errNotEncrypted)
+
+	other, err := newEnvelope(randomKey(t), "", "")
+	require.NoError(t, err)
+	sealed, err = sender.seal(msg)
+	require.NoError(t, err)
+	_, err = other.open(sealed)
+	assert.ErrorIs(t, err, errDecrypt)
+}
+
+func TestEnvelopePublicKeys(t *testing.T) {
+	privateA, privateB := randomKey(t), randomKey(t)
+
+	// the public keys are logged by each instance
+	setup, err := newEnvelope("", privateA, randomKey(t))
+	require.NoError(t, err)
+	publicA := setup.publicKey
+	setup, err = newEnvelope("", privateB, randomKey(t))
+	require.NoError(t, err)
+	publicB := setup.publicKey
+
+	a, err := newEnvelope("", privateA, publicB)
+	require.NoError(t, err)
+	b, err := newEnvelope("", privateB, publicA)
+	require.NoError(t, err)
+
+	sealed, err := a.seal(config.Message{Text: "from a"})
+	require.NoError(t, err)
+	opened, err := b.open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "from a", opened.Text)
+
+	sealed, err = b.seal(config.Message{Text: "from b"})
+	require.NoError(t, err)
+	opened, err = a.open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "from b", opened.Text)
+	_, err = b.open(sealed)
+	assert.ErrorIs(t, err, errReflected)
+
+	// an instance with another private key can't read or forge messages
+	mallory, err := newEnvelope("", randomKey(t), publicB)
+	require.NoError(t, err)
+	sealed, err = mallory.seal(config.Message{Text: "forged"})
+	require.NoError(t, err)
+	_, err = b.open(sealed)
+	assert.ErrorIs(t, err, errDecrypt)
+}
+
+func TestEnvelopeRestart(t *testing.T) {
+	key := randomKey(t)
+	sender, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+	store := storage.NewMemory()
+	// a nonce which expired before the restart is forgotten
+	require.NoError(t, store.Put(nonceNamespace+"api.test", nonceKey([nonceSize]byte{1}), []byte("0")))
+	receiver, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+	require.NoError(t, receiver.keepNonces(store, nonceNamespace+"api.test"))
+
+	sealed, err := sender.seal(config.Message{Text: "hello"})
+	require.NoError(t, err)
+	_, err = receiver.open(sealed)
+	require.NoError(t, err)
+
+	// the nonces are restored after a restart
+	restarted, err := newEnvelope(key, "", "")
+	require.NoError(t, err)
+	require.NoError(t, restarted.keepNonces(store, nonceNamespace+"api.test"))
+	_, err = restarted.open(sealed)
+	assert.ErrorIs(t, err, errReplayed)
+	keys, err := store.Keys(nonceNamespace + "api.test")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	// without the store, the messages sealed before the start are refused
+	restarted, err = newEnvelope(key, "", "")
+	require.NoError(t, err)
+	_, err = restarted.open(sealed)
+	assert.ErrorIs(t, err, errBeforeStart)
+	sealed, err = sender.seal(config.Message{Text: "hello again"})
+	require.NoError(t, err)
+	_, err = restarted.open(sealed)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+)
+
+// avatarNamespace is the prefix of the namespaces of the avatar caches in the
+// Store, followed by the account.
+const avatarNamespace = "avatars/"
+
+// CachedAvatar returns the SHA of the avatar of userID on the media server,
+// when it was downloaded already. The avatars are cached in memory, and in the
+// Store when it is set so that they aren't downloaded again after a restart,
+// as long as the media server keeps them.
+func (b *Bridge) CachedAvatar(userID string) (string, bool) {
+	b.avatarsMu.RLock()
+	sha, ok := b.avatars[userID]
+	b.avatarsMu.RUnlock()
+	if ok || b.Store == nil {
+		return sha, ok
+	}
+
+	data, err := b.Store.Get(avatarNamespace+b.Account, userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			b.Log.Errorf("Reading the cached avatar of %s failed: %s", userID, err)
+		}
+		return "", false
+	}
+	sha = string(data)
+	// the files of the media server can be removed since, see
+	// MediaRetentionDays
+	if dir := b.General.MediaDownloadPath; dir != "" {
+		if _, err := os.Stat(filepath.Join(dir, sha)); err != nil {
+			return "", false
+		}
+	}
+	b.setAvatar(userID, sha)
+	return sha, true
+}
+
+// CacheAvatar records sha as the SHA of the avatar of userID on the media
+// server.
+func (b *Bridge) CacheAvatar(userID string, sha string) {
+	b.Log.Debugf("Added %s to %s in the avatar cache", sha, userID)
+	b.setAvatar(userID, sha)
+	if b.Store == nil {
+		return
+	}
+	if err := b.Store.Put(avatarNamespace+b.Account, userID, []byte(sha)); err != nil {
+		b.Log.Errorf("Caching the avatar of %s failed: %s", userID, err)
+	}
+}
+
+func (b *Bridge) setAvatar(userID string, sha string) {
+	b.avatarsMu.Lock()
+	defer b.avatarsMu.Unlock()
+	if b.avatars == nil {
+		b.avatars = make(map[string]string)
+	}
+	b.avatars[userID] = sha
+}
+
+// AvatarURL returns the URL of the avatar of userID on the media server, empty
+// when it isn't cached.
+func (b *Bridge) AvatarURL(userID string) string {
+	sha, ok := b.CachedAvatar(userID)
+	if !ok {
+		return ""
+	}
+	return helper.MediaServerURL(b.General.MediaServerDownload, sha, helper.SanitizeFileName(userID+".png", ""))
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvatarCache(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewMemory()
+	newBridge := func() *Bridge {
+		br := New(&config.Bridge{Account: "telegram.test"})
+		br.Log = logrus.NewEntry(logrus.New())
+		br.General = &config.Protocol{MediaDownloadPath: dir, MediaServerDownload: "https://media.example"}
+		br.Store = store
+		return br
+	}
+
+	br := newBridge()
+	_, ok := br.CachedAvatar("42")
+	assert.False(t, ok)
+	assert.Empty(t, br.AvatarURL("42"))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "abcd1234"), 0o700))
+	br.CacheAvatar("42", "abcd1234")
+	assert.Equal(t, "https://media.example/abcd1234/42.png", br.AvatarURL("42"))
+
+	// the avatars are kept across restarts
+	sha, ok := newBridge().CachedAvatar("42")
+	assert.True(t, ok)
+	assert.Equal(t, "abcd1234", sha)
+
+	// unless the media server removed them since
+	require.NoError(t, os.Remove(filepath.Join(dir, "abcd1234")))
+	_, ok = newBridge().CachedAvatar("42")
+	assert.False(t, ok)
+}
@@ -2,10 +2,12 @@ package bridge
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,7 +15,10 @@ import (
 	"time"
 
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
 )
 
 type Bridger interface {
@@ -39,7 +44,29 @@ type Bridge struct {
 	Log            *logrus.Entry
 	Config         config.Config
 	General        *config.Protocol
-	HttpClient     *http.Client // Unique HTTP settings per bridge
+	HttpClient     *http.Client  // Unique HTTP settings per bridge
+	Budget         *APIBudget    // API calls of the account, see APIRateBudget
+	Store          storage.Store // state kept across restarts, nil without StorageBackend
+
+	// joinMu serializes the channel joins, so that JoinDelay separates all
+	// the joins of the bridge, and guards Joined while they run.
+	joinMu   sync.Mutex
+	lastJoin time.Time
+	// joins are the joins running in the background with LazyJoin
+	joins sync.WaitGroup
+
+	// sending is held by the Send call of the gateway, so that a Send still
+	// running after its SendTimeout isn't overtaken by the next ones.
+	sending chan struct{}
+
+	// credentials replace the settings of the account, see UseCredentials
+	credentialsMu sync.RWMutex
+	credentials   map[string]any
+
+	// avatars maps the user IDs to the SHA of their avatar on the media
+	// server, see CachedAvatar
+	avatarsMu sync.RWMutex
+	avatars   map[string]string
 }
 
 type Config struct {
@@ -48,6 +75,116 @@ type Config struct {
 	Remote chan config.Message
 }
 
+// AckMessage reports the real remote ID of a message for which Send returned
+// a provisional ID, so edits, deletes and replies keep resolving to it.
+//
+// It must not be called from Send, as the gateway is waiting for it to return.
+func (b *Config) AckMessage(channel string, provisionalID string, remoteID string) {
+	b.sendMsgAck(channel, provisionalID, config.MsgAck{RemoteID: remoteID})
+}
+
+// FailMessage reports that a message for which Send returned a provisional ID
+// was never delivered. Like AckMessage, it must not be called from Send.
+func (b *Config) FailMessage(channel string, provisionalID string, err error) {
+	b.sendMsgAck(channel, provisionalID, config.MsgAck{Err: err})
+}
+
+func (b *Config) sendMsgAck(channel string, provisionalID string, ack config.MsgAck) {
+	b.Remote <- config.Message{
+		Event:   config.EventMsgAck,
+		Account: b.Account,
+		Channel: channel,
+		ID:      provisionalID,
+		Extra:   map[string][]interface{}{config.EventMsgAck: {ack}},
+	}
+}
+
+// ChannelLister is implemented by bridges which can list the channels they can
+// join, so that channel patterns in gateways are resolved on startup.
+type ChannelLister interface {
+	ListChannels() ([]string, error)
+}
+
+// ChannelNameResolver is implemented by bridges whose messages can carry a
+// channel ID (eg. "ID:123456") rather than its name, so that samechannelgateways
+// can match the channel by name with the channels of other bridges.
+type ChannelNameResolver interface {
+	ResolveChannelName(channel string) string
+}
+
+// Permalinker is implemented by bridges whose messages have a permanent URL,
+// so that the link control command can point to the relayed copies of a
+// message.
+type Permalinker interface {
+	// Permalink returns the URL of the message id, as returned by Send, in
+	// channel, as named in the gateway.
+	Permalink(channel string, id string) (string, error)
+}
+
+// CommandInfo describes a control command of the gateway, for the bridges
+// which declare them to their platform.
+type CommandInfo struct {
+	Name  string
+	Usage string
+	Help  string
+}
+
+// CommandBridger is implemented by bridges receiving control commands through
+// a native mechanism of their platform, eg. Discord slash commands or Telegram
+// bot commands. The commands are sent to the gateway as EventCommand messages,
+// whose replies are given back to ReplyCommand.
+type CommandBridger interface {
+	// RegisterCommands declares the commands to the platform.
+	RegisterCommands(commands []CommandInfo) error
+	ReplyCommand(cmd config.Message, reply string) error
+}
+
+// HealthWarner is implemented by bridges which can run with reduced
+// functionality, so that the reason shows in the health of the router.
+type HealthWarner interface {
+	// HealthWarnings returns the problems of the bridge, none when it works
+	// fully.
+	HealthWarnings() []string
+}
+
+// Heartbeater is implemented by bridges which can be probed by the heartbeats
+// of the router, see HeartbeatInterval. The router gives up on a probe which
+// doesn't return within HeartbeatTimeout.
+type Heartbeater interface {
+	// Alive returns an error when the goroutines receiving the messages of
+	// the bridge stopped.
+	Alive() error
+	// Ping returns an error when the server of the bridge can't be reached.
+	Ping(ctx context.Context) error
+}
+
+// Heartbeat is the result of the last heartbeats of the bridge of an account.
+type Heartbeat struct {
+	Account string `json:"account"`
+	// LastAlive and LastReachable are the last successful probes, zero when
+	// none succeeded yet
+	LastAlive     time.Time `json:"last_alive"`
+	LastReachable time.Time `json:"last_reachable"`
+	// Failures is the number of failed heartbeats since the last success
+	Failures  int    `json:"failures,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// HeartbeatReporter is implemented by bridges which show the heartbeats of the
+// bridges of the router, eg. the api bridge. heartbeats returns them ordered by
+// account.
+type HeartbeatReporter interface {
+	SetHeartbeats(heartbeats func() []Heartbeat)
+}
+
+// MentionTranslator is implemented by bridges which turn the @-mentions of the
+// messages they send into mentions of their users, eg. nctalk. names returns
+// the user IDs of the users of the account linked in the identity map of the
+// gateway, by the names they are mentioned with on the other networks.
+type MentionTranslator interface {
+	SetMentionNames(names func() map[string]string)
+}
+
 // Factory is the factory function to create a bridge
 type Factory func(*Config) Bridger
 
@@ -69,11 +206,88 @@ func New(bridge *config.Bridge) *Bridge {
 		Protocol: protocol,
 		Account:  bridge.Account,
 		Joined:   make(map[string]bool),
+		Budget:   NewAPIBudget(0),
+		sending:  make(chan struct{}, 1),
 	}
 }
 
+// JoinChannels joins the channels of the bridge which aren't joined yet. With
+// LazyJoin they are joined in the background and JoinChannels returns at once,
+// the channels messages are sent to being joined first by JoinChannelByID.
 func (b *Bridge) JoinChannels() error {
-	return b.joinChannels(b.Channels, b.Joined)
+	if !b.GetBool("LazyJoin") {
+		return b.joinChannels()
+	}
+
+	b.joins.Add(1)
+	go func() {
+		defer b.joins.Done()
+		if err := b.joinChannels(); err != nil {
+			b.Log.Errorf("%s: joining channels in the background failed: %s", b.Account, err)
+		}
+	}()
+	return nil
+}
+
+// WaitJoins waits for the channels joined in the background with LazyJoin.
+func (b *Bridge) WaitJoins() {
+	b.joins.Wait()
+}
+
+// JoinChannelByID joins the channel ID of the bridge if it isn't joined yet.
+func (b *Bridge) JoinChannelByID(ID string) error {
+	b.RLock()
+	channel, ok := b.Channels[ID]
+	b.RUnlock()
+	if !ok {
+		return nil
+	}
+	return b.joinChannel(ID, channel)
+}
+
+// SendInOrder calls Send once the previous calls made through SendInOrder
+// returned. The gateway sends all its messages through it.
+func (b *Bridge) SendInOrder(msg config.Message) (string, error) {
+	b.sending <- struct{}{}
+	defer func() { <-b.sending }()
+	return b.Send(msg)
+}
+
+// SendInOrderContext is SendInOrder giving up when ctx is done, while waiting
+// for the previous calls as well as during Send, returning ctx.Err(). Send
+// itself can't be interrupted: it keeps running and the next calls wait for
+// it, so that a hung bridge holds a single goroutine.
+func (b *Bridge) SendInOrderContext(ctx context.Context, msg config.Message) (string, error) {
+	select {
+	case b.sending <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	type result struct {
+		id  string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-b.sending }()
+		id, err := b.Send(msg)
+		done <- result{id, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.id, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ResetJoined forgets the joined channels, eg. after a reconnection.
+func (b *Bridge) ResetJoined() {
+	b.joinMu.Lock()
+	b.Joined = make(map[string]bool)
+	b.joinMu.Unlock()
 }
 
 // SetChannelMembers sets the newMembers to the bridge ChannelMembers
@@ -89,11 +303,45 @@ func (b *Bridge) GetConfigKey(key string) string {
 	return b.Account + "." + key
 }
 
+// UseCredentials replaces the settings of the account by settings, eg. its
+// standby credentials, until ResetCredentials. They are kept apart from the
+// configuration, whose changes they would hide when it is reloaded.
+func (b *Bridge) UseCredentials(settings map[string]any) {
+	credentials := make(map[string]any, len(settings))
+	for key, value := range settings {
+		credentials[strings.ToLower(key)] = value
+	}
+	b.credentialsMu.Lock()
+	b.credentials = credentials
+	b.credentialsMu.Unlock()
+}
+
+// ResetCredentials goes back to the settings of the configuration.
+func (b *Bridge) ResetCredentials() {
+	b.credentialsMu.Lock()
+	b.credentials = nil
+	b.credentialsMu.Unlock()
+}
+
+// credential returns the value of key in the credentials in use.
+func (b *Bridge) credential(key string) (any, bool) {
+	b.credentialsMu.RLock()
+	defer b.credentialsMu.RUnlock()
+	value, ok := b.credentials[strings.ToLower(key)]
+	return value, ok
+}
+
 func (b *Bridge) IsKeySet(key string) bool {
+	if _, ok := b.credential(key); ok {
+		return true
+	}
 	return b.Config.IsKeySet(b.GetConfigKey(key)) || b.Config.IsKeySet("general."+key)
 }
 
 func (b *Bridge) GetBool(key string) bool {
+	if value, ok := b.credential(key); ok {
+		return cast.ToBool(value)
+	}
 	val, ok := b.Config.GetBool(b.GetConfigKey(key))
 	if !ok {
 		val, _ = b.Config.GetBool("general." + key)
@@ -102,6 +350,9 @@ func (b *Bridge) GetBool(key string) bool {
 }
 
 func (b *Bridge) GetInt(key string) int {
+	if value, ok := b.credential(key); ok {
+		return cast.ToInt(value)
+	}
 	val, ok := b.Config.GetInt(b.GetConfigKey(key))
 	if !ok {
 		val, _ = b.Config.GetInt("general." + key)
@@ -110,6 +361,9 @@ func (b *Bridge) GetInt(key string) int {
 }
 
 func (b *Bridge) GetString(key string) string {
+	if value, ok := b.credential(key); ok {
+		return cast.ToString(value)
+	}
 	val, ok := b.Config.GetString(b.GetConfigKey(key))
 	if !ok {
 		val, _ = b.Config.GetString("general." + key)
@@ -118,6 +372,9 @@ func (b *Bridge) GetString(key string) string {
 }
 
 func (b *Bridge) GetStringSlice(key string) []string {
+	if value, ok := b.credential(key); ok {
+		return cast.ToStringSlice(value)
+	}
 	val, ok := b.Config.GetStringSlice(b.GetConfigKey(key))
 	if !ok {
 		val, _ = b.Config.GetStringSlice("general." + key)
@@ -170,6 +427,12 @@ func (b *Bridge) SetVal(key string, value any) {
 // TODO: maybe protocols without HTTP downloads at all could override
 // this method and return nil? Or the other way around?
 func (b *Bridge) NewHttpClient(http_proxy string) (*http.Client, error) {
+	var transport *http.Transport
+	if b.customDialer() {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = b.Dialer(30 * time.Second).DialContext
+	}
+
 	if http_proxy != "" {
 		proxyUrl, err := url.Parse(b.GetString("http_proxy"))
 		if err != nil {
@@ -178,17 +441,25 @@ func (b *Bridge) NewHttpClient(http_proxy string) (*http.Client, error) {
 
 		b.Log.Debugf("%s using HTTP proxy %s", b.Protocol, proxyUrl)
 
+		if transport == nil {
+			transport = &http.Transport{}
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
 		return &http.Client{
 			Timeout:   time.Second * 15,
-			Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)},
+			Transport: transport,
 		}, nil
 	}
 
 	b.Log.Debugf("%s not using HTTP proxy", b.Protocol)
 
-	return &http.Client{
+	client := &http.Client{
 		Timeout: time.Second * 5,
-	}, nil
+	}
+	if transport != nil {
+		client.Transport = transport
+	}
+	return client, nil
 }
 
 var errHttpGetNotOk = errors.New("HTTP server responded non-OK code")
@@ -233,6 +504,37 @@ func (b *Bridge) HttpGetBytes(uri string) (*[]byte, error) {
 	return &data, nil
 }
 
+// HttpGetFile downloads the data of fi from a given URI, if the request
+// succeeds and HTTP response status is 200 (OK). The data is streamed to a
+// spool file past MediaSpoolSize, and the download stops with
+// helper.ErrFileTooLarge past MediaDownloadSize.
+func (b *Bridge) HttpGetFile(uri string, fi *config.FileInfo) error {
+	req, err := b.Bridger.NewHttpRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+
+	b.Log.Debugf("Getting HTTP file with request: %#v", req)
+
+	resp, err := b.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return HttpGetNotOkError(uri, resp.StatusCode)
+	}
+
+	maxSize := int64(b.General.MediaDownloadSize)
+	if resp.ContentLength > maxSize {
+		fi.Size = resp.ContentLength
+		return helper.ErrFileTooLarge
+	}
+
+	return helper.SpoolReader(b.Log, fi, resp.Body, maxSize, b.General)
+}
+
 // HttpUpload uploads data to a URI, and validates the response status code.
 //
 // Params:
@@ -245,10 +547,28 @@ func (b *Bridge) HttpGetBytes(uri string) (*[]byte, error) {
 //
 // The response body is always discarded.
 func (b *Bridge) HttpUpload(method string, uri string, headers map[string]string, data *[]byte, ok_status []int) error {
-	req, err := b.Bridger.NewHttpRequest(method, uri, bytes.NewReader(*data))
+	return b.httpUpload(method, uri, headers, bytes.NewReader(*data), int64(len(*data)), ok_status)
+}
+
+// HttpUploadFile uploads the data of fi to a URI like HttpUpload, streamed
+// from its spool file for the spooled files instead of loaded in memory.
+func (b *Bridge) HttpUploadFile(method string, uri string, headers map[string]string, fi config.FileInfo, ok_status []int) error {
+	r, err := fi.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return b.httpUpload(method, uri, headers, r, fi.DataSize(), ok_status)
+}
+
+func (b *Bridge) httpUpload(method string, uri string, headers map[string]string, body io.Reader, size int64, ok_status []int) error {
+	req, err := b.Bridger.NewHttpRequest(method, uri, body)
 	if err != nil {
 		return err
 	}
+	// the size of the readers of the spool files isn't known to net/http
+	req.ContentLength = size
 
 	for header_name, header_value := range headers {
 		req.Header.Set(header_name, header_value)
@@ -272,8 +592,10 @@ func (b *Bridge) HttpUpload(method string, uri string, headers map[string]string
 	}
 
 	for _, expected_code := range ok_status {
-		b.Log.Debugf("Successful file upload with code %d", expected_code)
-		return nil
+		if resp.StatusCode == expected_code {
+			b.Log.Debugf("Successful file upload with code %d", expected_code)
+			return nil
+		}
 	}
 
 	return HttpGetNotOkError(uri, resp.StatusCode)
@@ -317,6 +639,26 @@ func (b *Bridge) AddAvatarFromBytes(msg *config.Message, filename string, id str
 	return b.addAttachment(msg, filename, id, comment, "", data, true)
 }
 
+// ConvertSticker converts sticker data to the portable format set with the
+// MediaConvertStickers setting (png, gif or webp), and returns the new file
+// name. When the setting is empty or the conversion fails, the sticker is
+// relayed untouched.
+func (b *Bridge) ConvertSticker(name string, data *[]byte) string {
+	format := b.GetString("MediaConvertStickers")
+	if format == "" {
+		return name
+	}
+
+	newName, err := helper.ConvertSticker(name, data, format, b.Log)
+	if err != nil {
+		b.Log.Errorf("sticker %s conversion to %s failed: %v", name, format, err)
+		return name
+	}
+
+	b.Log.Debugf("Converted sticker %s to %s", name, newName)
+	return newName
+}
+
 // NewHttpRequest produces a new http.Request instance with bridge-specific settings.
 //
 // This is used by bridges where HTTP downloads require a cookie/token, by overriding
@@ -333,23 +675,41 @@ func (b *Bridge) handlePanic() {
 	}
 }
 
-func (b *Bridge) joinChannels(channels map[string]config.ChannelInfo, exists map[string]bool) error {
+func (b *Bridge) joinChannels() error {
+	// the gateway adds channels while the bridge joins them
+	b.RLock()
+	channels := maps.Clone(b.Channels)
+	b.RUnlock()
+
 	for ID, channel := range channels {
-		if exists[ID] {
-			continue
+		if err := b.joinChannel(ID, channel); err != nil {
+			return err
 		}
+	}
 
-		b.Log.Infof("%s: joining %s (ID: %s)", b.Account, channel.Name, ID)
-		time.Sleep(time.Duration(b.GetInt("JoinDelay")) * time.Millisecond)
+	return nil
+}
 
-		err := b.JoinChannel(channel)
-		if err != nil {
-			return err
-		}
+// joinChannel joins channel unless it is already joined, JoinDelay
+// milliseconds after the previous join of the bridge.
+func (b *Bridge) joinChannel(ID string, channel config.ChannelInfo) error {
+	b.joinMu.Lock()
+	defer b.joinMu.Unlock()
 
-		exists[ID] = true
+	if b.Joined[ID] {
+		return nil
 	}
 
+	b.Log.Infof("%s: joining %s (ID: %s)", b.Account, channel.Name, ID)
+	time.Sleep(time.Until(b.lastJoin.Add(time.Duration(b.GetInt("JoinDelay")) * time.Millisecond)))
+	b.lastJoin = time.Now()
+
+	err := b.JoinChannel(channel)
+	if err != nil {
+		return err
+	}
+
+	b.Joined[ID] = true
 	return nil
 }
 
@@ -358,21 +718,15 @@ func (b *Bridge) joinChannels(channels map[string]config.ChannelInfo, exists map
 // This method will process received bytes. If bytes are not set, they will be downloaded from the given URL.
 // If neither data bytes nor uri is provided, this will be a hard error because there's a logic error somewhere.
 func (b *Bridge) addAttachment(msg *config.Message, filename string, id string, comment string, uri string, data *[]byte, avatar bool) error {
-	if data != nil {
-		return b.addAttachmentProcess(msg, filename, id, comment, uri, data, avatar)
-	}
-
-	if uri == "" {
-		// This should never happen
-		b.Log.Fatalf("Logic error in bridge %s: attachment should have either URL or data set, neither was provided", b.Protocol)
-	}
-
-	data, err := b.HttpGetBytes(uri)
-	if err != nil {
-		return err
+	fi := config.FileInfo{
+		Name:    filename,
+		URL:     uri,
+		Comment: comment,
+		Avatar:  avatar,
+		// TODO: if id is not set, maybe use hash of bytes?
+		NativeID: id,
 	}
-
-	return b.addAttachmentProcess(msg, filename, id, comment, uri, data, avatar)
+	return b.addAttachmentProcess(msg, fi, uri, data)
 }
 
 // Internal method similar to addAttachment, but will not keep the URL.
@@ -380,21 +734,13 @@ func (b *Bridge) addAttachment(msg *config.Message, filename string, id string,
 // This is useful so protected URLs requiring specific headers (such as matrix authenticated media)
 // can be downloaded, and then omitted so other bridges don't spread along broken URLs.
 func (b *Bridge) addAttachmentNoURL(msg *config.Message, filename string, id string, comment string, uri string, data *[]byte, avatar bool) error {
-	if data != nil {
-		return b.addAttachmentProcess(msg, filename, id, comment, "", data, avatar)
-	}
-
-	if uri == "" {
-		// This should never happen
-		b.Log.Fatalf("Logic error in bridge %s: attachment should have either URL or data set, neither was provided", b.Protocol)
-	}
-
-	data, err := b.HttpGetBytes(uri)
-	if err != nil {
-		return err
+	fi := config.FileInfo{
+		Name:     filename,
+		Comment:  comment,
+		Avatar:   avatar,
+		NativeID: id,
 	}
-
-	return b.addAttachmentProcess(msg, filename, id, comment, "", data, avatar)
+	return b.addAttachmentProcess(msg, fi, uri, data)
 }
 
 type errFileTooLarge struct {
@@ -415,34 +761,44 @@ func (e *errFileBlacklisted) Error() string {
 	return fmt.Sprintf("File %#v matches the backlist, not downloading it", e.FileName)
 }
 
-func (b *Bridge) addAttachmentProcess(msg *config.Message, filename string, id string, comment string, uri string, data *[]byte, avatar bool) error {
-	size := len(*data)
-	if size > b.General.MediaDownloadSize {
-		return &errFileTooLarge{
-			FileName: filename,
-			Size:     size,
-			MaxSize:  b.General.MediaDownloadSize,
+// addAttachmentProcess adds fi to msg, with the data bytes or downloaded from
+// uri when data is nil. The downloads are streamed, see HttpGetFile.
+func (b *Bridge) addAttachmentProcess(msg *config.Message, fi config.FileInfo, uri string, data *[]byte) error {
+	// Apply `MediaDownloadBlackList` regexes
+	if b.Config.IsFilenameBlacklisted(fi.Name) {
+		return &errFileBlacklisted{
+			FileName: fi.Name,
 		}
 	}
 
-	// Apply `MediaDownloadBlackList` regexes
-	if b.Config.IsFilenameBlacklisted(filename) {
-		return &errFileBlacklisted{
-			FileName: filename,
+	tooLarge := &errFileTooLarge{
+		FileName: fi.Name,
+		MaxSize:  b.General.MediaDownloadSize,
+	}
+	switch {
+	case data != nil:
+		fi.Data = data
+		fi.Size = int64(len(*data))
+		if len(*data) > b.General.MediaDownloadSize {
+			tooLarge.Size = len(*data)
+			return tooLarge
 		}
+		helper.SpoolFile(b.Log, &fi, b.General)
+	case uri != "":
+		if err := b.HttpGetFile(uri, &fi); err != nil {
+			if errors.Is(err, helper.ErrFileTooLarge) {
+				tooLarge.Size = int(fi.Size)
+				return tooLarge
+			}
+			return err
+		}
+	default:
+		// This should never happen
+		b.Log.Fatalf("Logic error in bridge %s: attachment should have either URL or data set, neither was provided", b.Protocol)
 	}
 
-	b.Log.Debugf("Download OK %#v %#v", filename, size)
-	msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{
-		Name:    filename,
-		Data:    data,
-		URL:     uri,
-		Comment: comment,
-		Avatar:  avatar,
-		// TODO: if id is not set, maybe use hash of bytes?
-		NativeID: id,
-		Size:     int64(len(*data)),
-	})
+	b.Log.Debugf("Download OK %#v %#v", fi.Name, fi.Size)
+	msg.Extra["file"] = append(msg.Extra["file"], fi)
 
 	return nil
 }
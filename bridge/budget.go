@@ -0,0 +1,147 @@
+package bridge
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// budgetWindow is the period over which the API calls are counted.
+	budgetWindow = time.Minute
+	// budgetThreshold is the share of the limit from which the operations
+	// which can wait are slowed down.
+	budgetThreshold = 0.8
+)
+
+// APIBudget accounts the API calls of a bridge account over the last minute,
+// so that the operations which can wait (avatar fetches, member syncs) are
+// slowed down before the limit of the platform is reached.
+type APIBudget struct {
+	mu sync.Mutex
+
+	// limit is the number of calls per minute allowed by the platform, 0
+	// when unknown.
+	limit   int
+	calls   []time.Time
+	counted bool
+
+	total       uint64
+	rateLimited uint64
+	deferred    uint64
+}
+
+// APIBudgetStats are the counters of an APIBudget.
+type APIBudgetStats struct {
+	// Calls is the number of calls of the last minute
+	Calls int `json:"calls"`
+	// Limit is the number of calls per minute allowed, 0 when unknown
+	Limit int `json:"limit"`
+	// Total is the number of calls since startup
+	Total uint64 `json:"total"`
+	// RateLimited is the number of calls refused by the platform because of
+	// its rate limits
+	RateLimited uint64 `json:"rate_limited"`
+	// Deferred is the number of operations slowed down or skipped to spare
+	// the budget
+	Deferred uint64 `json:"deferred"`
+}
+
+// NewAPIBudget returns a budget of limit calls per minute, unlimited when
+// limit isn't positive.
+func NewAPIBudget(limit int) *APIBudget {
+	return &APIBudget{limit: max(limit, 0)}
+}
+
+// Record counts an API call.
+func (b *APIBudget) Record() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counted = true
+	b.total++
+	b.calls = append(b.prune(time.Now()), time.Now())
+}
+
+// prune forgets the calls older than budgetWindow and returns the others.
+func (b *APIBudget) prune(now time.Time) []time.Time {
+	i := 0
+	for i < len(b.calls) && now.Sub(b.calls[i]) >= budgetWindow {
+		i++
+	}
+	b.calls = b.calls[i:]
+	return b.calls
+}
+
+// Tight returns true when the calls of the last minute are close to the limit.
+// The operations which can be skipped, like avatar fetches, are skipped then.
+func (b *APIBudget) Tight() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.tight(time.Now()) {
+		return false
+	}
+	b.deferred++
+	return true
+}
+
+func (b *APIBudget) tight(now time.Time) bool {
+	return b.limit > 0 && float64(len(b.prune(now))) >= float64(b.limit)*budgetThreshold
+}
+
+// Wait blocks an operation which can wait, like a member sync, while the calls
+// of the last minute are close to the limit.
+func (b *APIBudget) Wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	waited := false
+	for now := time.Now(); b.tight(now); now = time.Now() {
+		if !waited {
+			waited = true
+			b.deferred++
+		}
+		// wait for the oldest call to leave the window
+		delay := budgetWindow - now.Sub(b.calls[0])
+		b.mu.Unlock()
+		time.Sleep(delay)
+		b.mu.Lock()
+	}
+}
+
+// Stats returns the counters of the budget, and false when no call was ever
+// counted, eg. for the protocols which don't account their calls.
+func (b *APIBudget) Stats() (APIBudgetStats, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return APIBudgetStats{
+		Calls:       len(b.prune(time.Now())),
+		Limit:       b.limit,
+		Total:       b.total,
+		RateLimited: b.rateLimited,
+		Deferred:    b.deferred,
+	}, b.counted
+}
+
+// Transport returns a transport counting the requests sent through rt, or
+// http.DefaultTransport when rt is nil, as API calls.
+func (b *APIBudget) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &budgetTransport{budget: b, next: rt}
+}
+
+type budgetTransport struct {
+	budget *APIBudget
+	next   http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.budget.Record()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.budget.mu.Lock()
+		t.budget.rateLimited++
+		t.budget.mu.Unlock()
+	}
+	return resp, err
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -29,13 +31,91 @@ const (
 	EventMsgDelete         = "msg_delete"
 	EventFileDelete        = "file_delete"
 	EventAPIConnected      = "api_connected"
+	EventAPIEncrypted      = "api_encrypted"
 	EventUserTyping        = "user_typing"
 	EventGetChannelMembers = "get_channel_members"
 	EventNoticeIRC         = "notice_irc"
+	EventReaction          = "reaction"
+	EventMsgAck            = "msg_ack"
+	// EventMsgPin messages announce that the message ParentID was pinned.
+	EventMsgPin = "msg_pin"
+	// EventCommand messages are control commands received through a native
+	// mechanism of the bridge, see bridge.CommandBridger.
+	EventCommand = "command"
+	// EventAnnounce messages are addressed to the moderators of the channels,
+	// see the AnnounceSupport of the protocols.
+	EventAnnounce = "announce"
 )
 
 const ParentIDNotFound = "msg-parent-not-found"
 
+// ExtraBot is the Extra key flagging messages sent by a bot, see Message.MarkBot.
+const ExtraBot = "bot"
+
+// ExtraEphemeral is the Extra key holding the lifetime of ephemeral messages,
+// see Message.MarkEphemeral.
+const ExtraEphemeral = "ephemeral"
+
+// ExtraExpired is the Extra key holding the name of the gateway of the
+// deletions of the relayed copies of expired ephemeral messages.
+const ExtraExpired = "expired"
+
+// ExtraForward is the Extra key holding the Forward of forwarded messages, see
+// Message.SetForward.
+const ExtraForward = "forward"
+
+// ExtraReactionRemoved is the Extra key flagging EventReaction messages which
+// remove the reaction instead of adding it, see Message.MarkReactionRemoved.
+const ExtraReactionRemoved = "reaction_removed"
+
+// ExtraRedirect is the Extra key holding the channel a message is relayed to
+// instead of all the channels of the gateway, see Message.SetRedirect.
+const ExtraRedirect = "redirect"
+
+// Forward is the origin of a forwarded message. Either field may be empty.
+type Forward struct {
+	// From is the author of the original message
+	From string
+	// Channel is the channel or chat the original message was sent in
+	Channel string
+}
+
+// Values of the BotMessages setting of gateways.
+const (
+	BotMessagesTag   = "tag"
+	BotMessagesRelay = "relay"
+	BotMessagesDrop  = "drop"
+)
+
+// Values of the RateLimitAction setting of gateways.
+const (
+	RateLimitDrop     = "drop"
+	RateLimitQueue    = "queue"
+	RateLimitCollapse = "collapse"
+)
+
+// Values of the FileOnlyMessages setting of accounts.
+const (
+	FileOnlyFile     = "file"
+	FileOnlyDescribe = "describe"
+	FileOnlySkip     = "skip"
+)
+
+// Values of the EphemeralMessages setting of gateways.
+const (
+	EphemeralMessagesTag    = "tag"
+	EphemeralMessagesDrop   = "drop"
+	EphemeralMessagesExpire = "expire"
+)
+
+// MsgAck is the outcome of a message a bridge sent asynchronously, stored in
+// Extra[EventMsgAck] of an EventMsgAck message whose ID is the provisional ID
+// returned by Send. Either RemoteID or Err is set.
+type MsgAck struct {
+	RemoteID string
+	Err      error
+}
+
 type Message struct {
 	Text      string    `json:"text"`
 	Channel   string    `json:"channel"`
@@ -60,6 +140,93 @@ func (m Message) ParentValid() bool {
 	return m.ParentID != "" && !m.ParentNotFound()
 }
 
+// MarkBot flags a received message as sent by a bot (or an app, integration,
+// etc.) of the platform, for the BotMessages setting of gateways.
+func (m *Message) MarkBot() {
+	if m.Extra == nil {
+		m.Extra = make(map[string][]interface{})
+	}
+	m.Extra[ExtraBot] = []interface{}{true}
+}
+
+// IsBot returns true if the message was flagged with MarkBot.
+func (m Message) IsBot() bool {
+	return len(m.Extra[ExtraBot]) > 0
+}
+
+// MarkEphemeral flags a received message as ephemeral (disappearing, view
+// once, ...) on the platform, for the EphemeralMessages setting of gateways.
+// ttl is the time after which it disappears, 0 if unknown.
+func (m *Message) MarkEphemeral(ttl time.Duration) {
+	if m.Extra == nil {
+		m.Extra = make(map[string][]interface{})
+	}
+	m.Extra[ExtraEphemeral] = []interface{}{ttl}
+}
+
+// Ephemeral returns the lifetime of a message flagged with MarkEphemeral.
+func (m Message) Ephemeral() (time.Duration, bool) {
+	if len(m.Extra[ExtraEphemeral]) == 0 {
+		return 0, false
+	}
+	ttl, _ := m.Extra[ExtraEphemeral][0].(time.Duration)
+	return ttl, true
+}
+
+// SetForward flags a received message as forwarded from fwd, the gateway adds
+// the attribution to its text for each destination.
+func (m *Message) SetForward(fwd Forward) {
+	if m.Extra == nil {
+		m.Extra = make(map[string][]interface{})
+	}
+	m.Extra[ExtraForward] = []interface{}{fwd}
+}
+
+// GetForward returns the origin of a message flagged with SetForward.
+func (m Message) GetForward() (Forward, bool) {
+	if len(m.Extra[ExtraForward]) == 0 {
+		return Forward{}, false
+	}
+	fwd, ok := m.Extra[ExtraForward][0].(Forward)
+	return fwd, ok
+}
+
+// MarkReactionRemoved flags a received EventReaction message as the removal of
+// the reaction in its Text.
+func (m *Message) MarkReactionRemoved() {
+	if m.Extra == nil {
+		m.Extra = make(map[string][]interface{})
+	}
+	m.Extra[ExtraReactionRemoved] = []interface{}{true}
+}
+
+// ReactionRemoved returns true if the message was flagged with
+// MarkReactionRemoved.
+func (m Message) ReactionRemoved() bool {
+	return len(m.Extra[ExtraReactionRemoved]) > 0
+}
+
+// SetRedirect flags a message to be relayed to the channels named channel of
+// its gateway only. The Extra of m is copied, as it is shared by the copies
+// of the message relayed by the other gateways.
+func (m *Message) SetRedirect(channel string) {
+	extra := make(map[string][]interface{}, len(m.Extra)+1)
+	for k, v := range m.Extra {
+		extra[k] = v
+	}
+	extra[ExtraRedirect] = []interface{}{channel}
+	m.Extra = extra
+}
+
+// Redirect returns the channel set with SetRedirect.
+func (m Message) Redirect() (string, bool) {
+	if len(m.Extra[ExtraRedirect]) == 0 {
+		return "", false
+	}
+	channel, ok := m.Extra[ExtraRedirect][0].(string)
+	return channel, ok
+}
+
 // GetFileInfos extracts typed FileInfo list from the message.
 //
 // This method is guaranteed not to fail. The inner type casting should never
@@ -92,6 +259,11 @@ func (m Message) GetFileInfos(log *logrus.Entry) *[]*FileInfo {
 // (such as IRC), the gateway router will upload the file to the media server
 // and populate the URL/SHA fields. The Data/Size fields are not removed
 // in this process. See handleFiles in gateway/handlers.go
+//
+// Voice is set for voice notes recorded in the chat application, as opposed to
+// regular audio files, so that destinations supporting them can render a
+// native voice message. Duration (in milliseconds) and Waveform (amplitudes
+// from 0 to 1024) are optional voice note metadata.
 type FileInfo struct {
 	Name     string
 	Data     *[]byte
@@ -101,6 +273,49 @@ type FileInfo struct {
 	Avatar   bool
 	SHA      string
 	NativeID string
+	Voice    bool
+	Duration int
+	Waveform []int
+	// AltText is the description of the file for accessibility, eg. the alt
+	// text of an image
+	AltText string
+	// Path is the spool file holding the data of the large files instead of
+	// Data, see MediaSpoolPath. Use Open or Bytes to read the data.
+	Path string `json:"-"`
+}
+
+// Open returns a reader of the data of the file, in memory or spooled.
+func (fi FileInfo) Open() (io.ReadCloser, error) {
+	if fi.Path != "" {
+		return os.Open(fi.Path)
+	}
+	if fi.Data == nil {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return io.NopCloser(bytes.NewReader(*fi.Data)), nil
+}
+
+// Bytes returns the data of the file, read from its spool file for the spooled
+// files. They are loaded in memory only for the time of the upload.
+func (fi FileInfo) Bytes() ([]byte, error) {
+	if fi.Path != "" {
+		return os.ReadFile(fi.Path)
+	}
+	if fi.Data == nil {
+		return nil, nil
+	}
+	return *fi.Data, nil
+}
+
+// DataSize returns the size of the data of the file, in memory or spooled.
+func (fi FileInfo) DataSize() int64 {
+	if fi.Path != "" {
+		return fi.Size
+	}
+	if fi.Data == nil {
+		return 0
+	}
+	return int64(len(*fi.Data))
 }
 
 var errFileCast = errors.New("failed to cast config.FileInfo")
@@ -116,6 +331,9 @@ type ChannelInfo struct {
 	ID          string
 	SameChannel map[string]bool
 	Options     ChannelOptions
+	// PatternKey is set for channels added because they match a channel
+	// pattern, to the part matched by its wildcards.
+	PatternKey string
 }
 
 type ChannelMember struct {
@@ -129,67 +347,123 @@ type ChannelMember struct {
 type ChannelMembers []ChannelMember
 
 type Protocol struct {
+	AdminListen            string   // general, address of the admin API
+	AdminToken             string   // general, bearer token of the admin API
+	Admins                 []string // all protocols, user IDs allowed to run admin control commands
+	AlertModerators        bool     // all protocols, announces the alerts of matterbridge to the moderators
 	AllowMention           []string // discord
+	AsyncSend              bool     // all protocols, sends the messages of the bridge in its own goroutine
+	APIRateBudget          int      // discord, matrix, slack, API calls per minute
+	AttachmentFormat       string   // irc, nctalk, sshchat, zulip
+	AuditLog               string   // general, file the dropped messages and moderation actions are appended to
+	AuditLogHashContent    bool     // general, logs the SHA-256 of the texts instead of the texts
 	BindAddress            string   // mattermost, slack // DEPRECATED
+	BotTag                 string   // all protocols, replaces {BOT} in RemoteNickFormat
 	Buffer                 int      // api
 	Charset                string   // irc
+	CharsetIn              string   // irc, overrides Charset for received messages
+	CharsetOut             string   // irc, overrides Charset for sent messages
 	ClientID               string   // msteams
 	Casemapping            string   // IRC, auto-configured setting for allowable characters in nicks, not configurable
 	ColorNicks             bool     // only irc for now
+	CommandPrefix          string   // general, prefix of the control commands sent in channels
+	Commands               bool     // all protocols, enables control commands
+	Component              string   // xmpp, domain of the component showing the remote participants
+	ComponentSecret        string   // xmpp
+	ComponentServer        string   // xmpp, address of the component port of the server
 	CustomStatus           string   // discord
+	DANEResolver           string   // IRC, DNSSEC validating resolver of the TLSA records
 	Debug                  bool     // general
 	DebugLevel             int      // only for irc now
 	DeviceID               string   // matrix
 	DisableMarkdownParsing bool     // matrix
 	DisableWebPagePreview  bool     // telegram
+	DisabledProtocols      []string // general, protocols which are compiled in but not started
+	DownloadCustomEmoji    bool     // telegram
 	EditSuffix             string   // mattermost, slack, discord, telegram
 	EditDisable            bool     // mattermost, slack, discord, telegram
 	EditMaxDays            int      // discord
+	EmojiShortcodes        bool     // all protocols
+	EphemeralTag           string   // all protocols, prepended to the ephemeral messages of gateways with EphemeralMessages="tag"
+	FileOnlyMessages       string   // all protocols, what is sent for the messages with files and no text: file (default), describe or skip
+	HappyEyeballsDelay     int      // all protocols, milliseconds before the other IP family is tried, negative to try one at a time
+	HeartbeatAction        string   // all protocols, "reconnect" or "alert" when the heartbeats fail
+	HeartbeatFailures      int      // all protocols, consecutive failed heartbeats before HeartbeatAction
+	HeartbeatInterval      int      // all protocols, in seconds
+	HeartbeatTimeout       int      // all protocols, in seconds
 	HTMLDisable            bool     // matrix
 	IconURL                string   // mattermost, slack
 	IgnoreFailureOnStart   bool     // general
+	IPFamily               string   // irc, xmpp and the HTTP client of all protocols: ipv4, ipv6, prefer-ipv4 or prefer-ipv6
 	IgnoreNicks            string   // all protocols
 	IgnoreMessages         string   // all protocols
+	IgnoreUserIDs          []string // all protocols
 	Jid                    string   // xmpp
 	JoinDelay              string   // all protocols
+	LazyJoin               bool     // all protocols
+	JoinTemplate           string   // all protocols, text of the joins from other bridges
 	Label                  string   // all protocols
+	LeaveTemplate          string   // all protocols, text of the leaves from other bridges
 	Login                  string   // mattermost, matrix
 	LogFile                string   // general
+	LongMessageLength      int      // all protocols, overrides the LongMessageLength of the gateways for the messages sent to the account
+	MaskPhoneNumbers       bool     // whatsapp, name the users without a name by their masked phone number
 	MediaDownloadBlackList []string
 	MediaDownloadPath      string // Write upload to a file on the same server.
 	MediaDownloadSize      int    // all protocols
 	MediaServerDownload    string
+	MediaSpoolPath         string     // general, directory of the large files waiting to be relayed
+	MediaSpoolSize         int        // general, size in bytes above which files are spooled
+	MediaConvertStickers   string     // all protocols
 	MediaConvertTgs        string     // telegram
 	MediaConvertWebPToPNG  bool       // telegram
+	MediaRateLimit         int        // all protocols, files per minute and channel
+	MediaRetentionDays     int        // general, age of the media server files removed
+	MediaRetentionSize     int        // general, size in bytes the media server is kept under
+	MediaTimeout           int        // general, in seconds
+	MediaWorkers           int        // general, files handled at the same time per gateway
 	MessageDelay           int        // IRC, time in millisecond to wait between messages
 	MessageFormat          string     // telegram
-	MessageLength          int        // IRC, max length of a message allowed, defaults to 512 (counting CRLF)
+	MessageLength          int        // IRC, xmpp, max length of a message allowed, defaults to 512 (counting CRLF) for IRC and unlimited for xmpp
 	MessagePrefix          int        // IRC, current length of message prefix for bot, not configurable
 	MessageQueue           int        // IRC, size of message queue for flood control
+	MessageSamples         int        // general, messages relayed or dropped last kept for the admin API
+	MessageStoreDays       int        // general, days the IDs of the messages are kept in the StorageBackend
 	MessageSplit           bool       // IRC, split long messages, default true.  If set false, let the irc library handle splitting
 	MessageSplitMaxCount   int        // discord, split long messages into at most this many messages instead of clipping (MessageLength=1950 cannot be configured)
+	MessageSplitNumbered   bool       // IRC, mumble, xmpp, end the parts of the split messages with (1/3), (2/3)...
+	ModRole                string     // discord, role pinged by the announcements
 	Muc                    string     // xmpp
 	MxID                   string     // matrix
 	Name                   string     // all protocols
 	Nick                   string     // all protocols
+	NickDisallowedChars    string     // all protocols, characters replaced by NickReplacement in the relayed nicks
 	NickFormatter          string     // mattermost, slack
+	NickMaxLength          int        // all protocols, maximum number of characters of the relayed nicks
+	NickReplacement        string     // all protocols
 	NickServNick           string     // IRC
 	NickServUsername       string     // IRC
 	NickServPassword       string     // IRC
+	NickStrip              []string   // all protocols, substrings removed from the relayed nicks
 	NicksPerRow            int        // mattermost, slack
 	NoHomeServerSuffix     bool       // matrix
 	NoSendJoinPart         bool       // all protocols
 	NoTLS                  bool       // mattermost, xmpp
 	Password               string     // IRC,mattermost,XMPP,matrix
+	PasteLines             int        // IRC, lines above which messages are uploaded to the media server
+	PeerPublicKey          string     // api, public key of the paired instance
 	PickleKey              string     // matrix
 	PrefixMessagesWithNick bool       // mattemost, slack
+	PresenceIdleTime       int        // xmpp, minutes after which the silent remote participants leave
 	PreserveThreading      bool       // slack
+	PrivateKey             string     // api
 	Protocol               string     // all protocols
 	QuoteDisable           bool       // telegram,discord
 	QuoteFormat            string     // telegram,discord
 	QuoteLengthLimit       int        // telegram,discord
 	RealName               string     // IRC
 	RecoveryKey            string     // matrix
+	RefreshToken           string     // mastodon, OAuth2 refresh token of AccessToken
 	RejoinDelay            int        // IRC
 	RelayFallbackNick      string     // IRC, fallback nick to use when SanitizeNick results in an empty message
 	RelayMsgSep            string     // IRC, autodetected, required separator char(s) in relayed nicks, not configurable
@@ -197,33 +471,54 @@ type Protocol struct {
 	ReplaceNicks           [][]string // all protocols
 	RemoteNickFormat       string     // all protocols
 	RunCommands            []string   // IRC
+	ScheduleFile           string     // general, file storing the scheduled messages
+	SendFailureThreshold   int        // all protocols, consecutive Send failures before a bridge is considered unhealthy
+	SendStallTimeout       int        // all protocols, in seconds, restarts an AsyncSend bridge stuck sending a message
+	SendTimeout            int        // all protocols, in seconds
 	Server                 string     // IRC,mattermost,XMPP,discord,matrix
 	SessionFile            string     // msteams,whatsapp
+	SpoilerFormat          string     // all protocols, how spoilers are shown on networks without spoilers
+	SharedKey              string     // api
 	ShowJoinPart           bool       // all protocols
+	ShowPins               bool       // all protocols
+	ShowReactions          bool       // all protocols without reactions
 	ShowTopicChange        bool       // slack
 	ShowUserTyping         bool       // slack
 	ShowEmbeds             bool       // discord
 	SkipTLSVerify          bool       // IRC, mattermost
 	SkipVersionCheck       bool       // mattermost
+	StorageBackend         string     // general, "sqlite", "bbolt", "redis" or "memory", keeps the state of matterbridge across restarts
+	StoragePath            string     // general, database of the sqlite and bbolt StorageBackend, URL of the redis one
 	StripNick              bool       // all protocols
 	StripMarkdown          bool       // irc
+	SyncFile               string     // matrix, file keeping the sync token across restarts
+	SyncLookback           int        // matrix, in seconds
 	SyncTopic              bool       // slack
 	TengoModifyMessage     string     // general
 	Team                   string     // mattermost
 	TeamID                 string     // msteams
 	TenantID               string     // msteams
+	ThreadReplies          bool       // discord and matrix, create threads for the replies
+	Timezone               string     // all protocols, timezone of {TIMESTAMP} in RemoteNickFormat
+	TimestampFormat        string     // all protocols, Go time layout of {TIMESTAMP} in RemoteNickFormat
+	TLSFingerprints        []string   // IRC, SHA-256 fingerprints of the certificate of the server or of its CA
 	Token                  string     // slack, discord, api, matrix
+	TopicTemplate          string     // all protocols, text of the topic changes from other bridges
+	TokenFile              string     // mastodon, file storing the refreshed OAuth2 tokens
 	Topic                  string     // zulip
 	URL                    string     // mattermost, slack // DEPRECATED
 	UseAPI                 bool       // mattermost, slack
+	UseDANE                bool       // IRC, validate the certificate of the server with its TLSA records
 	UseLocalAvatar         []string   // discord
 	UseSASL                bool       // IRC
 	UseTLS                 bool       // IRC
 	UseDiscriminator       bool       // discord
 	UseFirstName           bool       // telegram
 	UseUserName            bool       // discord, matrix, mattermost
+	UseVCardName           bool       // xmpp
 	UseInsecureURL         bool       // telegram
 	UseMSC4144             bool       // matrix
+	UseNotices             bool       // matrix
 	UserName               string     // IRC
 	UseRelayFallback       bool       // IRC, controls whether RelayFallbackNick is used, defaults to true
 	UseRelayMsg            bool       // IRC
@@ -233,9 +528,12 @@ type Protocol struct {
 }
 
 type ChannelOptions struct {
-	Key        string // irc, xmpp
-	WebhookURL string // discord
-	Topic      string // zulip
+	Key              string // irc, xmpp
+	OnboardingNotice string // overrides the OnboardingNotice of the gateway
+	PasteLines       int    // irc, overrides the PasteLines of the account, negative to disable
+	WebhookURL       string // discord
+	Topic            string // zulip
+	Embeds           bool   // discord
 }
 
 type Bridge struct {
@@ -243,14 +541,57 @@ type Bridge struct {
 	Channel     string
 	Options     ChannelOptions
 	SameChannel bool
+	// Optional lets the gateway run when the account fails to set up or to
+	// connect, the account is retried in the background
+	Optional bool
 }
 
 type Gateway struct {
 	Name   string
 	Enable bool
-	In     []Bridge
-	Out    []Bridge
-	InOut  []Bridge
+	// BotMessages is what to do with messages of bots: tag (default), relay or drop
+	BotMessages string
+	// EphemeralMessages is what to do with ephemeral messages: tag (default),
+	// drop or expire
+	EphemeralMessages string
+	// LongMessageLength is the number of characters above which messages are
+	// replaced by a preview of LongMessagePreview characters and a link to the
+	// full text on the media server, 0 to disable.
+	LongMessageLength  int
+	LongMessagePreview int
+	// PriorityUserIDs are the users whose messages bypass the rate limits of
+	// the gateway, as "userid" or "account/userid"
+	PriorityUserIDs []string
+	// UserRateLimit and ChannelRateLimit are the messages per minute a user
+	// and a channel can send through the gateway, in bursts of at most
+	// RateLimitBurst messages (the rate by default), 0 for no limit.
+	// RateLimitAction is what to do with the messages over the limits: drop
+	// (default), queue or collapse.
+	UserRateLimit    int
+	ChannelRateLimit int
+	RateLimitBurst   int
+	RateLimitAction  string
+	// DefaultAvatarURL and DefaultNick are relayed for the users without
+	// avatar or nick
+	DefaultAvatarURL string
+	DefaultNick      string
+	// OnboardingNotice is posted in the channels of the gateway when the
+	// bridges join them, and with OnboardingJoins to the users joining them,
+	// at most every OnboardingInterval seconds in a channel.
+	OnboardingNotice   string
+	OnboardingJoins    bool
+	OnboardingInterval int
+	// Commands enables the control commands in the channels of the gateway,
+	// for the CommandUserIDs only when set, as "userid" or "account/userid"
+	Commands       bool
+	CommandUserIDs []string
+	// Script is a tengo script run on the messages before they are relayed
+	// through the gateway, ScriptFile the file of one, see runScript
+	Script     string
+	ScriptFile string
+	In         []Bridge
+	Out        []Bridge
+	InOut      []Bridge
 }
 
 type Tengo struct {
@@ -267,20 +608,29 @@ type SameChannelGateway struct {
 	Accounts []string
 }
 
+// Highlight forwards the messages containing one of Keywords as a private
+// message to Target, a user on Account.
+type Highlight struct {
+	Account  string
+	Target   string
+	Keywords []string
+	// Gateways restricts the highlights to the messages of these gateways
+	Gateways []string
+}
+
 type BridgeValues struct {
 	API                map[string]Protocol
 	IRC                map[string]Protocol
 	Mattermost         map[string]Protocol
 	Matrix             map[string]Protocol
 	Slack              map[string]Protocol
-	SlackLegacy        map[string]Protocol
 	Steam              map[string]Protocol
 	XMPP               map[string]Protocol
 	Discord            map[string]Protocol
 	Telegram           map[string]Protocol
 	Rocketchat         map[string]Protocol
 	SSHChat            map[string]Protocol
-	WhatsApp           map[string]Protocol // TODO is this struct used? Search for "SlackLegacy" for example didn't return any results
+	WhatsApp           map[string]Protocol // TODO is this struct used?
 	Zulip              map[string]Protocol
 	Keybase            map[string]Protocol
 	Mumble             map[string]Protocol
@@ -288,6 +638,7 @@ type BridgeValues struct {
 	Tengo              Tengo
 	Gateway            []Gateway
 	SameChannelGateway []SameChannelGateway
+	Highlight          []Highlight
 }
 
 type Config interface {
@@ -299,8 +650,14 @@ type Config interface {
 	GetString(key string) (string, bool)
 	GetStringSlice(key string) ([]string, bool)
 	GetStringSlice2D(key string) ([][]string, bool)
+	GetStringMap(key string) (map[string]any, bool)
 	IsFilenameBlacklisted(filename string) bool
 	SetVal(key string, value any)
+	// Reload reads the configuration file again, see OnReload
+	Reload() error
+	// ReadBridgeValues returns the values of the configuration as they are
+	// now, BridgeValues those read at startup
+	ReadBridgeValues() (*BridgeValues, error)
 }
 
 type config struct {
@@ -310,6 +667,14 @@ type config struct {
 	v                             *viper.Viper
 	cv                            *BridgeValues
 	MediaDownloadBlackListRegexes *[]*regexp.Regexp
+	// renamed are the accounts renamed by migrateAccounts
+	renamed map[string]string
+
+	// slices2D caches the settings read by GetStringSlice2D, which are looked
+	// up for every message (ReplaceMessages, ExtractNicks...) and costly to
+	// convert from viper
+	slices2DMutex sync.Mutex
+	slices2D      map[string][][]string
 }
 
 // NewConfig instantiates a new configuration based on the specified configuration file path.
@@ -337,20 +702,107 @@ func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 	if mycfg.cv.General.MediaDownloadSize == 0 {
 		mycfg.cv.General.MediaDownloadSize = 1000000
 	}
+	if mycfg.cv.General.MediaSpoolSize == 0 {
+		mycfg.cv.General.MediaSpoolSize = 1000000
+	}
 
 	// Precompile MediaBlackList regexes so we make sure they're correct,
 	// and they don't have to be compiled on every file attachment, because
 	// that's a slow operation.
 	mycfg.compileMediaDownloadBlackListRegexes()
+	mycfg.validatePatterns()
 
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		logger.Println("Config file changed:", e.Name)
+		mycfg.reloaded(e.Name)
 	})
 
 	return mycfg
 }
 
+// Reload reads the configuration file again, eg. on SIGHUP, and applies it
+// like the changes of the file.
+func (c *config) Reload() error {
+	c.Lock()
+	err := c.v.ReadInConfig()
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+	c.logger.Println("Config file reloaded:", c.v.ConfigFileUsed())
+	c.reloaded(c.v.ConfigFileUsed())
+	return nil
+}
+
+// reloaded updates what is derived from the configuration once it was read
+// again, and runs the reload hooks.
+func (c *config) reloaded(name string) {
+	c.Lock()
+	// the file has the old names again
+	c.renamed, _ = migrateAccounts(c.v)
+	applyMigrations(c.v)
+	c.Unlock()
+
+	// Patterns may have changed, drop the compiled ones
+	ResetRegexCache()
+	c.resetSlices2D()
+	c.Lock()
+	c.compileMediaDownloadBlackListRegexes()
+	c.Unlock()
+	c.validatePatterns()
+	runReloadHooks(name)
+}
+
+// ReadBridgeValues reads the values of the configuration again, eg. the
+// gateways after a reload.
+func (c *config) ReadBridgeValues() (*BridgeValues, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	cv := &BridgeValues{}
+	if err := c.v.Unmarshal(cv); err != nil {
+		return nil, err
+	}
+	cv.renameAccounts(c.renamed)
+	return cv, nil
+}
+
+type reloadHook struct {
+	id int
+	fn func(name string)
+}
+
+var (
+	reloadHooksMutex sync.Mutex
+	reloadHooks      []reloadHook
+	reloadHooksID    int
+)
+
+// OnReload registers fn to be called with the name of the configuration file
+// after it changed and was reloaded. The returned function unregisters fn.
+func OnReload(fn func(name string)) func() {
+	reloadHooksMutex.Lock()
+	defer reloadHooksMutex.Unlock()
+	reloadHooksID++
+	id := reloadHooksID
+	reloadHooks = append(reloadHooks, reloadHook{id: id, fn: fn})
+	return func() {
+		reloadHooksMutex.Lock()
+		defer reloadHooksMutex.Unlock()
+		reloadHooks = slices.DeleteFunc(reloadHooks, func(hook reloadHook) bool { return hook.id == id })
+	}
+}
+
+func runReloadHooks(name string) {
+	reloadHooksMutex.Lock()
+	hooks := slices.Clone(reloadHooks)
+	reloadHooksMutex.Unlock()
+	for _, hook := range hooks {
+		hook.fn(name)
+	}
+}
+
 // NewConfigFromString instantiates a new configuration based on the specified string.
 func NewConfigFromString(rootLogger *logrus.Logger, input []byte) Config {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "config"})
@@ -422,26 +874,57 @@ func (c *config) GetStringSlice(key string) ([]string, bool) {
 func (c *config) GetStringSlice2D(key string) ([][]string, bool) {
 	defer c.handlePanic()
 
-	var result [][]string
+	c.slices2DMutex.Lock()
+	result, ok := c.slices2D[key]
+	c.slices2DMutex.Unlock()
+	if ok {
+		return result, result != nil
+	}
 
 	c.RLock()
 	res, ok := c.v.Get(key).([]interface{})
-	if !ok {
-		c.RUnlock()
-
-		return nil, false
-	}
-	for _, entry := range res {
-		result2 := []string{}
-		for _, entry2 := range entry.([]interface{}) {
-			result2 = append(result2, entry2.(string))
+	if ok {
+		for _, entry := range res {
+			result2 := []string{}
+			for _, entry2 := range entry.([]interface{}) {
+				result2 = append(result2, entry2.(string))
+			}
+			result = append(result, result2)
 		}
-		result = append(result, result2)
+		if result == nil {
+			result = [][]string{}
+		}
+	}
+	c.RUnlock()
+
+	c.slices2DMutex.Lock()
+	if c.slices2D == nil {
+		c.slices2D = make(map[string][][]string)
 	}
+	// the unset keys are cached too, as nil
+	c.slices2D[key] = result
+	c.slices2DMutex.Unlock()
+
+	return result, ok
+}
+
+// resetSlices2D drops the settings cached by GetStringSlice2D, when they may
+// have changed.
+func (c *config) resetSlices2D() {
+	c.slices2DMutex.Lock()
+	c.slices2D = nil
+	c.slices2DMutex.Unlock()
+}
+
+func (c *config) GetStringMap(key string) (map[string]any, bool) {
+	defer c.handlePanic()
 
+	c.RLock()
+	mykey := c.v.GetStringMap(key)
+	isset := c.v.IsSet(key)
 	c.RUnlock()
 
-	return result, true
+	return mykey, isset
 }
 
 func (c *config) SetVal(key string, value any) {
@@ -450,6 +933,7 @@ func (c *config) SetVal(key string, value any) {
 	c.Lock()
 	c.v.Set(key, value)
 	c.Unlock()
+	c.resetSlices2D()
 }
 
 // IsFilenameBlackListed checks if a given file name matches the
@@ -530,6 +1014,13 @@ func (c *TestConfig) GetStringSlice2D(key string) ([][]string, bool) {
 	return c.Config.GetStringSlice2D(key)
 }
 
+func (c *TestConfig) GetStringMap(key string) (map[string]any, bool) {
+	if val, ok := c.Overrides[key]; ok {
+		return val.(map[string]any), true
+	}
+	return c.Config.GetStringMap(key)
+}
+
 func (c *config) compileMediaDownloadBlackListRegexes() {
 	regexes := []*regexp.Regexp{}
 
@@ -539,7 +1030,7 @@ func (c *config) compileMediaDownloadBlackListRegexes() {
 	for _, regex := range c.v.GetStringSlice("general.MediaDownloadBlackList") {
 		c.logger.Debugf("Found blacklist regex %s", regex)
 
-		re, err := regexp.Compile(regex)
+		re, err := CompileRegex(regex)
 		if err != nil {
 			c.logger.Errorf("incorrect regexp %s for MediaDownloadBlackList", regex)
 			continue
@@ -552,6 +1043,13 @@ func (c *config) compileMediaDownloadBlackListRegexes() {
 	c.logger.Debug("Successfully applied new `MediaDownloadBlackList` regexes")
 }
 
+// validatePatterns logs every invalid regex of the configuration once.
+func (c *config) validatePatterns() {
+	for _, err := range ValidatePatterns(c) {
+		c.logger.Error(err)
+	}
+}
+
 // detectConfigType detects JSON and YAML formats, defaults to TOML.
 func detectConfigType(cfgfile string) string {
 	fileExt := filepath.Ext(cfgfile)
@@ -577,6 +1075,9 @@ func newConfigFromString(logger *logrus.Entry, input []byte, cfgtype string) *co
 	viper.SetDefault("General.RemoteNickFormat", "[{PROTOCOL}] <{NICK}> ") // fixes #162
 	viper.SetDefault("General.Charset", "utf-8")                           // fixes #120 (it's irc-only, but shouldn't hurt to put it here)
 	viper.SetDefault("General.MessageSplit", true)                         // fixes #190 (irc-only, but should be fine here.  Override it to prefer the girc split function)
+	viper.SetDefault("General.SendFailureThreshold", 5)
+	viper.SetDefault("General.BotTag", "[bot] ")
+	viper.SetDefault("General.EphemeralTag", "[disappearing] ")
 	viper.SetEnvPrefix("matterbridge")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AutomaticEnv()
@@ -586,14 +1087,21 @@ func newConfigFromString(logger *logrus.Entry, input []byte, cfgtype string) *co
 		logger.Fatalf("Failed to parse the configuration: %s", err)
 	}
 
+	renamed, warnings := migrateAccounts(viper.GetViper())
+	for _, warning := range append(warnings, applyMigrations(viper.GetViper())...) {
+		logger.Warn(warning)
+	}
+
 	cfg := &BridgeValues{}
 	err = viper.Unmarshal(cfg)
 	if err != nil {
 		logger.Fatalf("Failed to load the configuration: %s", err)
 	}
+	cfg.renameAccounts(renamed)
 	return &config{
-		logger: logger,
-		v:      viper.GetViper(),
-		cv:     cfg,
+		logger:  logger,
+		v:       viper.GetViper(),
+		cv:      cfg,
+		renamed: renamed,
 	}
 }
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnReload(t *testing.T) {
+	var calls []string
+	unregisterA := OnReload(func(name string) { calls = append(calls, "a "+name) })
+	unregisterB := OnReload(func(name string) { calls = append(calls, "b "+name) })
+	defer unregisterB()
+
+	runReloadHooks("matterbridge.toml")
+	unregisterA()
+	// unregistering twice is harmless
+	unregisterA()
+	runReloadHooks("matterbridge.toml")
+	assert.Equal(t, []string{"a matterbridge.toml", "b matterbridge.toml", "b matterbridge.toml"}, calls)
+}
@@ -0,0 +1,383 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// migration describes a renamed or obsolete configuration option.
+//
+// Keys are lowercased viper keys, "*" matches any account name, eg.
+// "xmpp.*.notls" matches NoTLS in every [xmpp.xxx] section.
+type migration struct {
+	from string
+	to   string // empty when the option was removed without replacement
+	note string
+	// manual options can't be mapped safely, they are left as is for the
+	// bridge to refuse them and only reported
+	manual bool
+}
+
+var migrations = []migration{
+	{from: "mattermost.*.bindaddress", to: "mattermost.*.webhookbindaddress"},
+	{from: "mattermost.*.url", to: "mattermost.*.webhookurl"},
+	{from: "slack.*.bindaddress", to: "slack.*.webhookbindaddress"},
+	{from: "slack.*.url", to: "slack.*.webhookurl"},
+	{from: "xmpp.*.notls", manual: true, note: "set NoStartTLS=true to connect without TLS on purpose, or remove it"},
+	{from: "general.tengomodifymessage", to: "tengo.inmessage"},
+	{from: "tengo.message", to: "tengo.inmessage"},
+}
+
+// protocolRenames are the protocols folded into another bridge, their
+// accounts are moved to it: [slack-legacy.team] becomes [slack.team].
+var protocolRenames = []migration{
+	{from: "slack-legacy", to: "slack", note: "the slack bridge handles the legacy tokens"},
+}
+
+// match returns the new key for key if the migration applies to it.
+func (m migration) match(key string) (string, bool) {
+	from := strings.Split(m.from, ".")
+	parts := strings.Split(key, ".")
+	if len(from) != len(parts) {
+		return "", false
+	}
+
+	account := ""
+	for i := range from {
+		if from[i] == "*" {
+			account = parts[i]
+			continue
+		}
+		if from[i] != parts[i] {
+			return "", false
+		}
+	}
+
+	return strings.Replace(m.to, "*", account, 1), true
+}
+
+// findMigration returns the migration applying to the lowercased key, if any.
+func findMigration(key string) (migration, string, bool) {
+	for _, m := range migrations {
+		if to, ok := m.match(key); ok {
+			return m, to, true
+		}
+	}
+
+	return migration{}, "", false
+}
+
+// renameAccount returns the new name of account, or of a key of its section,
+// if its protocol was folded into another bridge.
+func renameAccount(account string) (migration, string, bool) {
+	protocol, name, ok := strings.Cut(account, ".")
+	if !ok {
+		return migration{}, "", false
+	}
+	for _, m := range protocolRenames {
+		if strings.ToLower(protocol) == m.from {
+			return m, m.to + "." + name, true
+		}
+	}
+
+	return migration{}, "", false
+}
+
+// accountOf returns the lowercased account of the key of an account section,
+// eg. "slack.team" for "slack.team.token".
+func accountOf(key string) string {
+	parts := strings.SplitN(strings.ToLower(key), ".", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+
+	return parts[0] + "." + parts[1]
+}
+
+func (m migration) describe(key string, to string) string {
+	msg := fmt.Sprintf("%s is deprecated", key)
+	switch {
+	case m.manual:
+		msg += " and must be changed by hand"
+	case to != "":
+		msg += fmt.Sprintf(", use %s instead", to)
+	default:
+		msg += " and ignored"
+	}
+	if m.note != "" {
+		msg += " (" + m.note + ")"
+	}
+
+	return msg
+}
+
+// applyMigrations maps deprecated options found in the loaded configuration to
+// their current equivalent, unless that one is set too, and returns a warning
+// for each of them.
+func applyMigrations(v viperSetter) []string {
+	var warnings []string
+
+	for _, key := range v.AllKeys() {
+		m, to, ok := findMigration(key)
+		if !ok {
+			continue
+		}
+		if m.manual {
+			warnings = append(warnings, m.describe(key, to)+".")
+			continue
+		}
+
+		warnings = append(warnings, m.describe(key, to)+". Run `matterbridge migrate-config` to update your configuration.")
+
+		val := v.Get(key)
+		if to != "" && !v.IsSet(to) {
+			v.Set(to, val)
+		}
+		// Override the old value with its zero value, so that code checking for
+		// it does not trigger anymore
+		if val != nil {
+			v.Set(key, reflect.Zero(reflect.TypeOf(val)).Interface())
+		}
+	}
+
+	return warnings
+}
+
+// migrateAccounts moves the accounts of the protocols folded into another
+// bridge, unless an account of the same name exists there. It returns the
+// moved accounts by their old name, and a warning for each of them.
+func migrateAccounts(v viperSetter) (map[string]string, []string) {
+	options := make(map[string][]string) // account -> keys of its section
+	for _, key := range v.AllKeys() {
+		if account := accountOf(key); account != "" && strings.Count(key, ".") >= 2 {
+			options[account] = append(options[account], key[len(account)+1:])
+		}
+	}
+	accounts := make([]string, 0, len(options))
+	for account := range options {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	renamed := make(map[string]string)
+	var warnings []string
+	for _, account := range accounts {
+		m, to, ok := renameAccount(account)
+		if !ok {
+			continue
+		}
+		if _, exists := options[to]; exists {
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated and can't be moved to %s, which already exists. Rename one of them.", account, to))
+			continue
+		}
+		for _, option := range options[account] {
+			v.Set(to+"."+option, v.Get(account+"."+option))
+		}
+		renamed[account] = to
+		warnings = append(warnings, m.describe(account, to)+". Run `matterbridge migrate-config` to update your configuration.")
+	}
+
+	return renamed, warnings
+}
+
+// renameAccounts points the gateways, samechannelgateways and highlights to
+// the accounts moved by migrateAccounts.
+func (bv *BridgeValues) renameAccounts(renamed map[string]string) {
+	if len(renamed) == 0 {
+		return
+	}
+	rename := func(account string) string {
+		if _, ok := renamed[strings.ToLower(account)]; !ok {
+			return account
+		}
+		_, to, _ := renameAccount(account)
+		return to
+	}
+
+	for i := range bv.Gateway {
+		gw := &bv.Gateway[i]
+		for _, bridges := range [][]Bridge{gw.In, gw.Out, gw.InOut} {
+			for j := range bridges {
+				bridges[j].Account = rename(bridges[j].Account)
+			}
+		}
+	}
+	for i := range bv.SameChannelGateway {
+		accounts := bv.SameChannelGateway[i].Accounts
+		for j := range accounts {
+			accounts[j] = rename(accounts[j])
+		}
+	}
+	for i := range bv.Highlight {
+		bv.Highlight[i].Account = rename(bv.Highlight[i].Account)
+	}
+}
+
+// viperSetter is the subset of *viper.Viper used by applyMigrations.
+type viperSetter interface {
+	AllKeys() []string
+	IsSet(key string) bool
+	Get(key string) interface{}
+	Set(key string, value interface{})
+}
+
+var (
+	sectionRE = regexp.MustCompile(`^\s*\[+\s*([^\[\]]+?)\s*\]+\s*(#.*)?$`)
+	optionRE  = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)(\s*=.*)$`)
+	quotedRE  = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+)
+
+// MigrateTOML rewrites a TOML configuration so that deprecated options use their
+// current name. Comments and layout are kept; options moving to another section
+// are appended to it (and the section created if needed). The accounts of the
+// protocols folded into another bridge are moved to it, with the references to
+// them.
+//
+// It returns the new content and a description of every change.
+func MigrateTOML(input []byte) ([]byte, []string) {
+	var (
+		out       []string
+		changes   []string
+		section   string
+		headers   = make(map[string]int) // section name -> index of its header in out
+		additions = make(map[string][]string)
+		order     []string
+	)
+
+	// the accounts which can be moved, when no account of the same name
+	// exists already
+	sections := make(map[string]bool)
+	for _, line := range strings.Split(string(input), "\n") {
+		if match := sectionRE.FindStringSubmatch(line); match != nil {
+			sections[accountOf(strings.ReplaceAll(match[1], `"`, ""))] = true
+		}
+	}
+	moved := make(map[string]bool)
+	for account := range sections {
+		if _, to, ok := renameAccount(account); ok && !sections[to] {
+			moved[account] = true
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := sectionRE.FindStringSubmatch(line); match != nil {
+			section = strings.ToLower(strings.ReplaceAll(match[1], `"`, ""))
+			if m, to, ok := renameAccount(strings.ReplaceAll(match[1], `"`, "")); ok && moved[accountOf(section)] {
+				if accountOf(section) == section {
+					changes = append(changes, m.describe(section, strings.ToLower(to)))
+				}
+				line = strings.Replace(line, match[1], to, 1)
+				section = strings.ToLower(to)
+			}
+			if _, ok := headers[section]; !ok {
+				headers[section] = len(out)
+			}
+			out = append(out, line)
+			continue
+		}
+
+		match := optionRE.FindStringSubmatch(line)
+		if match == nil || section == "" {
+			out = append(out, line)
+			continue
+		}
+
+		if name := strings.ToLower(match[2]); name == "account" || name == "accounts" {
+			if refs := moveReferences(match[3], moved); refs != match[3] {
+				changes = append(changes, fmt.Sprintf("%s.%s now references the moved accounts", section, name))
+				line = match[1] + match[2] + refs
+			}
+		}
+
+		key := section + "." + strings.ToLower(match[2])
+		m, to, ok := findMigration(key)
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+
+		changes = append(changes, m.describe(key, to))
+
+		if m.manual {
+			out = append(out, line)
+			continue
+		}
+		if to == "" {
+			out = append(out, match[1]+"# "+strings.TrimLeft(line, " \t")+" # removed, no longer supported")
+			continue
+		}
+
+		toSection := to[:strings.LastIndex(to, ".")]
+		toName := newOptionName(to[strings.LastIndex(to, ".")+1:])
+		newLine := match[1] + toName + match[3]
+
+		if toSection == section {
+			out = append(out, newLine)
+			continue
+		}
+
+		if _, ok := additions[toSection]; !ok {
+			order = append(order, toSection)
+		}
+		additions[toSection] = append(additions[toSection], strings.TrimLeft(newLine, " \t"))
+	}
+
+	// Insert moved options right after their section header, starting with the
+	// last header so recorded header positions stay valid.
+	existing := []string{}
+	for _, toSection := range order {
+		if _, ok := headers[toSection]; ok {
+			existing = append(existing, toSection)
+		}
+	}
+	sort.Slice(existing, func(i, j int) bool { return headers[existing[i]] > headers[existing[j]] })
+	for _, toSection := range existing {
+		idx := headers[toSection]
+		rest := append([]string{}, out[idx+1:]...)
+		out = append(append(out[:idx+1], additions[toSection]...), rest...)
+	}
+	for _, toSection := range order {
+		if _, ok := headers[toSection]; ok {
+			continue
+		}
+		out = append(out, "", "["+toSection+"]")
+		out = append(out, additions[toSection]...)
+	}
+
+	return []byte(strings.Join(out, "\n") + "\n"), changes
+}
+
+// moveReferences renames the moved accounts quoted in the value of an option.
+func moveReferences(value string, moved map[string]bool) string {
+	return quotedRE.ReplaceAllStringFunc(value, func(quoted string) string {
+		account := quoted[1 : len(quoted)-1]
+		if !moved[strings.ToLower(account)] {
+			return quoted
+		}
+		_, to, _ := renameAccount(account)
+		return quoted[:1] + to + quoted[len(quoted)-1:]
+	})
+}
+
+// newOptionName restores the usual casing of a lowercased option name.
+func newOptionName(name string) string {
+	names := map[string]string{
+		"webhookbindaddress": "WebhookBindAddress",
+		"webhookurl":         "WebhookURL",
+		"inmessage":          "InMessage",
+	}
+	if n, ok := names[name]; ok {
+		return n
+	}
+
+	return name
+}
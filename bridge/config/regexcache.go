@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// regexCache holds compiled config-driven patterns (IgnoreNicks, ReplaceMessages, ...)
+// keyed by the pattern string, so they are compiled once instead of on every message.
+//
+// Compilation errors are cached as well: they are reported once by ValidatePatterns
+// when the configuration is (re)loaded, and the pattern is then simply skipped.
+type regexCache struct {
+	sync.RWMutex
+
+	compiled map[string]*regexp.Regexp
+	errors   map[string]error
+}
+
+var patterns = &regexCache{
+	compiled: make(map[string]*regexp.Regexp),
+	errors:   make(map[string]error),
+}
+
+// CompileRegex returns the compiled regular expression for pattern, compiling
+// and caching it on first use.
+func CompileRegex(pattern string) (*regexp.Regexp, error) {
+	patterns.RLock()
+	re, ok := patterns.compiled[pattern]
+	err := patterns.errors[pattern]
+	patterns.RUnlock()
+
+	if ok || err != nil {
+		return re, err
+	}
+
+	re, err = regexp.Compile(pattern)
+
+	patterns.Lock()
+	if err != nil {
+		patterns.errors[pattern] = err
+	} else {
+		patterns.compiled[pattern] = re
+	}
+	patterns.Unlock()
+
+	return re, err
+}
+
+// ResetRegexCache drops every cached pattern. It is called when the
+// configuration file changes.
+func ResetRegexCache() {
+	patterns.Lock()
+	patterns.compiled = make(map[string]*regexp.Regexp)
+	patterns.errors = make(map[string]error)
+	patterns.Unlock()
+}
+
+// Settings containing space-separated regexes, regex lists, and [regex, replacement] pairs.
+var (
+	fieldsPatternKeys = []string{"ignorenicks", "ignoremessages"}
+	listPatternKeys   = []string{"mediadownloadblacklist"}
+	pairPatternKeys   = []string{"replacemessages", "replacenicks", "extractnicks"}
+)
+
+// ValidatePatterns compiles every regex found in the configuration and returns
+// an error for each one that is invalid, so they can be reported at startup
+// rather than when a message comes in.
+func ValidatePatterns(cfg Config) []error {
+	var errs []error
+
+	check := func(key string, pattern string) {
+		if pattern == "" {
+			return
+		}
+		if _, err := CompileRegex(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid regexp %q in %s: %w", pattern, key, err))
+		}
+	}
+
+	for _, key := range cfg.Viper().AllKeys() {
+		setting := key[strings.LastIndex(key, ".")+1:]
+
+		switch {
+		case hasKey(fieldsPatternKeys, setting):
+			val, _ := cfg.GetString(key)
+			for _, pattern := range strings.Fields(val) {
+				check(key, pattern)
+			}
+		case hasKey(listPatternKeys, setting):
+			val, _ := cfg.GetStringSlice(key)
+			for _, pattern := range val {
+				check(key, pattern)
+			}
+		case hasKey(pairPatternKeys, setting):
+			val, _ := cfg.GetStringSlice2D(key)
+			for _, pair := range val {
+				if len(pair) == 0 {
+					continue
+				}
+				check(key, pair[0])
+				// ExtractNicks also has a regex as second element
+				if setting == "extractnicks" && len(pair) > 1 {
+					check(key, pair[1])
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var patternConfig = []byte(`
+[irc.test]
+IgnoreNicks="bot1 (bot2"
+ReplaceMessages=[ ["cat","dog"], ["[a-","b"] ]
+ExtractNicks=[ ["bridgebot","<(.*?)>\\s+"] ]
+`)
+
+func TestCompileRegexCache(t *testing.T) {
+	ResetRegexCache()
+
+	re1, err := CompileRegex("^abc$")
+	assert.NoError(t, err)
+	re2, err := CompileRegex("^abc$")
+	assert.NoError(t, err)
+	assert.Same(t, re1, re2)
+
+	_, err = CompileRegex("(abc")
+	assert.Error(t, err)
+	_, err = CompileRegex("(abc")
+	assert.Error(t, err)
+
+	ResetRegexCache()
+	re3, err := CompileRegex("^abc$")
+	assert.NoError(t, err)
+	assert.NotSame(t, re1, re3)
+}
+
+func TestValidatePatterns(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := NewConfigFromString(logger, patternConfig)
+
+	errs := ValidatePatterns(cfg)
+	assert.Len(t, errs, 2)
+}
+
+func TestGetStringSlice2DCache(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := NewConfigFromString(logger, patternConfig)
+
+	val, ok := cfg.GetStringSlice2D("irc.test.ReplaceMessages")
+	assert.True(t, ok)
+	assert.Equal(t, [][]string{{"cat", "dog"}, {"[a-", "b"}}, val)
+	_, ok = cfg.GetStringSlice2D("irc.test.ReplaceNicks")
+	assert.False(t, ok)
+
+	// the cached settings are dropped when they change
+	cfg.SetVal("irc.test.ReplaceNicks", []interface{}{[]interface{}{"a", "b"}})
+	val, ok = cfg.GetStringSlice2D("irc.test.ReplaceNicks")
+	assert.True(t, ok)
+	assert.Equal(t, [][]string{{"a", "b"}}, val)
+}
@@ -0,0 +1,184 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// IPFamily values, the family of the addresses the bridges connect to. The
+// default connects to the addresses in the order of the system, racing IPv6
+// and IPv4 ("happy eyeballs").
+const (
+	IPFamilyIPv4       = "ipv4"
+	IPFamilyIPv6       = "ipv6"
+	IPFamilyPreferIPv4 = "prefer-ipv4"
+	IPFamilyPreferIPv6 = "prefer-ipv6"
+)
+
+// defaultFallbackDelay is the delay after which the other family is tried,
+// unless HappyEyeballsDelay is set. It is the default of net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// Dialer connects to the addresses of the family set with IPFamily, forced or
+// preferred. It implements the dialer interfaces of the libraries, eg.
+// girc.Dialer.
+type Dialer struct {
+	net.Dialer
+	// Family is one of the IPFamily values, empty for the order of the system
+	Family string
+}
+
+// Dialer returns the dialer of the connections of the bridge, with the
+// IPFamily and HappyEyeballsDelay of its account.
+func (b *Bridge) Dialer(timeout time.Duration) *Dialer {
+	d := &Dialer{Dialer: net.Dialer{Timeout: timeout}}
+	switch family := strings.ToLower(b.GetString("IPFamily")); family {
+	case "", IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, IPFamilyPreferIPv6:
+		d.Family = family
+	default:
+		b.Log.Warnf("Unknown IPFamily %q, connecting to the addresses in the order of the system", family)
+	}
+	if delay := b.GetInt("HappyEyeballsDelay"); delay != 0 {
+		// negative disables happy eyeballs, like in net.Dialer
+		d.FallbackDelay = time.Duration(delay) * time.Millisecond
+	}
+	return d
+}
+
+// customDialer returns true if the account changes how its bridge connects.
+func (b *Bridge) customDialer() bool {
+	return b.GetString("IPFamily") != "" || b.GetInt("HappyEyeballsDelay") != 0
+}
+
+// networks returns the network of the addresses to try first, and of those
+// to try next, empty when there are none. network is "tcp", "udp" or "ip".
+func (d *Dialer) networks(network string) (string, string) {
+	if network != "tcp" && network != "udp" && network != "ip" {
+		return network, ""
+	}
+	switch d.Family {
+	case IPFamilyIPv4:
+		return network + "4", ""
+	case IPFamilyIPv6:
+		return network + "6", ""
+	case IPFamilyPreferIPv4:
+		return network + "4", network + "6"
+	case IPFamilyPreferIPv6:
+		return network + "6", network + "4"
+	}
+	return network, ""
+}
+
+// Dial connects to address, see DialContext.
+func (d *Dialer) Dial(network string, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+}
+
+// DialContext connects to address with the addresses of the family of d. With
+// a preferred family, the other one is tried when the preferred one didn't
+// connect within the FallbackDelay, or failed.
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	primary, fallback := d.networks(network)
+	if fallback == "" {
+		return d.Dialer.DialContext(ctx, primary, address)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2)
+	dial := func(network string, primary bool) {
+		conn, err := d.Dialer.DialContext(ctx, network, address)
+		results <- dialResult{conn: conn, err: err, primary: primary}
+	}
+
+	go dial(primary, true)
+	pending, fallbackStarted := 1, false
+	var timer <-chan time.Time
+	if delay := d.FallbackDelay; delay >= 0 {
+		if delay == 0 {
+			delay = defaultFallbackDelay
+		}
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+	startFallback := func() {
+		if !fallbackStarted {
+			fallbackStarted = true
+			pending++
+			go dial(fallback, false)
+		}
+	}
+
+	var primaryErr, fallbackErr error
+	for pending > 0 {
+		select {
+		case <-timer:
+			timer = nil
+			startFallback()
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// the other connection is canceled, or closed if it
+					// connected meanwhile
+					go func() {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			} else {
+				fallbackErr = res.err
+			}
+			startFallback()
+		}
+	}
+	if primaryErr != nil {
+		return nil, primaryErr
+	}
+	return nil, fallbackErr
+}
+
+// ResolveAddress returns address ("host:port") with the host resolved to an IP
+// address of the family of d, for the libraries which can't use d to connect.
+// Unlike DialContext, the other family isn't tried when the connection to the
+// preferred one fails.
+func (d *Dialer) ResolveAddress(ctx context.Context, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if d.Family == "" || net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	primary, fallback := d.networks("ip")
+	for _, network := range []string{primary, fallback} {
+		if network == "" {
+			continue
+		}
+		ips, err := resolver.LookupIP(ctx, network, host)
+		if err == nil && len(ips) > 0 {
+			return net.JoinHostPort(ips[0].String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("no %s address for %s", d.Family, host)
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialerNetworks(t *testing.T) {
+	networks := func(family string, network string) []string {
+		primary, fallback := (&Dialer{Family: family}).networks(network)
+		return []string{primary, fallback}
+	}
+	assert.Equal(t, []string{"tcp", ""}, networks("", "tcp"))
+	assert.Equal(t, []string{"tcp4", ""}, networks(IPFamilyIPv4, "tcp"))
+	assert.Equal(t, []string{"udp6", ""}, networks(IPFamilyIPv6, "udp"))
+	assert.Equal(t, []string{"tcp6", "tcp4"}, networks(IPFamilyPreferIPv6, "tcp"))
+	assert.Equal(t, []string{"ip4", "ip6"}, networks(IPFamilyPreferIPv4, "ip"))
+	assert.Equal(t, []string{"unix", ""}, networks(IPFamilyIPv4, "unix"))
+}
+
+func TestDialerDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	address := ln.Addr().String()
+
+	// the IPv4 address isn't reachable over IPv6
+	_, err = (&Dialer{Family: IPFamilyIPv6}).Dial("tcp", address)
+	require.Error(t, err)
+
+	// but IPv4 is tried once IPv6 failed, with and without happy eyeballs
+	for _, d := range []*Dialer{{Family: IPFamilyPreferIPv6}, {Family: IPFamilyPreferIPv6, Dialer: net.Dialer{FallbackDelay: -1}}} {
+		conn, err := d.Dial("tcp", address)
+		require.NoError(t, err)
+		assert.Equal(t, address, conn.RemoteAddr().String())
+		conn.Close()
+	}
+
+	resolved, err := (&Dialer{Family: IPFamilyIPv6}).ResolveAddress(context.Background(), address)
+	require.NoError(t, err)
+	assert.Equal(t, address, resolved)
+	_, err = (&Dialer{Family: IPFamilyIPv4}).ResolveAddress(context.Background(), "no-port")
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package bdiscord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// commandName is the slash command under which the control commands are
+// registered as subcommands, eg. "/bridge status".
+const commandName = "bridge"
+
+// RegisterCommands declares the control commands as subcommands of the
+// "/bridge" slash command of the guild.
+func (b *Bdiscord) RegisterCommands(commands []bridge.CommandInfo) error {
+	options := make([]*discordgo.ApplicationCommandOption, 0, len(commands))
+	for _, cmd := range commands {
+		option := &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        cmd.Name,
+			Description: cmd.Help,
+		}
+		if cmd.Usage != "" {
+			option.Options = []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "args",
+				Description: cmd.Usage,
+			}}
+		}
+		options = append(options, option)
+	}
+
+	_, err := b.c.ApplicationCommandBulkOverwrite(b.userID, b.guildID, []*discordgo.ApplicationCommand{{
+		Name:        commandName,
+		Description: "Control the bridge",
+		Options:     options,
+	}})
+	return err
+}
+
+// interactionCreate sends the "/bridge" slash commands of the guild to the
+// gateway as control commands.
+func (b *Bdiscord) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || i.GuildID != b.guildID || i.Member == nil {
+		return
+	}
+	data := i.ApplicationCommandData()
+	if data.Name != commandName || len(data.Options) == 0 {
+		return
+	}
+
+	text := data.Options[0].Name
+	for _, option := range data.Options[0].Options {
+		if option.Type == discordgo.ApplicationCommandOptionString {
+			text += " " + option.StringValue()
+		}
+	}
+
+	// the reply is only shown to the sender of the command
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		b.Log.Errorf("Acknowledging command %q failed: %s", text, err)
+		return
+	}
+
+	b.interactionsMutex.Lock()
+	b.interactions[i.ID] = i.Interaction
+	b.interactionsMutex.Unlock()
+
+	rmsg := config.Message{
+		Event:    config.EventCommand,
+		Text:     strings.TrimSpace(text),
+		Account:  b.Account,
+		Channel:  b.getChannelName(i.ChannelID),
+		ID:       i.ID,
+		UserID:   i.Member.User.ID,
+		Username: b.getNick(i.Member.User, i.GuildID),
+	}
+	b.Log.Debugf("<= Sending command from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Remote <- rmsg
+}
+
+// ReplyCommand replies to the slash command cmd.
+func (b *Bdiscord) ReplyCommand(cmd config.Message, reply string) error {
+	b.interactionsMutex.Lock()
+	interaction, ok := b.interactions[cmd.ID]
+	delete(b.interactions, cmd.ID)
+	b.interactionsMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown interaction %s", cmd.ID)
+	}
+
+	_, err := b.c.InteractionResponseEdit(interaction, &discordgo.WebhookEdit{Content: &reply})
+	return err
+}
@@ -1,7 +1,8 @@
 package bdiscord
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -16,6 +17,8 @@ import (
 
 const (
 	MessageLength = 1950
+	// MaxNickLength is the maximum length of the names of webhook messages
+	MaxNickLength = 80
 	cFileUpload   = "file_upload"
 )
 
@@ -36,6 +39,14 @@ type Bdiscord struct {
 	userMemberMap map[string]*discordgo.Member
 	nickMemberMap map[string]*discordgo.Member
 
+	// noMessageContent is set when the message content intent isn't granted
+	intentsMutex     sync.Mutex
+	noMessageContent bool
+
+	// slash commands waiting for their reply, by interaction ID
+	interactionsMutex sync.Mutex
+	interactions      map[string]*discordgo.Interaction
+
 	// Webhook specific logic
 	useAutoWebhooks bool
 	transmitter     *transmitter.Transmitter
@@ -56,6 +67,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b.userMemberMap = make(map[string]*discordgo.Member)
 	b.nickMemberMap = make(map[string]*discordgo.Member)
 	b.channelInfoMap = make(map[string]*config.ChannelInfo)
+	b.interactions = make(map[string]*discordgo.Interaction)
 
 	b.useAutoWebhooks = b.GetBool("AutoWebhooks")
 	if b.useAutoWebhooks {
@@ -80,11 +92,9 @@ func (b *Bdiscord) Connect() error {
 	if err != nil {
 		return err
 	}
+	b.c.Client.Transport = b.Budget.Transport(b.c.Client.Transport)
 	b.Log.Info("Connection succeeded")
-	// Add privileged intent for guild member tracking. This is needed to track nicks
-	// for display names and @mention translation
-	b.c.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAllWithoutPrivileged |
-		discordgo.IntentsGuildMembers)
+	b.c.Identify.Intents = b.intents()
 
 	err = b.c.Open()
 	if err != nil {
@@ -216,6 +226,7 @@ func (b *Bdiscord) Connect() error {
 	defer b.membersMutex.Unlock()
 	after := ""
 	for {
+		b.Budget.Wait()
 		members, err := b.c.GuildMembers(b.guildID, after, 1000)
 		if err != nil {
 			b.Log.Error("Error obtaining server members: ", err)
@@ -238,14 +249,21 @@ func (b *Bdiscord) Connect() error {
 		after = members[len(members)-1].User.ID
 	}
 
+	b.auditPermissions()
+
 	b.c.AddHandler(b.messageCreate)
 	b.c.AddHandler(b.messageTyping)
 	b.c.AddHandler(b.messageUpdate)
 	b.c.AddHandler(b.messageDelete)
 	b.c.AddHandler(b.messageDeleteBulk)
+	b.c.AddHandler(b.messageReactionAdd)
+	b.c.AddHandler(b.messageReactionRemove)
 	b.c.AddHandler(b.memberAdd)
 	b.c.AddHandler(b.memberRemove)
 	b.c.AddHandler(b.memberUpdate)
+	if b.GetBool("Commands") {
+		b.c.AddHandler(b.interactionCreate)
+	}
 	if b.GetInt("debuglevel") == 1 {
 		b.c.AddHandler(b.messageEvent)
 	}
@@ -257,6 +275,22 @@ func (b *Bdiscord) Disconnect() error {
 	return b.c.Close()
 }
 
+// Alive implements bridge.Heartbeater.
+func (b *Bdiscord) Alive() error {
+	b.c.RLock()
+	defer b.c.RUnlock()
+	if !b.c.DataReady {
+		return errors.New("gateway connection lost")
+	}
+	return nil
+}
+
+// Ping implements bridge.Heartbeater.
+func (b *Bdiscord) Ping(ctx context.Context) error {
+	_, err := b.c.User("@me", discordgo.WithContext(ctx))
+	return err
+}
+
 func (b *Bdiscord) JoinChannel(channel config.ChannelInfo) error {
 	b.channelsMutex.Lock()
 	defer b.channelsMutex.Unlock()
@@ -265,6 +299,21 @@ func (b *Bdiscord) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// ListChannels returns the names of the text channels of the guild, to resolve
+// channel patterns of gateways.
+func (b *Bdiscord) ListChannels() ([]string, error) {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	var names []string
+	for _, channel := range b.channels {
+		if channel.Type == discordgo.ChannelTypeGuildText {
+			names = append(names, channel.Name)
+		}
+	}
+	return names, nil
+}
+
 func (b *Bdiscord) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
@@ -281,6 +330,11 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	// Reaction to a previous message, added by the bot
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, channelID)
+	}
+
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
 		msg.Text = "_" + msg.Text + "_"
@@ -291,9 +345,10 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		msg.ParentID = ""
 	}
 
-	// Use webhook to send the message
+	// Use webhook to send the message, announcements are sent by the bot.
+	// Webhooks can't reply, the replies quote the message they reply to.
 	useWebhooks := b.shouldMessageUseWebhooks(&msg)
-	if useWebhooks && msg.Event != config.EventMsgDelete && msg.ParentID == "" {
+	if useWebhooks && msg.Event != config.EventMsgDelete && msg.Event != config.EventAnnounce {
 		return b.handleEventWebhook(&msg, channelID)
 	}
 
@@ -309,6 +364,10 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 		if msg.ID == "" {
 			return "", nil
 		}
+		// the replies sent to the thread of their parent are deleted there
+		if threadID, ok := b.messageThread(msg.ID); ok {
+			channelID = threadID
+		}
 		err := b.c.ChannelMessageDelete(channelID, msg.ID)
 		return "", err
 	}
@@ -343,6 +402,8 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 		}
 	}
 
+	useEmbeds := b.shouldMessageUseEmbeds(msg)
+
 	// Edit message
 	if msg.ID != "" {
 		// Exploit that a discord message ID is actually just a large number, and we encode a list of IDs by separating them with ";".
@@ -355,7 +416,12 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 			// In case of split-messages where some parts remain the same (i.e. only a typo-fix in a huge message), this causes some noop-updates.
 			// TODO: Optimize away noop-updates of un-edited messages
 			// TODO: Use RemoteNickFormat instead of this broken concatenation
-			_, err := b.c.ChannelMessageEdit(channelID, msgIds[i], msg.Username+msgParts[i])
+			var err error
+			if useEmbeds {
+				_, err = b.c.ChannelMessageEditEmbed(channelID, msgIds[i], messageEmbed(msg, msgParts[i]))
+			} else {
+				_, err = b.c.ChannelMessageEdit(channelID, msgIds[i], msg.Username+msgParts[i])
+			}
 			if err != nil {
 				return "", err
 			}
@@ -363,13 +429,22 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 		return msg.ID, nil
 	}
 
+	allowedMentions := b.getAllowedMentions()
+	if msg.Event == config.EventAnnounce {
+		allowedMentions = b.pingModerators(msg)
+	}
+
 	msgParts := helper.ClipOrSplitMessage(b.replaceUserMentions(msg.Text), MessageLength, b.GetString("MessageClipped"), b.GetInt("MessageSplitMaxCount"))
 	msgIds := []string{}
 
 	for _, msgPart := range msgParts {
 		m := discordgo.MessageSend{
 			Content:         msg.Username + msgPart,
-			AllowedMentions: b.getAllowedMentions(),
+			AllowedMentions: allowedMentions,
+		}
+		if useEmbeds {
+			m.Content = ""
+			m.Embeds = []*discordgo.MessageEmbed{messageEmbed(msg, msgPart)}
 		}
 
 		if msg.ParentValid() {
@@ -396,17 +471,34 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 func (b *Bdiscord) handleUploadFile(msg *config.Message, channelID string) (string, error) {
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
+		caption := msg.Username + fi.Comment
+		if fi.Voice && isVoiceFile(fi.Name) {
+			id, captioned, err := b.sendVoiceMessage(msg, channelID, &fi)
+			if err == nil {
+				b.cache.Add(cFileUpload+fi.NativeID, id)
+				continue
+			}
+			b.Log.WithError(err).Warnf("Sending %s as a voice message failed, uploading it as a file", fi.Name)
+			if captioned {
+				caption = ""
+			}
+		}
+		r, err := fi.Open()
+		if err != nil {
+			return "", fmt.Errorf("file upload failed: %s", err)
+		}
 		file := discordgo.File{
 			Name:        fi.Name,
 			ContentType: "",
-			Reader:      bytes.NewReader(*fi.Data),
+			Reader:      r,
 		}
 		m := discordgo.MessageSend{
-			Content:         msg.Username + fi.Comment,
+			Content:         caption,
 			Files:           []*discordgo.File{&file},
 			AllowedMentions: b.getAllowedMentions(),
 		}
 		res, err := b.c.ChannelMessageSendComplex(channelID, &m)
+		r.Close()
 		if err != nil {
 			return "", fmt.Errorf("file upload failed: %s", err)
 		}
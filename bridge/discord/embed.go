@@ -0,0 +1,78 @@
+package bdiscord
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// shouldMessageUseEmbeds returns true if msg is sent by the bot as an embed,
+// with the Embeds option of its channel in the gateway.
+func (b *Bdiscord) shouldMessageUseEmbeds(msg *config.Message) bool {
+	if msg.Username == "" || msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+	ci, ok := b.channelInfoMap[msg.Channel+b.Account]
+	return ok && ci.Options.Embeds
+}
+
+// messageEmbed returns the embed showing text of the sender of msg, with their
+// avatar and color.
+func messageEmbed(msg *config.Message, text string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    strings.TrimSpace(msg.Username),
+			IconURL: msg.Avatar,
+		},
+		Description: text,
+		Color:       userColor(msg),
+	}
+}
+
+// userColor returns the color of the sender of msg in the embeds, derived from
+// their user ID (or nick when unknown) so that it is the same in every message.
+// The hue varies, the saturation and brightness are fixed to stay readable on
+// the light and dark themes.
+func userColor(msg *config.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(msg.Account + "/"))
+	if msg.UserID != "" {
+		h.Write([]byte(msg.UserID))
+	} else {
+		h.Write([]byte(msg.Username))
+	}
+	return hsvColor(float64(h.Sum32()%360), 0.6, 0.85)
+}
+
+// hsvColor returns the RGB color of hue (in degrees), saturation and value.
+func hsvColor(hue float64, saturation float64, value float64) int {
+	c := value * saturation
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := value - c
+
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g = c, x
+	case hue < 120:
+		r, g = x, c
+	case hue < 180:
+		g, b = c, x
+	case hue < 240:
+		g, b = x, c
+	case hue < 300:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	channel := func(v float64) int {
+		return int(math.Round((v + m) * 255))
+	}
+	return channel(r)<<16 | channel(g)<<8 | channel(b)
+}
@@ -0,0 +1,45 @@
+package bdiscord
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldMessageUseEmbeds(t *testing.T) {
+	b := &Bdiscord{
+		Config: &bridge.Config{Bridge: &bridge.Bridge{Account: "discord.test"}},
+		channelInfoMap: map[string]*config.ChannelInfo{
+			"embeds" + "discord.test": {Name: "embeds", Options: config.ChannelOptions{Embeds: true}},
+			"plain" + "discord.test":  {Name: "plain"},
+		},
+	}
+
+	assert.True(t, b.shouldMessageUseEmbeds(&config.Message{Channel: "embeds", Username: "alice", Text: "hi"}))
+	assert.True(t, b.shouldMessageUseEmbeds(&config.Message{Channel: "embeds", Username: "alice", Event: config.EventUserAction}))
+	assert.False(t, b.shouldMessageUseEmbeds(&config.Message{Channel: "plain", Username: "alice", Text: "hi"}))
+	// announcements and messages without sender stay plain, eg. to ping
+	assert.False(t, b.shouldMessageUseEmbeds(&config.Message{Channel: "embeds", Username: "alice", Event: config.EventAnnounce}))
+	assert.False(t, b.shouldMessageUseEmbeds(&config.Message{Channel: "embeds", Text: "hi"}))
+}
+
+func TestMessageEmbed(t *testing.T) {
+	alice := &config.Message{Account: "irc.libera", UserID: "alice", Username: "[irc] <alice> ", Avatar: "https://example.com/alice.png"}
+	embed := messageEmbed(alice, "hello")
+	assert.Equal(t, "[irc] <alice>", embed.Author.Name)
+	assert.Equal(t, "https://example.com/alice.png", embed.Author.IconURL)
+	assert.Equal(t, "hello", embed.Description)
+
+	// the color follows the user ID, not the nick
+	renamed := &config.Message{Account: "irc.libera", UserID: "alice", Username: "[irc] <alice_away> "}
+	assert.Equal(t, userColor(alice), userColor(renamed))
+	assert.NotEqual(t, userColor(alice), userColor(&config.Message{Account: "irc.libera", UserID: "bob"}))
+}
+
+func TestHSVColor(t *testing.T) {
+	assert.Equal(t, 0xD95757, hsvColor(0, 0.6, 0.85))
+	assert.Equal(t, 0x57D957, hsvColor(120, 0.6, 0.85))
+	assert.Equal(t, 0x5757D9, hsvColor(240, 0.6, 0.85))
+}
@@ -1,12 +1,15 @@
 package bdiscord
 
 import (
+	"encoding/json"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 )
 
 func (b *Bdiscord) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) { //nolint:unparam
@@ -130,6 +133,42 @@ func (b *Bdiscord) handleQuote(s *discordgo.Session, m *discordgo.Message, msg s
 	return format
 }
 
+// handleForward flags a forwarded message with its origin. Forwards are
+// messages referencing another one without being replies, and don't have
+// content of their own, so the content of the original message is used.
+func (b *Bdiscord) handleForward(s *discordgo.Session, m *discordgo.Message, rmsg *config.Message) {
+	ref := m.MessageReference
+	if ref == nil || m.Type != discordgo.MessageTypeDefault || m.Content != "" || len(m.Attachments) > 0 {
+		return
+	}
+	orig, err := s.ChannelMessage(ref.ChannelID, ref.MessageID)
+	if err != nil {
+		b.Log.Errorf("Error getting forwarded message %s:%s: %s", ref.ChannelID, ref.MessageID, err)
+		return
+	}
+
+	fwd := config.Forward{}
+	if orig.Author != nil {
+		fwd.From = orig.Author.Username
+	}
+	if ref.GuildID == b.guildID {
+		b.channelsMutex.RLock()
+		for _, channel := range b.channels {
+			if channel.ID == ref.ChannelID {
+				fwd.Channel = "#" + channel.Name
+			}
+		}
+		b.channelsMutex.RUnlock()
+	}
+	rmsg.SetForward(fwd)
+
+	m.Content = orig.Content
+	m.Mentions = orig.Mentions
+	m.Attachments = orig.Attachments
+	m.StickerItems = orig.StickerItems
+	m.MessageReference = nil
+}
+
 func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) { //nolint:unparam
 	if m.GuildID != b.guildID {
 		b.Log.Debugf("Ignoring messageCreate because it originates from a different guild")
@@ -148,8 +187,19 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 
 	rmsg := config.Message{Account: b.Account, Avatar: "https://cdn.discordapp.com/avatars/" + m.Author.ID + "/" + m.Author.Avatar + ".jpg", UserID: "@" + m.Author.Username, ID: m.ID, Extra: make(map[string][]interface{})} // here we use .jpg over .webp for wider support across bridges and clients in general. discord automatically converts as needed anyhow.
 
+	if !m.Timestamp.IsZero() {
+		rmsg.Timestamp = m.Timestamp
+	}
+
+	// webhook messages have the bot flag too
+	if m.Author.Bot {
+		rmsg.MarkBot()
+	}
+
 	b.Log.Debugf("== Receiving event %#v", m.Message)
 
+	b.handleForward(s, m.Message, &rmsg)
+
 	if m.Content != "" {
 		m.Message.Content = b.replaceChannelMentions(m.Message.Content)
 		rmsg.Text, err = m.ContentWithMoreMentionsReplaced(b.c)
@@ -172,8 +222,9 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 		}
 	}
 
-	// if we have embedded content add it to text
-	if b.GetBool("ShowEmbeds") && m.Message.Embeds != nil {
+	// if we have embedded content add it to text, embeds are all we have
+	// without the message content intent
+	if (b.GetBool("ShowEmbeds") || b.missingMessageContent()) && m.Message.Embeds != nil {
 		for _, embed := range m.Message.Embeds {
 			rmsg.Text += handleEmbed(embed)
 		}
@@ -198,12 +249,15 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	}
 
 	// no empty messages
-	if rmsg.Text == "" && len(m.Attachments) == 0 {
+	if rmsg.Text == "" && len(m.Attachments) == 0 && len(m.StickerItems) == 0 {
+		if b.missingMessageContent() {
+			b.Log.Debugf("Dropping message %s of %s, its content is hidden without the Message Content intent", m.ID, m.Author.Username)
+		}
 		return
 	}
 
 	// if no attachments, send the message as-is
-	if len(m.Attachments) == 0 {
+	if len(m.Attachments) == 0 && len(m.StickerItems) == 0 {
 		b.Log.Debugf("<= Sending message from %s on %s to gateway", m.Author.Username, b.Account)
 		b.Log.Debugf("<= Message is %#v", rmsg)
 
@@ -217,12 +271,31 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	// messages while we download the attachments.
 	go func() {
 		count := 0
+		var metadata map[string]attachmentMetadata
+		if len(m.Attachments) > 0 {
+			metadata = b.attachmentMetadata(m.ChannelID, m.ID)
+		}
 		for _, attach := range m.Attachments {
 			err := b.AddAttachmentFromURL(&rmsg, attach.Filename, attach.ID, "", attach.URL)
 			if err != nil {
 				b.Log.WithError(err).Warnf("Failed to download attachment %s", attach.Filename)
 				continue
 			}
+			meta := metadata[attach.ID]
+			helper.SetAltText(&rmsg, meta.Description)
+			if m.Flags&discordgo.MessageFlagsIsVoiceMessage != 0 {
+				helper.MarkVoiceNote(&rmsg, int(meta.DurationSecs*1000), helper.VoiceWaveform(meta.Waveform, 255))
+			}
+
+			count += 1
+		}
+
+		for _, sticker := range m.StickerItems {
+			err := b.addSticker(&rmsg, sticker)
+			if err != nil {
+				b.Log.WithError(err).Warnf("Failed to download sticker %s", sticker.Name)
+				continue
+			}
 
 			count += 1
 		}
@@ -239,6 +312,53 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	}()
 }
 
+// attachmentMetadata holds what discordgo doesn't decode of an attachment:
+// its description (alt text), and the duration and waveform of voice messages.
+type attachmentMetadata struct {
+	ID           string  `json:"id"`
+	Description  string  `json:"description"`
+	DurationSecs float64 `json:"duration_secs"`
+	// Waveform is base64 encoded in the JSON, one 0-255 sample per byte
+	Waveform []byte `json:"waveform"`
+}
+
+// attachmentMetadata returns the metadata of the attachments of a message by
+// attachment ID. The message is fetched again, unless the API budget is tight.
+func (b *Bdiscord) attachmentMetadata(channelID, messageID string) map[string]attachmentMetadata {
+	if b.Budget.Tight() {
+		return nil
+	}
+	response, err := b.c.RequestWithBucketID(http.MethodGet, discordgo.EndpointChannelMessage(channelID, messageID), nil, discordgo.EndpointChannelMessage(channelID, ""))
+	if err != nil {
+		b.Log.Debugf("Error getting the attachment metadata of %s: %s", messageID, err)
+		return nil
+	}
+
+	metadata, err := parseAttachmentMetadata(response)
+	if err != nil {
+		b.Log.Debugf("Error decoding the attachment metadata of %s: %s", messageID, err)
+		return nil
+	}
+	return metadata
+}
+
+// parseAttachmentMetadata returns the metadata of the attachments of the
+// message JSON by attachment ID.
+func parseAttachmentMetadata(data []byte) (map[string]attachmentMetadata, error) {
+	var msg struct {
+		Attachments []attachmentMetadata `json:"attachments"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]attachmentMetadata, len(msg.Attachments))
+	for _, attach := range msg.Attachments {
+		metadata[attach.ID] = attach
+	}
+	return metadata, nil
+}
+
 func (b *Bdiscord) memberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
 	if m.GuildID != b.guildID {
 		b.Log.Debugf("Ignoring memberUpdate because it originates from a different guild")
@@ -355,3 +475,27 @@ func handleEmbed(embed *discordgo.MessageEmbed) string {
 
 	return result
 }
+
+// addSticker downloads a sticker from the Discord CDN and adds it to rmsg,
+// converted according to MediaConvertStickers.
+func (b *Bdiscord) addSticker(rmsg *config.Message, sticker *discordgo.StickerItem) error {
+	var uri, ext string
+	switch sticker.FormatType {
+	case discordgo.StickerFormatTypeLottie:
+		uri, ext = "https://discord.com/stickers/"+sticker.ID+".json", ".json"
+	case discordgo.StickerFormatTypeGIF:
+		uri, ext = "https://media.discordapp.net/stickers/"+sticker.ID+".gif", ".gif"
+	default:
+		// PNG and APNG stickers
+		uri, ext = "https://media.discordapp.net/stickers/"+sticker.ID+".png", ".png"
+	}
+
+	data, err := b.HttpGetBytes(uri)
+	if err != nil {
+		return err
+	}
+
+	name := b.ConvertSticker(helper.SanitizeFileName(sticker.Name, "")+ext, data)
+
+	return b.AddAttachmentFromBytes(rmsg, name, sticker.ID, "", data)
+}
@@ -56,3 +56,8 @@ func TestHandleEmbed(t *testing.T) {
 		assert.Equalf(t, tc.result, handleEmbed(tc.embed), "Testcases %s", name)
 	}
 }
+
+func TestReactionText(t *testing.T) {
+	assert.Equal(t, "👍", reactionText(discordgo.Emoji{Name: "👍"}))
+	assert.Equal(t, ":blobcat:", reactionText(discordgo.Emoji{ID: "42", Name: "blobcat"}))
+}
@@ -2,11 +2,15 @@ package bdiscord
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 	"unicode"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
 )
 
 func (b *Bdiscord) getAllowedMentions() *discordgo.MessageAllowedMentions {
@@ -33,6 +37,42 @@ func (b *Bdiscord) getAllowedMentions() *discordgo.MessageAllowedMentions {
 	}
 }
 
+// modRoleID returns the ID of the ModRole role, configured by ID or name.
+func (b *Bdiscord) modRoleID() string {
+	role := b.GetString("ModRole")
+	if role == "" {
+		return ""
+	}
+	guild, err := b.c.State.Guild(b.guildID)
+	if err != nil {
+		b.Log.Errorf("Could not get the roles of the guild: %s", err)
+		return ""
+	}
+	for _, r := range guild.Roles {
+		if r.ID == role || r.Name == role {
+			return r.ID
+		}
+	}
+	b.Log.Errorf("ModRole %s not found", role)
+	return ""
+}
+
+// pingModerators prefixes the announcement msg with a mention of ModRole, and
+// returns the mentions allowed in it.
+func (b *Bdiscord) pingModerators(msg *config.Message) *discordgo.MessageAllowedMentions {
+	allowed := b.getAllowedMentions()
+	roleID := b.modRoleID()
+	if roleID == "" {
+		return allowed
+	}
+	msg.Text = "<@&" + roleID + "> " + msg.Text
+	// the moderators are pinged even when AllowMention doesn't allow roles
+	if allowed != nil && !slices.Contains(allowed.Parse, discordgo.AllowedMentionTypeRoles) {
+		allowed.Roles = []string{roleID}
+	}
+	return allowed
+}
+
 func getGlobalNick(user *discordgo.User) string {
 	// Return the display name, if set.
 	if user.GlobalName != "" {
@@ -144,6 +184,55 @@ func (b *Bdiscord) getChannelName(id string) string {
 	return ""
 }
 
+// messageLink returns the jump URL of the message id of channelID, of its
+// first part for the split messages.
+func (b *Bdiscord) messageLink(channelID string, id string) string {
+	id, _, _ = strings.Cut(id, ";")
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", b.guildID, channelID, id)
+}
+
+// Permalink implements bridge.Permalinker with the jump URL of the message.
+func (b *Bdiscord) Permalink(channel string, id string) (string, error) {
+	channelID := b.getChannelID(channel)
+	if channelID == "" {
+		return "", fmt.Errorf("could not find channelID for %v", channel)
+	}
+	return b.messageLink(channelID, id), nil
+}
+
+// ResolveChannelName returns the name of a channel configured by ID.
+func (b *Bdiscord) ResolveChannelName(channel string) string {
+	channelID, ok := strings.CutPrefix(channel, "ID:")
+	if !ok {
+		return channel
+	}
+
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	for _, c := range b.channels {
+		if c.ID == channelID {
+			return c.Name
+		}
+	}
+	return channel
+}
+
+// ClassifyError wraps the errors of the Discord API with their bridge.ErrorClass.
+func (b *Bdiscord) ClassifyError(err error) error {
+	var rateLimitErr *discordgo.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return bridge.NewRateLimitError(err, rateLimitErr.RetryAfter)
+	}
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil {
+		if class := bridge.ClassifyStatusCode(restErr.Response.StatusCode); class != bridge.ErrorUnknown {
+			return bridge.NewError(class, err)
+		}
+	}
+	return err
+}
+
 func (b *Bdiscord) getCategoryChannelName(name, parentID string) string {
 	var usesCat bool
 	// do we have a category configuration in the channel config
@@ -44,3 +44,17 @@ func TestEnumerateUsernames(t *testing.T) {
 		assert.Equalf(t, testcase.expectedUsernames, foundUsernames, "Should have found the expected usernames for testcase %s", testname)
 	}
 }
+
+func TestHasMessageContent(t *testing.T) {
+	assert.False(t, hasMessageContent(0))
+	assert.False(t, hasMessageContent(1<<23))
+	assert.True(t, hasMessageContent(applicationFlagGatewayMessageContent))
+	assert.True(t, hasMessageContent(applicationFlagGatewayMessageContentLimited|1<<23))
+}
+
+func TestMessageLink(t *testing.T) {
+	b := &Bdiscord{guildID: "111"}
+	assert.Equal(t, "https://discord.com/channels/111/222/333", b.messageLink("222", "333"))
+	// split messages link to their first part
+	assert.Equal(t, "https://discord.com/channels/111/222/333", b.messageLink("222", "333;444"))
+}
@@ -0,0 +1,67 @@
+package bdiscord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Flags of the application telling whether the privileged message content
+// intent is granted, see
+// https://discord.com/developers/docs/resources/application#application-object-application-flags
+const (
+	applicationFlagGatewayMessageContent        = 1 << 18
+	applicationFlagGatewayMessageContentLimited = 1 << 19
+)
+
+// messageContentWarning is the health warning of the bridges running without
+// the message content intent.
+const messageContentWarning = "the Message Content intent is not enabled: only attachments, embeds, mentions of the bot and commands are relayed"
+
+// intents returns the gateway intents of the bot. The message content intent
+// is only requested when the application has it, the connection is refused
+// otherwise.
+func (b *Bdiscord) intents() discordgo.Intent {
+	// Add privileged intent for guild member tracking. This is needed to track nicks
+	// for display names and @mention translation
+	intents := discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsGuildMembers
+
+	app, err := b.c.Application("@me")
+	if err != nil {
+		// user tokens have no application, assume the intent is granted
+		b.Log.Debugf("Could not check the intents of the application: %s", err)
+		return intents | discordgo.IntentMessageContent
+	}
+
+	granted := hasMessageContent(app.Flags)
+	b.intentsMutex.Lock()
+	b.noMessageContent = !granted
+	b.intentsMutex.Unlock()
+	if !granted {
+		b.Log.Error("The Message Content intent is not enabled for this bot, the text of most messages will be empty. " +
+			"Enable it in the Bot section of the application on https://discord.com/developers/applications. " +
+			"Until then, only attachments, embeds, messages mentioning the bot and commands are relayed.")
+		return intents
+	}
+	return intents | discordgo.IntentMessageContent
+}
+
+// hasMessageContent returns true if the application flags grant the message
+// content intent, limited to the bots in less than 100 servers or not.
+func hasMessageContent(flags int) bool {
+	return flags&(applicationFlagGatewayMessageContent|applicationFlagGatewayMessageContentLimited) != 0
+}
+
+// missingMessageContent returns true if the bot runs without the message
+// content intent.
+func (b *Bdiscord) missingMessageContent() bool {
+	b.intentsMutex.Lock()
+	defer b.intentsMutex.Unlock()
+	return b.noMessageContent
+}
+
+// HealthWarnings implements bridge.HealthWarner.
+func (b *Bdiscord) HealthWarnings() []string {
+	if b.missingMessageContent() {
+		return []string{messageContentWarning}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package bdiscord
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// channelPermission is a permission the bot needs in a mapped channel.
+type channelPermission struct {
+	permission int64
+	name       string
+	reason     string
+	// needed returns false when the permission isn't used for the channel
+	needed func(b *Bdiscord, info config.ChannelInfo, channel *discordgo.Channel) bool
+}
+
+func always(*Bdiscord, config.ChannelInfo, *discordgo.Channel) bool {
+	return true
+}
+
+var channelPermissions = []channelPermission{
+	{discordgo.PermissionViewChannel, "View Channel", "to receive messages", always},
+	{discordgo.PermissionSendMessages, "Send Messages", "to relay messages", always},
+	{discordgo.PermissionEmbedLinks, "Embed Links", "to show link previews", always},
+	{discordgo.PermissionAttachFiles, "Attach Files", "to upload files", always},
+	{
+		discordgo.PermissionManageWebhooks, "Manage Webhooks", "to relay messages with AutoWebhooks",
+		func(b *Bdiscord, info config.ChannelInfo, _ *discordgo.Channel) bool {
+			return b.useAutoWebhooks && info.Options.WebhookURL == ""
+		},
+	},
+	{
+		discordgo.PermissionManageThreads, "Manage Threads", "to relay messages to archived threads",
+		func(_ *Bdiscord, _ config.ChannelInfo, channel *discordgo.Channel) bool {
+			return channel != nil && channel.IsThread()
+		},
+	},
+}
+
+// missingPermissions returns the permissions needed in channel which are not
+// granted by perms, with the reason they are needed.
+func (b *Bdiscord) missingPermissions(perms int64, info config.ChannelInfo, channel *discordgo.Channel) []string {
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return nil
+	}
+
+	var missing []string
+	for _, p := range channelPermissions {
+		if perms&p.permission == 0 && p.needed(b, info, channel) {
+			missing = append(missing, p.name+" ("+p.reason+")")
+		}
+	}
+	return missing
+}
+
+// auditPermissions checks the permissions of the bot in every mapped channel
+// and warns about the missing ones, instead of failing later with opaque 403
+// errors when sending.
+func (b *Bdiscord) auditPermissions() {
+	for _, info := range b.Channels {
+		channelID := b.getChannelID(info.Name)
+		if channelID == "" {
+			b.Log.Warnf("Permission audit: channel %s not found on the server", info.Name)
+			continue
+		}
+
+		perms, err := b.c.State.UserChannelPermissions(b.userID, channelID)
+		if err != nil {
+			// The state may not be populated yet, ask the API instead
+			perms, err = b.c.UserChannelPermissions(b.userID, channelID) //nolint:staticcheck
+		}
+		if err != nil {
+			b.Log.Warnf("Permission audit: could not get the permissions in channel %s: %s", info.Name, err)
+			continue
+		}
+
+		channel, _ := b.c.State.Channel(channelID)
+		if missing := b.missingPermissions(perms, info, channel); len(missing) > 0 {
+			b.Log.Warnf("Permission audit: the bot is missing permissions in channel %s: %s", info.Name, strings.Join(missing, ", "))
+		}
+	}
+}
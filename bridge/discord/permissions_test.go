@@ -0,0 +1,29 @@
+package bdiscord
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingPermissions(t *testing.T) {
+	b := &Bdiscord{}
+	text := &discordgo.Channel{Type: discordgo.ChannelTypeGuildText}
+	thread := &discordgo.Channel{Type: discordgo.ChannelTypeGuildPublicThread}
+	base := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks)
+
+	assert.Equal(t, []string{"Attach Files (to upload files)"}, b.missingPermissions(base, config.ChannelInfo{}, text))
+	assert.Empty(t, b.missingPermissions(base|discordgo.PermissionAttachFiles, config.ChannelInfo{}, text))
+	assert.Empty(t, b.missingPermissions(discordgo.PermissionAdministrator, config.ChannelInfo{}, thread))
+	assert.Equal(t, []string{"Attach Files (to upload files)", "Manage Threads (to relay messages to archived threads)"},
+		b.missingPermissions(base, config.ChannelInfo{}, thread))
+
+	b.useAutoWebhooks = true
+	assert.Equal(t, []string{"Manage Webhooks (to relay messages with AutoWebhooks)"},
+		b.missingPermissions(base|discordgo.PermissionAttachFiles, config.ChannelInfo{}, text))
+	// Channels with their own WebhookURL don't need it
+	assert.Empty(t, b.missingPermissions(base|discordgo.PermissionAttachFiles,
+		config.ChannelInfo{Options: config.ChannelOptions{WebhookURL: "https://discord.com/api/webhooks/1/token"}}, text))
+}
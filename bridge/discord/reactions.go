@@ -0,0 +1,93 @@
+package bdiscord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+func (b *Bdiscord) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) { //nolint:unparam
+	b.handleReaction(m.MessageReaction, m.Member, false)
+}
+
+func (b *Bdiscord) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) { //nolint:unparam
+	b.handleReaction(m.MessageReaction, nil, true)
+}
+
+// handleReaction relays a reaction added to or removed from a message.
+func (b *Bdiscord) handleReaction(r *discordgo.MessageReaction, member *discordgo.Member, removed bool) {
+	if r.GuildID != b.guildID {
+		b.Log.Debugf("Ignoring reaction because it originates from a different guild")
+		return
+	}
+	// not relay the reactions we added for the other bridges
+	if r.UserID == b.userID {
+		return
+	}
+
+	rmsg := config.Message{
+		Account:  b.Account,
+		Event:    config.EventReaction,
+		Text:     reactionText(r.Emoji),
+		Channel:  b.getChannelName(r.ChannelID),
+		UserID:   r.UserID,
+		Username: r.UserID,
+		ParentID: r.MessageID,
+	}
+	if member != nil && member.User != nil {
+		rmsg.UserID = "@" + member.User.Username
+		rmsg.Username = b.getNick(member.User, r.GuildID)
+	} else if user, err := b.c.User(r.UserID); err == nil {
+		rmsg.UserID = "@" + user.Username
+		rmsg.Username = b.getNick(user, r.GuildID)
+	}
+	if removed {
+		rmsg.MarkReactionRemoved()
+	}
+	b.Log.Debugf("<= Reaction is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// reactionText returns the unicode emoji of a reaction, or the :name: of the
+// custom emoji of the guild.
+func reactionText(emoji discordgo.Emoji) string {
+	if emoji.ID != "" {
+		return ":" + emoji.Name + ":"
+	}
+	return emoji.Name
+}
+
+// sendReaction adds the reaction in msg.Text to the message referenced by
+// msg.ParentID, or removes it when the message is flagged with
+// MarkReactionRemoved. The reactions which aren't unicode emoji (custom emoji
+// of other networks, ...) are sent as a reply.
+func (b *Bdiscord) sendReaction(msg *config.Message, channelID string) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+	// the messages split in several parts are reacted to on the first one
+	parentID := strings.Split(msg.ParentID, ";")[0]
+	if threadID, ok := b.messageThread(parentID); ok {
+		channelID = threadID
+	}
+
+	emoji := strings.TrimSpace(helper.EmojiToUnicode(msg.Text))
+	if emoji == "" || strings.HasPrefix(emoji, ":") {
+		if msg.ReactionRemoved() {
+			return "", nil
+		}
+		b.Log.Debugf("%q isn't a discord reaction, sending it as a reply", emoji)
+		msg.Event = ""
+		msg.Text = fmt.Sprintf("reacted with %s", msg.Text)
+		return b.Send(*msg)
+	}
+
+	if msg.ReactionRemoved() {
+		return "", b.c.MessageReactionRemove(channelID, parentID, emoji, "@me")
+	}
+	return "", b.c.MessageReactionAdd(channelID, parentID, emoji)
+}
@@ -8,6 +8,7 @@
 // - Sending new messages
 // - Editing messages, via message ID
 // - Deleting messages, via message ID
+// - Sending to threads, with the webhook of their parent channel
 //
 // The package has been designed for matterbridge, but with other
 // Go bots in mind. The public API should be matterbridge-agnostic.
@@ -33,6 +34,8 @@ type Transmitter struct {
 
 	// channelWebhooks maps from a channel ID to a webhook instance
 	channelWebhooks map[string]*discordgo.Webhook
+	// threadParents maps from a thread ID to the ID of its parent channel
+	threadParents map[string]string
 
 	mutex sync.RWMutex
 
@@ -57,19 +60,31 @@ func New(session *discordgo.Session, guild string, title string, autoCreate bool
 		autoCreate: autoCreate,
 
 		channelWebhooks: make(map[string]*discordgo.Webhook),
+		threadParents:   make(map[string]string),
 
 		Log: log.NewEntry(log.StandardLogger()),
 	}
 }
 
 // Send transmits a message to the given channel with the provided webhook data, and waits until Discord responds with message data.
+//
+// Threads can't have webhooks: a message to a thread is sent with the webhook of its parent channel.
+// An archived thread is unarchived when Discord refuses the message because of it.
 func (t *Transmitter) Send(channelID string, params *discordgo.WebhookParams) (*discordgo.Message, error) {
-	wh, err := t.getOrCreateWebhook(channelID)
+	webhookChannelID, threadID, err := t.webhookTarget(channelID)
+	if err != nil {
+		return nil, err
+	}
+	wh, err := t.getOrCreateWebhook(webhookChannelID)
 	if err != nil {
 		return nil, err
 	}
 
-	msg, err := t.session.WebhookExecute(wh.ID, wh.Token, true, params)
+	var msg *discordgo.Message
+	err = t.unarchiveOnError(threadID, func() error {
+		msg, err = t.session.WebhookThreadExecute(wh.ID, wh.Token, true, threadID, params)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("execute failed: %w", err)
 	}
@@ -77,21 +92,76 @@ func (t *Transmitter) Send(channelID string, params *discordgo.WebhookParams) (*
 	return msg, nil
 }
 
-// Edit will edit a message in a channel, if possible.
+// Edit will edit a message in a channel, if possible. Like in Send, the messages in threads are edited
+// with the webhook of their parent channel.
 func (t *Transmitter) Edit(channelID string, messageID string, params *discordgo.WebhookParams) error {
-	wh := t.getWebhook(channelID)
+	webhookChannelID, threadID, err := t.webhookTarget(channelID)
+	if err != nil {
+		return err
+	}
+	wh := t.getWebhook(webhookChannelID)
 
 	if wh == nil {
 		return ErrWebhookNotFound
 	}
 
 	uri := discordgo.EndpointWebhookToken(wh.ID, wh.Token) + "/messages/" + messageID
-	_, err := t.session.RequestWithBucketID("PATCH", uri, params, discordgo.EndpointWebhookToken("", ""))
+	if threadID != "" {
+		uri += "?thread_id=" + threadID
+	}
+	return t.unarchiveOnError(threadID, func() error {
+		_, err := t.session.RequestWithBucketID("PATCH", uri, params, discordgo.EndpointWebhookToken("", ""))
+		return err
+	})
+}
+
+// webhookTarget returns the channel whose webhook sends the messages to channelID, and the thread
+// they are sent to, empty when channelID isn't a thread.
+func (t *Transmitter) webhookTarget(channelID string) (string, string, error) {
+	t.mutex.RLock()
+	parentID, ok := t.threadParents[channelID]
+	t.mutex.RUnlock()
+	if ok {
+		if parentID == "" {
+			return channelID, "", nil
+		}
+		return parentID, channelID, nil
+	}
+
+	channel, err := t.session.State.Channel(channelID)
 	if err != nil {
+		channel, err = t.session.Channel(channelID)
+		if err != nil {
+			return "", "", fmt.Errorf("could not get channel %s: %w", channelID, err)
+		}
+	}
+	if channel.IsThread() {
+		parentID = channel.ParentID
+	}
+
+	t.mutex.Lock()
+	t.threadParents[channelID] = parentID
+	t.mutex.Unlock()
+	if parentID == "" {
+		return channelID, "", nil
+	}
+	return parentID, channelID, nil
+}
+
+// unarchiveOnError calls fn, and again after unarchiving threadID when it failed because the
+// thread is archived.
+func (t *Transmitter) unarchiveOnError(threadID string, fn func() error) error {
+	err := fn()
+	if threadID == "" || !isDiscordErrorCode(err, discordgo.ErrCodePerformedOperationOnArchivedThread) {
 		return err
 	}
 
-	return nil
+	t.Log.Debugf("Unarchiving thread %s", threadID)
+	archived := false
+	if _, editErr := t.session.ChannelEdit(threadID, &discordgo.ChannelEdit{Archived: &archived}); editErr != nil {
+		return fmt.Errorf("could not unarchive thread %s: %w", threadID, editErr)
+	}
+	return fn()
 }
 
 // HasWebhook checks whether the transmitter is using a particular webhook.
@@ -6,6 +6,11 @@ import (
 
 // isDiscordPermissionError returns false for nil, and true if a Discord RESTError with code discordgo.ErrorCodeMissionPermissions
 func isDiscordPermissionError(err error) bool {
+	return isDiscordErrorCode(err, discordgo.ErrCodeMissingPermissions)
+}
+
+// isDiscordErrorCode returns false for nil, and true if a Discord RESTError with the given code
+func isDiscordErrorCode(err error, code int) bool {
 	if err == nil {
 		return false
 	}
@@ -15,7 +20,7 @@ func isDiscordPermissionError(err error) bool {
 		return false
 	}
 
-	return restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions
+	return restErr.Message != nil && restErr.Message.Code == code
 }
 
 // getDiscordUserID gets own user ID from state, and fallback on API request
@@ -0,0 +1,125 @@
+package bdiscord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+// voiceFilename is the name Discord gives to voice messages.
+const voiceFilename = "voice-message.ogg"
+
+// isVoiceFile returns true if name can be a Discord voice message, which must
+// be Opus in an OGG container.
+func isVoiceFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ogg", ".oga", ".opus":
+		return true
+	}
+	return false
+}
+
+// voiceAttachment is the attachment of a voice message, uploaded beforehand.
+type voiceAttachment struct {
+	ID               string  `json:"id"`
+	Filename         string  `json:"filename"`
+	UploadedFilename string  `json:"uploaded_filename"`
+	DurationSecs     float64 `json:"duration_secs"`
+	// Waveform is base64 encoded in the JSON, one 0-255 sample per byte
+	Waveform []byte `json:"waveform"`
+}
+
+// voiceMessage returns the message sending the uploaded file of fi as a voice
+// message. Voice messages can have no content.
+func voiceMessage(fi *config.FileInfo, uploadedFilename string) map[string]any {
+	waveform := helper.RawVoiceWaveform(fi.Waveform, 255)
+	if waveform == nil {
+		// Discord shows a flat waveform rather than none
+		waveform = make([]byte, 1)
+	}
+	return map[string]any{
+		"flags": discordgo.MessageFlagsIsVoiceMessage,
+		"attachments": []voiceAttachment{{
+			ID:               "0",
+			Filename:         voiceFilename,
+			UploadedFilename: uploadedFilename,
+			DurationSecs:     float64(fi.Duration) / 1000,
+			Waveform:         waveform,
+		}},
+	}
+}
+
+// sendVoiceMessage sends fi as a native voice message and returns its ID. The
+// file is uploaded to the storage of Discord first, and the name of the user
+// with the comment of the file are sent in a message before it. captioned
+// tells if that message was sent when it fails.
+func (b *Bdiscord) sendVoiceMessage(msg *config.Message, channelID string, fi *config.FileInfo) (id string, captioned bool, err error) {
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", captioned, err
+	}
+
+	endpoint := discordgo.EndpointChannel(channelID) + "/attachments"
+	response, err := b.c.RequestWithBucketID(http.MethodPost, endpoint, map[string]any{
+		"files": []map[string]any{{"id": "0", "filename": voiceFilename, "file_size": len(data)}},
+	}, endpoint)
+	if err != nil {
+		return "", captioned, fmt.Errorf("requesting the upload failed: %w", err)
+	}
+	var upload struct {
+		Attachments []struct {
+			UploadURL      string `json:"upload_url"`
+			UploadFilename string `json:"upload_filename"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(response, &upload); err != nil {
+		return "", captioned, err
+	}
+	if len(upload.Attachments) != 1 {
+		return "", captioned, errors.New("discord returned no upload URL")
+	}
+
+	req, err := http.NewRequest(http.MethodPut, upload.Attachments[0].UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", captioned, err
+	}
+	req.Header.Set("Content-Type", "audio/ogg")
+	res, err := b.c.Client.Do(req)
+	if err != nil {
+		return "", captioned, fmt.Errorf("uploading the file failed: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return "", captioned, fmt.Errorf("uploading the file failed: %s", res.Status)
+	}
+
+	if caption := msg.Username + fi.Comment; strings.TrimSpace(caption) != "" {
+		_, err := b.c.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:         caption,
+			AllowedMentions: b.getAllowedMentions(),
+		})
+		if err != nil {
+			return "", false, err
+		}
+		captioned = true
+	}
+
+	endpoint = discordgo.EndpointChannelMessages(channelID)
+	response, err = b.c.RequestWithBucketID(http.MethodPost, endpoint, voiceMessage(fi, upload.Attachments[0].UploadFilename), endpoint)
+	if err != nil {
+		return "", captioned, err
+	}
+	var sent discordgo.Message
+	if err := json.Unmarshal(response, &sent); err != nil {
+		return "", captioned, err
+	}
+	return sent.ID, captioned, nil
+}
@@ -0,0 +1,43 @@
+package bdiscord
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAttachmentMetadata(t *testing.T) {
+	metadata, err := parseAttachmentMetadata([]byte(`{"id":"1","flags":8192,"attachments":[
+		{"id":"10","filename":"voice-message.ogg","duration_secs":2.5,"waveform":"AH//"},
+		{"id":"11","filename":"cat.png","description":"a cat"}]}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2.5, metadata["10"].DurationSecs)
+	assert.Equal(t, []byte{0, 127, 255}, metadata["10"].Waveform)
+	assert.Equal(t, "a cat", metadata["11"].Description)
+	assert.Nil(t, metadata["11"].Waveform)
+
+	_, err = parseAttachmentMetadata([]byte(`{"attachments":"x"}`))
+	assert.Error(t, err)
+}
+
+func TestVoiceMessage(t *testing.T) {
+	assert.True(t, isVoiceFile("voice.OGG"))
+	assert.True(t, isVoiceFile("note.opus"))
+	assert.False(t, isVoiceFile("song.mp3"))
+
+	fi := &config.FileInfo{Name: "voice.ogg", Voice: true, Duration: 2500, Waveform: []int{0, 512, 1024}}
+	data, err := json.Marshal(voiceMessage(fi, "uploads/voice-message.ogg"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"flags":8192,"attachments":[{"id":"0","filename":"voice-message.ogg",
+		"uploaded_filename":"uploads/voice-message.ogg","duration_secs":2.5,"waveform":"AH//"}]}`, string(data))
+
+	// without a waveform, a flat one is sent
+	fi.Waveform = nil
+	data, err = json.Marshal(voiceMessage(fi, "uploads/voice-message.ogg"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"waveform":"AA=="`)
+}
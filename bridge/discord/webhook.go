@@ -1,7 +1,6 @@
 package bdiscord
 
 import (
-	"bytes"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
@@ -43,6 +42,120 @@ func (b *Bdiscord) maybeGetLocalAvatar(msg *config.Message) string {
 	return ""
 }
 
+const (
+	// replyExcerptLength is the number of characters of the parent message
+	// quoted in the replies sent by webhook.
+	replyExcerptLength = 80
+	// threadNameLength is the number of characters of the parent message
+	// naming the threads created with ThreadReplies.
+	threadNameLength = 50
+	// cThreadMessage prefixes the cache keys of the replies sent to the
+	// thread of their parent, whose values are the thread IDs.
+	cThreadMessage = "thread_message"
+)
+
+// parentMessage returns the message parentID replied to, nil when it can't be
+// fetched.
+func (b *Bdiscord) parentMessage(channelID string, parentID string) *discordgo.Message {
+	if b.Budget.Tight() {
+		return nil
+	}
+	// the first part of split messages
+	parentID, _, _ = strings.Cut(parentID, ";")
+	parent, err := b.c.ChannelMessage(channelID, parentID)
+	if err != nil {
+		b.Log.Debugf("Error getting the message %s replied to: %s", parentID, err)
+		return nil
+	}
+	return parent
+}
+
+// replyThread returns the thread the replies to parent are sent to by webhook:
+// the thread started from parent, else with ThreadReplies a new one. Empty
+// when the replies are sent to the channel.
+func (b *Bdiscord) replyThread(channelID string, parent *discordgo.Message) string {
+	if parent == nil {
+		return ""
+	}
+	if parent.Thread != nil {
+		return parent.Thread.ID
+	}
+	if !b.GetBool("ThreadReplies") {
+		return ""
+	}
+	thread, err := b.c.MessageThreadStart(channelID, parent.ID, threadName(parent), 0)
+	if err != nil {
+		b.Log.Warnf("Could not create a thread for the replies to %s, quoting it instead: %s", parent.ID, err)
+		return ""
+	}
+	b.Log.Debugf("Created thread %s for the replies to %s", thread.ID, parent.ID)
+	return thread.ID
+}
+
+// threadName returns the name of the thread started from parent, the
+// beginning of its content.
+func threadName(parent *discordgo.Message) string {
+	name := strings.Join(strings.Fields(parent.Content), " ")
+	if runes := []rune(name); len(runes) > threadNameLength {
+		name = string(runes[:threadNameLength]) + "…"
+	}
+	if name == "" && parent.Author != nil {
+		name = parent.Author.Username
+	}
+	if name == "" {
+		name = "replies"
+	}
+	return name
+}
+
+// messageThread returns the thread the webhook message id was sent to, if it
+// was sent to the thread of its parent.
+func (b *Bdiscord) messageThread(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	threadID, ok := b.cache.Get(cThreadMessage + id)
+	if !ok {
+		return "", false
+	}
+	return threadID.(string), true //nolint:forcetypeassert
+}
+
+// replyExcerpt returns the line quoting the message parentID, which the
+// replies sent by webhook start with as webhooks can't reply: the author and
+// the beginning of the parent, with a link to it. Only the link is kept when
+// the parent wasn't fetched.
+func (b *Bdiscord) replyExcerpt(channelID string, parentID string, parent *discordgo.Message) string {
+	// the first part of split messages
+	parentID, _, _ = strings.Cut(parentID, ";")
+	link := b.messageLink(channelID, parentID)
+	if parent == nil {
+		return formatReplyExcerpt("", "", link)
+	}
+	author := ""
+	if parent.Author != nil {
+		author = parent.Author.Username
+	}
+	return formatReplyExcerpt(author, parent.Content, link)
+}
+
+// formatReplyExcerpt returns "> ↩ author: content… [jump](link)" with the
+// content on one line and clipped.
+func formatReplyExcerpt(author string, content string, link string) string {
+	excerpt := "> ↩"
+	if author != "" {
+		excerpt += " **" + author + "**:"
+	}
+	content = strings.Join(strings.Fields(content), " ")
+	if runes := []rune(content); len(runes) > replyExcerptLength {
+		content = string(runes[:replyExcerptLength]) + "…"
+	}
+	if content != "" {
+		excerpt += " " + content
+	}
+	return excerpt + " [jump](<" + link + ">)"
+}
+
 func (b *Bdiscord) webhookSendTextOnly(msg *config.Message, channelID string) (string, error) {
 	msgParts := helper.ClipOrSplitMessage(msg.Text, MessageLength, b.GetString("MessageClipped"), b.GetInt("MessageSplitMaxCount"))
 	msgIds := []string{}
@@ -69,16 +182,21 @@ func (b *Bdiscord) webhookSendTextOnly(msg *config.Message, channelID string) (s
 func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID string) error {
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo) //nolint:forcetypeassert
+		r, err := fi.Open()
+		if err != nil {
+			b.Log.Errorf("Could not open file %s for message %#v: %s", fi.Name, msg, err)
+			return err
+		}
 		file := discordgo.File{
 			Name:        fi.Name,
 			ContentType: "",
-			Reader:      bytes.NewReader(*fi.Data),
+			Reader:      r,
 		}
 		content := fi.Comment
 
 		// Cannot use the resulting ID for any edits anyway, so throw it away.
 		// This has to be re-enabled when we implement message deletion.
-		_, err := b.transmitter.Send(
+		_, err = b.transmitter.Send(
 			channelID,
 			&discordgo.WebhookParams{
 				Username:        msg.Username,
@@ -88,6 +206,7 @@ func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID string) e
 				AllowedMentions: b.getAllowedMentions(),
 			},
 		)
+		r.Close()
 		if err != nil {
 			b.Log.Errorf("Could not send file %#v for message %#v: %s", file, msg, err)
 			return err
@@ -141,9 +260,27 @@ func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID string) (st
 		return "", nil
 	}
 
-	// discord username must be [0..32] max
-	if len(msg.Username) > 32 {
-		msg.Username = msg.Username[0:32]
+	// With PreserveThreading, the replies are sent to the thread of their
+	// parent, else quote it.
+	threadID, inThread := b.messageThread(msg.ID)
+	if !inThread && msg.ParentValid() {
+		parent := b.parentMessage(channelID, msg.ParentID)
+		if msg.ID == "" {
+			threadID = b.replyThread(channelID, parent)
+			inThread = threadID != ""
+		}
+		if !inThread {
+			msg.Text = b.replyExcerpt(channelID, msg.ParentID, parent) + "\n" + msg.Text
+		}
+	}
+	if inThread {
+		channelID = threadID
+	}
+
+	// the nick is clipped by the gateway already, unless NickMaxLength is set
+	// higher than the limit
+	if runes := []rune(msg.Username); len(runes) > MaxNickLength {
+		msg.Username = string(runes[:MaxNickLength])
 	}
 
 	if msg.ID != "" {
@@ -180,5 +317,8 @@ func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID string) (st
 		b.Log.Errorf("Could not broadcast via webhook for message %#v: %s", msgID, err)
 		return "", err
 	}
+	if inThread && msgID != "" {
+		b.cache.Add(cThreadMessage+msgID, threadID)
+	}
 	return msgID, nil
 }
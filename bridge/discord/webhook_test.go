@@ -0,0 +1,44 @@
+package bdiscord
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatReplyExcerpt(t *testing.T) {
+	link := "https://discord.com/channels/1/2/3"
+	assert.Equal(t, "> ↩ **alice**: original text [jump](<"+link+">)", formatReplyExcerpt("alice", "original\ntext", link))
+	assert.Equal(t, "> ↩ [jump](<"+link+">)", formatReplyExcerpt("", "", link))
+	assert.Equal(t, "> ↩ **alice**: "+strings.Repeat("a", replyExcerptLength)+"… [jump](<"+link+">)",
+		formatReplyExcerpt("alice", strings.Repeat("a", replyExcerptLength+10), link))
+}
+
+func TestThreadName(t *testing.T) {
+	alice := &discordgo.User{Username: "alice"}
+	assert.Equal(t, "original text", threadName(&discordgo.Message{Content: "original\ntext", Author: alice}))
+	assert.Equal(t, strings.Repeat("a", threadNameLength)+"…",
+		threadName(&discordgo.Message{Content: strings.Repeat("a", threadNameLength+10), Author: alice}))
+	assert.Equal(t, "alice", threadName(&discordgo.Message{Author: alice}))
+	assert.Equal(t, "replies", threadName(&discordgo.Message{}))
+}
+
+func TestReplyThread(t *testing.T) {
+	cache, err := lru.New(10)
+	require.NoError(t, err)
+	b := &Bdiscord{cache: cache}
+
+	assert.Empty(t, b.replyThread("1", nil), "the parent wasn't fetched")
+	assert.Equal(t, "3", b.replyThread("1", &discordgo.Message{ID: "2", Thread: &discordgo.Channel{ID: "3"}}))
+
+	_, ok := b.messageThread("")
+	assert.False(t, ok)
+	b.cache.Add(cThreadMessage+"4;5", "3")
+	threadID, ok := b.messageThread("4;5")
+	assert.True(t, ok)
+	assert.Equal(t, "3", threadID)
+}
@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrorClass is the kind of a bridge failure. The gateway uses it to decide
+// whether to retry, reconnect or drop a message, and to log a readable cause.
+type ErrorClass int
+
+const (
+	ErrorUnknown ErrorClass = iota
+	ErrorAuth
+	ErrorPermission
+	ErrorRateLimited
+	ErrorNetwork
+	ErrorTooLarge
+)
+
+// Sentinels for errors.Is, matching any *Error of their class.
+var (
+	ErrAuth         = errors.New("authentication failed")
+	ErrPermission   = errors.New("permission denied")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrNetwork      = errors.New("network unreachable")
+	ErrTooLarge     = errors.New("payload too large")
+	errUnknownClass = errors.New("unknown error")
+)
+
+var errorClasses = map[ErrorClass]struct {
+	sentinel    error
+	description string
+}{
+	ErrorUnknown:     {errUnknownClass, "unexpected error"},
+	ErrorAuth:        {ErrAuth, "authentication failed, check the credentials or token of the account"},
+	ErrorPermission:  {ErrPermission, "permission denied, check the permissions of the bot in the channel"},
+	ErrorRateLimited: {ErrRateLimited, "rate limited by the server, messages are sent too fast"},
+	ErrorNetwork:     {ErrNetwork, "the server can't be reached"},
+	ErrorTooLarge:    {ErrTooLarge, "the message or file is too large for the server"},
+}
+
+// Description returns a human-readable cause for errors of class c.
+func (c ErrorClass) Description() string {
+	return errorClasses[c].description
+}
+
+// Error is a bridge error with its class, so that the gateway can handle it
+// without knowing the errors of each protocol library.
+type Error struct {
+	Class ErrorClass
+	Err   error
+	// RetryAfter is the delay asked by the server for rate limited errors, if known
+	RetryAfter time.Duration
+}
+
+// NewError wraps err with its class.
+func NewError(class ErrorClass, err error) *Error {
+	return &Error{Class: class, Err: err}
+}
+
+// NewRateLimitError wraps err as a rate limited error, to retry after retryAfter.
+func NewRateLimitError(err error, retryAfter time.Duration) *Error {
+	return &Error{Class: ErrorRateLimited, Err: err, RetryAfter: retryAfter}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Class.Description(), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel of the class of e, eg. errors.Is(err, ErrRateLimited).
+func (e *Error) Is(target error) bool {
+	return target == errorClasses[e.Class].sentinel
+}
+
+// ErrorClassOf returns the class of err: the class of an *Error it wraps, or
+// ErrorNetwork for network errors and timeouts.
+func ErrorClassOf(err error) ErrorClass {
+	var bridgeErr *Error
+	if errors.As(err, &bridgeErr) {
+		return bridgeErr.Class
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
+
+// ClassifyStatusCode returns the class of an error returned with an HTTP status
+// code, for protocols with HTTP APIs.
+func ClassifyStatusCode(code int) ErrorClass {
+	switch {
+	case code == http.StatusUnauthorized:
+		return ErrorAuth
+	case code == http.StatusForbidden:
+		return ErrorPermission
+	case code == http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case code == http.StatusRequestEntityTooLarge:
+		return ErrorTooLarge
+	case code >= http.StatusInternalServerError:
+		return ErrorNetwork
+	default:
+		return ErrorUnknown
+	}
+}
+
+// ErrorClassifier is implemented by bridges which can classify the errors of
+// their protocol library. It is called with the errors returned by Send.
+type ErrorClassifier interface {
+	ClassifyError(err error) error
+}
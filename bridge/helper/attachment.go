@@ -0,0 +1,162 @@
+package helper
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // register jpeg for image.DecodeConfig
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// DefaultAttachmentFormat is the AttachmentFormat used when it isn't set,
+// eg. "[image: cat.jpg 1.2MB, 800x600] https://example.com/cat.jpg".
+const DefaultAttachmentFormat = "[{TYPE}: {NAME} {INFO}] {URL}"
+
+// attachmentIcons are the {ICON} of the attachment types.
+var attachmentIcons = map[string]string{
+	"image": "🖼",
+	"video": "🎬",
+	"audio": "🎵",
+	"voice": "🎤",
+	"file":  "📎",
+}
+
+// FormatAttachment returns the text describing fi on the networks which only
+// show text, according to format, see AttachmentFormat. The placeholders are
+// {ICON}, {TYPE}, {NAME}, {SIZE}, {INFO} (the size, and the dimensions of the
+// images or the duration), {URL} and {COMMENT}. An empty placeholder is
+// removed with the space before it.
+func FormatAttachment(fi config.FileInfo, format string) string {
+	if format == "" {
+		format = DefaultAttachmentFormat
+	}
+	kind, details := attachmentDetails(fi)
+	n := fi.Size
+	if n <= 0 {
+		n = fi.DataSize()
+	}
+	size := ""
+	if n > 0 {
+		size = formatSize(n)
+	}
+	info := size
+	switch {
+	case info == "":
+		info = details
+	case details != "":
+		info += ", " + details
+	}
+
+	replacements := []string{
+		"{ICON}", attachmentIcons[kind],
+		"{TYPE}", kind,
+		"{NAME}", fi.Name,
+		"{SIZE}", size,
+		"{INFO}", info,
+		"{URL}", fi.URL,
+		"{COMMENT}", fi.Comment,
+	}
+	var args []string
+	for i := 0; i < len(replacements); i += 2 {
+		if replacements[i+1] == "" {
+			args = append(args, " "+replacements[i], "")
+		}
+	}
+	args = append(args, replacements...)
+	return strings.TrimSpace(strings.NewReplacer(args...).Replace(format))
+}
+
+// attachmentDetails returns the type of fi (image, video, audio, voice or
+// file), and the dimensions of the images or the duration of the voice
+// messages.
+func attachmentDetails(fi config.FileInfo) (string, string) {
+	if fi.Voice {
+		return "voice", formatDuration(fi.Duration)
+	}
+
+	var head []byte
+	if r, err := fi.Open(); err == nil {
+		head = make([]byte, 512)
+		n, _ := io.ReadFull(r, head)
+		head = head[:n]
+		r.Close()
+	}
+	kind := "file"
+	mimeType := ""
+	if len(head) > 0 {
+		mimeType = http.DetectContentType(head)
+	}
+	// the types sniffed from generic data say less than the extension
+	if mimeType == "" || strings.HasPrefix(mimeType, "application/octet-stream") || strings.HasPrefix(mimeType, "text/plain") {
+		mimeType = mimeTypeByExtension(fi.Name)
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		kind = "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		kind = "video"
+	case strings.HasPrefix(mimeType, "audio/"), mimeType == "application/ogg":
+		kind = "audio"
+	}
+
+	switch kind {
+	case "image":
+		r, err := fi.Open()
+		if err != nil {
+			return kind, ""
+		}
+		defer r.Close()
+		cfg, _, err := image.DecodeConfig(r)
+		if err != nil {
+			return kind, ""
+		}
+		return kind, fmt.Sprintf("%dx%d", cfg.Width, cfg.Height)
+	case "video", "audio":
+		return kind, formatDuration(fi.Duration)
+	}
+	return kind, ""
+}
+
+// mimeTypeByExtension returns the type of the file name according to its
+// extension, or "" when unknown.
+func mimeTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	for mimeType, preferred := range preferredExtensions {
+		if preferred == ext {
+			return mimeType
+		}
+	}
+	return ""
+}
+
+// formatSize returns n bytes in a short human form, eg. 1.2MB.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fGB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fMB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.0fKB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// formatDuration returns seconds as m:ss or h:mm:ss, or "" when unknown.
+func formatDuration(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
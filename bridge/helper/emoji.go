@@ -0,0 +1,191 @@
+package helper
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kyokomi/emoji/v2"
+)
+
+// emojiAliases are the shortcodes of some platforms which are not in the
+// shared emoji table, mapped to the shortcode of the same emoji in the table.
+var emojiAliases = map[string]string{
+	"simple_smile": "slightly_smiling_face", // slack
+}
+
+// skinTones are the shortcode suffixes platforms use for skin tone variants,
+// mapped to the unicode modifiers. Slack sends the tone as a separate
+// shortcode (":+1::skin-tone-2:"), which is in the shared table.
+var skinTones = map[string]string{
+	"_tone1":                  "\U0001f3fb", // discord
+	"_tone2":                  "\U0001f3fc",
+	"_tone3":                  "\U0001f3fd",
+	"_tone4":                  "\U0001f3fe",
+	"_tone5":                  "\U0001f3ff",
+	"_light_skin_tone":        "\U0001f3fb", // mattermost
+	"_medium_light_skin_tone": "\U0001f3fc",
+	"_medium_skin_tone":       "\U0001f3fd",
+	"_medium_dark_skin_tone":  "\U0001f3fe",
+	"_dark_skin_tone":         "\U0001f3ff",
+}
+
+// flagShortcodeRE matches the slack flag shortcodes, eg. "flag-fr".
+var flagShortcodeRE = regexp.MustCompile(`^flag-([a-z]{2})$`)
+
+// customEmojiRE matches discord custom emoji, eg. "<:party_parrot:123456>".
+var customEmojiRE = regexp.MustCompile(`<a?:(\w+):\d+>`)
+
+// maxShortcodeLength bounds the search for the closing colon of a shortcode.
+const maxShortcodeLength = 64
+
+// EmojiUnicode returns the unicode emoji of a shortcode (without colons) of
+// any platform.
+func EmojiUnicode(shortcode string) (string, bool) {
+	codes := emoji.CodeMap()
+	if alias, ok := emojiAliases[shortcode]; ok {
+		shortcode = alias
+	}
+	if e, ok := codes[":"+shortcode+":"]; ok {
+		return e, true
+	}
+	if match := flagShortcodeRE.FindStringSubmatch(shortcode); match != nil {
+		return string('\U0001F1E6'+rune(match[1][0]-'a')) + string('\U0001F1E6'+rune(match[1][1]-'a')), true
+	}
+	for suffix, modifier := range skinTones {
+		base, ok := strings.CutSuffix(shortcode, suffix)
+		if !ok {
+			continue
+		}
+		if e, ok := EmojiUnicode(base); ok {
+			return strings.TrimSuffix(e, "\ufe0f") + modifier, true
+		}
+	}
+	return "", false
+}
+
+// EmojiToUnicode replaces the emoji shortcodes in text (eg. ":thumbsup:") by
+// their unicode emoji, so that they don't arrive as literal text on networks
+// using other shortcodes. Unknown shortcodes and shortcodes in inline code are
+// left as is, discord custom emoji are replaced by their shortcode.
+func EmojiToUnicode(text string) string {
+	if !strings.Contains(text, ":") {
+		return text
+	}
+	text = customEmojiRE.ReplaceAllString(text, ":$1:")
+
+	var out strings.Builder
+	out.Grow(len(text))
+	inCode := false
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '`':
+			inCode = !inCode
+		case ':':
+			if inCode {
+				break
+			}
+			end := strings.IndexByte(text[i+1:min(len(text), i+1+maxShortcodeLength)], ':')
+			if end <= 0 {
+				break
+			}
+			name := text[i+1 : i+1+end]
+			if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+				break
+			}
+			if e, ok := EmojiUnicode(name); ok {
+				out.WriteString(e)
+				i += end + 2
+				continue
+			}
+			// the closing colon may open the next shortcode, eg. "12:30:smile:"
+			out.WriteString(text[i : i+1+end])
+			i += end + 1
+			continue
+		}
+		out.WriteByte(text[i])
+		i++
+	}
+	return out.String()
+}
+
+var (
+	emojiShortcodesOnce sync.Once
+	emojiShortcodes     map[string]string
+	maxEmojiLength      int
+)
+
+// loadEmojiShortcodes builds the reverse table, from unicode emoji to their
+// first shortcode in the shared table.
+func loadEmojiShortcodes() {
+	emojiShortcodes = make(map[string]string)
+	for e, codes := range emoji.RevCodeMap() {
+		if len(codes) == 0 {
+			continue
+		}
+		emojiShortcodes[e] = codes[0]
+		maxEmojiLength = max(maxEmojiLength, len(e))
+	}
+}
+
+// EmojiShortcodes returns the shortcodes (without colons) of the unicode emoji
+// e in the shared table, for the networks which take reactions by name.
+func EmojiShortcodes(e string) []string {
+	var names []string
+	// the table has some emoji both with and without variation selector
+	base := strings.ReplaceAll(e, "\ufe0f", "")
+	for _, variant := range []string{e, base, base + "\ufe0f"} {
+		for _, code := range emoji.RevCodeMap()[variant] {
+			if name := strings.Trim(code, ":"); !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// ReactionNames returns the names the networks taking reactions by name may
+// know the reaction text of another network by: the shortcodes of the unicode
+// emoji in the shared table, or the name of a custom emoji.
+func ReactionNames(text string) []string {
+	text = strings.TrimSpace(EmojiToUnicode(text))
+	if len(text) > 2 && strings.HasPrefix(text, ":") && strings.HasSuffix(text, ":") {
+		return []string{strings.Trim(text, ":")}
+	}
+	return EmojiShortcodes(text)
+}
+
+// EmojiToShortcodes replaces the unicode emoji in text by their shortcode, for
+// networks whose clients can't display emoji.
+func EmojiToShortcodes(text string) string {
+	emojiShortcodesOnce.Do(loadEmojiShortcodes)
+
+	var out strings.Builder
+	out.Grow(len(text))
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		// longest match first, to keep sequences like flags and skin tones
+		matched := false
+		for n := min(maxEmojiLength, len(text)-i); n > 0; n-- {
+			if code, ok := emojiShortcodes[text[i:i+n]]; ok {
+				out.WriteString(code)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			out.WriteString(text[i : i+size])
+			i += size
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmojiToUnicode(t *testing.T) {
+	for text, expected := range map[string]string{
+		"":                                  "",
+		"no emoji":                          "no emoji",
+		":thumbsup: and :+1:":               "👍 and 👍",
+		"slack :simple_smile:":              "slack 🙂",
+		"slack :+1::skin-tone-2:":           "slack 👍🏻",
+		"discord :thumbsup_tone2:":          "discord 👍🏼",
+		"mattermost :+1_dark_skin_tone:":    "mattermost 👍🏿",
+		":flag-fr: :flag_fr:":               "🇫🇷 🇫🇷",
+		"custom <:party_parrot:1234567890>": "custom :party_parrot:",
+		"custom <a:smile:1234567890>":       "custom 😄",
+		":unknown_shortcode:":               ":unknown_shortcode:",
+		"at 12:30:smile:":                   "at 12:30😄",
+		"http://example.com:8080/:smile:":   "http://example.com:8080/😄",
+		"`a :smile: in code` :smile:":       "`a :smile: in code` 😄",
+		": smile :":                         ": smile :",
+		"::smile::":                         ":😄:",
+	} {
+		assert.Equal(t, expected, EmojiToUnicode(text), text)
+	}
+}
+
+func TestEmojiToShortcodes(t *testing.T) {
+	for text, expected := range map[string]string{
+		"":                  "",
+		"no emoji, ünïcode": "no emoji, ünïcode",
+		"👍 ok":              ":+1: ok",
+		"🇫🇷":                ":fr:",
+	} {
+		assert.Equal(t, expected, EmojiToShortcodes(text), text)
+	}
+
+	// round trip
+	assert.Equal(t, "😄 👍", EmojiToUnicode(EmojiToShortcodes("😄 👍")))
+}
+
+func TestEmojiShortcodes(t *testing.T) {
+	assert.Contains(t, EmojiShortcodes("👍"), "thumbsup")
+	assert.Contains(t, EmojiShortcodes("❤"), "heart")
+	assert.Contains(t, EmojiShortcodes("❤️"), "heart")
+	assert.Empty(t, EmojiShortcodes("a"))
+}
+
+func TestReactionNames(t *testing.T) {
+	assert.Contains(t, ReactionNames("👍"), "thumbsup")
+	assert.Contains(t, ReactionNames(":thumbsup:"), "+1")
+	assert.Equal(t, []string{"party_parrot"}, ReactionNames(":party_parrot:"))
+	assert.Empty(t, ReactionNames("no emoji"))
+}
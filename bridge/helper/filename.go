@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// preferredExtensions overrides the extension picked by the mime package for
+// common types, where its first (alphabetical) choice is an unusual one.
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"video/mp4":  ".mp4",
+	"audio/mpeg": ".mp3",
+	"audio/ogg":  ".ogg",
+	"text/plain": ".txt",
+}
+
+// SanitizeFileName returns a file name which is safe to store on disk and to
+// use in URLs and protocol payloads, for all bridges and the media server.
+//
+// Letters and digits of any script are kept, so unicode names stay readable.
+// Invisible formatting characters, including RTL/LTR overrides which can be
+// used to disguise an extension (eg. "photo‮gpj.exe"), are removed.
+// Everything else is replaced by an underscore. When the name has no
+// extension, one is added according to mimeType, if set.
+func SanitizeFileName(name string, mimeType string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Never keep directories from the original name
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+
+	ext := path.Ext(name)
+	base := sanitizeFileNamePart(strings.TrimSuffix(name, ext), "-_")
+	ext = sanitizeFileNamePart(strings.TrimPrefix(ext, "."), "")
+	if ext != "" && ext != "_" {
+		ext = "." + ext
+	} else {
+		ext = ""
+	}
+
+	if base == "" || base == "_" {
+		base = "file"
+	}
+
+	if ext == "" {
+		ext = ExtensionForMimeType(mimeType)
+	}
+
+	return base + ext
+}
+
+// sanitizeFileNamePart replaces every run of characters which are not letters,
+// digits, combining marks or in allowed by a single underscore.
+func sanitizeFileNamePart(part string, allowed string) string {
+	var sb strings.Builder
+
+	replaced := false
+	for _, r := range part {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || strings.ContainsRune(allowed, r) {
+			sb.WriteRune(r)
+			replaced = false
+			continue
+		}
+		if !replaced {
+			sb.WriteRune('_')
+			replaced = true
+		}
+	}
+
+	return sb.String()
+}
+
+// ExtensionForMimeType returns the usual file extension (with leading dot) for
+// a MIME type, or an empty string when it is unknown.
+func ExtensionForMimeType(mimeType string) string {
+	if mimeType == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+
+	return exts[0]
+}
+
+// AddFileExtension appends an extension matching mimeType to name, when name
+// does not have any extension yet.
+func AddFileExtension(name string, mimeType string) string {
+	if path.Ext(name) != "" {
+		return name
+	}
+
+	return name + ExtensionForMimeType(mimeType)
+}
+
+// MediaServerURL returns the public URL of a file stored on the media server
+// under the given hash directory. The name is expected to be sanitized
+// already, it is escaped so unicode names produce valid URLs.
+func MediaServerURL(base string, hash string, name string) string {
+	return strings.TrimSuffix(base, "/") + "/" + hash + "/" + url.PathEscape(name)
+}
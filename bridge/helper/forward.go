@@ -0,0 +1,36 @@
+package helper
+
+import (
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// ForwardAttribution returns the line attributing a forwarded message to its
+// origin, eg. "forwarded from alice in #general".
+func ForwardAttribution(fwd config.Forward) string {
+	switch {
+	case fwd.From != "" && fwd.Channel != "":
+		return "forwarded from " + fwd.From + " in " + fwd.Channel
+	case fwd.From != "":
+		return "forwarded from " + fwd.From
+	case fwd.Channel != "":
+		return "forwarded from " + fwd.Channel
+	}
+	return "forwarded message"
+}
+
+// FormatForward adds the attribution of a forwarded message to its text. With
+// blockquote, for networks rendering markdown, the text is quoted below the
+// attribution, otherwise it follows it on the same line.
+func FormatForward(text string, fwd config.Forward, blockquote bool) string {
+	attribution := ForwardAttribution(fwd)
+	attribution = strings.ToUpper(attribution[:1]) + attribution[1:]
+	if text == "" {
+		return attribution
+	}
+	if !blockquote {
+		return attribution + ": " + text
+	}
+	return attribution + ":\n> " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n> ")
+}
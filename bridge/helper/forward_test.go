@@ -0,0 +1,16 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatForward(t *testing.T) {
+	fwd := config.Forward{From: "alice", Channel: "#news"}
+	assert.Equal(t, "Forwarded from alice in #news: hello", FormatForward("hello", fwd, false))
+	assert.Equal(t, "Forwarded from alice in #news:\n> hello\n> world", FormatForward("hello\nworld\n", fwd, true))
+	assert.Equal(t, "Forwarded from #news", FormatForward("", config.Forward{Channel: "#news"}, true))
+	assert.Equal(t, "Forwarded message: hello", FormatForward("hello", config.Forward{}, false))
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -25,6 +26,25 @@ var errHttpGetNotOk = errors.New("HTTP server responded non-OK code")
 
 var pTagRE = regexp.MustCompile(`(?s)<p>(.*?)</p>`)
 
+// markdown is the converter of ParseMarkdown, which is safe for concurrent
+// use and costly to set up for every message.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Strikethrough,
+		extension.Linkify,
+	),
+	goldmark.WithParserOptions(),
+	goldmark.WithRendererOptions(
+		html.WithHardWraps(),
+		html.WithUnsafe(),
+	),
+)
+
+// markdownBuffers are the buffers ParseMarkdown renders to.
+var markdownBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
 func HttpGetNotOkError(url string, code int) error {
 	return fmt.Errorf("%w: %s returned code %d", errHttpGetNotOk, url, code)
 }
@@ -140,55 +160,6 @@ func GetSubLines(message string, maxLineLength int, clippingMessage string) []st
 	return lines
 }
 
-// GetSubLinesWords splits messages in newline-delimited lines. If maxLineLength is
-// specified as non-zero GetSubLinesWords will also clip long lines to the maximum
-// length and insert a warning marker that the line was clipped. It tries to respect word boundaries.
-func GetSubLinesWords(message string, maxLineLength int, clippingMessage string) []string {
-	if clippingMessage == "" {
-		clippingMessage = " <clipped message>"
-	}
-
-	var lines []string
-	for line := range strings.SplitSeq(strings.TrimSpace(message), "\n") {
-		if line == "" {
-			// Prevent sending empty messages, so we'll skip this line
-			// if it has no content.
-			continue
-		}
-
-		if maxLineLength == 0 || len([]byte(line)) <= maxLineLength {
-			lines = append(lines, line)
-			continue
-		}
-
-		clipMsgLen := len([]byte(clippingMessage))
-		idx := 0
-
-		for idx < (len(line)) {
-			endofline := idx + maxLineLength - clipMsgLen
-			if endofline >= (len(line)) {
-				lines = append(lines, line[idx:])
-				break
-			}
-			linesegment := line[idx:endofline]
-			splitMidWord := false
-
-			lastword := strings.LastIndex(linesegment, " ")
-			if lastword == -1 { // No space found, so cut at a rune
-				lastword = len(linesegment)
-				splitMidWord = true
-			}
-			linesegment = linesegment[:lastword]
-			idx += lastword
-			if !splitMidWord {
-				idx++ // Skip over the space we found
-			}
-			lines = append(lines, linesegment+clippingMessage)
-		}
-	}
-	return lines
-}
-
 // HandleExtra manages the supplementary details stored inside a message's 'Extra' field map.
 func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message {
 	extra := msg.Extra
@@ -206,23 +177,15 @@ func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message
 	return rmsg
 }
 
-// GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
-func GetAvatar(av map[string]string, userid string, general *config.Protocol) string {
-	if sha, ok := av[userid]; ok {
-		return general.MediaServerDownload + "/" + sha + "/" + userid + ".png"
-	}
-	return ""
-}
-
 // HandleDownloadSize checks a specified filename against the configured download blacklist
 // and checks a specified file-size against the configure limit.
 func HandleDownloadSize(logger *logrus.Entry, msg *config.Message, name string, size int64, general *config.Protocol) error {
 	// check blacklist here
 	for _, entry := range general.MediaDownloadBlackList {
 		if entry != "" {
-			re, err := regexp.Compile(entry)
+			re, err := config.CompileRegex(entry)
 			if err != nil {
-				logger.Errorf("incorrect regexp %s for %s", entry, msg.Account)
+				// already reported when loading the configuration
 				continue
 			}
 			if re.MatchString(name) {
@@ -255,14 +218,98 @@ func HandleDownloadData2(logger *logrus.Entry, msg *config.Message, name, id, co
 	if msg.Event == config.EventAvatarDownload {
 		avatar = true
 	}
-	msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{
+	fi := config.FileInfo{
 		Name:     name,
 		Data:     data,
 		URL:      url,
 		Comment:  comment,
 		Avatar:   avatar,
 		NativeID: id,
-	})
+	}
+	SpoolFile(logger, &fi, general)
+	msg.Extra["file"] = append(msg.Extra["file"], fi)
+}
+
+// MarkVoiceNote flags the last file added to msg as a voice note, with its
+// duration in milliseconds and waveform when known (0/nil otherwise).
+func MarkVoiceNote(msg *config.Message, duration int, waveform []int) {
+	files := msg.Extra["file"]
+	if len(files) == 0 {
+		return
+	}
+
+	fi, ok := files[len(files)-1].(config.FileInfo)
+	if !ok {
+		return
+	}
+
+	fi.Voice = true
+	fi.Duration = duration
+	fi.Waveform = waveform
+	files[len(files)-1] = fi
+}
+
+// SetAltText sets the description of the last file added to msg, eg. the alt
+// text of an image.
+func SetAltText(msg *config.Message, altText string) {
+	files := msg.Extra["file"]
+	if len(files) == 0 || altText == "" {
+		return
+	}
+
+	fi, ok := files[len(files)-1].(config.FileInfo)
+	if !ok {
+		return
+	}
+
+	fi.AltText = altText
+	files[len(files)-1] = fi
+}
+
+// SetDuration sets the duration in milliseconds of the last file added to msg,
+// eg. of an audio file which isn't a voice note.
+func SetDuration(msg *config.Message, duration int) {
+	files := msg.Extra["file"]
+	if len(files) == 0 || duration <= 0 {
+		return
+	}
+
+	fi, ok := files[len(files)-1].(config.FileInfo)
+	if !ok {
+		return
+	}
+
+	fi.Duration = duration
+	files[len(files)-1] = fi
+}
+
+// VoiceWaveform rescales raw waveform samples (eg. 0-100 for WhatsApp,
+// 0-255 for Telegram and Discord) to the 0-1024 range used in FileInfo.
+func VoiceWaveform(samples []byte, maxSample int) []int {
+	if len(samples) == 0 || maxSample <= 0 {
+		return nil
+	}
+
+	waveform := make([]int, len(samples))
+	for i, sample := range samples {
+		waveform[i] = min(int(sample)*1024/maxSample, 1024)
+	}
+
+	return waveform
+}
+
+// RawVoiceWaveform converts a 0-1024 FileInfo waveform back to samples in the 0-maxSample range.
+func RawVoiceWaveform(waveform []int, maxSample int) []byte {
+	if len(waveform) == 0 {
+		return nil
+	}
+
+	samples := make([]byte, len(waveform))
+	for i, amplitude := range waveform {
+		samples[i] = byte(min(max(amplitude, 0)*maxSample/1024, maxSample)) //nolint:gosec // clamped to maxSample
+	}
+
+	return samples
 }
 
 var emptyLineMatcher = regexp.MustCompile("\n+")
@@ -329,20 +376,10 @@ func ClipOrSplitMessage(text string, length int, clippingMessage string, splitMa
 
 // ParseMarkdown takes in an input string as markdown and parses it to html
 func ParseMarkdown(input string, logger *logrus.Entry) string {
-	actualInput := []byte(input)
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.Strikethrough,
-			extension.Linkify,
-		),
-		goldmark.WithParserOptions(),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithUnsafe(),
-		),
-	)
-	var buf bytes.Buffer
-	if err := md.Convert(actualInput, &buf); err != nil {
+	buf := markdownBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer markdownBuffers.Put(buf)
+	if err := markdown.Convert([]byte(input), buf); err != nil {
 		logger.Debugf("markdown parser errored with %#v with input \"%v\"\n\n", err, input)
 		return input
 	}
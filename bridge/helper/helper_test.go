@@ -1,10 +1,19 @@
 package helper
 
 import (
+	"bytes"
+	"image"
+	"image/png"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const testLineLength = 64
@@ -235,3 +244,229 @@ func TestClipOrSplitMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestSanitizeFileName(t *testing.T) {
+	fileNameTestCases := map[string]struct {
+		name     string
+		mimeType string
+		expected string
+	}{
+		"plain ascii":         {name: "cat.jpg", expected: "cat.jpg"},
+		"spaces and symbols":  {name: "my cat (1).jpg", expected: "my_cat_1_.jpg"},
+		"unicode kept":        {name: "café été.png", expected: "café_été.png"},
+		"arabic kept":         {name: "صورة.png", expected: "صورة.png"},
+		"rtl override":        {name: "photo‮gpj.exe", expected: "photogpj.exe"},
+		"directories removed": {name: "../../etc/passwd", expected: "passwd"},
+		"only dots":           {name: "..", expected: "file"},
+		"missing extension":   {name: "voice", mimeType: "audio/ogg", expected: "voice.ogg"},
+		"jpeg extension":      {name: "IMG 1", mimeType: "image/jpeg; charset=binary", expected: "IMG_1.jpg"},
+		"unknown mime":        {name: "blob", mimeType: "application/x-unknown-thing", expected: "blob"},
+	}
+
+	for testname, testcase := range fileNameTestCases {
+		assert.Equalf(t, testcase.expected, SanitizeFileName(testcase.name, testcase.mimeType), "case '%s' failed", testname)
+	}
+
+	assert.Equal(t, "https://media.example.com/abcd1234/caf%C3%A9.png", MediaServerURL("https://media.example.com/", "abcd1234", "café.png"))
+}
+
+func TestVoiceWaveform(t *testing.T) {
+	assert.Nil(t, VoiceWaveform(nil, 100))
+	assert.Equal(t, []int{0, 512, 1024, 1024}, VoiceWaveform([]byte{0, 50, 100, 200}, 100))
+	assert.Equal(t, []byte{0, 50, 100, 100, 0}, RawVoiceWaveform([]int{0, 512, 1024, 2000, -5}, 100))
+
+	msg := &config.Message{Extra: map[string][]interface{}{}}
+	MarkVoiceNote(msg, 1500, nil)
+	assert.Empty(t, msg.Extra["file"])
+
+	msg.Extra["file"] = []interface{}{config.FileInfo{Name: "a.jpg"}, config.FileInfo{Name: "b.ogg"}}
+	MarkVoiceNote(msg, 1500, []int{1, 2})
+	assert.False(t, msg.Extra["file"][0].(config.FileInfo).Voice)
+	assert.Equal(t, config.FileInfo{Name: "b.ogg", Voice: true, Duration: 1500, Waveform: []int{1, 2}}, msg.Extra["file"][1])
+}
+
+func TestSetAltText(t *testing.T) {
+	msg := &config.Message{Extra: map[string][]interface{}{}}
+	SetAltText(msg, "a cat")
+	assert.Empty(t, msg.Extra["file"])
+
+	msg.Extra["file"] = []interface{}{config.FileInfo{Name: "a.jpg"}, config.FileInfo{Name: "b.jpg"}}
+	SetAltText(msg, "a cat")
+	assert.Empty(t, msg.Extra["file"][0].(config.FileInfo).AltText)
+	assert.Equal(t, config.FileInfo{Name: "b.jpg", AltText: "a cat"}, msg.Extra["file"][1])
+}
+
+func TestSetDuration(t *testing.T) {
+	msg := &config.Message{Extra: map[string][]interface{}{}}
+	SetDuration(msg, 1500)
+	assert.Empty(t, msg.Extra["file"])
+
+	msg.Extra["file"] = []interface{}{config.FileInfo{Name: "a.mp3"}}
+	SetDuration(msg, 0)
+	assert.Equal(t, config.FileInfo{Name: "a.mp3"}, msg.Extra["file"][0])
+	SetDuration(msg, 1500)
+	assert.Equal(t, config.FileInfo{Name: "a.mp3", Duration: 1500}, msg.Extra["file"][0])
+}
+
+func TestConvertSticker(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+
+	assert.Equal(t, StickerFormatPNG, DetectStickerFormat(buf.Bytes()))
+	assert.Equal(t, StickerFormatTgs, DetectStickerFormat([]byte{0x1f, 0x8b, 0x08}))
+	assert.Equal(t, StickerFormatLottie, DetectStickerFormat([]byte(` {"v":"5.5.2"}`)))
+	assert.Equal(t, StickerFormatWebP, DetectStickerFormat([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, "", DetectStickerFormat([]byte("hello")))
+
+	data := buf.Bytes()
+	name, err := ConvertSticker("sticker.png", &data, "gif", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "sticker.gif", name)
+	assert.Equal(t, StickerFormatGIF, DetectStickerFormat(data))
+
+	name, err = ConvertSticker("sticker.tgs.webp", &data, "gif", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "sticker.gif", name, "no conversion needed, only the extension is fixed")
+
+	_, err = ConvertSticker("sticker.gif", &data, "bmp", nil)
+	assert.Error(t, err)
+}
+
+func TestApplyNickRules(t *testing.T) {
+	discord := NickRules{Strip: []string{"discord", "clyde"}, MaxLength: 12}
+	for nick, expected := range map[string]string{
+		"alice":                "alice",
+		"[Discord] <alice>":    "[] <alice>",
+		"discdiscordord fan":   " fan",
+		"CLYDEbot":             "bot",
+		"a very long nickname": "a very long ",
+		"日本語のニックネームです、長い": "日本語のニックネームです",
+	} {
+		assert.Equal(t, expected, ApplyNickRules(nick, discord), nick)
+	}
+
+	rules := NickRules{Disallowed: "@#:", Replacement: "_"}
+	assert.Equal(t, "alice_home_ ", ApplyNickRules("alice@home: ", rules))
+	assert.Equal(t, "bob", ApplyNickRules("b@o#b:", NickRules{Disallowed: "@#:"}))
+	assert.Equal(t, "<alice> ", ApplyNickRules("<alice> ", NickRules{}))
+}
+
+func TestSpoolFile(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	general := &config.Protocol{MediaSpoolPath: t.TempDir(), MediaSpoolSize: 4}
+	dir := SpoolDir(general.MediaSpoolPath)
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	// the files of the user in MediaSpoolPath are kept
+	other := filepath.Join(general.MediaSpoolPath, "matterbridge-notes.txt")
+	require.NoError(t, os.WriteFile(other, []byte("mine"), 0o600))
+
+	small := []byte("abc")
+	fi := config.FileInfo{Name: "small.txt", Data: &small}
+	SpoolFile(logger, &fi, general)
+	assert.Empty(t, fi.Path)
+	assert.Equal(t, &small, fi.Data)
+
+	large := []byte("abcdefgh")
+	fi = config.FileInfo{Name: "large.txt", Data: &large}
+	SpoolFile(logger, &fi, general)
+	assert.Nil(t, fi.Data)
+	assert.Equal(t, ".txt", filepath.Ext(fi.Path))
+	assert.Equal(t, dir, filepath.Dir(fi.Path))
+	assert.Equal(t, int64(8), fi.Size)
+	assert.Equal(t, int64(8), fi.DataSize())
+	data, err := fi.Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, large, data)
+
+	removed, err := CleanSpool(dir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	removed, err = CleanSpool(dir, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, fi.Path)
+	assert.FileExists(t, other)
+}
+
+func TestSpoolReader(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	general := &config.Protocol{MediaSpoolPath: t.TempDir(), MediaSpoolSize: 4}
+	dir := SpoolDir(general.MediaSpoolPath)
+	require.NoError(t, os.Mkdir(dir, 0o700))
+
+	fi := config.FileInfo{Name: "small.txt"}
+	require.NoError(t, SpoolReader(logger, &fi, strings.NewReader("abc"), 10, general))
+	assert.Empty(t, fi.Path)
+	assert.Equal(t, []byte("abc"), *fi.Data)
+	assert.Equal(t, int64(3), fi.Size)
+
+	// the data past MediaSpoolSize is streamed to the spool file
+	fi = config.FileInfo{Name: "large.txt"}
+	require.NoError(t, SpoolReader(logger, &fi, strings.NewReader("abcdefgh"), 10, general))
+	assert.Nil(t, fi.Data)
+	assert.Equal(t, dir, filepath.Dir(fi.Path))
+	assert.Equal(t, int64(8), fi.DataSize())
+	data, err := fi.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abcdefgh"), data)
+
+	// the download stops past the maximum size
+	fi = config.FileInfo{Name: "huge.txt"}
+	assert.ErrorIs(t, SpoolReader(logger, &fi, strings.NewReader(strings.Repeat("a", 100)), 10, general), ErrFileTooLarge)
+	assert.Empty(t, fi.Path)
+	assert.Equal(t, int64(11), fi.Size)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the partial spool file is removed")
+
+	// without MediaSpoolPath the files are read in memory
+	fi = config.FileInfo{Name: "large.txt"}
+	require.NoError(t, SpoolReader(logger, &fi, strings.NewReader("abcdefgh"), 10, &config.Protocol{}))
+	assert.Equal(t, []byte("abcdefgh"), *fi.Data)
+}
+
+func TestFormatAttachment(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 800, 600))))
+	pngData := buf.Bytes()
+	textData := []byte("hello")
+
+	for _, tc := range []struct {
+		fi       config.FileInfo
+		format   string
+		expected string
+	}{
+		{
+			config.FileInfo{Name: "cat.png", Data: &pngData, Size: 1234567, URL: "https://example.com/cat.png"},
+			"",
+			"[image: cat.png 1.2MB, 800x600] https://example.com/cat.png",
+		},
+		{
+			config.FileInfo{Name: "notes.txt", Data: &textData, URL: "https://example.com/notes.txt"},
+			"",
+			"[file: notes.txt 5B] https://example.com/notes.txt",
+		},
+		{
+			config.FileInfo{Name: "clip.mp4", URL: "https://example.com/clip.mp4", Duration: 95},
+			"",
+			"[video: clip.mp4 1:35] https://example.com/clip.mp4",
+		},
+		{
+			config.FileInfo{Name: "voice.ogg", Voice: true, Size: 20000, URL: "https://example.com/voice.ogg"},
+			"{ICON} {NAME} ({INFO}) {URL}",
+			"🎤 voice.ogg (20KB) https://example.com/voice.ogg",
+		},
+		{
+			config.FileInfo{Name: "doc.pdf", URL: "https://example.com/doc.pdf"},
+			"[{TYPE}: {NAME} {SIZE}] {URL}",
+			"[file: doc.pdf] https://example.com/doc.pdf",
+		},
+		{
+			config.FileInfo{Name: "doc.pdf", URL: "https://example.com/doc.pdf"},
+			"{URL}",
+			"https://example.com/doc.pdf",
+		},
+	} {
+		assert.Equal(t, tc.expected, FormatAttachment(tc.fi, tc.format), tc.fi.Name)
+	}
+}
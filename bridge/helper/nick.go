@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"strings"
+)
+
+// NickRules are the naming rules of a platform, which the relayed nicks must
+// follow to be accepted.
+type NickRules struct {
+	// Strip are substrings removed from the nicks, ignoring case
+	Strip []string
+	// Disallowed are the characters replaced by Replacement
+	Disallowed  string
+	Replacement string
+	// MaxLength is the maximum number of characters of the nicks, 0 when
+	// unlimited
+	MaxLength int
+}
+
+// ApplyNickRules returns nick following rules. The result only depends on nick
+// and rules, so that a user is always relayed with the same nick.
+func ApplyNickRules(nick string, rules NickRules) string {
+	if rules.Disallowed != "" {
+		nick = replaceDisallowed(nick, rules)
+	}
+
+	// removing a substring can form another one: "discdiscordord"
+	for stripped := false; !stripped; {
+		stripped = true
+		for _, s := range rules.Strip {
+			if s == "" {
+				continue
+			}
+			if i := indexFold(nick, s); i != -1 {
+				nick = nick[:i] + nick[i+len(s):]
+				stripped = false
+			}
+		}
+	}
+
+	if rules.MaxLength > 0 {
+		if runes := []rune(nick); len(runes) > rules.MaxLength {
+			nick = string(runes[:rules.MaxLength])
+		}
+	}
+	return nick
+}
+
+// replaceDisallowed replaces the disallowed characters of nick by the
+// replacement of rules.
+func replaceDisallowed(nick string, rules NickRules) string {
+	var out strings.Builder
+	out.Grow(len(nick))
+	for _, r := range nick {
+		if strings.ContainsRune(rules.Disallowed, r) {
+			out.WriteString(rules.Replacement)
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// indexFold returns the index of the first instance of substr in s ignoring
+// case, or -1.
+func indexFold(s string, substr string) int {
+	for i := range s {
+		if len(s)-i < len(substr) {
+			break
+		}
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
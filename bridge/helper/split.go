@@ -0,0 +1,185 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	codeFence = "```"
+	// fenceClose closes the code block left open at the end of a part.
+	fenceClose = "\n" + codeFence
+)
+
+// SplitMessage splits text in parts of at most length bytes. It never splits
+// inside a UTF-8 sequence and prefers to split on line breaks, then on the end
+// of sentences, then between words. The code blocks split between two parts
+// are closed at the end of the first one and opened again, with their
+// language, at the start of the next one. When numbered is set, the parts end
+// with "(1/3)", "(2/3)"... A length of 0 or less doesn't split.
+func SplitMessage(text string, length int, numbered bool) []string {
+	if length <= 0 || len(text) <= length {
+		return []string{text}
+	}
+	if !numbered {
+		return splitText(text, length)
+	}
+
+	// room is kept for the numbers, with more digits when the parts are more
+	// numerous than expected
+	for digits := 1; ; digits++ {
+		widest := strings.Repeat("9", digits)
+		room := len(partNumber(widest, widest))
+		if length-room <= 0 {
+			return splitText(text, length)
+		}
+		parts := splitText(text, length-room)
+		if len(fmt.Sprint(len(parts))) > digits {
+			continue
+		}
+		for i := range parts {
+			number := partNumber(fmt.Sprint(i+1), fmt.Sprint(len(parts)))
+			// the closing fence must stay alone on its line
+			if strings.HasSuffix(parts[i], fenceClose) {
+				number = "\n" + number[1:]
+			}
+			parts[i] += number
+		}
+		return parts
+	}
+}
+
+// SplitLines splits message in its non-empty lines, for the protocols sending
+// every line as a message, and the lines longer than length with SplitMessage.
+func SplitLines(message string, length int, numbered bool) []string {
+	var lines []string
+	for line := range strings.SplitSeq(strings.TrimSpace(message), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, SplitMessage(line, length, numbered)...)
+	}
+	return lines
+}
+
+func partNumber(part string, total string) string {
+	return " (" + part + "/" + total + ")"
+}
+
+// splitText splits text in parts of at most length bytes, see SplitMessage.
+func splitText(text string, length int) []string {
+	var (
+		parts []string
+		// fence is the line opening the code block the remaining text is in
+		fence     string
+		lineStart = true
+	)
+	remaining := text
+	for remaining != "" {
+		prefix := ""
+		if fence != "" {
+			prefix = fence + "\n"
+		}
+		if len(prefix)+len(remaining) <= length {
+			parts = append(parts, prefix+remaining)
+			break
+		}
+		limit := length - len(prefix)
+		// the parts too short to keep the code blocks balanced don't
+		balanced := limit > len(fenceClose)
+		if !balanced {
+			prefix, limit = "", length
+			if len(remaining) <= limit {
+				parts = append(parts, remaining)
+				break
+			}
+		}
+
+		end, next := splitIndex(remaining, limit)
+		open := scanFences(remaining[:end], fence, lineStart)
+		if open != "" && balanced {
+			// room is needed to close the code block
+			end, next = splitIndex(remaining, limit-len(fenceClose))
+			open = scanFences(remaining[:end], fence, lineStart)
+		}
+
+		part := prefix + remaining[:end]
+		if open != "" && balanced {
+			part += fenceClose
+		}
+		parts = append(parts, part)
+		fence = open
+		lineStart = next > end && remaining[end] == '\n'
+		remaining = remaining[next:]
+	}
+	return parts
+}
+
+// splitIndex returns where to end the part of text of at most limit bytes
+// (limit < len(text)) and where the next one starts, skipping the line break
+// or spaces split on.
+func splitIndex(text string, limit int) (int, int) {
+	end := limit
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	if end == 0 {
+		// a rune longer than limit is sent alone rather than never
+		_, size := utf8.DecodeRuneInString(text)
+		return size, size
+	}
+	window := text[:end]
+
+	// the line breaks and sentences are only split on when they don't leave
+	// too short a part
+	if i := strings.LastIndex(window, "\n"); i >= end/2 {
+		return i, i + 1
+	}
+	if i := lastSentenceEnd(window); i >= end/2 {
+		return i, i + len(text[i:]) - len(strings.TrimLeft(text[i:], " "))
+	}
+	// the space right after the window ends a word too
+	if i := strings.LastIndex(text[:end+1], " "); i > 0 {
+		if trimmed := len(strings.TrimRight(text[:i], " ")); trimmed > 0 {
+			end = trimmed
+		} else {
+			end = i
+		}
+		return end, i + 1 + len(text[i+1:]) - len(strings.TrimLeft(text[i+1:], " "))
+	}
+	return end, end
+}
+
+// lastSentenceEnd returns the index after the last punctuation ending a
+// sentence followed by a space in text, -1 if there is none.
+func lastSentenceEnd(text string) int {
+	for i := len(text) - 2; i >= 0; i-- {
+		if text[i+1] == ' ' && strings.IndexByte(".!?", text[i]) >= 0 {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// scanFences returns the line opening the code block open at the end of text,
+// given the one open at its start. lineStart tells if text starts a line.
+func scanFences(text string, fence string, lineStart bool) string {
+	for i, line := range strings.Split(text, "\n") {
+		if i == 0 && !lineStart {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, codeFence) {
+			continue
+		}
+		switch {
+		case fence != "":
+			fence = ""
+		case !strings.Contains(line[len(codeFence):], codeFence):
+			// not a block opened and closed on a line
+			fence = line
+		}
+	}
+	return fence
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var splitMessageTestCases = map[string]struct {
+	input    string
+	length   int
+	numbered bool
+	expected []string
+}{
+	"Short message": {
+		input:    "Hello world",
+		length:   20,
+		expected: []string{"Hello world"},
+	},
+	"No length": {
+		input:    "Hello world",
+		expected: []string{"Hello world"},
+	},
+	"Words": {
+		input:    "Lorem ipsum dolor sit amet, consectetur adipiscing elit",
+		length:   20,
+		expected: []string{"Lorem ipsum dolor", "sit amet,", "consectetur", "adipiscing elit"},
+	},
+	"Sentences": {
+		input:    "The first one works. The second one is split",
+		length:   30,
+		expected: []string{"The first one works.", "The second one is split"},
+	},
+	"Lines": {
+		input:    "first line. here\nsecond line",
+		length:   20,
+		expected: []string{"first line. here", "second line"},
+	},
+	"UTF-8 multi-byte runes": {
+		input:    "人人生而自由，在尊嚴和權利上一律平等。",
+		length:   20,
+		expected: []string{"人人生而自由", "，在尊嚴和權", "利上一律平等", "。"},
+	},
+	"Numbered": {
+		input:    "Lorem ipsum dolor sit amet, consectetur adipiscing elit",
+		length:   30,
+		numbered: true,
+		expected: []string{"Lorem ipsum dolor sit (1/3)", "amet, consectetur (2/3)", "adipiscing elit (3/3)"},
+	},
+	"Code block": {
+		input:    "see:\n```go\nfunc a() {}\nfunc b() {}\n```\ndone",
+		length:   30,
+		expected: []string{"see:\n```go\nfunc a() {}\n```", "```go\nfunc b() {}\n```\ndone"},
+	},
+	"Numbered code block": {
+		input:    "```\nfirst line\nsecond line\n```",
+		length:   28,
+		numbered: true,
+		expected: []string{"```\nfirst line\n```\n(1/2)", "```\nsecond line\n```\n(2/2)"},
+	},
+	"Long fence line": {
+		input:    "```golang\nx := 1\ny",
+		length:   14,
+		expected: []string{"```golang\n```", "x := 1\ny"},
+	},
+	"Fence line longer than the length": {
+		input:    "```a-very-long-language\nab\ncd",
+		length:   12,
+		expected: []string{"```a-ver\n```", "y-long-langu", "age\nab\ncd"},
+	},
+	"Inline code block": {
+		input:    "```a``` and then some words",
+		length:   16,
+		expected: []string{"```a``` and then", "some words"},
+	},
+}
+
+func TestSplitMessage(t *testing.T) {
+	for testname, testcase := range splitMessageTestCases {
+		parts := SplitMessage(testcase.input, testcase.length, testcase.numbered)
+		assert.Equalf(t, testcase.expected, parts, "'%s' testcase should give the expected parts.", testname)
+		for _, part := range parts {
+			assert.Truef(t, utf8.ValidString(part), "'%s' testcase should give valid UTF-8.", testname)
+			if testcase.length > 0 {
+				assert.LessOrEqualf(t, len(part), testcase.length, "'%s' testcase should not exceed the length.", testname)
+			}
+		}
+	}
+}
+
+func TestSplitMessageNumberedDigits(t *testing.T) {
+	// 3 words fit with one digit, which makes 20 parts, so 2 words fit
+	parts := SplitMessage(strings.Repeat("word ", 59)+"word", 20, true)
+	assert.Len(t, parts, 30)
+	assert.Equal(t, "word word (1/30)", parts[0])
+	for _, part := range parts {
+		assert.LessOrEqual(t, len(part), 20)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	assert.Equal(t, []string{"first", "second line", "is longer"}, SplitLines("first\n\nsecond line is longer\n", 12, false))
+}
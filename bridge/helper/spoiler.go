@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Spoilers are relayed marked like on discord: "||spoiler||".
+var (
+	spoilerRE = regexp.MustCompile(`(?s)\|\|(.+?)\|\|`)
+	// codeRE matches the code blocks and inline code of markdown, which
+	// spoilers are never searched in.
+	codeRE = regexp.MustCompile("(?s)```.*?```|`[^`\n]*`")
+)
+
+// Formats of the spoilers sent to networks which can't hide them.
+const (
+	SpoilerFormatHide  = "hide"
+	SpoilerFormatRot13 = "rot13"
+)
+
+// SpoilerHidden replaces spoilers with SpoilerFormatHide.
+const SpoilerHidden = "[spoiler hidden: click through on origin]"
+
+// ReplaceSpoilers replaces the spoilers of text by the result of replace with
+// their content, leaving code alone.
+func ReplaceSpoilers(text string, replace func(spoiler string) string) string {
+	if !strings.Contains(text, "||") {
+		return text
+	}
+
+	var out strings.Builder
+	out.Grow(len(text))
+	last := 0
+	for _, code := range codeRE.FindAllStringIndex(text, -1) {
+		out.WriteString(replaceSpoilers(text[last:code[0]], replace))
+		out.WriteString(text[code[0]:code[1]])
+		last = code[1]
+	}
+	out.WriteString(replaceSpoilers(text[last:], replace))
+	return out.String()
+}
+
+func replaceSpoilers(text string, replace func(spoiler string) string) string {
+	return spoilerRE.ReplaceAllStringFunc(text, func(m string) string {
+		return replace(m[2 : len(m)-2])
+	})
+}
+
+// HideSpoilers replaces the spoilers of text for networks which can't hide
+// them: by SpoilerHidden, or with their content in rot13 when format is
+// SpoilerFormatRot13.
+func HideSpoilers(text string, format string) string {
+	return ReplaceSpoilers(text, func(spoiler string) string {
+		if strings.EqualFold(format, SpoilerFormatRot13) {
+			return "[spoiler: " + Rot13(spoiler) + "]"
+		}
+		return SpoilerHidden
+	})
+}
+
+// Rot13 rotates the latin letters of text by 13 places.
+func Rot13(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,26 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHideSpoilers(t *testing.T) {
+	for text, expected := range map[string]string{
+		"":                         "",
+		"no spoiler || here":       "no spoiler || here",
+		"it was ||Bruce Willis||!": "it was " + SpoilerHidden + "!",
+		"||a|| and ||b||":          SpoilerHidden + " and " + SpoilerHidden,
+		"||multi\nline||":          SpoilerHidden,
+		"`a || b || c` ||d||":      "`a || b || c` " + SpoilerHidden,
+		"```\nx || y || z\n```":    "```\nx || y || z\n```",
+		"||||":                     "||||",
+		"||*bold* spoiler|| after": SpoilerHidden + " after",
+	} {
+		assert.Equal(t, expected, HideSpoilers(text, SpoilerFormatHide), text)
+	}
+
+	assert.Equal(t, "it was [spoiler: Oehpr Jvyyvf]!", HideSpoilers("it was ||Bruce Willis||!", "ROT13"))
+	assert.Equal(t, "Bruce Willis", Rot13(Rot13("Bruce Willis")))
+}
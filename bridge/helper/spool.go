@@ -0,0 +1,142 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+)
+
+// spoolPattern is the pattern of the names of the spool files, see
+// os.CreateTemp.
+const spoolPattern = "matterbridge-*"
+
+// spoolDirName is the subdirectory of MediaSpoolPath holding the spool files,
+// which matterbridge owns: the files left in it are removed on startup.
+const spoolDirName = "matterbridge-spool"
+
+// SpoolDir returns the directory of the spool files of mediaSpoolPath.
+func SpoolDir(mediaSpoolPath string) string {
+	return filepath.Join(mediaSpoolPath, spoolDirName)
+}
+
+// SpoolFile writes the data of fi to a file of the SpoolDir of MediaSpoolPath
+// when it is larger than MediaSpoolSize, and drops it from memory. The file is
+// passed by Path through the gateway. Files which can't be written stay in
+// memory.
+func SpoolFile(logger *logrus.Entry, fi *config.FileInfo, general *config.Protocol) {
+	if general.MediaSpoolPath == "" || fi.Path != "" || fi.Data == nil || len(*fi.Data) <= general.MediaSpoolSize {
+		return
+	}
+
+	f, err := os.CreateTemp(SpoolDir(general.MediaSpoolPath), spoolPattern+filepath.Ext(fi.Name))
+	if err != nil {
+		logger.Errorf("spooling %s failed, keeping it in memory: %s", fi.Name, err)
+		return
+	}
+	_, err = f.Write(*fi.Data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Errorf("spooling %s failed, keeping it in memory: %s", fi.Name, err)
+		os.Remove(f.Name())
+		return
+	}
+
+	logger.Debugf("Spooled %s (%d bytes) to %s", fi.Name, len(*fi.Data), f.Name())
+	fi.Size = int64(len(*fi.Data))
+	fi.Path = f.Name()
+	fi.Data = nil
+}
+
+// ErrFileTooLarge is returned by SpoolReader for the files larger than the
+// maximum size.
+var ErrFileTooLarge = errors.New("file too large")
+
+// SpoolReader reads the data of fi from r: in memory up to MediaSpoolSize,
+// and streamed to a file of the SpoolDir of MediaSpoolPath past it, so that
+// the large files are never held in memory. Without MediaSpoolPath, or when
+// the spool file can't be created, the data is read in memory. It fails with
+// ErrFileTooLarge once more than maxSize bytes are read.
+func SpoolReader(logger *logrus.Entry, fi *config.FileInfo, r io.Reader, maxSize int64, general *config.Protocol) error {
+	limited := io.LimitReader(r, maxSize+1)
+	inMemory := maxSize
+	if general.MediaSpoolPath != "" && int64(general.MediaSpoolSize) < maxSize {
+		inMemory = int64(general.MediaSpoolSize)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, limited, inMemory+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n > inMemory && n <= maxSize {
+		f, err := os.CreateTemp(SpoolDir(general.MediaSpoolPath), spoolPattern+filepath.Ext(fi.Name))
+		if err != nil {
+			logger.Errorf("spooling %s failed, keeping it in memory: %s", fi.Name, err)
+			if _, err := io.Copy(&buf, limited); err != nil {
+				return err
+			}
+			n = int64(buf.Len())
+		} else {
+			n, err = io.Copy(f, io.MultiReader(&buf, limited))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil || n > maxSize {
+				os.Remove(f.Name())
+			} else {
+				logger.Debugf("Spooled %s (%d bytes) to %s", fi.Name, n, f.Name())
+				fi.Path = f.Name()
+			}
+			if err != nil {
+				return fmt.Errorf("spooling %s failed: %w", fi.Name, err)
+			}
+		}
+	}
+	fi.Size = n
+	if n > maxSize {
+		return ErrFileTooLarge
+	}
+	if fi.Path != "" {
+		fi.Data = nil
+		return nil
+	}
+	data := buf.Bytes()
+	fi.Data = &data
+	return nil
+}
+
+// CleanSpool removes the spool files of dir older than maxAge, and returns the
+// number of removed files.
+func CleanSpool(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := strings.TrimSuffix(spoolPattern, "*")
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("removing %s failed: %w", entry.Name(), err)
+		}
+		removed++
+	}
+	return removed, nil
+}
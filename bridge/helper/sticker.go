@@ -0,0 +1,201 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sticker source formats returned by DetectStickerFormat.
+const (
+	StickerFormatTgs    = "tgs"    // gzipped lottie (telegram animated stickers)
+	StickerFormatLottie = "lottie" // plain lottie JSON (discord)
+	StickerFormatWebm   = "webm"   // telegram video stickers
+	StickerFormatWebP   = "webp"   // telegram/whatsapp static (and animated) stickers
+	StickerFormatPNG    = "png"
+	StickerFormatAPNG   = "apng" // discord animated stickers
+	StickerFormatGIF    = "gif"
+)
+
+// stickerExtensions are the file name suffixes bridges use for stickers, longest first.
+var stickerExtensions = []string{".tgs.webp", ".tgs", ".json", ".webm", ".webp", ".png", ".gif"}
+
+// DetectStickerFormat sniffs the format of sticker data, returning an empty
+// string when it is not a known sticker format.
+func DetectStickerFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return StickerFormatTgs
+	case bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("{")):
+		return StickerFormatLottie
+	case bytes.HasPrefix(data, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return StickerFormatWebm
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return StickerFormatWebP
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		// The animation control chunk of APNG comes before the first image data
+		idat := bytes.Index(data, []byte("IDAT"))
+		if actl := bytes.Index(data, []byte("acTL")); actl != -1 && (idat == -1 || actl < idat) {
+			return StickerFormatAPNG
+		}
+		return StickerFormatPNG
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return StickerFormatGIF
+	default:
+		return ""
+	}
+}
+
+// IsStickerOutputFormat returns whether format can be used for MediaConvertStickers.
+func IsStickerOutputFormat(format string) bool {
+	switch format {
+	case "png", "gif", "webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// ConvertSticker converts sticker data (tgs, lottie, webm, webp, apng, png, gif) to
+// format (png, gif or webp), and returns name with the matching extension.
+//
+// Static images are converted natively, lottie animations with the lottie backend
+// (see ConvertTgsToX) and everything else with ffmpeg, when it is installed.
+// On error, data and name are left untouched.
+func ConvertSticker(name string, data *[]byte, format string, logger *logrus.Entry) (string, error) {
+	if !IsStickerOutputFormat(format) {
+		return name, fmt.Errorf("unsupported sticker output format %q", format)
+	}
+
+	source := DetectStickerFormat(*data)
+
+	var err error
+	switch source {
+	case "":
+		return name, errors.New("unknown sticker format")
+	case format:
+		return stickerFileName(name, format), nil
+	case StickerFormatTgs, StickerFormatLottie:
+		if !SupportsFormat(format) {
+			return name, fmt.Errorf("%s does not support converting to %s", LottieBackend(), format)
+		}
+		err = ConvertTgsToX(data, format, logger)
+	case StickerFormatPNG, StickerFormatWebP, StickerFormatGIF:
+		if format == "webp" {
+			err = convertWithFFmpeg(data, source, format, logger)
+			break
+		}
+		err = convertStaticImage(data, format)
+	default:
+		err = convertWithFFmpeg(data, source, format, logger)
+	}
+	if err != nil {
+		return name, err
+	}
+
+	return stickerFileName(name, format), nil
+}
+
+// stickerFileName replaces the sticker extension of name by format.
+func stickerFileName(name string, format string) string {
+	for _, ext := range stickerExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+
+	return name + "." + format
+}
+
+// convertStaticImage re-encodes the first frame of a png, webp or gif image to png or gif.
+// The WebP decoder is registered by the golang.org/x/image/webp import in helper.go.
+func convertStaticImage(data *[]byte, format string) error {
+	img, _, err := image.Decode(bytes.NewReader(*data))
+	if err != nil {
+		return err
+	}
+
+	var w bytes.Buffer
+	if format == "gif" {
+		err = gif.Encode(&w, img, nil)
+	} else {
+		err = png.Encode(&w, img)
+	}
+	if err != nil {
+		return err
+	}
+
+	*data = w.Bytes()
+	return nil
+}
+
+// CanConvertWithFFmpeg checks whether the ffmpeg command used for video and animated stickers works.
+func CanConvertWithFFmpeg() error {
+	return exec.Command("ffmpeg", "-version").Run()
+}
+
+// convertWithFFmpeg converts data from the source to the target format with ffmpeg.
+// Like lottie, ffmpeg needs seekable files rather than pipes for some formats.
+func convertWithFFmpeg(data *[]byte, source string, format string, logger *logrus.Entry) error {
+	if source == StickerFormatAPNG {
+		source = "png"
+	}
+
+	tmpInFile, err := os.CreateTemp(os.TempDir(), "matterbridge-ffmpeg-input-*."+source)
+	if err != nil {
+		return err
+	}
+	tmpInFileName := tmpInFile.Name()
+	defer func() {
+		if removeErr := os.Remove(tmpInFileName); removeErr != nil {
+			logger.Errorf("Could not delete temporary (input) file %s: %v", tmpInFileName, removeErr)
+		}
+	}()
+
+	if _, writeErr := tmpInFile.Write(*data); writeErr != nil {
+		tmpInFile.Close()
+		return writeErr
+	}
+	if closeErr := tmpInFile.Close(); closeErr != nil {
+		return closeErr
+	}
+
+	tmpOutFileName := strings.TrimSuffix(tmpInFileName, "."+source) + "-output." + format
+	defer func() {
+		if removeErr := os.Remove(tmpOutFileName); removeErr != nil && !os.IsNotExist(removeErr) {
+			logger.Errorf("Could not delete temporary (output) file %s: %v", tmpOutFileName, removeErr)
+		}
+	}()
+
+	args := []string{"-y", "-loglevel", "error", "-i", tmpInFileName}
+	if format == "png" {
+		args = append(args, "-frames:v", "1")
+	} else {
+		args = append(args, "-loop", "0")
+	}
+	args = append(args, tmpOutFileName)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	dataContents, err := os.ReadFile(tmpOutFileName) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	*data = dataContents
+	return nil
+}
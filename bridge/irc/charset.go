@@ -1,7 +1,15 @@
 package birc
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/paulrosania/go-charset/charset"
 	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/encoding/korean"
 	"golang.org/x/text/encoding/simplifiedchinese"
@@ -21,12 +29,111 @@ var encoders = map[string]encoding.Encoding{
 	"gb18030":     simplifiedchinese.GB18030,
 }
 
-func toUTF8(from string, input string) string {
-	enc, ok := encoders[from]
-	if !ok {
-		return input
+// isUTF8Charset returns true when no transcoding is needed for name.
+func isUTF8Charset(name string) bool {
+	switch strings.ToLower(name) {
+	case "", "utf8", utf8charset:
+		return true
+	default:
+		return false
+	}
+}
+
+// lookupEncoding returns the encoding for a charset name. Besides our own
+// encoders, WHATWG and IANA names and aliases are accepted, with or without
+// dashes (eg. "latin-1", "cp1251", "koi8-r").
+func lookupEncoding(name string) (encoding.Encoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	for _, candidate := range []string{name, strings.ReplaceAll(name, "-", "")} {
+		if enc, ok := encoders[candidate]; ok {
+			return enc, true
+		}
+		if enc, err := htmlindex.Get(candidate); err == nil {
+			return enc, true
+		}
+		if enc, err := ianaindex.IANA.Encoding(candidate); err == nil && enc != nil {
+			return enc, true
+		}
+	}
+
+	return nil, false
+}
+
+// decodeCharset converts input from the named charset to UTF-8. Charsets
+// unknown to x/text are looked up in go-charset.
+func decodeCharset(name string, input string) (string, error) {
+	if enc, ok := lookupEncoding(name); ok {
+		return enc.NewDecoder().String(input)
+	}
+
+	r, err := charset.NewReader(name, strings.NewReader(input))
+	if err != nil {
+		return input, err
+	}
+
+	output, err := io.ReadAll(r)
+	if err != nil {
+		return input, err
+	}
+
+	return string(output), nil
+}
+
+// encodeCharset converts UTF-8 input to the named charset. Characters which
+// cannot be represented in the charset are replaced by a question mark.
+func encodeCharset(name string, input string) (string, error) {
+	if enc, ok := lookupEncoding(name); ok {
+		return encodeString(enc, input), nil
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := charset.NewWriter(name, buf)
+	if err != nil {
+		return input, err
+	}
+	fmt.Fprint(w, input)
+	if err := w.Close(); err != nil {
+		return input, err
+	}
+
+	return buf.String(), nil
+}
+
+func encodeString(enc encoding.Encoding, input string) string {
+	if output, err := enc.NewEncoder().String(input); err == nil {
+		return output
+	}
+
+	// Encode rune by rune to replace the unsupported ones only
+	var sb strings.Builder
+	encoder := enc.NewEncoder()
+	for _, r := range input {
+		output, err := encoder.String(string(r))
+		if err != nil {
+			sb.WriteByte('?')
+			continue
+		}
+		sb.WriteString(output)
+	}
+
+	return sb.String()
+}
+
+// charsetIn returns the charset of messages received from IRC, CharsetIn or Charset.
+func (b *Birc) charsetIn() string {
+	if b.IsKeySet("CharsetIn") {
+		return b.GetString("CharsetIn")
+	}
+
+	return b.GetString("Charset")
+}
+
+// charsetOut returns the charset of messages sent to IRC, CharsetOut or Charset.
+func (b *Birc) charsetOut() string {
+	if b.IsKeySet("CharsetOut") {
+		return b.GetString("CharsetOut")
 	}
 
-	res, _ := enc.NewDecoder().String(input)
-	return res
+	return b.GetString("Charset")
 }
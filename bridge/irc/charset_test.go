@@ -0,0 +1,42 @@
+package birc
+
+import (
+	"testing"
+)
+
+func TestCharsetRoundTrip(t *testing.T) {
+	cases := []struct {
+		charset string
+		text    string
+		raw     string
+	}{
+		{charset: "latin-1", text: "café", raw: "caf\xe9"},
+		{charset: "iso-8859-1", text: "naïve", raw: "na\xefve"},
+		{charset: "cp1251", text: "привет", raw: "\xef\xf0\xe8\xe2\xe5\xf2"},
+		{charset: "koi8-r", text: "мир", raw: "\xcd\xc9\xd2"},
+		{charset: "gbk", text: "你好", raw: "\xc4\xe3\xba\xc3"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.charset, func(t *testing.T) {
+			raw, err := encodeCharset(c.charset, c.text)
+			if err != nil || raw != c.raw {
+				t.Fatalf("encodeCharset(%q, %q) = %q, %v; want %q", c.charset, c.text, raw, err, c.raw)
+			}
+			text, err := decodeCharset(c.charset, c.raw)
+			if err != nil || text != c.text {
+				t.Fatalf("decodeCharset(%q, %q) = %q, %v; want %q", c.charset, c.raw, text, err, c.text)
+			}
+		})
+	}
+}
+
+func TestEncodeCharsetUnsupported(t *testing.T) {
+	raw, err := encodeCharset("latin1", "ok 👍")
+	if err != nil || raw != "ok ?" {
+		t.Fatalf("encodeCharset with unsupported rune = %q, %v", raw, err)
+	}
+	if !isUTF8Charset("UTF-8") || isUTF8Charset("latin1") {
+		t.Fatal("isUTF8Charset is wrong")
+	}
+}
@@ -1,9 +1,7 @@
 package birc
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"strconv"
 	"strings"
 	"time"
@@ -12,7 +10,6 @@ import (
 	"github.com/lrstanley/girc"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
 	"github.com/matterbridge-org/matterbridge/bridge/helper"
-	"github.com/paulrosania/go-charset/charset"
 	"github.com/saintfish/chardet"
 
 	// We need to import the 'data' package as an implicit dependency.
@@ -32,24 +29,28 @@ const (
 // If we received the message from another IRC bridge, the text should have already been converted to UTF-8.
 // If from any other bridge type, no conversion is needed, as all other supported bridge types use UTF-8 exclusively.
 //
-// TODO: rework this using x/text/transform and x/text/encoding packages instead of go-charset
+// The text is converted to CharsetOut (or Charset), as well as the nick prefix unless
+// UseRelayMsg is set, since RELAYMSG nicks are sanitized for the server casemapping.
 func (b *Birc) handleCharset(msg *config.Message) error {
-	if b.GetString("Charset") != "autodetect" {
-		switch b.GetString("Charset") {
-		case "utf8", utf8charset:
-			break
-		default:
-			buf := new(bytes.Buffer)
-			w, err := charset.NewWriter(b.GetString("Charset"), buf)
-			if err != nil {
-				b.Log.Errorf("utf-8 to charset conversion failed: %s", err)
-				return err
-			}
-			fmt.Fprint(w, msg.Text)
-			w.Close()
-			// TODO: String() apparently converts to utf8.  Does that mess anything up here?
-			msg.Text = buf.String()
+	out := b.charsetOut()
+	if out == "autodetect" || isUTF8Charset(out) {
+		return nil
+	}
+
+	text, err := encodeCharset(out, msg.Text)
+	if err != nil {
+		b.Log.Errorf("utf-8 to %s conversion failed: %s", out, err)
+		return err
+	}
+	msg.Text = text
+
+	if !b.GetBool("UseRelayMsg") {
+		username, err := encodeCharset(out, msg.Username)
+		if err != nil {
+			b.Log.Errorf("utf-8 to %s conversion failed: %s", out, err)
+			return err
 		}
+		msg.Username = username
 	}
 
 	return nil
@@ -83,6 +84,9 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 			//
 			// Here we just produce an error to be announced and logged, hoping the
 			// matterbridge operator will finally enable the media server.
+			if fi.Comment != "" {
+				b.Local <- config.Message{Text: fi.Comment, Username: msg.Username, Channel: msg.Channel, Event: msg.Event}
+			}
 			msg.Text = fmt.Sprintf("Could not share file %s (no mediaserver configured)", fi.Name)
 			b.Local <- config.Message{Text: msg.Text, Username: "<matterbridge>", Channel: msg.Channel, Event: msg.Event}
 
@@ -94,10 +98,9 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 		// File has a public URL, either because it's provided by the remote bridge,
 		// or because the media server is enabled. Share it alongside the
 		// attachment caption, if any.
-		if fi.Comment == "" {
-			msg.Text = fi.URL
-		} else {
-			msg.Text = fi.Comment + " : " + fi.URL
+		msg.Text = helper.FormatAttachment(fi, b.GetString("AttachmentFormat"))
+		if fi.Comment != "" {
+			msg.Text = fi.Comment + " : " + msg.Text
 		}
 
 		b.Local <- config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event}
@@ -106,6 +109,27 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 	return true
 }
 
+// handleIdentity logs the IRCv3 account-notify and chghost changes of the
+// users, which girc tracks for userID.
+func (b *Birc) handleIdentity(client *girc.Client, event girc.Event) {
+	if event.Source == nil || len(event.Params) == 0 {
+		return
+	}
+	switch event.Command {
+	case girc.CAP_ACCOUNT:
+		if event.Params[0] == "*" {
+			b.Log.Debugf("%s logged out of their account", event.Source.Name)
+			return
+		}
+		b.Log.Debugf("%s logged in as %s", event.Source.Name, event.Params[0])
+	case girc.CAP_CHGHOST:
+		if len(event.Params) < 2 {
+			return
+		}
+		b.Log.Debugf("%s changed host to %s@%s", event.Source.Name, event.Params[0], event.Params[1])
+	}
+}
+
 func (b *Birc) handleInvite(client *girc.Client, event girc.Event) {
 	defer b.ircHandlePanic()
 
@@ -215,6 +239,7 @@ func (b *Birc) handleJoinPart(client *girc.Client, event girc.Event) {
 			Text:     text,
 			Channel:  channel,
 			Account:  b.Account,
+			UserID:   b.userID(event),
 			Event:    config.EventJoinLeave,
 		}
 
@@ -472,6 +497,9 @@ func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	i.Handlers.Clear(girc.RPL_ISUPPORT)
 	i.Handlers.Clear(girc.CAP)
 	i.Handlers.Clear("FAIL")
+	i.Handlers.Clear("BATCH")
+	i.Handlers.Clear(girc.CAP_ACCOUNT)
+	i.Handlers.Clear(girc.CAP_CHGHOST)
 
 	// Foregrounded handlers for the same event will still be executed concurrently,
 	// but they will all be placed in the same sync.WaitGroup,
@@ -479,6 +507,7 @@ func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	// Still-running backgrounded handlers will be simply abandoned,
 	// therefore try to make sure they won't have any active mutex locks.
 
+	i.Handlers.Add("BATCH", b.handleBatch) // foregrounded so batches are known before their messages
 	i.Handlers.AddBg("PRIVMSG", b.handlePrivMsg)
 	i.Handlers.AddBg(girc.RPL_TOPICWHOTIME, b.handleTopicWhoTime)
 	i.Handlers.AddBg(girc.NOTICE, b.handleNotice)
@@ -489,6 +518,8 @@ func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	i.Handlers.AddBg(girc.KICK, b.handleJoinPartKICK) // Background this because it sleeps, but need to figure out an alternative.
 	i.Handlers.AddBg(girc.KICK, b.handleJoinPart)     // Relay kicks of other channel members as usual
 	i.Handlers.Add("INVITE", b.handleInvite)          // handleInvite obtains a read lock, so make sure it comes home
+	i.Handlers.AddBg(girc.CAP_ACCOUNT, b.handleIdentity)
+	i.Handlers.AddBg(girc.CAP_CHGHOST, b.handleIdentity)
 
 	i.Handlers.Add(girc.RPL_ISUPPORT, b.handleISupportBOT) // enable bot mode
 	i.Handlers.Add(girc.RPL_ISUPPORT, b.handleISupportCM)  // determine casemapping value
@@ -591,7 +622,7 @@ func (b *Birc) handleOtherAuth(client *girc.Client, event girc.Event) {
 }
 
 func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
-	if b.skipPrivMsg(event) {
+	if b.handleQueryCommand(event) || b.skipPrivMsg(event) || b.skipPlayback(event) {
 		return
 	}
 
@@ -599,7 +630,7 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		Username: event.Source.Name,
 		Channel:  strings.ToLower(event.Params[0]),
 		Account:  b.Account,
-		UserID:   event.Source.Ident + "@" + event.Source.Host,
+		UserID:   b.userID(event),
 	}
 
 	b.Log.Debugf("== Receiving PRIVMSG: %s %s %#v", event.Source.Name, event.Last(), event)
@@ -622,14 +653,12 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 	// we'll treat it as a byte slice first, convert to utf-8 if needed, then do our own version of StripAction
 	rmsg.Text = event.Params[len(event.Params)-1]
 
-	mycharset := b.GetString("Charset")
+	mycharset := b.charsetIn()
 
-	switch mycharset {
-	case "utf8", utf8charset:
+	switch {
+	case isUTF8Charset(mycharset):
 		break
-	case "gbk", "gb18030", "gb2312", "big5", "euc-kr", "euc-jp", "shift-jis", "iso-2022-jp":
-		rmsg.Text = toUTF8(mycharset, rmsg.Text)
-	case "autodetect": // start detecting the charset.  fixes #120 (mostly)
+	case mycharset == "autodetect": // start detecting the charset.  fixes #120 (mostly)
 		if utf8.ValidString(rmsg.Text) { // check for valid utf-8 before any other checks
 			break
 		}
@@ -648,15 +677,12 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		}
 		fallthrough
 	default:
-		r, err := charset.NewReader(mycharset, strings.NewReader(rmsg.Text))
+		text, err := decodeCharset(mycharset, rmsg.Text)
 		if err != nil {
-			b.Log.Errorf("charset to utf-8 conversion failed: %s", err)
+			b.Log.Errorf("%s to utf-8 conversion failed: %s", mycharset, err)
 			return
 		}
-
-		output, _ := io.ReadAll(r)
-
-		rmsg.Text = string(output)
+		rmsg.Text = text
 	}
 
 	// let's make sure only to modify the message text AFTER the possible utf-8 conversion.
@@ -669,6 +695,59 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 	b.Remote <- rmsg
 }
 
+// userID returns the services account of the sender of event, which doesn't
+// change with their nick or host, else their ident@host. The account is the
+// IRCv3 account-tag of the message, else the one girc tracks from
+// extended-join, account-notify and WHOX.
+func (b *Birc) userID(event girc.Event) string {
+	account := ""
+	if b.i != nil && event.Source != nil {
+		if user := b.i.LookupUser(event.Source.Name); user != nil {
+			account = user.Extras.Account
+		}
+	}
+	return eventUserID(event, account)
+}
+
+// eventUserID returns the UserID of the sender of event, whose tracked
+// account is account.
+func eventUserID(event girc.Event, account string) string {
+	if tag, ok := event.Tags.Get("account"); ok && tag != "" && tag != "*" {
+		return tag
+	}
+	if account != "" && account != "*" {
+		return account
+	}
+	if event.Source == nil {
+		return ""
+	}
+	return event.Source.Ident + "@" + event.Source.Host
+}
+
+// handleQueryCommand sends the private messages to the bot to the gateway as
+// control commands when Commands is enabled, the replies are sent back to
+// the user. Returns true if event was such a message.
+func (b *Birc) handleQueryCommand(event girc.Event) bool {
+	if !b.GetBool("Commands") || event.Command != girc.PRIVMSG || event.Source == nil ||
+		!strings.EqualFold(event.Params[0], b.i.GetNick()) {
+		return false
+	}
+	if ok, _ := event.IsCTCP(); ok {
+		return false
+	}
+
+	b.Log.Debugf("== Receiving command from %s: %s", event.Source.Name, event.Last())
+	b.Remote <- config.Message{
+		Event:    config.EventCommand,
+		Text:     event.Last(),
+		Username: event.Source.Name,
+		UserID:   b.userID(event),
+		Channel:  event.Source.Name,
+		Account:  b.Account,
+	}
+	return true
+}
+
 func (b *Birc) handleRunCommands() {
 	for _, cmd := range b.GetStringSlice("RunCommands") {
 		cmd = strings.ReplaceAll(cmd, "{BOTNICK}", b.Nick)
@@ -1,9 +1,11 @@
 package birc
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/lrstanley/girc"
+	"github.com/matterbridge-org/matterbridge/bridge"
 )
 
 func TestFormatJoinLeaveText(t *testing.T) {
@@ -65,3 +67,51 @@ func TestFormatJoinLeaveText(t *testing.T) {
 		})
 	}
 }
+
+func TestEventUserID(t *testing.T) {
+	source := &girc.Source{Name: "alice_", Ident: "alice", Host: "home.example"}
+
+	cases := []struct {
+		name    string
+		event   girc.Event
+		account string
+		want    string
+	}{
+		{"account-tag", girc.Event{Source: source, Tags: girc.Tags{"account": "alice"}}, "", "alice"},
+		{"tracked account", girc.Event{Source: source}, "alice", "alice"},
+		{"logged out", girc.Event{Source: source, Tags: girc.Tags{"account": "*"}}, "*", "alice@home.example"},
+		{"no account", girc.Event{Source: source}, "", "alice@home.example"},
+		{"no source", girc.Event{}, "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := eventUserID(c.event, c.account); got != c.want {
+				t.Errorf("eventUserID() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	b := &Birc{}
+
+	cases := []struct {
+		name string
+		err  error
+		want bridge.ErrorClass
+	}{
+		{"k-line", &girc.EventError{Event: &girc.Event{Command: "ERROR", Params: []string{"Closing Link: bot (K-Lined)"}}}, bridge.ErrorAuth},
+		{"sasl", &girc.EventError{Event: &girc.Event{Command: "ERROR", Params: []string{"SASL authentication failed"}}}, bridge.ErrorAuth},
+		{"timeout", &girc.EventError{Event: &girc.Event{Command: "ERROR", Params: []string{"Closing Link: bot (Ping timeout)"}}}, bridge.ErrorUnknown},
+		{"network", errors.New("connection refused"), bridge.ErrorUnknown},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := bridge.ErrorClassOf(b.ClassifyError(c.err)); got != c.want {
+				t.Errorf("ClassifyError() class = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package birc
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -44,6 +45,12 @@ type Birc struct {
 	CasemapFailures                           int                     // Count of casemapping errors
 	RelayMsgFailures                          int                     // Count of general relaymsg errors
 
+	// bouncer playback detection, see playback.go
+	startTime   time.Time
+	playbackMu  sync.Mutex
+	batches     map[string]*batch
+	lastRelayed map[string]time.Time // time of the last message relayed per channel
+
 	*bridge.Config
 }
 
@@ -58,6 +65,9 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b.connected = make(chan error)
 	b.names = make(map[string][]string)
 	b.channels = make(map[string]bool)
+	b.startTime = time.Now()
+	b.batches = make(map[string]*batch)
+	b.lastRelayed = make(map[string]time.Time)
 
 	if b.GetInt("MessageDelay") == 0 {
 		b.MessageDelay = 1300
@@ -171,6 +181,20 @@ func (b *Birc) Disconnect() error {
 	return nil
 }
 
+// Alive implements bridge.Heartbeater.
+func (b *Birc) Alive() error {
+	if !b.i.IsConnected() {
+		return errors.New("not connected to the server")
+	}
+	return nil
+}
+
+// Ping implements bridge.Heartbeater. girc pings the server itself and
+// disconnects when it doesn't answer, which Alive reports.
+func (b *Birc) Ping(ctx context.Context) error {
+	return nil
+}
+
 func (b *Birc) JoinChannel(channel config.ChannelInfo) error {
 	b.channelsChan <- channel
 
@@ -237,7 +261,7 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 	//
 	// For now, we'll repurpose the MessageSplit setting to hand off the whole message to girc when set to false.
 	if b.GetBool("MessageSplit") {
-		msgLines := helper.GetSubLinesWords(msg.Text, b.MessageLength-prefix, b.GetString("MessageClipped"))
+		msgLines := helper.SplitLines(msg.Text, b.MessageLength-prefix, b.GetBool("MessageSplitNumbered"))
 		for i := range msgLines {
 			if len(b.Local) >= b.MessageQueue {
 				b.Log.Debugf("flooding, dropping message (queue at %d)", len(b.Local))
@@ -287,9 +311,21 @@ func (b *Birc) doConnect() {
 	for {
 		// TODO: support connecting using a proxy
 		// Since we're doing connections and joins asynchronously now, we can afford a generous timeout here
-		err := b.i.DialerConnect(&net.Dialer{Timeout: 500 * time.Second})
+		err := b.i.DialerConnect(b.Dialer(500 * time.Second))
 		if err != nil {
 			b.Log.Errorf("disconnect: error: %s", err)
+			if err = b.ClassifyError(err); bridge.ErrorClassOf(err) == bridge.ErrorAuth {
+				// the gateway connects the bridge again, with its standby
+				// credentials if it has some
+				b.Remote <- config.Message{
+					Username: "system",
+					Text:     "reconnect",
+					Account:  b.Account,
+					Event:    config.EventFailure,
+					Extra:    map[string][]interface{}{config.EventFailure: {err}},
+				}
+				return
+			}
 			if b.FirstConnection {
 				// try again
 				// this will completely spam the log if the server port is closed, so sleep a bit
@@ -320,6 +356,26 @@ func (b *Birc) doConnect() {
 	}
 }
 
+// refusedConnection are the texts of the ERROR sent by the servers refusing
+// the bot: bans (K-Lined, G-Lined, ...) and failed SASL authentications.
+var refusedConnection = []string{"-lined", "banned", "sasl", "authentication failed"}
+
+// ClassifyError wraps the errors of the servers refusing the bot as
+// authentication errors.
+func (b *Birc) ClassifyError(err error) error {
+	var eventErr *girc.EventError
+	if !errors.As(err, &eventErr) {
+		return err
+	}
+	text := strings.ToLower(eventErr.Error())
+	for _, refused := range refusedConnection {
+		if strings.Contains(text, refused) {
+			return bridge.NewError(bridge.ErrorAuth, err)
+		}
+	}
+	return err
+}
+
 func (b *Birc) doSend() {
 	rate := time.Millisecond * time.Duration(b.MessageDelay)
 	throttle := time.NewTicker(rate)
@@ -340,7 +396,8 @@ func (b *Birc) doSend() {
 		// Optional support for the proposed RELAYMSG extension, described at
 		// https://github.com/jlu5/ircv3-specifications/blob/master/extensions/relaymsg.md
 		// nolint:nestif
-		if b.GetBool("UseRelayMsg") { // Let's check this by itself first.
+		// announcements are sent by the bot itself, to the operators only
+		if b.GetBool("UseRelayMsg") && msg.Event != config.EventAnnounce { // Let's check this by itself first.
 			// Avoid needlessly querying the irc lib on each msg, in case it takes out any locks
 			if b.i.HasCapability("overdrivenetworks.com/relaymsg") || b.i.HasCapability("draft/relaymsg") {
 				// nick is now sanitized in gateway.go
@@ -400,6 +457,9 @@ func (b *Birc) doSend() {
 		case config.EventNoticeIRC:
 			cmdline = fmt.Sprintf("NOTICE %s :%s", msg.Channel, username+msg.Text)
 			b.Log.Debugf("Sending notice to channel %s", msg.Channel)
+		case config.EventAnnounce:
+			cmdline = fmt.Sprintf("NOTICE %s :%s", b.operatorsTarget(msg.Channel), username+msg.Text)
+			b.Log.Debugf("Sending announcement to channel %s", msg.Channel)
 		default:
 			cmdline = fmt.Sprintf("PRIVMSG %s :%s", msg.Channel, username+msg.Text)
 			b.Log.Debugf("Sending to channel %s", msg.Channel)
@@ -552,6 +612,8 @@ func (b *Birc) handlePrefix(msg *config.Message) int {
 	// account for spaces, command names, and other padding
 	// TODO: make these len()'s into constants?  But the go compiler does that anyway, so no performance loss here
 	switch {
+	case msg.Event == config.EventAnnounce:
+		prefix += len("NOTICE @ :")
 	case b.GetBool("UseRelayMsg"):
 		switch msg.Event {
 		case config.EventUserAction:
@@ -587,6 +649,16 @@ func (b *Birc) handlePrefix(msg *config.Message) int {
 	return prefix
 }
 
+// operatorsTarget returns the STATUSMSG target of channel reaching its
+// operators only, or channel when the server doesn't support it.
+func (b *Birc) operatorsTarget(channel string) string {
+	if prefixes, ok := b.i.GetServerOption("STATUSMSG"); ok && strings.Contains(prefixes, "@") {
+		return "@" + channel
+	}
+	b.Log.Debugf("STATUSMSG isn't supported, announcing to all of %s", channel)
+	return channel
+}
+
 // TODO: Add a check for any locks still active, for debug-mode only
 // We may or may not want to override the Bridge.handlePanic() method instead, TBD
 func (b *Birc) ircHandlePanic() {
@@ -654,13 +726,34 @@ func (b *Birc) formatnicks(nicks []string) string {
 }
 
 func (b *Birc) getTLSConfig() (*tls.Config, error) {
-	server, _, _ := net.SplitHostPort(b.GetString("server"))
+	server, port, _ := net.SplitHostPort(b.GetString("server"))
 
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: b.GetBool("skiptlsverify"), //nolint:gosec
 		ServerName:         server,
 	}
 
+	pins, err := parseFingerprints(b.GetStringSlice("TLSFingerprints"))
+	if err != nil {
+		return nil, err
+	}
+	var resolver string
+	if b.GetBool("UseDANE") {
+		if resolver, err = b.daneResolver(); err != nil {
+			return nil, err
+		}
+	}
+	if len(pins) > 0 || resolver != "" {
+		if tlsConfig.InsecureSkipVerify {
+			b.Log.Warn("SkipTLSVerify is ignored with TLSFingerprints or UseDANE")
+		}
+		// the certificate is verified by verifyConnection instead
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return b.verifyConnection(cs, pins, resolver, port)
+		}
+	}
+
 	if filename := b.GetString("TLSClientCertificate"); filename != "" {
 		cert, err := tls.LoadX509KeyPair(filename, filename)
 		if err != nil {
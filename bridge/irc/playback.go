@@ -0,0 +1,141 @@
+package birc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// BouncerPlayback values.
+const (
+	// playbackDedupe relays the played back messages which are newer than the
+	// last message relayed from the channel, ie. the messages missed while
+	// matterbridge was disconnected from the bouncer.
+	playbackDedupe = "dedupe"
+	// playbackSkip never relays played back messages.
+	playbackSkip = "skip"
+	// playbackRelay relays played back messages like live ones.
+	playbackRelay = "relay"
+)
+
+const (
+	// playbackTolerance is how old a timestamped message can be before it is
+	// considered played back, to allow for clock skew with the server.
+	playbackTolerance = 30 * time.Second
+	// batchRetention is how long ended batches are remembered, as messages are
+	// handled in the background and can be handled after the end of their batch.
+	batchRetention = time.Minute
+	// zncMarkerSource is the nick ZNC uses for the "Buffer Playback..." and
+	// "Playback Complete." markers around playback without server-time.
+	zncMarkerSource = "***"
+)
+
+// batch is an IRCv3 batch, see https://ircv3.net/specs/extensions/batch
+type batch struct {
+	kind  string
+	ended time.Time
+}
+
+func (b *Birc) playbackMode() string {
+	switch mode := strings.ToLower(b.GetString("BouncerPlayback")); mode {
+	case playbackSkip, playbackRelay:
+		return mode
+	default:
+		return playbackDedupe
+	}
+}
+
+// handleBatch records the type of the batches opened by the server, so that
+// messages of chathistory batches (eg. sent by soju) are known to be played back.
+func (b *Birc) handleBatch(client *girc.Client, event girc.Event) {
+	if len(event.Params) == 0 || len(event.Params[0]) < 2 {
+		return
+	}
+	ref := event.Params[0][1:]
+
+	b.playbackMu.Lock()
+	defer b.playbackMu.Unlock()
+
+	switch event.Params[0][0] {
+	case '+':
+		for id, old := range b.batches {
+			if !old.ended.IsZero() && time.Since(old.ended) > batchRetention {
+				delete(b.batches, id)
+			}
+		}
+		kind := ""
+		if len(event.Params) > 1 {
+			kind = event.Params[1]
+		}
+		b.batches[ref] = &batch{kind: kind}
+	case '-':
+		if open, ok := b.batches[ref]; ok {
+			open.ended = time.Now()
+		}
+	}
+}
+
+// isPlayback returns true if the message was played back by a bouncer rather
+// than sent live: it is part of a chathistory batch, or its server-time is
+// older than when it was received.
+func (b *Birc) isPlayback(event girc.Event, received time.Time) bool {
+	if ref, ok := event.Tags.Get("batch"); ok {
+		b.playbackMu.Lock()
+		open, known := b.batches[ref]
+		b.playbackMu.Unlock()
+		if known && strings.HasSuffix(open.kind, "chathistory") {
+			return true
+		}
+	}
+	if _, ok := event.Tags.Get("time"); ok {
+		return event.Timestamp.Before(received.Add(-playbackTolerance))
+	}
+	return false
+}
+
+// skipPlayback returns true if the message shouldn't be relayed because it was
+// played back by a bouncer, according to the BouncerPlayback setting. It keeps
+// track of the time of the last message relayed from every channel.
+func (b *Birc) skipPlayback(event girc.Event) bool {
+	mode := b.playbackMode()
+	if mode == playbackRelay {
+		return false
+	}
+	if event.Source != nil && event.Source.Name == zncMarkerSource {
+		b.Log.Debugf("skipping bouncer playback marker: %s", event.Last())
+		return true
+	}
+
+	channel := strings.ToLower(event.Params[0])
+	if !b.isPlayback(event, time.Now()) {
+		b.markRelayed(channel, event.Timestamp)
+		return false
+	}
+	if mode == playbackSkip {
+		b.Log.Debugf("skipping message played back by the bouncer on %s from %s", channel, event.Timestamp)
+		return true
+	}
+
+	b.playbackMu.Lock()
+	last, ok := b.lastRelayed[channel]
+	b.playbackMu.Unlock()
+	if !ok {
+		last = b.startTime
+	}
+	if !event.Timestamp.After(last) {
+		b.Log.Debugf("skipping message played back by the bouncer on %s from %s, already relayed", channel, event.Timestamp)
+		return true
+	}
+	b.markRelayed(channel, event.Timestamp)
+	return false
+}
+
+func (b *Birc) markRelayed(channel string, ts time.Time) {
+	b.playbackMu.Lock()
+	defer b.playbackMu.Unlock()
+
+	if ts.After(b.lastRelayed[channel]) {
+		b.lastRelayed[channel] = ts
+	}
+}
@@ -0,0 +1,92 @@
+package birc
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPlaybackBirc(mode string) *Birc {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[irc.test]\nBouncerPlayback=\""+mode+"\"\n"))
+	return &Birc{
+		Config: &bridge.Config{Bridge: &bridge.Bridge{
+			Account: "irc.test",
+			Config:  cfg,
+			Log:     logrus.NewEntry(logger),
+		}},
+		startTime:   time.Now().Add(-time.Hour),
+		batches:     make(map[string]*batch),
+		lastRelayed: make(map[string]time.Time),
+	}
+}
+
+func playbackEvent(ts time.Time, tags girc.Tags) girc.Event {
+	if tags == nil {
+		tags = girc.Tags{}
+	}
+	if !ts.IsZero() {
+		tags["time"] = ts.UTC().Format("2006-01-02T15:04:05.000Z")
+	} else {
+		ts = time.Now()
+	}
+	return girc.Event{
+		Source:    &girc.Source{Name: "alice"},
+		Command:   "PRIVMSG",
+		Params:    []string{"#Chan", "hello"},
+		Tags:      tags,
+		Timestamp: ts,
+	}
+}
+
+func TestSkipPlaybackDedupe(t *testing.T) {
+	b := newPlaybackBirc("")
+	now := time.Now()
+
+	// Older than the start of the bridge
+	assert.True(t, b.skipPlayback(playbackEvent(now.Add(-2*time.Hour), nil)))
+	// Missed while disconnected from the bouncer
+	assert.False(t, b.skipPlayback(playbackEvent(now.Add(-30*time.Minute), nil)))
+	// Live messages, with or without server-time
+	assert.False(t, b.skipPlayback(playbackEvent(now.Add(-time.Second), nil)))
+	assert.False(t, b.skipPlayback(playbackEvent(time.Time{}, nil)))
+
+	// Played back again after a reconnect
+	assert.True(t, b.skipPlayback(playbackEvent(now.Add(-30*time.Minute), nil)))
+	assert.True(t, b.skipPlayback(playbackEvent(now.Add(-10*time.Minute), nil)))
+
+	// ZNC markers
+	marker := playbackEvent(time.Time{}, nil)
+	marker.Source.Name = "***"
+	assert.True(t, b.skipPlayback(marker))
+}
+
+func TestSkipPlaybackBatch(t *testing.T) {
+	b := newPlaybackBirc("skip")
+
+	b.handleBatch(nil, girc.Event{Command: "BATCH", Params: []string{"+abc", "chathistory", "#chan"}})
+	b.handleBatch(nil, girc.Event{Command: "BATCH", Params: []string{"+def", "netsplit"}})
+	// chathistory batches are played back, even with a recent timestamp
+	assert.True(t, b.skipPlayback(playbackEvent(time.Now(), girc.Tags{"batch": "abc"})))
+	assert.False(t, b.skipPlayback(playbackEvent(time.Now(), girc.Tags{"batch": "def"})))
+
+	// messages can be handled after the end of their batch
+	b.handleBatch(nil, girc.Event{Command: "BATCH", Params: []string{"-abc"}})
+	assert.True(t, b.skipPlayback(playbackEvent(time.Now(), girc.Tags{"batch": "abc"})))
+
+	assert.True(t, b.skipPlayback(playbackEvent(time.Now().Add(-30*time.Minute), nil)))
+}
+
+func TestSkipPlaybackRelay(t *testing.T) {
+	b := newPlaybackBirc("relay")
+
+	assert.False(t, b.skipPlayback(playbackEvent(time.Now().Add(-2*time.Hour), nil)))
+	assert.False(t, b.skipPlayback(playbackEvent(time.Now().Add(-2*time.Hour), nil)))
+}
@@ -0,0 +1,412 @@
+package birc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The fields of the TLSA records (RFC 6698).
+const (
+	// PKIX-TA and PKIX-EE: the certificate is validated by the CAs, and
+	// issued by the one of the record or is it
+	tlsaPKIXTA = 0
+	tlsaPKIXEE = 1
+	// DANE-TA and DANE-EE: the same without the CAs
+	tlsaDANETA = 2
+	tlsaDANEEE = 3
+
+	tlsaSelectorCert = 0
+	tlsaSelectorSPKI = 1
+
+	tlsaMatchFull   = 0
+	tlsaMatchSHA256 = 1
+	tlsaMatchSHA512 = 2
+)
+
+const typeTLSA dnsmessage.Type = 52
+
+// daneTimeout is how long the TLSA records are looked up for.
+const daneTimeout = 10 * time.Second
+
+// defaultResolver is the resolver of the TLSA records when resolv.conf has
+// none, it must validate DNSSEC.
+const defaultResolver = "127.0.0.1:53"
+
+// resolvConf is where the nameservers are read from.
+var resolvConf = "/etc/resolv.conf"
+
+// pkixRoots are the CAs validating the certificates without TLSA records, nil
+// for the CAs of the system.
+var pkixRoots *x509.CertPool
+
+var errNoTLSAMatch = errors.New("the certificate of the server matches none of its TLSA records or TLSFingerprints")
+
+// tlsaRecord is a TLSA record, which tells the certificate of a TLS server or
+// of its CA.
+type tlsaRecord struct {
+	usage        uint8
+	selector     uint8
+	matchingType uint8
+	data         []byte
+}
+
+// matches returns true if cert is the one of the record.
+func (r tlsaRecord) matches(cert *x509.Certificate) bool {
+	var data []byte
+	switch r.selector {
+	case tlsaSelectorCert:
+		data = cert.Raw
+	case tlsaSelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch r.matchingType {
+	case tlsaMatchFull:
+	case tlsaMatchSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case tlsaMatchSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(data, r.data)
+}
+
+// parseFingerprints returns the records matching the certificates of the
+// SHA-256 fingerprints, of the certificate or of its public key, in hex with
+// or without colons. They match the certificate of the server or of a CA it
+// is issued by.
+func parseFingerprints(fingerprints []string) ([]tlsaRecord, error) {
+	var records []tlsaRecord
+	for _, fingerprint := range fingerprints {
+		data, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(fingerprint), "sha256:"), ":", ""))
+		if err != nil || len(data) != sha256.Size {
+			return nil, fmt.Errorf("invalid TLSFingerprints %q, not a SHA-256 fingerprint", fingerprint)
+		}
+		for _, usage := range []uint8{tlsaDANEEE, tlsaDANETA} {
+			for _, selector := range []uint8{tlsaSelectorCert, tlsaSelectorSPKI} {
+				records = append(records, tlsaRecord{usage: usage, selector: selector, matchingType: tlsaMatchSHA256, data: data})
+			}
+		}
+	}
+	return records, nil
+}
+
+// verifyTLSA returns nil if the certificates of the connection match one of
+// records. roots are the CAs of the PKIX records, nil for those of the
+// system.
+func verifyTLSA(cs tls.ConnectionState, records []tlsaRecord, roots *x509.CertPool) error {
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("the server sent no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	verify := func(roots *x509.CertPool) ([][]*x509.Certificate, error) {
+		return certs[0].Verify(x509.VerifyOptions{DNSName: cs.ServerName, Roots: roots, Intermediates: intermediates})
+	}
+
+	var pkixChains [][]*x509.Certificate
+	var pkixErr error
+	pkixVerified := false
+	for _, r := range records {
+		switch r.usage {
+		case tlsaDANEEE:
+			if r.matches(certs[0]) {
+				return nil
+			}
+		case tlsaDANETA:
+			anchors := certs[1:]
+			// the full certificate of the CA can be in the record only
+			if r.selector == tlsaSelectorCert && r.matchingType == tlsaMatchFull {
+				if cert, err := x509.ParseCertificate(r.data); err == nil {
+					anchors = append(anchors, cert)
+				}
+			}
+			for _, anchor := range anchors {
+				if !r.matches(anchor) {
+					continue
+				}
+				pool := x509.NewCertPool()
+				pool.AddCert(anchor)
+				if _, err := verify(pool); err == nil {
+					return nil
+				}
+			}
+		case tlsaPKIXTA, tlsaPKIXEE:
+			if !pkixVerified {
+				pkixChains, pkixErr = verify(roots)
+				pkixVerified = true
+			}
+			if pkixErr != nil {
+				continue
+			}
+			if r.usage == tlsaPKIXEE {
+				if r.matches(certs[0]) {
+					return nil
+				}
+				continue
+			}
+			for _, chain := range pkixChains {
+				for _, cert := range chain[1:] {
+					if r.matches(cert) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	if pkixErr != nil {
+		return fmt.Errorf("%w: %w", errNoTLSAMatch, pkixErr)
+	}
+	return errNoTLSAMatch
+}
+
+// verifyPKIX validates the certificates of the connection with the CAs of the
+// system, as crypto/tls does without InsecureSkipVerify.
+func verifyPKIX(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("the server sent no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: cs.ServerName, Intermediates: intermediates, Roots: pkixRoots})
+	return err
+}
+
+// verifyConnection validates the certificate of the server with its
+// TLSFingerprints, then with its TLSA records looked up on resolver when
+// UseDANE is set. Without TLSA records secured by DNSSEC, it is validated by
+// the CAs of the system, unless TLSFingerprints are set: a certificate
+// matching none of them is then only accepted by a TLSA record.
+func (b *Birc) verifyConnection(cs tls.ConnectionState, pins []tlsaRecord, resolver string, port string) error {
+	var pinErr error
+	if len(pins) > 0 {
+		pinErr = verifyTLSA(cs, pins, nil)
+		if pinErr == nil || resolver == "" {
+			return pinErr
+		}
+	}
+	if resolver == "" {
+		return verifyPKIX(cs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daneTimeout)
+	defer cancel()
+	name := "_" + port + "._tcp." + cs.ServerName
+	records, err := lookupTLSA(ctx, resolver, name)
+	if err != nil {
+		// a failed DNSSEC validation looks like a failed lookup
+		return fmt.Errorf("looking up the TLSA records of %s failed: %w", name, err)
+	}
+	if len(records) == 0 && pinErr != nil {
+		return fmt.Errorf("no TLSA records secured by DNSSEC for %s: %w", name, pinErr)
+	}
+	if len(records) == 0 {
+		b.Log.Debugf("no TLSA records secured by DNSSEC for %s, validating the certificate with the CAs", name)
+		return verifyPKIX(cs)
+	}
+	b.Log.Debugf("validating the certificate with the %d TLSA records of %s", len(records), name)
+	return verifyTLSA(cs, records, nil)
+}
+
+// daneResolver returns the address of the resolver of the TLSA records: the
+// DANEResolver setting, or the first nameserver of resolv.conf. The records
+// are only as trustworthy as the path to the resolver, so a nameserver of
+// resolv.conf which isn't on this host is refused.
+func (b *Birc) daneResolver() (string, error) {
+	if resolver := b.GetString("DANEResolver"); resolver != "" {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			return net.JoinHostPort(resolver, "53"), nil
+		}
+		return resolver, nil
+	}
+	data, err := os.ReadFile(resolvConf)
+	if err != nil {
+		return defaultResolver, nil
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip == nil || !ip.IsLoopback() {
+			return "", fmt.Errorf("UseDANE needs a DNSSEC validating resolver on this host, set DANEResolver to trust the nameserver %s of %s", fields[1], resolvConf)
+		}
+		return net.JoinHostPort(fields[1], "53"), nil
+	}
+	return defaultResolver, nil
+}
+
+// lookupTLSA returns the TLSA records of name, asking resolver for DNSSEC. The
+// records not authenticated by the resolver are ignored, and no records are
+// returned without error when name doesn't exist.
+func lookupTLSA(ctx context.Context, resolver string, name string) ([]tlsaRecord, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: typeTLSA, Class: dnsmessage.ClassINET}},
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+		return nil, err
+	}
+	query.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := exchangeDNS(ctx, "udp", resolver, packed)
+	if err != nil {
+		return nil, err
+	}
+	records, truncated, err := parseTLSA(answer, query)
+	if truncated {
+		answer, err = exchangeDNS(ctx, "tcp", resolver, packed)
+		if err != nil {
+			return nil, err
+		}
+		records, _, err = parseTLSA(answer, query)
+	}
+	return records, err
+}
+
+// exchangeDNS sends the DNS query to resolver and returns its answer.
+func exchangeDNS(ctx context.Context, network string, resolver string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, 65535)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		return answer[:n], nil
+	}
+
+	// the messages are prefixed by their length over TCP
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// parseTLSA returns the TLSA records of the answer to query, nil if they
+// aren't authenticated, and whether it was truncated.
+func parseTLSA(answer []byte, query dnsmessage.Message) ([]tlsaRecord, bool, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(answer)
+	if err != nil {
+		return nil, false, err
+	}
+	if header.ID != query.ID || !header.Response || !sameQuestions(&p, query.Questions) {
+		return nil, false, errors.New("the DNS answer doesn't match the query")
+	}
+	switch {
+	case header.Truncated:
+		return nil, true, nil
+	case header.RCode == dnsmessage.RCodeNameError:
+		return nil, false, nil
+	case header.RCode != dnsmessage.RCodeSuccess:
+		return nil, false, fmt.Errorf("the resolver answered %s", header.RCode)
+	case !header.AuthenticData:
+		return nil, false, nil
+	}
+
+	var records []tlsaRecord
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return records, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if rh.Type != typeTLSA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		rr, err := p.UnknownResource()
+		if err != nil {
+			return nil, false, err
+		}
+		if len(rr.Data) < 4 {
+			return nil, false, errors.New("invalid TLSA record")
+		}
+		records = append(records, tlsaRecord{
+			usage:        rr.Data[0],
+			selector:     rr.Data[1],
+			matchingType: rr.Data[2],
+			data:         rr.Data[3:],
+		})
+	}
+}
+
+// sameQuestions returns true if the questions of the answer parsed by p are
+// questions, the names being case insensitive.
+func sameQuestions(p *dnsmessage.Parser, questions []dnsmessage.Question) bool {
+	answered, err := p.AllQuestions()
+	if err != nil || len(answered) != len(questions) {
+		return false
+	}
+	for i, q := range answered {
+		if q.Type != questions[i].Type || q.Class != questions[i].Class || !strings.EqualFold(q.Name.String(), questions[i].Name.String()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+package birc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyFingerprints(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Private CA", nil, nil)
+	leaf, _ := newTestCertificate(t, "irc.example.org", ca, caKey)
+	selfSigned, _ := newTestCertificate(t, "irc.example.org", nil, nil)
+	cs := tls.ConnectionState{ServerName: "irc.example.org", PeerCertificates: []*x509.Certificate{leaf, ca}}
+
+	verify := func(cs tls.ConnectionState, fingerprints ...string) error {
+		pins, err := parseFingerprints(fingerprints)
+		require.NoError(t, err)
+		return verifyTLSA(cs, pins, nil)
+	}
+
+	assert.NoError(t, verify(cs, fingerprint(leaf.Raw)))
+	assert.NoError(t, verify(cs, "SHA256:"+fingerprint(leaf.RawSubjectPublicKeyInfo)))
+	// the certificates issued by a pinned CA are accepted for their name only
+	assert.NoError(t, verify(cs, fingerprint(ca.Raw)))
+	cs.ServerName = "irc.example.net"
+	assert.ErrorIs(t, verify(cs, fingerprint(ca.Raw)), errNoTLSAMatch)
+	// a pinned certificate is accepted whatever its name
+	assert.NoError(t, verify(cs, fingerprint(leaf.Raw)))
+
+	selfSignedCS := tls.ConnectionState{ServerName: "irc.example.org", PeerCertificates: []*x509.Certificate{selfSigned}}
+	assert.NoError(t, verify(selfSignedCS, fingerprint(selfSigned.Raw)))
+	assert.ErrorIs(t, verify(selfSignedCS, fingerprint(leaf.Raw)), errNoTLSAMatch)
+
+	_, err := parseFingerprints([]string{"AB:CD"})
+	assert.Error(t, err)
+}
+
+func TestVerifyTLSA(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "Private CA", nil, nil)
+	leaf, _ := newTestCertificate(t, "irc.example.org", ca, caKey)
+	cs := tls.ConnectionState{ServerName: "irc.example.org", PeerCertificates: []*x509.Certificate{leaf}}
+	sha256Of := func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+
+	// DANE-EE 3 1 1
+	assert.NoError(t, verifyTLSA(cs, []tlsaRecord{{tlsaDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, sha256Of(leaf.RawSubjectPublicKeyInfo)}}, nil))
+	// DANE-TA 2 0 0, with the CA only in the record
+	assert.NoError(t, verifyTLSA(cs, []tlsaRecord{{tlsaDANETA, tlsaSelectorCert, tlsaMatchFull, ca.Raw}}, nil))
+	// PKIX-EE 1 0 1 needs the CAs to validate the certificate too
+	pkixEE := []tlsaRecord{{tlsaPKIXEE, tlsaSelectorCert, tlsaMatchSHA256, sha256Of(leaf.Raw)}}
+	assert.ErrorIs(t, verifyTLSA(cs, pkixEE, nil), errNoTLSAMatch)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	assert.NoError(t, verifyTLSA(cs, pkixEE, roots))
+	// PKIX-TA 0 1 1
+	assert.NoError(t, verifyTLSA(cs, []tlsaRecord{{tlsaPKIXTA, tlsaSelectorSPKI, tlsaMatchSHA256, sha256Of(ca.RawSubjectPublicKeyInfo)}}, roots))
+
+	assert.ErrorIs(t, verifyTLSA(cs, []tlsaRecord{{tlsaDANEEE, tlsaSelectorCert, tlsaMatchSHA256, sha256Of(ca.Raw)}}, nil), errNoTLSAMatch)
+}
+
+// serveTLSA answers the TLSA queries on a local UDP port with record, flagged
+// as authenticated or not.
+func serveTLSA(t *testing.T, record []byte, authenticated bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			answer := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true, AuthenticData: authenticated},
+				Questions: query.Questions,
+				Answers: []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: typeTLSA, Class: dnsmessage.ClassINET, TTL: 300},
+					Body:   &dnsmessage.UnknownResource{Type: typeTLSA, Data: record},
+				}},
+			}
+			packed, err := answer.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr) //nolint:errcheck
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestLookupTLSA(t *testing.T) {
+	record := append([]byte{tlsaDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256}, make([]byte, sha256.Size)...)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records, err := lookupTLSA(ctx, serveTLSA(t, record, true), "_6697._tcp.irc.example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []tlsaRecord{{tlsaDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, make([]byte, sha256.Size)}}, records)
+
+	// the records not validated by DNSSEC are ignored
+	records, err = lookupTLSA(ctx, serveTLSA(t, record, false), "_6697._tcp.irc.example.org")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestVerifyConnection(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	b := &Birc{Config: &bridge.Config{Bridge: &bridge.Bridge{Account: "irc.test", Log: logrus.NewEntry(logger)}}}
+	ca, caKey := newTestCertificate(t, "Private CA", nil, nil)
+	leaf, _ := newTestCertificate(t, "irc.example.org", ca, caKey)
+	other, _ := newTestCertificate(t, "irc.example.org", nil, nil)
+	cs := tls.ConnectionState{ServerName: "irc.example.org", PeerCertificates: []*x509.Certificate{leaf}}
+	defer func() { pkixRoots = nil }()
+	pkixRoots = x509.NewCertPool()
+	pkixRoots.AddCert(ca)
+	pins, err := parseFingerprints([]string{fingerprint(other.Raw)})
+	require.NoError(t, err)
+	record := append([]byte{tlsaDANEEE, tlsaSelectorCert, tlsaMatchSHA256}, make([]byte, sha256.Size)...)
+	noRecords := serveTLSA(t, record, false)
+
+	// without TLSA records the CAs validate the certificate, unless it
+	// matches none of the TLSFingerprints
+	assert.NoError(t, b.verifyConnection(cs, nil, noRecords, "6697"))
+	assert.ErrorIs(t, b.verifyConnection(cs, pins, noRecords, "6697"), errNoTLSAMatch)
+	assert.ErrorIs(t, b.verifyConnection(cs, pins, "", "6697"), errNoTLSAMatch)
+
+	// a TLSA record still accepts it
+	sum := sha256.Sum256(leaf.Raw)
+	record = append([]byte{tlsaDANEEE, tlsaSelectorCert, tlsaMatchSHA256}, sum[:]...)
+	assert.NoError(t, b.verifyConnection(cs, pins, serveTLSA(t, record, true), "6697"))
+}
+
+func TestDANEResolver(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[irc.test]\nDANEResolver=\"127.0.0.53\"\n"))
+	b := &Birc{Config: &bridge.Config{Bridge: &bridge.Bridge{Account: "irc.test", Config: cfg, Log: logrus.NewEntry(logger)}}}
+	resolver, err := b.daneResolver()
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.53:53", resolver)
+
+	// the nameservers of resolv.conf are only trusted on this host
+	b.Config.Bridge.Config = config.NewConfigFromString(logger, []byte("[irc.test]\n"))
+	defer func(path string) { resolvConf = path }(resolvConf)
+	resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConf, []byte("search example.org\nnameserver 127.0.0.53\n"), 0o600))
+	resolver, err = b.daneResolver()
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.53:53", resolver)
+	require.NoError(t, os.WriteFile(resolvConf, []byte("nameserver 192.0.2.1\nnameserver 127.0.0.1\n"), 0o600))
+	_, err = b.daneResolver()
+	assert.Error(t, err)
+}
+
+func TestParseTLSAQuestion(t *testing.T) {
+	name := dnsmessage.MustNewName("_6697._tcp.irc.example.org.")
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42},
+		Questions: []dnsmessage.Question{{Name: name, Type: typeTLSA, Class: dnsmessage.ClassINET}},
+	}
+	answerTo := func(question dnsmessage.Question) []byte {
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: 42, Response: true, AuthenticData: true},
+			Questions: []dnsmessage.Question{question},
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: typeTLSA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.UnknownResource{Type: typeTLSA, Data: []byte{tlsaDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, 1}},
+			}},
+		}
+		packed, err := answer.Pack()
+		require.NoError(t, err)
+		return packed
+	}
+
+	records, _, err := parseTLSA(answerTo(dnsmessage.Question{Name: dnsmessage.MustNewName("_6697._TCP.IRC.example.org."), Type: typeTLSA, Class: dnsmessage.ClassINET}), query)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// the answer with the right ID must be to the same question
+	_, _, err = parseTLSA(answerTo(dnsmessage.Question{Name: dnsmessage.MustNewName("_6697._tcp.evil.example.org."), Type: typeTLSA, Class: dnsmessage.ClassINET}), query)
+	assert.Error(t, err)
+	_, _, err = parseTLSA(answerTo(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}), query)
+	assert.Error(t, err)
+}
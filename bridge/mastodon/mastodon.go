@@ -1,10 +1,10 @@
 package mastodon
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 
@@ -13,6 +13,7 @@ import (
 	"github.com/matterbridge-org/matterbridge/bridge/helper"
 
 	mastodon "github.com/mattn/go-mastodon"
+	"golang.org/x/oauth2"
 )
 
 var (
@@ -34,6 +35,8 @@ type Bmastodon struct {
 
 	c       *mastodon.Client
 	account *mastodon.Account
+	// tokens refreshes the access token, with RefreshToken
+	tokens *bridge.TokenManager
 
 	rooms   []string
 	handles []context.CancelFunc
@@ -54,6 +57,10 @@ func (b *Bmastodon) Connect() error {
 		AccessToken:  b.GetString("AccessToken"),
 	}
 	b.c = mastodon.NewClient(&cfg)
+	if b.GetString("RefreshToken") != "" {
+		b.useTokenManager(&cfg)
+	}
+	b.c.Transport = newPostingQueue(b.c.Transport, b.Log)
 
 	var err error
 
@@ -66,6 +73,37 @@ func (b *Bmastodon) Connect() error {
 	return nil
 }
 
+// useTokenManager sends the requests of the client with the access token
+// refreshed by the token manager.
+func (b *Bmastodon) useTokenManager(cfg *mastodon.Config) {
+	b.tokens = b.NewTokenManager(&oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  strings.TrimSuffix(cfg.Server, "/") + "/oauth/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}, &oauth2.Token{
+		AccessToken:  cfg.AccessToken,
+		RefreshToken: b.GetString("RefreshToken"),
+		TokenType:    "Bearer",
+	})
+	// the Authorization header set by the client is replaced
+	b.c.Transport = &oauth2.Transport{Source: b.tokens}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	b.handles = append(b.handles, ctxCancel)
+	b.tokens.Start(ctx)
+}
+
+// HealthWarnings implements bridge.HealthWarner.
+func (b *Bmastodon) HealthWarnings() []string {
+	if b.tokens == nil {
+		return nil
+	}
+	return b.tokens.HealthWarnings()
+}
+
 func (b *Bmastodon) Disconnect() error {
 	for _, ctxCancel := range b.handles {
 		ctxCancel()
@@ -147,7 +185,7 @@ func (b *Bmastodon) Send(msg config.Message) (string, error) {
 		if err != nil {
 			b.Log.Errorf("Could not send message to room %v from %v: %v", msg.Channel, msg.Username, err)
 
-			return "", nil
+			return "", err
 		}
 
 		return string(sentMessage.ID), nil
@@ -169,6 +207,18 @@ func (b *Bmastodon) Send(msg config.Message) (string, error) {
 	return "", nil
 }
 
+// ClassifyError wraps the errors of the Mastodon API with their bridge.ErrorClass.
+func (b *Bmastodon) ClassifyError(err error) error {
+	var apiErr *mastodon.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if class := bridge.ClassifyStatusCode(apiErr.StatusCode); class != bridge.ErrorUnknown {
+		return bridge.NewError(class, err)
+	}
+	return err
+}
+
 func (b *Bmastodon) handleSendRemoteStatus(msg *mastodon.Status, channel string) {
 	if msg.Account.ID == b.account.ID {
 		// Ignore messages that are from the bot user
@@ -197,10 +247,11 @@ func (b *Bmastodon) handleSendRemoteStatus(msg *mastodon.Status, channel string)
 		}
 
 		remoteMessage.Extra["file"] = append(remoteMessage.Extra["file"], config.FileInfo{
-			Name:   media.Description,
-			Data:   b,
-			Size:   int64(len(*b)),
-			Avatar: false,
+			Name:    path.Base(media.RemoteURL),
+			Data:    b,
+			Size:    int64(len(*b)),
+			Avatar:  false,
+			AltText: media.Description,
 		})
 	}
 
@@ -235,10 +286,16 @@ func (b *Bmastodon) handleSendingMessage(ctx context.Context, msg *config.Messag
 	}
 
 	for _, file := range *msg.GetFileInfos(b.Log) {
+		data, err := file.Open()
+		if err != nil {
+			b.Log.Error(err)
+			continue
+		}
 		attachment, err := b.c.UploadMediaFromMedia(ctx, &mastodon.Media{
-			File:        bytes.NewReader(*file.Data),
-			Description: file.Comment,
+			File:        data,
+			Description: file.AltText,
 		})
+		data.Close()
 		if err != nil {
 			b.Log.Error(err)
 			continue
@@ -0,0 +1,209 @@
+package mastodon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxPostAttempts is how many times a post failing with 429 or 5xx is sent
+	maxPostAttempts = 3
+	// maxPostWait is the longest wait before a post. Longer waits are left to
+	// the gateway, which queues the message for the account.
+	maxPostWait = 10 * time.Second
+)
+
+// postBackoff is the wait before sending again a failed post, doubled on each
+// attempt when the server doesn't say how long to wait.
+var postBackoff = time.Second
+
+var errRateLimitReached = errors.New("rate limit of the instance reached")
+
+// statusesPath is the endpoint posting the toots.
+const statusesPath = "/api/v1/statuses"
+
+// postingQueue is the transport of the client. It sends the posts (every
+// request but GET) one at a time, waiting when the rate limit of the instance
+// is reached, and sends them again when they fail with 429 or 5xx. The new
+// toots are sent with an Idempotency-Key, so that a toot posted by a request
+// which failed afterwards isn't posted again. The streaming requests (GET)
+// aren't queued, but their rate limit is tracked.
+type postingQueue struct {
+	next http.RoundTripper
+	log  *logrus.Entry
+
+	// postMu is held while posting
+	postMu  sync.Mutex
+	limitMu sync.Mutex
+	// remaining is the X-RateLimit-Remaining of the last response, -1 if unknown
+	remaining int
+	reset     time.Time
+}
+
+func newPostingQueue(next http.RoundTripper, log *logrus.Entry) *postingQueue {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &postingQueue{next: next, log: log, remaining: -1}
+}
+
+func (q *postingQueue) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		resp, err := q.next.RoundTrip(req)
+		if err == nil {
+			q.track(resp, time.Now())
+		}
+		return resp, err
+	}
+
+	q.postMu.Lock()
+	defer q.postMu.Unlock()
+
+	if wait := q.limitWait(time.Now()); wait > 0 {
+		if wait > maxPostWait {
+			return nil, bridge.NewRateLimitError(errRateLimitReached, wait)
+		}
+		q.log.Debugf("rate limit of the instance reached, posting in %s", wait)
+		if err := sleepContext(req, wait); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Method == http.MethodPost && req.URL.Path == statusesPath && req.Header.Get("Idempotency-Key") == "" {
+		key, err := idempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	backoff := postBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := q.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		q.track(resp, now)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+
+		wait, ok := retryAfter(resp.Header, now)
+		if !ok {
+			wait = backoff
+			backoff *= 2
+		}
+		if attempt == maxPostAttempts || wait > maxPostWait || req.Body != nil && req.GetBody == nil {
+			if resp.StatusCode != http.StatusTooManyRequests {
+				return resp, nil
+			}
+			// the client would send it again for up to an hour
+			drain(resp)
+			return nil, bridge.NewRateLimitError(fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status), wait)
+		}
+
+		q.log.Debugf("%s %s failed with %s, sending it again in %s", req.Method, req.URL.Path, resp.Status, wait)
+		drain(resp)
+		if err := sleepContext(req, wait); err != nil {
+			return nil, err
+		}
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// track records the rate limit sent with resp.
+func (q *postingQueue) track(resp *http.Response, now time.Time) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := time.Parse(time.RFC3339, resp.Header.Get("X-RateLimit-Reset"))
+	if err != nil {
+		return
+	}
+
+	q.limitMu.Lock()
+	defer q.limitMu.Unlock()
+	q.remaining = remaining
+	q.reset = reset
+}
+
+// limitWait returns how long to wait at now before posting.
+func (q *postingQueue) limitWait(now time.Time) time.Duration {
+	q.limitMu.Lock()
+	defer q.limitMu.Unlock()
+	if q.remaining != 0 || !q.reset.After(now) {
+		return 0
+	}
+	return q.reset.Sub(now)
+}
+
+// retryAfter returns the wait asked by the Retry-After or X-RateLimit-Reset
+// headers of a failed response.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	if reset, err := time.Parse(time.RFC3339, header.Get("X-RateLimit-Reset")); err == nil && header.Get("X-RateLimit-Remaining") == "0" {
+		return max(reset.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// idempotencyKey returns a new key identifying a toot across its attempts.
+func idempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// rewind returns a copy of req with a new body, to send it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+func sleepContext(req *http.Request, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
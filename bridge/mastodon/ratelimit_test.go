@@ -0,0 +1,130 @@
+package mastodon
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postStatus(t *testing.T, q *postingQueue, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("status=hello"))
+	require.NoError(t, err)
+	return q.RoundTrip(req)
+}
+
+func TestPostingQueue(t *testing.T) {
+	defer func(backoff time.Duration) { postBackoff = backoff }(postBackoff)
+	postBackoff = time.Millisecond
+
+	var (
+		statuses []int
+		bodies   []string
+		keys     []string
+		headers  http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		for key, values := range headers {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+	q := newPostingQueue(nil, logrus.NewEntry(logrus.New()))
+
+	// 429 and 5xx are sent again with the same body
+	statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusOK}
+	resp, err := postStatus(t, q, server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"status=hello", "status=hello", "status=hello"}, bodies)
+	// the other posts aren't toots
+	assert.Equal(t, []string{"", "", ""}, keys)
+
+	// the attempts of a toot share its Idempotency-Key
+	keys = nil
+	statuses = []int{http.StatusBadGateway, http.StatusOK, http.StatusOK}
+	resp, err = postStatus(t, q, server.URL+statusesPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = postStatus(t, q, server.URL+statusesPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.NotEqual(t, keys[0], keys[2])
+
+	// persistent 5xx are returned to the client
+	bodies = nil
+	statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+	resp, err = postStatus(t, q, server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, bodies, maxPostAttempts)
+
+	// a 429 with a long reset is left to the gateway
+	bodies = nil
+	reset := time.Now().Add(time.Hour).UTC()
+	headers = http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {reset.Format(time.RFC3339)},
+	}
+	statuses = []int{http.StatusTooManyRequests}
+	_, err = postStatus(t, q, server.URL)
+	require.ErrorIs(t, err, bridge.ErrRateLimited)
+	var bridgeErr *bridge.Error
+	require.True(t, errors.As(err, &bridgeErr))
+	assert.InDelta(t, time.Hour, bridgeErr.RetryAfter, float64(time.Minute))
+	assert.Len(t, bodies, 1)
+
+	// and the next posts aren't sent until the reset
+	_, err = postStatus(t, q, server.URL)
+	require.ErrorIs(t, err, bridge.ErrRateLimited)
+	assert.Len(t, bodies, 1)
+
+	// a close reset is waited for
+	bodies = nil
+	headers = nil
+	q.reset = time.Now().Add(50 * time.Millisecond)
+	statuses = []int{http.StatusOK}
+	start := time.Now()
+	resp, err = postStatus(t, q, server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	assert.Len(t, bodies, 1)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		header http.Header
+		wait   time.Duration
+		ok     bool
+	}{
+		{http.Header{"Retry-After": {"30"}}, 30 * time.Second, true},
+		{http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"2026-01-02T03:05:05.123Z"}}, time.Minute + 123*time.Millisecond, true},
+		{http.Header{"X-Ratelimit-Remaining": {"12"}, "X-Ratelimit-Reset": {"2026-01-02T03:05:05Z"}}, 0, false},
+		{http.Header{}, 0, false},
+	} {
+		wait, ok := retryAfter(tc.header, now)
+		assert.Equal(t, tc.ok, ok, tc.header)
+		assert.Equal(t, tc.wait, wait, tc.header)
+	}
+}
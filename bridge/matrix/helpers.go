@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"strings"
 	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	mautrix "maunium.net/go/mautrix"
 	/* trunk-ignore(golangci-lint2/typecheck) */
 	"maunium.net/go/mautrix/crypto"
@@ -45,8 +49,74 @@ func (b *Bmatrix) getRoomID(channel string) id.RoomID {
 	return ""
 }
 
-// getDisplayName retrieves the displayName for mxid, querying the homeserver if the mxid is not in the cache.
-func (b *Bmatrix) getDisplayName(ctx context.Context, mxid id.UserID) string {
+// Permalink implements bridge.Permalinker with the matrix.to link of the
+// event, routed through the homeserver of matterbridge.
+func (b *Bmatrix) Permalink(channel string, eventID string) (string, error) {
+	roomID := b.getRoomID(channel)
+	if roomID == "" {
+		return "", fmt.Errorf("unknown room %s", channel)
+	}
+	return roomID.EventURI(id.EventID(eventID), b.UserID.Homeserver()).MatrixToURL(), nil
+}
+
+// ResolveChannelName returns the canonical alias of a room configured by ID,
+// so that it matches channels with the same name on other bridges.
+func (b *Bmatrix) ResolveChannelName(channel string) string {
+	if !strings.HasPrefix(channel, "!") || b.mc == nil {
+		return channel
+	}
+	roomID := id.RoomID(channel)
+
+	b.RLock()
+	alias, ok := b.AliasMap[roomID]
+	b.RUnlock()
+	if ok {
+		return alias
+	}
+
+	// Rooms without an alias are cached too, to query them only once
+	alias = channel
+	var content event.CanonicalAliasEventContent
+	err := b.mc.StateEvent(context.TODO(), roomID, event.StateCanonicalAlias, "", &content)
+	if err != nil {
+		b.Log.Debugf("Could not get the canonical alias of %s: %s", channel, err)
+	} else if content.Alias != "" {
+		alias = content.Alias.String()
+	}
+
+	b.Lock()
+	b.AliasMap[roomID] = alias
+	b.Unlock()
+
+	return alias
+}
+
+// ClassifyError wraps the errors of the Matrix API with their bridge.ErrorClass.
+func (b *Bmatrix) ClassifyError(err error) error {
+	switch {
+	case errors.Is(err, mautrix.MUnknownToken), errors.Is(err, mautrix.MMissingToken):
+		return bridge.NewError(bridge.ErrorAuth, err)
+	case errors.Is(err, mautrix.MForbidden):
+		return bridge.NewError(bridge.ErrorPermission, err)
+	case errors.Is(err, mautrix.MTooLarge):
+		return bridge.NewError(bridge.ErrorTooLarge, err)
+	case errors.Is(err, mautrix.MLimitExceeded):
+		var httpErr mautrix.HTTPError
+		var retryAfter time.Duration
+		if errors.As(err, &httpErr) && httpErr.RespError != nil {
+			if ms, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok {
+				retryAfter = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return bridge.NewRateLimitError(err, retryAfter)
+	}
+	return err
+}
+
+// getDisplayName retrieves the displayName for mxid in roomID, from the cache
+// or from the members of the room. The user name is returned while the members
+// are being fetched, the message flow never waits for the homeserver.
+func (b *Bmatrix) getDisplayName(roomID id.RoomID, mxid id.UserID) string {
 	// Localpart is the user name. Return it if UseUserName is set.
 	if b.GetBool("UseUserName") {
 		return mxid.Localpart()
@@ -62,15 +132,16 @@ func (b *Bmatrix) getDisplayName(ctx context.Context, mxid id.UserID) string {
 
 	b.RUnlock()
 
-	resp, err := b.mc.GetDisplayName(ctx, mxid)
-	if err != nil {
-		b.Log.Errorf("Retrieving the display name for %s failed: %s", mxid, err)
-
-		// Return the user name since retrieving the display name failed
-		return b.cacheDisplayName(mxid, mxid.Localpart())
+	displayName, ok := b.memberName(roomID, mxid)
+	if !ok {
+		// not cached, the next messages get the display name once fetched
+		return mxid.Localpart()
+	}
+	if displayName == "" {
+		displayName = mxid.Localpart()
 	}
 
-	return b.cacheDisplayName(mxid, resp.DisplayName)
+	return b.cacheDisplayName(mxid, displayName)
 }
 
 // cacheDisplayName stores the mapping between a mxid and a display name, to be reused later without performing a query to the homserver.
@@ -156,8 +227,13 @@ func (b *Bmatrix) containsAttachment(content event.Content) bool {
 	return true
 }
 
-// getAvatarURL returns the avatar URL of the specified sender.
+// getAvatarURL returns the avatar URL of the specified sender, or nothing when
+// the API budget is tight.
 func (b *Bmatrix) getAvatarURL(ctx context.Context, sender id.UserID) string {
+	if b.Budget.Tight() {
+		return ""
+	}
+
 	urlPath, err := b.mc.GetAvatarURL(ctx, sender)
 	if err != nil {
 		b.Log.Errorf("getAvatarURL failed: %s", err)
@@ -252,3 +328,45 @@ func verifyWithRecoveryKey(ctx context.Context, machine *crypto.OlmMachine, reco
 
 	return err
 }
+
+// parseMarkdown formats a message as HTML, with its spoilers.
+func (b *Bmatrix) parseMarkdown(text string) string {
+	text = helper.ReplaceSpoilers(text, func(spoiler string) string {
+		return "<span data-mx-spoiler>" + spoiler + "</span>"
+	})
+	return helper.ParseMarkdown(text, b.Log)
+}
+
+// spoilersFromHTML returns the text of the formatted body of a message, with
+// its spoilers marked as "||spoiler||". The plain body of messages with
+// spoilers doesn't contain them.
+func spoilersFromHTML(formattedBody string) string {
+	text := mxReplyRE.ReplaceAllString(formattedBody, "")
+	text = spoilerSpanRE.ReplaceAllString(text, "||$1||")
+	text = htmlLineBreakRE.ReplaceAllString(text, "\n")
+	text = htmlReplacementTag.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// useNotice returns true if msg is sent as a notice rather than as a text:
+// the join/leave events and announcements, and with UseNotices the topic
+// changes and the messages of bots.
+func (b *Bmatrix) useNotice(msg *config.Message) bool {
+	switch msg.Event {
+	case config.EventJoin, config.EventLeave, config.EventJoinLeave, config.EventAnnounce:
+		return true
+	case config.EventTopicChange:
+		return b.GetBool("UseNotices")
+	case "":
+		return b.GetBool("UseNotices") && msg.IsBot()
+	}
+	return false
+}
+
+// textMsgType returns the msgtype of the text of msg, see useNotice.
+func (b *Bmatrix) textMsgType(msg *config.Message) event.MessageType {
+	if b.useNotice(msg) {
+		return event.MsgNotice
+	}
+	return event.MsgText
+}
@@ -10,11 +10,11 @@ import (
 	"mime"
 	"net/http"
 	"net/url"
-	"path"
 	"regexp"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// Initialize specific format decoders,
@@ -23,6 +23,7 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
 	"github.com/matterbridge-org/matterbridge/bridge/helper"
@@ -38,6 +39,10 @@ var Audio_MimeTypes = []string{"aac", "flac", "matroska", "mp4", "mpeg", "ogg",
 var (
 	htmlTag            = regexp.MustCompile("</.*?>")
 	htmlReplacementTag = regexp.MustCompile("<[^>]*>")
+	homeServerSuffixRE = regexp.MustCompile(`\s+\(@.*`)
+	spoilerSpanRE      = regexp.MustCompile(`(?s)<span data-mx-spoiler(?:="[^"]*")?>(.*?)</span>`)
+	mxReplyRE          = regexp.MustCompile(`(?s)<mx-reply>.*?</mx-reply>`)
+	htmlLineBreakRE    = regexp.MustCompile(`<br\s*/?>|</p>`)
 )
 
 type NicknameCacheEntry struct {
@@ -50,7 +55,23 @@ type Bmatrix struct {
 	UserID      id.UserID
 	NicknameMap map[string]NicknameCacheEntry
 	RoomMap     map[id.RoomID]string
-	rateMutex   sync.RWMutex
+	// AliasMap caches the canonical alias of rooms joined by ID
+	AliasMap  map[id.RoomID]string
+	rateMutex sync.RWMutex
+	// members of the joined rooms, see roomMembers
+	members      map[id.RoomID]*roomMembers
+	membersMutex sync.Mutex
+	// reactions are the reactions received and sent, see
+	// handleReactionEvent and sendReaction
+	reactions *lru.Cache
+	// threads are the roots of the threads of the events received and
+	// sent, see replyRelation
+	threads *lru.Cache
+	// asyncUploads is set when the homeserver supports MSC2246, see
+	// uploadMedia
+	asyncUploads bool
+	// lastSync is the unix time of the last sync, see Alive
+	lastSync atomic.Int64
 	sync.RWMutex
 	*bridge.Config
 }
@@ -105,7 +126,11 @@ type ReplyMessage struct {
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmatrix{Config: cfg}
 	b.RoomMap = make(map[id.RoomID]string)
+	b.AliasMap = make(map[id.RoomID]string)
 	b.NicknameMap = make(map[string]NicknameCacheEntry)
+	b.members = make(map[id.RoomID]*roomMembers)
+	b.reactions, _ = lru.New(reactionsSize)
+	b.threads, _ = lru.New(threadsSize)
 	return b
 }
 
@@ -122,6 +147,7 @@ func (b *Bmatrix) Connect() error {
 		if err != nil {
 			return err
 		}
+		b.mc.Client.Transport = b.Budget.Transport(b.mc.Client.Transport)
 
 		b.UserID = userID
 		b.Log.Info("Using existing Matrix credentials")
@@ -132,6 +158,7 @@ func (b *Bmatrix) Connect() error {
 		if err != nil {
 			return err
 		}
+		b.mc.Client.Transport = b.Budget.Transport(b.mc.Client.Transport)
 
 		resp, err2 := b.mc.Login(
 			context.TODO(),
@@ -154,6 +181,8 @@ func (b *Bmatrix) Connect() error {
 	b.Log.Infof("Token: %s", b.mc.AccessToken)
 	b.Log.Infof("Device ID: %s", b.mc.DeviceID)
 
+	b.detectAsyncUploads()
+
 	go b.handlematrix()
 	return nil
 }
@@ -162,6 +191,22 @@ func (b *Bmatrix) Disconnect() error {
 	return nil
 }
 
+// Alive implements bridge.Heartbeater, the syncs are long polls returning at
+// least every syncStaleAfter.
+func (b *Bmatrix) Alive() error {
+	last := time.Unix(b.lastSync.Load(), 0)
+	if time.Since(last) > syncStaleAfter {
+		return fmt.Errorf("no sync since %s", last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Ping implements bridge.Heartbeater.
+func (b *Bmatrix) Ping(ctx context.Context) error {
+	_, err := b.mc.Whoami(ctx)
+	return err
+}
+
 func (b *Bmatrix) JoinChannel(channel config.ChannelInfo) error {
 	return b.retry(func() error {
 		resp, err := b.mc.JoinRoom(context.TODO(), channel.Name, nil)
@@ -173,6 +218,8 @@ func (b *Bmatrix) JoinChannel(channel config.ChannelInfo) error {
 		b.RoomMap[resp.RoomID] = channel.Name
 		b.Unlock()
 
+		b.refreshMembers(resp.RoomID)
+
 		return nil
 	})
 }
@@ -184,15 +231,22 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	roomID := b.getRoomID(msg.Channel)
 	b.Log.Debugf("Channel %s maps to channel id %s", msg.Channel, roomID.String())
 
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, roomID)
+	}
+
 	username := newMatrixUsername(msg.Username)
 
-	body := username.plain + msg.Text
+	// the plain body is shown in notifications, so spoilers are hidden
+	plainText := helper.HideSpoilers(msg.Text, b.GetString("SpoilerFormat"))
+	body := username.plain + plainText
 
 	var formattedBody string
 	if b.GetBool("DisableMarkdownParsing") {
 		formattedBody = username.formatted + msg.Text
 	} else {
-		formattedBody = username.formatted + helper.ParseMarkdown(msg.Text, b.Log)
+		formattedBody = username.formatted + b.parseMarkdown(msg.Text)
 	}
 
 	if b.GetBool("SpoofUsername") {
@@ -212,12 +266,12 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 
 		_, err := b.mc.SendStateEvent(context.TODO(), roomID, event.StateMember, b.UserID.String(), content)
 		if err == nil {
-			body = msg.Text
+			body = plainText
 
 			if b.GetBool("DisableMarkdownParsing") {
 				formattedBody = msg.Text
 			} else {
-				formattedBody = helper.ParseMarkdown(msg.Text, b.Log)
+				formattedBody = b.parseMarkdown(msg.Text)
 			}
 		}
 	}
@@ -300,6 +354,11 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
 
 			err := b.retry(func() error {
+				// these texts tell that the files couldn't be relayed
+				if b.GetBool("UseNotices") {
+					_, err := b.mc.SendNotice(context.TODO(), roomID, rmsg.Username+rmsg.Text)
+					return err
+				}
 				_, err := b.mc.SendText(context.TODO(), roomID, rmsg.Username+rmsg.Text)
 
 				return err
@@ -326,13 +385,13 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			content = event.MessageEventContent{
 				Body:          "* " + body,
 				FormattedBody: "<b>*</b> " + formattedBody,
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(&msg),
 				Format:        event.FormatHTML,
 				NewContent: &event.MessageEventContent{
 					Body:          body,
 					FormattedBody: formattedBody,
 					Format:        event.FormatHTML,
-					MsgType:       event.MsgText,
+					MsgType:       b.textMsgType(&msg),
 					BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 						ID:          msg.UserID + "/" + username.plain,
 						Displayname: username.plain,
@@ -355,13 +414,13 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			content = event.MessageEventContent{
 				Body:          "* " + body,
 				FormattedBody: "<b>*</b> " + formattedBody,
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(&msg),
 				Format:        event.FormatHTML,
 				NewContent: &event.MessageEventContent{
 					Body:          body,
 					FormattedBody: formattedBody,
 					Format:        event.FormatHTML,
-					MsgType:       event.MsgText,
+					MsgType:       b.textMsgType(&msg),
 				},
 				RelatesTo: &event.RelatesTo{
 					EventID: id.EventID(msg.ID),
@@ -389,10 +448,9 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		return msg.ID, nil
 	}
 
-	// Use notices to send join/leave events
-	if msg.Event == config.EventJoin ||
-		msg.Event == config.EventLeave ||
-		msg.Event == config.EventJoinLeave {
+	// Use notices to send join/leave events and announcements, which matrix
+	// clients show apart from the conversation
+	if msg.Event != "" && b.useNotice(&msg) {
 		content := event.MessageEventContent{
 			MsgType:       event.MsgNotice,
 			Body:          body,
@@ -425,6 +483,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	// Reply to parent if message has a parent id
 	if msg.ParentValid() {
 		var content event.MessageEventContent
+		relation := b.replyRelation(id.EventID(msg.ParentID))
 		if b.GetBool("UseMSC4144") {
 			body, _ = strings.CutPrefix(body, username.plain)
 			body = username.plain + ": " + body
@@ -433,16 +492,11 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			avatar := b.handleAvatar(msg.Avatar)
 
 			content = event.MessageEventContent{
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(&msg),
 				Body:          body,
 				FormattedBody: formattedBody,
 				Format:        event.FormatHTML,
-				RelatesTo: &event.RelatesTo{
-					Type: "m.reply",
-					InReplyTo: &event.InReplyTo{
-						EventID: id.EventID(msg.ParentID),
-					},
-				},
+				RelatesTo:     relation,
 				BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 					ID:          msg.UserID + "/" + username.plain,
 					Displayname: username.plain,
@@ -452,16 +506,11 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			}
 		} else {
 			content = event.MessageEventContent{
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(&msg),
 				Body:          body,
 				FormattedBody: formattedBody,
 				Format:        event.FormatHTML,
-				RelatesTo: &event.RelatesTo{
-					Type: "m.reply",
-					InReplyTo: &event.InReplyTo{
-						EventID: id.EventID(msg.ParentID),
-					},
-				},
+				RelatesTo:     relation,
 			}
 		}
 
@@ -483,6 +532,9 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		if relation.Type == event.RelThread {
+			b.rememberThread(resp.EventID, relation.EventID)
+		}
 
 		return resp.EventID.String(), err
 	}
@@ -526,12 +578,25 @@ func (b *Bmatrix) handlematrix() {
 	}
 
 	syncer := b.mc.Syncer.(*mautrix.DefaultSyncer) //nolint:forcetypeassert // We're only using DefaultSyncer
+	b.lastSync.Store(time.Now().Unix())
 
 	readyChan := make(chan bool)
 	var once sync.Once
 
-	// Drop historical messages so they don't get forwarded to other bridges
+	// Resume the sync where it stopped before a restart
+	if err = b.setupSyncStore(syncer); err != nil {
+		b.Log.Errorf("Not keeping the sync token: %s", err)
+	}
+
+	// Drop historical messages so they don't get forwarded to other bridges,
+	// the first sync without a token returns the last messages of the rooms
 	syncer.OnSync(b.mc.DontProcessOldEvents)
+	// and the syncs resuming after a long downtime the messages sent meanwhile
+	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
+		b.lastSync.Store(time.Now().Unix())
+		dropOldEvents(resp, b.syncLookback(), time.Now())
+		return true
+	})
 	// Drop unencrypted room connection so we can reconnect encrypted if we are using encryption
 	syncer.OnSync(func(ctx context.Context, resp *mautrix.RespSync, since string) bool {
 		once.Do(func() {
@@ -544,6 +609,7 @@ func (b *Bmatrix) handlematrix() {
 	})
 	syncer.OnEventType(event.EventRedaction, b.handleRedactionEvent)
 	syncer.OnEventType(event.EventMessage, b.handleMessageEvent)
+	syncer.OnEventType(event.EventReaction, b.handleReactionEvent)
 	syncer.OnEventType(event.StateMember, b.handleMemberChange)
 	go func() {
 		for {
@@ -592,6 +658,9 @@ func (b *Bmatrix) handleEdit(ev *event.Event, rmsg config.Message) bool {
 
 	rmsg.ID = relation.EventID.String()
 	rmsg.Text = newContent.Body
+	if spoilerSpanRE.MatchString(newContent.FormattedBody) {
+		rmsg.Text = spoilersFromHTML(newContent.FormattedBody)
+	}
 	b.Remote <- rmsg
 
 	return true
@@ -600,7 +669,21 @@ func (b *Bmatrix) handleEdit(ev *event.Event, rmsg config.Message) bool {
 func (b *Bmatrix) handleReply(ev *event.Event, rmsg config.Message) bool {
 	relation := ev.Content.AsMessage().OptionalGetRelatesTo()
 
-	if relation == nil || relation.InReplyTo == nil || relation.InReplyTo.EventID == "" {
+	if relation == nil {
+		return false
+	}
+
+	var parentID id.EventID
+	switch {
+	case relation.Type == event.RelThread && relation.EventID != "":
+		// the messages of a thread are relayed as replies to its root,
+		// like those of the slack threads, for the other bridges to
+		// find their thread
+		b.rememberThread(ev.ID, relation.EventID)
+		parentID = relation.EventID
+	case relation.InReplyTo != nil && relation.InReplyTo.EventID != "":
+		parentID = relation.InReplyTo.EventID
+	default:
 		return false
 	}
 
@@ -619,7 +702,7 @@ func (b *Bmatrix) handleReply(ev *event.Event, rmsg config.Message) bool {
 
 	rmsg.Text = body
 
-	rmsg.ParentID = relation.InReplyTo.EventID.String()
+	rmsg.ParentID = parentID.String()
 	b.Remote <- rmsg
 
 	return true
@@ -649,10 +732,15 @@ func (b *Bmatrix) handleMemberChange(ctx context.Context, ev *event.Event) {
 	// Update the displayname on join messages, according to https://matrix.org/docs/spec/client_server/r0.6.1#events-on-change-of-profile-information
 	content := ev.Content.AsMember()
 
-	if content.Membership == event.MembershipJoin {
+	switch content.Membership {
+	case event.MembershipJoin:
 		if content.Displayname != "" {
 			b.cacheDisplayName(ev.Sender, content.Displayname)
 		}
+		b.setMemberName(ev.RoomID, id.UserID(ev.GetStateKey()), content.Displayname)
+	case event.MembershipLeave, event.MembershipBan:
+		// the state key is the member, the sender kicked or banned it
+		b.removeMember(ev.RoomID, id.UserID(ev.GetStateKey()))
 	}
 
 	if b.GetBool("nosendjoinpart") {
@@ -668,7 +756,7 @@ func (b *Bmatrix) handleMemberChange(ctx context.Context, ev *event.Event) {
 		}
 
 		msg := config.Message{
-			Username: b.getDisplayName(ctx, ev.Sender),
+			Username: b.getDisplayName(ev.RoomID, ev.Sender),
 			Channel:  channel,
 			Account:  b.Account,
 			UserID:   ev.Sender.String(),
@@ -719,9 +807,14 @@ func (b *Bmatrix) handleRedactionEvent(ctx context.Context, ev *event.Event) {
 		return
 	}
 
+	// Removed reaction
+	if b.handleReactionRedaction(ev.Redacts) {
+		return
+	}
+
 	// Create our message
 	rmsg := config.Message{
-		Username: b.getDisplayName(ctx, ev.Sender),
+		Username: b.getDisplayName(ev.RoomID, ev.Sender),
 		Channel:  channel,
 		Account:  b.Account,
 		UserID:   ev.Sender.String(),
@@ -731,8 +824,7 @@ func (b *Bmatrix) handleRedactionEvent(ctx context.Context, ev *event.Event) {
 
 	// Remove homeserver suffix if configured
 	if b.GetBool("NoHomeServerSuffix") {
-		re := regexp.MustCompile(`\s+\(@.*`)
-		rmsg.Username = re.ReplaceAllString(rmsg.Username, `$1`)
+		rmsg.Username = homeServerSuffixRE.ReplaceAllString(rmsg.Username, `$1`)
 	}
 
 	// Delete event
@@ -793,7 +885,7 @@ func (b *Bmatrix) handleMessageEvent(ctx context.Context, ev *event.Event) {
 
 	// Create our message
 	rmsg := config.Message{
-		Username: b.getDisplayName(ctx, ev.Sender),
+		Username: b.getDisplayName(ev.RoomID, ev.Sender),
 		Channel:  channel,
 		Account:  b.Account,
 		UserID:   ev.Sender.String(),
@@ -803,8 +895,7 @@ func (b *Bmatrix) handleMessageEvent(ctx context.Context, ev *event.Event) {
 
 	// Remove homeserver suffix if configured
 	if b.GetBool("NoHomeServerSuffix") {
-		re := regexp.MustCompile(`\s+\(@.*`)
-		rmsg.Username = re.ReplaceAllString(rmsg.Username, `$1`)
+		rmsg.Username = homeServerSuffixRE.ReplaceAllString(rmsg.Username, `$1`)
 	}
 
 	// Delete event as a relation
@@ -833,11 +924,20 @@ func (b *Bmatrix) handleMessageEvent(ctx context.Context, ev *event.Event) {
 		return
 	}
 
+	if formattedBody, ok := ev.Content.GetRaw()["formatted_body"].(string); ok && spoilerSpanRE.MatchString(formattedBody) {
+		rmsg.Text = spoilersFromHTML(formattedBody)
+	}
+
 	// Do we have a /me action
 	if ev.Content.AsMessage().MsgType == event.MsgEmote {
 		rmsg.Event = config.EventUserAction
 	}
 
+	// Notices are sent by bots and other automated clients
+	if ev.Content.AsMessage().MsgType == event.MsgNotice && b.GetBool("UseNotices") {
+		rmsg.MarkBot()
+	}
+
 	// Is it an edit?
 	if b.handleEdit(ev, rmsg) {
 		return
@@ -901,6 +1001,16 @@ func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content event.Content
 		return fmt.Errorf("mtype isn't a %T", mtype)
 	}
 
+	// When the file name is given apart (MSC2530), the body of an image is
+	// its description
+	var altText string
+	if filename, ok := content.Raw["filename"].(string); ok && filename != "" && filename != name {
+		if msgtype == string(event.MsgImage) {
+			altText = name
+		}
+		name = filename
+	}
+
 	b.Log.Debugf("Processing attachment %s with mimetype %s", name, mtype)
 
 	// If the mime library can't guess an appropriate extension for that
@@ -934,14 +1044,7 @@ func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content event.Content
 
 	// Until consensus emerges, we simply add an extension matching the mimetype
 	// if no extension at all was provided.
-	if path.Ext(name) == "" {
-		mext, err := mime.ExtensionsByType(mtype)
-		if err != nil {
-			return err
-		}
-
-		name += mext[0]
-	}
+	name = helper.AddFileExtension(name, mtype)
 
 	// Now that we have performed sanity checks and edited the filename,
 	// remove the message "body" (which was parsed into the filename) so
@@ -953,20 +1056,56 @@ func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content event.Content
 	if err != nil {
 		return err
 	}
+	helper.SetAltText(rmsg, altText)
+
+	// the duration of audio files is in milliseconds in their info
+	if msgtype == string(event.MsgAudio) {
+		if duration, ok := info["duration"].(float64); ok {
+			helper.SetDuration(rmsg, int(duration))
+		}
+	}
+
+	// MSC3245 voice messages are audio messages with an empty voice marker
+	if _, ok := content.Raw["org.matrix.msc3245.voice"]; ok {
+		duration, waveform := parseMSC1767Audio(content.Raw["org.matrix.msc1767.audio"])
+		helper.MarkVoiceNote(rmsg, duration, waveform)
+	}
+
 	return nil
 }
 
+// parseMSC1767Audio extracts duration (in milliseconds) and waveform from the
+// raw org.matrix.msc1767.audio content, zero values when missing.
+func parseMSC1767Audio(raw interface{}) (int, []int) {
+	audio, ok := raw.(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+
+	duration, _ := audio["duration"].(float64)
+
+	samples, _ := audio["waveform"].([]interface{})
+	waveform := make([]int, 0, len(samples))
+	for _, sample := range samples {
+		if amplitude, ok := sample.(float64); ok {
+			waveform = append(waveform, int(amplitude))
+		}
+	}
+
+	return int(duration), waveform
+}
+
 // handleUploadFiles handles native upload of files.
 func (b *Bmatrix) handleUploadFiles(msg *config.Message, roomID id.RoomID) (string, error) {
 	if msg.Text != "" {
 		username := newMatrixUsername(msg.Username)
 		b.Log.Debugf("Sending text message alongside attachment from %s", username.plain)
-		body := username.plain + msg.Text
+		body := username.plain + helper.HideSpoilers(msg.Text, b.GetString("SpoilerFormat"))
 		var formattedBody string
 		if b.GetBool("DisableMarkdownParsing") {
 			formattedBody = username.formatted + msg.Text
 		} else {
-			formattedBody = username.formatted + helper.ParseMarkdown(msg.Text, b.Log)
+			formattedBody = username.formatted + b.parseMarkdown(msg.Text)
 		}
 
 		// TODO: message ID
@@ -991,7 +1130,11 @@ func (b *Bmatrix) handleUploadFiles(msg *config.Message, roomID id.RoomID) (stri
 //nolint:funlen // This function is necessarily long because it is an event handler
 func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *config.FileInfo) {
 	username := newMatrixUsername(msg.Username)
-	content := bytes.NewReader(*fi.Data)
+	data, err := fi.Bytes()
+	if err != nil {
+		b.Log.WithError(err).Errorf("Failed to read %s", fi.Name)
+		return
+	}
 	sp := strings.Split(fi.Name, ".")
 	mtype := mime.TypeByExtension("." + sp[len(sp)-1])
 	// image and video uploads send no username, we have to do this ourself here #715
@@ -1013,24 +1156,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 		}
 	}
 
-	b.Log.Debugf("uploading file: %s %s", fi.Name, mtype)
-
-	var res *mautrix.RespMediaUpload
-
-	err := b.retry(func() error {
-		media := mautrix.ReqUploadMedia{
-			Content:       content,
-			ContentType:   mtype,
-			ContentLength: int64(len(*fi.Data)),
-		}
-
-		var err2 error
-
-		res, err2 = b.mc.UploadMedia(context.TODO(), media)
-
-		return err2
-	})
-
+	contentURI, err := b.uploadMedia(fi.Name, mtype, data)
 	if err != nil {
 		b.Log.Errorf("file upload failed: %#v", err)
 		return
@@ -1038,7 +1164,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 
 	switch {
 	case strings.Contains(mtype, "video"):
-		b.Log.Debugf("sendVideo %s", res.ContentURI)
+		b.Log.Debugf("sendVideo %s", contentURI)
 		err = b.retry(func() error {
 			var content event.MessageEventContent
 			if b.GetBool("UseMSC4144") {
@@ -1046,10 +1172,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgVideo,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 					BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 						ID:          msg.UserID + "/" + username.plain,
@@ -1065,10 +1191,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgVideo,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 				}
 			}
@@ -1081,9 +1207,9 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 			b.Log.Errorf("sendVideo failed: %#v", err)
 		}
 	case strings.Contains(mtype, "image"):
-		b.Log.Debugf("sendImage %s", res.ContentURI)
+		b.Log.Debugf("sendImage %s", contentURI)
 
-		cfg, format, err2 := image.DecodeConfig(bytes.NewReader(*fi.Data))
+		cfg, format, err2 := image.DecodeConfig(bytes.NewReader(data))
 		if err2 != nil {
 			b.Log.WithError(err2).Errorf("Failed to decode image %s", fi.Name)
 			return
@@ -1098,10 +1224,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				MsgType:  event.MsgImage,
 				Body:     username.plain + ": " + fi.Name,
 				FileName: fi.Name,
-				URL:      id.ContentURIString(res.ContentURI.String()),
+				URL:      id.ContentURIString(contentURI.String()),
 				Info: &event.FileInfo{
 					MimeType: mtype,
-					Size:     len(*fi.Data),
+					Size:     len(data),
 					Width:    cfg.Width,  // #nosec G115 -- go std will not returned negative size
 					Height:   cfg.Height, // #nosec G115 -- go std will not returned negative size
 				},
@@ -1120,14 +1246,20 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 			img = event.MessageEventContent{
 				MsgType: event.MsgImage,
 				Body:    fi.Name,
-				URL:     id.ContentURIString(res.ContentURI.String()),
+				URL:     id.ContentURIString(contentURI.String()),
 				Info: &event.FileInfo{
 					MimeType: mtype,
-					Size:     len(*fi.Data),
+					Size:     len(data),
 					Width:    cfg.Width,  // #nosec G115 -- go std will not returned negative size
 					Height:   cfg.Height, // #nosec G115 -- go std will not returned negative size
 				},
 			}
+			// the body of an image is its description when the file name is
+			// given apart
+			if fi.AltText != "" {
+				img.Body = fi.AltText
+				img.FileName = fi.Name
+			}
 		}
 
 		err = b.retry(func() error {
@@ -1145,7 +1277,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 			return false
 		}
 	}():
-		b.Log.Debugf("sendAudio %s", res.ContentURI)
+		b.Log.Debugf("sendAudio %s", contentURI)
 		err = b.retry(func() error {
 			var content event.MessageEventContent
 			if b.GetBool("UseMSC4144") {
@@ -1153,10 +1285,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgAudio,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 					BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 						ID:          msg.UserID + "/" + username.plain,
@@ -1172,13 +1304,23 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgAudio,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 				}
 			}
+			if fi.Duration > 0 {
+				content.Info.Duration = fi.Duration
+			}
+			if fi.Voice {
+				content.MSC3245Voice = &event.MSC3245Voice{}
+				content.MSC1767Audio = &event.MSC1767Audio{
+					Duration: fi.Duration,
+					Waveform: fi.Waveform,
+				}
+			}
 			_, err2 := b.mc.SendMessageEvent(context.TODO(), roomID, event.EventMessage, content)
 			return err2
 		})
@@ -1186,7 +1328,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 			b.Log.Errorf("sendAudio failed: %#v", err)
 		}
 	default:
-		b.Log.Debugf("sendFile %s", res.ContentURI)
+		b.Log.Debugf("sendFile %s", contentURI)
 		err = b.retry(func() error {
 			var content event.MessageEventContent
 			if b.GetBool("UseMSC4144") {
@@ -1194,10 +1336,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgFile,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 					BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 						ID:          msg.UserID + "/" + username.plain,
@@ -1213,10 +1355,10 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 				content = event.MessageEventContent{
 					MsgType:  event.MsgFile,
 					FileName: fi.Name,
-					URL:      id.ContentURIString(res.ContentURI.String()),
+					URL:      id.ContentURIString(contentURI.String()),
 					Info: &event.FileInfo{
 						MimeType: mtype,
-						Size:     len(*fi.Data),
+						Size:     len(data),
 					},
 				}
 			}
@@ -1229,7 +1371,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, roomID id.RoomID, fi *co
 			b.Log.Errorf("sendFile failed: %#v", err)
 		}
 	}
-	b.Log.Debugf("result: %#v", res)
+	b.Log.Debugf("result: %s", contentURI)
 }
 
 func (b *Bmatrix) sendNormalMessage(roomID id.RoomID, body string, formattedBody string, username *matrixUsername, msg *config.Message) (string, error) {
@@ -1254,7 +1396,7 @@ func (b *Bmatrix) sendNormalMessagePlaintext(roomID id.RoomID, body string, user
 			body, _ = strings.CutPrefix(body, username.plain)
 			body = username.plain + ": " + body
 			content := event.MessageEventContent{
-				MsgType: event.MsgText,
+				MsgType: b.textMsgType(msg),
 				Body:    body,
 				BeeperPerMessageProfile: &event.BeeperPerMessageProfile{
 					ID:          msg.UserID + "/" + username.plain,
@@ -1264,6 +1406,8 @@ func (b *Bmatrix) sendNormalMessagePlaintext(roomID id.RoomID, body string, user
 				},
 			}
 			resp, err = b.mc.SendMessageEvent(context.TODO(), roomID, event.EventMessage, content)
+		} else if b.useNotice(msg) {
+			resp, err = b.mc.SendNotice(context.TODO(), roomID, body)
 		} else {
 			resp, err = b.mc.SendText(context.TODO(), roomID, body)
 		}
@@ -1291,7 +1435,7 @@ func (b *Bmatrix) sendNormalMessageHTML(roomID id.RoomID, body string, formatted
 			formattedBody = "<strong data-mx-profile-fallback>" + username.formatted + ": </strong>" + formattedBody
 			avatar := b.handleAvatar(msg.Avatar)
 			content = event.MessageEventContent{
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(msg),
 				Body:          body,
 				FormattedBody: formattedBody,
 				Format:        event.FormatHTML,
@@ -1304,7 +1448,7 @@ func (b *Bmatrix) sendNormalMessageHTML(roomID id.RoomID, body string, formatted
 			}
 		} else {
 			content = event.MessageEventContent{
-				MsgType:       event.MsgText,
+				MsgType:       b.textMsgType(msg),
 				Body:          body,
 				FormattedBody: formattedBody,
 				Format:        event.FormatHTML,
@@ -1,9 +1,25 @@
 package bmatrix
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mautrix "maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestPlainUsername(t *testing.T) {
@@ -26,3 +42,277 @@ func TestFancyUsername(t *testing.T) {
 	assert.Equal(t, "&lt;MyUser&gt;", uut.formatted)
 	assert.Equal(t, "<MyUser>", uut.plain)
 }
+
+func TestSpoilersFromHTML(t *testing.T) {
+	assert.Equal(t, "it was ||Bruce Willis||\nall along & more",
+		spoilersFromHTML(`<mx-reply><blockquote>quoted</blockquote></mx-reply>it was <span data-mx-spoiler="movie">Bruce <b>Willis</b></span><br/>all along &amp; more`))
+	assert.Equal(t, "||a|| and ||b||", spoilersFromHTML(`<span data-mx-spoiler>a</span> and <span data-mx-spoiler>b</span>`))
+}
+
+func TestMemberName(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, `{"joined": {"@alice:example.org": {"display_name": "Alice"}, "@bob:example.org": {}}}`)
+	}))
+	defer server.Close()
+
+	mc, err := mautrix.NewClient(server.URL, "@bridge:example.org", "token")
+	require.NoError(t, err)
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Log: logrus.NewEntry(logrus.New())}}).(*Bmatrix)
+	b.mc = mc
+
+	roomID := id.RoomID("!room:example.org")
+	_, ok := b.memberName(roomID, "@alice:example.org")
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		name, ok := b.memberName(roomID, "@alice:example.org")
+		return ok && name == "Alice"
+	}, time.Second, 10*time.Millisecond)
+	name, ok := b.memberName(roomID, "@bob:example.org")
+	assert.True(t, ok)
+	assert.Empty(t, name)
+
+	// unknown senders don't fetch the members again right away
+	_, ok = b.memberName(roomID, "@carol:example.org")
+	assert.False(t, ok)
+	b.setMemberName(roomID, "@carol:example.org", "Carol")
+	name, _ = b.memberName(roomID, "@carol:example.org")
+	assert.Equal(t, "Carol", name)
+	b.removeMember(roomID, "@alice:example.org")
+	_, ok = b.memberName(roomID, "@alice:example.org")
+	assert.False(t, ok)
+
+	assert.Equal(t, 1, fetches)
+}
+
+func TestUploadMedia(t *testing.T) {
+	var uploads []string
+	done := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploads = append(uploads, r.Method+" "+r.URL.Path+" "+string(body))
+		switch r.URL.Path {
+		case "/_matrix/media/v1/create":
+			fmt.Fprint(w, `{"content_uri": "mxc://example.org/async"}`)
+		case "/_matrix/media/v3/upload":
+			fmt.Fprint(w, `{"content_uri": "mxc://example.org/sync"}`)
+		default:
+			fmt.Fprint(w, `{}`)
+			done <- struct{}{}
+		}
+	}))
+	defer server.Close()
+
+	mc, err := mautrix.NewClient(server.URL, "@bridge:example.org", "token")
+	require.NoError(t, err)
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Log: logrus.NewEntry(logrus.New())}}).(*Bmatrix)
+	b.mc = mc
+
+	uri, err := b.uploadMedia("cat.png", "image/png", []byte("sync"))
+	require.NoError(t, err)
+	assert.Equal(t, "mxc://example.org/sync", uri.String())
+
+	// the content URI is returned before the data is uploaded
+	b.asyncUploads = true
+	uri, err = b.uploadMedia("cat.png", "image/png", []byte("async"))
+	require.NoError(t, err)
+	assert.Equal(t, "mxc://example.org/async", uri.String())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the data wasn't uploaded")
+	}
+	assert.Equal(t, []string{
+		"POST /_matrix/media/v3/upload sync",
+		"POST /_matrix/media/v1/create {}",
+		"PUT /_matrix/media/v3/upload/example.org/async async",
+	}, uploads)
+}
+
+func TestNotices(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		fmt.Fprint(w, `{"event_id": "$event"}`)
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[matrix.test]\nUseNotices=true\n"))
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Account: "matrix.test", Config: cfg, Log: logrus.NewEntry(logger)}}).(*Bmatrix)
+	mc, err := mautrix.NewClient(server.URL, "@bridge:example.org", "token")
+	require.NoError(t, err)
+	b.mc = mc
+
+	bot := config.Message{Text: "build passed"}
+	bot.MarkBot()
+	assert.Equal(t, event.MsgNotice, b.textMsgType(&bot))
+	assert.Equal(t, event.MsgText, b.textMsgType(&config.Message{Text: "hello"}))
+	assert.True(t, b.useNotice(&config.Message{Event: config.EventTopicChange}))
+	assert.True(t, b.useNotice(&config.Message{Event: config.EventJoinLeave}))
+
+	username := newMatrixUsername("")
+	_, err = b.sendNormalMessage("!room:example.org", "build passed", "build passed", username, &bot)
+	require.NoError(t, err)
+	_, err = b.sendNormalMessage("!room:example.org", "hello", "hello", username, &config.Message{Text: "hello"})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[0], `"msgtype":"m.notice"`)
+	assert.Contains(t, bodies[1], `"msgtype":"m.text"`)
+
+	// without UseNotices only the events are notices
+	b.SetBool("UseNotices", false)
+	assert.Equal(t, event.MsgText, b.textMsgType(&bot))
+	assert.False(t, b.useNotice(&config.Message{Event: config.EventTopicChange}))
+	assert.True(t, b.useNotice(&config.Message{Event: config.EventAnnounce}))
+}
+
+func TestSyncFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sync.json")
+	filter := `{"a":1}`
+	filterJSON := func(id.UserID) string { return filter }
+	ctx := context.Background()
+	user := id.UserID("@bot:example.org")
+
+	store, err := newSyncFileStore(file, filterJSON)
+	require.NoError(t, err)
+	token, err := store.LoadNextBatch(ctx, user)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	require.NoError(t, store.SaveNextBatch(ctx, user, "s42"))
+	require.NoError(t, store.SaveFilterID(ctx, user, "7"))
+
+	store, err = newSyncFileStore(file, filterJSON)
+	require.NoError(t, err)
+	token, err = store.LoadNextBatch(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, "s42", token)
+	filterID, err := store.LoadFilterID(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, "7", filterID)
+
+	// the filter is created again when it changed
+	filter = `{"a":2}`
+	filterID, err = store.LoadFilterID(ctx, user)
+	require.NoError(t, err)
+	assert.Empty(t, filterID)
+
+	// without SyncFile the tokens are kept in the storage, by account
+	kv := storage.NewMemory()
+	store, err = newSyncStorageStore(kv, "matrix.test", filterJSON)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveNextBatch(ctx, user, "s43"))
+	store, err = newSyncStorageStore(kv, "matrix.test", filterJSON)
+	require.NoError(t, err)
+	token, err = store.LoadNextBatch(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, "s43", token)
+	keys, err := kv.Keys(syncStorageNamespace)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"matrix.test"}, keys)
+}
+
+func TestDropOldEvents(t *testing.T) {
+	now := time.Now()
+	stateKey := ""
+	old := &event.Event{Type: event.EventMessage, Timestamp: now.Add(-2 * time.Hour).UnixMilli()}
+	oldState := &event.Event{Type: event.StateMember, Timestamp: now.Add(-2 * time.Hour).UnixMilli(), StateKey: &stateKey}
+	recent := &event.Event{Type: event.EventMessage, Timestamp: now.Add(-time.Minute).UnixMilli()}
+	room := &mautrix.SyncJoinedRoom{}
+	room.Timeline.Events = []*event.Event{old, oldState, recent}
+	resp := &mautrix.RespSync{}
+	resp.Rooms.Join = map[id.RoomID]*mautrix.SyncJoinedRoom{"!room:example.org": room}
+
+	dropOldEvents(resp, time.Hour, now)
+	assert.Equal(t, []*event.Event{oldState, recent}, room.Timeline.Events)
+}
+
+func TestPermalink(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[matrix.test]\n"))
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Account: "matrix.test", Config: cfg, Log: logrus.NewEntry(logger)}}).(*Bmatrix)
+	b.UserID = "@bridge:example.org"
+	b.RoomMap["!room:example.com"] = "#project:example.com"
+
+	link, err := b.Permalink("#project:example.com", "$event")
+	require.NoError(t, err)
+	assert.Equal(t, "https://matrix.to/#/%21room:example.com/$event?via=example.org", link)
+	_, err = b.Permalink("#other:example.com", "$event")
+	assert.Error(t, err)
+}
+
+func TestReactions(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+strings.Join(strings.Split(r.URL.Path, "/")[6:8], "/")+" "+string(body))
+		fmt.Fprint(w, `{"event_id": "$reaction"}`)
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[matrix.test]\n"))
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Account: "matrix.test", Config: cfg, Log: logrus.NewEntry(logger)}, Remote: make(chan config.Message, 1)}).(*Bmatrix)
+	mc, err := mautrix.NewClient(server.URL, "@bridge:example.org", "token")
+	require.NoError(t, err)
+	b.mc = mc
+
+	reaction := config.Message{Event: config.EventReaction, Text: ":thumbsup:", ParentID: "$parent"}
+	_, err = b.sendReaction(&reaction, "!room:example.org")
+	require.NoError(t, err)
+	reaction.MarkReactionRemoved()
+	_, err = b.sendReaction(&reaction, "!room:example.org")
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	assert.Equal(t, `PUT send/m.reaction {"m.relates_to":{"rel_type":"m.annotation","event_id":"$parent","key":"👍"}}`, requests[0])
+	assert.True(t, strings.HasPrefix(requests[1], "PUT redact/$reaction "))
+
+	// the redaction of a reaction received removes it
+	b.reactions.Add("$received", matrixReaction{parent: "$parent", key: "👍", rmsg: config.Message{Event: config.EventReaction, Text: "👍", ParentID: "$parent"}})
+	assert.False(t, b.handleReactionRedaction("$message"))
+	require.True(t, b.handleReactionRedaction("$received"))
+	removed := <-b.Remote
+	assert.Equal(t, "$parent", removed.ParentID)
+	assert.True(t, removed.ReactionRemoved())
+}
+
+func TestThreads(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[matrix.test]\n"))
+	b := New(&bridge.Config{Bridge: &bridge.Bridge{Account: "matrix.test", Config: cfg, Log: logrus.NewEntry(logger)}, Remote: make(chan config.Message, 1)}).(*Bmatrix)
+
+	// the messages of a thread are relayed as replies to its root
+	thread := &event.Event{ID: "$second", Content: event.Content{Parsed: &event.MessageEventContent{
+		MsgType:   event.MsgText,
+		Body:      "in the thread",
+		RelatesTo: (&event.RelatesTo{}).SetThread("$root", "$first"),
+	}}}
+	require.True(t, b.handleReply(thread, config.Message{Text: "in the thread"}))
+	assert.Equal(t, "$root", (<-b.Remote).ParentID)
+
+	// the replies are plain replies without PreserveThreading
+	assert.Equal(t, &event.RelatesTo{Type: "m.reply", InReplyTo: &event.InReplyTo{EventID: "$second"}}, b.replyRelation("$second"))
+
+	b.SetBool("PreserveThreading", true)
+	relation := b.replyRelation("$second")
+	assert.Equal(t, event.RelThread, relation.Type)
+	assert.Equal(t, id.EventID("$root"), relation.EventID)
+	assert.Equal(t, id.EventID("$second"), relation.GetReplyTo())
+	assert.False(t, relation.IsFallingBack)
+	relation = b.replyRelation("$root")
+	assert.Equal(t, id.EventID("$root"), relation.EventID)
+	assert.True(t, relation.IsFallingBack)
+	assert.Equal(t, "m.reply", string(b.replyRelation("$other").Type))
+
+	// ThreadReplies starts a thread from the other messages
+	b.SetBool("ThreadReplies", true)
+	relation = b.replyRelation("$other")
+	assert.Equal(t, event.RelThread, relation.Type)
+	assert.Equal(t, id.EventID("$other"), relation.EventID)
+}
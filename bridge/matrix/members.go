@@ -0,0 +1,111 @@
+package bmatrix
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// membersTTL is the age from which the members of a room are fetched
+	// again, the membership events keep them up to date in between.
+	membersTTL = time.Hour
+	// membersRefreshDelay spaces the fetches of the members of a room
+	// triggered by senders which aren't known yet.
+	membersRefreshDelay = time.Minute
+)
+
+// roomMembers are the display names of the joined members of a room, fetched
+// at once with joined_members so that the senders of the messages don't need a
+// profile query each.
+type roomMembers struct {
+	names     map[id.UserID]string
+	fetchedAt time.Time
+	fetching  bool
+}
+
+// memberName returns the display name of mxid in roomID, and false when it
+// isn't known yet. The members of the room are then fetched in the background,
+// as when they are stale, so that the callers never wait for the homeserver.
+func (b *Bmatrix) memberName(roomID id.RoomID, mxid id.UserID) (string, bool) {
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+
+	members := b.members[roomID]
+	if members == nil {
+		members = &roomMembers{names: make(map[id.UserID]string)}
+		b.members[roomID] = members
+	}
+	name, ok := members.names[mxid]
+	age := time.Since(members.fetchedAt)
+	if age > membersTTL || !ok && age > membersRefreshDelay {
+		b.refreshMembersLocked(roomID, members)
+	}
+	return name, ok
+}
+
+// refreshMembers fetches the members of roomID in the background.
+func (b *Bmatrix) refreshMembers(roomID id.RoomID) {
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+
+	members := b.members[roomID]
+	if members == nil {
+		members = &roomMembers{names: make(map[id.UserID]string)}
+		b.members[roomID] = members
+	}
+	b.refreshMembersLocked(roomID, members)
+}
+
+// refreshMembersLocked starts the fetch of the members of roomID, unless one is
+// running. It must be called with membersMutex locked.
+func (b *Bmatrix) refreshMembersLocked(roomID id.RoomID, members *roomMembers) {
+	if members.fetching {
+		return
+	}
+	members.fetching = true
+	go b.fetchMembers(roomID, members)
+}
+
+func (b *Bmatrix) fetchMembers(roomID id.RoomID, members *roomMembers) {
+	resp, err := b.mc.JoinedMembers(context.TODO(), roomID)
+
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+
+	members.fetching = false
+	// the failed fetches are spaced like the successful ones
+	members.fetchedAt = time.Now()
+	if err != nil {
+		b.Log.Errorf("Retrieving the members of %s failed: %s", roomID, err)
+		return
+	}
+
+	members.names = make(map[id.UserID]string, len(resp.Joined))
+	for mxid, member := range resp.Joined {
+		members.names[mxid] = member.DisplayName
+	}
+	b.Log.Debugf("Retrieved %d members of %s", len(resp.Joined), roomID)
+}
+
+// setMemberName records the display name of mxid which joined roomID, or
+// changed its profile.
+func (b *Bmatrix) setMemberName(roomID id.RoomID, mxid id.UserID, name string) {
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+
+	if members := b.members[roomID]; members != nil {
+		members.names[mxid] = name
+	}
+}
+
+// removeMember forgets mxid which left roomID.
+func (b *Bmatrix) removeMember(roomID id.RoomID, mxid id.UserID) {
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+
+	if members := b.members[roomID]; members != nil {
+		delete(members.names, mxid)
+	}
+}
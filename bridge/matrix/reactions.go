@@ -0,0 +1,125 @@
+package bmatrix
+
+import (
+	"context"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// reactionsSize is the number of reactions remembered to relay their removal.
+const reactionsSize = 5000
+
+// matrixReaction is a reaction received, the redaction of its event removes
+// it.
+type matrixReaction struct {
+	parent id.EventID
+	key    string
+	rmsg   config.Message
+}
+
+// sentReactionKey is the key of the event of a reaction sent to parent.
+func sentReactionKey(parent string, key string) string {
+	return "sent " + parent + " " + key
+}
+
+func (b *Bmatrix) handleReactionEvent(ctx context.Context, ev *event.Event) {
+	b.Log.Debugf("== Receiving reaction event: %#v", ev)
+
+	if ev.Sender == b.UserID {
+		return
+	}
+
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+
+	if !ok {
+		b.Log.Debugf("Unknown room %s", ev.RoomID)
+		return
+	}
+
+	relation := ev.Content.AsReaction().RelatesTo
+	if relation.Type != event.RelAnnotation || relation.EventID == "" || relation.Key == "" {
+		return
+	}
+
+	rmsg := config.Message{
+		Event:    config.EventReaction,
+		Text:     relation.Key,
+		Username: b.getDisplayName(ev.RoomID, ev.Sender),
+		Channel:  channel,
+		Account:  b.Account,
+		UserID:   ev.Sender.String(),
+		ParentID: relation.EventID.String(),
+		Avatar:   b.getAvatarURL(ctx, ev.Sender),
+	}
+
+	// Remove homeserver suffix if configured
+	if b.GetBool("NoHomeServerSuffix") {
+		rmsg.Username = homeServerSuffixRE.ReplaceAllString(rmsg.Username, `$1`)
+	}
+
+	b.reactions.Add(ev.ID.String(), matrixReaction{parent: relation.EventID, key: relation.Key, rmsg: rmsg})
+	b.Log.Debugf("<= Reaction is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// handleReactionRedaction relays the removal of a reaction when the event
+// redacted is one, and returns whether it was.
+func (b *Bmatrix) handleReactionRedaction(redacts id.EventID) bool {
+	known, ok := b.reactions.Get(redacts.String())
+	if !ok {
+		return false
+	}
+	b.reactions.Remove(redacts.String())
+	rmsg := known.(matrixReaction).rmsg //nolint:forcetypeassert
+	rmsg.MarkReactionRemoved()
+	b.Log.Debugf("<= Reaction removal is %#v", rmsg)
+	b.Remote <- rmsg
+	return true
+}
+
+// sendReaction adds the reaction in msg.Text to the event referenced by
+// msg.ParentID, or redacts the one added before when the message is flagged
+// with MarkReactionRemoved.
+func (b *Bmatrix) sendReaction(msg *config.Message, roomID id.RoomID) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+	key := strings.TrimSpace(helper.EmojiToUnicode(msg.Text))
+	sentKey := sentReactionKey(msg.ParentID, key)
+
+	if msg.ReactionRemoved() {
+		sent, ok := b.reactions.Get(sentKey)
+		if !ok {
+			b.Log.Debugf("Dropping reaction removal %#v, the reaction is unknown", msg)
+			return "", nil
+		}
+		b.reactions.Remove(sentKey)
+		return "", b.retry(func() error {
+			_, err := b.mc.RedactEvent(context.TODO(), roomID, sent.(id.EventID), mautrix.ReqRedact{}) //nolint:forcetypeassert
+			return err
+		})
+	}
+
+	var eventID id.EventID
+	err := b.retry(func() error {
+		resp, err := b.mc.SendReaction(context.TODO(), roomID, id.EventID(msg.ParentID), key)
+		if err != nil {
+			return err
+		}
+		eventID = resp.EventID
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b.reactions.Add(sentKey, eventID)
+	return "", nil
+}
@@ -0,0 +1,197 @@
+package bmatrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	mautrix "maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// defaultSyncLookback is the age of the oldest events relayed after a restart,
+// unless SyncLookback is set.
+const defaultSyncLookback = time.Hour
+
+// syncStaleAfter is the time after which the sync of the bridge is considered
+// stuck, the syncs wait for events for 30 seconds.
+const syncStaleAfter = 5 * time.Minute
+
+// syncStorageNamespace is the namespace of the sync tokens kept in the
+// storage without SyncFile, by account.
+const syncStorageNamespace = "matrix-sync"
+
+// syncFileStore is a mautrix.SyncStore keeping the sync token in SyncFile, or in
+// the storage, so that the bridge resumes the sync where it stopped after a
+// restart. The filter is kept with its JSON and created again when the filter
+// of the bridge changed.
+type syncFileStore struct {
+	path string
+	// store and key are where the tokens are kept without SyncFile
+	store storage.Store
+	key   string
+	// filterJSON returns the JSON of the current filter of the user
+	filterJSON func(userID id.UserID) string
+
+	mu   sync.Mutex
+	data syncFileData
+}
+
+type syncFileData struct {
+	NextBatch map[id.UserID]string      `json:"next_batch"`
+	Filters   map[id.UserID]savedFilter `json:"filters"`
+}
+
+type savedFilter struct {
+	ID   string `json:"id"`
+	JSON string `json:"json"`
+}
+
+var _ mautrix.SyncStore = (*syncFileStore)(nil)
+
+// newSyncFileStore reads the sync tokens of path, which doesn't have to exist.
+func newSyncFileStore(path string, filterJSON func(userID id.UserID) string) (*syncFileStore, error) {
+	s := &syncFileStore{path: path, filterJSON: filterJSON}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := s.load(data); err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", path, err)
+	}
+	return s, nil
+}
+
+// newSyncStorageStore reads the sync tokens kept under key in store.
+func newSyncStorageStore(store storage.Store, key string, filterJSON func(userID id.UserID) string) (*syncFileStore, error) {
+	s := &syncFileStore{store: store, key: key, filterJSON: filterJSON}
+	data, err := store.Get(syncStorageNamespace, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if err := s.load(data); err != nil {
+		return nil, fmt.Errorf("reading the sync tokens of %s failed: %w", key, err)
+	}
+	return s, nil
+}
+
+// load sets the data of the store from its JSON, empty when nothing was saved.
+func (s *syncFileStore) load(data []byte) error {
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.data); err != nil {
+			return err
+		}
+	}
+	if s.data.NextBatch == nil {
+		s.data.NextBatch = make(map[id.UserID]string)
+	}
+	if s.data.Filters == nil {
+		s.data.Filters = make(map[id.UserID]savedFilter)
+	}
+	return nil
+}
+
+func (s *syncFileStore) SaveFilterID(_ context.Context, userID id.UserID, filterID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Filters[userID] = savedFilter{ID: filterID, JSON: s.filterJSON(userID)}
+	return s.save()
+}
+
+func (s *syncFileStore) LoadFilterID(_ context.Context, userID id.UserID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filter := s.data.Filters[userID]
+	if filter.JSON != s.filterJSON(userID) {
+		return "", nil
+	}
+	return filter.ID, nil
+}
+
+func (s *syncFileStore) SaveNextBatch(_ context.Context, userID id.UserID, nextBatchToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.NextBatch[userID] == nextBatchToken {
+		return nil
+	}
+	s.data.NextBatch[userID] = nextBatchToken
+	return s.save()
+}
+
+func (s *syncFileStore) LoadNextBatch(_ context.Context, userID id.UserID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.NextBatch[userID], nil
+}
+
+// save writes the store to its file or the storage, it must be called with the
+// store locked.
+func (s *syncFileStore) save() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.store != nil {
+		return s.store.Put(syncStorageNamespace, s.key, data)
+	}
+	// replace the file at once, a crash must not lose the token
+	if err := os.WriteFile(s.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+// setupSyncStore keeps the sync token in SyncFile when it is set, or in the
+// storage.
+func (b *Bmatrix) setupSyncStore(syncer *mautrix.DefaultSyncer) error {
+	file := b.GetString("SyncFile")
+	if file == "" && b.Store == nil {
+		return nil
+	}
+	filterJSON := func(userID id.UserID) string {
+		data, _ := json.Marshal(syncer.GetFilterJSON(userID))
+		return string(data)
+	}
+	var store *syncFileStore
+	var err error
+	if file != "" {
+		store, err = newSyncFileStore(file, filterJSON)
+	} else {
+		store, err = newSyncStorageStore(b.Store, b.Account, filterJSON)
+	}
+	if err != nil {
+		return err
+	}
+	b.mc.Store = store
+	return nil
+}
+
+// syncLookback returns the age of the oldest events relayed, which the syncs
+// resuming from SyncFile may return.
+func (b *Bmatrix) syncLookback() time.Duration {
+	if seconds := b.GetInt("SyncLookback"); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultSyncLookback
+}
+
+// dropOldEvents removes the events older than the look-back window from the
+// timelines of resp, the messages sent while the bridge was down for longer
+// aren't relayed. The state events are kept for the state store.
+func dropOldEvents(resp *mautrix.RespSync, lookback time.Duration, now time.Time) {
+	oldest := now.Add(-lookback).UnixMilli()
+	for _, room := range resp.Rooms.Join {
+		events := room.Timeline.Events[:0]
+		for _, ev := range room.Timeline.Events {
+			if ev.Timestamp >= oldest || ev.StateKey != nil {
+				events = append(events, ev)
+			}
+		}
+		room.Timeline.Events = events
+	}
+}
@@ -0,0 +1,52 @@
+package bmatrix
+
+import (
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// threadsSize is the number of events remembered with the root of their
+// thread, to send the replies to them in the thread.
+const threadsSize = 5000
+
+// rememberThread records that the event eventID is in the thread started by
+// root, as well as root itself.
+func (b *Bmatrix) rememberThread(eventID id.EventID, root id.EventID) {
+	b.threads.Add(root, root)
+	if eventID != "" {
+		b.threads.Add(eventID, root)
+	}
+}
+
+// threadRoot returns the root of the thread of the event eventID, when it is
+// known to be in a thread or to have started one.
+func (b *Bmatrix) threadRoot(eventID id.EventID) (id.EventID, bool) {
+	v, ok := b.threads.Get(eventID)
+	if !ok {
+		return "", false
+	}
+	root, ok := v.(id.EventID)
+	return root, ok
+}
+
+// replyRelation returns the relation of a reply to the event parent. With
+// PreserveThreading the replies to the events of a thread are sent to the
+// thread, and with ThreadReplies all the replies start a thread when their
+// parent isn't in one. The others are plain replies.
+func (b *Bmatrix) replyRelation(parent id.EventID) *event.RelatesTo {
+	root, inThread := b.threadRoot(parent)
+	if !inThread && b.GetBool("ThreadReplies") {
+		root, inThread = parent, true
+	}
+	if !b.GetBool("PreserveThreading") || !inThread {
+		return &event.RelatesTo{
+			Type:      "m.reply",
+			InReplyTo: &event.InReplyTo{EventID: parent},
+		}
+	}
+	relation := (&event.RelatesTo{}).SetThread(root, parent)
+	// the reply to a message of the thread isn't a fallback for the
+	// clients without threads, it is shown as a reply by the others too
+	relation.IsFallingBack = parent == root
+	return relation
+}
@@ -0,0 +1,132 @@
+package bmatrix
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	mautrix "maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// uploadProgressSteps is the number of parts of an upload whose progress is
+// logged.
+const uploadProgressSteps = 4
+
+// progressReader logs the progress of the upload of size bytes.
+type progressReader struct {
+	r     io.Reader
+	log   *logrus.Entry
+	size  int64
+	start time.Time
+	sent  int64
+	step  int64
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.sent += int64(n)
+	if p.size <= 0 {
+		return n, err
+	}
+	// the last step is logged once the homeserver answered
+	if step := p.sent * uploadProgressSteps / p.size; step > p.step && step < uploadProgressSteps {
+		p.step = step
+		p.log.WithFields(logrus.Fields{
+			"sent":    p.sent,
+			"elapsed": time.Since(p.start).Round(time.Millisecond),
+		}).Debugf("Uploading %d%%", step*100/uploadProgressSteps)
+	}
+	return n, err
+}
+
+// detectAsyncUploads checks if the homeserver supports the asynchronous
+// uploads of MSC2246 (matrix v1.7).
+func (b *Bmatrix) detectAsyncUploads() {
+	versions, err := b.mc.Versions(context.TODO())
+	if err != nil {
+		b.Log.WithError(err).Warn("Failed to get the versions of the homeserver, uploading media synchronously")
+		return
+	}
+	b.asyncUploads = versions.Supports(mautrix.FeatureAsyncUploads)
+	if b.asyncUploads {
+		b.Log.Info("Homeserver supports asynchronous media uploads")
+	}
+}
+
+// uploadMedia uploads data and returns its content URI. When the homeserver
+// supports asynchronous uploads, the URI is created first and returned right
+// away: the event referencing it is sent while the data follows in the
+// background.
+func (b *Bmatrix) uploadMedia(name string, mtype string, data []byte) (id.ContentURI, error) {
+	log := b.Log.WithFields(logrus.Fields{"file": name, "type": mtype, "size": len(data)})
+	req := mautrix.ReqUploadMedia{ContentType: mtype}
+
+	if b.asyncUploads {
+		var mxc *mautrix.RespCreateMXC
+		err := b.retry(func() error {
+			var err2 error
+			mxc, err2 = b.mc.CreateMXC(context.TODO())
+			return err2
+		})
+		if err == nil {
+			req.MXC = mxc.ContentURI
+			req.UnstableUploadURL = mxc.UnstableUploadURL
+			log = log.WithFields(logrus.Fields{"mxc": mxc.ContentURI.String(), "async": true})
+			go b.uploadInBackground(log, req, data)
+			return mxc.ContentURI, nil
+		}
+		log.WithError(err).Warn("Failed to create the media URI, uploading synchronously")
+	}
+
+	var res *mautrix.RespMediaUpload
+	err := b.retry(func() error {
+		var err2 error
+		res, err2 = b.putMedia(log, req, data)
+		return err2
+	})
+	if err != nil {
+		return id.ContentURI{}, err
+	}
+	return res.ContentURI, nil
+}
+
+// uploadInBackground uploads the data of a content URI created beforehand.
+// The events referencing it are already sent, a failure is only logged.
+func (b *Bmatrix) uploadInBackground(log *logrus.Entry, req mautrix.ReqUploadMedia, data []byte) {
+	for {
+		_, err := b.putMedia(log, req, data)
+		if err == nil {
+			return
+		}
+		backoff, ok := b.handleRatelimit(err)
+		if !ok {
+			log.Error("Asynchronous media upload failed, the file won't be available")
+			return
+		}
+		time.Sleep(backoff)
+	}
+}
+
+// putMedia makes one attempt to upload data, logging its progress and how
+// long it took.
+func (b *Bmatrix) putMedia(log *logrus.Entry, req mautrix.ReqUploadMedia, data []byte) (*mautrix.RespMediaUpload, error) {
+	start := time.Now()
+	// a new reader for each attempt
+	req.Content = &progressReader{r: bytes.NewReader(data), log: log, size: int64(len(data)), start: start}
+	req.ContentLength = int64(len(data))
+	log.Debug("Uploading media")
+
+	res, err := b.mc.UploadMedia(context.TODO(), req)
+	log = log.WithField("duration", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		if _, limited := b.handleRatelimit(err); !limited {
+			log.WithError(err).Warn("Media upload failed")
+		}
+		return nil, err
+	}
+	log.Debug("Media uploaded")
+	return res, nil
+}
@@ -0,0 +1,155 @@
+package bmattermost
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge/matterclient"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// isDirectChannel returns true if the channel of a gateway is a direct or
+// group message, configured by its members: "@alice" for the direct messages
+// with alice, "@alice,@bob" for the group messages with alice and bob.
+func isDirectChannel(name string) bool {
+	return strings.HasPrefix(name, "@")
+}
+
+// directMembers returns the usernames of the members of a direct channel
+// name, sorted and without the bot.
+func directMembers(name string) []string {
+	var members []string
+	for _, member := range strings.Split(name, ",") {
+		member = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(member), "@"))
+		if member != "" && !slices.Contains(members, member) {
+			members = append(members, member)
+		}
+	}
+	slices.Sort(members)
+	return members
+}
+
+// resolveDirectChannel returns the ID of the direct or group message channel
+// of the bot with the members of name, which mattermost creates if it doesn't
+// exist yet.
+func (b *Bmattermost) resolveDirectChannel(name string) (string, error) {
+	members := directMembers(name)
+	if len(members) == 0 {
+		return "", fmt.Errorf("no members in channel %s", name)
+	}
+	users, _, err := b.mc.Client.GetUsersByUsernames(context.TODO(), members)
+	if err != nil {
+		return "", fmt.Errorf("getting the members of %s failed: %w", name, err)
+	}
+	if len(users) != len(members) {
+		return "", fmt.Errorf("some members of %s don't exist", name)
+	}
+
+	var channel *model.Channel
+	if len(users) == 1 {
+		channel, _, err = b.mc.Client.CreateDirectChannel(context.TODO(), b.mc.User.Id, users[0].Id)
+	} else {
+		ids := []string{b.mc.User.Id}
+		for _, user := range users {
+			ids = append(ids, user.Id)
+		}
+		channel, _, err = b.mc.Client.CreateGroupChannel(context.TODO(), ids)
+	}
+	if err != nil {
+		return "", fmt.Errorf("opening %s failed: %w", name, err)
+	}
+	return channel.Id, nil
+}
+
+// joinDirectChannel maps a direct channel of a gateway to its mattermost
+// channel. The bot is a member of the direct and group messages it opens.
+func (b *Bmattermost) joinDirectChannel(name string) error {
+	if b.mc == nil {
+		return fmt.Errorf("channel %s needs Login or Token, the webhooks can't send direct messages", name)
+	}
+	id, err := b.resolveDirectChannel(name)
+	if err != nil {
+		return err
+	}
+	b.channelsMutex.Lock()
+	b.directChannels[id] = name
+	b.channelsMutex.Unlock()
+	b.Log.Infof("Bridging %s (channel %s)", name, id)
+	return nil
+}
+
+// getDirectChannelID returns the ID of the direct channel name once joined.
+func (b *Bmattermost) getDirectChannelID(name string) string {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	for id, direct := range b.directChannels {
+		if direct == name {
+			return id
+		}
+	}
+	return ""
+}
+
+// getDirectChannelName returns the name in the gateways of the direct
+// channel id, or "" if it isn't bridged.
+func (b *Bmattermost) getDirectChannelName(id string) string {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	return b.directChannels[id]
+}
+
+// isDirectMessage returns true if message was sent in a direct or group
+// message channel.
+func isDirectMessage(message *matterclient.Message) bool {
+	channelType, _ := message.Raw.GetData()["channel_type"].(string)
+	return channelType == string(model.ChannelTypeDirect) || channelType == string(model.ChannelTypeGroup)
+}
+
+// handleMembershipEvent relays the members added to or removed from the
+// bridged group messages (or the private channels they were converted to)
+// as join and leave events. Returns true if the event was handled.
+func (b *Bmattermost) handleMembershipEvent(message *matterclient.Message) bool {
+	var event string
+	switch message.Raw.EventType() {
+	case model.WebsocketEventUserAdded:
+		event = config.EventJoin
+	case model.WebsocketEventUserRemoved:
+		event = config.EventLeave
+	case model.WebsocketEventChannelConverted:
+		id, _ := message.Raw.GetData()["channel_id"].(string)
+		if name := b.getDirectChannelName(id); name != "" {
+			b.Log.Infof("%s was converted to a private channel, it is still bridged", name)
+		}
+		return true
+	default:
+		return false
+	}
+
+	name := b.getDirectChannelName(message.Raw.GetBroadcast().ChannelId)
+	userID, _ := message.Raw.GetData()["user_id"].(string)
+	if name == "" || userID == "" || b.GetBool("nosendjoinpart") {
+		return true
+	}
+	username := b.mc.GetUserName(userID)
+	if username == "" {
+		username = userID
+	}
+	text := username + " joined"
+	if event == config.EventLeave {
+		text = username + " left"
+	}
+	b.Log.Debugf("Sending %s event from %s to gateway", event, b.Account)
+	b.Remote <- config.Message{
+		Username: systemUsername,
+		Text:     text,
+		Channel:  name,
+		Account:  b.Account,
+		Event:    event,
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+package bmattermost
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectMembers(t *testing.T) {
+	assert.True(t, isDirectChannel("@alice"))
+	assert.False(t, isDirectChannel("town-square"))
+	assert.False(t, isDirectChannel("ID:oc4wifyuojgw5f3nsuweesmz8w"))
+
+	assert.Equal(t, []string{"alice"}, directMembers("@alice"))
+	assert.Equal(t, []string{"alice", "bob"}, directMembers("@Bob, @alice,bob"))
+	assert.Empty(t, directMembers("@"))
+}
@@ -22,7 +22,7 @@ func (b *Bmattermost) handleDownloadAvatar(userid string, channel string) {
 		Event:    config.EventAvatarDownload,
 		Extra:    make(map[string][]interface{}),
 	}
-	if _, ok := b.avatarMap[userid]; !ok {
+	if _, ok := b.CachedAvatar(userid); !ok {
 		var (
 			data []byte
 			err  error
@@ -81,7 +81,7 @@ func (b *Bmattermost) handleMatter() {
 	}
 	var ok bool
 	for message := range messages {
-		message.Avatar = helper.GetAvatar(b.avatarMap, message.UserID, b.General)
+		message.Avatar = b.AvatarURL(message.UserID)
 		message.Account = b.Account
 		message.Text, ok = b.replaceAction(message.Text)
 		if ok {
@@ -98,6 +98,14 @@ func (b *Bmattermost) handleMatterClient(messages chan *config.Message) {
 	for message := range b.mc.MessageChan {
 		b.Log.Debugf("%#v %#v", message.Raw.GetData(), message.Raw.EventType())
 
+		if b.handleMembershipEvent(message) {
+			continue
+		}
+
+		if b.handleReactionEvent(message) {
+			continue
+		}
+
 		if b.skipMessage(message) {
 			b.Log.Debugf("Skipped message: %#v", message)
 			continue
@@ -175,7 +183,12 @@ func (b *Bmattermost) handleUploadFile(msg *config.Message) (string, error) {
 	channelID := b.getChannelID(msg.Channel)
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
-		id, err = b.mc.UploadFile(*fi.Data, channelID, fi.Name)
+		var data []byte
+		data, err = fi.Bytes()
+		if err != nil {
+			return "", err
+		}
+		id, err = b.mc.UploadFile(data, channelID, fi.Name)
 		if err != nil {
 			return "", err
 		}
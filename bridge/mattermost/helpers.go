@@ -106,8 +106,7 @@ func (b *Bmattermost) cacheAvatar(msg *config.Message) (string, error) {
 	/* if we have a sha we have successfully uploaded the file to the media server,
 	so we can now cache the sha */
 	if fi.SHA != "" {
-		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		b.CacheAvatar(msg.UserID, fi.SHA)
 	}
 	return "", nil
 }
@@ -251,8 +250,10 @@ func (b *Bmattermost) skipMessage(message *matterclient.Message) bool {
 		return true
 	}
 
-	// ignore messages from other teams than ours
-	if message.Raw.GetData()["team_id"].(string) != b.TeamID {
+	// ignore messages from other teams than ours, the direct and group
+	// messages have no team
+	if message.Raw.GetData()["team_id"].(string) != b.TeamID &&
+		!(isDirectMessage(message) && b.getChannelName(message.Post.ChannelId) != "") {
 		b.Log.Debug("message from other team, ignoring")
 		return true
 	}
@@ -284,6 +285,10 @@ func (b *Bmattermost) getVersion() string {
 }
 
 func (b *Bmattermost) getChannelID(name string) string {
+	if isDirectChannel(name) {
+		return b.getDirectChannelID(name)
+	}
+
 	idcheck := strings.Split(name, "ID:")
 	if len(idcheck) > 1 {
 		return idcheck[1]
@@ -293,6 +298,10 @@ func (b *Bmattermost) getChannelID(name string) string {
 }
 
 func (b *Bmattermost) getChannelName(id string) string {
+	if name := b.getDirectChannelName(id); name != "" {
+		return name
+	}
+
 	b.channelsMutex.RLock()
 	defer b.channelsMutex.RUnlock()
 
@@ -22,9 +22,11 @@ type Bmattermost struct {
 	uuid   string
 	TeamID string
 	*bridge.Config
-	avatarMap      map[string]string
 	channelsMutex  sync.RWMutex
 	channelInfoMap map[string]*config.ChannelInfo
+	// directChannels are the names in the gateways of the direct and group
+	// messages configured by their members, by channel ID
+	directChannels map[string]string
 }
 
 const mattermostPlugin = "mattermost.plugin"
@@ -32,8 +34,8 @@ const mattermostPlugin = "mattermost.plugin"
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmattermost{
 		Config:         cfg,
-		avatarMap:      make(map[string]string),
 		channelInfoMap: make(map[string]*config.ChannelInfo),
+		directChannels: make(map[string]string),
 	}
 
 	b.v6 = b.GetBool("v6")
@@ -98,6 +100,23 @@ func (b *Bmattermost) Disconnect() error {
 	return nil
 }
 
+// Alive implements bridge.Heartbeater, the webhooks aren't probed.
+func (b *Bmattermost) Alive() error {
+	if b.mc != nil && !b.mc.WsConnected {
+		return errors.New("websocket disconnected")
+	}
+	return nil
+}
+
+// Ping implements bridge.Heartbeater.
+func (b *Bmattermost) Ping(ctx context.Context) error {
+	if b.mc == nil {
+		return nil
+	}
+	_, _, err := b.mc.Client.GetPing(ctx)
+	return err
+}
+
 func (b *Bmattermost) JoinChannel(channel config.ChannelInfo) error {
 	if b.Account == mattermostPlugin {
 		return nil
@@ -107,6 +126,10 @@ func (b *Bmattermost) JoinChannel(channel config.ChannelInfo) error {
 	b.channelInfoMap[channel.ID] = &channel
 	b.channelsMutex.Unlock()
 
+	if isDirectChannel(channel.Name) {
+		return b.joinDirectChannel(channel.Name)
+	}
+
 	// we can only join channels using the API
 	if b.GetString("WebhookURL") == "" && b.GetString("WebhookBindAddress") == "" {
 		id := b.getChannelID(channel.Name)
@@ -150,6 +173,11 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 		return msg.ID, b.mc.DeleteMessage(msg.ID)
 	}
 
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg)
+	}
+
 	// Handle prefix hint for unthreaded messages.
 	if msg.ParentNotFound() {
 		msg.ParentID = ""
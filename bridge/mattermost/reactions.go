@@ -0,0 +1,114 @@
+package bmattermost
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	"github.com/matterbridge/matterclient"
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// handleReactionEvent relays the reactions added and removed, and returns
+// whether message was one.
+func (b *Bmattermost) handleReactionEvent(message *matterclient.Message) bool {
+	eventType := message.Raw.EventType()
+	if eventType != model.WebsocketEventReactionAdded && eventType != model.WebsocketEventReactionRemoved {
+		return false
+	}
+
+	raw, ok := message.Raw.GetData()["reaction"].(string)
+	if !ok {
+		return true
+	}
+	var reaction model.Reaction
+	if err := json.Unmarshal([]byte(raw), &reaction); err != nil {
+		b.Log.Errorf("Invalid reaction %q: %s", raw, err)
+		return true
+	}
+	// not relay the reactions we added for the other bridges
+	if reaction.UserId == b.mc.User.Id {
+		return true
+	}
+
+	channelID := reaction.ChannelId
+	if channelID == "" {
+		channelID = message.Raw.GetBroadcast().ChannelId
+	}
+	channelName := b.getChannelName(channelID)
+	if channelName == "" {
+		// the direct and group messages have no team
+		if b.mc.GetChannelTeamID(channelID) != b.TeamID {
+			b.Log.Debug("reaction from other team, ignoring")
+			return true
+		}
+		channelName = b.mc.GetChannelName(channelID)
+	}
+
+	rmsg := config.Message{
+		Event:    config.EventReaction,
+		Text:     reactionText(reaction.EmojiName),
+		Channel:  channelName,
+		Account:  b.Account,
+		Username: b.mc.GetUserName(reaction.UserId),
+		UserID:   reaction.UserId,
+		ParentID: reaction.PostId,
+	}
+	if !b.GetBool("useusername") {
+		if nick := b.mc.GetNickName(reaction.UserId); nick != "" {
+			rmsg.Username = nick
+		}
+	}
+	if eventType == model.WebsocketEventReactionRemoved {
+		rmsg.MarkReactionRemoved()
+	}
+	b.Log.Debugf("<= Reaction is %#v", rmsg)
+	b.Remote <- rmsg
+	return true
+}
+
+// reactionText returns the unicode emoji of the name of a mattermost reaction,
+// or its :name: for the custom emoji.
+func reactionText(name string) string {
+	if e, ok := helper.EmojiUnicode(name); ok {
+		return e
+	}
+	return ":" + name + ":"
+}
+
+// sendReaction adds the reaction in msg.Text to the post referenced by
+// msg.ParentID, or removes it when the message is flagged with
+// MarkReactionRemoved. Mattermost takes the reactions by name, the names of
+// the emoji are tried until one is accepted. The reactions mattermost doesn't
+// know are sent as a reply.
+func (b *Bmattermost) sendReaction(msg *config.Message) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+
+	for _, name := range helper.ReactionNames(msg.Text) {
+		reaction := &model.Reaction{UserId: b.mc.User.Id, PostId: msg.ParentID, EmojiName: name}
+		var err error
+		if msg.ReactionRemoved() {
+			_, err = b.mc.Client.DeleteReaction(context.TODO(), reaction)
+		} else {
+			_, _, err = b.mc.Client.SaveReaction(context.TODO(), reaction)
+		}
+		if err == nil {
+			return "", nil
+		}
+		b.Log.Debugf("reaction %s: %s", name, err)
+	}
+
+	if msg.ReactionRemoved() {
+		return "", nil
+	}
+	b.Log.Debugf("%q isn't a mattermost reaction, sending it as a reply", msg.Text)
+	msg.Event = ""
+	msg.Text = fmt.Sprintf("reacted with %s", strings.TrimSpace(helper.EmojiToUnicode(msg.Text)))
+	return b.Send(*msg)
+}
@@ -36,17 +36,18 @@ func (b *Bmumble) decodeImage(uri string, parts *[]MessagePart) error {
 	return nil
 }
 
+// `^(.*?)` matches everything before the image
+// `!\[[^\]]*\]\(` matches the `![alt](` part of markdown images
+// `(data:image\/[^)]+)` matches the data: URI used by Mumble
+// `\)` matches the closing parenthesis after the URI
+// `(.*)$` matches the remaining text to be examined in the next iteration
+var imageTokenRE = regexp.MustCompile(`^(?ms)(.*?)!\[[^\]]*\]\((data:image\/[^)]+)\)(.*)$`)
+
 func (b *Bmumble) tokenize(t *string) ([]MessagePart, error) {
-	// `^(.*?)` matches everything before the image
-	// `!\[[^\]]*\]\(` matches the `![alt](` part of markdown images
-	// `(data:image\/[^)]+)` matches the data: URI used by Mumble
-	// `\)` matches the closing parenthesis after the URI
-	// `(.*)$` matches the remaining text to be examined in the next iteration
-	p := regexp.MustCompile(`^(?ms)(.*?)!\[[^\]]*\]\((data:image\/[^)]+)\)(.*)$`)
 	remaining := *t
 	var parts []MessagePart
 	for {
-		tokens := p.FindStringSubmatch(remaining)
+		tokens := imageTokenRE.FindStringSubmatch(remaining)
 		if tokens == nil {
 			// no match -> remaining string is non-image text
 			pre := strings.TrimSpace(remaining)
@@ -106,7 +107,7 @@ func (b *Bmumble) extractFiles(msg *config.Message) []config.Message {
 			Event:     "mumble_image",
 		}
 		// If no data is present for the file, send a link instead
-		if fi.Data == nil || len(*fi.Data) == 0 {
+		if fi.DataSize() == 0 {
 			if len(fi.URL) > 0 {
 				imsg.Text = fmt.Sprintf(`<a href="%s">%s</a>`, fi.URL, fi.URL)
 				messages = append(messages, imsg)
@@ -115,7 +116,12 @@ func (b *Bmumble) extractFiles(msg *config.Message) []config.Message {
 			}
 			continue
 		}
-		mimeType := http.DetectContentType(*fi.Data)
+		data, err := fi.Bytes()
+		if err != nil {
+			b.Log.WithError(err).Infof("Reading %s failed", fi.Name)
+			continue
+		}
+		mimeType := http.DetectContentType(data)
 		// Mumble only supports images natively, send a link instead
 		if !strings.HasPrefix(mimeType, "image/") {
 			if len(fi.URL) > 0 {
@@ -128,10 +134,10 @@ func (b *Bmumble) extractFiles(msg *config.Message) []config.Message {
 		}
 		mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
 		// Build data:image/...;base64,... style image URL and embed image directly into the message
-		du := dataurl.New(*fi.Data, mimeType)
+		du := dataurl.New(data, mimeType)
 		dataURL, err := du.MarshalText()
 		if err != nil {
-			b.Log.WithError(err).Infof("Image Serialization into data URL failed (type: %s, length: %d)", mimeType, len(*fi.Data))
+			b.Log.WithError(err).Infof("Image Serialization into data URL failed (type: %s, length: %d)", mimeType, len(data))
 			continue
 		}
 		imsg.Text = fmt.Sprintf(`<img src="%s"/>`, dataURL)
@@ -247,18 +247,12 @@ func (b *Bmumble) processMessage(msg *config.Message) {
 		msg.Text = stripmd.Strip(msg.Text)
 	}
 
-	// If there is a maximum message length, split and truncate the lines
-	var msgLines []string
-	if maxLength := b.serverConfig.MaximumMessageLength; maxLength != nil {
-		if *maxLength != 0 { // Some servers will have unlimited message lengths.
-			// Not doing this makes underflows happen.
-			msgLines = helper.GetSubLines(msg.Text, *maxLength-len(msg.Username), b.GetString("MessageClipped"))
-		} else {
-			msgLines = helper.GetSubLines(msg.Text, 0, b.GetString("MessageClipped"))
-		}
-	} else {
-		msgLines = helper.GetSubLines(msg.Text, 0, b.GetString("MessageClipped"))
+	// If there is a maximum message length, split the long lines
+	length := 0
+	if maxLength := b.serverConfig.MaximumMessageLength; maxLength != nil && *maxLength != 0 { // Some servers will have unlimited message lengths.
+		length = max(*maxLength-len(msg.Username), 1)
 	}
+	msgLines := helper.SplitLines(msg.Text, length, b.GetBool("MessageSplitNumbered"))
 	// Send the individual lines
 	for i := range msgLines {
 		// Remove unnecessary newline character, since either way we're sending it as individual lines
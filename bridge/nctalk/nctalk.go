@@ -3,11 +3,23 @@ package nctalk
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 
 	"gomod.garykim.dev/nc-talk/ocs"
 	"gomod.garykim.dev/nc-talk/room"
@@ -17,11 +29,41 @@ import (
 type Btalk struct {
 	user  *user.TalkUser
 	rooms []Broom
+
+	// identities maps display names seen in Talk to their user IDs, so that
+	// @-mentions coming from other networks can be turned into Talk mentions,
+	// along with the users linked in the identity map of the gateway, see
+	// SetMentionNames.
+	identities     map[string]string
+	identitiesLock sync.RWMutex
+	mentionNames   func() map[string]string
+
+	// reactions holds the reactions relayed, by reactionKey, to relay their
+	// removal: the system messages of the removals don't tell the reaction
+	// removed.
+	reactions *lru.Cache
+
 	*bridge.Config
 }
 
+// reactionsSize is the number of messages and users whose reactions are
+// remembered.
+const reactionsSize = 5000
+
 func New(cfg *bridge.Config) bridge.Bridger {
-	return &Btalk{Config: cfg}
+	reactions, _ := lru.New(reactionsSize)
+	return &Btalk{
+		Config:     cfg,
+		identities: make(map[string]string),
+		reactions:  reactions,
+	}
+}
+
+// SetMentionNames implements bridge.MentionTranslator.
+func (b *Btalk) SetMentionNames(names func() map[string]string) {
+	b.identitiesLock.Lock()
+	defer b.identitiesLock.Unlock()
+	b.mentionNames = names
 }
 
 type Broom struct {
@@ -95,6 +137,16 @@ func (b *Btalk) JoinChannel(channel config.ChannelInfo) error {
 				continue
 			}
 
+			// Handle reactions to a previous message
+			if msg.MessageType == ocs.MessageSystem && msg.SystemMessage == systemMessageReaction && msg.Parent != nil {
+				b.handleReaction(&msg, &newRoom)
+				continue
+			}
+			if msg.MessageType == ocs.MessageSystem && (msg.SystemMessage == systemMessageReactionRevoked || msg.SystemMessage == systemMessageReactionDeleted) && msg.Parent != nil {
+				b.handleReactionRemoved(&msg, &newRoom)
+				continue
+			}
+
 			// Handle sending messages
 			if msg.MessageType == ocs.MessageComment {
 				b.handleSendingMessage(&msg, &newRoom)
@@ -132,6 +184,11 @@ func (b *Btalk) Send(msg config.Message) (string, error) {
 		return strconv.Itoa(sentMessage.ID), nil
 	}
 
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(r, &msg)
+	}
+
 	// Message Deletion
 	if msg.Event == config.EventMsgDelete {
 		messageID, err := strconv.Atoi(msg.ID)
@@ -159,6 +216,7 @@ func (b *Btalk) getRoom(token string) *Broom {
 }
 
 func (b *Btalk) sendText(r *Broom, msg *config.Message, text string) (*ocs.TalkRoomMessageData, error) {
+	text = b.translateMentions(text)
 	messageToSend := &room.Message{Message: msg.Username + text}
 
 	if b.GetBool("SeparateDisplayName") {
@@ -205,7 +263,7 @@ func (b *Btalk) handleSendingFile(msg *config.Message, r *Broom) error {
 		if fi.Comment != "" {
 			message += fi.Comment + " "
 		}
-		message += fi.URL
+		message += helper.FormatAttachment(fi, b.GetString("AttachmentFormat"))
 		_, err := b.sendText(r, msg, message)
 		if err != nil {
 			return err
@@ -216,6 +274,8 @@ func (b *Btalk) handleSendingFile(msg *config.Message, r *Broom) error {
 }
 
 func (b *Btalk) handleSendingMessage(msg *ocs.TalkRoomMessageData, r *Broom) {
+	b.learnIdentities(msg)
+
 	remoteMessage := config.Message{
 		Text:     formatRichObjectString(msg.Message, msg.MessageParameters),
 		Channel:  r.room.Token,
@@ -253,6 +313,256 @@ func (b *Btalk) handleDeletingMessage(msg *ocs.TalkRoomMessageData, r *Broom) {
 	b.Remote <- remoteMessage
 }
 
+func (b *Btalk) handleReaction(msg *ocs.TalkRoomMessageData, r *Broom) {
+	key := reactionKey(msg.Parent.ID, string(msg.ActorType), msg.ActorID)
+	var reactions []string
+	if v, ok := b.reactions.Get(key); ok {
+		reactions = v.([]string)
+	}
+	b.reactions.Add(key, append(reactions[:len(reactions):len(reactions)], msg.Message))
+
+	remoteMessage := config.Message{
+		Event:    config.EventReaction,
+		Text:     msg.Message,
+		Channel:  r.room.Token,
+		Username: DisplayName(msg, b.guestSuffix()),
+		UserID:   msg.ActorID,
+		ParentID: strconv.Itoa(msg.Parent.ID),
+		Account:  b.Account,
+	}
+	b.Log.Debugf("<= Reaction is %#v", remoteMessage)
+	b.Remote <- remoteMessage
+}
+
+// handleReactionRemoved relays the removal of the reactions of the author of
+// msg to its parent message. Talk doesn't tell which reaction was removed, the
+// reactions relayed before which the message doesn't have anymore are.
+func (b *Btalk) handleReactionRemoved(msg *ocs.TalkRoomMessageData, r *Broom) {
+	key := reactionKey(msg.Parent.ID, string(msg.ActorType), msg.ActorID)
+	v, ok := b.reactions.Get(key)
+	if !ok {
+		b.Log.Debugf("Dropping the removal of a reaction to %d, the reactions of %s weren't relayed", msg.Parent.ID, msg.ActorID)
+		return
+	}
+	current, err := b.getReactions(r, msg.Parent.ID)
+	if err != nil {
+		b.Log.Errorf("Could not get the reactions to %d: %s", msg.Parent.ID, err)
+		return
+	}
+
+	var kept []string
+	for _, reaction := range v.([]string) {
+		if slices.ContainsFunc(current[reaction], func(actor reactionActor) bool {
+			return actor.ActorType == string(msg.ActorType) && actor.ActorID == msg.ActorID
+		}) {
+			kept = append(kept, reaction)
+			continue
+		}
+		remoteMessage := config.Message{
+			Event:    config.EventReaction,
+			Text:     reaction,
+			Channel:  r.room.Token,
+			Username: DisplayName(msg, b.guestSuffix()),
+			UserID:   msg.ActorID,
+			ParentID: strconv.Itoa(msg.Parent.ID),
+			Account:  b.Account,
+		}
+		remoteMessage.MarkReactionRemoved()
+		b.Log.Debugf("<= Reaction removal is %#v", remoteMessage)
+		b.Remote <- remoteMessage
+	}
+	if len(kept) == 0 {
+		b.reactions.Remove(key)
+		return
+	}
+	b.reactions.Add(key, kept)
+}
+
+// reactionKey is the key of the reactions of an actor to the message
+// messageID in Btalk.reactions.
+func reactionKey(messageID int, actorType string, actorID string) string {
+	return strconv.Itoa(messageID) + " " + actorType + " " + actorID
+}
+
+// reactionActor is an author of a reaction, as listed by the reaction API.
+type reactionActor struct {
+	ActorType string `json:"actorType"`
+	ActorID   string `json:"actorId"`
+}
+
+// getReactions returns the authors of the reactions to the message messageID,
+// by reaction.
+func (b *Btalk) getReactions(r *Broom, messageID int) (map[string][]reactionActor, error) {
+	uri := fmt.Sprintf("%s/ocs/v2.php/apps/spreed/api/v1/reaction/%s/%d",
+		strings.TrimSuffix(b.GetString("Server"), "/"), url.PathEscape(r.room.Token), messageID)
+	req, err := b.NewHttpRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(b.GetString("Login"), b.GetString("Password"))
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, bridge.HttpGetNotOkError(uri, resp.StatusCode)
+	}
+
+	var result struct {
+		OCS struct {
+			// Data is an empty list when there are no reactions
+			Data json.RawMessage `json:"data"`
+		} `json:"ocs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	reactions := make(map[string][]reactionActor)
+	if len(result.OCS.Data) == 0 || result.OCS.Data[0] != '{' {
+		return reactions, nil
+	}
+	if err := json.Unmarshal(result.OCS.Data, &reactions); err != nil {
+		return nil, err
+	}
+	return reactions, nil
+}
+
+// sendReaction adds the reaction in msg.Text to the message referenced by
+// msg.ParentID, or removes it when the message is flagged with
+// MarkReactionRemoved.
+//
+// The nc-talk library does not expose the reaction API, so the OCS endpoint is
+// called directly with the bot credentials.
+func (b *Btalk) sendReaction(r *Broom, msg *config.Message) error {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return nil
+	}
+
+	uri := fmt.Sprintf("%s/ocs/v2.php/apps/spreed/api/v1/reaction/%s/%s",
+		strings.TrimSuffix(b.GetString("Server"), "/"), url.PathEscape(r.room.Token), url.PathEscape(msg.ParentID))
+	form := url.Values{"reaction": {msg.Text}}
+
+	method := "POST"
+	if msg.ReactionRemoved() {
+		method = "DELETE"
+	}
+	req, err := b.NewHttpRequest(method, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(b.GetString("Login"), b.GetString("Password"))
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := b.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// 200 means the reaction was already there or was removed, 201 that it
+	// was added
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return bridge.HttpGetNotOkError(uri, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// learnIdentities records the display name to user ID mapping for the author
+// of a message and for every user mentioned in it.
+func (b *Btalk) learnIdentities(msg *ocs.TalkRoomMessageData) {
+	b.identitiesLock.Lock()
+	defer b.identitiesLock.Unlock()
+
+	if msg.ActorType != ocs.ActorGuest && msg.ActorDisplayName != "" && msg.ActorID != "" {
+		b.identities[msg.ActorDisplayName] = msg.ActorID
+	}
+
+	for _, parameter := range msg.MessageParameters {
+		if parameter.Type == ocs.ROSTypeUser && parameter.Name != "" && parameter.ID != "" {
+			b.identities[parameter.Name] = parameter.ID
+		}
+	}
+}
+
+// translateMentions replaces "@Display Name" with the Talk mention syntax for
+// the matching user ID, for every user we have seen so far and those linked in
+// the identity map of the gateway. Only whole
+// mentions are replaced: "@Ann" isn't found in "@Annabel" nor in an email
+// address.
+func (b *Btalk) translateMentions(text string) string {
+	if !strings.Contains(text, "@") {
+		return text
+	}
+
+	b.identitiesLock.RLock()
+	identities := maps.Clone(b.identities)
+	mentionNames := b.mentionNames
+	b.identitiesLock.RUnlock()
+	// the users linked in the identity map are mentioned even if they
+	// weren't seen in Talk yet
+	if mentionNames != nil {
+		maps.Copy(identities, mentionNames())
+	}
+
+	// Try the longest names first so "Ann Marie" wins over "Ann"
+	names := make([]string, 0, len(identities))
+	for name := range identities {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, '@')
+		if i < 0 {
+			out.WriteString(text)
+			return out.String()
+		}
+		out.WriteString(text[:i+1])
+		rest := text[i+1:]
+		text = rest
+		// an email address
+		if before, _ := utf8.DecodeLastRuneInString(out.String()[:out.Len()-1]); isMentionRune(before) {
+			continue
+		}
+		for _, name := range names {
+			after, ok := strings.CutPrefix(rest, name)
+			if !ok {
+				continue
+			}
+			if next, _ := utf8.DecodeRuneInString(after); after != "" && isMentionRune(next) {
+				continue
+			}
+			out.WriteString("\"" + identities[name] + "\"")
+			text = after
+			break
+		}
+	}
+}
+
+// isMentionRune returns true for the runes which can't surround a mention
+// without being part of it.
+func isMentionRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 func (b *Btalk) guestSuffix() string {
 	guestSuffix := " (Guest)"
 	if b.IsKeySet("GuestSuffix") {
@@ -262,6 +572,18 @@ func (b *Btalk) guestSuffix() string {
 	return guestSuffix
 }
 
+const (
+	// systemMessageReaction is the system message type Talk uses for a new reaction
+	systemMessageReaction = "reaction"
+	// systemMessageReactionRevoked is sent when the author of a reaction
+	// removes it, and systemMessageReactionDeleted when the reaction itself
+	// is deleted, which has the same effect
+	systemMessageReactionRevoked = "reaction_revoked"
+	systemMessageReactionDeleted = "reaction_deleted"
+	// rosTypePoll is the rich object type of a poll shared in a conversation
+	rosTypePoll = "talk-poll"
+)
+
 // Spec: https://github.com/nextcloud/server/issues/1706#issue-182308785
 func formatRichObjectString(message string, parameters map[string]ocs.RichObjectString) string {
 	for id, parameter := range parameters {
@@ -270,6 +592,8 @@ func formatRichObjectString(message string, parameters map[string]ocs.RichObject
 		switch parameter.Type {
 		case ocs.ROSTypeUser, ocs.ROSTypeGroup:
 			text = "@" + text
+		case rosTypePoll:
+			text = "Poll: " + text
 		case ocs.ROSTypeFile:
 			if parameter.Link != "" {
 				text = parameter.Name
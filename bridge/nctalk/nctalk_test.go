@@ -0,0 +1,174 @@
+package nctalk
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gomod.garykim.dev/nc-talk/ocs"
+	"gomod.garykim.dev/nc-talk/room"
+)
+
+func newTestBtalk(settings string) *Btalk {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[nctalk.test]\n"+settings))
+	return New(&bridge.Config{
+		Bridge: &bridge.Bridge{Account: "nctalk.test", Config: cfg, Log: logrus.NewEntry(logger)},
+		Remote: make(chan config.Message, 1),
+	}).(*Btalk)
+}
+
+func TestTranslateMentions(t *testing.T) {
+	b := newTestBtalk("")
+	b.learnIdentities(&ocs.TalkRoomMessageData{
+		ActorID: "ann", ActorDisplayName: "Ann", ActorType: "users",
+		MessageParameters: map[string]ocs.RichObjectString{
+			"mention-user1": {Type: ocs.ROSTypeUser, ID: "annmarie", Name: "Ann Marie"},
+		},
+	})
+	// the display names of the guests aren't theirs
+	b.learnIdentities(&ocs.TalkRoomMessageData{ActorID: "guest/1234", ActorDisplayName: "Bob", ActorType: ocs.ActorGuest})
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"hello", "hello"},
+		{"@Ann hi", `@"ann" hi`},
+		{"hi @Ann, and (@Ann)!", `hi @"ann", and (@"ann")!`},
+		{"@Ann Marie hi", `@"annmarie" hi`},
+		{"@Ann Mari", `@"ann" Mari`},
+		{"@Annabel hi", "@Annabel hi"},
+		{"@Ann_", "@Ann_"},
+		{"mail ann@Ann.example", "mail ann@Ann.example"},
+		{"@Bob hi", "@Bob hi"},
+		{"@@Ann", `@@"ann"`},
+		{"@", "@"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, b.translateMentions(test.text), test.text)
+	}
+
+	// the users linked in the gateway are mentioned even when Talk hasn't seen them
+	b.SetMentionNames(func() map[string]string {
+		return map[string]string{"carol": "carol", "caro": "carol"}
+	})
+	assert.Equal(t, `@"carol" and @"carol" and @"ann"`, b.translateMentions("@carol and @caro and @Ann"))
+}
+
+func TestHandleReaction(t *testing.T) {
+	b := newTestBtalk("")
+	r := &Broom{room: &room.TalkRoom{Token: "abcd"}}
+
+	b.handleReaction(&ocs.TalkRoomMessageData{
+		Message: "👍", ActorID: "ann", ActorDisplayName: "Ann", ActorType: "users",
+		MessageType: ocs.MessageSystem, SystemMessage: systemMessageReaction,
+		Parent: &ocs.TalkRoomMessageData{ID: 42},
+	}, r)
+	assert.Equal(t, config.Message{
+		Event: config.EventReaction, Text: "👍", Channel: "abcd", Username: "Ann", UserID: "ann",
+		ParentID: "42", Account: "nctalk.test",
+	}, <-b.Remote)
+
+	b.handleReaction(&ocs.TalkRoomMessageData{
+		Message: "🎉", ActorID: "guest/1234", ActorDisplayName: "Bob", ActorType: ocs.ActorGuest,
+		Parent: &ocs.TalkRoomMessageData{ID: 43},
+	}, r)
+	assert.Equal(t, "Bob (Guest)", (<-b.Remote).Username)
+}
+
+func TestHandleReactionRemoved(t *testing.T) {
+	reactions := `{"ocs":{"data":{"👍":[{"actorType":"users","actorId":"ann"}],"🎉":[{"actorType":"users","actorId":"bob"}]}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET /ocs/v2.php/apps/spreed/api/v1/reaction/abcd/42", r.Method+" "+r.URL.Path)
+		io.WriteString(w, reactions) //nolint:errcheck
+	}))
+	defer server.Close()
+	b := newTestBtalk("Server=\"" + server.URL + "/\"\n")
+	b.HttpClient = server.Client()
+	r := &Broom{room: &room.TalkRoom{Token: "abcd"}}
+
+	ann := ocs.TalkRoomMessageData{
+		ActorID: "ann", ActorDisplayName: "Ann", ActorType: "users",
+		MessageType: ocs.MessageSystem, Parent: &ocs.TalkRoomMessageData{ID: 42},
+	}
+	for _, reaction := range []string{"👍", "🎉"} {
+		added := ann
+		added.Message, added.SystemMessage = reaction, systemMessageReaction
+		b.handleReaction(&added, r)
+		<-b.Remote
+	}
+
+	// Ann still reacts with 👍, only 🎉 was revoked
+	revoked := ann
+	revoked.SystemMessage = systemMessageReactionRevoked
+	b.handleReactionRemoved(&revoked, r)
+	removal := <-b.Remote
+	assert.Equal(t, config.EventReaction, removal.Event)
+	assert.Equal(t, "🎉", removal.Text)
+	assert.Equal(t, "42", removal.ParentID)
+	assert.True(t, removal.ReactionRemoved())
+	assert.Empty(t, b.Remote)
+
+	reactions = `{"ocs":{"data":[]}}`
+	deleted := ann
+	deleted.SystemMessage = systemMessageReactionDeleted
+	b.handleReactionRemoved(&deleted, r)
+	assert.Equal(t, "👍", (<-b.Remote).Text)
+
+	// the reactions which weren't relayed aren't removed
+	b.handleReactionRemoved(&deleted, r)
+	assert.Empty(t, b.Remote)
+}
+
+func TestSendReaction(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login, password, _ := r.BasicAuth()
+		// the form is sent with the DELETE requests too
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+form.Get("reaction")+" "+login+":"+password)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	b := newTestBtalk("Server=\"" + server.URL + "/\"\nLogin=\"bot\"\nPassword=\"secret\"\n")
+	b.HttpClient = server.Client()
+	r := &Broom{room: &room.TalkRoom{Token: "abcd"}}
+
+	reaction := config.Message{Event: config.EventReaction, Text: "👍", ParentID: "42"}
+	require.NoError(t, b.sendReaction(r, &reaction))
+	reaction.MarkReactionRemoved()
+	require.NoError(t, b.sendReaction(r, &reaction))
+	// the reactions to unknown messages are dropped
+	require.NoError(t, b.sendReaction(r, &config.Message{Event: config.EventReaction, Text: "👍"}))
+	assert.Equal(t, []string{
+		"POST /ocs/v2.php/apps/spreed/api/v1/reaction/abcd/42 👍 bot:secret",
+		"DELETE /ocs/v2.php/apps/spreed/api/v1/reaction/abcd/42 👍 bot:secret",
+	}, requests)
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	assert.Error(t, b.sendReaction(r, &config.Message{Event: config.EventReaction, Text: "👍", ParentID: "43"}))
+}
+
+func TestFormatRichObjectString(t *testing.T) {
+	parameters := map[string]ocs.RichObjectString{
+		"actor":         {Type: ocs.ROSTypeUser, ID: "ann", Name: "Ann"},
+		"poll":          {Type: rosTypePoll, ID: "7", Name: "Lunch at noon?"},
+		"mention-call1": {Type: "call", ID: "abcd", Name: "General"},
+	}
+	assert.Equal(t, "@Ann shared Poll: Lunch at noon? in General",
+		formatRichObjectString("{actor} shared {poll} in {mention-call1}", parameters))
+	assert.Equal(t, "no parameters", formatRichObjectString("no parameters", nil))
+}
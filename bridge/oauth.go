@@ -0,0 +1,211 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// tokenRefreshMargin is how long before its expiry a token is refreshed.
+	tokenRefreshMargin = 5 * time.Minute
+	// tokenRetryDelay is the delay before refreshing a token again after a
+	// refresh failed for another reason than a refused refresh token.
+	tokenRetryDelay = time.Minute
+)
+
+// TokenManager keeps the OAuth2 token of an account valid: it refreshes the
+// token before it expires, stores the refreshed tokens in the TokenFile of the
+// account so that they survive a restart, and reports when the provider
+// refuses the refresh token, after which the account must be authorized again.
+// It is an oauth2.TokenSource.
+type TokenManager struct {
+	conf *oauth2.Config
+	path string
+	log  *logrus.Entry
+
+	mu    sync.Mutex
+	token *oauth2.Token
+	// stale is set for the configured tokens, which don't tell when they
+	// expire: they are refreshed first to learn it
+	stale bool
+	// reauth is the error of the refused refresh, nil while the token can
+	// be refreshed
+	reauth error
+}
+
+// NewTokenManager returns the manager of the token of the account, the one
+// stored in its TokenFile, else token.
+func (b *Bridge) NewTokenManager(conf *oauth2.Config, token *oauth2.Token) *TokenManager {
+	m := &TokenManager{
+		conf:  conf,
+		path:  b.GetString("TokenFile"),
+		log:   b.Log,
+		token: token,
+		stale: token.Expiry.IsZero(),
+	}
+	if m.path == "" {
+		if token.RefreshToken != "" {
+			b.Log.Warn("No TokenFile is set, the refreshed tokens will be lost on restart")
+		}
+		return m
+	}
+	stored, err := loadToken(m.path)
+	switch {
+	case err == nil:
+		m.token = stored
+		m.stale = false
+	case errors.Is(err, os.ErrNotExist):
+		b.Log.Debugf("No token stored in %s yet", m.path)
+	default:
+		b.Log.Errorf("Reading the token stored in %s failed: %s", m.path, err)
+	}
+	return m
+}
+
+// Token returns the token, refreshed first when it expires within
+// tokenRefreshMargin.
+func (m *TokenManager) Token() (*oauth2.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reauth != nil {
+		return nil, m.reauth
+	}
+	if !m.expiresWithin(tokenRefreshMargin) || m.token.RefreshToken == "" {
+		return m.token, nil
+	}
+	return m.refresh(context.Background())
+}
+
+// Start refreshes the token in the background before it expires, until ctx is
+// done.
+func (m *TokenManager) Start(ctx context.Context) {
+	go func() {
+		for {
+			m.mu.Lock()
+			delay := m.refreshDelay()
+			m.mu.Unlock()
+			if delay < 0 {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			m.mu.Lock()
+			if m.reauth == nil && m.expiresWithin(tokenRefreshMargin) {
+				_, _ = m.refresh(ctx)
+			}
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// HealthWarnings returns why the account must be authorized again, if it must.
+func (m *TokenManager) HealthWarnings() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reauth == nil {
+		return nil
+	}
+	return []string{m.reauth.Error()}
+}
+
+// refreshDelay returns the time until the token is to be refreshed, negative
+// when it is never refreshed.
+func (m *TokenManager) refreshDelay() time.Duration {
+	if m.reauth != nil || m.token.RefreshToken == "" {
+		return -1
+	}
+	if m.stale {
+		return tokenRetryDelay
+	}
+	if m.token.Expiry.IsZero() {
+		return -1
+	}
+	return max(time.Until(m.token.Expiry.Add(-tokenRefreshMargin)), tokenRetryDelay)
+}
+
+func (m *TokenManager) expiresWithin(d time.Duration) bool {
+	return m.stale || !m.token.Expiry.IsZero() && time.Until(m.token.Expiry) < d
+}
+
+// refresh gets a new token with the refresh token and stores it. The lock
+// must be held.
+func (m *TokenManager) refresh(ctx context.Context) (*oauth2.Token, error) {
+	token, err := m.conf.TokenSource(ctx, &oauth2.Token{RefreshToken: m.token.RefreshToken}).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			m.reauth = fmt.Errorf("the refresh token was refused, the account must be authorized again: %w", err)
+			m.log.Error(m.reauth)
+			return nil, m.reauth
+		}
+		m.log.Errorf("Refreshing the token failed: %s", err)
+		// the current token is used until it expires
+		if m.token.Valid() {
+			return m.token, nil
+		}
+		return nil, err
+	}
+	// providers which don't rotate the refresh token don't return it
+	if token.RefreshToken == "" {
+		token.RefreshToken = m.token.RefreshToken
+	}
+	m.token = token
+	m.stale = false
+	m.log.Debugf("Token refreshed, it expires at %s", token.Expiry)
+	if m.path != "" {
+		if err := storeToken(m.path, token); err != nil {
+			m.log.Errorf("Storing the refreshed token in %s failed: %s", m.path, err)
+		}
+	}
+	return token, nil
+}
+
+func loadToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// storeToken writes token to path, readable only by matterbridge. The file is
+// replaced at once, so that a crash doesn't leave half a token.
+func storeToken(path string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func newTestTokenManager(t *testing.T, tokenURL string, settings string, token *oauth2.Token) *TokenManager {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[mastodon.test]\n"+settings))
+	b := &Bridge{Account: "mastodon.test", Config: cfg, Log: logrus.NewEntry(logger)}
+	return b.NewTokenManager(&oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{TokenURL: tokenURL, AuthStyle: oauth2.AuthStyleInParams},
+	}, token)
+}
+
+func TestTokenManager(t *testing.T) {
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		refreshes++
+		fmt.Fprintf(w, `{"access_token":"access%d","refresh_token":"refresh%d","token_type":"Bearer","expires_in":3600}`, refreshes, refreshes)
+	}))
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "token.json")
+
+	// the configured token is refreshed first, then while it is valid
+	m := newTestTokenManager(t, server.URL, `TokenFile="`+file+`"`, &oauth2.Token{AccessToken: "access0", RefreshToken: "refresh0"})
+	token, err := m.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "access1", token.AccessToken)
+	token, err = m.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "access1", token.AccessToken)
+	assert.Equal(t, 1, refreshes)
+
+	// the stored token is used after a restart, and refreshed before it expires
+	m = newTestTokenManager(t, server.URL, `TokenFile="`+file+`"`, &oauth2.Token{AccessToken: "access0", RefreshToken: "refresh0"})
+	token, err = m.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "refresh1", token.RefreshToken)
+	m.token.Expiry = time.Now().Add(time.Minute)
+	token, err = m.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "access2", token.AccessToken)
+	assert.Empty(t, m.HealthWarnings())
+}
+
+func TestTokenManagerReauth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant"}`)
+	}))
+	defer server.Close()
+
+	m := newTestTokenManager(t, server.URL, "", &oauth2.Token{AccessToken: "access0", RefreshToken: "revoked"})
+	_, err := m.Token()
+	require.Error(t, err)
+	require.Len(t, m.HealthWarnings(), 1)
+	assert.Contains(t, m.HealthWarnings()[0], "must be authorized again")
+	assert.Equal(t, time.Duration(-1), m.refreshDelay(), "a refused token isn't refreshed again")
+}
+
+func TestTokenManagerUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// the configured token is used until the provider answers
+	m := newTestTokenManager(t, server.URL, "", &oauth2.Token{AccessToken: "access0", RefreshToken: "refresh0"})
+	token, err := m.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "access0", token.AccessToken)
+	assert.Empty(t, m.HealthWarnings())
+	assert.Equal(t, tokenRetryDelay, m.refreshDelay())
+}
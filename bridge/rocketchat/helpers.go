@@ -121,7 +121,11 @@ func (b *Brocketchat) uploadFile(fi *config.FileInfo, channel string) error {
 	if !strings.Contains(mtype, "image") && !strings.Contains(mtype, "video") {
 		return nil
 	}
-	if err := fb.WriteFile("file", fi.Name, mtype, *fi.Data); err != nil {
+	data, err := fi.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := fb.WriteFile("file", fi.Name, mtype, data); err != nil {
 		return err
 	}
 	req, err := fb.GetHTTPRequest(context.TODO(), b.GetString("server")+"/api/v1/rooms.upload/"+channel)
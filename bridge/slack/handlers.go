@@ -18,7 +18,7 @@ var ErrEventIgnored = errors.New("this event message should ignored")
 
 func (b *Bslack) handleSlack() {
 	messages := make(chan *config.Message)
-	if b.GetString(incomingWebhookConfig) != "" && b.GetString(tokenConfig) == "" {
+	if b.GetString(incomingWebhookConfig) != "" {
 		b.Log.Debugf("Choosing webhooks based receiving")
 		go b.handleMatterHook(messages)
 	} else if b.GetString(appTokenConfig) != "" && b.GetString(tokenConfig) != "" {
@@ -33,7 +33,7 @@ func (b *Bslack) handleSlack() {
 	for message := range messages {
 		// don't do any action on deleted/typing messages
 		if message.Event != config.EventUserTyping && message.Event != config.EventMsgDelete &&
-			message.Event != config.EventFileDelete {
+			message.Event != config.EventFileDelete && message.Event != config.EventReaction {
 			b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
 			// cleanup the message
 			message.Text = b.replaceMention(message.Text)
@@ -56,7 +56,7 @@ func (b *Bslack) handleSlack() {
 func (b *Bslack) handleSlackClientEAPI(messages chan *config.Message) {
 	b.Log.Warn("Socket mode Events API is currently WORK IN PROGRESS")
 
-	for sockEvt := range b.smc.Events {
+	for sockEvt := range b.smcEvents {
 		b.Log.Debugf("== Received socket event: %#v", sockEvt)
 
 		switch sockEvt.Type {
@@ -73,8 +73,10 @@ func (b *Bslack) handleSlackClientEAPI(messages chan *config.Message) {
 			}
 			b.actingUserID = si.UserID
 			b.actingUserName = si.User
-			b.channels.populateChannels(true)
-			b.users.populateUsers(true)
+			if b.syncsClient() {
+				b.channels.populateChannels(true)
+				b.users.populateUsers(true)
+			}
 		case socketmode.EventTypeHello:
 			appID := sockEvt.Request.ConnectionInfo.AppID
 			b.Log.Debugf("Hello received, AppID %v", appID)
@@ -87,9 +89,7 @@ func (b *Bslack) handleSlackClientEAPI(messages chan *config.Message) {
 				b.Log.Debugf("Ignored %#v", sockEvt)
 				continue
 			}
-			// you MUST ack event
-			b.smc.Ack(*sockEvt.Request)
-
+			// the event was acknowledged by the shared client
 			b.Log.Debugf("Received event: %v %#v; inner: %#v", oevt.Type, oevt.Data, oevt.InnerEvent)
 
 			// CallbackEvent is more or less the only meaningful type of outer event
@@ -101,10 +101,9 @@ func (b *Bslack) handleSlackClientEAPI(messages chan *config.Message) {
 			b.handleInnerEventEAPI(oevt.InnerEvent, messages)
 
 		case socketmode.EventTypeSlashCommand, socketmode.EventTypeInteractive:
-			// we don't expect these events to show up here but it's a valid one
-			// ack it properly and do nothing else
+			// we don't expect these events to show up here but it's a valid one,
+			// acknowledged by the shared client
 			b.Log.Debugf("Skip unsupported event type %s", sockEvt.Type)
-			b.smc.Ack(*sockEvt.Request)
 
 		default:
 			// some of the events come from the socket mode client itself and are not a part of events api
@@ -152,15 +151,37 @@ func (b *Bslack) handleInnerEventEAPI(ievt slackevents.EventsAPIInnerEvent, mess
 
 	case *slackevents.UserChangeEvent:
 		b.users.invalidateUser(ev.User.ID)
+
+	case *slackevents.ReactionAddedEvent:
+		b.sendReactionEvent(messages, ev.User, ev.Reaction, ev.Item.Channel, ev.Item.Timestamp, false)
+	case *slackevents.ReactionRemovedEvent:
+		b.sendReactionEvent(messages, ev.User, ev.Reaction, ev.Item.Channel, ev.Item.Timestamp, true)
 	}
 }
 
+// sendReactionEvent sends the reaction of an event to messages, when it is
+// one to a message.
+func (b *Bslack) sendReactionEvent(messages chan *config.Message, user string, reaction string, channel string, ts string, removed bool) {
+	if channel == "" || ts == "" {
+		return
+	}
+	rmsg, err := b.handleReaction(user, reaction, channel, ts, removed)
+	if err == ErrEventIgnored {
+		return
+	}
+	if err != nil {
+		b.Log.Errorf("%#v", err)
+		return
+	}
+	messages <- rmsg
+}
+
 // handleSlackClientRTM handle slack events coming from legacy RTM system
 // this is an event dispatch board, it might not be a good idea to atomize the tasks to handle events
 //
 //nolint:gocyclo,funlen
 func (b *Bslack) handleSlackClientRTM(messages chan *config.Message) {
-	for msg := range b.rtm.IncomingEvents {
+	for msg := range b.rtmEvents {
 		if msg.Type != sUserTyping && msg.Type != sHello && msg.Type != sLatencyReport {
 			b.Log.Debugf("== Receiving event %#v", msg.Data)
 		}
@@ -196,6 +217,11 @@ func (b *Bslack) handleSlackClientRTM(messages chan *config.Message) {
 			}
 			messages <- rmsg
 
+		case *slack.ReactionAddedEvent:
+			b.sendReactionEvent(messages, ev.User, ev.Reaction, ev.Item.Channel, ev.Item.Timestamp, false)
+		case *slack.ReactionRemovedEvent:
+			b.sendReactionEvent(messages, ev.User, ev.Reaction, ev.Item.Channel, ev.Item.Timestamp, true)
+
 		case *slack.ChannelJoinedEvent:
 			// When we join a channel we update the full list of users as
 			// well as the information for the channel that we joined as this
@@ -208,8 +234,10 @@ func (b *Bslack) handleSlackClientRTM(messages chan *config.Message) {
 		case *slack.ConnectedEvent:
 			b.actingUserID = ev.Info.User.ID
 			b.actingUserName = ev.Info.User.Name
-			b.channels.populateChannels(true)
-			b.users.populateUsers(true)
+			if b.syncsClient() {
+				b.channels.populateChannels(true)
+				b.users.populateUsers(true)
+			}
 
 		case *slack.OutgoingErrorEvent:
 			b.Log.Debugf("%#v", ev.Error())
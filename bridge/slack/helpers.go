@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
 	"github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
@@ -56,6 +57,30 @@ func (b *Bslack) populateReceivedMessage(msg *slack.Msg, channel string, edited
 	return rmsg, err
 }
 
+// ClassifyError wraps the errors of the Slack API with their bridge.ErrorClass.
+func (b *Bslack) ClassifyError(err error) error {
+	var rateLimitErr *slack.RateLimitedError
+	if errors.As(err, &rateLimitErr) {
+		return bridge.NewRateLimitError(err, rateLimitErr.RetryAfter)
+	}
+	var statusErr slack.StatusCodeError
+	if errors.As(err, &statusErr) {
+		if class := bridge.ClassifyStatusCode(statusErr.Code); class != bridge.ErrorUnknown {
+			return bridge.NewError(class, err)
+		}
+	}
+
+	switch err.Error() {
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "token_expired":
+		return bridge.NewError(bridge.ErrorAuth, err)
+	case "not_in_channel", "channel_not_found", "is_archived", "restricted_action", "missing_scope":
+		return bridge.NewError(bridge.ErrorPermission, err)
+	case "msg_too_long", "file_too_large":
+		return bridge.NewError(bridge.ErrorTooLarge, err)
+	}
+	return err
+}
+
 var ErrNoUserInfo = errors.New("could not find information for user")
 
 func (b *Bslack) populateMessageWithUserInfo(msg *slack.Msg, rmsg *config.Message) error {
@@ -63,6 +88,10 @@ func (b *Bslack) populateMessageWithUserInfo(msg *slack.Msg, rmsg *config.Messag
 		return nil
 	}
 
+	if msg.BotID != "" {
+		rmsg.MarkBot()
+	}
+
 	// First, deal with bot-originating messages but only do so when not using webhooks:
 	// we would not be able to distinguish which bot would be sending them.
 	if err := b.populateMessageWithBotInfo(msg.BotID, msg.Username, rmsg); err != nil {
@@ -187,31 +216,31 @@ func (b *Bslack) replaceURL(text string) string {
 	return urlRE.ReplaceAllString(text, "[${2}](${1})")
 }
 
+// taken from https://github.com/mattermost/mattermost-server/blob/master/app/slackimport.go
+var b0rkedMarkDownRules = []struct {
+	regex *regexp.Regexp
+	rpl   string
+}{
+	// bold
+	{
+		regexp.MustCompile(`(^|[\s.;,])\*(\S[^*\n]+)\*`),
+		"$1**$2**",
+	},
+	// strikethrough
+	{
+		regexp.MustCompile(`(^|[\s.;,])\~(\S[^~\n]+)\~`),
+		"$1~~$2~~",
+	},
+	// single paragraph blockquote
+	// Slack converts > character to &gt;
+	{
+		regexp.MustCompile(`(?sm)^&gt;`),
+		">",
+	},
+}
+
 func (b *Bslack) replaceb0rkedMarkDown(text string) string {
-	// taken from https://github.com/mattermost/mattermost-server/blob/master/app/slackimport.go
-	//
-	regexReplaceAllString := []struct {
-		regex *regexp.Regexp
-		rpl   string
-	}{
-		// bold
-		{
-			regexp.MustCompile(`(^|[\s.;,])\*(\S[^*\n]+)\*`),
-			"$1**$2**",
-		},
-		// strikethrough
-		{
-			regexp.MustCompile(`(^|[\s.;,])\~(\S[^~\n]+)\~`),
-			"$1~~$2~~",
-		},
-		// single paragraph blockquote
-		// Slack converts > character to &gt;
-		{
-			regexp.MustCompile(`(?sm)^&gt;`),
-			">",
-		},
-	}
-	for _, rule := range regexReplaceAllString {
+	for _, rule := range b0rkedMarkDownRules {
 		text = rule.regex.ReplaceAllString(text, rule.rpl)
 	}
 	return text
@@ -1,11 +1,14 @@
 package bslack
 
 import (
+	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +37,66 @@ func TestExtractTopicOrPurpose(t *testing.T) {
 		assert.Equalf(t, tc.wantOutput, gotOutput, "This testcase failed: %s", name)
 	}
 }
+
+func TestClassifyError(t *testing.T) {
+	b := &Bslack{}
+
+	err := b.ClassifyError(&slack.RateLimitedError{RetryAfter: 3 * time.Second})
+	assert.ErrorIs(t, err, bridge.ErrRateLimited)
+	var bridgeErr *bridge.Error
+	assert.ErrorAs(t, err, &bridgeErr)
+	assert.Equal(t, 3*time.Second, bridgeErr.RetryAfter)
+
+	assert.ErrorIs(t, b.ClassifyError(errors.New("not_in_channel")), bridge.ErrPermission)
+	assert.ErrorIs(t, b.ClassifyError(errors.New("invalid_auth")), bridge.ErrAuth)
+	assert.ErrorIs(t, b.ClassifyError(slack.StatusCodeError{Code: 503}), bridge.ErrNetwork)
+	assert.Equal(t, bridge.ErrorUnknown, bridge.ErrorClassOf(b.ClassifyError(errors.New("oops"))))
+}
+
+func TestSharedClient(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	newAccount := func(account string) *Bslack {
+		return newBridge(&bridge.Config{Bridge: &bridge.Bridge{Log: logrus.NewEntry(logger), Account: account}})
+	}
+
+	c := &sharedClient{
+		key:       "xoxb-test/",
+		rtm:       &slack.RTM{IncomingEvents: make(chan slack.RTMEvent)},
+		budget:    bridge.NewAPIBudget(50),
+		rtmEvents: make(map[*Bslack]*subscriber[slack.RTMEvent]),
+	}
+	sharedClients[c.key] = c
+	defer delete(sharedClients, c.key)
+	go c.dispatchRTM()
+
+	first, second := newAccount("slack.first"), newAccount("slack.second")
+	first.acquireClient("xoxb-test", "")
+	second.acquireClient("xoxb-test", "")
+	assert.Same(t, c.budget, second.Budget)
+	assert.True(t, first.syncsClient())
+	assert.False(t, second.syncsClient())
+
+	// every account receives the events of the connection, an account not
+	// reading them doesn't hold up the others
+	for range 200 {
+		c.rtm.IncomingEvents <- slack.RTMEvent{Type: "hello"}
+	}
+	for range 200 {
+		assert.Equal(t, "hello", (<-second.rtmEvents).Type)
+	}
+	assert.Equal(t, "hello", (<-first.rtmEvents).Type)
+
+	// the events not read yet are dropped once the account is released
+	assert.NoError(t, first.releaseClient())
+	for range first.rtmEvents {
+	}
+	assert.True(t, second.syncsClient())
+	assert.Contains(t, sharedClients, c.key)
+}
+
+func TestReactionText(t *testing.T) {
+	assert.Equal(t, "👍", reactionText("thumbsup"))
+	assert.Equal(t, "👍", reactionText("+1::skin-tone-2"))
+	assert.Equal(t, ":partyparrot:", reactionText("partyparrot"))
+}
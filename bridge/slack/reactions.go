@@ -0,0 +1,90 @@
+package bslack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	"github.com/slack-go/slack"
+)
+
+// handleReaction returns the message of a reaction added to or removed from
+// the message ts of channel.
+func (b *Bslack) handleReaction(user string, reaction string, channel string, ts string, removed bool) (*config.Message, error) {
+	if user == b.actingUserID {
+		return nil, ErrEventIgnored
+	}
+	channelInfo, err := b.channels.getChannelByID(channel)
+	if err != nil {
+		return nil, err
+	}
+	rmsg := &config.Message{
+		Event:    config.EventReaction,
+		Text:     reactionText(reaction),
+		Channel:  channelInfo.Name,
+		Account:  b.Account,
+		Username: b.users.getUsername(user),
+		UserID:   user,
+		ParentID: ts,
+		Protocol: b.Protocol,
+	}
+	if b.useChannelID {
+		rmsg.Channel = "ID:" + channelInfo.ID
+	}
+	if removed {
+		rmsg.MarkReactionRemoved()
+	}
+	return rmsg, nil
+}
+
+// reactionText returns the unicode emoji of the name of a slack reaction, or
+// its :name: for the custom emoji of the workspace. The skin tones are
+// dropped.
+func reactionText(name string) string {
+	name, _, _ = strings.Cut(name, "::")
+	if e, ok := helper.EmojiUnicode(name); ok {
+		return e
+	}
+	return ":" + name + ":"
+}
+
+// sendReaction adds the reaction in msg.Text to the message referenced by
+// msg.ParentID, or removes it when the message is flagged with
+// MarkReactionRemoved. Slack takes the reactions by name, the names of the
+// emoji are tried until one is accepted. The reactions slack doesn't know are
+// sent as a reply.
+func (b *Bslack) sendReaction(msg *config.Message, channelInfo *slack.Channel) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+
+	item := slack.NewRefToMessage(channelInfo.ID, msg.ParentID)
+	for _, name := range helper.ReactionNames(msg.Text) {
+		var err error
+		if msg.ReactionRemoved() {
+			err = b.sc.RemoveReaction(name, item)
+		} else {
+			err = b.sc.AddReaction(name, item)
+		}
+		switch {
+		case err == nil, err.Error() == "already_reacted", err.Error() == "no_reaction":
+			return "", nil
+		case err.Error() != "invalid_name":
+			return "", err
+		}
+		b.Log.Debugf("reaction %s: %s", name, err)
+	}
+
+	if msg.ReactionRemoved() {
+		return "", nil
+	}
+	b.Log.Debugf("%q isn't a slack reaction, sending it as a reply", msg.Text)
+	msg.Event = ""
+	msg.Text = fmt.Sprintf("reacted with %s", strings.TrimSpace(helper.EmojiToUnicode(msg.Text)))
+	if b.GetBool(useNickPrefixConfig) {
+		msg.Text = msg.Username + msg.Text
+	}
+	return b.postMessage(msg, channelInfo)
+}
@@ -0,0 +1,264 @@
+package bslack
+
+import (
+	"context"
+	"sync"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// sharedClients are the connections to slack by token, see sharedClient.
+var (
+	sharedClientsMutex sync.Mutex
+	sharedClients      = make(map[string]*sharedClient)
+)
+
+// sharedClient is the connection to slack of the accounts using the same
+// token. Accounts splitting the channels of a workspace across gateways share
+// one API client, websocket and API budget, and each receives all the events
+// of the connection: the gateways only relay the channels of their accounts.
+type sharedClient struct {
+	key string
+	sc  *slack.Client
+	rtm *slack.RTM
+	smc *socketmode.Client
+
+	smcStop context.CancelFunc
+
+	channels *channels
+	users    *users
+	budget   *bridge.APIBudget
+
+	// accounts are the accounts using the connection, the first one syncs the
+	// channels and users for all of them
+	accountsMutex sync.Mutex
+	accounts      []*Bslack
+
+	// eventsMutex guards the subscribers the events are queued for
+	eventsMutex sync.Mutex
+	rtmEvents   map[*Bslack]*subscriber[slack.RTMEvent]
+	smcEvents   map[*Bslack]*subscriber[socketmode.Event]
+}
+
+// subscriber forwards the events of a connection to one of its accounts. The
+// events are queued without limit and forwarded by a goroutine of its own, so
+// that an account slow to handle them, or no longer handling them, doesn't
+// hold up the others.
+type subscriber[T any] struct {
+	events chan T
+
+	mu    sync.Mutex
+	queue []T
+	wake  chan struct{}
+	done  chan struct{}
+}
+
+func newSubscriber[T any]() *subscriber[T] {
+	s := &subscriber[T]{
+		events: make(chan T, 100),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.forward()
+	return s
+}
+
+// push queues evt for the account.
+func (s *subscriber[T]) push(evt T) {
+	s.mu.Lock()
+	s.queue = append(s.queue, evt)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward sends the queued events to the account until stop is called, and
+// closes its channel.
+func (s *subscriber[T]) forward() {
+	defer close(s.events)
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, evt := range queue {
+			select {
+			case s.events <- evt:
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stop stops forwarding the events, the events still queued are dropped.
+func (s *subscriber[T]) stop() {
+	close(s.done)
+}
+
+// acquireClient connects b to slack, through the connection of another
+// account using the same token if there is one.
+func (b *Bslack) acquireClient(token string, appToken string) {
+	sharedClientsMutex.Lock()
+	defer sharedClientsMutex.Unlock()
+
+	key := token + "/" + appToken
+	c, ok := sharedClients[key]
+	if !ok {
+		c = b.newSharedClient(key, token, appToken)
+		sharedClients[key] = c
+	}
+
+	c.accountsMutex.Lock()
+	if len(c.accounts) > 0 {
+		b.Log.Infof("Sharing the slack connection of %s", c.accounts[0].Account)
+	}
+	c.accounts = append(c.accounts, b)
+	c.accountsMutex.Unlock()
+
+	c.eventsMutex.Lock()
+	defer c.eventsMutex.Unlock()
+	if c.smc != nil {
+		s := newSubscriber[socketmode.Event]()
+		c.smcEvents[b] = s
+		b.smcEvents = s.events
+	} else {
+		s := newSubscriber[slack.RTMEvent]()
+		c.rtmEvents[b] = s
+		b.rtmEvents = s.events
+	}
+
+	b.shared = c
+	b.sc = c.sc
+	b.rtm = c.rtm
+	b.smc = c.smc
+	b.channels = c.channels
+	b.users = c.users
+	b.Budget = c.budget
+}
+
+// newSharedClient opens the connection of token. The API calls are counted in
+// the budget of b.
+func (b *Bslack) newSharedClient(key string, token string, appToken string) *sharedClient {
+	debug := b.GetBool("Debug")
+	c := &sharedClient{
+		key:       key,
+		sc:        slack.New(token, slack.OptionDebug(debug), slack.OptionAppLevelToken(appToken), b.httpClientOption()),
+		budget:    b.Budget,
+		rtmEvents: make(map[*Bslack]*subscriber[slack.RTMEvent]),
+		smcEvents: make(map[*Bslack]*subscriber[socketmode.Event]),
+	}
+	c.channels = newChannelManager(b.Log, c.sc)
+	c.users = newUserManager(b.Log, c.sc, b.Budget)
+
+	// if app token is set then prefer using socketmode events rather than legacy RTM
+	if appToken != "" {
+		ctx, stop := context.WithCancel(context.Background())
+		c.smc = socketmode.New(c.sc, socketmode.OptionDebug(debug), socketmode.OptionLog(&smlog{b.Log}))
+		c.smcStop = stop
+		go b.startSocketEAPIConnection(ctx, c.smc)
+		go c.dispatchEAPI()
+	} else {
+		c.rtm = c.sc.NewRTM()
+		go c.rtm.ManageConnection()
+		go c.dispatchRTM()
+	}
+	return c
+}
+
+// releaseClient disconnects b from its connection, which is closed when no
+// other account uses it.
+func (b *Bslack) releaseClient() error {
+	sharedClientsMutex.Lock()
+	defer sharedClientsMutex.Unlock()
+
+	// b.shared is kept, the handlers of the events may still use it
+	c := b.shared
+	c.accountsMutex.Lock()
+	found := false
+	for i, account := range c.accounts {
+		if account == b {
+			c.accounts = append(c.accounts[:i:i], c.accounts[i+1:]...)
+			found = true
+			break
+		}
+	}
+	remaining := len(c.accounts)
+	c.accountsMutex.Unlock()
+	if !found {
+		return nil
+	}
+
+	c.eventsMutex.Lock()
+	if s, ok := c.rtmEvents[b]; ok {
+		delete(c.rtmEvents, b)
+		s.stop()
+	}
+	if s, ok := c.smcEvents[b]; ok {
+		delete(c.smcEvents, b)
+		s.stop()
+	}
+	c.eventsMutex.Unlock()
+
+	if remaining > 0 {
+		return nil
+	}
+	delete(sharedClients, c.key)
+	if c.smc != nil {
+		c.smcStop()
+		return nil
+	}
+	return c.rtm.Disconnect()
+}
+
+// syncsClient returns true if b syncs the channels and users of its
+// connection, which are shared with the other accounts using it.
+func (b *Bslack) syncsClient() bool {
+	if b.shared == nil {
+		return true
+	}
+	b.shared.accountsMutex.Lock()
+	defer b.shared.accountsMutex.Unlock()
+	return len(b.shared.accounts) > 0 && b.shared.accounts[0] == b
+}
+
+// dispatchRTM sends the events of the RTM connection to all its accounts.
+func (c *sharedClient) dispatchRTM() {
+	for evt := range c.rtm.IncomingEvents {
+		c.eventsMutex.Lock()
+		for _, s := range c.rtmEvents {
+			s.push(evt)
+		}
+		c.eventsMutex.Unlock()
+	}
+}
+
+// dispatchEAPI acknowledges the socket mode events and sends them to all the
+// accounts of the connection.
+func (c *sharedClient) dispatchEAPI() {
+	for evt := range c.smc.Events {
+		switch evt.Type {
+		case socketmode.EventTypeEventsAPI, socketmode.EventTypeSlashCommand, socketmode.EventTypeInteractive:
+			// you MUST ack event
+			if evt.Request != nil {
+				c.smc.Ack(*evt.Request)
+			}
+		}
+		c.eventsMutex.Lock()
+		for _, s := range c.smcEvents {
+			s.push(evt)
+		}
+		c.eventsMutex.Unlock()
+	}
+}
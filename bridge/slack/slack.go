@@ -1,10 +1,10 @@
 package bslack
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -30,8 +30,13 @@ type Bslack struct {
 	rtm *slack.RTM
 
 	// new socket based Events API connection
-	smc     *socketmode.Client
-	smcStop context.CancelFunc
+	smc *socketmode.Client
+
+	// shared is the connection of the accounts using the same token, which
+	// sends its events to rtmEvents or smcEvents
+	shared    *sharedClient
+	rtmEvents chan slack.RTMEvent
+	smcEvents chan socketmode.Event
 
 	actingUserID   string
 	actingUserName string
@@ -42,7 +47,8 @@ type Bslack struct {
 
 	channels *channels
 	users    *users
-	legacy   bool
+	// legacy is set for the non-bot tokens, which can join the channels
+	legacy bool
 }
 
 const (
@@ -91,7 +97,9 @@ func New(cfg *bridge.Config) bridge.Bridger {
 		cfg.Log.Warn("Non-bot token detected. It is STRONGLY recommended to use a proper bot-token instead.")
 		cfg.Log.Warn("Legacy tokens may be deprecated by Slack at short notice. See the Matterbridge GitHub wiki for a migration guide.")
 		cfg.Log.Warn("See https://github.com/42wim/matterbridge/wiki/Slack-bot-setup")
-		return NewLegacy(cfg)
+		b := newBridge(cfg)
+		b.legacy = true
+		return b
 	}
 	// print warning for RTM + classic slack apps setup
 	if strings.HasPrefix(token, "xoxb-") && appToken == "" {
@@ -129,27 +137,28 @@ func (b *Bslack) Connect() error {
 
 	appToken := b.GetString(appTokenConfig)
 	token := b.GetString(tokenConfig)
-	debug := b.GetBool("Debug")
+
+	// With a token and WebhookBindAddress, as set up for slack-legacy, the token
+	// sends the messages and they are received by the local webhook.
+	if token != "" && b.GetString(incomingWebhookConfig) != "" {
+		b.Log.Info("Connecting using token (sending) and webhookbindaddress (receiving)")
+		b.sc = slack.New(token, slack.OptionDebug(b.GetBool("Debug")), b.httpClientOption())
+		b.channels = newChannelManager(b.Log, b.sc)
+		b.users = newUserManager(b.Log, b.sc, b.Budget)
+		b.channels.populateChannels(true)
+		b.users.populateUsers(true)
+		b.mh = matterhook.New("", matterhook.Config{
+			InsecureSkipVerify: b.GetBool("SkipTLSVerify"),
+			BindAddress:        b.GetString(incomingWebhookConfig),
+		})
+		go b.handleSlack()
+		return nil
+	}
 
 	// If we have a token we use the Slack websocket-based RTM or Events API for both sending and receiving.
 	if token != "" {
 		b.Log.Info("Connecting using token")
-
-		b.sc = slack.New(token, slack.OptionDebug(debug), slack.OptionAppLevelToken(appToken))
-
-		b.channels = newChannelManager(b.Log, b.sc)
-		b.users = newUserManager(b.Log, b.sc)
-
-		// if app token is set then prefer using socketmode events rather than legacy RTM
-		if appToken != "" {
-			ctx, stop := context.WithCancel(context.Background())
-			b.smc = socketmode.New(b.sc, socketmode.OptionDebug(debug), socketmode.OptionLog(&smlog{b.Log}))
-			b.smcStop = stop
-			go b.startSocketEAPIConnection(ctx)
-		} else {
-			b.rtm = b.sc.NewRTM()
-			go b.rtm.ManageConnection()
-		}
+		b.acquireClient(token, appToken)
 		go b.handleSlack()
 
 		return nil
@@ -176,10 +185,14 @@ func (b *Bslack) Connect() error {
 	return nil
 }
 
+// httpClientOption counts the Web API calls in the API budget of the account.
+func (b *Bslack) httpClientOption() slack.Option {
+	return slack.OptionHTTPClient(&http.Client{Transport: b.Budget.Transport(nil)})
+}
+
 func (b *Bslack) Disconnect() error {
-	if b.smc != nil {
-		b.smcStop()
-		return nil
+	if b.shared != nil {
+		return b.releaseClient()
 	}
 	if b.rtm != nil {
 		return b.rtm.Disconnect()
@@ -187,6 +200,21 @@ func (b *Bslack) Disconnect() error {
 	return nil
 }
 
+// Alive implements bridge.Heartbeater, the library keeps the connection up
+// itself.
+func (b *Bslack) Alive() error {
+	return nil
+}
+
+// Ping implements bridge.Heartbeater, the webhooks aren't probed.
+func (b *Bslack) Ping(ctx context.Context) error {
+	if b.sc == nil {
+		return nil
+	}
+	_, err := b.sc.AuthTestContext(ctx)
+	return err
+}
+
 // JoinChannel only acts as a verification method that checks whether Matterbridge's
 // Slack integration is already member of the channel. This is because Slack does not
 // allow apps or bots to join channels themselves and they need to be invited
@@ -228,6 +256,44 @@ func (b *Bslack) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// ListChannels returns the names of the channels matterbridge can join, to
+// resolve channel patterns of gateways.
+func (b *Bslack) ListChannels() ([]string, error) {
+	if b.sc == nil {
+		return nil, errors.New("listing channels requires a token")
+	}
+
+	b.channels.populateChannels(true)
+	return b.channels.getChannelNames(b.legacy), nil
+}
+
+// ResolveChannelName returns the name of a channel configured by ID.
+func (b *Bslack) ResolveChannelName(channel string) string {
+	if !strings.HasPrefix(channel, "ID:") || b.channels == nil {
+		return channel
+	}
+
+	channelInfo, err := b.channels.getChannel(channel)
+	if err != nil {
+		return channel
+	}
+	return channelInfo.Name
+}
+
+// Permalink implements bridge.Permalinker, asking slack for the permalink of
+// the message ts.
+func (b *Bslack) Permalink(channel string, ts string) (string, error) {
+	if b.sc == nil {
+		return "", errors.New("permalinks require a token")
+	}
+
+	channelInfo, err := b.channels.getChannel(channel)
+	if err != nil {
+		return "", err
+	}
+	return b.sc.GetPermalink(&slack.PermalinkParameters{Channel: channelInfo.ID, Ts: ts})
+}
+
 func (b *Bslack) Reload(cfg *bridge.Config) (string, error) {
 	return "", nil
 }
@@ -335,6 +401,11 @@ func (b *Bslack) sendAPI(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, channelInfo)
+	}
+
 	var handled bool
 
 	// Handle topic/purpose updates.
@@ -364,7 +435,7 @@ func (b *Bslack) sendAPI(msg config.Message) (string, error) {
 	}
 
 	// Upload a file if it exists.
-	if len(msg.Extra) > 0 {
+	if msg.Extra != nil {
 		extraMsgs := helper.HandleExtra(&msg, b.General)
 		for i := range extraMsgs {
 			rmsg := &extraMsgs[i]
@@ -375,16 +446,20 @@ func (b *Bslack) sendAPI(msg config.Message) (string, error) {
 			}
 		}
 		// Upload files if necessary (from Slack, Telegram or Mattermost).
-		return b.uploadFile(&msg, channelInfo.ID)
+		// The other keys of Extra, eg. the bot or forward flags, don't
+		// make a message an upload.
+		if len(msg.Extra["file"]) > 0 {
+			return b.uploadFile(&msg, channelInfo.ID)
+		}
 	}
 
 	// Post message.
 	return b.postMessage(&msg, channelInfo)
 }
 
-func (b *Bslack) startSocketEAPIConnection(ctx context.Context) {
+func (b *Bslack) startSocketEAPIConnection(ctx context.Context, smc *socketmode.Client) {
 	for {
-		err := b.smc.RunContext(ctx)
+		err := smc.RunContext(ctx)
 		b.Log.Warnf("Slack socket mode client stopped with: %v", err)
 
 		if errors.Is(err, context.Canceled) {
@@ -497,62 +572,6 @@ func (b *Bslack) postMessage(msg *config.Message, channelInfo *slack.Channel) (s
 	}
 }
 
-// uploadFile handles native upload of files
-func (b *Bslack) uploadFile(msg *config.Message, channelID string) (string, error) {
-	var messageID string
-	for _, f := range msg.Extra["file"] {
-		fi, ok := f.(config.FileInfo)
-		if !ok {
-			b.Log.Errorf("Received a file with unexpected content: %#v", f)
-			continue
-		}
-		if msg.Text == fi.Comment {
-			msg.Text = ""
-		}
-		// Because the result of the UploadFile is slower than the MessageEvent from slack
-		// we can't match on the file ID yet, so we have to match on the filename too.
-		ts := time.Now()
-		b.Log.Debugf("Adding file %s to cache at %s with timestamp", fi.Name, ts.String())
-		b.cache.Add("filename"+fi.Name, ts)
-		initialComment := fmt.Sprintf("File from %s", msg.Username)
-		if fi.Comment != "" {
-			initialComment += fmt.Sprintf(" with comment: %s", fi.Comment)
-		}
-		res, err := b.sc.UploadFileV2(slack.UploadFileV2Parameters{
-			Reader:          bytes.NewReader(*fi.Data),
-			Filename:        fi.Name,
-			FileSize:        len(*fi.Data),
-			Channel:         channelID,
-			InitialComment:  initialComment,
-			ThreadTimestamp: msg.ParentID,
-		})
-		if err != nil {
-			b.Log.Errorf("UploadFileV2 error %#v", err)
-			return "", err
-		}
-		if res.ID != "" {
-			// UploadFileV2 doesn't return the full slack.File info like the previous UploadFile, so query it separately
-			sfi, _, _, err := b.sc.GetFileInfo(res.ID, 0, 1)
-			if err != nil {
-				b.Log.Errorf("GetFileInfo uploaded error %#v", err)
-				return "", err
-			}
-
-			b.Log.Debugf("Adding file ID %s to cache with timestamp %s", res.ID, ts.String())
-			b.cache.Add("file"+res.ID, ts)
-
-			// search for message id by uploaded file in private/public channels, get thread timestamp from uploaded file
-			if v, ok := sfi.Shares.Private[channelID]; ok && len(v) > 0 {
-				messageID = v[0].Ts
-			}
-			if v, ok := sfi.Shares.Public[channelID]; ok && len(v) > 0 {
-				messageID = v[0].Ts
-			}
-		}
-	}
-	return messageID, nil
-}
-
 func (b *Bslack) prepareMessageOptions(msg *config.Message) []slack.MsgOption {
 	params := slack.NewPostMessageParameters()
 	if b.GetBool(useNickPrefixConfig) {
@@ -0,0 +1,199 @@
+package bslack
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// maxUploadAttempts is the number of times the content of a file is sent
+	// to Slack when the upload fails with a network error.
+	maxUploadAttempts = 3
+	// maxTitleLength keeps file titles short enough for Slack to show them
+	// under the preview of the file.
+	maxTitleLength = 80
+	// progressLogSize is the size from which the progress of uploads is logged.
+	progressLogSize = 1 << 20
+)
+
+// uploadFile handles native upload of files, with the external upload flow of
+// Slack: every file gets an upload URL, its content is sent there, and the
+// upload is completed by sharing it to the channel.
+func (b *Bslack) uploadFile(msg *config.Message, channelID string) (string, error) {
+	var messageID string
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			b.Log.Errorf("Received a file with unexpected content: %#v", f)
+			continue
+		}
+		if msg.Text == fi.Comment {
+			msg.Text = ""
+		}
+		// Because the result of the upload is slower than the MessageEvent from slack
+		// we can't match on the file ID yet, so we have to match on the filename too.
+		ts := time.Now()
+		b.Log.Debugf("Adding file %s to cache at %s with timestamp", fi.Name, ts.String())
+		b.cache.Add("filename"+fi.Name, ts)
+
+		fileID, err := b.uploadExternal(&fi)
+		if err != nil {
+			b.Log.Errorf("Uploading file %s failed: %s", fi.Name, err)
+			return "", err
+		}
+		b.Log.Debugf("Adding file ID %s to cache with timestamp %s", fileID, ts.String())
+		b.cache.Add("file"+fileID, ts)
+
+		initialComment := fmt.Sprintf("File from %s", msg.Username)
+		if fi.Comment != "" {
+			initialComment += fmt.Sprintf(" with comment: %s", fi.Comment)
+		}
+		if err = b.shareUpload(fileID, uploadTitle(fi.Name), channelID, initialComment, msg.ParentID); err != nil {
+			b.Log.Errorf("Sharing file %s to %s failed: %s", fi.Name, channelID, err)
+			return "", err
+		}
+
+		if id := b.sharedMessageID(fileID, channelID); id != "" {
+			messageID = id
+		}
+	}
+	return messageID, nil
+}
+
+// uploadExternal sends the content of a file to Slack and returns its ID. The
+// file isn't visible until it is shared with shareUpload.
+func (b *Bslack) uploadExternal(fi *config.FileInfo) (string, error) {
+	size := int(fi.DataSize())
+	var upload *slack.GetUploadURLExternalResponse
+	for {
+		var err error
+		upload, err = b.sc.GetUploadURLExternalContext(context.Background(), slack.GetUploadURLExternalParameters{
+			FileName: fi.Name,
+			FileSize: size,
+			AltTxt:   fi.AltText,
+		})
+		if err == nil {
+			break
+		}
+		if err = handleRateLimit(b.Log, err); err != nil {
+			return "", err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		// spooled files are streamed from their spool file
+		data, err := fi.Open()
+		if err != nil {
+			return "", err
+		}
+		var reader io.Reader = data
+		if size >= progressLogSize {
+			reader = &progressReader{Reader: reader, log: b.Log, name: fi.Name, size: size}
+		}
+		err = b.sc.UploadToURL(context.Background(), slack.UploadToURLParameters{
+			UploadURL: upload.UploadURL,
+			Reader:    reader,
+			Filename:  fi.Name,
+		})
+		data.Close()
+		if err == nil {
+			return upload.FileID, nil
+		}
+		if attempt == maxUploadAttempts || !b.isTransientError(err) {
+			return "", err
+		}
+		b.Log.Warnf("Uploading %s failed (attempt %d/%d), retrying: %s", fi.Name, attempt, maxUploadAttempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// shareUpload completes the upload of a file by sharing it to the channel.
+func (b *Bslack) shareUpload(fileID, title, channelID, initialComment, threadTS string) error {
+	for {
+		_, err := b.sc.CompleteUploadExternalContext(context.Background(), slack.CompleteUploadExternalParameters{
+			Files:           []slack.FileSummary{{ID: fileID, Title: title}},
+			Channel:         channelID,
+			InitialComment:  initialComment,
+			ThreadTimestamp: threadTS,
+		})
+		if err == nil {
+			return nil
+		}
+		if err = handleRateLimit(b.Log, err); err != nil {
+			return err
+		}
+	}
+}
+
+// sharedMessageID returns the timestamp of the message sharing the file in the
+// channel, which is its message ID.
+func (b *Bslack) sharedMessageID(fileID, channelID string) string {
+	sfi, _, _, err := b.sc.GetFileInfo(fileID, 0, 1)
+	if err != nil {
+		b.Log.Errorf("GetFileInfo uploaded error %#v", err)
+		return ""
+	}
+	// search for message id by uploaded file in private/public channels
+	if v, ok := sfi.Shares.Public[channelID]; ok && len(v) > 0 {
+		return v[0].Ts
+	}
+	if v, ok := sfi.Shares.Private[channelID]; ok && len(v) > 0 {
+		return v[0].Ts
+	}
+	return ""
+}
+
+// isTransientError returns true if sending the file again may succeed.
+func (b *Bslack) isTransientError(err error) bool {
+	switch bridge.ErrorClassOf(b.ClassifyError(err)) {
+	case bridge.ErrorNetwork, bridge.ErrorRateLimited:
+		return true
+	default:
+		return false
+	}
+}
+
+// uploadTitle returns the title shown under the preview of an uploaded file:
+// its base name, shortened to maxTitleLength while keeping the extension.
+func uploadTitle(name string) string {
+	title := path.Base(name)
+	runes := []rune(title)
+	if len(runes) <= maxTitleLength {
+		return title
+	}
+	ext := []rune(path.Ext(title))
+	if len(ext) > maxTitleLength/2 {
+		ext = nil
+	}
+	return strings.TrimSpace(string(runes[:maxTitleLength-len(ext)-1])) + "…" + string(ext)
+}
+
+// progressReader logs the progress of an upload every quarter.
+type progressReader struct {
+	io.Reader
+
+	log      *logrus.Entry
+	name     string
+	size     int
+	read     int
+	reported int
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	if quarter := r.read * 4 / r.size; quarter > r.reported {
+		r.reported = quarter
+		r.log.Debugf("Uploading %s: %d%% of %d bytes", r.name, quarter*25, r.size)
+	}
+	return n, err
+}
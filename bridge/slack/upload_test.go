@@ -0,0 +1,115 @@
+package bslack
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadTitle(t *testing.T) {
+	assert.Equal(t, "photo.jpg", uploadTitle("photo.jpg"))
+	assert.Equal(t, "photo.jpg", uploadTitle("albums/photo.jpg"))
+
+	long := uploadTitle(strings.Repeat("a", 100) + ".png")
+	assert.Len(t, []rune(long), maxTitleLength)
+	assert.True(t, strings.HasSuffix(long, "a….png"))
+}
+
+func TestUploadFile(t *testing.T) {
+	var uploads int
+	var completed []string
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/files.getUploadURLExternal", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true,"upload_url":"`+srv.URL+`/upload","file_id":"F1"}`)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails with a server error and is retried
+		if uploads++; uploads == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, "OK")
+	})
+	mux.HandleFunc("/files.completeUploadExternal", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		completed = append(completed, r.Form.Get("channel_id"), r.Form.Get("files"), r.Form.Get("initial_comment"))
+		io.WriteString(w, `{"ok":true,"files":[{"id":"F1","title":"photo.jpg"}]}`)
+	})
+	mux.HandleFunc("/files.info", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"ok":true,"file":{"id":"F1","shares":{"public":{"C1":[{"ts":"1234.5678"}]}}}}`)
+	})
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cache, _ := lru.New(10)
+	b := &Bslack{
+		Config: &bridge.Config{Bridge: &bridge.Bridge{Log: logrus.NewEntry(logger)}},
+		sc:     slack.New("token", slack.OptionAPIURL(srv.URL+"/")),
+		cache:  cache,
+	}
+
+	data := []byte("image")
+	msg := &config.Message{
+		Username: "bob",
+		Extra: map[string][]interface{}{
+			"file": {config.FileInfo{Name: "photo.jpg", Data: &data, Comment: "look"}},
+		},
+	}
+	id, err := b.uploadFile(msg, "C1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1234.5678", id)
+	assert.Equal(t, 2, uploads)
+	assert.Equal(t, []string{"C1", `[{"id":"F1","title":"photo.jpg"}]`, "File from bob with comment: look"}, completed)
+	assert.True(t, b.cache.Contains("fileF1"))
+}
+
+func TestSendFlaggedMessage(t *testing.T) {
+	var posted []string
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		posted = append(posted, r.Form.Get("text"))
+		io.WriteString(w, `{"ok":true,"channel":"C1","ts":"1234.5678"}`)
+	})
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cache, _ := lru.New(10)
+	sc := slack.New("token", slack.OptionAPIURL(srv.URL+"/"))
+	b := &Bslack{
+		Config: &bridge.Config{Bridge: &bridge.Bridge{
+			Account: "slack.test",
+			Config:  config.NewConfigFromString(logger, []byte("[slack.test]\nToken=\"token\"\n")),
+			General: &config.Protocol{},
+			Log:     logrus.NewEntry(logger),
+		}},
+		sc:       sc,
+		cache:    cache,
+		channels: newChannelManager(logrus.NewEntry(logger), sc),
+	}
+	b.channels.channelsByName["general"] = &slack.Channel{GroupConversation: slack.GroupConversation{Conversation: slack.Conversation{ID: "C1"}}}
+
+	// The flags in Extra don't turn the message into an upload, dropping its text
+	msg := config.Message{Text: "hello", Username: "bob: ", Channel: "general", Account: "irc.libera", Extra: map[string][]interface{}{}}
+	msg.MarkBot()
+	msg.SetForward(config.Forward{From: "alice"})
+	id, err := b.Send(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234.5678", id)
+	assert.Len(t, posted, 1)
+	assert.Contains(t, posted[0], "hello")
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
 	"github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
@@ -15,8 +16,9 @@ import (
 const minimumRefreshInterval = 10 * time.Second
 
 type users struct {
-	log *logrus.Entry
-	sc  *slack.Client
+	log    *logrus.Entry
+	sc     *slack.Client
+	budget *bridge.APIBudget
 
 	users           map[string]*slack.User
 	usersMutex      sync.RWMutex
@@ -27,10 +29,11 @@ type users struct {
 	refreshMutex      sync.Mutex
 }
 
-func newUserManager(log *logrus.Entry, sc *slack.Client) *users {
+func newUserManager(log *logrus.Entry, sc *slack.Client, budget *bridge.APIBudget) *users {
 	return &users{
 		log:             log,
 		sc:              sc,
+		budget:          budget,
 		users:           make(map[string]*slack.User),
 		usersSyncPoints: make(map[string]chan struct{}),
 		earliestRefresh: time.Now(),
@@ -139,6 +142,7 @@ func (b *users) populateUsers(wait bool) {
 	pagination := b.sc.GetUsersPaginated(slack.GetUsersOptionLimit(200))
 	count := 0
 	for {
+		b.budget.Wait()
 		var err error
 		pagination, err = pagination.Next(context.Background())
 		time.Sleep(time.Second)
@@ -227,6 +231,21 @@ func (b *channels) getChannelBy(lookupKey string, lookupMap map[string]*slack.Ch
 	return nil, fmt.Errorf("channel %s not found", lookupKey)
 }
 
+// getChannelNames returns the names of the channels we are a member of, or of
+// all channels when we can join them ourselves.
+func (b *channels) getChannelNames(all bool) []string {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+
+	names := make([]string, 0, len(b.channelsByName))
+	for name, channel := range b.channelsByName {
+		if all || channel.IsMember {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (b *channels) getChannelMembers(users *users) config.ChannelMembers {
 	b.channelMembersMutex.RLock()
 	defer b.channelMembersMutex.RUnlock()
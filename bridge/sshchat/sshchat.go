@@ -156,9 +156,9 @@ func (b *Bsshchat) handleUploadFile(msg *config.Message) (string, error) {
 			msg.Text += fi.Comment + ": "
 		}
 		if fi.URL != "" {
-			msg.Text = fi.URL
+			msg.Text = helper.FormatAttachment(fi, b.GetString("AttachmentFormat"))
 			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
+				msg.Text = fi.Comment + ": " + msg.Text
 			}
 		}
 		if _, err := b.w.Write([]byte(msg.Username + msg.Text + "\r\n")); err != nil {
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisPrefix is the prefix of the keys of the hashes of the namespaces,
+	// so that the server can be shared.
+	redisPrefix = "matterbridge:"
+	// redisTimeout is how long a request to the server may take.
+	redisTimeout = 10 * time.Second
+)
+
+// redisStore is a Store kept by a Redis server, with a hash per namespace.
+type redisStore struct {
+	client *redis.Client
+}
+
+func openRedis(url string) (*redisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the redis URL failed: %w", err)
+	}
+	s := &redisStore{client: redis.NewClient(opts)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, fmt.Errorf("connecting to %s failed: %w", opts.Addr, err)
+	}
+	return s, nil
+}
+
+func (s *redisStore) Get(namespace string, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	value, err := s.client.HGet(ctx, redisPrefix+namespace, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *redisStore) Put(namespace string, key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.HSet(ctx, redisPrefix+namespace, key, value).Err()
+}
+
+func (s *redisStore) Delete(namespace string, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.HDel(ctx, redisPrefix+namespace, key).Err()
+}
+
+func (s *redisStore) Keys(namespace string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	keys, err := s.client.HKeys(ctx, redisPrefix+namespace).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
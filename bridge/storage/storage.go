@@ -0,0 +1,115 @@
+// Package storage keeps the state of matterbridge across restarts (scheduled
+// messages, sync tokens, message IDs, ...) in a key-value store with namespaces, whose
+// backend is chosen with StorageBackend.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Backends of StorageBackend.
+const (
+	BackendMemory = "memory"
+	BackendSQLite = "sqlite"
+	BackendBolt   = "bbolt"
+	BackendRedis  = "redis"
+)
+
+// ErrNotFound is returned by Get for the keys which aren't stored.
+var ErrNotFound = errors.New("not found")
+
+// Store is a key-value store. The keys are grouped in namespaces, one per kind
+// of state, eg. "schedule" or "matrix-sync". It is safe for concurrent use.
+type Store interface {
+	// Get returns the value of key in namespace, or ErrNotFound.
+	Get(namespace string, key string) ([]byte, error)
+	// Put sets the value of key in namespace.
+	Put(namespace string, key string, value []byte) error
+	// Delete removes key from namespace, it isn't an error when it isn't
+	// stored.
+	Delete(namespace string, key string) error
+	// Keys returns the keys of namespace, sorted.
+	Keys(namespace string) ([]string, error)
+	Close() error
+}
+
+// Open returns the store of backend, kept in path for the backends writing to
+// disk, or by the server of the URL path for redis.
+func Open(backend string, path string) (Store, error) {
+	if backend != BackendMemory && path == "" {
+		return nil, fmt.Errorf("StoragePath is required by the %s storage", backend)
+	}
+	switch backend {
+	case BackendMemory:
+		return NewMemory(), nil
+	case BackendSQLite:
+		return openSQLite(path)
+	case BackendBolt:
+		return openBolt(path)
+	case BackendRedis:
+		return openRedis(path)
+	default:
+		return nil, fmt.Errorf("unknown StorageBackend %q, use %q, %q, %q or %q", backend, BackendSQLite, BackendBolt, BackendRedis, BackendMemory)
+	}
+}
+
+// Memory is a Store keeping its values in memory only, they are lost on
+// restart.
+type Memory struct {
+	mu     sync.RWMutex
+	values map[string]map[string][]byte
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{values: make(map[string]map[string][]byte)}
+}
+
+func (m *Memory) Get(namespace string, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.values[namespace][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) Put(namespace string, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.values[namespace] == nil {
+		m.values[namespace] = make(map[string][]byte)
+	}
+	m.values[namespace][key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Delete(namespace string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values[namespace], key)
+	return nil
+}
+
+func (m *Memory) Keys(namespace string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.values[namespace]))
+	for key := range m.values[namespace] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
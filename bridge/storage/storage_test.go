@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	for _, backend := range []string{BackendMemory, BackendSQLite, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			s, err := Open(backend, filepath.Join(dir, backend+".db"))
+			require.NoError(t, err)
+			defer s.Close()
+
+			_, err = s.Get("schedule", "messages")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, s.Put("schedule", "messages", []byte("[1]")))
+			require.NoError(t, s.Put("schedule", "messages", []byte("[2]")))
+			require.NoError(t, s.Put("schedule", "b", nil))
+			require.NoError(t, s.Put("other", "messages", []byte("x")))
+
+			value, err := s.Get("schedule", "messages")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("[2]"), value)
+			keys, err := s.Keys("schedule")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b", "messages"}, keys)
+
+			require.NoError(t, s.Delete("schedule", "messages"))
+			require.NoError(t, s.Delete("schedule", "unknown"))
+			_, err = s.Get("schedule", "messages")
+			assert.ErrorIs(t, err, ErrNotFound)
+			keys, err = s.Keys("missing")
+			require.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+	}
+
+	// the values are kept across restarts
+	for _, backend := range []string{BackendSQLite, BackendBolt} {
+		s, err := Open(backend, filepath.Join(dir, backend+".db"))
+		require.NoError(t, err)
+		value, err := s.Get("other", "messages")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("x"), value, backend)
+		require.NoError(t, s.Close())
+	}
+
+	_, err := Open("etcd", "state")
+	assert.Error(t, err)
+	_, err = Open(BackendSQLite, "")
+	assert.Error(t, err)
+	_, err = Open(BackendRedis, "localhost:6379")
+	assert.ErrorContains(t, err, "redis URL")
+	// the server is checked on startup
+	_, err = Open(BackendRedis, "redis://127.0.0.1:1/0")
+	assert.ErrorContains(t, err, "127.0.0.1:1")
+}
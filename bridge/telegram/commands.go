@@ -0,0 +1,72 @@
+package btelegram
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+)
+
+// RegisterCommands declares the control commands as bot commands, suggested
+// by the clients when typing "/".
+func (b *Btelegram) RegisterCommands(commands []bridge.CommandInfo) error {
+	botCommands := make([]tgbotapi.BotCommand, 0, len(commands))
+	names := make(map[string]bool, len(commands))
+	for _, cmd := range commands {
+		botCommands = append(botCommands, tgbotapi.BotCommand{
+			Command:     cmd.Name,
+			Description: strings.TrimSpace(cmd.Usage + " " + cmd.Help),
+		})
+		names[cmd.Name] = true
+	}
+
+	b.commandsMu.Lock()
+	b.commands = names
+	b.commandsMu.Unlock()
+
+	_, err := b.c.Request(tgbotapi.NewSetMyCommands(botCommands...))
+	return err
+}
+
+// handleCommand sends message to the gateway as a control command when it is
+// one of the registered bot commands. Returns true if message was a command.
+func (b *Btelegram) handleCommand(rmsg *config.Message, message *tgbotapi.Message) bool {
+	if !b.GetBool("Commands") || message.From == nil || !message.IsCommand() {
+		return false
+	}
+	// ignore the commands addressed to other bots of the group
+	if _, bot, ok := strings.Cut(message.CommandWithAt(), "@"); ok && !strings.EqualFold(bot, b.c.Self.UserName) {
+		return false
+	}
+	b.commandsMu.Lock()
+	known := b.commands[message.Command()]
+	b.commandsMu.Unlock()
+	if !known {
+		return false
+	}
+
+	rmsg.Event = config.EventCommand
+	rmsg.Text = strings.TrimSpace(message.Command() + " " + message.CommandArguments())
+	rmsg.UserID = strconv.FormatInt(message.From.ID, 10)
+	rmsg.Username = message.From.String()
+	b.Log.Debugf("<= Sending command from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Remote <- *rmsg
+	return true
+}
+
+// ReplyCommand replies to the bot command cmd in its chat.
+func (b *Btelegram) ReplyCommand(cmd config.Message, reply string) error {
+	chatid, topicid, err := b.getIds(cmd.Channel)
+	if err != nil {
+		return err
+	}
+	m := tgbotapi.NewMessage(chatid, reply)
+	m.BaseChat.MessageThreadID = topicid
+	if parentID, err := strconv.Atoi(cmd.ID); err == nil {
+		m.ReplyToMessageID = parentID
+	}
+	_, err = b.c.Send(m)
+	return err
+}
@@ -0,0 +1,164 @@
+package btelegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+)
+
+// reactionEmoji are the emoji bots can react with, see
+// https://core.telegram.org/bots/api#reactiontypeemoji
+// They are stored without variation selector.
+var reactionEmoji = map[string]struct{}{
+	"👍": {}, "👎": {}, "❤": {}, "🔥": {}, "🥰": {}, "👏": {}, "😁": {}, "🤔": {},
+	"🤯": {}, "😱": {}, "🤬": {}, "😢": {}, "🎉": {}, "🤩": {}, "🤮": {}, "💩": {},
+	"🙏": {}, "👌": {}, "🕊": {}, "🤡": {}, "🥱": {}, "🥴": {}, "😍": {}, "🐳": {},
+	"❤‍🔥": {}, "🌚": {}, "🌭": {}, "💯": {}, "🤣": {}, "⚡": {}, "🍌": {}, "🏆": {},
+	"💔": {}, "🤨": {}, "😐": {}, "🍓": {}, "🍾": {}, "💋": {}, "🖕": {}, "😈": {},
+	"😴": {}, "😭": {}, "🤓": {}, "👻": {}, "👨‍💻": {}, "👀": {}, "🎃": {}, "🙈": {},
+	"😇": {}, "😨": {}, "🤝": {}, "✍": {}, "🤗": {}, "🫡": {}, "🎅": {}, "🎄": {},
+	"☃": {}, "💅": {}, "🤪": {}, "🗿": {}, "🆒": {}, "💘": {}, "🙉": {}, "🦄": {},
+	"😘": {}, "💊": {}, "🙊": {}, "😎": {}, "👾": {}, "🤷‍♂": {}, "🤷": {}, "🤷‍♀": {},
+	"😡": {},
+}
+
+// reactionText returns the emoji of the reaction text of another network, and
+// whether telegram accepts it as a reaction.
+func reactionText(text string) (string, bool) {
+	text = strings.TrimSpace(helper.EmojiToUnicode(text))
+	_, ok := reactionEmoji[strings.ReplaceAll(text, "\ufe0f", "")]
+	return text, ok
+}
+
+// sendReaction adds the reaction in msg.Text to the message referenced by
+// msg.ParentID. Telegram only accepts a fixed set of emoji as reactions, the
+// other reactions (custom emoji of other networks, ...) are sent as a reply.
+//
+// The bot API library predates the reactions, so setMessageReaction is called
+// directly.
+func (b *Btelegram) sendReaction(msg *config.Message, chatid int64) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+	// the bot can only set its reactions all at once, it doesn't know the
+	// other ones it added
+	if msg.ReactionRemoved() {
+		b.Log.Debugf("Dropping reaction removal %#v", msg)
+		return "", nil
+	}
+	parentID, err := b.intParentID(msg.ParentID)
+	if err != nil {
+		return "", err
+	}
+
+	emoji, ok := reactionText(msg.Text)
+	if !ok {
+		b.Log.Debugf("%q isn't a telegram reaction, sending it as a reply", emoji)
+		msg.Event = ""
+		msg.Text = fmt.Sprintf("reacted with %s", emoji)
+		return b.Send(*msg)
+	}
+
+	reaction, err := json.Marshal([]map[string]string{{"type": "emoji", "emoji": emoji}})
+	if err != nil {
+		return "", err
+	}
+	params := tgbotapi.Params{
+		"chat_id":    strconv.FormatInt(chatid, 10),
+		"message_id": strconv.Itoa(parentID),
+		"reaction":   string(reaction),
+	}
+	_, err = b.c.MakeRequest("setMessageReaction", params)
+	return "", err
+}
+
+// getCustomEmoji returns the stickers of the custom emoji of the entities,
+// by custom emoji ID.
+func (b *Btelegram) getCustomEmoji(entities []tgbotapi.MessageEntity) map[string]tgbotapi.Sticker {
+	var ids []string
+	for _, e := range entities {
+		if e.Type == "custom_emoji" && e.CustomEmojiID != "" {
+			ids = append(ids, e.CustomEmojiID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := b.c.Request(tgbotapi.GetCustomEmojiStickersConfig{CustomEmojiIDs: ids})
+	if err != nil {
+		b.Log.Errorf("getting the custom emoji failed: %s", err)
+		return nil
+	}
+	var stickers []tgbotapi.Sticker
+	if err := json.Unmarshal(resp.Result, &stickers); err != nil {
+		b.Log.Errorf("decoding the custom emoji failed: %s", err)
+		return nil
+	}
+
+	emoji := make(map[string]tgbotapi.Sticker, len(stickers))
+	for _, s := range stickers {
+		emoji[s.CustomEmojiID] = s
+	}
+	return emoji
+}
+
+// customEmojiText returns the text of a custom emoji, whose placeholder in the
+// message is placeholder: the base emoji of its sticker, else the placeholder,
+// else the name of its sticker set as a shortcode.
+func customEmojiText(placeholder string, sticker tgbotapi.Sticker) string {
+	switch {
+	case sticker.Emoji != "":
+		return sticker.Emoji
+	case strings.TrimSpace(placeholder) != "":
+		return placeholder
+	case sticker.SetName != "":
+		return ":" + sticker.SetName + ":"
+	}
+	return ":custom_emoji:"
+}
+
+// handleCustomEmoji replaces the custom emoji entity e at offset by its text,
+// and returns the change of the length of the text.
+func handleCustomEmoji(rmsg *config.Message, e tgbotapi.MessageEntity, offset int, stickers map[string]tgbotapi.Sticker) int {
+	asRunes := utf16.Encode([]rune(rmsg.Text))
+	if offset+e.Length > len(asRunes) {
+		return 0
+	}
+	placeholder := string(utf16.Decode(asRunes[offset : offset+e.Length]))
+	text := customEmojiText(placeholder, stickers[e.CustomEmojiID])
+	if text == placeholder {
+		return 0
+	}
+	rmsg.Text = string(utf16.Decode(asRunes[:offset])) + text + string(utf16.Decode(asRunes[offset+e.Length:]))
+	return len(utf16.Encode([]rune(text))) - e.Length
+}
+
+// handleDownloadCustomEmoji attaches the images of the custom emoji of the
+// message with DownloadCustomEmoji, for the networks which can't show them.
+func (b *Btelegram) handleDownloadCustomEmoji(rmsg *config.Message, stickers map[string]tgbotapi.Sticker) {
+	if !b.GetBool("DownloadCustomEmoji") {
+		return
+	}
+	for _, sticker := range stickers {
+		_, name, url := b.getDownloadInfo(sticker.FileID, ".webp", true)
+		if err := helper.HandleDownloadSize(b.Log, rmsg, name, int64(sticker.FileSize), b.General); err != nil {
+			b.Log.Errorf("download of custom emoji %s failed: %s", sticker.CustomEmojiID, err)
+			continue
+		}
+		data, err := helper.DownloadFile(url)
+		if err != nil {
+			b.Log.Errorf("download of custom emoji %s failed: %s", sticker.CustomEmojiID, err)
+			continue
+		}
+		name = b.convertSticker(name, data)
+		helper.HandleDownloadData(b.Log, rmsg, name, sticker.Emoji, "", data, b.General)
+	}
+}
@@ -0,0 +1,53 @@
+package btelegram
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactionText(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"👍", "👍", true},
+		{":thumbsup:", "👍", true},
+		{"❤️", "❤️", true},
+		{" 🔥 ", "🔥", true},
+		{"🦀", "🦀", false},
+		{"<:party_parrot:123456>", ":party_parrot:", false},
+	} {
+		text, ok := reactionText(tc.input)
+		assert.Equal(t, tc.expected, text, tc.input)
+		assert.Equal(t, tc.ok, ok, tc.input)
+	}
+}
+
+func TestHandleCustomEmoji(t *testing.T) {
+	stickers := map[string]tgbotapi.Sticker{
+		"1": {CustomEmojiID: "1", Emoji: "😎"},
+		"2": {CustomEmojiID: "2", SetName: "parrots"},
+	}
+
+	// "🤩" is two UTF-16 code units long
+	rmsg := &config.Message{Text: "a 🤩 b 🤩 c"}
+	moved := handleCustomEmoji(rmsg, tgbotapi.MessageEntity{Type: "custom_emoji", Offset: 2, Length: 2, CustomEmojiID: "1"}, 2, stickers)
+	assert.Equal(t, 0, moved)
+	assert.Equal(t, "a 😎 b 🤩 c", rmsg.Text)
+
+	// the placeholder is kept without the base emoji
+	moved = handleCustomEmoji(rmsg, tgbotapi.MessageEntity{Type: "custom_emoji", Offset: 7, Length: 2, CustomEmojiID: "2"}, 7, stickers)
+	assert.Equal(t, 0, moved)
+	assert.Equal(t, "a 😎 b 🤩 c", rmsg.Text)
+
+	rmsg = &config.Message{Text: "a  b"}
+	moved = handleCustomEmoji(rmsg, tgbotapi.MessageEntity{Type: "custom_emoji", Offset: 2, Length: 0, CustomEmojiID: "2"}, 2, stickers)
+	assert.Equal(t, 9, moved)
+	assert.Equal(t, "a :parrots: b", rmsg.Text)
+
+	assert.Equal(t, ":custom_emoji:", customEmojiText("", tgbotapi.Sticker{}))
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf16"
 
 	"github.com/davecgh/go-spew/spew"
@@ -58,19 +59,23 @@ func (b *Btelegram) handleGroups(rmsg *config.Message, message *tgbotapi.Message
 	return b.handleUpdate(rmsg, message, update.Message, update.EditedMessage)
 }
 
-// handleForwarded handles forwarded messages
+// handleForwarded flags forwarded messages with their origin
 func (b *Btelegram) handleForwarded(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.ForwardDate == 0 {
 		return
 	}
 
 	if message.ForwardFromChat != nil && message.ForwardFrom == nil {
-		rmsg.Text = "Forwarded from " + message.ForwardFromChat.Title + ": " + rmsg.Text
+		rmsg.SetForward(config.Forward{From: message.ForwardSignature, Channel: message.ForwardFromChat.Title})
 		return
 	}
 
 	if message.ForwardFrom == nil {
-		rmsg.Text = "Forwarded from " + unknownUser + ": " + rmsg.Text
+		usernameForward := message.ForwardSenderName
+		if usernameForward == "" {
+			usernameForward = unknownUser
+		}
+		rmsg.SetForward(config.Forward{From: usernameForward})
 		return
 	}
 
@@ -93,7 +98,7 @@ func (b *Btelegram) handleForwarded(rmsg *config.Message, message *tgbotapi.Mess
 		usernameForward = unknownUser
 	}
 
-	rmsg.Text = "Forwarded from " + usernameForward + ": " + rmsg.Text
+	rmsg.SetForward(config.Forward{From: usernameForward})
 }
 
 // handleQuoting handles quoting of previous messages
@@ -130,6 +135,11 @@ func (b *Btelegram) handleQuoting(rmsg *config.Message, message *tgbotapi.Messag
 
 // handleUsername handles the correct setting of the username
 func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Message) {
+	// inline bots post in the name of the user, via_bot tells them apart
+	if (message.From != nil && message.From.IsBot) || message.ViaBot != nil {
+		rmsg.MarkBot()
+	}
+
 	if message.From != nil {
 		rmsg.UserID = strconv.FormatInt(message.From.ID, 10)
 		if b.GetBool("UseFirstName") {
@@ -201,7 +211,9 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 			spew.Dump(update.Message)
 		}
 
-		b.handleGroupUpdate(update)
+		if b.handleGroupUpdate(update) {
+			continue
+		}
 
 		var message *tgbotapi.Message
 
@@ -220,11 +232,20 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 
 		// set the ID's from the channel or group message
 		rmsg.ID = strconv.Itoa(message.MessageID)
+		rmsg.Timestamp = message.Time()
 		rmsg.Channel = strconv.FormatInt(message.Chat.ID, 10)
 		if message.IsTopicMessage {
 			rmsg.Channel += "/" + strconv.Itoa(message.MessageThreadID)
 		}
 
+		// flag the messages of chats with an auto-delete timer
+		b.handleAutoDelete(&rmsg, message)
+
+		// handle the control commands sent to the bot
+		if b.handleCommand(&rmsg, message) {
+			continue
+		}
+
 		// preserve threading from telegram reply
 		if message.ReplyToMessage != nil &&
 			// Used to check if the message was a reply to the root topic
@@ -255,7 +276,7 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 			// rmsg.Text = helper.RemoveEmptyNewLines(rmsg.Text)
 			// channels don't have (always?) user information. see #410
 			if message.From != nil {
-				rmsg.Avatar = helper.GetAvatar(b.avatarMap, strconv.FormatInt(message.From.ID, 10), b.General)
+				rmsg.Avatar = b.AvatarURL(strconv.FormatInt(message.From.ID, 10))
 			}
 
 			b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
@@ -265,19 +286,96 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 	}
 }
 
-func (b *Btelegram) handleGroupUpdate(update tgbotapi.Update) {
-	if msg := update.Message; msg != nil {
-		switch {
-		case msg.NewChatMembers != nil:
-			b.handleUserJoin(update)
-		case msg.LeftChatMember != nil:
-			b.handleUserLeave(update)
+// handleAutoDelete flags rmsg as ephemeral when the messages of its chat are
+// deleted automatically. The timer of each chat is fetched once, and updated by
+// the service messages of its changes.
+func (b *Btelegram) handleAutoDelete(rmsg *config.Message, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	if changed := message.MessageAutoDeleteTimerChanged; changed != nil {
+		b.autoDelete[chatID] = changed.MessageAutoDeleteTime
+	}
+	seconds, ok := b.autoDelete[chatID]
+	if !ok {
+		chat, err := b.c.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+		if err != nil {
+			b.Log.Debugf("Could not get the auto-delete timer of %d: %s", chatID, err)
 		}
+		seconds = chat.MessageAutoDeleteTime
+		b.autoDelete[chatID] = seconds
+	}
+	if seconds > 0 {
+		rmsg.MarkEphemeral(time.Duration(seconds) * time.Second)
 	}
 }
 
-func (b *Btelegram) handleUserJoin(update tgbotapi.Update) {
+// handleGroupUpdate relays the service messages of the chats as events, and
+// returns true if message was one.
+func (b *Btelegram) handleGroupUpdate(update tgbotapi.Update) bool {
 	msg := update.Message
+	if msg == nil {
+		msg = update.ChannelPost
+	}
+	if msg == nil {
+		return false
+	}
+	switch {
+	case msg.NewChatMembers != nil:
+		b.handleUserJoin(msg)
+	case msg.LeftChatMember != nil:
+		b.handleUserLeave(msg)
+	default:
+		return b.handleServiceMessage(msg)
+	}
+	return true
+}
+
+// serviceEvent returns the event and text relayed for the title, photo and pin
+// service messages, and the ID of the pinned message.
+func serviceEvent(msg *tgbotapi.Message) (string, string, string, bool) {
+	switch {
+	case msg.NewChatTitle != "":
+		return config.EventTopicChange, "changed the chat title to " + msg.NewChatTitle, "", true
+	case msg.NewChatPhoto != nil:
+		return config.EventTopicChange, "changed the chat photo", "", true
+	case msg.DeleteChatPhoto:
+		return config.EventTopicChange, "removed the chat photo", "", true
+	case msg.PinnedMessage != nil:
+		text := "pinned a message"
+		pinned := msg.PinnedMessage.Text
+		if pinned == "" {
+			pinned = msg.PinnedMessage.Caption
+		}
+		if pinned != "" {
+			text += ": " + pinned
+		}
+		return config.EventMsgPin, text, strconv.Itoa(msg.PinnedMessage.MessageID), true
+	}
+	return "", "", "", false
+}
+
+func (b *Btelegram) handleServiceMessage(msg *tgbotapi.Message) bool {
+	event, text, parentID, ok := serviceEvent(msg)
+	if !ok {
+		return false
+	}
+	rmsg := config.Message{
+		Channel:  strconv.FormatInt(msg.Chat.ID, 10),
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		Event:    event,
+		Text:     text,
+		ParentID: parentID,
+	}
+	if msg.IsTopicMessage {
+		rmsg.Channel += "/" + strconv.Itoa(msg.MessageThreadID)
+	}
+	b.handleUsername(&rmsg, msg)
+	b.Log.Debugf("<= Sending %s from %s on %s to gateway", event, rmsg.Username, b.Account)
+	b.Remote <- rmsg
+	return true
+}
+
+func (b *Btelegram) handleUserJoin(msg *tgbotapi.Message) {
 	for _, user := range msg.NewChatMembers {
 		rmsg := config.Message{
 			UserID:   strconv.FormatInt(user.ID, 10),
@@ -288,12 +386,14 @@ func (b *Btelegram) handleUserJoin(update tgbotapi.Update) {
 			Event:    config.EventJoin,
 			Text:     "joined chat",
 		}
+		if msg.From != nil && msg.From.ID != user.ID {
+			rmsg.Text = "was added by " + msg.From.FirstName
+		}
 		b.Remote <- rmsg
 	}
 }
 
-func (b *Btelegram) handleUserLeave(update tgbotapi.Update) {
-	msg := update.Message
+func (b *Btelegram) handleUserLeave(msg *tgbotapi.Message) {
 	user := msg.LeftChatMember
 
 	rmsg := config.Message{
@@ -305,6 +405,9 @@ func (b *Btelegram) handleUserLeave(update tgbotapi.Update) {
 		Event:    config.EventLeave,
 		Text:     "left chat",
 	}
+	if msg.From != nil && msg.From.ID != user.ID {
+		rmsg.Text = "was removed by " + msg.From.FirstName
+	}
 
 	b.Remote <- rmsg
 }
@@ -323,7 +426,7 @@ func (b *Btelegram) handleDownloadAvatar(userid int64, channel string) {
 		Extra:    make(map[string][]interface{}),
 	}
 
-	if _, ok := b.avatarMap[strconv.FormatInt(userid, 10)]; ok {
+	if _, ok := b.CachedAvatar(strconv.FormatInt(userid, 10)); ok {
 		return
 	}
 
@@ -383,6 +486,20 @@ func (b *Btelegram) maybeConvertWebp(name *string, data *[]byte) {
 	}
 }
 
+// convertSticker converts a sticker with MediaConvertStickers, else its tgs
+// or webp image with the telegram settings, and returns its new name.
+func (b *Btelegram) convertSticker(name string, data *[]byte) string {
+	switch {
+	case b.GetString("MediaConvertStickers") != "":
+		name = b.ConvertSticker(name, data)
+	case strings.HasSuffix(name, ".tgs.webp"):
+		b.maybeConvertTgs(&name, data)
+	case strings.HasSuffix(name, ".webp"):
+		b.maybeConvertWebp(&name, data)
+	}
+	return name
+}
+
 // handleDownloadFile handles file download
 func (b *Btelegram) handleDownload(rmsg *config.Message, message *tgbotapi.Message) error {
 	size := int64(0)
@@ -431,7 +548,9 @@ func (b *Btelegram) handleDownload(rmsg *config.Message, message *tgbotapi.Messa
 		return err
 	}
 
-	if strings.HasSuffix(name, ".tgs.webp") {
+	if message.Sticker != nil {
+		name = b.convertSticker(name, data)
+	} else if strings.HasSuffix(name, ".tgs.webp") {
 		b.maybeConvertTgs(&name, data)
 	} else if strings.HasSuffix(name, ".webp") {
 		b.maybeConvertWebp(&name, data)
@@ -443,6 +562,11 @@ func (b *Btelegram) handleDownload(rmsg *config.Message, message *tgbotapi.Messa
 	}
 
 	helper.HandleDownloadData(b.Log, rmsg, name, message.Caption, "", data, b.General)
+	if message.Voice != nil {
+		helper.MarkVoiceNote(rmsg, message.Voice.Duration*1000, nil)
+	} else if message.Audio != nil {
+		helper.SetDuration(rmsg, message.Audio.Duration*1000)
+	}
 	return nil
 }
 
@@ -497,6 +621,7 @@ func (b *Btelegram) handleEdit(msg *config.Message, chatid int64) (string, error
 		m.ParseMode = tgbotapi.ModeMarkdown
 	case MarkdownV2:
 		b.Log.Debug("Using mode MarkdownV2")
+		m.Text = makeMarkdownV2Nick(msg.Username) + makeMarkdownV2(msg.Text)
 		m.ParseMode = MarkdownV2
 	}
 	if strings.ToLower(b.GetString("MessageFormat")) == HTMLNick {
@@ -504,6 +629,11 @@ func (b *Btelegram) handleEdit(msg *config.Message, chatid int64) (string, error
 		m.ParseMode = tgbotapi.ModeHTML
 	}
 	_, err = b.c.Send(m)
+	if err != nil && m.ParseMode == MarkdownV2 && isParseError(err) {
+		b.Log.Warnf("Telegram rejected the MarkdownV2 edit (%s), sending it as HTML", err)
+		m.Text, m.ParseMode = makeHTMLFallback(msg.Username, msg.Text), tgbotapi.ModeHTML
+		_, err = b.c.Send(m)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -512,6 +642,14 @@ func (b *Btelegram) handleEdit(msg *config.Message, chatid int64) (string, error
 
 // handleUploadFile handles native upload of files
 func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid int, parentID int) (string, error) {
+	// A single voice note is sent as a native voice message, which can't be
+	// part of a media group.
+	if len(msg.Extra["file"]) == 1 {
+		if fi, ok := msg.Extra["file"][0].(config.FileInfo); ok && fi.Voice {
+			return b.sendVoice(msg, &fi, chatid, threadid, parentID)
+		}
+	}
+
 	var media []interface{}
 	equal := true
 	first := true
@@ -556,9 +694,14 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid
 
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
+		data, err := fi.Bytes()
+		if err != nil {
+			b.Log.Errorf("reading %s failed: %s", fi.Name, err)
+			continue
+		}
 		file := tgbotapi.FileBytes{
 			Name:  fi.Name,
-			Bytes: *fi.Data,
+			Bytes: data,
 		}
 
 		if b.GetString("MessageFormat") == HTMLFormat {
@@ -606,6 +749,35 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid
 	return b.sendMediaFiles(msg, chatid, threadid, parentID, media)
 }
 
+// sendVoice sends a voice note with sendVoice, so it shows up with a player
+// instead of as an audio file.
+func (b *Btelegram) sendVoice(msg *config.Message, fi *config.FileInfo, chatid int64, threadid int, parentID int) (string, error) {
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+	voice := tgbotapi.NewVoice(chatid, tgbotapi.FileBytes{
+		Name:  fi.Name,
+		Bytes: data,
+	})
+	voice.MessageThreadID = threadid
+	voice.ReplyToMessageID = parentID
+	voice.Duration = fi.Duration / 1000
+
+	caption := fi.Comment
+	if b.GetString("MessageFormat") == HTMLFormat {
+		caption = makeHTML(html.EscapeString(caption))
+	}
+	voice.Caption, voice.ParseMode = TGGetParseMode(b, msg.Username, caption)
+
+	res, err := b.c.Send(voice)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.Itoa(res.MessageID), nil
+}
+
 func (b *Btelegram) handleQuote(message, quoteNick, quoteMessage string) string {
 	format := b.GetString("quoteformat")
 	if format == "" {
@@ -634,6 +806,9 @@ func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Messa
 	indexMovedBy := 0
 	prevLinkOffset := -1
 
+	stickers := b.getCustomEmoji(message.Entities)
+	b.handleDownloadCustomEmoji(rmsg, stickers)
+
 	for _, e := range message.Entities {
 
 		asRunes := utf16.Encode([]rune(rmsg.Text))
@@ -659,6 +834,10 @@ func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Messa
 			continue
 		}
 
+		if e.Type == "custom_emoji" {
+			indexMovedBy += handleCustomEmoji(rmsg, e, e.Offset+indexMovedBy, stickers)
+		}
+
 		if e.Type == "code" {
 			offset := e.Offset + indexMovedBy
 			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "`" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "`" + string(utf16.Decode(asRunes[offset+e.Length:]))
@@ -686,5 +865,10 @@ func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Messa
 			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "~" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "~" + string(utf16.Decode(asRunes[offset+e.Length:]))
 			indexMovedBy += 2
 		}
+		if e.Type == "spoiler" {
+			offset := e.Offset + indexMovedBy
+			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "||" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "||" + string(utf16.Decode(asRunes[offset+e.Length:]))
+			indexMovedBy += 4
+		}
 	}
 }
@@ -0,0 +1,33 @@
+package btelegram
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceEvent(t *testing.T) {
+	for _, tc := range []struct {
+		msg      *tgbotapi.Message
+		event    string
+		text     string
+		parentID string
+		ok       bool
+	}{
+		{&tgbotapi.Message{NewChatTitle: "Bridged"}, config.EventTopicChange, "changed the chat title to Bridged", "", true},
+		{&tgbotapi.Message{NewChatPhoto: []tgbotapi.PhotoSize{{FileID: "1"}}}, config.EventTopicChange, "changed the chat photo", "", true},
+		{&tgbotapi.Message{DeleteChatPhoto: true}, config.EventTopicChange, "removed the chat photo", "", true},
+		{&tgbotapi.Message{PinnedMessage: &tgbotapi.Message{MessageID: 42, Text: "rules"}}, config.EventMsgPin, "pinned a message: rules", "42", true},
+		{&tgbotapi.Message{PinnedMessage: &tgbotapi.Message{MessageID: 43, Caption: "photo"}}, config.EventMsgPin, "pinned a message: photo", "43", true},
+		{&tgbotapi.Message{PinnedMessage: &tgbotapi.Message{MessageID: 44}}, config.EventMsgPin, "pinned a message", "44", true},
+		{&tgbotapi.Message{Text: "hello"}, "", "", "", false},
+	} {
+		event, text, parentID, ok := serviceEvent(tc.msg)
+		assert.Equal(t, tc.event, event, tc.text)
+		assert.Equal(t, tc.text, text)
+		assert.Equal(t, tc.parentID, parentID, tc.text)
+		assert.Equal(t, tc.ok, ok, tc.text)
+	}
+}
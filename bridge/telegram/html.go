@@ -3,6 +3,7 @@ package btelegram
 import (
 	"bytes"
 
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/russross/blackfriday"
 )
 
@@ -62,6 +63,9 @@ func (options *customHTML) ListItem(out *bytes.Buffer, text []byte, flags int) {
 }
 
 func makeHTML(input string) string {
+	input = helper.ReplaceSpoilers(input, func(spoiler string) string {
+		return "<tg-spoiler>" + spoiler + "</tg-spoiler>"
+	})
 	return string(blackfriday.Markdown([]byte(input),
 		&customHTML{blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML|blackfriday.HTML_SKIP_IMAGES, "", "")},
 		blackfriday.EXTENSION_NO_INTRA_EMPHASIS|
@@ -0,0 +1,269 @@
+package btelegram
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/russross/blackfriday"
+)
+
+// Characters which have to be escaped in MarkdownV2, see
+// https://core.telegram.org/bots/api#markdownv2-style
+var (
+	markdownV2Escaper     = newEscaper("\\_*[]()~`>#+-=|{}.!")
+	markdownV2CodeEscaper = newEscaper("\\`")
+	markdownV2URLEscaper  = newEscaper("\\)")
+)
+
+func newEscaper(chars string) *strings.Replacer {
+	var pairs []string
+	for _, c := range chars {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// markdownV2 renders markdown (the format messages are relayed in) as Telegram
+// MarkdownV2. Everything which isn't markup is escaped, so that text with
+// characters like "_", "*" or "(" is never rejected by Telegram.
+type markdownV2 struct{}
+
+// blockSeparator separates a block from the previous one by an empty line.
+func blockSeparator(out *bytes.Buffer) {
+	if out.Len() == 0 {
+		return
+	}
+	for !bytes.HasSuffix(out.Bytes(), []byte("\n\n")) {
+		out.WriteByte('\n')
+	}
+}
+
+func (*markdownV2) BlockCode(out *bytes.Buffer, text []byte, infoString string) {
+	blockSeparator(out)
+	out.WriteString("```")
+	if lang := strings.Fields(infoString); len(lang) > 0 && isCodeLanguage(lang[0]) {
+		out.WriteString(lang[0])
+	}
+	out.WriteByte('\n')
+	out.WriteString(markdownV2CodeEscaper.Replace(strings.TrimSuffix(string(text), "\n")))
+	out.WriteString("\n```")
+}
+
+// isCodeLanguage returns true if lang can be used as language of a code block
+// without escaping.
+func isCodeLanguage(lang string) bool {
+	for _, r := range lang {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (*markdownV2) BlockQuote(out *bytes.Buffer, text []byte) {
+	blockSeparator(out)
+	lines := strings.Split(strings.Trim(string(text), "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(">" + line)
+	}
+}
+
+func (*markdownV2) BlockHtml(out *bytes.Buffer, text []byte) {
+	blockSeparator(out)
+	out.WriteString(markdownV2Escaper.Replace(strings.TrimRight(string(text), "\n")))
+}
+
+func (r *markdownV2) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	blockSeparator(out)
+	marker := out.Len()
+	out.WriteByte('*')
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteByte('*')
+}
+
+func (*markdownV2) HRule(out *bytes.Buffer) {
+	blockSeparator(out)
+	out.WriteString("\\-\\-\\-")
+}
+
+func (*markdownV2) List(out *bytes.Buffer, text func() bool, flags int) {
+	blockSeparator(out)
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.Truncate(len(bytes.TrimRight(out.Bytes(), "\n")))
+}
+
+func (*markdownV2) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	out.WriteString("\\- ")
+	out.Write(bytes.TrimSpace(text))
+	out.WriteByte('\n')
+}
+
+func (*markdownV2) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	blockSeparator(out)
+	start := out.Len()
+	if !text() || out.Len() == start {
+		out.Truncate(marker)
+	}
+}
+
+func (*markdownV2) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	blockSeparator(out)
+	out.Write(header)
+	out.Write(bytes.TrimRight(body, "\n"))
+}
+
+func (*markdownV2) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteByte('\n')
+}
+
+func (*markdownV2) TableHeaderCell(out *bytes.Buffer, text []byte, flags int) {
+	if out.Len() > 0 {
+		out.WriteString(" \\| ")
+	}
+	out.WriteString("*")
+	out.Write(text)
+	out.WriteString("*")
+}
+
+func (*markdownV2) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	if out.Len() > 0 {
+		out.WriteString(" \\| ")
+	}
+	out.Write(text)
+}
+
+func (*markdownV2) Footnotes(out *bytes.Buffer, text func() bool) {
+	blockSeparator(out)
+	text()
+}
+
+func (*markdownV2) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	out.WriteString("\\[" + markdownV2Escaper.Replace(string(name)) + "\\] ")
+	out.Write(bytes.TrimSpace(text))
+	out.WriteByte('\n')
+}
+
+func (*markdownV2) TitleBlock(out *bytes.Buffer, text []byte) {
+	blockSeparator(out)
+	out.WriteString(markdownV2Escaper.Replace(string(text)))
+}
+
+func (*markdownV2) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	// Telegram detects links and addresses by itself
+	text := string(link)
+	if kind == blackfriday.LINK_TYPE_EMAIL {
+		text = strings.TrimPrefix(text, "mailto:")
+	}
+	out.WriteString(markdownV2Escaper.Replace(text))
+}
+
+func (*markdownV2) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("`" + markdownV2CodeEscaper.Replace(string(text)) + "`")
+}
+
+func (*markdownV2) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*" + string(text) + "*")
+}
+
+func (*markdownV2) Emphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("_" + string(text) + "_")
+}
+
+func (r *markdownV2) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	if len(alt) == 0 {
+		alt = link
+	}
+	r.Link(out, link, title, []byte(markdownV2Escaper.Replace(string(alt))))
+}
+
+func (*markdownV2) LineBreak(out *bytes.Buffer) {
+	out.WriteByte('\n')
+}
+
+func (*markdownV2) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	out.WriteString("[")
+	out.Write(content)
+	out.WriteString("](" + markdownV2URLEscaper.Replace(string(link)) + ")")
+}
+
+func (*markdownV2) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	out.WriteString(markdownV2Escaper.Replace(string(tag)))
+}
+
+func (*markdownV2) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("*_" + string(text) + "_*")
+}
+
+func (*markdownV2) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~" + string(text) + "~")
+}
+
+func (*markdownV2) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("\\[" + markdownV2Escaper.Replace(string(ref)) + "\\]")
+}
+
+func (*markdownV2) Entity(out *bytes.Buffer, entity []byte) {
+	out.WriteString(markdownV2Escaper.Replace(html.UnescapeString(string(entity))))
+}
+
+func (*markdownV2) NormalText(out *bytes.Buffer, text []byte) {
+	out.WriteString(markdownV2Escaper.Replace(string(text)))
+}
+
+func (*markdownV2) DocumentHeader(out *bytes.Buffer) {}
+
+func (*markdownV2) DocumentFooter(out *bytes.Buffer) {}
+
+func (*markdownV2) GetFlags() int {
+	return 0
+}
+
+// makeMarkdownV2 converts a markdown message to MarkdownV2.
+func makeMarkdownV2(input string) string {
+	out := blackfriday.Markdown([]byte(input), &markdownV2{},
+		blackfriday.EXTENSION_NO_INTRA_EMPHASIS|
+			blackfriday.EXTENSION_FENCED_CODE|
+			blackfriday.EXTENSION_STRIKETHROUGH|
+			blackfriday.EXTENSION_SPACE_HEADERS|
+			blackfriday.EXTENSION_BACKSLASH_LINE_BREAK)
+	return markdownV2SpoilerRE.ReplaceAllString(strings.Trim(string(out), "\n"), "||$1||")
+}
+
+// markdownV2SpoilerRE matches the spoilers of the message once escaped, as
+// "|" isn't markup in markdown.
+var markdownV2SpoilerRE = regexp.MustCompile(`(?s)\\\|\\\|(.+?)\\\|\\\|`)
+
+// makeMarkdownV2Nick converts the formatted username of a message, keeping the
+// whitespace separating it from the text.
+func makeMarkdownV2Nick(username string) string {
+	trimmed := strings.TrimRight(username, " \t\n")
+	return makeMarkdownV2(trimmed) + username[len(trimmed):]
+}
+
+// makeHTMLFallback formats a message as HTML, for MarkdownV2 messages
+// rejected by Telegram.
+func makeHTMLFallback(username string, text string) string {
+	return html.EscapeString(username) + strings.TrimRight(makeHTML(html.EscapeString(text)), "\n")
+}
+
+// isParseError returns true if Telegram rejected the formatting of a message.
+func isParseError(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "can't parse entities")
+}
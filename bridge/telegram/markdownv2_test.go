@@ -0,0 +1,52 @@
+package btelegram
+
+import (
+	"errors"
+	"testing"
+
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeMarkdownV2(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected string
+	}{
+		{"hello world", "hello world"},
+		{"snake_case_name and file_name.txt", "snake\\_case\\_name and file\\_name\\.txt"},
+		{"2*3*4 = 24!", "2\\*3\\*4 \\= 24\\!"},
+		{"(see https://example.com/foo_bar?a=1&b=2)", "\\(see https://example\\.com/foo\\_bar?a\\=1&b\\=2\\)"},
+		{"price: $5.00 (-10%) {x} [y] |z| #tag +1 =2", "price: $5\\.00 \\(\\-10%\\) \\{x\\} \\[y\\] \\|z\\| \\#tag \\+1 \\=2"},
+		{":) :-( ;-)", ":\\) :\\-\\( ;\\-\\)"},
+		{"*unclosed emphasis and _unclosed too", "\\*unclosed emphasis and \\_unclosed too"},
+		{"<b>not html</b> & &amp; \\*escaped\\*", "<b\\>not html</b\\> & & \\*escaped\\*"},
+		{"**bold** and *italic* and _also italic_ and ~~strike~~", "*bold* and _italic_ and _also italic_ and ~strike~"},
+		{"`a_b*c` and ``code with ` tick``", "`a_b*c` and `code with \\` tick`"},
+		{"```go\nfunc main() { fmt.Println(\"`hi`\") }\n```", "```go\nfunc main() { fmt.Println(\"\\`hi\\`\") }\n```"},
+		{"[link](https://example.com/a_(b)) text", "[link](https://example.com/a_(b\\)) text"},
+		{"> quoted text\n> second line", ">quoted text\n>second line"},
+		{"- one\n- two_three", "\\- one\n\\- two\\_three"},
+		{"line one\nline two\n\nnew paragraph", "line one\nline two\n\nnew paragraph"},
+		{"# title\n#channel", "*title*\n\n\\#channel"},
+		{"it was ||*Bruce* Willis|| `a || b`", "it was ||_Bruce_ Willis|| `a || b`"},
+		{"", ""},
+	} {
+		assert.Equal(t, tc.expected, makeMarkdownV2(tc.input), tc.input)
+	}
+}
+
+func TestMakeMarkdownV2Nick(t *testing.T) {
+	assert.Equal(t, "\\[irc\\] <bob\\_the\\.builder\\> ", makeMarkdownV2Nick("[irc] <bob_the.builder> "))
+	assert.Equal(t, "*bob*: ", makeMarkdownV2Nick("**bob**: "))
+}
+
+func TestIsParseError(t *testing.T) {
+	assert.True(t, isParseError(&tgbotapi.Error{Code: 400, Message: "Bad Request: can't parse entities: Character '.' is reserved and must be escaped"}))
+	assert.False(t, isParseError(&tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}))
+	assert.False(t, isParseError(errors.New("can't parse entities")))
+}
+
+func TestMakeHTMLSpoilers(t *testing.T) {
+	assert.Equal(t, "it was <tg-spoiler><em>Bruce</em> Willis</tg-spoiler> <code>a || b</code>\n", makeHTML("it was ||*Bruce* Willis|| `a || b`"))
+}
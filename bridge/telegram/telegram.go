@@ -1,11 +1,15 @@
 package btelegram
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html"
 	"log"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
@@ -19,12 +23,19 @@ const (
 	HTMLFormat  = "HTML"
 	HTMLNick    = "htmlnick"
 	MarkdownV2  = "MarkdownV2"
+
+	// maxMediaGroupSize is the maximum number of files in a telegram album
+	maxMediaGroupSize = 10
 )
 
 type Btelegram struct {
 	c *tgbotapi.BotAPI
 	*bridge.Config
-	avatarMap map[string]string // keep cache of userid and avatar sha
+
+	commandsMu sync.Mutex
+	commands   map[string]bool // names of the registered bot commands
+
+	autoDelete map[int64]int // auto-delete timers of the chats, in seconds
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
@@ -38,7 +49,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 			log.Fatalf("Telegram bridge configured to convert .tgs files to '%s', but %s doesn't support it.", tgsConvertFormat, helper.LottieBackend())
 		}
 	}
-	return &Btelegram{Config: cfg, avatarMap: make(map[string]string)}
+	return &Btelegram{Config: cfg, autoDelete: make(map[int64]int)}
 }
 
 func (b *Btelegram) Connect() error {
@@ -61,6 +72,18 @@ func (b *Btelegram) Disconnect() error {
 	return nil
 }
 
+// Alive implements bridge.Heartbeater, the library polls the updates until the
+// bridge stops.
+func (b *Btelegram) Alive() error {
+	return nil
+}
+
+// Ping implements bridge.Heartbeater.
+func (b *Btelegram) Ping(context.Context) error {
+	_, err := b.c.GetMe()
+	return err
+}
+
 func (b *Btelegram) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
@@ -77,6 +100,7 @@ func TGGetParseMode(b *Btelegram, username string, text string) (textout string,
 	}
 	if b.GetString("MessageFormat") == MarkdownV2 {
 		b.Log.Debug("Using mode MarkdownV2")
+		textout = makeMarkdownV2Nick(username) + makeMarkdownV2(text)
 		parsemode = MarkdownV2
 	}
 	if strings.ToLower(b.GetString("MessageFormat")) == HTMLNick {
@@ -131,8 +155,18 @@ func (b *Btelegram) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg)
 	}
 
-	if b.GetString("MessageFormat") == HTMLFormat {
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, chatid)
+	}
+
+	switch b.GetString("MessageFormat") {
+	case HTMLFormat:
 		msg.Text = makeHTML(html.EscapeString(msg.Text))
+	case MarkdownV2:
+	default:
+		// only HTML and MarkdownV2 messages can have spoilers
+		msg.Text = helper.HideSpoilers(msg.Text, b.GetString("SpoilerFormat"))
 	}
 
 	// Delete message
@@ -198,32 +232,59 @@ func (b *Btelegram) sendMessage(chatid int64, topicid int, username, text string
 	m.DisableWebPagePreview = b.GetBool("DisableWebPagePreview")
 
 	res, err := b.c.Send(m)
+	if err != nil && m.ParseMode == MarkdownV2 && isParseError(err) {
+		b.Log.Warnf("Telegram rejected the MarkdownV2 message (%s), sending it as HTML", err)
+		m.Text, m.ParseMode = makeHTMLFallback(username, text), tgbotapi.ModeHTML
+		res, err = b.c.Send(m)
+	}
 	if err != nil {
 		return "", err
 	}
 	return strconv.Itoa(res.MessageID), nil
 }
 
-// sendMediaFiles native upload media files via media group
+// sendMediaFiles native upload media files via media group. Albums are split
+// in groups of maxMediaGroupSize.
 func (b *Btelegram) sendMediaFiles(msg *config.Message, chatid int64, threadid int, parentID int, media []interface{}) (string, error) {
-	if len(media) == 0 {
-		return "", nil
-	}
-	mg := tgbotapi.MediaGroupConfig{
-		BaseChat: tgbotapi.BaseChat{
-			ChatID:           chatid,
-			MessageThreadID:  threadid,
-			ChannelUsername:  msg.Username,
-			ReplyToMessageID: parentID,
-		},
-		Media: media,
-	}
-	messages, err := b.c.SendMediaGroup(mg)
-	if err != nil {
-		return "", err
+	var firstID string
+	for len(media) > 0 {
+		n := min(len(media), maxMediaGroupSize)
+		mg := tgbotapi.MediaGroupConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:           chatid,
+				MessageThreadID:  threadid,
+				ChannelUsername:  msg.Username,
+				ReplyToMessageID: parentID,
+			},
+			Media: media[:n],
+		}
+		media = media[n:]
+
+		messages, err := b.c.SendMediaGroup(mg)
+		if err != nil {
+			return firstID, err
+		}
+		// return first message id
+		if firstID == "" && len(messages) > 0 {
+			firstID = strconv.Itoa(messages[0].MessageID)
+		}
+	}
+	return firstID, nil
+}
+
+// ClassifyError wraps the errors of the Bot API with their bridge.ErrorClass.
+func (b *Btelegram) ClassifyError(err error) error {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if apiErr.RetryAfter > 0 {
+		return bridge.NewRateLimitError(err, time.Duration(apiErr.RetryAfter)*time.Second)
+	}
+	if class := bridge.ClassifyStatusCode(apiErr.Code); class != bridge.ErrorUnknown {
+		return bridge.NewError(class, err)
 	}
-	// return first message id
-	return strconv.Itoa(messages[0].MessageID), nil
+	return err
 }
 
 // intParentID return integer parent id for telegram message
@@ -240,8 +301,7 @@ func (b *Btelegram) cacheAvatar(msg *config.Message) (string, error) {
 	/* if we have a sha we have successfully uploaded the file to the media server,
 	so we can now cache the sha */
 	if fi.SHA != "" {
-		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		b.CacheAvatar(msg.UserID, fi.SHA)
 	}
 	return "", nil
 }
@@ -1,8 +1,8 @@
 package bvk
 
 import (
-	"bytes"
 	"context"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -28,6 +28,8 @@ const (
 	wall         = "wall"
 )
 
+var photoRE = regexp.MustCompile(".(jpg|jpe|png)$")
+
 type user struct {
 	lastname, firstname, avatar string
 }
@@ -81,6 +83,40 @@ func (b *Bvk) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// messageID returns the ID of a message for the gateway: the
+// conversation_message_id of VK is only unique in its conversation, so it is
+// prefixed by the peer_id, eg. "2000000001_42".
+func messageID(peerID int, conversationMessageID int) string {
+	return strconv.Itoa(peerID) + "_" + strconv.Itoa(conversationMessageID)
+}
+
+// parseMessageID returns the conversation_message_id of a message ID of
+// peerID. The bare IDs of older versions are taken as is.
+func parseMessageID(peerID int, id string) (int, error) {
+	peer, cmid, found := strings.Cut(id, "_")
+	if !found {
+		return strconv.Atoi(id)
+	}
+	if peer != strconv.Itoa(peerID) {
+		return 0, fmt.Errorf("message %s isn't in conversation %d", id, peerID)
+	}
+	return strconv.Atoi(cmid)
+}
+
+// replyForward returns the forward parameter replying to the message parentID
+// of peerID.
+func replyForward(peerID int, parentID string) (string, error) {
+	cmid, err := parseMessageID(peerID, parentID)
+	if err != nil {
+		return "", err
+	}
+	return object.MessagesForward{
+		PeerID:                 peerID,
+		ConversationMessageIDs: []int{cmid},
+		IsReply:                true,
+	}.ToJSON(), nil
+}
+
 func (b *Bvk) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
@@ -91,6 +127,11 @@ func (b *Bvk) Send(msg config.Message) (string, error) {
 
 	params := api.Params{}
 
+	if msg.ParentNotFound() {
+		msg.ParentID = ""
+		msg.Text = "[reply]: " + msg.Text
+	}
+
 	text := msg.Username + msg.Text
 
 	if msg.Extra != nil {
@@ -109,21 +150,30 @@ func (b *Bvk) Send(msg config.Message) (string, error) {
 		params["random_id"] = time.Now().Unix()
 		params["peer_ids"] = msg.Channel
 
-		res, e := b.c.MessagesSendPeerIDs(params)
-		if e != nil {
+		if msg.ParentValid() {
+			forward, err := replyForward(peerID, msg.ParentID)
+			if err != nil {
+				b.Log.WithError(err).Debugf("Not replying to %s", msg.ParentID)
+			} else {
+				params["forward"] = forward
+			}
+		}
+
+		res, err := b.c.MessagesSendPeerIDs(params)
+		if err != nil {
 			return "", err
 		}
 
-		return strconv.Itoa(res[0].ConversationMessageID), nil
+		return messageID(peerID, res[0].ConversationMessageID), nil
 	}
 	// Edit message
-	messageID, err := strconv.ParseInt(msg.ID, 10, 64)
+	cmid, err := parseMessageID(peerID, msg.ID)
 	if err != nil {
 		return "", err
 	}
 
 	params["peer_id"] = peerID
-	params["conversation_message_id"] = messageID
+	params["conversation_message_id"] = cmid
 
 	_, err = b.c.MessagesEdit(params)
 	if err != nil {
@@ -172,17 +222,22 @@ func (b *Bvk) handleMessage(msg object.MessagesMessage, isFwd bool) {
 		Channel:  strconv.Itoa(msg.PeerID),
 		Account:  b.Account,
 		UserID:   strconv.Itoa(msg.FromID),
-		ID:       strconv.Itoa(msg.ConversationMessageID),
+		ID:       messageID(msg.PeerID, msg.ConversationMessageID),
 		Extra:    make(map[string][]interface{}),
 	}
 
 	if msg.ReplyMessage != nil {
 		ur := b.getUser(msg.ReplyMessage.FromID)
 		rmsg.Text = "Re: " + ur.firstname + " " + ur.lastname + "\n" + rmsg.Text
+		rmsg.ParentID = messageID(msg.PeerID, msg.ReplyMessage.ConversationMessageID)
 	}
 
 	if isFwd {
 		rmsg.Username = "Fwd: " + rmsg.Username
+		// the IDs of the forwarded messages are of the conversation they
+		// come from
+		rmsg.ID = ""
+		rmsg.ParentID = ""
 	}
 
 	if len(msg.Attachments) > 0 {
@@ -233,9 +288,12 @@ func (b *Bvk) uploadFiles(extra map[string][]interface{}, peerID int) (string, s
 }
 
 func (b *Bvk) uploadFile(file config.FileInfo, peerID int) (string, error) {
-	r := bytes.NewReader(*file.Data)
+	r, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
 
-	photoRE := regexp.MustCompile(".(jpg|jpe|png)$")
 	if photoRE.MatchString(file.Name) {
 		// BUG(VK): for community chat peerID=0
 		p, err := b.c.UploadMessagesPhoto(0, r)
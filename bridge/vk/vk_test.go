@@ -0,0 +1,32 @@
+package bvk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageID(t *testing.T) {
+	assert.Equal(t, "2000000001_42", messageID(2000000001, 42))
+
+	cmid, err := parseMessageID(2000000001, "2000000001_42")
+	require.NoError(t, err)
+	assert.Equal(t, 42, cmid)
+
+	cmid, err = parseMessageID(2000000001, "42")
+	require.NoError(t, err)
+	assert.Equal(t, 42, cmid)
+
+	_, err = parseMessageID(2000000001, "2000000002_42")
+	assert.Error(t, err)
+}
+
+func TestReplyForward(t *testing.T) {
+	forward, err := replyForward(2000000001, "2000000001_42")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"peer_id":2000000001,"conversation_message_ids":[42],"is_reply":true}`, forward)
+
+	_, err = replyForward(2000000001, "2000000002_42")
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package bwhatsapp
+
+import (
+	"context"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// contact returns the contact of the user jid, a phone number or a LID, from
+// the cache or from the contact store of the session database, where whatsmeow
+// keeps the names of the address book and the push names across restarts.
+func (b *Bwhatsapp) contact(jid types.JID) types.ContactInfo {
+	jid = jid.ToNonAD()
+	b.contactsMu.RLock()
+	contact, ok := b.contacts[jid]
+	b.contactsMu.RUnlock()
+	if ok || b.wc == nil || b.wc.Store.Contacts == nil {
+		return contact
+	}
+
+	contact, err := b.wc.Store.Contacts.GetContact(context.Background(), jid)
+	if err != nil {
+		b.Log.Errorf("Getting the contact %s failed: %s", jid, err)
+		return contact
+	}
+	b.contactsMu.Lock()
+	b.contacts[jid] = contact
+	b.contactsMu.Unlock()
+	return contact
+}
+
+// userName returns the name of the user jid, whose alternative address (the
+// phone number of a LID, or the other way round) is alt when known: the name
+// of the address book, the push name sent with their message or the one seen
+// last, or their first name. The users without a name are fallback, or their
+// masked phone number with MaskPhoneNumbers, their phone number is never shown.
+func (b *Bwhatsapp) userName(jid types.JID, alt types.JID, pushName string, fallback string) string {
+	contact := b.contact(jid)
+	if !alt.IsEmpty() && contact.FullName == "" {
+		if other := b.contact(alt); other.Found {
+			contact = other
+		}
+	}
+
+	switch {
+	case contact.FullName != "":
+		return contact.FullName
+	case pushName != "":
+		return pushName
+	case contact.PushName != "":
+		return contact.PushName
+	case contact.FirstName != "":
+		return contact.FirstName
+	case contact.BusinessName != "":
+		return contact.BusinessName
+	}
+
+	if b.GetBool("MaskPhoneNumbers") {
+		for _, user := range []types.JID{jid, alt} {
+			if user.Server == types.DefaultUserServer {
+				return maskPhoneNumber(user.User)
+			}
+		}
+		if contact.RedactedPhone != "" {
+			return contact.RedactedPhone
+		}
+	}
+	return fallback
+}
+
+// getSenderName returns the name of the sender of the message info.
+func (b *Bwhatsapp) getSenderName(info types.MessageInfo) string {
+	return b.userName(info.Sender, info.SenderAlt, info.PushName, "Someone")
+}
+
+// getSenderNameFromJID returns the name of the user senderJid, for the group
+// events.
+func (b *Bwhatsapp) getSenderNameFromJID(senderJid types.JID) string {
+	return b.userName(senderJid, types.JID{}, "", "Someone")
+}
+
+// getSenderNotify returns the name replacing the mention of senderJid, whose
+// phone number isn't relayed to the other bridges.
+func (b *Bwhatsapp) getSenderNotify(senderJid types.JID) string {
+	return b.userName(senderJid, types.JID{}, "", "someone")
+}
+
+// updateContact refreshes the cached contact of the user of a push name or
+// address book change.
+func (b *Bwhatsapp) updateContact(evt interface{}) {
+	b.contactsMu.Lock()
+	defer b.contactsMu.Unlock()
+
+	switch e := evt.(type) {
+	case *events.PushName:
+		for _, jid := range []types.JID{e.JID, e.JIDAlt} {
+			if jid.IsEmpty() {
+				continue
+			}
+			jid = jid.ToNonAD()
+			contact := b.contacts[jid]
+			contact.Found = true
+			contact.PushName = e.NewPushName
+			b.contacts[jid] = contact
+		}
+	case *events.Contact:
+		// the contact is read again from the store, updated by whatsmeow
+		delete(b.contacts, e.JID.ToNonAD())
+	}
+}
+
+// maskPhoneNumber returns the international phone number without its middle
+// digits, eg. +49•••1234.
+func maskPhoneNumber(number string) string {
+	number = strings.TrimPrefix(number, "+")
+	if len(number) < 7 {
+		return "+•••"
+	}
+	return "+" + number[:2] + "•••" + number[len(number)-4:]
+}
@@ -0,0 +1,43 @@
+package bwhatsapp
+
+import (
+	"io"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestUserName(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[whatsapp.test]\n"))
+	b := &Bwhatsapp{
+		Config:   &bridge.Config{Bridge: &bridge.Bridge{Account: "whatsapp.test", Config: cfg, Log: logrus.NewEntry(logger)}},
+		contacts: make(map[types.JID]types.ContactInfo),
+	}
+	alice := types.NewJID("491701234567", types.DefaultUserServer)
+	aliceLID := types.NewJID("1234", types.HiddenUserServer)
+	bob := types.NewJID("491709876543", types.DefaultUserServer)
+	b.contacts[alice] = types.ContactInfo{Found: true, FullName: "Alice Liddell", PushName: "alice"}
+
+	assert.Equal(t, "Alice Liddell", b.getSenderName(types.MessageInfo{MessageSource: types.MessageSource{Sender: aliceLID, SenderAlt: alice}}))
+	assert.Equal(t, "bobby", b.getSenderName(types.MessageInfo{MessageSource: types.MessageSource{Sender: bob}, PushName: "bobby"}))
+	assert.Equal(t, "Someone", b.getSenderNameFromJID(bob))
+
+	// the push names seen last are kept
+	b.updateContact(&events.PushName{JID: bob, NewPushName: "bob"})
+	assert.Equal(t, "bob", b.getSenderNotify(bob))
+
+	// the phone numbers are masked
+	b.SetBool("MaskPhoneNumbers", true)
+	carol := types.NewJID("441234567890", types.DefaultUserServer)
+	assert.Equal(t, "+44•••7890", b.getSenderNameFromJID(carol))
+	b.contacts[types.NewJID("5678", types.HiddenUserServer)] = types.ContactInfo{Found: true, RedactedPhone: "+1∙∙∙∙∙∙∙∙80"}
+	assert.Equal(t, "+1∙∙∙∙∙∙∙∙80", b.getSenderNameFromJID(types.NewJID("5678", types.HiddenUserServer)))
+	assert.Equal(t, "+•••", maskPhoneNumber("+123"))
+}
@@ -0,0 +1,152 @@
+package bwhatsapp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// setGroups caches the subjects of the joined groups.
+func (b *Bwhatsapp) setGroups(groups []*types.GroupInfo) {
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+
+	b.groupSubjects = make(map[types.JID]string, len(groups))
+	for _, group := range groups {
+		b.groupSubjects[group.JID] = group.Name
+	}
+}
+
+// updateGroupSubject records the new subject of a group, when it is renamed or
+// when we join it.
+func (b *Bwhatsapp) updateGroupSubject(jid types.JID, subject string) {
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+
+	old, known := b.groupSubjects[jid]
+	b.groupSubjects[jid] = subject
+	if alias, ok := b.channelAliases[jid]; ok && known && old != subject {
+		b.Log.Warnf("Group %q (%s) configured as channel=%q was renamed to %q, it is still bridged but you may want to update your configuration", old, jid, alias, subject)
+	}
+}
+
+// findGroups returns the JIDs of the joined groups named subject.
+func (b *Bwhatsapp) findGroups(subject string) []types.JID {
+	b.groupsMu.RLock()
+	defer b.groupsMu.RUnlock()
+
+	var found []types.JID
+	for jid, name := range b.groupSubjects {
+		if name == subject {
+			found = append(found, jid)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].String() < found[j].String()
+	})
+	return found
+}
+
+// joinGroup checks that we are member of the group configured as channel, by
+// JID or by subject. Groups configured by subject are aliased to their JID.
+func (b *Bwhatsapp) joinGroup(channel string) error {
+	if isGroupJid(channel) {
+		jid, err := types.ParseJID(channel)
+		if err != nil {
+			return err
+		}
+		b.groupsMu.RLock()
+		_, ok := b.groupSubjects[jid]
+		b.groupsMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("we are not a member of the group %s, the groups we are member of are: %s", channel, b.groupList())
+		}
+		return nil
+	}
+
+	found := b.findGroups(channel)
+	switch len(found) {
+	case 0:
+		return fmt.Errorf("no group named %q, the groups we are member of are: %s", channel, b.groupList())
+	case 1:
+		b.groupsMu.Lock()
+		b.channelAliases[found[0]] = channel
+		b.groupsMu.Unlock()
+		b.Log.Infof("Group %q is %s", channel, found[0])
+		return nil
+	default:
+		jids := make([]string, 0, len(found))
+		for _, jid := range found {
+			jids = append(jids, jid.String())
+		}
+		return fmt.Errorf("there is more than one group named %q, please specify one of their JIDs as channel: %s", channel, strings.Join(jids, ", "))
+	}
+}
+
+// groupList returns the joined groups as `"subject" (JID)`, for error messages.
+func (b *Bwhatsapp) groupList() string {
+	b.groupsMu.RLock()
+	defer b.groupsMu.RUnlock()
+
+	groups := make([]string, 0, len(b.groupSubjects))
+	for jid, name := range b.groupSubjects {
+		groups = append(groups, fmt.Sprintf("%q (%s)", name, jid))
+	}
+	sort.Strings(groups)
+	return strings.Join(groups, ", ")
+}
+
+// channelName returns the channel of a chat for the gateway: the subject it is
+// configured with, or its JID. A warning with the configuration to use is
+// logged the first time an unconfigured group is seen.
+func (b *Bwhatsapp) channelName(jid types.JID) string {
+	b.groupsMu.RLock()
+	alias, ok := b.channelAliases[jid]
+	b.groupsMu.RUnlock()
+	if ok {
+		return alias
+	}
+
+	if jid.Server == types.GroupServer {
+		b.warnUnmappedGroup(jid)
+	}
+	return jid.String()
+}
+
+func (b *Bwhatsapp) warnUnmappedGroup(jid types.JID) {
+	b.RLock()
+	for _, channel := range b.Channels {
+		if channel.Name == jid.String() {
+			b.RUnlock()
+			return
+		}
+	}
+	b.RUnlock()
+
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+
+	if b.warnedGroups[jid] {
+		return
+	}
+	b.warnedGroups[jid] = true
+	b.Log.Warnf("Group %q (%s) is not bridged, to bridge it add to a gateway: [[gateway.inout]] account=%q channel=%q",
+		b.groupSubjects[jid], jid, b.Account, jid.String())
+}
+
+// groupJID returns the JID of a channel configured by subject or JID.
+func (b *Bwhatsapp) groupJID(channel string) types.JID {
+	b.groupsMu.RLock()
+	for jid, alias := range b.channelAliases {
+		if alias == channel {
+			b.groupsMu.RUnlock()
+			return jid
+		}
+	}
+	b.groupsMu.RUnlock()
+
+	jid, _ := types.ParseJID(channel)
+	return jid
+}
@@ -0,0 +1,91 @@
+package bwhatsapp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var (
+	familyJID  = types.NewJID("48111222333-1549986983", types.GroupServer)
+	friendsJID = types.NewJID("48111222333-1549986984", types.GroupServer)
+	twinJID    = types.NewJID("48111222333-1549986985", types.GroupServer)
+)
+
+func newGroupsBwhatsapp() (*Bwhatsapp, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	b := &Bwhatsapp{
+		Config: &bridge.Config{Bridge: &bridge.Bridge{
+			Account:  "whatsapp.test",
+			Log:      logrus.NewEntry(logger),
+			RWMutex:  new(sync.RWMutex),
+			Channels: make(map[string]config.ChannelInfo),
+		}},
+		groupSubjects:  make(map[types.JID]string),
+		channelAliases: make(map[types.JID]string),
+		warnedGroups:   make(map[types.JID]bool),
+	}
+	b.setGroups([]*types.GroupInfo{
+		{JID: familyJID, GroupName: types.GroupName{Name: "Family Chat"}},
+		{JID: friendsJID, GroupName: types.GroupName{Name: "Friends"}},
+		{JID: twinJID, GroupName: types.GroupName{Name: "Friends"}},
+	})
+	return b, hook
+}
+
+func TestJoinGroup(t *testing.T) {
+	b, _ := newGroupsBwhatsapp()
+
+	require.NoError(t, b.joinGroup(familyJID.String()))
+	assert.Error(t, b.joinGroup("48111222333-1@g.us"))
+
+	require.NoError(t, b.joinGroup("Family Chat"))
+	assert.Equal(t, "Family Chat", b.channelName(familyJID))
+	assert.Equal(t, familyJID, b.groupJID("Family Chat"))
+
+	err := b.joinGroup("Friends")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), friendsJID.String())
+	assert.Contains(t, err.Error(), twinJID.String())
+
+	err = b.joinGroup("Colleagues")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"Family Chat" (`+familyJID.String()+")")
+}
+
+func TestGroupRenamed(t *testing.T) {
+	b, hook := newGroupsBwhatsapp()
+
+	require.NoError(t, b.joinGroup("Family Chat"))
+	b.updateGroupSubject(familyJID, "Family")
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+
+	// The group keeps being bridged under the configured subject
+	assert.Equal(t, "Family Chat", b.channelName(familyJID))
+	assert.Equal(t, familyJID, b.groupJID("Family Chat"))
+	assert.Equal(t, []types.JID{familyJID}, b.findGroups("Family"))
+}
+
+func TestChannelName(t *testing.T) {
+	b, hook := newGroupsBwhatsapp()
+	b.Channels[familyJID.String()+b.Account] = config.ChannelInfo{Name: familyJID.String()}
+
+	// Configured by JID
+	assert.Equal(t, familyJID.String(), b.channelName(familyJID))
+	assert.Empty(t, hook.AllEntries())
+
+	// Unmapped groups are warned about once
+	assert.Equal(t, friendsJID.String(), b.channelName(friendsJID))
+	assert.Equal(t, friendsJID.String(), b.channelName(friendsJID))
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Contains(t, hook.LastEntry().Message, `channel="`+friendsJID.String()+`"`)
+
+	assert.Equal(t, friendsJID, b.groupJID(friendsJID.String()))
+}
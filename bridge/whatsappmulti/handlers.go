@@ -21,12 +21,20 @@ func (b *Bwhatsapp) eventHandler(evt interface{}) {
 		b.handleMessage(e)
 	case *events.GroupInfo:
 		b.handleGroupInfo(e)
+	case *events.JoinedGroup:
+		b.updateGroupSubject(e.JID, e.Name)
+	case *events.PushName, *events.Contact:
+		b.updateContact(e)
 	}
 }
 
 func (b *Bwhatsapp) handleGroupInfo(event *events.GroupInfo) {
 	b.Log.Debugf("Receiving event %#v", event)
 
+	if event.Name != nil {
+		b.updateGroupSubject(event.JID, event.Name.Name)
+	}
+
 	switch {
 	case event.Join != nil:
 		b.handleUserJoin(event)
@@ -44,7 +52,7 @@ func (b *Bwhatsapp) handleUserJoin(event *events.GroupInfo) {
 		rmsg := config.Message{
 			UserID:   joinedJid.String(),
 			Username: senderName,
-			Channel:  event.JID.String(),
+			Channel:  b.channelName(event.JID),
 			Account:  b.Account,
 			Protocol: b.Protocol,
 			Event:    config.EventJoin,
@@ -62,7 +70,7 @@ func (b *Bwhatsapp) handleUserLeave(event *events.GroupInfo) {
 		rmsg := config.Message{
 			UserID:   leftJid.String(),
 			Username: senderName,
-			Channel:  event.JID.String(),
+			Channel:  b.channelName(event.JID),
 			Account:  b.Account,
 			Protocol: b.Protocol,
 			Event:    config.EventLeave,
@@ -86,7 +94,7 @@ func (b *Bwhatsapp) handleTopicChange(event *events.GroupInfo) {
 	rmsg := config.Message{
 		UserID:   senderJid.String(),
 		Username: senderName,
-		Channel:  event.JID.String(),
+		Channel:  b.channelName(event.JID),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Event:    config.EventTopicChange,
@@ -107,7 +115,7 @@ func (b *Bwhatsapp) handleMessage(message *events.Message) {
 
 	switch {
 	case msg.Conversation != nil || msg.ExtendedTextMessage != nil:
-		b.handleTextMessage(message.Info, msg)
+		b.handleTextMessage(message)
 	case msg.VideoMessage != nil:
 		b.handleVideoMessage(message)
 	case msg.AudioMessage != nil:
@@ -116,13 +124,16 @@ func (b *Bwhatsapp) handleMessage(message *events.Message) {
 		b.handleDocumentMessage(message)
 	case msg.ImageMessage != nil:
 		b.handleImageMessage(message)
+	case msg.StickerMessage != nil:
+		b.handleStickerMessage(message)
 	case msg.ProtocolMessage != nil && *msg.ProtocolMessage.Type == proto.ProtocolMessage_REVOKE:
 		b.handleDelete(msg.ProtocolMessage)
 	}
 }
 
 // nolint:funlen
-func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.Message) {
+func (b *Bwhatsapp) handleTextMessage(message *events.Message) {
+	messageInfo, msg := message.Info, message.Message
 	senderJID := messageInfo.Sender
 	channel := messageInfo.Chat
 
@@ -153,13 +164,16 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 		if ci.MentionedJID != nil {
 			// handle user mentions
 			for _, mentionedJID := range ci.MentionedJID {
-				numberAndSuffix := strings.SplitN(mentionedJID, "@", 2)
+				jid, err := types.ParseJID(mentionedJID)
+				if err != nil {
+					continue
+				}
 
-				// mentions comes as telephone numbers and we don't want to expose it to other bridges
+				// mentions comes as telephone numbers or LIDs and we don't want to expose it to other bridges
 				// replace it with something more meaninful to others
-				mention := b.getSenderNotify(types.NewJID(numberAndSuffix[0], types.DefaultUserServer))
+				mention := b.getSenderNotify(jid)
 
-				text = strings.Replace(text, "@"+numberAndSuffix[0], "@"+mention, 1)
+				text = strings.Replace(text, "@"+jid.User, "@"+mention, 1)
 			}
 		}
 	}
@@ -174,13 +188,14 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 		UserID:   senderJID.String(),
 		Username: senderName,
 		Text:     text,
-		Channel:  channel.String(),
+		Channel:  b.channelName(channel),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
 		ID:       getMessageIdFormat(senderJID, messageInfo.ID),
 		ParentID: parentID,
 	}
+	markEphemeral(&rmsg, message, msg.GetExtendedTextMessage().GetContextInfo())
 
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
@@ -207,13 +222,14 @@ func (b *Bwhatsapp) handleImageMessage(msg *events.Message) {
 	rmsg := config.Message{
 		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  msg.Info.Chat.String(),
+		Channel:  b.channelName(msg.Info.Chat),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
 		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
 		ParentID: getParentIdFromCtx(ci),
 	}
+	markEphemeral(&rmsg, msg, ci)
 
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
@@ -256,6 +272,57 @@ func (b *Bwhatsapp) handleImageMessage(msg *events.Message) {
 	b.Remote <- rmsg
 }
 
+// HandleStickerMessage downloads stickers, converted according to MediaConvertStickers
+func (b *Bwhatsapp) handleStickerMessage(msg *events.Message) {
+	imsg := msg.Message.GetStickerMessage()
+
+	senderJID := msg.Info.Sender
+	senderName := b.getSenderName(msg.Info)
+	ci := imsg.GetContextInfo()
+
+	if senderJID == (types.JID{}) && ci.Participant != nil {
+		senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
+	}
+
+	rmsg := config.Message{
+		UserID:   senderJID.String(),
+		Username: senderName,
+		Channel:  b.channelName(msg.Info.Chat),
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		Extra:    make(map[string][]interface{}),
+		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
+		ParentID: getParentIdFromCtx(ci),
+	}
+	markEphemeral(&rmsg, msg, ci)
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
+		rmsg.Avatar = avatarURL
+	}
+
+	// WhatsApp stickers are always WebP, animated or not
+	filename := fmt.Sprintf("%v.webp", msg.Info.ID)
+
+	b.Log.Debugf("Trying to download sticker %s with type %s", filename, imsg.GetMimetype())
+
+	data, err := b.wc.Download(context.Background(), imsg)
+	if err != nil {
+		b.Log.Errorf("Download sticker failed: %s", err)
+
+		return
+	}
+
+	filename = b.ConvertSticker(filename, &data)
+
+	// Move file to bridge storage
+	helper.HandleDownloadData(b.Log, &rmsg, filename, "", "", &data, b.General)
+
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+
+	b.Remote <- rmsg
+}
+
 // HandleVideoMessage downloads video messages
 func (b *Bwhatsapp) handleVideoMessage(msg *events.Message) {
 	imsg := msg.Message.GetVideoMessage()
@@ -271,13 +338,14 @@ func (b *Bwhatsapp) handleVideoMessage(msg *events.Message) {
 	rmsg := config.Message{
 		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  msg.Info.Chat.String(),
+		Channel:  b.channelName(msg.Info.Chat),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
 		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
 		ParentID: getParentIdFromCtx(ci),
 	}
+	markEphemeral(&rmsg, msg, ci)
 
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
@@ -337,13 +405,14 @@ func (b *Bwhatsapp) handleAudioMessage(msg *events.Message) {
 	rmsg := config.Message{
 		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  msg.Info.Chat.String(),
+		Channel:  b.channelName(msg.Info.Chat),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
 		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
 		ParentID: getParentIdFromCtx(ci),
 	}
+	markEphemeral(&rmsg, msg, ci)
 
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
@@ -373,6 +442,10 @@ func (b *Bwhatsapp) handleAudioMessage(msg *events.Message) {
 
 	// Move file to bridge storage
 	helper.HandleDownloadData(b.Log, &rmsg, filename, "audio message", "", &data, b.General)
+	if imsg.GetPTT() {
+		// WhatsApp waveforms are 64 samples from 0 to 100
+		helper.MarkVoiceNote(&rmsg, int(imsg.GetSeconds())*1000, helper.VoiceWaveform(imsg.GetWaveform(), 100))
+	}
 
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
@@ -395,13 +468,14 @@ func (b *Bwhatsapp) handleDocumentMessage(msg *events.Message) {
 	rmsg := config.Message{
 		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  msg.Info.Chat.String(),
+		Channel:  b.channelName(msg.Info.Chat),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
 		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
 		ParentID: getParentIdFromCtx(ci),
 	}
+	markEphemeral(&rmsg, msg, ci)
 
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
@@ -436,6 +510,7 @@ func (b *Bwhatsapp) handleDocumentMessage(msg *events.Message) {
 
 func (b *Bwhatsapp) handleDelete(messageInfo *proto.ProtocolMessage) {
 	sender, _ := types.ParseJID(*messageInfo.Key.Participant)
+	channel, _ := types.ParseJID(*messageInfo.Key.RemoteJID)
 
 	rmsg := config.Message{
 		Account:  b.Account,
@@ -443,7 +518,7 @@ func (b *Bwhatsapp) handleDelete(messageInfo *proto.ProtocolMessage) {
 		ID:       getMessageIdFormat(sender, *messageInfo.Key.ID),
 		Event:    config.EventMsgDelete,
 		Text:     config.EventMsgDelete,
-		Channel:  *messageInfo.Key.RemoteJID,
+		Channel:  b.channelName(channel),
 	}
 
 	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
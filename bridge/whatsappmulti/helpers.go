@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge/config"
 	goproto "google.golang.org/protobuf/proto"
 
 	"go.mau.fi/whatsmeow"
@@ -12,6 +14,7 @@ import (
 	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
 )
 
 type ProfilePicInfo struct {
@@ -20,98 +23,6 @@ type ProfilePicInfo struct {
 	Status int16  `json:"status"`
 }
 
-func (b *Bwhatsapp) reloadContacts() {
-	if _, err := b.wc.Store.Contacts.GetAllContacts(context.Background()); err != nil {
-		b.Log.Errorf("error on update of contacts: %v", err)
-	}
-
-	allcontacts, err := b.wc.Store.Contacts.GetAllContacts(context.Background())
-	if err != nil {
-		b.Log.Errorf("error on update of contacts: %v", err)
-	}
-
-	if len(allcontacts) > 0 {
-		b.contacts = allcontacts
-	}
-}
-
-func (b *Bwhatsapp) getSenderName(info types.MessageInfo) string {
-	// Parse AD JID
-	var senderJid types.JID
-	senderJid.User, senderJid.Server = info.Sender.User, info.Sender.Server
-
-	sender, exists := b.contacts[senderJid]
-
-	if !exists || (sender.FullName == "" && sender.FirstName == "") {
-		b.reloadContacts() // Contacts may need to be reloaded
-		sender, exists = b.contacts[senderJid]
-	}
-
-	if exists && sender.FullName != "" {
-		return sender.FullName
-	}
-
-	if info.PushName != "" {
-		return info.PushName
-	}
-
-	if exists && sender.FirstName != "" {
-		return sender.FirstName
-	}
-
-	return "Someone"
-}
-
-func (b *Bwhatsapp) getSenderNameFromJID(senderJid types.JID) string {
-	sender, exists := b.contacts[senderJid]
-
-	if !exists || (sender.FullName == "" && sender.FirstName == "") {
-		b.reloadContacts() // Contacts may need to be reloaded
-		sender, exists = b.contacts[senderJid]
-	}
-
-	if exists && sender.FullName != "" {
-		return sender.FullName
-	}
-
-	if exists && sender.FirstName != "" {
-		return sender.FirstName
-	}
-
-	if sender.PushName != "" {
-		return sender.PushName
-	}
-
-	return "Someone"
-}
-
-func (b *Bwhatsapp) getSenderNotify(senderJid types.JID) string {
-	sender, exists := b.contacts[senderJid]
-
-	if !exists || (sender.FullName == "" && sender.PushName == "" && sender.FirstName == "") {
-		b.reloadContacts() // Contacts may need to be reloaded
-		sender, exists = b.contacts[senderJid]
-	}
-
-	if !exists {
-		return "someone"
-	}
-
-	if exists && sender.FullName != "" {
-		return sender.FullName
-	}
-
-	if exists && sender.PushName != "" {
-		return sender.PushName
-	}
-
-	if exists && sender.FirstName != "" {
-		return sender.FirstName
-	}
-
-	return "someone"
-}
-
 func (b *Bwhatsapp) GetProfilePicThumb(jid string) (*types.ProfilePictureInfo, error) {
 	pjid, _ := types.ParseJID(jid)
 
@@ -205,3 +116,14 @@ func getMessageIdFormat(jid types.JID, messageID string) string {
 	jidStr := fmt.Sprintf("%s@%s", jid.User, jid.Server)
 	return fmt.Sprintf("%s/%s", jidStr, messageID)
 }
+
+// markEphemeral flags rmsg as ephemeral when message is a view once message,
+// or a disappearing message expiring after the time set in its context info ci.
+func markEphemeral(rmsg *config.Message, message *events.Message, ci *proto.ContextInfo) {
+	switch {
+	case message.IsViewOnce:
+		rmsg.MarkEphemeral(0)
+	case message.IsEphemeral || ci.GetExpiration() > 0:
+		rmsg.MarkEphemeral(time.Duration(ci.GetExpiration()) * time.Second)
+	}
+}
@@ -0,0 +1,31 @@
+package bwhatsapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	goproto "google.golang.org/protobuf/proto"
+)
+
+func TestMarkEphemeral(t *testing.T) {
+	ephemeral := func(message *events.Message, ci *proto.ContextInfo) (time.Duration, bool) {
+		rmsg := config.Message{}
+		markEphemeral(&rmsg, message, ci)
+		return rmsg.Ephemeral()
+	}
+
+	_, ok := ephemeral(&events.Message{}, nil)
+	assert.False(t, ok)
+
+	ttl, ok := ephemeral(&events.Message{IsEphemeral: true}, &proto.ContextInfo{Expiration: goproto.Uint32(86400)})
+	assert.True(t, ok)
+	assert.Equal(t, 24*time.Hour, ttl)
+
+	ttl, ok = ephemeral(&events.Message{IsViewOnce: true}, nil)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), ttl)
+}
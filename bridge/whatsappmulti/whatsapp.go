@@ -3,14 +3,15 @@ package bwhatsapp
 import (
 	"context"
 	"errors"
-	"fmt"
 	"mime"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/mdp/qrterminal"
 
 	"go.mau.fi/whatsmeow"
@@ -32,12 +33,21 @@ const (
 type Bwhatsapp struct {
 	*bridge.Config
 
-	startedAt    time.Time
-	wc           *whatsmeow.Client
-	contacts     map[types.JID]types.ContactInfo
-	users        map[string]types.ContactInfo
-	userAvatars  map[string]string
-	joinedGroups []*types.GroupInfo
+	startedAt   time.Time
+	wc          *whatsmeow.Client
+	users       map[string]types.ContactInfo
+	userAvatars map[string]string
+
+	// contacts caches the contacts of the users, see contact
+	contactsMu sync.RWMutex
+	contacts   map[types.JID]types.ContactInfo
+
+	// groupSubjects caches the subjects of the joined groups, channelAliases the
+	// groups configured by subject rather than by JID.
+	groupsMu       sync.RWMutex
+	groupSubjects  map[types.JID]string
+	channelAliases map[types.JID]string
+	warnedGroups   map[types.JID]bool
 }
 
 type Replyable struct {
@@ -58,6 +68,11 @@ func New(cfg *bridge.Config) bridge.Bridger {
 
 		users:       make(map[string]types.ContactInfo),
 		userAvatars: make(map[string]string),
+		contacts:    make(map[types.JID]types.ContactInfo),
+
+		groupSubjects:  make(map[types.JID]string),
+		channelAliases: make(map[types.JID]string),
+		warnedGroups:   make(map[types.JID]bool),
 	}
 
 	return b
@@ -119,20 +134,26 @@ func (b *Bwhatsapp) Connect() error {
 
 	b.Log.Infoln("WhatsApp connection successful")
 
-	b.contacts, err = b.wc.Store.Contacts.GetAllContacts(context.Background())
+	contacts, err := b.wc.Store.Contacts.GetAllContacts(context.Background())
 	if err != nil {
 		return errors.New("failed to get contacts: " + err.Error())
 	}
+	b.contactsMu.Lock()
+	for jid, contact := range contacts {
+		b.contacts[jid] = contact
+	}
+	b.contactsMu.Unlock()
 
-	b.joinedGroups, err = b.wc.GetJoinedGroups(context.Background())
+	groups, err := b.wc.GetJoinedGroups(context.Background())
 	if err != nil {
 		return errors.New("failed to get list of joined groups: " + err.Error())
 	}
+	b.setGroups(groups)
 
 	b.startedAt = time.Now()
 
 	// map all the users
-	for id, contact := range b.contacts {
+	for id, contact := range contacts {
 		if !isGroupJid(id.String()) && id.String() != "status@broadcast" {
 			// it is user
 			b.users[id.String()] = contact
@@ -168,57 +189,27 @@ func (b *Bwhatsapp) Disconnect() error {
 	return nil
 }
 
-// JoinChannel Join a WhatsApp group specified in gateway config as channel='number-id@g.us' or channel='Channel name'
+// JoinChannel Join a WhatsApp group specified in gateway config as channel='number-id@g.us' or channel='Group subject'
 // Required implementation of the Bridger interface
 // https://github.com/42wim/matterbridge/blob/2cfd880cdb0df29771bf8f31df8d990ab897889d/bridge/bridge.go#L11-L16
 func (b *Bwhatsapp) JoinChannel(channel config.ChannelInfo) error {
-	byJid := isGroupJid(channel.Name)
-
-	// verify if we are member of the given group
-	if byJid {
-		gJID, err := types.ParseJID(channel.Name)
-		if err != nil {
-			return err
-		}
-
-		for _, group := range b.joinedGroups {
-			if group.JID == gJID {
-				return nil
-			}
-		}
-	}
-
-	foundGroups := []string{}
-
-	for _, group := range b.joinedGroups {
-		if group.Name == channel.Name {
-			foundGroups = append(foundGroups, group.Name)
-		}
-	}
-
-	switch len(foundGroups) {
-	case 0:
-		// didn't match any group - print out possibilites
-		for _, group := range b.joinedGroups {
-			b.Log.Infof("%s %s", group.JID, group.Name)
-		}
-		return fmt.Errorf("please specify group's JID from the list above instead of the name '%s'", channel.Name)
-	case 1:
-		return fmt.Errorf("group name might change. Please configure gateway with channel=\"%v\" instead of channel=\"%v\"", foundGroups[0], channel.Name)
-	default:
-		return fmt.Errorf("there is more than one group with name '%s'. Please specify one of JIDs as channel name: %v", channel.Name, foundGroups)
-	}
+	return b.joinGroup(channel.Name)
 }
 
 // Post a document message from the bridge to WhatsApp
 func (b *Bwhatsapp) PostDocumentMessage(msg config.Message, filetype string) (string, error) {
-	groupJID, _ := types.ParseJID(msg.Channel)
+	groupJID := b.groupJID(msg.Channel)
 
 	fi := msg.Extra["file"][0].(config.FileInfo)
 
 	caption := msg.Username + fi.Comment
 
-	resp, err := b.wc.Upload(context.Background(), *fi.Data, whatsmeow.MediaDocument)
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.wc.Upload(context.Background(), data, whatsmeow.MediaDocument)
 	if err != nil {
 		return "", err
 	}
@@ -259,7 +250,12 @@ func (b *Bwhatsapp) PostImageMessage(msg config.Message, filetype string) (strin
 
 	caption := msg.Username + fi.Comment
 
-	resp, err := b.wc.Upload(context.Background(), *fi.Data, whatsmeow.MediaImage)
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.wc.Upload(context.Background(), data, whatsmeow.MediaImage)
 	if err != nil {
 		return "", err
 	}
@@ -293,7 +289,12 @@ func (b *Bwhatsapp) PostVideoMessage(msg config.Message, filetype string) (strin
 
 	caption := msg.Username + fi.Comment
 
-	resp, err := b.wc.Upload(context.Background(), *fi.Data, whatsmeow.MediaVideo)
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.wc.Upload(context.Background(), data, whatsmeow.MediaVideo)
 	if err != nil {
 		return "", err
 	}
@@ -323,11 +324,16 @@ func (b *Bwhatsapp) PostVideoMessage(msg config.Message, filetype string) (strin
 
 // Post audio inline
 func (b *Bwhatsapp) PostAudioMessage(msg config.Message, filetype string) (string, error) {
-	groupJID, _ := types.ParseJID(msg.Channel)
+	groupJID := b.groupJID(msg.Channel)
 
 	fi := msg.Extra["file"][0].(config.FileInfo)
 
-	resp, err := b.wc.Upload(context.Background(), *fi.Data, whatsmeow.MediaAudio)
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.wc.Upload(context.Background(), data, whatsmeow.MediaAudio)
 	if err != nil {
 		return "", err
 	}
@@ -349,6 +355,13 @@ func (b *Bwhatsapp) PostAudioMessage(msg config.Message, filetype string) (strin
 		ContextInfo:   ctx,
 	}
 
+	// Voice notes are sent as push-to-talk messages
+	if fi.Voice {
+		message.AudioMessage.PTT = goproto.Bool(true)
+		message.AudioMessage.Seconds = goproto.Uint32(uint32(fi.Duration / 1000)) //nolint:gosec // durations are small and positive
+		message.AudioMessage.Waveform = helper.RawVoiceWaveform(fi.Waveform, 100)
+	}
+
 	b.Log.Debugf("=> Sending %#v as audio", msg)
 
 	ID, err := b.sendMessage(msg, &message)
@@ -365,7 +378,7 @@ func (b *Bwhatsapp) PostAudioMessage(msg config.Message, filetype string) (strin
 
 // Send a message from the bridge to WhatsApp
 func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
-	groupJID, _ := types.ParseJID(msg.Channel)
+	groupJID := b.groupJID(msg.Channel)
 
 	extendedMsgID, _ := b.parseMessageID(msg.ID)
 	msg.ID = extendedMsgID.MessageID
@@ -444,7 +457,7 @@ func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 }
 
 func (b *Bwhatsapp) sendMessage(rmsg config.Message, message *proto.Message) (string, error) {
-	groupJID, _ := types.ParseJID(rmsg.Channel)
+	groupJID := b.groupJID(rmsg.Channel)
 	ID := whatsmeow.GenerateMessageID()
 
 	_, err := b.wc.SendMessage(context.Background(), groupJID, message, whatsmeow.SendRequestExtra{ID: ID})
@@ -0,0 +1,192 @@
+package bxmpp
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the handshake of XEP-0114 is defined with SHA-1
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nsComponent = "jabber:component:accept"
+	nsStream    = "http://etherx.jabber.org/streams"
+)
+
+// component is a connection of matterbridge as an external component of the
+// XMPP server, see https://xmpp.org/extensions/xep-0114.html. It sends the
+// presences of the remote participants of the gateways in the rooms.
+type component struct {
+	conn    net.Conn
+	decoder *xml.Decoder
+	domain  string
+
+	writeMutex sync.Mutex
+}
+
+// componentIQ is an IQ addressed to the component or to its users.
+type componentIQ struct {
+	XMLName xml.Name  `xml:"iq"`
+	ID      string    `xml:"id,attr"`
+	From    string    `xml:"from,attr"`
+	To      string    `xml:"to,attr"`
+	Type    string    `xml:"type,attr"`
+	Ping    *struct{} `xml:"urn:xmpp:ping ping"`
+}
+
+// dialComponent connects to server as the component domain, authenticated
+// with secret.
+func dialComponent(server string, domain string, secret string) (*component, error) {
+	conn, err := net.DialTimeout("tcp", server, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &component{conn: conn, decoder: xml.NewDecoder(conn), domain: domain}
+	if err := c.handshake(secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake opens the stream and authenticates the component.
+func (c *component) handshake(secret string) error {
+	header := fmt.Sprintf("<stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>", nsComponent, nsStream, xmlEscape(c.domain))
+	if err := c.send(header); err != nil {
+		return err
+	}
+
+	se, err := c.nextElement()
+	if err != nil {
+		return fmt.Errorf("opening the stream failed: %w", err)
+	}
+	if se.Name.Space != nsStream || se.Name.Local != "stream" {
+		return fmt.Errorf("unexpected element %s", se.Name.Local)
+	}
+	streamID := attrValue(se, "id")
+	if streamID == "" {
+		return errors.New("the stream has no id")
+	}
+
+	hash := sha1.Sum([]byte(streamID + secret)) //nolint:gosec
+	if err := c.send("<handshake>" + hex.EncodeToString(hash[:]) + "</handshake>"); err != nil {
+		return err
+	}
+	se, err = c.nextElement()
+	if err != nil {
+		return fmt.Errorf("the handshake failed: %w", err)
+	}
+	if se.Name.Local != "handshake" {
+		var streamErr struct {
+			InnerXML string `xml:",innerxml"`
+		}
+		_ = c.decoder.DecodeElement(&streamErr, &se)
+		return fmt.Errorf("the handshake was refused: %s", streamErr.InnerXML)
+	}
+	return c.decoder.Skip()
+}
+
+// nextElement returns the next element of the stream.
+func (c *component) nextElement() (xml.StartElement, error) {
+	for {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, io.EOF
+		}
+	}
+}
+
+// send writes the stanza to the stream.
+func (c *component) send(stanza string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := io.WriteString(c.conn, stanza)
+	return err
+}
+
+// run reads the stanzas addressed to the component until the connection
+// fails. The messages of the rooms are discarded, the pings are answered.
+func (c *component) run(log *logrus.Entry) error {
+	for {
+		se, err := c.nextElement()
+		if err != nil {
+			return err
+		}
+		switch se.Name.Local {
+		case "presence":
+			if attrValue(se, "type") == "error" {
+				log.Warnf("The presence of %s in %s was refused", attrValue(se, "to"), attrValue(se, "from"))
+			}
+		case "iq":
+			var iq componentIQ
+			if err := c.decoder.DecodeElement(&iq, &se); err != nil {
+				return err
+			}
+			if err := c.answerIQ(iq); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.decoder.Skip(); err != nil {
+			return err
+		}
+	}
+}
+
+// answerIQ answers the pings, and refuses the other requests.
+func (c *component) answerIQ(iq componentIQ) error {
+	if iq.Type != "get" && iq.Type != "set" {
+		return nil
+	}
+	if iq.Ping != nil {
+		return c.send(fmt.Sprintf("<iq type='result' id='%s' from='%s' to='%s'/>", xmlEscape(iq.ID), xmlEscape(iq.To), xmlEscape(iq.From)))
+	}
+	return c.send(fmt.Sprintf("<iq type='error' id='%s' from='%s' to='%s'><error type='cancel'>"+
+		"<service-unavailable xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>",
+		xmlEscape(iq.ID), xmlEscape(iq.To), xmlEscape(iq.From)))
+}
+
+func (c *component) close() {
+	_ = c.send("</stream:stream>")
+	c.conn.Close()
+}
+
+// joinRoom sends the presence of jid in room as nick.
+func (c *component) joinRoom(jid string, room string, nick string) error {
+	return c.send(fmt.Sprintf("<presence from='%s' to='%s/%s'><x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x></presence>",
+		xmlEscape(jid), xmlEscape(room), xmlEscape(nick)))
+}
+
+// leaveRoom removes jid, present as nick, from room.
+func (c *component) leaveRoom(jid string, room string, nick string) error {
+	return c.send(fmt.Sprintf("<presence from='%s' to='%s/%s' type='unavailable'/>", xmlEscape(jid), xmlEscape(room), xmlEscape(nick)))
+}
+
+func attrValue(se xml.StartElement, name string) string {
+	for _, attr := range se.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
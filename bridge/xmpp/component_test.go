@@ -0,0 +1,124 @@
+package bxmpp
+
+import (
+	"bufio"
+	"encoding/xml"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeComponentServer accepts one component connection and records the
+// stanzas it receives.
+func fakeComponentServer(t *testing.T, handshake string) (string, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	stanzas := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// the stream header
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		conn.Write([]byte("<stream:stream xmlns='jabber:component:accept' xmlns:stream='http://etherx.jabber.org/streams' from='bridge.example.com' id='3BF96D32'>"))
+		// <handshake>hash</handshake>
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		hash, err := r.ReadString('<')
+		if err != nil {
+			return
+		}
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		stanzas <- strings.TrimSuffix(hash, "<")
+		conn.Write([]byte(handshake))
+
+		for {
+			stanza, err := r.ReadString('\n')
+			if strings.TrimSpace(stanza) != "" {
+				stanzas <- stanza
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), stanzas
+}
+
+func TestComponentHandshake(t *testing.T) {
+	server, stanzas := fakeComponentServer(t, "<handshake/>")
+	c, err := dialComponent(server, "bridge.example.com", "secret")
+	require.NoError(t, err)
+	defer c.close()
+	// sha1("3BF96D32secret")
+	assert.Equal(t, "b09ea9b3b7f586be8a08d0a3dd7466f110aeb136", <-stanzas)
+
+	server, _ = fakeComponentServer(t, "<stream:error><not-authorized xmlns='urn:ietf:params:xml:ns:xmpp-streams'/></stream:error>")
+	_, err = dialComponent(server, "bridge.example.com", "wrong")
+	assert.ErrorContains(t, err, "not-authorized")
+}
+
+func TestUpdatePresence(t *testing.T) {
+	b := newTestXMPP("Component=\"bridge.example.com\"\nMuc=\"muc.example.com\"\n")
+	client, server := net.Pipe()
+	defer client.Close()
+	b.component = &component{conn: client, decoder: xml.NewDecoder(client), domain: "bridge.example.com"}
+	stanzas := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			stanzas <- string(buf[:n])
+		}
+	}()
+
+	msg := config.Message{Text: "hi", Username: "alice ", UserID: "1234", Account: "discord.test", Channel: "room"}
+	assert.False(t, b.updatePresence(msg))
+	join := <-stanzas
+	assert.Contains(t, join, "to='room@muc.example.com/alice'")
+	assert.Contains(t, join, "@bridge.example.com/matterbridge")
+
+	// already present
+	assert.False(t, b.updatePresence(msg))
+	msg.Username = "alicia"
+	assert.False(t, b.updatePresence(msg))
+	assert.Contains(t, <-stanzas, "to='room@muc.example.com/alicia'")
+
+	msg.Event = config.EventLeave
+	assert.True(t, b.updatePresence(msg))
+	leave := <-stanzas
+	assert.Contains(t, leave, "to='room@muc.example.com/alicia'")
+	assert.Contains(t, leave, "type='unavailable'")
+	assert.Empty(t, b.puppets["room@muc.example.com"])
+
+	msg.Event = config.EventJoin
+	assert.True(t, b.updatePresence(msg))
+	<-stanzas
+	b.removeIdlePuppets(time.Now().Add(2 * time.Hour))
+	assert.Contains(t, <-stanzas, "type='unavailable'")
+	assert.Empty(t, b.puppets["room@muc.example.com"])
+
+	// the events only change the presences with a component
+	b = newTestXMPP("")
+	msg.Event = config.EventLeave
+	assert.False(t, b.updatePresence(msg))
+}
@@ -0,0 +1,162 @@
+package bxmpp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/rs/xid"
+	"github.com/xmppo/go-xmpp"
+)
+
+// Namespaces of the corrections and retractions of messages, see
+// https://xmpp.org/extensions/xep-0308.html and
+// https://xmpp.org/extensions/xep-0424.html
+const (
+	nsCorrect  = "urn:xmpp:message-correct:0"
+	nsRetract  = "urn:xmpp:message-retract:1"
+	nsFallback = "urn:xmpp:fallback:0"
+	nsOriginID = "urn:xmpp:sid:0"
+	nsHints    = "urn:xmpp:hints"
+)
+
+// messageCacheSize is the number of sent and received messages remembered to
+// correct and retract them.
+const messageCacheSize = 5000
+
+// retractFallback is the body of the retractions, shown by the clients which
+// don't support them.
+const retractFallback = "This person attempted to retract a previous message, but it's unsupported by your client."
+
+// receivedMessage is a message received from a room, which its sender can
+// correct with its origin-id or retract with its stanza-id.
+type receivedMessage struct {
+	stanzaID string
+	sender   string
+}
+
+// recordSentMessage records the origin-id of the message we sent with the
+// stanza-id given by the room, which is its ID in the gateway. The corrections
+// refer to the origin-id.
+func (b *Bxmpp) recordSentMessage(stanzaID string, originID string) {
+	if stanzaID != "" && originID != "" {
+		b.sentMessages.Add(stanzaID, originID)
+	}
+}
+
+// recordReceivedMessage records the message received in room from sender, by
+// its origin-id and its stanza-id.
+func (b *Bxmpp) recordReceivedMessage(room string, v xmpp.Chat, sender string) {
+	if v.StanzaID.ID == "" {
+		return
+	}
+	received := &receivedMessage{stanzaID: v.StanzaID.ID, sender: sender}
+	b.receivedMessages.Add(room+" "+v.StanzaID.ID, received)
+	if v.OriginID != "" {
+		b.receivedMessages.Add(room+" "+v.OriginID, received)
+	}
+}
+
+// receivedMessage returns the message received in room with the origin-id or
+// stanza-id id.
+func (b *Bxmpp) receivedMessage(room string, id string) (*receivedMessage, bool) {
+	received, ok := b.receivedMessages.Get(room + " " + id)
+	if !ok {
+		return nil, false
+	}
+	return received.(*receivedMessage), true
+}
+
+// correctMessage sends msg as the correction (XEP-0308) of the message we sent
+// with the stanza-id msg.ID.
+func (b *Bxmpp) correctMessage(msg config.Message) error {
+	originID := msg.ID
+	if id, ok := b.sentMessages.Get(msg.ID); ok {
+		originID = id.(string)
+	}
+	id := xid.New().String()
+	_, err := b.xc.SendOrg(fmt.Sprintf(
+		"<message to='%s' type='groupchat' id='%s'><body>%s</body><replace id='%s' xmlns='%s'/><origin-id xmlns='%s' id='%s'/></message>",
+		xmlEscape(msg.Channel+"@"+b.GetString("Muc")), id, xmlEscape(msg.Username+msg.Text),
+		xmlEscape(originID), nsCorrect, nsOriginID, id))
+	return err
+}
+
+// retractMessage sends the retraction (XEP-0424) of the message we sent with
+// the stanza-id msg.ID.
+func (b *Bxmpp) retractMessage(msg config.Message) error {
+	id := xid.New().String()
+	_, err := b.xc.SendOrg(fmt.Sprintf(
+		"<message to='%s' type='groupchat' id='%s'><retract id='%s' xmlns='%s'/><fallback xmlns='%s' for='%s'/><body>%s</body><store xmlns='%s'/><origin-id xmlns='%s' id='%s'/></message>",
+		xmlEscape(msg.Channel+"@"+b.GetString("Muc")), id, xmlEscape(msg.ID), nsRetract,
+		nsFallback, nsRetract, retractFallback, nsHints, nsOriginID, id))
+	return err
+}
+
+// handleRetraction relays the retraction of a message received from the room
+// as a delete, and returns true if v is a retraction. Only the sender of a
+// message can retract it.
+func (b *Bxmpp) handleRetraction(v xmpp.Chat) bool {
+	id, ok := elementAttr(v, nsRetract, "retract", "id")
+	if !ok {
+		return false
+	}
+	rnick, rchan := b.parseJID(v.Remote)
+	if rnick == b.GetString("Nick") {
+		return true
+	}
+
+	room, _, _ := strings.Cut(v.Remote, "/")
+	userID, rnick := b.senderIdentity(v, rnick)
+	received, ok := b.receivedMessage(room, id)
+	if !ok || received.sender != userID {
+		b.Log.Debugf("Ignoring the retraction of unknown message %s by %s", id, v.Remote)
+		return true
+	}
+
+	b.Log.Debugf("<= Sending the retraction of %s by %s on %s to gateway", received.stanzaID, rnick, b.Account)
+	b.Remote <- config.Message{
+		Username: rnick,
+		UserID:   userID,
+		Channel:  rchan,
+		Account:  b.Account,
+		ID:       received.stanzaID,
+		Event:    config.EventMsgDelete,
+		Text:     config.EventMsgDelete,
+	}
+	return true
+}
+
+// correctedMessage returns the stanza-id of the message received from sender
+// which v corrects, and whether v is a correction. A correction of an unknown
+// message is relayed as a new message.
+func (b *Bxmpp) correctedMessage(v xmpp.Chat, sender string) (string, bool) {
+	id, ok := elementAttr(v, nsCorrect, "replace", "id")
+	if !ok {
+		return "", false
+	}
+	room, _, _ := strings.Cut(v.Remote, "/")
+	received, ok := b.receivedMessage(room, id)
+	if !ok || received.sender != sender {
+		b.Log.Debugf("Correction of unknown message %s by %s, relaying it as a new message", id, v.Remote)
+		return "", true
+	}
+	return received.stanzaID, true
+}
+
+// elementAttr returns the attribute name of the first element local in the
+// namespace space of v, and whether v has such an element.
+func elementAttr(v xmpp.Chat, space string, local string, name string) (string, bool) {
+	for _, elem := range v.OtherElem {
+		if elem.XMLName.Space != space || elem.XMLName.Local != local {
+			continue
+		}
+		for _, attr := range elem.Attr {
+			if attr.Name.Local == name {
+				return attr.Value, true
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
@@ -0,0 +1,76 @@
+package bxmpp
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmppo/go-xmpp"
+)
+
+func elementChat(remote string, space string, local string, id string) xmpp.Chat {
+	return xmpp.Chat{
+		Remote: remote,
+		Type:   "groupchat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: space, Local: local},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		}},
+	}
+}
+
+func TestCorrectedMessage(t *testing.T) {
+	b := newTestXMPP("")
+	original := xmpp.Chat{Remote: "room@muc/alice", OriginID: "origin1", StanzaID: xmpp.StanzaID{ID: "stanza1"}}
+	b.recordReceivedMessage("room@muc", original, "room@muc/alice")
+
+	id, ok := b.correctedMessage(xmpp.Chat{Remote: "room@muc/alice"}, "room@muc/alice")
+	assert.False(t, ok, "not a correction")
+	assert.Empty(t, id)
+
+	id, ok = b.correctedMessage(elementChat("room@muc/alice", nsCorrect, "replace", "origin1"), "room@muc/alice")
+	assert.True(t, ok)
+	assert.Equal(t, "stanza1", id)
+
+	id, ok = b.correctedMessage(elementChat("room@muc/bob", nsCorrect, "replace", "origin1"), "room@muc/bob")
+	assert.True(t, ok)
+	assert.Empty(t, id, "only the sender corrects a message")
+
+	id, ok = b.correctedMessage(elementChat("other@muc/alice", nsCorrect, "replace", "origin1"), "room@muc/alice")
+	assert.True(t, ok)
+	assert.Empty(t, id, "the messages are looked up in the room")
+}
+
+func TestHandleRetraction(t *testing.T) {
+	b := newTestXMPP("Nick=\"bridge\"\n")
+	b.Remote = make(chan config.Message, 1)
+	original := xmpp.Chat{Remote: "room@muc/alice", OriginID: "origin1", StanzaID: xmpp.StanzaID{ID: "stanza1"}}
+	b.recordReceivedMessage("room@muc", original, "room@muc/alice")
+
+	assert.False(t, b.handleRetraction(xmpp.Chat{Remote: "room@muc/alice", Text: "hello"}))
+
+	assert.True(t, b.handleRetraction(elementChat("room@muc/bob", nsRetract, "retract", "stanza1")))
+	assert.True(t, b.handleRetraction(elementChat("room@muc/bridge", nsRetract, "retract", "stanza1")))
+	assert.Empty(t, b.Remote, "only the sender retracts a message")
+
+	require.True(t, b.handleRetraction(elementChat("room@muc/alice", nsRetract, "retract", "stanza1")))
+	require.Len(t, b.Remote, 1)
+	msg := <-b.Remote
+	assert.Equal(t, config.EventMsgDelete, msg.Event)
+	assert.Equal(t, "stanza1", msg.ID)
+	assert.Equal(t, "room", msg.Channel)
+	assert.Equal(t, "alice", msg.Username)
+}
+
+func TestRecordSentMessage(t *testing.T) {
+	b := newTestXMPP("")
+	b.recordSentMessage("stanza1", "origin1")
+	b.recordSentMessage("stanza2", "")
+
+	origin, ok := b.sentMessages.Get("stanza1")
+	require.True(t, ok)
+	assert.Equal(t, "origin1", origin)
+	assert.False(t, b.sentMessages.Contains("stanza2"))
+}
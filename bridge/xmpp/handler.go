@@ -26,7 +26,7 @@ func (b *Bxmpp) handleDownloadAvatar(avatar xmpp.AvatarData) {
 
 	// TODO: why do we check if the avatar is already set?
 	// Can't we change avatar once set?
-	_, ok := b.avatarMap[avatar.From]
+	_, ok := b.CachedAvatar(avatar.From)
 	if !ok {
 		b.Log.Debugf("Avatar.From: %s", avatar.From)
 		fileName := avatar.From + ".png"
@@ -91,6 +91,7 @@ func (b *Bxmpp) handleUploadFile(msg *config.Message) {
 			// 1. Find the server's HTTP upload component (upon login, in HTTP_UPLOAD_DISCO steps)
 			// 2. Request an "upload slot" from the upload component (we are here)
 			// 3. Send a PUT request with the data to the remote HTTP "upload slot" (when receiving the slot)
+			// 4. Announce the GET URL of the slot with OOB, once the upload succeeded
 			//
 			// Steps 2 and 3 are commented as HTTP_UPLOAD_SLOT
 			fileId := xid.New().String()
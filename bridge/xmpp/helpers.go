@@ -1,36 +1,30 @@
 package bxmpp
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"mime"
+	"net/http"
+	"net/url"
 	"path"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/xmppo/go-xmpp"
 )
 
-var pathRegex = regexp.MustCompile("[^a-zA-Z0-9]+")
-
-// GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
-func getAvatar(av map[string]string, userid string, general *config.Protocol) string {
-	if hash, ok := av[userid]; ok {
-		// NOTE: This does not happen in bridge/helper/helper.go but messes up XMPP
-		id := pathRegex.ReplaceAllString(userid, "_")
-		return general.MediaServerDownload + "/" + hash + "/" + id + ".png"
-	}
-	return ""
-}
+var errInsecureSlot = errors.New("upload slot URL is not HTTPS")
 
 func (b *Bxmpp) cacheAvatar(msg *config.Message) string {
 	fi := msg.Extra["file"][0].(config.FileInfo)
 	/* if we have a sha we have successfully uploaded the file to the media server,
 	so we can now cache the sha */
 	if fi.SHA != "" {
-		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		b.CacheAvatar(msg.UserID, fi.SHA)
 	}
 	return ""
 }
@@ -111,54 +105,159 @@ func (b *Bxmpp) extractMaxSizeFromXFieldValue(value string) int64 {
 // Will stall until the compoennt is advertised by the server, or until a timeout has been reached.
 // This method must therefore be called from a background thread.
 func (b *Bxmpp) requestUploadSlot(fileId string, fileInfo *config.FileInfo, to string, text string, description string) {
-	retry := 0
+	entry := &UploadBufferEntry{
+		FileInfo:    fileInfo,
+		Text:        text,
+		To:          to,
+		Description: description,
+	}
 
-	httpUploadComponent := ""
-	for httpUploadComponent == "" {
-		retry += 1
-		if retry > 6 {
+	var httpUploadComponent string
+	var maxSize int64
+	for retry := 0; ; retry++ {
+		b.Lock()
+		httpUploadComponent = b.httpUploadComponent
+		maxSize = b.httpUploadMaxSize
+		b.Unlock()
+		if httpUploadComponent != "" {
+			break
+		}
+		if retry == 6 {
 			// No need to keep trying, the XMPP server apparently has no HTTP upload
 			// component configured.
 			b.Log.Warn("Abandoning file upload because XMPP server still hasn't advertised an HTTP upload component.")
-			break
+			b.announceFailedUpload(entry, "no upload service")
+			return
 		}
 
-		b.Lock()
-		httpUploadComponent = b.httpUploadComponent
-		b.Unlock()
-
 		// Wait 5 seconds before next attempt
 		time.Sleep(5 * time.Second)
 	}
 
-	reg := regexp.MustCompile(`[^a-zA-Z0-9\+\-\_\.]+`)
-	fileNameEscaped := reg.ReplaceAllString(fileInfo.Name, "_")
+	// The upload component would refuse the slot anyway, but telling it
+	// in the room is more useful than waiting for the error.
+	if maxSize > 0 && fileInfo.DataSize() > maxSize {
+		b.Log.Warnf("Not uploading file %s of %d bytes, the XMPP server accepts at most %d bytes", fileInfo.Name, fileInfo.DataSize(), maxSize)
+		b.announceFailedUpload(entry, fmt.Sprintf("too big, the maximum is %d bytes", maxSize))
+		return
+	}
+
+	fileNameEscaped := helper.SanitizeFileName(fileInfo.Name, "")
 
 	// Guess the mime-type
-	mimeType := mime.TypeByExtension(path.Ext(fileInfo.Name))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	entry.Mime = mime.TypeByExtension(path.Ext(fileInfo.Name))
+	if entry.Mime == "" {
+		entry.Mime = "application/octet-stream"
 	}
 
-	b.Log.Debugf("Requesting upload slot ID %s for %s (escaped) with mime-type %s", fileId, fileNameEscaped, mimeType)
+	b.Log.Debugf("Requesting upload slot ID %s for %s (escaped) with mime-type %s", fileId, fileNameEscaped, entry.Mime)
+
+	// Save the FileInfo in the buffer to actually upload it later
+	// when we receive the upload slot, before the slot may arrive.
+	b.Lock()
+	b.httpUploadBuffer[fileId] = entry
+	b.Unlock()
 
-	request := fmt.Sprintf("<request xmlns='urn:xmpp:http:upload:0' filename='%s' size='%d' content-type='%s' />", fileNameEscaped, fileInfo.Size, mimeType)
+	request := fmt.Sprintf("<request xmlns='urn:xmpp:http:upload:0' filename='%s' size='%d' content-type='%s' />",
+		html.EscapeString(fileNameEscaped), fileInfo.DataSize(), html.EscapeString(entry.Mime))
 
 	_, err := b.xc.RawInformation(b.xc.JID(), httpUploadComponent, fileId, "get", request)
 	if err != nil {
 		b.Log.WithError(err).Warn("Failed to request upload slot")
-		return
+		b.takeUploadEntry(fileId)
+		b.announceFailedUpload(entry, "no upload slot")
 	}
+}
 
-	// Save the FileInfo in the buffer to actually upload it later
-	// when we receive the upload slot.
+// takeUploadEntry removes the file waiting for the upload slot id from the
+// upload buffer and returns it, nil if there is none.
+func (b *Bxmpp) takeUploadEntry(id string) *UploadBufferEntry {
 	b.Lock()
-	b.httpUploadBuffer[fileId] = &UploadBufferEntry{
-		FileInfo:    fileInfo,
-		Mime:        mimeType,
-		Text:        text,
-		To:          to,
-		Description: description,
+	defer b.Unlock()
+
+	entry, ok := b.httpUploadBuffer[id]
+	if !ok {
+		return nil
+	}
+	delete(b.httpUploadBuffer, id)
+	return entry
+}
+
+// HTTP_UPLOAD_SLOT step 2
+//
+// handleUploadSlot uploads the file waiting for slot, then announces its GET
+// URL in the room (HTTP_UPLOAD_SLOT step 3). The upload runs in the
+// background.
+func (b *Bxmpp) handleUploadSlot(slot xmpp.Slot) {
+	b.Log.Debugf("Received upload slot ID %s", slot.ID)
+	entry := b.takeUploadEntry(slot.ID)
+	if entry == nil {
+		b.Log.Warnf("Received upload slot ID %s doesn't match a known file", slot.ID)
+		return
+	}
+
+	go func() {
+		if err := b.uploadToSlot(entry, slot); err != nil {
+			b.Log.WithError(err).Warnf("Failed to upload file %s", entry.FileInfo.Name)
+			b.announceFailedUpload(entry, "upload failed")
+			return
+		}
+		b.announceUploadedFile(entry.To, entry.Text, entry.Description, slot.Get.Url)
+	}()
+}
+
+// handleUploadSlotError gives up the upload of the file whose slot request
+// the upload component refused, eg. because the file is too big.
+func (b *Bxmpp) handleUploadSlotError(v xmpp.IQ) {
+	if v.Type != "error" {
+		return
+	}
+	entry := b.takeUploadEntry(v.ID)
+	if entry == nil {
+		return
+	}
+	b.Log.Warnf("Upload slot for file %s refused by %s: %s", entry.FileInfo.Name, v.From, v.Query)
+	b.announceFailedUpload(entry, "refused by the upload service")
+}
+
+// uploadToSlot sends the data of the file of entry to the PUT URL of slot,
+// with the headers of the slot which XEP-0363 allows.
+func (b *Bxmpp) uploadToSlot(entry *UploadBufferEntry, slot xmpp.Slot) error {
+	for _, uri := range []string{slot.Put.Url, slot.Get.Url} {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme != "https" {
+			return fmt.Errorf("%w: %s", errInsecureSlot, uri)
+		}
+	}
+
+	headers := map[string]string{"Content-Type": entry.Mime}
+	for _, h := range slot.Put.Headers {
+		switch h.Name {
+		case "Authorization", "Cookie", "Expires":
+			b.Log.Debugf("Setting header %s", h.Name)
+			// the values can't contain newlines
+			headers[h.Name] = strings.NewReplacer("\r", "", "\n", "").Replace(html.UnescapeString(h.Value))
+		default:
+			b.Log.Warnf("Ignoring header %s from HTTP upload component", h.Name)
+		}
+	}
+
+	b.Log.Debugf("Uploading file %s to %s", entry.FileInfo.Name, slot.Put.Url)
+	return b.HttpUploadFile(http.MethodPut, slot.Put.Url, headers, *entry.FileInfo, []int{http.StatusOK, http.StatusCreated})
+}
+
+// announceFailedUpload tells the room of entry that its file couldn't be
+// uploaded, so that the message of the sharer isn't lost.
+func (b *Bxmpp) announceFailedUpload(entry *UploadBufferEntry, reason string) {
+	_, err := b.xc.Send(xmpp.Chat{
+		Type:   "groupchat",
+		Remote: entry.To,
+		Text:   fmt.Sprintf("%s (file %s not uploaded: %s)", entry.Text, entry.FileInfo.Name, reason),
+	})
+	if err != nil {
+		b.Log.WithError(err).Warnf("Failed to announce the failed upload of %s", entry.FileInfo.Name)
 	}
-	b.Unlock()
 }
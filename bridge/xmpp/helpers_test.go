@@ -0,0 +1,76 @@
+package bxmpp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmppo/go-xmpp"
+)
+
+func TestUploadToSlot(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	status := http.StatusCreated
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	b := newTestXMPP("")
+	b.Bridger = b
+	b.HttpClient = server.Client()
+
+	data := []byte("hello")
+	entry := &UploadBufferEntry{FileInfo: &config.FileInfo{Name: "hello.txt", Data: &data}, Mime: "text/plain"}
+	slot := xmpp.Slot{
+		ID: "slot1",
+		Put: xmpp.Put{Url: server.URL + "/put/hello.txt", Headers: []xmpp.Header{
+			{Name: "Authorization", Value: "Basic Zm9v\n"},
+			{Name: "Cookie", Value: "foo=bar&amp;baz"},
+			{Name: "X-Tracking", Value: "1"},
+		}},
+		Get: xmpp.Get{Url: server.URL + "/get/hello.txt"},
+	}
+	require.NoError(t, b.uploadToSlot(entry, slot))
+	assert.Equal(t, http.MethodPut, got.Method)
+	assert.Equal(t, "/put/hello.txt", got.URL.Path)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, int64(5), got.ContentLength)
+	assert.Equal(t, "text/plain", got.Header.Get("Content-Type"))
+	assert.Equal(t, "Basic Zm9v", got.Header.Get("Authorization"))
+	assert.Equal(t, "foo=bar&baz", got.Header.Get("Cookie"))
+	assert.Empty(t, got.Header.Get("X-Tracking"))
+
+	// the upload fails when the server refuses it
+	status = http.StatusForbidden
+	assert.Error(t, b.uploadToSlot(entry, slot))
+
+	// the slots must be HTTPS
+	got = nil
+	slot.Get.Url = "http://example.com/get/hello.txt"
+	assert.ErrorIs(t, b.uploadToSlot(entry, slot), errInsecureSlot)
+	assert.Nil(t, got)
+}
+
+func TestUploadBuffer(t *testing.T) {
+	b := newTestXMPP("")
+	data := []byte("hello")
+	entry := &UploadBufferEntry{FileInfo: &config.FileInfo{Name: "hello.txt", Data: &data}}
+	b.httpUploadBuffer["slot1"] = entry
+
+	// the IQs of other requests are left alone
+	b.handleUploadSlotError(xmpp.IQ{ID: "slot2", Type: "error"})
+	b.handleUploadSlotError(xmpp.IQ{ID: "slot1", Type: "result"})
+	assert.Len(t, b.httpUploadBuffer, 1)
+
+	assert.Equal(t, entry, b.takeUploadEntry("slot1"))
+	assert.Nil(t, b.takeUploadEntry("slot1"))
+	assert.Empty(t, b.httpUploadBuffer)
+}
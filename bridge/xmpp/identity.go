@@ -0,0 +1,122 @@
+package bxmpp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/rs/xid"
+	"github.com/xmppo/go-xmpp"
+)
+
+// nsOccupantID is the namespace of the occupant IDs, see
+// https://xmpp.org/extensions/xep-0421.html
+const nsOccupantID = "urn:xmpp:occupant-id:0"
+
+// vCard holds the names of a vcard-temp vCard, see
+// https://xmpp.org/extensions/xep-0054.html
+type vCard struct {
+	FullName string `xml:"FN"`
+	Nickname string `xml:"NICKNAME"`
+}
+
+// handleRoomInfo records whether the room which sent the disco info result v
+// sets the occupant IDs. They can be spoofed in the rooms which don't.
+func (b *Bxmpp) handleRoomInfo(v xmpp.DiscoResult) {
+	for _, feature := range v.Features {
+		if feature == nsOccupantID {
+			b.Log.Debugf("%s supports occupant IDs", v.From)
+			b.occupantIDRooms[v.From] = true
+			return
+		}
+	}
+}
+
+// handlePresence records the real JID of the occupant whose presence is v, in
+// the rooms which disclose it, and requests its vCard with UseVCardName.
+func (b *Bxmpp) handlePresence(v xmpp.Presence) {
+	if v.JID == "" {
+		return
+	}
+	if v.Type == "unavailable" {
+		delete(b.occupants, v.From)
+		return
+	}
+
+	jid, _, _ := strings.Cut(v.JID, "/")
+	b.occupants[v.From] = jid
+	if !b.GetBool("UseVCardName") {
+		return
+	}
+	if _, ok := b.vcardNames[jid]; ok {
+		return
+	}
+
+	// requested once, failed requests keep the name empty
+	b.vcardNames[jid] = ""
+	id := xid.New().String()
+	b.vcardRequests[id] = jid
+	if _, err := b.xc.RawInformation(b.xc.JID(), jid, id, "get", "<vCard xmlns='vcard-temp'/>"); err != nil {
+		b.Log.WithError(err).Warnf("Failed to request the vCard of %s", jid)
+	}
+}
+
+// handleVCard records the name of the vCard in the result v of a vCard request.
+func (b *Bxmpp) handleVCard(v xmpp.IQ) {
+	jid, ok := b.vcardRequests[v.ID]
+	if !ok {
+		return
+	}
+	delete(b.vcardRequests, v.ID)
+	if v.Type != "result" {
+		b.Log.Debugf("No vCard for %s", jid)
+		return
+	}
+
+	name, err := parseVCardName(v.Query)
+	if err != nil {
+		b.Log.WithError(err).Warnf("Failed to parse the vCard of %s", jid)
+		return
+	}
+	b.Log.Debugf("vCard name of %s is %q", jid, name)
+	b.vcardNames[jid] = name
+}
+
+// parseVCardName returns the full name of the vCard query, or its nickname.
+func parseVCardName(query []byte) (string, error) {
+	var card vCard
+	if err := xml.Unmarshal(query, &card); err != nil {
+		return "", fmt.Errorf("invalid vCard: %w", err)
+	}
+	if name := strings.TrimSpace(card.FullName); name != "" {
+		return name, nil
+	}
+	return strings.TrimSpace(card.Nickname), nil
+}
+
+// senderIdentity returns the user ID and the name of the sender of the
+// groupchat message v, sent as nick. The user ID is the real JID of the sender
+// when the room discloses it, else its occupant ID which doesn't change with
+// the nick, else its room JID.
+func (b *Bxmpp) senderIdentity(v xmpp.Chat, nick string) (string, string) {
+	userID := v.Remote
+	room, _, _ := strings.Cut(v.Remote, "/")
+	if id := occupantID(v); id != "" && b.occupantIDRooms[room] {
+		userID = id
+	}
+
+	jid, ok := b.occupants[v.Remote]
+	if !ok {
+		return userID, nick
+	}
+	if name := b.vcardNames[jid]; name != "" && b.GetBool("UseVCardName") {
+		nick = name
+	}
+	return jid, nick
+}
+
+// occupantID returns the occupant ID set by the room in v, if any.
+func occupantID(v xmpp.Chat) string {
+	id, _ := elementAttr(v, nsOccupantID, "occupant-id", "id")
+	return id
+}
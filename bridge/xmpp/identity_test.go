@@ -0,0 +1,75 @@
+package bxmpp
+
+import (
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmppo/go-xmpp"
+)
+
+func newTestXMPP(settings string) *Bxmpp {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte("[xmpp.test]\n"+settings))
+	return New(&bridge.Config{Bridge: &bridge.Bridge{
+		Account: "xmpp.test",
+		Config:  cfg,
+		Log:     logrus.NewEntry(logger),
+	}}).(*Bxmpp)
+}
+
+func occupantChat(remote string, id string) xmpp.Chat {
+	return xmpp.Chat{
+		Remote: remote,
+		Type:   "groupchat",
+		OtherElem: []xmpp.XMLElement{{
+			XMLName: xml.Name{Space: nsOccupantID, Local: "occupant-id"},
+			Attr:    []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		}},
+	}
+}
+
+func TestSenderIdentity(t *testing.T) {
+	b := newTestXMPP("UseVCardName=true\n")
+
+	// the occupant IDs of the rooms which don't set them can be spoofed
+	v := occupantChat("room@muc.example.com/alice", "dGhpcyBpcyBhbGljZQ==")
+	userID, nick := b.senderIdentity(v, "alice")
+	assert.Equal(t, "room@muc.example.com/alice", userID)
+	assert.Equal(t, "alice", nick)
+
+	b.handleRoomInfo(xmpp.DiscoResult{From: "room@muc.example.com", Features: []string{nsOccupantID}})
+	userID, _ = b.senderIdentity(v, "alice")
+	assert.Equal(t, "dGhpcyBpcyBhbGljZQ==", userID)
+
+	// the real JIDs are used in the non-anonymous rooms
+	b.occupants["room@muc.example.com/alice"] = "alice@example.com"
+	b.vcardNames["alice@example.com"] = "Alice Liddell"
+	userID, nick = b.senderIdentity(v, "alice")
+	assert.Equal(t, "alice@example.com", userID)
+	assert.Equal(t, "Alice Liddell", nick)
+
+	b.handlePresence(xmpp.Presence{From: "room@muc.example.com/alice", Type: "unavailable", JID: "alice@example.com/phone"})
+	userID, nick = b.senderIdentity(v, "alice")
+	assert.Equal(t, "dGhpcyBpcyBhbGljZQ==", userID)
+	assert.Equal(t, "alice", nick)
+}
+
+func TestParseVCardName(t *testing.T) {
+	name, err := parseVCardName([]byte(`<vCard xmlns="vcard-temp"><FN>Alice Liddell</FN><NICKNAME>alice</NICKNAME></vCard>`))
+	require.NoError(t, err)
+	assert.Equal(t, "Alice Liddell", name)
+
+	name, err = parseVCardName([]byte(`<vCard xmlns="vcard-temp"><NICKNAME> alice </NICKNAME></vCard>`))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	_, err = parseVCardName([]byte(`<vCard`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package bxmpp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// defaultPresenceIdleTime is the number of minutes after which the remote
+// participants which didn't talk leave the rooms, unless PresenceIdleTime is
+// set.
+const defaultPresenceIdleTime = 60
+
+// puppet is a remote participant of a gateway, present in a room through the
+// component.
+type puppet struct {
+	jid      string
+	nick     string
+	lastSeen time.Time
+}
+
+// startComponent connects the component in the background when Component is
+// set, to show the remote participants of the gateways in the rooms.
+func (b *Bxmpp) startComponent() {
+	if b.GetString("Component") == "" {
+		return
+	}
+	go b.manageComponent()
+	go b.expirePuppets()
+}
+
+// manageComponent connects the component, and reconnects it when its
+// connection fails. The participants are shown in the rooms again on each
+// connection.
+func (b *Bxmpp) manageComponent() {
+	bf := &backoff.Backoff{
+		Min:    time.Second,
+		Max:    5 * time.Minute,
+		Jitter: true,
+	}
+	for {
+		c, err := dialComponent(b.GetString("ComponentServer"), b.GetString("Component"), b.GetString("ComponentSecret"))
+		if err != nil {
+			d := bf.Duration()
+			b.Log.WithError(err).Errorf("Failed to connect the component %s, retrying in %s.", b.GetString("Component"), d)
+			time.Sleep(d)
+			continue
+		}
+		bf.Reset()
+		b.Log.Infof("Connected as component %s", b.GetString("Component"))
+
+		b.puppetsMutex.Lock()
+		b.component = c
+		for room, puppets := range b.puppets {
+			for _, p := range puppets {
+				if err := c.joinRoom(p.jid, room, p.nick); err != nil {
+					b.Log.WithError(err).Warnf("Failed to show %s in %s", p.nick, room)
+				}
+			}
+		}
+		b.puppetsMutex.Unlock()
+
+		err = c.run(b.Log)
+		b.Log.WithError(err).Error("Component disconnected.")
+
+		b.puppetsMutex.Lock()
+		b.component = nil
+		b.puppetsMutex.Unlock()
+		c.close()
+	}
+}
+
+// updatePresence shows the sender of msg in its room, or removes it for the
+// leave events. Returns true if msg is a join or leave event, which isn't
+// sent as a message.
+func (b *Bxmpp) updatePresence(msg config.Message) bool {
+	if b.GetString("Component") == "" {
+		return false
+	}
+	join := msg.Event == "" || msg.Event == config.EventUserAction || msg.Event == config.EventJoin
+	leave := msg.Event == config.EventLeave
+	if !join && !leave {
+		return false
+	}
+
+	nick := strings.TrimSpace(msg.Username)
+	key := msg.Account + "/" + msg.UserID
+	if msg.UserID == "" {
+		key = msg.Account + "/" + nick
+	}
+	if nick == "" {
+		return false
+	}
+	room := msg.Channel + "@" + b.GetString("Muc")
+
+	b.puppetsMutex.Lock()
+	defer b.puppetsMutex.Unlock()
+
+	puppets := b.puppets[room]
+	if puppets == nil {
+		puppets = make(map[string]*puppet)
+		b.puppets[room] = puppets
+	}
+	p, present := puppets[key]
+
+	if leave {
+		if present {
+			delete(puppets, key)
+			b.leaveRoom(p, room)
+		}
+		return true
+	}
+
+	if !present {
+		hash := sha256.Sum256([]byte(key))
+		p = &puppet{jid: hex.EncodeToString(hash[:8]) + "@" + b.GetString("Component") + "/matterbridge"}
+		puppets[key] = p
+	}
+	p.lastSeen = time.Now()
+	// joining with another nick changes the nick
+	if p.nick != nick {
+		p.nick = nick
+		if b.component != nil {
+			if err := b.component.joinRoom(p.jid, room, nick); err != nil {
+				b.Log.WithError(err).Warnf("Failed to show %s in %s", nick, room)
+			}
+		}
+	}
+	return msg.Event == config.EventJoin
+}
+
+// expirePuppets removes the participants which didn't talk for
+// PresenceIdleTime minutes from the rooms.
+func (b *Bxmpp) expirePuppets() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.removeIdlePuppets(time.Now())
+	}
+}
+
+func (b *Bxmpp) removeIdlePuppets(now time.Time) {
+	idle := b.GetInt("PresenceIdleTime")
+	if idle <= 0 {
+		idle = defaultPresenceIdleTime
+	}
+
+	b.puppetsMutex.Lock()
+	defer b.puppetsMutex.Unlock()
+	for room, puppets := range b.puppets {
+		for key, p := range puppets {
+			if now.Sub(p.lastSeen) < time.Duration(idle)*time.Minute {
+				continue
+			}
+			delete(puppets, key)
+			b.leaveRoom(p, room)
+		}
+	}
+}
+
+// leaveRoom removes p from room. It must be called with puppetsMutex locked.
+func (b *Bxmpp) leaveRoom(p *puppet, room string) {
+	if b.component == nil {
+		return
+	}
+	if err := b.component.leaveRoom(p.jid, room, p.nick); err != nil {
+		b.Log.WithError(err).Warnf("Failed to remove %s from %s", p.nick, room)
+	}
+}
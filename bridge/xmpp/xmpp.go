@@ -1,13 +1,16 @@
 package bxmpp
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"net/http"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/jpillora/backoff"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
@@ -36,7 +39,22 @@ type Bxmpp struct {
 	sync.RWMutex
 
 	avatarAvailability map[string]bool
-	avatarMap          map[string]string
+
+	// occupants maps the room JIDs (room@muc/nick) to the real JIDs of the
+	// occupants, in the rooms which disclose them.
+	occupants map[string]string
+	// occupantIDRooms are the rooms which set the occupant IDs (XEP-0421).
+	occupantIDRooms map[string]bool
+	// vcardNames caches the names of the vCards of the real JIDs, requested
+	// with UseVCardName, and vcardRequests the JIDs of the pending requests.
+	vcardNames    map[string]string
+	vcardRequests map[string]string
+
+	// component shows the remote participants of the gateways in the rooms,
+	// puppets maps the rooms to them by account and user ID
+	component    *component
+	puppets      map[string]map[string]*puppet
+	puppetsMutex sync.Mutex
 
 	// The account's HTTP [upload component](https://xmpp.org/extensions/xep-0363.html#disco)
 	// is discovered in steps commented HTTP_UPLOAD_DISCO.
@@ -55,15 +73,51 @@ type Bxmpp struct {
 	// Note that in most cases, remote bridges will provide an attachment URL, no file
 	// will actually be uploaded on XMPP side, and this buffer will be untouched.
 	httpUploadBuffer map[string]*UploadBufferEntry
+
+	// Messages we sent, waiting for the MUC to reflect them with their
+	// stanza-id, which is then reported to the gateway with AckMessage.
+	pendingAcks      []pendingAck
+	pendingAcksMutex sync.Mutex
+
+	// sentMessages maps the stanza-ids of the messages we sent to their
+	// origin-ids, and receivedMessages the stanza-ids and origin-ids of the
+	// messages received from the rooms to them, to correct and retract them.
+	sentMessages     *lru.Cache
+	receivedMessages *lru.Cache
+}
+
+type pendingAck struct {
+	channel string
+	text    string
+	id      string
+	sent    time.Time
 }
 
+// pendingAckTimeout is how long we wait for a sent message to be reflected.
+const pendingAckTimeout = time.Minute
+
 func New(cfg *bridge.Config) bridge.Bridger {
+	sentMessages, err := lru.New(messageCacheSize)
+	if err != nil {
+		cfg.Log.Fatalf("Could not create LRU cache: %v", err)
+	}
+	receivedMessages, err := lru.New(messageCacheSize)
+	if err != nil {
+		cfg.Log.Fatalf("Could not create LRU cache: %v", err)
+	}
+
 	return &Bxmpp{
 		Config:             cfg,
 		xmppMap:            make(map[string]string),
 		avatarAvailability: make(map[string]bool),
-		avatarMap:          make(map[string]string),
+		occupants:          make(map[string]string),
+		occupantIDRooms:    make(map[string]bool),
+		vcardNames:         make(map[string]string),
+		vcardRequests:      make(map[string]string),
+		puppets:            make(map[string]map[string]*puppet),
 		httpUploadBuffer:   make(map[string]*UploadBufferEntry),
+		sentMessages:       sentMessages,
+		receivedMessages:   receivedMessages,
 	}
 }
 
@@ -79,6 +133,7 @@ func (b *Bxmpp) Connect() error {
 	}
 
 	b.Log.Info("Connection succeeded")
+	b.startComponent()
 	go b.manageConnection()
 	return nil
 }
@@ -95,11 +150,17 @@ func (b *Bxmpp) Disconnect() error {
 }
 
 func (b *Bxmpp) JoinChannel(channel config.ChannelInfo) error {
+	room := channel.Name + "@" + b.GetString("Muc")
 	if channel.Options.Key != "" {
 		b.Log.Debugf("using key %s for channel %s", channel.Options.Key, channel.Name)
-		b.xc.JoinProtectedMUC(channel.Name+"@"+b.GetString("Muc"), b.GetString("Nick"), channel.Options.Key, xmpp.NoHistory, 0, nil)
+		b.xc.JoinProtectedMUC(room, b.GetString("Nick"), channel.Options.Key, xmpp.NoHistory, 0, nil)
 	} else {
-		b.xc.JoinMUCNoHistory(channel.Name+"@"+b.GetString("Muc"), b.GetString("Nick"))
+		b.xc.JoinMUCNoHistory(room, b.GetString("Nick"))
+	}
+
+	// find out whether the room sets the occupant IDs
+	if _, err := b.xc.DiscoverInfo(room); err != nil {
+		b.Log.WithError(err).Warnf("Failed to disco info from %s", room)
 	}
 	return nil
 }
@@ -109,9 +170,13 @@ func (b *Bxmpp) Send(msg config.Message) (string, error) {
 	if !b.Connected() {
 		return "", fmt.Errorf("bridge %s not connected, dropping message %#v to bridge", b.Account, msg)
 	}
-	// ignore delete messages
+	// Retract the deleted messages (XEP-0424).
 	if msg.Event == config.EventMsgDelete {
-		return "", nil
+		if msg.ID == "" {
+			return "", nil
+		}
+		b.Log.Debugf("=> Retracting message %s", msg.ID)
+		return "", b.retractMessage(msg)
 	}
 
 	b.Log.Debugf("=> Receiving %#v", msg)
@@ -120,13 +185,18 @@ func (b *Bxmpp) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg), nil
 	}
 
+	// the joins and leaves only change the participants shown in the room
+	if b.updatePresence(msg) {
+		return "", nil
+	}
+
 	// Make a action /me of the message, prepend the username with it.
 	// https://xmpp.org/extensions/xep-0245.html
 	if msg.Event == config.EventUserAction {
 		msg.Username = "/me " + msg.Username
 	}
 
-	// Upload a file (in XMPP case send the upload URL because XMPP has no native upload support).
+	// Upload a file, announcing its URL or uploading it with HTTP upload (XEP-0363).
 	var err error
 	if msg.Extra != nil {
 		// HandleExtra will produce error messages to be printed in the chat
@@ -149,22 +219,80 @@ func (b *Bxmpp) Send(msg config.Message) (string, error) {
 		}
 	}
 
-	// Post normal message.
+	// Correct the edited messages (XEP-0308).
+	if msg.ID != "" {
+		b.Log.Debugf("=> Correcting message %s with %#v", msg.ID, msg)
+		return msg.ID, b.correctMessage(msg)
+	}
+
+	// Post normal message, split in several ones when longer than MessageLength.
 	b.Log.Debugf("=> Sending message %#v", msg)
-	if _, err := b.xc.Send(xmpp.Chat{
-		Type:   "groupchat",
-		Remote: msg.Channel + "@" + b.GetString("Muc"),
-		Text:   msg.Username + msg.Text,
-	}); err != nil {
-		return "", err
+	var text string
+	for _, text = range helper.SplitMessage(msg.Username+msg.Text, b.GetInt("MessageLength"), b.GetBool("MessageSplitNumbered")) {
+		if _, err := b.xc.Send(xmpp.Chat{
+			Type:   "groupchat",
+			Remote: msg.Channel + "@" + b.GetString("Muc"),
+			Text:   text,
+		}); err != nil {
+			return "", err
+		}
 	}
 
-	// Generate a dummy ID because to avoid collision with other internal messages
-	// However this does not provide proper Edits/Replies integration on XMPP side.
+	// go-xmpp generates the origin-id of the message, so a provisional ID is
+	// returned. The real stanza-id is reported to the gateway when the MUC
+	// reflects the message, which gives its origin-id for the corrections.
+	// The split messages are corrected through their last part.
 	msgID := xid.New().String()
+	b.addPendingAck(msg.Channel, text, msgID)
 	return msgID, nil
 }
 
+func (b *Bxmpp) addPendingAck(channel string, text string, id string) {
+	b.pendingAcksMutex.Lock()
+	defer b.pendingAcksMutex.Unlock()
+
+	// Drop messages the MUC never reflected
+	now := time.Now()
+	for len(b.pendingAcks) > 0 && now.Sub(b.pendingAcks[0].sent) > pendingAckTimeout {
+		b.pendingAcks = b.pendingAcks[1:]
+	}
+
+	b.pendingAcks = append(b.pendingAcks, pendingAck{channel: channel, text: text, id: id, sent: now})
+}
+
+// ackReflectedMessage reports the stanza-id of a message we sent to the gateway,
+// when message is its reflection by the MUC.
+func (b *Bxmpp) ackReflectedMessage(message xmpp.Chat) {
+	if message.StanzaID.ID == "" {
+		return
+	}
+	// the reflections of our corrections and retractions aren't pending
+	if _, ok := elementAttr(message, nsCorrect, "replace", "id"); ok {
+		return
+	}
+
+	rnick, rchan := b.parseJID(message.Remote)
+	if rnick != b.GetString("Nick") {
+		return
+	}
+
+	b.pendingAcksMutex.Lock()
+	var id string
+	for i, pending := range b.pendingAcks {
+		if pending.channel == rchan && pending.text == message.Text {
+			id = pending.id
+			b.pendingAcks = append(b.pendingAcks[:i], b.pendingAcks[i+1:]...)
+			break
+		}
+	}
+	b.pendingAcksMutex.Unlock()
+
+	if id != "" {
+		b.recordSentMessage(message.StanzaID.ID, message.OriginID)
+		b.AckMessage(rchan, id, message.StanzaID.ID)
+	}
+}
+
 func (b *Bxmpp) createXMPP() error {
 	// TODO: remove in release after first community fork release (N+2)
 	if b.GetBool("NoTLS") {
@@ -189,8 +317,13 @@ func (b *Bxmpp) createXMPP() error {
 		InsecureSkipVerify: b.GetBool("SkipTLSVerify"), // nolint: gosec
 	}
 
+	server, err := b.serverAddress()
+	if err != nil {
+		return err
+	}
+
 	options := xmpp.Options{
-		Host:                         b.GetString("Server"),
+		Host:                         server,
 		User:                         b.GetString("Jid"),
 		Password:                     b.GetString("Password"),
 		NoTLS:                        !b.GetBool("UseDirectTLS"),
@@ -206,11 +339,38 @@ func (b *Bxmpp) createXMPP() error {
 		Mechanism:                    b.GetString("Mechanism"),
 		NoPLAIN:                      b.GetBool("NoPLAIN"),
 	}
-	var err error
 	b.xc, err = options.NewClient()
 	return err
 }
 
+// serverAddress returns the address of the server, resolved to an address of
+// the IPFamily of the account when it is set, as go-xmpp connects by itself.
+func (b *Bxmpp) serverAddress() (string, error) {
+	server := b.GetString("Server")
+	d := b.Dialer(0)
+	if d.Family == "" || b.GetBool("Anonymous") {
+		return server, nil
+	}
+
+	switch {
+	case server == "":
+		// like go-xmpp, the server of the SRV record of the domain of the Jid
+		_, domain, _ := strings.Cut(b.GetString("Jid"), "@")
+		server = net.JoinHostPort(domain, "5222")
+		if _, addrs, err := net.LookupSRV("xmpp-client", "tcp", domain); err == nil && len(addrs) > 0 {
+			server = net.JoinHostPort(strings.TrimSuffix(addrs[0].Target, "."), strconv.Itoa(int(addrs[0].Port)))
+		}
+	case !strings.Contains(server, ":"):
+		server = net.JoinHostPort(server, "5222")
+	}
+	address, err := d.ResolveAddress(context.Background(), server)
+	if err != nil {
+		return "", err
+	}
+	b.Log.Debugf("Connecting to %s (%s) with IPFamily %s", server, address, d.Family)
+	return address, nil
+}
+
 func (b *Bxmpp) manageConnection() {
 	b.setConnected(true)
 	initial := true
@@ -307,6 +467,13 @@ func (b *Bxmpp) handleXMPP() error {
 			if v.Type == "groupchat" {
 				b.Log.Debugf("== Receiving %#v", v)
 
+				b.ackReflectedMessage(v)
+
+				// Retractions have a fallback body or none.
+				if b.handleRetraction(v) {
+					continue
+				}
+
 				// Skip invalid messages.
 				if b.skipMessage(v) {
 					continue
@@ -324,17 +491,18 @@ func (b *Bxmpp) handleXMPP() error {
 					b.avatarAvailability[v.Remote] = false
 					b.xc.AvatarRequestData(v.Remote)
 				} else if available {
-					avatar = getAvatar(b.avatarMap, v.Remote, b.General)
+					avatar = b.AvatarURL(v.Remote)
 				}
 
 				rnick, rchan := b.parseJID(v.Remote)
+				userID, rnick := b.senderIdentity(v, rnick)
 				rmsg := config.Message{
 					Username: rnick,
 					Text:     v.Text,
 					Channel:  rchan,
 					Account:  b.Account,
 					Avatar:   avatar,
-					UserID:   v.Remote,
+					UserID:   userID,
 					// Here the stanza-id has been set by the server and can be used to provide replies
 					// as explained in XEP-0461 https://xmpp.org/extensions/xep-0461.html#business-id
 					ID:    v.StanzaID.ID,
@@ -342,6 +510,16 @@ func (b *Bxmpp) handleXMPP() error {
 					Extra: make(map[string][]any),
 				}
 
+				room, _, _ := strings.Cut(v.Remote, "/")
+				if origID, ok := b.correctedMessage(v, userID); !ok {
+					b.recordReceivedMessage(room, v, userID)
+				} else if b.GetBool("EditDisable") {
+					continue
+				} else if origID != "" {
+					rmsg.ID = origID
+					rmsg.Text += b.GetString("EditSuffix")
+				}
+
 				// Check if we have an action event.
 				var ok bool
 				rmsg.Text, ok = b.replaceAction(rmsg.Text)
@@ -361,7 +539,10 @@ func (b *Bxmpp) handleXMPP() error {
 			b.avatarAvailability[v.From] = true
 			b.Log.Debugf("Avatar for %s is now available", v.From)
 		case xmpp.Presence:
-			// Do nothing.
+			b.handlePresence(v)
+		case xmpp.IQ:
+			b.handleUploadSlotError(v)
+			b.handleVCard(v)
 		case xmpp.DiscoItems:
 			// Received a list of items, most likely from trying to find the HTTP upload server
 			// Send a disco info query to all items to find out which is which
@@ -375,7 +556,8 @@ func (b *Bxmpp) handleXMPP() error {
 			}
 		case xmpp.DiscoResult:
 			// Received disco info about a specific item, most likely from trying
-			// to find the HTTP upload server.
+			// to find the HTTP upload server, or about a joined room.
+			b.handleRoomInfo(v)
 			for _, identity := range v.Identities {
 				if identity.Type != "file" || identity.Category != "store" {
 					// Filter out disco info about everything else.
@@ -392,42 +574,7 @@ func (b *Bxmpp) handleXMPP() error {
 				b.Unlock()
 			}
 		case xmpp.Slot:
-			// HTTP_UPLOAD_SLOT step 2
-			b.Log.Debugf("Received upload slot ID %s", v.ID)
-			b.Lock()
-			entry, ok := b.httpUploadBuffer[v.ID]
-			b.Unlock()
-
-			if !ok {
-				b.Log.Warnf("Received upload slot ID %s doesn't match a known file", v.ID)
-				continue
-			}
-
-			b.Log.Debugf("Preparing to upload file %s to %s", entry.FileInfo.Name, v.Put.Url)
-
-			go func() {
-				headers := make(map[string]string)
-				headers["Content-Type"] = entry.Mime
-
-				for _, h := range v.Put.Headers {
-					switch h.Name {
-					case "Authorization", "Cookie", "Expires":
-						b.Log.Debugf("Setting header %s to %s", h.Name, h.Value)
-						headers[h.Name] = h.Value
-					default:
-						b.Log.Warnf("Unknown header from HTTP upload component: %s: %s", h.Name, h.Value)
-					}
-				}
-
-				err := b.HttpUpload(http.MethodPut, v.Put.Url, headers, entry.FileInfo.Data, []int{http.StatusOK, http.StatusCreated})
-				if err != nil {
-					b.Log.WithError(err).Warnf("Failed to upload file %s", entry.FileInfo.Name)
-				}
-
-				// Actually perform the chat announcement
-				// HTTP_UPLOAD_SLOT step 3
-				b.announceUploadedFile(entry.To, entry.Text, entry.Description, v.Get.Url)
-			}()
+			b.handleUploadSlot(v)
 		}
 	}
 }
@@ -0,0 +1,74 @@
+package bzulip
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+// reactionText returns the text relayed for a zulip reaction: the unicode
+// emoji, else the shortcode of the realm (custom) emoji.
+func reactionText(reactionType string, name string, code string) string {
+	if reactionType == "unicode_emoji" {
+		if e, ok := unicodeEmoji(code); ok {
+			return e
+		}
+	}
+	if e, ok := helper.EmojiUnicode(name); ok {
+		return e
+	}
+	return ":" + name + ":"
+}
+
+// unicodeEmoji returns the emoji of a zulip emoji code, the hexadecimal code
+// points separated by dashes, eg. "1f44d".
+func unicodeEmoji(code string) (string, bool) {
+	if code == "" {
+		return "", false
+	}
+	var e strings.Builder
+	for _, part := range strings.Split(code, "-") {
+		r, err := strconv.ParseUint(part, 16, 32)
+		if err != nil {
+			return "", false
+		}
+		e.WriteRune(rune(r))
+	}
+	return e.String(), true
+}
+
+// sendReaction adds the reaction in msg.Text to the message referenced by
+// msg.ParentID, or removes it when the message is flagged with
+// MarkReactionRemoved. Zulip takes the reactions by name, the names of the
+// emoji are tried until one is accepted. The reactions zulip doesn't know are
+// sent as a message.
+func (b *Bzulip) sendReaction(msg *config.Message) (string, error) {
+	if !msg.ParentValid() {
+		b.Log.Debugf("Dropping reaction %#v, message it applies to is unknown", msg)
+		return "", nil
+	}
+
+	method := "POST"
+	if msg.ReactionRemoved() {
+		method = "DELETE"
+	}
+	for _, name := range helper.ReactionNames(msg.Text) {
+		err := b.apiCall(method, "messages/"+url.PathEscape(msg.ParentID)+"/reactions", url.Values{"emoji_name": {name}}, nil)
+		if err == nil {
+			return "", nil
+		}
+		b.Log.Debugf("reaction %s: %s", name, err)
+	}
+
+	if msg.ReactionRemoved() {
+		return "", nil
+	}
+	b.Log.Debugf("%q isn't a zulip reaction, sending it as a message", msg.Text)
+	msg.Event = ""
+	msg.Text = fmt.Sprintf("reacted with %s", strings.TrimSpace(helper.EmojiToUnicode(msg.Text)))
+	return b.sendMessage(*msg)
+}
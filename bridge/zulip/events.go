@@ -0,0 +1,276 @@
+package bzulip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	gzb "github.com/matterbridge/gozulipbot"
+)
+
+// eventTypes are the events the bridge relays, the library only parses the
+// messages so the events are read by getEvents.
+var eventTypes = []gzb.EventType{"message", "update_message", "delete_message", "reaction"}
+
+// event is an event of the zulip events API, see
+// https://zulip.com/api/get-events
+type event struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+
+	// message
+	Message *gzb.EventMessage `json:"message"`
+
+	// update_message, delete_message and reaction
+	MessageID  int    `json:"message_id"`
+	MessageIDs []int  `json:"message_ids"`
+	UserID     int    `json:"user_id"`
+	StreamID   int    `json:"stream_id"`
+	Topic      string `json:"topic"`
+	// Content is nil when only the topic or the stream of the message changed
+	Content       *string `json:"content"`
+	RenderingOnly bool    `json:"rendering_only"`
+
+	// reaction
+	Op           string `json:"op"`
+	EmojiName    string `json:"emoji_name"`
+	EmojiCode    string `json:"emoji_code"`
+	ReactionType string `json:"reaction_type"`
+	User         struct {
+		FullName string `json:"full_name"`
+	} `json:"user"`
+}
+
+// getEvents waits for the next events of the queue, like gzb.Queue.GetEvents
+// which only returns the messages.
+func (b *Bzulip) getEvents() ([]event, error) {
+	resp, err := b.q.RawGetEvents()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, gzb.BackoffError
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return nil, gzb.UnauthorizedError
+	case resp.StatusCode >= 400:
+		if bytes.HasPrefix(body, []byte("<")) {
+			return nil, gzb.NoJSONError
+		}
+		qErr, err := b.q.ParseError(body)
+		if err != nil || qErr == nil {
+			return nil, gzb.UnknownError
+		}
+		return nil, gzb.BadEventQueueError
+	}
+
+	events, err := parseEvents(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		b.q.LastEventID = max(b.q.LastEventID, e.ID)
+	}
+	return events, nil
+}
+
+// parseEvents returns the events of a response of the events API.
+func parseEvents(body []byte) ([]event, error) {
+	var res struct {
+		Events []event `json:"events"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res.Events, nil
+}
+
+// handleEvent relays an event of the queue, the heartbeats are ignored.
+func (b *Bzulip) handleEvent(e event) {
+	switch e.Type {
+	case "message":
+		if e.Message != nil {
+			b.Log.Debugf("== Receiving %#v", *e.Message)
+			b.handleMessage(e.Message)
+		}
+	case "update_message":
+		b.Log.Debugf("== Receiving %#v", e)
+		b.handleUpdateMessage(e)
+	case "delete_message":
+		b.Log.Debugf("== Receiving %#v", e)
+		b.handleDeleteMessage(e)
+	case "reaction":
+		b.Log.Debugf("== Receiving %#v", e)
+		b.handleReaction(e)
+	}
+}
+
+func (b *Bzulip) handleMessage(m *gzb.EventMessage) {
+	// ignore our own messages
+	if m.SenderEmail == b.GetString("login") {
+		return
+	}
+
+	avatarURL := m.AvatarURL
+	if !strings.HasPrefix(avatarURL, "http") {
+		avatarURL = b.GetString("server") + avatarURL
+	}
+
+	rmsg := config.Message{
+		Username: m.SenderFullName,
+		Text:     m.Content,
+		Channel:  b.getChannel(m.StreamID) + "/topic:" + m.Subject,
+		Account:  b.Account,
+		UserID:   strconv.Itoa(m.SenderID),
+		Avatar:   avatarURL,
+		ID:       strconv.Itoa(m.ID),
+	}
+	b.messages.Add(rmsg.ID, knownMessage{channel: rmsg.Channel, username: rmsg.Username})
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// handleUpdateMessage relays the edits of the content of the messages, the
+// gateway finds the relayed copies by the message ID.
+func (b *Bzulip) handleUpdateMessage(e event) {
+	if e.Content == nil || e.RenderingOnly || e.UserID == b.userID {
+		return
+	}
+	id := strconv.Itoa(e.MessageID)
+	known, ok := b.lookupMessage(id)
+	if !ok {
+		b.Log.Debugf("Dropping the edit of %s, its channel is unknown", id)
+		return
+	}
+	rmsg := config.Message{
+		Username: known.username,
+		Text:     *e.Content,
+		Channel:  known.channel,
+		Account:  b.Account,
+		UserID:   strconv.Itoa(e.UserID),
+		ID:       id,
+	}
+	b.Log.Debugf("<= Message edit is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+func (b *Bzulip) handleDeleteMessage(e event) {
+	ids := e.MessageIDs
+	if len(ids) == 0 {
+		ids = []int{e.MessageID}
+	}
+	for _, messageID := range ids {
+		id := strconv.Itoa(messageID)
+		known, ok := b.lookupMessage(id)
+		if !ok {
+			if e.StreamID == 0 {
+				continue
+			}
+			known.channel = b.getChannel(e.StreamID) + "/topic:" + e.Topic
+		}
+		rmsg := config.Message{
+			Event:   config.EventMsgDelete,
+			Text:    config.EventMsgDelete,
+			Channel: known.channel,
+			Account: b.Account,
+			ID:      id,
+		}
+		b.messages.Remove(id)
+		b.Log.Debugf("<= Message deletion is %#v", rmsg)
+		b.Remote <- rmsg
+	}
+}
+
+func (b *Bzulip) handleReaction(e event) {
+	if e.UserID == b.userID {
+		return
+	}
+	parentID := strconv.Itoa(e.MessageID)
+	known, ok := b.lookupMessage(parentID)
+	if !ok {
+		b.Log.Debugf("Dropping the reaction to %s, its channel is unknown", parentID)
+		return
+	}
+	rmsg := config.Message{
+		Event:    config.EventReaction,
+		Text:     reactionText(e.ReactionType, e.EmojiName, e.EmojiCode),
+		Channel:  known.channel,
+		Username: e.User.FullName,
+		UserID:   strconv.Itoa(e.UserID),
+		ParentID: parentID,
+		Account:  b.Account,
+	}
+	if e.Op == "remove" {
+		rmsg.MarkReactionRemoved()
+	}
+	b.Log.Debugf("<= Reaction is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// apiRequest calls an endpoint of the API the library doesn't expose.
+func (b *Bzulip) apiRequest(method string, endpoint string, values url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.bot.APIURL+endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", b.bot.UserAgent)
+	req.SetBasicAuth(b.bot.Email, b.bot.APIKey)
+	return b.bot.Client.Do(req)
+}
+
+// apiCall calls an endpoint and decodes its answer into res, which may be
+// nil.
+func (b *Bzulip) apiCall(method string, endpoint string, values url.Values, res any) error {
+	resp, err := b.apiRequest(method, endpoint, values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var jr struct {
+			Msg string `json:"msg"`
+		}
+		_ = json.Unmarshal(body, &jr)
+		return fmt.Errorf("%s %s failed with status %d: %s", method, endpoint, resp.StatusCode, jr.Msg)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(body, res)
+}
+
+// knownMessage is what the edit and reaction events don't say about the
+// messages the bridge received or sent.
+type knownMessage struct {
+	channel  string
+	username string
+}
+
+// lookupMessage returns the channel and author of the message id.
+func (b *Bzulip) lookupMessage(id string) (knownMessage, bool) {
+	v, ok := b.messages.Get(id)
+	if !ok {
+		return knownMessage{}, false
+	}
+	return v.(knownMessage), true
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
+
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
 	"github.com/matterbridge-org/matterbridge/bridge/helper"
@@ -22,20 +25,37 @@ type Bzulip struct {
 	q       *gzb.Queue
 	bot     *gzb.Bot
 	streams map[int]string
+	// userID is the ID of the bot, to ignore its edits and reactions
+	userID int
+	// messages are the channels and authors of the messages received and
+	// sent, by ID
+	messages *lru.Cache
 	*bridge.Config
 	sync.RWMutex
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
-	return &Bzulip{Config: cfg, streams: make(map[int]string)}
+	messages, err := lru.New(5000)
+	if err != nil {
+		cfg.Log.Fatalf("Could not create LRU cache: %v", err)
+	}
+	return &Bzulip{Config: cfg, streams: make(map[int]string), messages: messages}
 }
 
 func (b *Bzulip) Connect() error {
 	bot := gzb.Bot{APIKey: b.GetString("token"), APIURL: b.GetString("server") + "/api/v1/", Email: b.GetString("login"), UserAgent: fmt.Sprintf("matterbridge/%s", version.Release)}
 	bot.Init()
-	q, err := bot.RegisterAll()
-	b.q = q
 	b.bot = &bot
+	var me struct {
+		UserID int `json:"user_id"`
+	}
+	if err := b.apiCall("GET", "users/me", nil, &me); err != nil {
+		b.Log.Errorf("Connect() %#v", err)
+		return err
+	}
+	b.userID = me.UserID
+	q, err := bot.RegisterEvents(eventTypes, "")
+	b.q = q
 	if err != nil {
 		b.Log.Errorf("Connect() %#v", err)
 		return err
@@ -63,8 +83,12 @@ func (b *Bzulip) Send(msg config.Message) (string, error) {
 		if msg.ID == "" {
 			return "", nil
 		}
-		_, err := b.bot.UpdateMessage(msg.ID, "")
-		return "", err
+		return "", b.apiCall("DELETE", "messages/"+url.PathEscape(msg.ID), nil, nil)
+	}
+
+	// Reaction to a previous message
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg)
 	}
 
 	// Upload a file if it exists
@@ -80,7 +104,7 @@ func (b *Bzulip) Send(msg config.Message) (string, error) {
 	// edit the message if we have a msg ID
 	if msg.ID != "" {
 		_, err := b.bot.UpdateMessage(msg.ID, msg.Username+msg.Text)
-		return "", err
+		return msg.ID, err
 	}
 
 	// Post normal message
@@ -107,7 +131,7 @@ func (b *Bzulip) getChannel(id int) string {
 
 func (b *Bzulip) handleQueue() error {
 	for {
-		messages, err := b.q.GetEvents()
+		events, err := b.getEvents()
 		if err != nil {
 			switch err {
 			case gzb.BackoffError:
@@ -119,7 +143,7 @@ func (b *Bzulip) handleQueue() error {
 				b.Log.Info("got a bad event queue id error, reconnecting")
 				b.bot.Queues = nil
 				for {
-					b.q, err = b.bot.RegisterAll()
+					b.q, err = b.bot.RegisterEvents(eventTypes, "")
 					if err != nil {
 						b.Log.Errorf("reconnecting failed: %s. Sleeping 10 seconds", err)
 						time.Sleep(time.Second * 10)
@@ -135,29 +159,8 @@ func (b *Bzulip) handleQueue() error {
 
 			continue
 		}
-		for _, m := range messages {
-			b.Log.Debugf("== Receiving %#v", m)
-			// ignore our own messages
-			if m.SenderEmail == b.GetString("login") {
-				continue
-			}
-
-			avatarURL := m.AvatarURL
-			if !strings.HasPrefix(avatarURL, "http") {
-				avatarURL = b.GetString("server") + avatarURL
-			}
-
-			rmsg := config.Message{
-				Username: m.SenderFullName,
-				Text:     m.Content,
-				Channel:  b.getChannel(m.StreamID) + "/topic:" + m.Subject,
-				Account:  b.Account,
-				UserID:   strconv.Itoa(m.SenderID),
-				Avatar:   avatarURL,
-			}
-			b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
-			b.Log.Debugf("<= Message is %#v", rmsg)
-			b.Remote <- rmsg
+		for _, e := range events {
+			b.handleEvent(e)
 		}
 
 		time.Sleep(time.Second * 3)
@@ -165,6 +168,7 @@ func (b *Bzulip) handleQueue() error {
 }
 
 func (b *Bzulip) sendMessage(msg config.Message) (string, error) {
+	channel := msg.Channel
 	topic := ""
 	if strings.Contains(msg.Channel, "/topic:") {
 		res := strings.Split(msg.Channel, "/topic:")
@@ -194,7 +198,9 @@ func (b *Bzulip) sendMessage(msg config.Message) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		return strconv.Itoa(jr.ID), nil
+		id := strconv.Itoa(jr.ID)
+		b.messages.Add(id, knownMessage{channel: channel})
+		return id, nil
 	}
 	return "", nil
 }
@@ -206,9 +212,9 @@ func (b *Bzulip) handleUploadFile(msg *config.Message) (string, error) {
 			msg.Text += fi.Comment + ": "
 		}
 		if fi.URL != "" {
-			msg.Text = fi.URL
+			msg.Text = helper.FormatAttachment(fi, b.GetString("AttachmentFormat"))
 			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
+				msg.Text = fi.Comment + ": " + msg.Text
 			}
 		}
 		_, err := b.sendMessage(*msg)
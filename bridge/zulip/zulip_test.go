@@ -0,0 +1,83 @@
+package bzulip
+
+import (
+	"io"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBzulip() *Bzulip {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	b := New(&bridge.Config{
+		Bridge: &bridge.Bridge{Account: "zulip.test", Log: logrus.NewEntry(logger)},
+		Remote: make(chan config.Message, 10),
+	}).(*Bzulip)
+	b.userID = 1
+	return b
+}
+
+func TestReactionText(t *testing.T) {
+	assert.Equal(t, "👍", reactionText("unicode_emoji", "+1", "1f44d"))
+	assert.Equal(t, "👨‍💻", reactionText("unicode_emoji", "technologist", "1f468-200d-1f4bb"))
+	assert.Equal(t, "😄", reactionText("unicode_emoji", "smile", "xyz"))
+	assert.Equal(t, ":party_parrot:", reactionText("realm_emoji", "party_parrot", "42"))
+	assert.Equal(t, "😄", reactionText("realm_emoji", "smile", "43"))
+	assert.Equal(t, ":zulip:", reactionText("zulip_extra_emoji", "zulip", "zulip"))
+}
+
+func TestHandleEvents(t *testing.T) {
+	b := newTestBzulip()
+	b.messages.Add("10", knownMessage{channel: "general/topic:test", username: "alice"})
+
+	events, err := parseEvents([]byte(`{"result": "success", "events": [
+		{"id": 1, "type": "heartbeat"},
+		{"id": 2, "type": "update_message", "message_id": 10, "user_id": 2, "content": "edited", "rendering_only": false},
+		{"id": 3, "type": "update_message", "message_id": 10, "user_id": 2, "rendering_only": true},
+		{"id": 4, "type": "update_message", "message_id": 10, "user_id": 1, "content": "edited by us"},
+		{"id": 5, "type": "reaction", "op": "add", "message_id": 10, "user_id": 2, "user": {"full_name": "Bob"},
+			"emoji_name": "+1", "emoji_code": "1f44d", "reaction_type": "unicode_emoji"},
+		{"id": 6, "type": "reaction", "op": "remove", "message_id": 10, "user_id": 2, "user": {"full_name": "Bob"},
+			"emoji_name": "party_parrot", "emoji_code": "42", "reaction_type": "realm_emoji"},
+		{"id": 7, "type": "reaction", "op": "add", "message_id": 11, "user_id": 2,
+			"emoji_name": "+1", "emoji_code": "1f44d", "reaction_type": "unicode_emoji"},
+		{"id": 8, "type": "delete_message", "message_id": 10, "message_type": "stream", "stream_id": 3, "topic": "test"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, events, 8)
+	for _, e := range events {
+		b.handleEvent(e)
+	}
+	close(b.Remote)
+
+	var received []config.Message
+	for msg := range b.Remote {
+		received = append(received, msg)
+	}
+	require.Len(t, received, 4)
+
+	assert.Equal(t, "10", received[0].ID)
+	assert.Equal(t, "edited", received[0].Text)
+	assert.Equal(t, "alice", received[0].Username)
+	assert.Equal(t, "general/topic:test", received[0].Channel)
+
+	assert.Equal(t, config.EventReaction, received[1].Event)
+	assert.Equal(t, "👍", received[1].Text)
+	assert.Equal(t, "10", received[1].ParentID)
+	assert.Equal(t, "Bob", received[1].Username)
+	assert.False(t, received[1].ReactionRemoved())
+
+	assert.Equal(t, ":party_parrot:", received[2].Text)
+	assert.True(t, received[2].ReactionRemoved())
+
+	assert.Equal(t, config.EventMsgDelete, received[3].Event)
+	assert.Equal(t, "10", received[3].ID)
+	assert.Equal(t, "general/topic:test", received[3].Channel)
+	_, ok := b.lookupMessage("10")
+	assert.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+const diagnosticsUsage = `usage: matterbridge [-conf matterbridge.toml] diagnostics [file]
+
+Saves the diagnostic bundle of the running matterbridge (goroutine dump, recent
+logs, configuration without its secrets, state of the bridges and queues) in file,
+matterbridge-diagnostics-<time>.zip by default, to attach to bug reports.
+
+The command talks to the admin API of the running matterbridge, see AdminListen
+and AdminToken.`
+
+// diagnosticsCommand saves the diagnostic bundle fetched with client in the
+// file given in args, writing what it did to out.
+func diagnosticsCommand(client *adminClient, args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return errors.New(diagnosticsUsage)
+	}
+	file := "matterbridge-diagnostics-" + time.Now().Format("20060102-150405") + ".zip"
+	if len(args) == 1 {
+		file = args[0]
+	}
+
+	status, body, err := client.send(http.MethodGet, "/api/diagnostics")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("admin API returned %d %s: %s", status, http.StatusText(status), body)
+	}
+	if err := os.WriteFile(file, body, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Diagnostic bundle saved in %s\n", file)
+	return nil
+}
+
+func runDiagnosticsCommand(cfg config.Config, args []string) error {
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+	return diagnosticsCommand(client, args, os.Stdout)
+}
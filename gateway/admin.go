@@ -0,0 +1,340 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/matterbridge-org/matterbridge/bridge"
+)
+
+// QueuedMessage describes a message queued for an unhealthy bridge.
+type QueuedMessage struct {
+	Gateway   string    `json:"gateway"`
+	Channel   string    `json:"channel"`
+	Username  string    `json:"username"`
+	Text      string    `json:"text"`
+	Event     string    `json:"event,omitempty"`
+	Files     int       `json:"files,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Queue is the queue of messages of a bridge, see sendBreaker.
+type Queue struct {
+	Account string `json:"account"`
+	// Unhealthy is true while the bridge is reconnected after failing to send
+	Unhealthy bool            `json:"unhealthy"`
+	Failures  int             `json:"failures"`
+	Messages  []QueuedMessage `json:"messages"`
+}
+
+// QueueResult is the result of a replay or drop of a queue.
+type QueueResult struct {
+	Account string `json:"account"`
+	// Processed is the number of messages sent (or dropped)
+	Processed int `json:"processed"`
+	Remaining int `json:"remaining"`
+}
+
+// Health is the health of the router: "ok" when all the bridges which aren't
+// optional are connected, "degraded" otherwise.
+type Health struct {
+	Status  string         `json:"status"`
+	Bridges []BridgeStatus `json:"bridges"`
+}
+
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// APIBudget is the accounting of the API calls of an account, see
+// bridge.APIBudget.
+type APIBudget struct {
+	Account string `json:"account"`
+	bridge.APIBudgetStats
+}
+
+// APIBudgets returns the API call accounting of the accounts which count their
+// calls, ordered by account.
+func (r *Router) APIBudgets() []APIBudget {
+	budgets := []APIBudget{}
+	for _, account := range r.sortedAccounts() {
+		stats, ok := r.getBridge(account).Budget.Stats()
+		if ok {
+			budgets = append(budgets, APIBudget{Account: account, APIBudgetStats: stats})
+		}
+	}
+	return budgets
+}
+
+// writeMetrics writes the API budgets in the Prometheus text format.
+func writeMetrics(w io.Writer, budgets []APIBudget) {
+	metrics := []struct {
+		name, kind, help string
+		value            func(APIBudget) any
+	}{
+		{"matterbridge_api_calls_total", "counter", "API calls made by the account.", func(b APIBudget) any { return b.Total }},
+		{"matterbridge_api_calls_last_minute", "gauge", "API calls made by the account in the last minute.", func(b APIBudget) any { return b.Calls }},
+		{"matterbridge_api_rate_budget", "gauge", "API calls per minute allowed to the account, 0 when unknown.", func(b APIBudget) any { return b.Limit }},
+		{"matterbridge_api_rate_limited_total", "counter", "API calls refused because of the rate limits of the platform.", func(b APIBudget) any { return b.RateLimited }},
+		{"matterbridge_api_deferred_total", "counter", "Operations slowed down or skipped to spare the API budget.", func(b APIBudget) any { return b.Deferred }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, budget := range budgets {
+			fmt.Fprintf(w, "%s{account=%q} %v\n", m.name, budget.Account, m.value(budget))
+		}
+	}
+}
+
+// writeMediaMetrics writes the counters of the media pools of the gateways in
+// the Prometheus text format.
+func writeMediaMetrics(w io.Writer, stats []MediaStats) {
+	metrics := []struct {
+		name, kind, help string
+		value            func(MediaStats) any
+	}{
+		{"matterbridge_media_queue_depth", "gauge", "Files waiting for a worker of the gateway.", func(s MediaStats) any { return s.Queued }},
+		{"matterbridge_media_workers_busy", "gauge", "Workers of the gateway handling a file.", func(s MediaStats) any { return s.Busy }},
+		{"matterbridge_media_files_total", "counter", "Files handled by the gateway.", func(s MediaStats) any { return s.Processed }},
+		{"matterbridge_media_failures_total", "counter", "Files the gateway failed to handle.", func(s MediaStats) any { return s.Failed }},
+		{"matterbridge_media_timeouts_total", "counter", "Files not handled before MediaTimeout, relayed without their media server URL.", func(s MediaStats) any { return s.TimedOut }},
+		{"matterbridge_media_processing_seconds_total", "counter", "Time spent handling the files of the gateway.", func(s MediaStats) any { return s.Seconds }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, stat := range stats {
+			fmt.Fprintf(w, "%s{gateway=%q} %v\n", m.name, stat.Gateway, m.value(stat))
+		}
+	}
+}
+
+// Health returns the health of the router, with the status of every bridge.
+func (r *Router) Health() Health {
+	health := Health{Status: HealthOK, Bridges: r.BridgeStatus()}
+	if len(health.Bridges) == 0 {
+		health.Status = HealthDegraded
+	}
+	for _, status := range health.Bridges {
+		// the bridges which gave up are reported even when optional
+		if (status.State != BridgeConnected || len(status.Warnings) > 0) && !status.Optional || status.State == BridgeFailed {
+			health.Status = HealthDegraded
+		}
+	}
+	return health
+}
+
+// Queues returns the queues of the bridges which have queued messages, or of
+// account when it isn't empty, ordered by account.
+func (r *Router) Queues(account string) ([]Queue, error) {
+	if account != "" && r.getBridge(account) == nil {
+		return nil, fmt.Errorf("unknown account %s", account)
+	}
+
+	r.RLock()
+	breakers := make(map[string]*sendBreaker, len(r.breakers))
+	for name, breaker := range r.breakers {
+		if account == "" || name == account {
+			breakers[name] = breaker
+		}
+	}
+	r.RUnlock()
+
+	queues := []Queue{}
+	for name, breaker := range breakers {
+		breaker.Lock()
+		queue := Queue{Account: name, Unhealthy: breaker.open, Failures: breaker.failures, Messages: []QueuedMessage{}}
+		for _, queued := range breaker.queue {
+			queue.Messages = append(queue.Messages, QueuedMessage{
+				Gateway:   queued.gw.Name,
+				Channel:   queued.msg.Channel,
+				Username:  queued.msg.Username,
+				Text:      queued.msg.Text,
+				Event:     queued.msg.Event,
+				Files:     len(queued.msg.Extra["file"]),
+				Timestamp: queued.msg.Timestamp,
+			})
+		}
+		breaker.Unlock()
+		if account == "" && len(queue.Messages) == 0 && !queue.Unhealthy {
+			continue
+		}
+		queues = append(queues, queue)
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Account < queues[j].Account
+	})
+	return queues, nil
+}
+
+// ReplayQueue sends the messages queued for account now, eg. after fixing its
+// credentials, instead of with the next message relayed to it.
+func (r *Router) ReplayQueue(account string) (QueueResult, error) {
+	br := r.getBridge(account)
+	if br == nil {
+		return QueueResult{}, fmt.Errorf("unknown account %s", account)
+	}
+	breaker := r.getBreaker(account)
+
+	breaker.Lock()
+	queue := breaker.queue
+	breaker.queue = nil
+	breaker.Unlock()
+
+	if len(queue) > 0 {
+		r.logger.Infof("Replaying %d messages queued for %s", len(queue), account)
+		queue[0].gw.flushQueue(br, breaker, queue)
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+	remaining := len(breaker.queue)
+	return QueueResult{Account: account, Processed: max(len(queue)-remaining, 0), Remaining: remaining}, nil
+}
+
+// DropQueue discards the messages queued for account.
+func (r *Router) DropQueue(account string) (QueueResult, error) {
+	if r.getBridge(account) == nil {
+		return QueueResult{}, fmt.Errorf("unknown account %s", account)
+	}
+	breaker := r.getBreaker(account)
+
+	breaker.Lock()
+	defer breaker.Unlock()
+	dropped := len(breaker.queue)
+	for i := range breaker.queue {
+		r.unstoreQueued(account, &breaker.queue[i])
+		r.releaseFiles(breaker.queue[i].files)
+	}
+	breaker.queue = nil
+	r.logger.Warnf("Dropped %d messages queued for %s", dropped, account)
+	return QueueResult{Account: account, Processed: dropped}, nil
+}
+
+// adminHandler returns the handler of the admin API, authenticated with the
+// AdminToken bearer token when it is set.
+func (r *Router) adminHandler() http.Handler {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	if token := r.BridgeValues().General.AdminToken; token != "" {
+		e.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+			// health checks of containers don't know the token
+			Skipper: func(c echo.Context) bool {
+				return c.Path() == "/healthz"
+			},
+			Validator: func(key string, c echo.Context) (bool, error) {
+				return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+			},
+			// a missing token is refused as a wrong one, not as a bad request
+			ErrorHandler: func(err error, c echo.Context) error {
+				return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "Unauthorized", Internal: err}
+			},
+		}))
+	}
+
+	e.GET("/healthz", func(c echo.Context) error {
+		health := r.Health()
+		if health.Status != HealthOK {
+			return c.JSON(http.StatusServiceUnavailable, health)
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+	e.GET("/metrics", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4")
+		writeMetrics(c.Response(), r.APIBudgets())
+		writeMediaMetrics(c.Response(), r.MediaStats())
+		writeTrafficMetrics(c.Response(), r.ChannelTraffic())
+		return nil
+	})
+	// the samples tell who said what, they aren't served unauthenticated
+	e.GET("/api/messages", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, r.MessageSamples(c.QueryParam("gateway"), c.QueryParam("channel"), c.QueryParam("action")))
+	}, r.requireAdminToken("the message samples need AdminToken"))
+	// the queued messages tell who said what, and a queue can be dropped
+	requireQueueToken := r.requireAdminToken("the queues need AdminToken")
+	e.GET("/api/queues", func(c echo.Context) error {
+		queues, err := r.Queues(c.QueryParam("account"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusOK, queues)
+	}, requireQueueToken)
+	e.POST("/api/queues/:account/replay", func(c echo.Context) error {
+		result, err := r.ReplayQueue(c.Param("account"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusOK, result)
+	}, requireQueueToken)
+	e.DELETE("/api/queues/:account", func(c echo.Context) error {
+		result, err := r.DropQueue(c.Param("account"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return c.JSON(http.StatusOK, result)
+	}, requireQueueToken)
+	// anyone reaching AdminListen could post as the bot otherwise, and read
+	// what is going to be posted
+	requireScheduleToken := r.requireAdminToken("scheduling messages needs AdminToken")
+	e.GET("/api/scheduled", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, r.ScheduledMessages())
+	}, requireScheduleToken)
+	e.POST("/api/scheduled", func(c echo.Context) error {
+		var msg ScheduledMessage
+		if err := c.Bind(&msg); err != nil {
+			return err
+		}
+		scheduled, err := r.Schedule(msg)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusCreated, scheduled)
+	}, requireScheduleToken)
+	e.DELETE("/api/scheduled/:id", func(c echo.Context) error {
+		if err := r.Unschedule(c.Param("id")); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, requireScheduleToken)
+	r.registerDiagnostics(e)
+	return e
+}
+
+// requireAdminToken refuses the requests with the error text when AdminToken
+// isn't set, for the endpoints which mustn't be served unauthenticated.
+func (r *Router) requireAdminToken(text string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if r.BridgeValues().General.AdminToken == "" {
+				return echo.NewHTTPError(http.StatusForbidden, text)
+			}
+			return next(c)
+		}
+	}
+}
+
+// serveAdmin serves the admin API on AdminListen.
+func (r *Router) serveAdmin() {
+	addr := r.BridgeValues().General.AdminListen
+	r.logger.Infof("Admin API listening on %s", addr)
+	if r.BridgeValues().General.AdminToken == "" {
+		r.logger.Warn("AdminToken is not set, the admin API is not authenticated")
+	}
+	server := &http.Server{Addr: addr, Handler: r.adminHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-r.stop
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		r.logger.Errorf("Admin API failed: %s", err)
+	}
+}
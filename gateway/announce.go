@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+)
+
+// announceFallback turns the announcement msg into a plain message for the
+// protocols which can't address the moderators only.
+func announceFallback(msg *config.Message, dest *bridge.Bridge) {
+	if msg.Event != config.EventAnnounce {
+		return
+	}
+	if _, ok := bridgemap.AnnounceSupport[dest.Protocol]; !ok {
+		msg.Event = ""
+	}
+}
+
+// Announce sends text to the moderators of channel of account, and relays it
+// to the moderators of the channels bridged with it.
+func (r *Router) Announce(account string, channel string, username string, text string) error {
+	br := r.getBridge(account)
+	if br == nil {
+		return fmt.Errorf("unknown account %s", account)
+	}
+	if !r.channelBridged(account, channel) {
+		return fmt.Errorf("channel %s of %s isn't bridged", channel, account)
+	}
+
+	msg := config.Message{
+		Event:    config.EventAnnounce,
+		Text:     text,
+		Channel:  channel,
+		Account:  account,
+		Protocol: br.Protocol,
+		Extra:    make(map[string][]any),
+	}
+	announceFallback(&msg, br)
+	if _, err := sendWithTimeout(br, msg); err != nil {
+		return err
+	}
+	r.Message <- config.Message{
+		Event:    config.EventAnnounce,
+		Text:     text,
+		Channel:  channel,
+		Username: username,
+		Account:  account,
+		Extra:    make(map[string][]any),
+	}
+	return nil
+}
+
+// alert announces text to the moderators of the channels of the accounts with
+// AlertModerators set, except to the channels of account, which the alert is
+// about.
+func (r *Router) alert(account string, text string) {
+	announced := make(map[string]bool)
+	for _, gw := range r.sortedGateways() {
+		for _, channel := range gw.channels() {
+			if channel.Account == account || announced[channel.ID] || !strings.Contains(channel.Direction, "out") {
+				continue
+			}
+			br := r.getBridge(channel.Account)
+			if br == nil || !br.GetBool("AlertModerators") || !r.bridgeStarted(br.Account) {
+				continue
+			}
+			announced[channel.ID] = true
+
+			msg := config.Message{
+				Event:    config.EventAnnounce,
+				Text:     text,
+				Channel:  channel.Name,
+				Account:  br.Account,
+				Protocol: br.Protocol,
+				Extra:    make(map[string][]any),
+			}
+			announceFallback(&msg, br)
+			if _, err := sendWithTimeout(br, msg); err != nil {
+				r.logger.Errorf("Alerting the moderators of %s on %s failed: %s", channel.Name, br.Account, err)
+			}
+		}
+	}
+}
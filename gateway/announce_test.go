@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventBridger records the messages sent, and joins channels instantly.
+type eventBridger struct {
+	bridge.Bridger
+
+	mu   sync.Mutex
+	msgs []config.Message
+}
+
+func (b *eventBridger) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+func (b *eventBridger) Send(msg config.Message) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.msgs = append(b.msgs, msg)
+	return "", nil
+}
+
+func TestAnnounce(t *testing.T) {
+	r, gw := newTestGateway()
+	irc, slack := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount]
+	ircEvents, slackEvents := &eventBridger{Bridger: irc.Bridger}, &eventBridger{Bridger: slack.Bridger}
+	irc.Bridger, slack.Bridger = ircEvents, slackEvents
+
+	// Announcements are plain messages for the protocols which can't address moderators
+	msg := &config.Message{Event: config.EventAnnounce, Text: "maintenance", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram"}
+	_, err := gw.SendMessage(msg, irc, gw.Channels["#main"+ircTestAccount], "")
+	require.NoError(t, err)
+	_, err = gw.SendMessage(msg, slack, gw.Channels["irc"+slackTestAccount], "")
+	require.NoError(t, err)
+	require.Len(t, ircEvents.msgs, 1)
+	assert.Equal(t, config.EventAnnounce, ircEvents.msgs[0].Event)
+	require.Len(t, slackEvents.msgs, 1)
+	assert.Equal(t, "", slackEvents.msgs[0].Event)
+
+	// Alerts are only sent to the accounts with AlertModerators, not about themselves
+	ircEvents.msgs, slackEvents.msgs = nil, nil
+	irc.SetBool("AlertModerators", true)
+	r.statusMu.Lock()
+	r.started[ircTestAccount] = true
+	r.started[slackTestAccount] = true
+	r.statusMu.Unlock()
+	r.alert(slackTestAccount, "slack.zzz is healthy again")
+	assert.Empty(t, slackEvents.msgs)
+	require.NotEmpty(t, ircEvents.msgs)
+	for _, alert := range ircEvents.msgs {
+		assert.Equal(t, config.EventAnnounce, alert.Event)
+		assert.Equal(t, "slack.zzz is healthy again", alert.Text)
+	}
+	r.alert(ircTestAccount, "irc.zzz is healthy again")
+	assert.Empty(t, slackEvents.msgs)
+}
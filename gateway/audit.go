@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// The actions of the audit log.
+const (
+	auditDrop     = "drop"
+	auditDelete   = "delete"
+	auditAnnounce = "announce"
+	auditReload   = "reload"
+)
+
+// The reasons of the dropped messages.
+const (
+	auditBotMessage       = "bot message"
+	auditEphemeralMessage = "ephemeral message"
+	auditIgnoredUserID    = "ignored user id"
+	auditOptedOut         = "user opted out"
+	auditIgnoredNick      = "ignored nick"
+	auditIgnoredMessage   = "ignored message"
+	auditMediaRateLimit   = "media rate limit"
+	auditRateLimit        = "rate limit"
+	auditQueueFull        = "send queue full"
+	auditSendFailed       = "send failed"
+	auditTooLarge         = "too large"
+	auditExpired          = "expired"
+	auditScript           = "dropped by script"
+	auditMuted            = "account muted"
+	auditFileOnly         = "file without text"
+)
+
+// auditLog appends what the router decided about the messages to AuditLog,
+// one JSON object per line: the messages it dropped and why, the moderation
+// actions it relayed and the config reloads.
+type auditLog struct {
+	sync.Mutex
+	w io.Writer
+	// hash logs the SHA-256 of the texts instead of the texts, with
+	// AuditLogHashContent
+	hash bool
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+	Gateway string    `json:"gateway,omitempty"`
+	// Account and Channel are where the message comes from, Dest the account
+	// it was sent to
+	Account    string `json:"account,omitempty"`
+	Channel    string `json:"channel,omitempty"`
+	Dest       string `json:"dest,omitempty"`
+	Username   string `json:"username,omitempty"`
+	UserID     string `json:"userid,omitempty"`
+	MessageID  string `json:"msgid,omitempty"`
+	File       string `json:"file,omitempty"`
+	Text       string `json:"text,omitempty"`
+	TextSHA256 string `json:"text_sha256,omitempty"`
+	Config     string `json:"config,omitempty"`
+}
+
+// prepareAuditLog opens AuditLog, entries are only appended to it.
+func (r *Router) prepareAuditLog() error {
+	general := r.BridgeValues().General
+	if general.AuditLog == "" {
+		return nil
+	}
+	f, err := os.OpenFile(general.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening the AuditLog failed: %w", err)
+	}
+	r.audit = &auditLog{w: f, hash: general.AuditLogHashContent}
+	r.onReload(func(name string) {
+		r.audit.record(auditEntry{Action: auditReload, Config: name})
+	})
+	return nil
+}
+
+// record appends e to the audit log, which may be nil when AuditLog isn't
+// set.
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if a.hash && e.Text != "" {
+		sum := sha256.Sum256([]byte(e.Text))
+		e.TextSHA256 = hex.EncodeToString(sum[:])
+		e.Text = ""
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	_, _ = a.w.Write(append(line, '\n'))
+}
+
+// auditMessage records the action on msg of gw, sent to dest when not empty,
+// in the audit log.
+func (r *Router) auditMessage(action string, reason string, gw *Gateway, msg *config.Message, dest string) {
+	if action == auditDrop {
+		r.recordTraffic(action, reason, gw, msg, dest)
+	}
+	if r == nil || r.audit == nil {
+		return
+	}
+	e := auditEntry{
+		Action:    action,
+		Reason:    reason,
+		Account:   msg.Account,
+		Channel:   msg.Channel,
+		Dest:      dest,
+		Username:  msg.Username,
+		UserID:    msg.UserID,
+		MessageID: msg.ID,
+		Text:      msg.Text,
+	}
+	if gw != nil {
+		e.Gateway = gw.Name
+	}
+	r.audit.record(e)
+}
+
+// auditFilesTooLarge records the files of msg which were too large to be
+// downloaded.
+func (r *Router) auditFilesTooLarge(msg *config.Message) {
+	if r.audit == nil {
+		return
+	}
+	for _, f := range msg.Extra[config.EventFileFailureSize] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		r.audit.record(auditEntry{
+			Action:    auditDrop,
+			Reason:    auditTooLarge,
+			Account:   msg.Account,
+			Channel:   msg.Channel,
+			Username:  msg.Username,
+			UserID:    msg.UserID,
+			MessageID: msg.ID,
+			File:      fi.Name,
+		})
+	}
+}
+
+// auditModeration records the deletions and the announcements relayed by gw.
+func (r *Router) auditModeration(gw *Gateway, msg *config.Message) {
+	switch msg.Event {
+	case config.EventMsgDelete:
+		reason := ""
+		if len(msg.Extra[config.ExtraExpired]) > 0 {
+			reason = auditExpired
+		}
+		r.auditMessage(auditDelete, reason, gw, msg, "")
+	case config.EventAnnounce:
+		r.auditMessage(auditAnnounce, "", gw, msg, "")
+	}
+}
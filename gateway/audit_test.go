@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	r, gw := newTestGateway()
+	gw.Bridges[ircTestAccount].SetString("IgnoreNicks", "spammer")
+	defer gw.Bridges[ircTestAccount].SetString("IgnoreNicks", "")
+
+	var buf bytes.Buffer
+	r.audit = &auditLog{w: &buf}
+	msg := &config.Message{Text: "buy now", Username: "spammer", Account: ircTestAccount, Channel: "#main"}
+	assert.True(t, gw.ignoreMessage(msg))
+	var e auditEntry
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &e))
+	assert.Equal(t, auditDrop, e.Action)
+	assert.Equal(t, auditIgnoredNick, e.Reason)
+	assert.Equal(t, "bridge", e.Gateway)
+	assert.Equal(t, "buy now", e.Text)
+
+	buf.Reset()
+	r.audit.hash = true
+	r.auditModeration(gw, &config.Message{Event: config.EventMsgDelete, Text: "buy now", Account: ircTestAccount, Channel: "#main"})
+	e = auditEntry{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &e))
+	assert.Equal(t, auditDelete, e.Action)
+	assert.Empty(t, e.Text)
+	assert.Equal(t, "a7219a05dccdd3e89b58bd0e6f88973b5d341d3ce9bd61df4dc88873fa3ded3c", e.TextSHA256)
+
+	// the empty messages aren't worth a line
+	buf.Reset()
+	assert.True(t, gw.ignoreMessage(&config.Message{Username: "friend", Account: ircTestAccount, Channel: "#main"}))
+	assert.Empty(t, buf.String())
+}
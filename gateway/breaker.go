@@ -0,0 +1,334 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// maxQueuedMessages is the number of messages kept for an unhealthy bridge,
+// older ones are dropped.
+const maxQueuedMessages = 100
+
+// maxRateLimitWait is the longest delay asked by a rate limited bridge which
+// is waited for before sending a message again.
+const maxRateLimitWait = 10 * time.Second
+
+var errSendTimeout = errors.New("send timed out")
+
+// sendBreaker is the circuit breaker guarding the Send calls to one bridge.
+//
+// After SendFailureThreshold consecutive failures or timeouts the bridge is
+// marked unhealthy: messages for it are queued instead of sent, and it is
+// reconnected. The queue is flushed once it is reconnected.
+type sendBreaker struct {
+	sync.Mutex
+
+	failures int
+	open     bool
+	queue    []queuedMessage
+	// flushing is set while the queue is sent, the messages sent meanwhile
+	// are queued behind it, see drainQueue
+	flushing bool
+	// reconnects are the reconnections started by the breaker
+	reconnects sync.WaitGroup
+}
+
+// queuedMessage is a message ready to be sent to a bridge, with what is needed
+// to record its ID once it is sent.
+type queuedMessage struct {
+	gw        *Gateway
+	msg       config.Message
+	channelID string
+	// key of the source message in gw.Messages
+	canonicalID string
+	// retried messages were rate limited, and are sent again after
+	// notBefore only once
+	retried   bool
+	notBefore time.Time
+	// files are the spool files of msg held until it is sent
+	files []string
+	// storeKey is the key of the message in the StorageBackend, see
+	// storeQueued
+	storeKey string
+}
+
+// getBreaker returns the circuit breaker of the bridge for account, which is
+// shared by all gateways like the bridge itself.
+func (r *Router) getBreaker(account string) *sendBreaker {
+	r.Lock()
+	defer r.Unlock()
+
+	breaker, ok := r.breakers[account]
+	if !ok {
+		breaker = &sendBreaker{}
+		r.breakers[account] = breaker
+	}
+	return breaker
+}
+
+// sendWithTimeout calls dest.Send, giving up after SendTimeout seconds. The Send
+// call itself cannot be interrupted and its result is discarded on timeout,
+// the next messages waiting for it to return or timing out as well. File
+// uploads have no timeout, as they can take long without the bridge being
+// unhealthy.
+// Errors are classified by the bridge when it can (see bridge.ErrorClassifier).
+func sendWithTimeout(dest *bridge.Bridge, msg config.Message) (string, error) {
+	timeout := time.Duration(dest.GetInt("SendTimeout")) * time.Second
+	if timeout <= 0 || len(msg.Extra["file"]) > 0 {
+		return classifySend(dest, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	mID, err := dest.SendInOrderContext(ctx, msg)
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return "", fmt.Errorf("%w after %s", errSendTimeout, timeout)
+	}
+	if err != nil {
+		return mID, classifyError(dest, err)
+	}
+	return mID, nil
+}
+
+func classifySend(dest *bridge.Bridge, msg config.Message) (string, error) {
+	mID, err := dest.SendInOrder(msg)
+	if err == nil {
+		return mID, nil
+	}
+	return mID, classifyError(dest, err)
+}
+
+// classifyError wraps err with its class when the bridge can classify it.
+func classifyError(br *bridge.Bridge, err error) error {
+	if classifier, ok := br.Bridger.(bridge.ErrorClassifier); ok {
+		return classifier.ClassifyError(err)
+	}
+	return err
+}
+
+// retryDelay returns how long to wait before sending again a message which
+// failed with err. Only the messages rate limited for less than
+// maxRateLimitWait are sent again.
+func retryDelay(err error) (time.Duration, bool) {
+	var bridgeErr *bridge.Error
+	if !errors.As(err, &bridgeErr) || bridgeErr.Class != bridge.ErrorRateLimited {
+		return 0, false
+	}
+	wait := bridgeErr.RetryAfter
+	if wait <= 0 {
+		wait = time.Second
+	}
+	return wait, wait <= maxRateLimitWait
+}
+
+// sendOrRetry sends msg to dest. When the bridge is rate limited, the message
+// is sent again once in the background, ahead of the next messages for the
+// channel, and an empty ID is returned.
+func (gw *Gateway) sendOrRetry(dest *bridge.Bridge, msg config.Message, channelID string, canonicalID string, retry bool) (string, error) {
+	mID, err := sendWithTimeout(dest, msg)
+	wait, ok := retryDelay(err)
+	if !retry || !ok {
+		return mID, err
+	}
+
+	gw.logger.Debugf("%s is rate limited, sending the message to %s again in %s", dest.Account, msg.Channel, wait)
+	gw.Router.getMediaQueue(channelID).retry(gw, dest, queuedMessage{
+		gw:          gw,
+		msg:         msg,
+		channelID:   channelID,
+		canonicalID: canonicalID,
+		retried:     true,
+		notBefore:   time.Now().Add(wait),
+	})
+	return "", nil
+}
+
+// guardedSend sends msg to dest through its circuit breaker. When dest is
+// unhealthy the message is queued and an empty ID returned. Rate limited
+// messages are sent again once when retry is set.
+func (gw *Gateway) guardedSend(dest *bridge.Bridge, msg config.Message, channelID string, canonicalID string, retry bool) (string, error) {
+	threshold := dest.GetInt("SendFailureThreshold")
+	if threshold <= 0 {
+		return gw.sendOrRetry(dest, msg, channelID, canonicalID, retry)
+	}
+
+	breaker := gw.Router.getBreaker(dest.Account)
+
+	breaker.Lock()
+	if breaker.open || breaker.flushing {
+		defer breaker.Unlock()
+		// Typing notifications are useless by the time the bridge is back
+		if msg.Event != config.EventUserTyping {
+			breaker.enqueue(gw, dest, msg, channelID, canonicalID)
+		}
+		return "", nil
+	}
+	if len(breaker.queue) == 0 {
+		breaker.Unlock()
+		mID, err := gw.sendOrRetry(dest, msg, channelID, canonicalID, retry)
+		gw.recordSend(dest, breaker, threshold, err)
+		return mID, err
+	}
+	queue := breaker.queue
+	breaker.queue = nil
+	breaker.flushing = true
+	breaker.Unlock()
+
+	if !gw.flushQueue(dest, breaker, queue) {
+		breaker.Lock()
+		breaker.enqueue(gw, dest, msg, channelID, canonicalID)
+		breaker.flushing = false
+		breaker.Unlock()
+		return "", nil
+	}
+	mID, err := gw.sendOrRetry(dest, msg, channelID, canonicalID, retry)
+	gw.recordSend(dest, breaker, threshold, err)
+	gw.drainQueue(dest, breaker)
+	return mID, err
+}
+
+// drainQueue sends the messages queued while the queue was flushed, until
+// none is left or the bridge fails again, and ends the flush. The caller must
+// have set breaker.flushing.
+func (gw *Gateway) drainQueue(dest *bridge.Bridge, breaker *sendBreaker) {
+	for {
+		breaker.Lock()
+		if breaker.open || len(breaker.queue) == 0 {
+			breaker.flushing = false
+			breaker.Unlock()
+			return
+		}
+		queue := breaker.queue
+		breaker.queue = nil
+		breaker.Unlock()
+
+		if !gw.flushQueue(dest, breaker, queue) {
+			breaker.Lock()
+			breaker.flushing = false
+			breaker.Unlock()
+			return
+		}
+	}
+}
+
+// flushQueue sends the messages queued while dest was unhealthy, and records
+// their IDs. It stops and queues the remaining ones again on the first error,
+// rate limits included.
+func (gw *Gateway) flushQueue(dest *bridge.Bridge, breaker *sendBreaker, queue []queuedMessage) bool {
+	gw.logger.Infof("Sending %d messages queued for %s", len(queue), dest.Account)
+
+	for i, queued := range queue {
+		mID, err := sendWithTimeout(dest, queued.msg)
+		gw.recordSend(dest, breaker, dest.GetInt("SendFailureThreshold"), err)
+		if err != nil && isRefusedMessage(err) {
+			gw.logger.Errorf("Dropping queued message refused by %s: %s", dest.Account, err)
+			gw.Router.unstoreQueued(dest.Account, &queued)
+			gw.Router.releaseFiles(queued.files)
+			continue
+		}
+		if err != nil {
+			gw.logger.Errorf("Sending queued message to %s failed: %s", dest.Account, err)
+			breaker.Lock()
+			breaker.queue = append(queue[i:], breaker.queue...)
+			breaker.Unlock()
+			return false
+		}
+		queued.recordID(dest, mID)
+		gw.Router.unstoreQueued(dest.Account, &queued)
+		gw.Router.releaseFiles(queued.files)
+	}
+	return true
+}
+
+// recordID adds the ID of the message sent to dest to the IDs of the source
+// message, as relayMessage does for messages which aren't queued.
+func (queued *queuedMessage) recordID(dest *bridge.Bridge, mID string) {
+	if mID == "" {
+		return
+	}
+	queued.gw.addMsgIDs(queued.canonicalID, []*BrMsgID{{dest, dest.Protocol + " " + mID, queued.channelID}})
+}
+
+// recordSend updates the breaker with the result of a Send call, and marks
+// dest unhealthy and reconnects it when the threshold is reached.
+func (gw *Gateway) recordSend(dest *bridge.Bridge, breaker *sendBreaker, threshold int, err error) {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if err == nil {
+		breaker.failures = 0
+		return
+	}
+
+	// Reconnecting doesn't help when the message itself is refused
+	if isRefusedMessage(err) {
+		return
+	}
+
+	breaker.failures++
+	// refused credentials don't get better with more attempts
+	failOver := !breaker.open && gw.Router.canFailOver(dest.Account, err)
+	if breaker.open || (!failOver && (threshold <= 0 || breaker.failures < threshold)) {
+		return
+	}
+
+	failures := breaker.failures
+	gw.logger.Warnf("%s failed %d times in a row (last error: %s), queueing its messages and reconnecting", dest.Account, failures, err)
+	breaker.open = true
+	breaker.reconnects.Add(1)
+	go func() {
+		defer breaker.reconnects.Done()
+		// failOver alerts the moderators itself
+		if !gw.Router.failOver(dest.Account, err) {
+			gw.Router.alert(dest.Account, fmt.Sprintf("%s failed to send %d messages in a row and is reconnecting, its messages are queued", dest.Account, failures))
+		}
+		gw.reconnectBridge(dest)
+
+		breaker.Lock()
+		breaker.open = false
+		breaker.failures = 0
+		// a flush still running sends the queue itself
+		flush := !breaker.flushing
+		breaker.flushing = true
+		breaker.Unlock()
+		gw.logger.Infof("%s is healthy again", dest.Account)
+		gw.Router.alert(dest.Account, dest.Account+" is healthy again")
+		if flush {
+			gw.drainQueue(dest, breaker)
+		}
+	}()
+}
+
+// isRefusedMessage returns true for errors caused by the message rather than
+// by the health of the bridge, which are not retried.
+func isRefusedMessage(err error) bool {
+	switch bridge.ErrorClassOf(err) {
+	case bridge.ErrorPermission, bridge.ErrorTooLarge:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue adds a message to the queue, dropping the oldest one when full, and
+// keeps it in the StorageBackend. The breaker must be locked.
+func (breaker *sendBreaker) enqueue(gw *Gateway, dest *bridge.Bridge, msg config.Message, channelID string, canonicalID string) {
+	if len(breaker.queue) >= maxQueuedMessages {
+		gw.logger.Warnf("Too many messages queued for %s, dropping the oldest one", dest.Account)
+		dropped := breaker.queue[0]
+		gw.Router.auditMessage(auditDrop, auditQueueFull, dropped.gw, &dropped.msg, dest.Account)
+		gw.Router.unstoreQueued(dest.Account, &dropped)
+		gw.Router.releaseFiles(dropped.files)
+		breaker.queue = breaker.queue[1:]
+	}
+	queued := queuedMessage{gw: gw, msg: msg, channelID: channelID, canonicalID: canonicalID, files: gw.Router.holdFiles(&msg)}
+	gw.Router.storeQueued(dest.Account, &queued)
+	breaker.queue = append(breaker.queue, queued)
+	gw.logger.Debugf("%s is unhealthy, queued message for %s (%d queued)", dest.Account, msg.Channel, len(breaker.queue))
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyBridger fails or blocks its Send calls on demand.
+type flakyBridger struct {
+	bridge.Bridger
+
+	fail  bool
+	block chan struct{}
+	sent  []string
+	// connect is waited for by Connect when set
+	connect chan struct{}
+	// errs are returned by the next Send calls
+	errs []error
+}
+
+func (b *flakyBridger) Send(msg config.Message) (string, error) {
+	if b.block != nil {
+		<-b.block
+	}
+	if len(b.errs) > 0 {
+		err := b.errs[0]
+		b.errs = b.errs[1:]
+		return "", err
+	}
+	if b.fail {
+		return "", errors.New("unavailable")
+	}
+	b.sent = append(b.sent, msg.Text)
+	return "id-" + msg.Text, nil
+}
+
+func (b *flakyBridger) Connect() error {
+	if b.connect != nil {
+		<-b.connect
+	}
+	return nil
+}
+
+func (b *flakyBridger) Disconnect() error {
+	return nil
+}
+
+func (b *flakyBridger) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+func TestSendBreaker(t *testing.T) {
+	r, gw := newTestGateway()
+	gw.reconnectDelay = 0
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger, fail: true, connect: make(chan struct{})}
+	irc.Bridger = flaky
+	gw.Messages.Add("telegram 1", []*BrMsgID{})
+
+	send := func(text string) (string, error) {
+		return gw.guardedSend(irc, config.Message{Text: text}, "#main"+ircTestAccount, "telegram 1", true)
+	}
+
+	// SendFailureThreshold defaults to 5
+	for i := 0; i < 5; i++ {
+		_, err := send("lost")
+		assert.Error(t, err)
+	}
+	breaker := r.getBreaker(ircTestAccount)
+	assert.True(t, breaker.open)
+
+	mID, err := send("queued")
+	assert.NoError(t, err)
+	assert.Equal(t, "", mID)
+	assert.Len(t, breaker.queue, 1)
+
+	// The queue is sent once the bridge is reconnected
+	flaky.fail = false
+	close(flaky.connect)
+	breaker.reconnects.Wait()
+	assert.False(t, breaker.open)
+	assert.Equal(t, []string{"queued"}, flaky.sent)
+
+	mID, err = send("new")
+	assert.NoError(t, err)
+	assert.Equal(t, "id-new", mID)
+	assert.Equal(t, []string{"queued", "new"}, flaky.sent)
+	assert.Empty(t, breaker.queue)
+	assert.Equal(t, "id-queued", gw.getDestMsgID("telegram 1", irc, &config.ChannelInfo{ID: "#main" + ircTestAccount}))
+}
+
+func TestSendBreakerFlush(t *testing.T) {
+	r, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger, block: make(chan struct{})}
+	irc.Bridger = flaky
+	breaker := r.getBreaker(ircTestAccount)
+	breaker.queue = []queuedMessage{{gw: gw, msg: config.Message{Text: "queued"}}}
+
+	send := func(text string) (string, error) {
+		return gw.guardedSend(irc, config.Message{Text: text}, "#main"+ircTestAccount, "telegram 1", true)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mID, err := send("first")
+		assert.NoError(t, err)
+		assert.Equal(t, "id-first", mID)
+	}()
+	assert.Eventually(t, func() bool {
+		breaker.Lock()
+		defer breaker.Unlock()
+		return breaker.flushing
+	}, time.Second, time.Millisecond)
+
+	// a message sent while the queue is flushed waits behind it
+	mID, err := send("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "", mID)
+
+	close(flaky.block)
+	<-done
+	assert.Equal(t, []string{"queued", "first", "second"}, flaky.sent)
+	assert.Empty(t, breaker.queue)
+	assert.False(t, breaker.flushing)
+}
+
+func TestSendErrorClasses(t *testing.T) {
+	r, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	send := func(text string) (string, error) {
+		return gw.guardedSend(irc, config.Message{Text: text}, "#main"+ircTestAccount, "", true)
+	}
+
+	// Refused messages don't make the bridge unhealthy
+	for i := 0; i < 6; i++ {
+		flaky.errs = []error{bridge.NewError(bridge.ErrorPermission, errors.New("forbidden"))}
+		_, err := send("refused")
+		assert.ErrorIs(t, err, bridge.ErrPermission)
+	}
+	assert.False(t, r.getBreaker(ircTestAccount).open)
+
+	// Rate limited messages are sent again in the background, before the
+	// next messages of the channel
+	channelID := "#main" + ircTestAccount
+	flaky.errs = []error{bridge.NewRateLimitError(errors.New("slow down"), 50*time.Millisecond)}
+	mID, err := gw.pacedSend(irc, config.Message{Text: "retried"}, channelID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", mID)
+	mID, err = gw.pacedSend(irc, config.Message{Text: "next"}, channelID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "", mID)
+	assert.Eventually(t, func() bool { return r.pendingMediaQueue(channelID) == nil }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"retried", "next"}, flaky.sent)
+
+	// only once
+	flaky.errs = []error{bridge.NewRateLimitError(errors.New("slow down"), time.Millisecond), bridge.NewRateLimitError(errors.New("slow down"), time.Millisecond)}
+	_, err = gw.pacedSend(irc, config.Message{Text: "lost"}, channelID, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return r.pendingMediaQueue(channelID) == nil }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, flaky.errs)
+
+	flaky.errs = []error{bridge.NewRateLimitError(errors.New("slow down"), time.Hour)}
+	_, err = send("dropped")
+	assert.ErrorIs(t, err, bridge.ErrRateLimited)
+	assert.Equal(t, []string{"retried", "next"}, flaky.sent)
+
+	assert.Equal(t, bridge.ErrorNetwork, bridge.ErrorClassOf(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.Equal(t, bridge.ErrorUnknown, bridge.ErrorClassOf(errors.New("oops")))
+}
+
+func TestSendTimeout(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	irc := r.getBridge(ircTestAccount)
+	flaky := &flakyBridger{Bridger: irc.Bridger, block: make(chan struct{})}
+	irc.Bridger = flaky
+
+	// SendTimeout is disabled by default
+	assert.Equal(t, 0, irc.GetInt("SendTimeout"))
+	irc.SetInt("SendTimeout", 1)
+	defer irc.SetInt("SendTimeout", 0)
+
+	_, err := sendWithTimeout(irc, config.Message{Text: "hung"})
+	assert.ErrorIs(t, err, errSendTimeout)
+
+	// the next message times out waiting for the hung one, and isn't sent
+	_, err = sendWithTimeout(irc, config.Message{Text: "late"})
+	assert.ErrorIs(t, err, errSendTimeout)
+
+	// the next message waits for the hung one, and uploads have no timeout
+	done := make(chan error, 1)
+	go func() {
+		_, err := sendWithTimeout(irc, config.Message{Text: "upload", Extra: map[string][]interface{}{
+			"file": {config.FileInfo{Name: "cat.png", Data: &[]byte{}}},
+		}})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("the upload didn't wait for the hung message: %v", err)
+	case <-time.After(1500 * time.Millisecond):
+	}
+	close(flaky.block)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"hung", "upload"}, flaky.sent)
+}
@@ -5,9 +5,19 @@ package bridgemap
 
 import (
 	bdiscord "github.com/matterbridge-org/matterbridge/bridge/discord"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 )
 
 func init() {
 	FullMap["discord"] = bdiscord.New
+	AuthenticatedUserIDs["discord"] = struct{}{}
+	APIRateBudgets["discord"] = 3000
+	BlockquoteSupport["discord"] = struct{}{}
 	UserTypingSupport["discord"] = struct{}{}
+	ReactionSupport["discord"] = struct{}{}
+	SpoilerSupport["discord"] = struct{}{}
+	AnnounceSupport["discord"] = struct{}{}
+	CaptionSupport["discord"] = struct{}{}
+	// webhook names can't contain "discord" or "clyde"
+	NickRules["discord"] = helper.NickRules{Strip: []string{"discord", "clyde"}, MaxLength: bdiscord.MaxNickLength}
 }
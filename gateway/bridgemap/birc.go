@@ -10,4 +10,6 @@ import (
 func init() {
 	FullMap["irc"] = birc.New
 	SanitizeNickSupport["irc"] = struct{}{}
+	AnnounceSupport["irc"] = struct{}{}
+	CaptionSupport["irc"] = struct{}{}
 }
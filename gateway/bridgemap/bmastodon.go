@@ -9,4 +9,5 @@ import (
 //nolint:gochecknoinits
 func init() {
 	FullMap["mastodon"] = bmastodon.New
+	AuthenticatedUserIDs["mastodon"] = struct{}{}
 }
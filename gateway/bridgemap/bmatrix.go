@@ -9,4 +9,11 @@ import (
 
 func init() {
 	FullMap["matrix"] = bmatrix.New
+	AuthenticatedUserIDs["matrix"] = struct{}{}
+	APIRateBudgets["matrix"] = 600
+	BlockquoteSupport["matrix"] = struct{}{}
+	ReactionSupport["matrix"] = struct{}{}
+	SpoilerSupport["matrix"] = struct{}{}
+	AnnounceSupport["matrix"] = struct{}{}
+	CaptionSupport["matrix"] = struct{}{}
 }
@@ -9,4 +9,8 @@ import (
 
 func init() {
 	FullMap["mattermost"] = bmattermost.New
+	AuthenticatedUserIDs["mattermost"] = struct{}{}
+	BlockquoteSupport["mattermost"] = struct{}{}
+	ReactionSupport["mattermost"] = struct{}{}
+	CaptionSupport["mattermost"] = struct{}{}
 }
@@ -9,4 +9,5 @@ import (
 
 func init() {
 	FullMap["msteams"] = bmsteams.New
+	AuthenticatedUserIDs["msteams"] = struct{}{}
 }
@@ -9,4 +9,7 @@ import (
 
 func init() {
 	FullMap["nctalk"] = btalk.New
+	AuthenticatedUserIDs["nctalk"] = struct{}{}
+	ReactionSupport["nctalk"] = struct{}{}
+	CaptionSupport["nctalk"] = struct{}{}
 }
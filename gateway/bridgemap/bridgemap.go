@@ -2,10 +2,60 @@ package bridgemap
 
 import (
 	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 )
 
 var (
 	FullMap             = map[string]bridge.Factory{}
 	UserTypingSupport   = map[string]struct{}{}
 	SanitizeNickSupport = map[string]struct{}{}
+	ReactionSupport     = map[string]struct{}{}
+	// SpoilerSupport holds the protocols which send and receive spoilers
+	// ("||spoiler||"), they are hidden for the others.
+	SpoilerSupport = map[string]struct{}{}
+	// BlockquoteSupport holds the protocols rendering markdown blockquotes,
+	// used to style the attribution of forwarded messages. It is about the
+	// destinations only, the forwards are flagged by telegram and discord.
+	BlockquoteSupport = map[string]struct{}{}
+	// AnnounceSupport holds the protocols addressing announcements to the
+	// moderators, the others send them as plain messages.
+	AnnounceSupport = map[string]struct{}{}
+	// CaptionSupport holds the protocols sending the comments of the files
+	// with them, see gateway.dedupCaption.
+	CaptionSupport = map[string]struct{}{}
+	// AuthenticatedUserIDs holds the protocols whose user IDs are verified by
+	// the server, unlike the nicks and hosts of irc or xmpp. Only their users
+	// can be Admins.
+	AuthenticatedUserIDs = map[string]struct{}{}
+	// AlbumSupport holds the protocols sending the files of a message as a
+	// single album, see gateway.runMediaQueue.
+	AlbumSupport = map[string]struct{}{}
+	// NickRules holds the naming rules of the protocols, which the settings of
+	// the accounts (NickStrip, NickMaxLength...) override.
+	NickRules = map[string]helper.NickRules{}
+	// APIRateBudgets holds the default number of API calls per minute of the
+	// protocols accounting their calls, see bridge.APIBudget.
+	APIRateBudgets = map[string]int{}
+
+	// BuildTags holds the build tag which leaves each protocol out of
+	// FullMap, to explain why a configured protocol is missing.
+	BuildTags = map[string]string{
+		"api":        "noapi",
+		"discord":    "nodiscord",
+		"irc":        "noirc",
+		"mastodon":   "nomastodon",
+		"matrix":     "nomatrix",
+		"mattermost": "nomattermost",
+		"msteams":    "nomsteams",
+		"mumble":     "nomumble",
+		"nctalk":     "nonctalk",
+		"rocketchat": "norocketchat",
+		"slack":      "noslack",
+		"sshchat":    "nosshchat",
+		"telegram":   "notelegram",
+		"vk":         "novk",
+		"whatsapp":   "nowhatsappmulti",
+		"xmpp":       "noxmpp",
+		"zulip":      "nozulip",
+	}
 )
@@ -9,4 +9,7 @@ import (
 
 func init() {
 	FullMap["rocketchat"] = brocketchat.New
+	AuthenticatedUserIDs["rocketchat"] = struct{}{}
+	BlockquoteSupport["rocketchat"] = struct{}{}
+	CaptionSupport["rocketchat"] = struct{}{}
 }
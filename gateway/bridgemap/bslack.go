@@ -8,7 +8,11 @@ import (
 )
 
 func init() {
-	FullMap["slack-legacy"] = bslack.NewLegacy
 	FullMap["slack"] = bslack.New
+	AuthenticatedUserIDs["slack"] = struct{}{}
+	APIRateBudgets["slack"] = 50
+	BlockquoteSupport["slack"] = struct{}{}
 	UserTypingSupport["slack"] = struct{}{}
+	ReactionSupport["slack"] = struct{}{}
+	CaptionSupport["slack"] = struct{}{}
 }
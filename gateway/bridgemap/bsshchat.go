@@ -9,4 +9,5 @@ import (
 
 func init() {
 	FullMap["sshchat"] = bsshchat.New
+	CaptionSupport["sshchat"] = struct{}{}
 }
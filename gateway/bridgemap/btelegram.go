@@ -9,4 +9,10 @@ import (
 
 func init() {
 	FullMap["telegram"] = btelegram.New
+	AuthenticatedUserIDs["telegram"] = struct{}{}
+	BlockquoteSupport["telegram"] = struct{}{}
+	ReactionSupport["telegram"] = struct{}{}
+	SpoilerSupport["telegram"] = struct{}{}
+	CaptionSupport["telegram"] = struct{}{}
+	AlbumSupport["telegram"] = struct{}{}
 }
@@ -9,4 +9,6 @@ import (
 
 func init() {
 	FullMap["vk"] = bvk.New
+	CaptionSupport["vk"] = struct{}{}
+	AuthenticatedUserIDs["vk"] = struct{}{}
 }
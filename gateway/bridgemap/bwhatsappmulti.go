@@ -8,4 +8,6 @@ import (
 
 func init() {
 	FullMap["whatsapp"] = bwhatsapp.New
+	CaptionSupport["whatsapp"] = struct{}{}
+	AuthenticatedUserIDs["whatsapp"] = struct{}{}
 }
@@ -9,4 +9,5 @@ import (
 
 func init() {
 	FullMap["xmpp"] = bxmpp.New
+	CaptionSupport["xmpp"] = struct{}{}
 }
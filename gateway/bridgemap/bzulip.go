@@ -9,4 +9,8 @@ import (
 
 func init() {
 	FullMap["zulip"] = bzulip.New
+	AuthenticatedUserIDs["zulip"] = struct{}{}
+	BlockquoteSupport["zulip"] = struct{}{}
+	ReactionSupport["zulip"] = struct{}{}
+	CaptionSupport["zulip"] = struct{}{}
 }
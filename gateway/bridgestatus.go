@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// BridgeState is the connection state of a bridge.
+type BridgeState string
+
+const (
+	BridgeConnecting BridgeState = "connecting"
+	BridgeConnected  BridgeState = "connected"
+	// BridgeRetrying bridges failed to start and are retried in the background.
+	BridgeRetrying BridgeState = "retrying"
+	// BridgeFailed bridges had their credentials refused and are not retried
+	// until matterbridge restarts.
+	BridgeFailed BridgeState = "failed"
+)
+
+// BridgeStatus is the connection status of the bridge of an account.
+type BridgeStatus struct {
+	Account string      `json:"account"`
+	State   BridgeState `json:"state"`
+	// Since is when the bridge entered State
+	Since time.Time `json:"since"`
+	// Attempts is the number of failed connection attempts since the last success
+	Attempts int `json:"attempts,omitempty"`
+	// LastError is the last connection error
+	LastError string `json:"last_error,omitempty"`
+	// Optional is true when the gateways run without the bridge while it's
+	// not connected
+	Optional bool `json:"optional,omitempty"`
+	// Warnings are the problems of the connected bridge, see
+	// bridge.HealthWarner
+	Warnings []string `json:"warnings,omitempty"`
+	// Standby is true when the bridge uses its standby credentials
+	Standby bool `json:"standby,omitempty"`
+	// Muted is true when the bridge was muted with the mute command
+	Muted bool `json:"muted,omitempty"`
+	// Heartbeat is the result of the last heartbeats, see HeartbeatInterval
+	Heartbeat *bridge.Heartbeat `json:"heartbeat,omitempty"`
+}
+
+// Delays between the connection attempts of a bridge which failed to start,
+// doubled after each failure.
+var (
+	startRetryDelay    = 10 * time.Second
+	maxStartRetryDelay = 5 * time.Minute
+)
+
+// eventBridgeStarted is sent on the router channel by a bridge which connected
+// after the router started, so that its pattern channels are discovered by the
+// goroutine handling messages.
+const eventBridgeStarted = "bridge_started"
+
+// setBridgeStatus records the state of account, and the error which led to it.
+func (r *Router) setBridgeStatus(account string, state BridgeState, err error) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	status, ok := r.status[account]
+	if !ok {
+		status = &BridgeStatus{Account: account}
+		r.status[account] = status
+	}
+	if status.State != state {
+		status.State = state
+		status.Since = time.Now()
+	}
+	switch {
+	case err != nil:
+		status.Attempts++
+		status.LastError = err.Error()
+	case state == BridgeConnected:
+		status.Attempts = 0
+		status.LastError = ""
+	}
+}
+
+// BridgeStatus returns the connection status of all the bridges, ordered by
+// account.
+func (r *Router) BridgeStatus() []BridgeStatus {
+	r.statusMu.Lock()
+	statuses := make([]BridgeStatus, 0, len(r.status))
+	for _, status := range r.status {
+		statuses = append(statuses, *status)
+	}
+	r.statusMu.Unlock()
+
+	for i := range statuses {
+		statuses[i].Optional = r.bridgeOptional(statuses[i].Account)
+		statuses[i].Standby = r.onStandby(statuses[i].Account)
+		statuses[i].Muted = r.isMuted(statuses[i].Account)
+		statuses[i].Heartbeat = r.heartbeat(statuses[i].Account)
+		if br := r.getBridge(statuses[i].Account); br != nil && statuses[i].State == BridgeConnected {
+			if warner, ok := br.Bridger.(bridge.HealthWarner); ok {
+				statuses[i].Warnings = warner.HealthWarnings()
+			}
+			if hb := statuses[i].Heartbeat; hb != nil && hb.Failures >= heartbeatThreshold(br) {
+				statuses[i].Warnings = append(statuses[i].Warnings, fmt.Sprintf("missed %d heartbeats: %s", hb.Failures, hb.LastError))
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Account < statuses[j].Account
+	})
+	return statuses
+}
+
+// bridgeOptional returns true if account is optional in all the gateways it's
+// used in.
+func (r *Router) bridgeOptional(account string) bool {
+	optional := false
+	for _, gw := range r.ownerGateways(account) {
+		for _, br := range append(gw.MyConfig.In, append(gw.MyConfig.InOut, gw.MyConfig.Out...)...) {
+			if br.Account != account {
+				continue
+			}
+			if !br.Optional {
+				return false
+			}
+			optional = true
+		}
+	}
+	return optional
+}
+
+// bridgeStarted returns true once the bridge of account connected for the first
+// time. Messages aren't relayed to bridges which never connected.
+func (r *Router) bridgeStarted(account string) bool {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	return r.started[account]
+}
+
+// joinError is returned by startBridge when the bridge connected but failed to
+// join its channels. Such bridges aren't connected again.
+type joinError struct {
+	error
+}
+
+func (e *joinError) Unwrap() error {
+	return e.error
+}
+
+// startBridge connects the bridge of account and joins its channels.
+func (r *Router) startBridge(account string) error {
+	br := r.getBridge(account)
+	r.setBridgeStatus(account, BridgeConnecting, nil)
+	r.logger.Infof("Starting bridge: %s ", account)
+	if err := br.Connect(); err != nil {
+		return fmt.Errorf("Bridge %s failed to start: %w", account, classifyError(br, err))
+	}
+
+	// Channel patterns are resolved before joining, one bridge at a time as
+	// they add channels to the gateways.
+	r.discoverMu.Lock()
+	for _, gw := range r.ownerGateways(account) {
+		gw.discoverPatternChannels(br)
+	}
+	r.discoverMu.Unlock()
+
+	if err := br.JoinChannels(); err != nil {
+		return &joinError{fmt.Errorf("Bridge %s failed to join channel: %w", account, err)}
+	}
+	r.markBridgeStarted(account)
+	return nil
+}
+
+func (r *Router) markBridgeStarted(account string) {
+	r.setBridgeStatus(account, BridgeConnected, nil)
+	r.registerCommands(account)
+
+	r.statusMu.Lock()
+	r.started[account] = true
+	r.statusMu.Unlock()
+
+	r.postOnboardingNotices(account)
+}
+
+// startBridges connects all the bridges concurrently, so that a slow bridge
+// doesn't delay the others. The errors of the bridges which failed to start are
+// returned by account.
+func (r *Router) startBridges() map[string]error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+	)
+	for _, account := range r.sortedAccounts() {
+		if refs := r.bridgeRefs(account); refs > 1 {
+			r.logger.Infof("Bridge %s is shared by %d gateways: %s", account, refs, strings.Join(r.bridgeOwners[account], ", "))
+		}
+		wg.Add(1)
+		go func(account string) {
+			defer wg.Done()
+			err := r.startBridge(account)
+			if err != nil && r.failOver(account, err) {
+				err = r.startBridge(account)
+			}
+			if err != nil {
+				mu.Lock()
+				errs[account] = err
+				mu.Unlock()
+			}
+		}(account)
+	}
+	wg.Wait()
+	return errs
+}
+
+// retryBridge connects a bridge which failed to start in the background, with
+// an increasing delay between attempts, until it succeeds.
+func (r *Router) retryBridge(account string, err error) {
+	delay := startRetryDelay
+	for {
+		// the standby credentials are tried right away, but refused
+		// credentials don't get better with more attempts
+		failedOver := r.failOver(account, err)
+		if !failedOver && bridge.ErrorClassOf(err) == bridge.ErrorAuth {
+			r.setBridgeStatus(account, BridgeFailed, err)
+			r.logger.Errorf("%s. Not trying again, check its credentials", err)
+			r.alert(account, fmt.Sprintf("%s gave up connecting, its credentials were refused: %s", account, err))
+			return
+		}
+		r.setBridgeStatus(account, BridgeRetrying, err)
+		if !failedOver {
+			r.logger.Errorf("%s. Trying again in %s", err, delay)
+			if !r.sleep(delay) {
+				return
+			}
+			if delay *= 2; delay > maxStartRetryDelay {
+				delay = maxStartRetryDelay
+			}
+		}
+
+		br := r.getBridge(account)
+		r.setBridgeStatus(account, BridgeConnecting, nil)
+		r.logger.Infof("Starting bridge: %s ", account)
+		if err = br.Connect(); err != nil {
+			err = fmt.Errorf("Bridge %s failed to start: %w", account, classifyError(br, err))
+			continue
+		}
+		// Pattern channels are discovered by receiveMessage, which joins the
+		// channels afterwards.
+		select {
+		case r.Message <- config.Message{Event: eventBridgeStarted, Account: account}:
+		case <-r.stop:
+		}
+		return
+	}
+}
+
+// handleEventBridgeStarted discovers the pattern channels of a bridge which
+// connected after the router started, and joins its channels. Returns true if
+// msg was such an event.
+func (r *Router) handleEventBridgeStarted(msg *config.Message) bool {
+	if msg.Event != eventBridgeStarted {
+		return false
+	}
+	br := r.getBridge(msg.Account)
+	if br == nil {
+		return true
+	}
+	for _, gw := range r.ownerGateways(msg.Account) {
+		gw.discoverPatternChannels(br)
+	}
+	account := msg.Account
+	r.run(func() {
+		if err := br.JoinChannels(); err != nil {
+			r.logger.Errorf("Bridge %s failed to join channel: %s", account, err)
+		}
+		r.logger.Infof("Bridge %s started, relaying messages", account)
+		r.markBridgeStarted(account)
+	})
+	return true
+}
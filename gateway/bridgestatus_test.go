@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// warnBridger reports health warnings.
+type warnBridger struct {
+	bridge.Bridger
+	warnings []string
+}
+
+func (b *warnBridger) HealthWarnings() []string {
+	return b.warnings
+}
+
+func TestHealth(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	r.BridgeValues().General.AdminToken = "secret"
+	server := httptest.NewServer(r.adminHandler())
+	defer server.Close()
+
+	health := func() (int, Health) {
+		// no token, health checks are not authenticated
+		resp, err := http.Get(server.URL + "/healthz")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var health Health
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+		return resp.StatusCode, health
+	}
+
+	// nothing connected yet
+	code, h := health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthDegraded, h.Status)
+
+	for _, account := range r.sortedAccounts() {
+		r.markBridgeStarted(account)
+	}
+	code, h = health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthOK, h.Status)
+	assert.Len(t, h.Bridges, 3)
+
+	// bridges running with reduced functionality degrade the health
+	irc := r.getBridge(ircTestAccount)
+	orig := irc.Bridger
+	irc.Bridger = &warnBridger{Bridger: orig, warnings: []string{"missing intent"}}
+	code, h = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthDegraded, h.Status)
+	assert.Equal(t, []string{"missing intent"}, h.Bridges[0].Warnings)
+	irc.Bridger = orig
+
+	r.setBridgeStatus(tgTestAccount, BridgeRetrying, errors.New("unauthorized"))
+	code, h = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthDegraded, h.Status)
+	// ordered by account
+	tg := h.Bridges[2]
+	assert.Equal(t, tgTestAccount, tg.Account)
+	assert.Equal(t, BridgeRetrying, tg.State)
+	assert.Equal(t, 1, tg.Attempts)
+	assert.Equal(t, "unauthorized", tg.LastError)
+}
+
+// startBridger fails its first Connect calls, with an authentication error
+// when refused.
+type startBridger struct {
+	bridge.Bridger
+
+	failures int
+	refused  bool
+	calls    int
+}
+
+func (b *startBridger) Connect() error {
+	b.calls++
+	if b.failures > 0 {
+		b.failures--
+		if b.refused {
+			return bridge.NewError(bridge.ErrorAuth, errors.New("bad password"))
+		}
+		return bridge.NewError(bridge.ErrorNetwork, errors.New("connection refused"))
+	}
+	return nil
+}
+
+func (b *startBridger) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+func TestStartBridges(t *testing.T) {
+	defer func(delay time.Duration) { startRetryDelay = delay }(startRetryDelay)
+	startRetryDelay = 10 * time.Millisecond
+
+	// the routers are stopped before startRetryDelay is restored
+	var routers []*Router
+	defer func() {
+		for _, r := range routers {
+			r.Stop()
+		}
+	}()
+	newRouterFromConfig := func(general string, cfg string) *Router {
+		r := maketestRouter([]byte("[general]\n" + general + "\n" + cfg))
+		routers = append(routers, r)
+		for _, account := range r.sortedAccounts() {
+			br := r.getBridge(account)
+			br.Bridger = &startBridger{Bridger: br.Bridger}
+		}
+		r.getBridge(ircTestAccount).Bridger.(*startBridger).failures = 2
+		return r
+	}
+	newRouter := func(general string) *Router {
+		return newRouterFromConfig(general, string(testconfig3))
+	}
+
+	// A failing bridge aborts the startup by default
+	r := newRouter("")
+	err := r.Start()
+	assert.ErrorContains(t, err, "Bridge irc.zzz failed to start")
+
+	r = newRouter("IgnoreFailureOnStart=true")
+	assert.NoError(t, r.Start())
+	assert.True(t, r.bridgeStarted(tgTestAccount))
+	assert.True(t, r.bridgeStarted(slackTestAccount))
+	assert.False(t, r.bridgeStarted(ircTestAccount))
+
+	status := r.BridgeStatus()
+	assert.Len(t, status, 3)
+	assert.Equal(t, ircTestAccount, status[0].Account)
+	assert.Equal(t, BridgeConnected, status[1].State)
+
+	assert.Eventually(t, func() bool {
+		return r.bridgeStarted(ircTestAccount)
+	}, 5*time.Second, 10*time.Millisecond)
+	status = r.BridgeStatus()
+	assert.Equal(t, BridgeConnected, status[0].State)
+	assert.Zero(t, status[0].Attempts)
+	assert.Empty(t, status[0].LastError)
+
+	// An account optional in all its gateways doesn't abort the startup
+	optional := strings.ReplaceAll(string(testconfig3), `account="irc.zzz"`, "account=\"irc.zzz\"\noptional=true")
+	r = newRouterFromConfig("", optional)
+	assert.NoError(t, r.Start())
+	assert.False(t, r.bridgeStarted(ircTestAccount))
+	assert.Equal(t, HealthOK, r.Health().Status)
+	assert.True(t, r.BridgeStatus()[0].Optional)
+	assert.Eventually(t, func() bool {
+		return r.bridgeStarted(ircTestAccount)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	r = newRouterFromConfig("", strings.Replace(optional, "optional=true", "", 1))
+	assert.ErrorContains(t, r.Start(), "Bridge irc.zzz failed to start")
+
+	// Refused credentials aren't retried, even for optional accounts
+	r = newRouterFromConfig("", optional)
+	irc := r.getBridge(ircTestAccount).Bridger.(*startBridger)
+	irc.refused = true
+	assert.NoError(t, r.Start())
+	assert.Eventually(t, func() bool {
+		return r.BridgeStatus()[0].State == BridgeFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, r.BridgeStatus()[0].LastError, "bad password")
+	assert.Equal(t, HealthDegraded, r.Health().Status)
+	time.Sleep(5 * startRetryDelay)
+	assert.Equal(t, 1, irc.calls)
+	assert.False(t, r.bridgeStarted(ircTestAccount))
+}
+
+func TestSetBridgeStatus(t *testing.T) {
+	r := maketestRouter(testconfig3)
+
+	r.setBridgeStatus(ircTestAccount, BridgeRetrying, bridge.NewError(bridge.ErrorAuth, errors.New("bad password")))
+	r.setBridgeStatus(ircTestAccount, BridgeRetrying, errors.New("timeout"))
+	status := r.BridgeStatus()
+	assert.Len(t, status, 1)
+	assert.Equal(t, BridgeRetrying, status[0].State)
+	assert.Equal(t, 2, status[0].Attempts)
+	assert.Equal(t, "timeout", status[0].LastError)
+
+	r.setBridgeStatus(ircTestAccount, BridgeRetrying, bridge.NewError(bridge.ErrorAuth, errors.New("bad password")))
+	assert.Equal(t, "authentication failed, check the credentials or token of the account: bad password", r.BridgeStatus()[0].LastError)
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+)
+
+// dedupCaption sends the caption of the files of msg once to dest when the
+// text of msg repeats it, as telegram and whatsapp do: the protocols sending
+// the comments of the files with them don't get the text, the others don't get
+// the comments.
+func dedupCaption(msg *config.Message, dest *bridge.Bridge) {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" || msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return
+	}
+	dup := false
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && strings.TrimSpace(fi.Comment) == text {
+			dup = true
+			break
+		}
+	}
+	if !dup {
+		return
+	}
+
+	if _, ok := bridgemap.CaptionSupport[dest.Protocol]; ok {
+		msg.Text = ""
+		return
+	}
+
+	// msg shares Extra with the message sent to the other destinations
+	files := make([]any, len(msg.Extra["file"]))
+	for i, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && strings.TrimSpace(fi.Comment) == text {
+			fi.Comment = ""
+			f = fi
+		}
+		files[i] = f
+	}
+	extra := make(map[string][]any, len(msg.Extra))
+	for k, v := range msg.Extra {
+		extra[k] = v
+	}
+	extra["file"] = files
+	msg.Extra = extra
+}
@@ -0,0 +1,277 @@
+package gateway
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// channelPattern is a channel of a gateway definition matching several
+// channels, eg. channel="glob:#proj-*" or channel="re:#proj-(.+)". Without
+// one of these prefixes a channel name is always literal, like #c++.
+//
+// Matching channels are added to the gateway when the bridge lists them on
+// startup, or when a message is received from them. They are paired by the
+// part matched by the wildcards (or the regex groups): #proj-foo on irc is
+// only relayed to proj-foo on slack, like SameChannel does for exact names.
+type channelPattern struct {
+	re *regexp.Regexp
+	// glob patterns can be expanded back into a channel name for a key,
+	// so destination channels don't have to be discovered first.
+	glob string
+	info config.ChannelInfo
+}
+
+// The prefixes of the channel patterns.
+const (
+	channelGlobPrefix  = "glob:"
+	channelRegexPrefix = "re:"
+)
+
+// isChannelPattern returns true if the configured channel name is a pattern.
+func isChannelPattern(name string) bool {
+	return strings.HasPrefix(name, channelGlobPrefix) || strings.HasPrefix(name, channelRegexPrefix)
+}
+
+// compileChannelPattern returns the anchored regex for a channel pattern, and
+// the glob when it is one.
+func compileChannelPattern(name string) (*regexp.Regexp, string, error) {
+	if expr, ok := strings.CutPrefix(name, channelRegexPrefix); ok {
+		re, err := regexp.Compile("^(?:" + strings.TrimSuffix(strings.TrimPrefix(expr, "^"), "$") + ")$")
+		return re, "", err
+	}
+
+	glob, ok := strings.CutPrefix(name, channelGlobPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("not a channel pattern: %s", name)
+	}
+	if strings.ContainsRune(glob, '[') {
+		return nil, "", fmt.Errorf("character classes are only supported in regex channel patterns: %s", name)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString("(.*)")
+		case '?':
+			sb.WriteString("(.)")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	return re, glob, err
+}
+
+// match returns the pairing key of name when it matches the pattern.
+func (p *channelPattern) match(name string) (string, bool) {
+	groups := p.re.FindStringSubmatch(name)
+	if groups == nil {
+		return "", false
+	}
+	if len(groups) == 1 {
+		return groups[0], true
+	}
+	return strings.Join(groups[1:], "\x00"), true
+}
+
+// expand returns the channel name matching key, for glob patterns only.
+func (p *channelPattern) expand(key string) (string, bool) {
+	if p.glob == "" {
+		return "", false
+	}
+	parts := strings.Split(key, "\x00")
+	if strings.Count(p.glob, "*")+strings.Count(p.glob, "?") != len(parts) {
+		return "", false
+	}
+
+	var sb strings.Builder
+	for _, r := range p.glob {
+		if r != '*' && r != '?' {
+			sb.WriteRune(r)
+			continue
+		}
+		part := parts[0]
+		parts = parts[1:]
+		if r == '?' && len([]rune(part)) != 1 {
+			return "", false
+		}
+		sb.WriteString(part)
+	}
+	return sb.String(), true
+}
+
+// addChannelPattern records a channel pattern of the gateway configuration.
+func (gw *Gateway) addChannelPattern(br config.Bridge, direction string) {
+	for _, p := range gw.channelPatterns {
+		if p.info.Account == br.Account && p.info.Name == br.Channel {
+			if p.info.Direction != direction {
+				p.info.Direction = "inout"
+			}
+			return
+		}
+	}
+
+	re, glob, err := compileChannelPattern(br.Channel)
+	if err != nil {
+		gw.logger.Errorf("invalid channel pattern %q for %s: %s", br.Channel, br.Account, err)
+		return
+	}
+
+	gw.channelPatterns = append(gw.channelPatterns, &channelPattern{
+		re:   re,
+		glob: glob,
+		info: config.ChannelInfo{
+			Name:        br.Channel,
+			Account:     br.Account,
+			Direction:   direction,
+			Options:     br.Options,
+			SameChannel: map[string]bool{gw.Name: false},
+		},
+	})
+}
+
+// addPatternChannel adds the channel name of account to the gateway if it
+// matches one of its channel patterns. Returns whether it was added.
+func (gw *Gateway) addPatternChannel(account string, name string) bool {
+	if name == "" {
+		return false
+	}
+	if _, ok := gw.channels()[name+account]; ok {
+		return false
+	}
+
+	for _, p := range gw.channelPatterns {
+		if p.info.Account != account {
+			continue
+		}
+		key, ok := p.match(name)
+		if !ok {
+			continue
+		}
+		gw.addMatchedChannel(p, name, key)
+		return true
+	}
+	return false
+}
+
+func (gw *Gateway) addMatchedChannel(p *channelPattern, name string, key string) *config.ChannelInfo {
+	channel := p.info
+	channel.Name = name
+	channel.ID = name + p.info.Account
+	channel.PatternKey = key
+	channel.SameChannel = map[string]bool{gw.Name: false}
+	// the channels are read without lock by the other goroutines, they get a
+	// new map
+	gw.channelsMu.Lock()
+	channels := maps.Clone(gw.Channels)
+	channels[channel.ID] = &channel
+	gw.Channels = channels
+	gw.channelsMu.Unlock()
+
+	gw.logger.Infof("channel %s of %s matches %s, adding it to gateway %s", name, p.info.Account, p.info.Name, gw.Name)
+
+	if br, ok := gw.Bridges[p.info.Account]; ok {
+		br.Lock()
+		br.Channels[channel.ID] = channel
+		br.Unlock()
+	}
+	return &channel
+}
+
+// discoverPatternChannels adds the channels of br matching a channel pattern,
+// when the bridge can list them. It is called before joining channels.
+func (gw *Gateway) discoverPatternChannels(br *bridge.Bridge) {
+	hasPattern := false
+	for _, p := range gw.channelPatterns {
+		if p.info.Account == br.Account {
+			hasPattern = true
+			break
+		}
+	}
+	if !hasPattern {
+		return
+	}
+
+	lister, ok := br.Bridger.(bridge.ChannelLister)
+	if !ok {
+		gw.logger.Infof("%s cannot list its channels, channels matching patterns will be added when they receive a message", br.Account)
+		return
+	}
+
+	names, err := lister.ListChannels()
+	if err != nil {
+		gw.logger.Errorf("listing channels of %s failed: %s", br.Account, err)
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		gw.addPatternChannel(br.Account, name)
+	}
+}
+
+// expandPatternChannels adds, for a message received on a channel matched by
+// a pattern, the channels with the same key on dest which can be derived from
+// its glob patterns, and joins them.
+func (gw *Gateway) expandPatternChannels(key string, dest *bridge.Bridge) {
+	added := false
+	for _, p := range gw.channelPatterns {
+		if p.info.Account != dest.Account || !strings.Contains(p.info.Direction, "out") {
+			continue
+		}
+		name, ok := p.expand(key)
+		if !ok {
+			continue
+		}
+		if _, exists := gw.channels()[name+dest.Account]; exists {
+			continue
+		}
+		gw.addMatchedChannel(p, name, key)
+		added = true
+	}
+
+	if added {
+		if err := dest.JoinChannels(); err != nil {
+			gw.logger.Errorf("joining channels of %s failed: %s", dest.Account, err)
+		}
+		gw.postOnboardingNotices(dest.Account)
+	}
+}
+
+// handlePatternChannels adds the source channel of msg when it matches a
+// channel pattern, and the channels it is paired with on the other bridges.
+func (gw *Gateway) handlePatternChannels(msg *config.Message) {
+	if len(gw.channelPatterns) == 0 {
+		return
+	}
+
+	gw.addPatternChannel(msg.Account, msg.Channel)
+
+	src, ok := gw.channels()[getChannelID(msg)]
+	if !ok || src.PatternKey == "" || !strings.Contains(src.Direction, "in") {
+		return
+	}
+	for _, account := range gw.sortedBridgeAccounts() {
+		if account != msg.Account {
+			gw.expandPatternChannels(src.PatternKey, gw.Bridges[account])
+		}
+	}
+}
+
+func (gw *Gateway) sortedBridgeAccounts() []string {
+	accounts := make([]string, 0, len(gw.Bridges))
+	for account := range gw.Bridges {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigPatterns = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "projects"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "glob:#proj-*"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "glob:proj-*"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "re:(?:dev|proj)-(.+)"
+
+    [[gateway.out]]
+    account = "slack.test"
+    channel = "logs"
+
+    [[gateway.out]]
+    account = "irc.freenode"
+    channel = "#c++"
+	`)
+
+// patternBridger joins channels without a connection and lists fixed channels.
+type patternBridger struct {
+	bridge.Bridger
+
+	channels []string
+}
+
+func (b *patternBridger) JoinChannel(channel config.ChannelInfo) error {
+	return nil
+}
+
+func (b *patternBridger) ListChannels() ([]string, error) {
+	return b.channels, nil
+}
+
+func TestChannelPatterns(t *testing.T) {
+	r := maketestRouter(testconfigPatterns)
+	gw := r.Gateways["projects"]
+	for _, br := range gw.Bridges {
+		br.Bridger = &patternBridger{Bridger: br.Bridger}
+	}
+	irc := gw.Bridges["irc.freenode"]
+	slack := gw.Bridges["slack.test"]
+	discord := gw.Bridges["discord.test"]
+	irc.Bridger.(*patternBridger).channels = []string{"#other", "#proj-foo"}
+
+	// Patterns are never joined literally, and names without a prefix are
+	// never patterns
+	assert.Equal(t, []string{"#c++irc.freenode", "logsslack.test"}, mapKeys(gw.Channels))
+
+	gw.discoverPatternChannels(irc)
+	assert.Equal(t, "foo", gw.Channels["#proj-fooirc.freenode"].PatternKey)
+	assert.NotContains(t, gw.Channels, "#otherirc.freenode")
+
+	destNames := func(msg *config.Message, dest *bridge.Bridge) []string {
+		var names []string
+		for _, channel := range gw.getDestChannel(msg, dest) {
+			names = append(names, channel.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// Glob patterns are expanded on the other bridges, regexes are not
+	msg := &config.Message{Text: "hi", Channel: "#proj-foo", Account: "irc.freenode", Gateway: "projects", Protocol: "irc"}
+	gw.handlePatternChannels(msg)
+	assert.Equal(t, []string{"logs", "proj-foo"}, destNames(msg, slack))
+	assert.True(t, slack.Joined["proj-fooslack.test"])
+	assert.Nil(t, destNames(msg, discord))
+
+	// Regex groups are used as key
+	msg = &config.Message{Text: "hi", Channel: "dev-foo", Account: "discord.test", Gateway: "projects", Protocol: "discord"}
+	gw.handlePatternChannels(msg)
+	assert.Equal(t, []string{"#c++", "#proj-foo"}, destNames(msg, irc))
+	assert.Equal(t, []string{"logs", "proj-foo"}, destNames(msg, slack))
+
+	msg = &config.Message{Text: "hi", Channel: "proj-bar", Account: "discord.test", Gateway: "projects", Protocol: "discord"}
+	gw.handlePatternChannels(msg)
+	assert.Equal(t, []string{"#c++", "#proj-bar"}, destNames(msg, irc))
+
+	// Unmatched channels aren't added
+	msg = &config.Message{Text: "hi", Channel: "random", Account: "discord.test", Gateway: "projects", Protocol: "discord"}
+	gw.handlePatternChannels(msg)
+	assert.NotContains(t, gw.Channels, "randomdiscord.test")
+}
+
+func TestChannelPatternsConcurrentReads(t *testing.T) {
+	r := maketestRouter(testconfigPatterns)
+	gw := r.Gateways["projects"]
+
+	// the channels are read by other goroutines while patterns add channels
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			for range gw.channels() {
+			}
+		}
+	}()
+	for i := range 100 {
+		gw.addPatternChannel("irc.freenode", fmt.Sprintf("#proj-%d", i))
+	}
+	<-done
+	assert.Len(t, gw.channels(), 102)
+}
+
+func TestCompileChannelPattern(t *testing.T) {
+	p := &channelPattern{}
+	var err error
+	p.re, p.glob, err = compileChannelPattern("glob:#team-?-*")
+	assert.NoError(t, err)
+	key, ok := p.match("#team-a-dev")
+	assert.True(t, ok)
+	name, ok := p.expand(key)
+	assert.True(t, ok)
+	assert.Equal(t, "#team-a-dev", name)
+	_, ok = p.match("#team-ab-dev")
+	assert.False(t, ok)
+
+	_, _, err = compileChannelPattern("glob:#proj-[ab]")
+	assert.Error(t, err)
+	_, _, err = compileChannelPattern("re:proj-(.+")
+	assert.Error(t, err)
+
+	assert.True(t, isChannelPattern("re:#proj-.*"))
+	for _, name := range []string{"#c++", "#proj-*", "#what?", "(?:dev|proj)-(.+)"} {
+		assert.False(t, isChannelPattern(name), name)
+	}
+}
+
+func mapKeys(m map[string]*config.ChannelInfo) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
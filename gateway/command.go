@@ -0,0 +1,430 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+)
+
+// defaultCommandPrefix starts the control commands sent in channels, unless
+// CommandPrefix is set.
+const defaultCommandPrefix = "!bridge"
+
+// Command is a control command, run with "!bridge <name> <args>" in a channel
+// or through the native commands of the bridges (see bridge.CommandBridger).
+type Command struct {
+	Name  string
+	Usage string
+	Help  string
+	// Admin commands can only be run by the Admins of the account
+	Admin bool
+	// Run returns the reply to the command sent in msg.
+	Run func(r *Router, msg *config.Message, args []string) (string, error)
+}
+
+var commands = map[string]*Command{}
+
+// RegisterCommand adds cmd to the control commands, replacing the command with
+// the same name.
+func RegisterCommand(cmd *Command) {
+	commands[cmd.Name] = cmd
+}
+
+// sortedCommands returns the control commands ordered by name.
+func sortedCommands() []*Command {
+	cmds := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+var errCommandAccount = errors.New("an account is required")
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "help",
+		Help: "list the commands",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			lines := []string{"Commands:"}
+			for _, cmd := range sortedCommands() {
+				line := strings.TrimSpace(cmd.Name + " " + cmd.Usage)
+				if cmd.Admin {
+					line += " (admin)"
+				}
+				lines = append(lines, line+": "+cmd.Help)
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "status",
+		Help: "show the state of the bridges, with their errors for the admins",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			// the errors can tell about the servers and accounts
+			admin := false
+			if br := r.getBridge(msg.Account); br != nil {
+				admin = isAdmin(br, msg)
+			}
+			lines := []string{}
+			for _, status := range r.BridgeStatus() {
+				line := fmt.Sprintf("%s: %s", status.Account, status.State)
+				if status.Muted {
+					line += " (muted)"
+				}
+				if status.LastError != "" && admin {
+					line += fmt.Sprintf(" (%s)", status.LastError)
+				}
+				for _, warning := range status.Warnings {
+					line += fmt.Sprintf("\nwarning: %s", warning)
+				}
+				lines = append(lines, line)
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "queues",
+		Usage: "[account]",
+		Help:  "show the messages queued for unhealthy bridges",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			queues, err := r.Queues(strings.Join(args, ""))
+			if err != nil {
+				return "", err
+			}
+			if len(queues) == 0 {
+				return "No queued messages", nil
+			}
+			lines := []string{}
+			for _, queue := range queues {
+				lines = append(lines, fmt.Sprintf("%s: %d queued messages", queue.Account, len(queue.Messages)))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "replay",
+		Usage: "<account>",
+		Help:  "send the messages queued for account now",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errCommandAccount
+			}
+			result, err := r.ReplayQueue(args[0])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s: %d messages sent, %d remaining", result.Account, result.Processed, result.Remaining), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "drop",
+		Usage: "<account>",
+		Help:  "discard the messages queued for account",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errCommandAccount
+			}
+			result, err := r.DropQueue(args[0])
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s: %d messages dropped", result.Account, result.Processed), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "rejoin",
+		Usage: "<account>",
+		Help:  "join the channels of account again",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errCommandAccount
+			}
+			br := r.getBridge(args[0])
+			if br == nil {
+				return "", fmt.Errorf("unknown account %s", args[0])
+			}
+			br.ResetJoined()
+			if err := br.JoinChannels(); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s: channels joined", br.Account), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "announce",
+		Usage: "<text>",
+		Help:  "send text to the moderators of this channel and of the channels bridged with it",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) == 0 {
+				return "", errors.New("the announcement is empty")
+			}
+			if err := r.Announce(msg.Account, msg.Channel, msg.Username, strings.Join(args, " ")); err != nil {
+				return "", err
+			}
+			return "Announced", nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "schedule",
+		Usage: "<30m|18:00|2006-01-02 18:00> <text>",
+		Help:  "send text in this channel and relay it at the given time",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			at, rest, err := parseScheduleTime(args, time.Now())
+			if err != nil {
+				return "", err
+			}
+			scheduled, err := r.Schedule(ScheduledMessage{
+				At:       at,
+				Account:  msg.Account,
+				Channel:  msg.Channel,
+				Username: msg.Username,
+				Text:     strings.Join(rest, " "),
+			})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Scheduled message %s for %s", scheduled.ID, scheduled.At.Format(scheduleLayout)), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "scheduled",
+		Help: "list the scheduled messages",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			scheduled := r.ScheduledMessages()
+			if len(scheduled) == 0 {
+				return "No scheduled messages", nil
+			}
+			lines := []string{}
+			for _, s := range scheduled {
+				lines = append(lines, fmt.Sprintf("%s: %s in %s of %s: %s", s.ID, s.At.Format(scheduleLayout), s.Channel, s.Account, s.Text))
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "seen",
+		Usage: "<nick|user id>",
+		Help:  "show when and where a user last spoke, on any network",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) == 0 {
+				return "", errors.New("a nick or user id is required")
+			}
+			who := strings.Join(args, " ")
+			seen, ok := r.findSeen(who)
+			if !ok {
+				return fmt.Sprintf("%s hasn't been seen", who), nil
+			}
+			return fmt.Sprintf("%s was last seen %s (%s) in %s on %s", seen.Username, formatAgo(time.Since(seen.At)), seen.At.Format(scheduleLayout), seen.Channel, seen.Account), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "link",
+		Usage: "<message id>",
+		Help:  "show the links to the copies of a message on the other networks, or of the message replied to",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			id := msg.ParentID
+			if len(args) > 0 {
+				id = args[0]
+			}
+			if id == "" {
+				return "", errors.New("a message id, or a reply to the message, is required")
+			}
+			links := r.permalinks(msg.Account, id)
+			if len(links) == 0 {
+				return fmt.Sprintf("No links to the copies of message %s", id), nil
+			}
+			return strings.Join(links, "\n"), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "unschedule",
+		Usage: "<id>",
+		Help:  "cancel a scheduled message",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errors.New("a message id is required")
+			}
+			if err := r.Unschedule(args[0]); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Unscheduled message %s", args[0]), nil
+		},
+	})
+}
+
+// commandText returns the command of msg without the command prefix, and false
+// when msg isn't a control command for a bridge which has Commands enabled.
+func (r *Router) commandText(br *bridge.Bridge, msg *config.Message) (string, bool) {
+	enabled := br.GetBool("Commands")
+	for _, gw := range r.commandGateways(msg) {
+		enabled = enabled || gw.MyConfig.Commands
+	}
+	if !enabled {
+		return "", false
+	}
+
+	prefix := r.BridgeValues().General.CommandPrefix
+	if prefix == "" {
+		prefix = defaultCommandPrefix
+	}
+	switch {
+	case msg.Event == config.EventCommand:
+		// the prefix is optional in native commands
+		text := strings.TrimSpace(msg.Text)
+		if rest, ok := strings.CutPrefix(text, prefix); ok && (rest == "" || rest[0] == ' ') {
+			text = strings.TrimSpace(rest)
+		}
+		return text, true
+	case msg.Event == "":
+		rest, ok := strings.CutPrefix(strings.TrimSpace(msg.Text), prefix)
+		if !ok || rest != "" && rest[0] != ' ' {
+			return "", false
+		}
+		return strings.TrimSpace(rest), true
+	}
+	return "", false
+}
+
+// handleCommand runs msg when it is a control command, replying to its sender.
+// Returns true if msg was a command, which is not relayed.
+func (r *Router) handleCommand(msg *config.Message) bool {
+	br := r.getBridge(msg.Account)
+	if br == nil {
+		return false
+	}
+	text, ok := r.commandText(br, msg)
+	if !ok {
+		return msg.Event == config.EventCommand
+	}
+
+	go func(msg config.Message) {
+		reply := r.runCommand(br, &msg, text)
+		if err := r.replyCommand(br, &msg, reply); err != nil {
+			r.logger.Errorf("Replying to command %q on %s failed: %s", text, br.Account, err)
+		}
+	}(*msg)
+	return true
+}
+
+// runCommand runs the command text sent in msg and returns its reply.
+func (r *Router) runCommand(br *bridge.Bridge, msg *config.Message, text string) string {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+	cmd, ok := commands[strings.ToLower(args[0])]
+	if !ok {
+		return fmt.Sprintf("Unknown command %s, see help", args[0])
+	}
+	if !r.commandAllowed(br, msg) {
+		r.logger.Warnf("Refused command %q of %s (%s) on %s, not in CommandUserIDs", text, msg.Username, msg.UserID, br.Account)
+		return "The commands are restricted in this channel"
+	}
+	if cmd.Admin && !isAdmin(br, msg) {
+		r.logger.Warnf("Refused command %q of %s (%s) on %s", text, msg.Username, msg.UserID, br.Account)
+		return fmt.Sprintf("Command %s is restricted to the admins", cmd.Name)
+	}
+
+	r.logger.Infof("Running command %q of %s (%s) on %s", text, msg.Username, msg.UserID, br.Account)
+	reply, err := cmd.Run(r, msg, args[1:])
+	if err != nil {
+		return fmt.Sprintf("%s failed: %s", cmd.Name, err)
+	}
+	return reply
+}
+
+// commandGateways returns the gateways relaying the channel of msg.
+func (r *Router) commandGateways(msg *config.Message) []*Gateway {
+	if msg.Channel == "" {
+		return nil
+	}
+	gateways := []*Gateway{}
+	for _, gw := range r.sortedGateways() {
+		if _, ok := gw.channels()[getChannelID(msg)]; ok {
+			gateways = append(gateways, gw)
+		}
+	}
+	return gateways
+}
+
+// commandAllowed returns true if the sender of msg can run commands in its
+// channel: the admins always can, the others must be in the CommandUserIDs of
+// the gateways of the channel which set them. Like the Admins, these only
+// apply to the protocols whose user IDs can't be chosen by anyone.
+func (r *Router) commandAllowed(br *bridge.Bridge, msg *config.Message) bool {
+	if isAdmin(br, msg) {
+		return true
+	}
+	_, authenticated := bridgemap.AuthenticatedUserIDs[br.Protocol]
+	for _, gw := range r.commandGateways(msg) {
+		if len(gw.MyConfig.CommandUserIDs) == 0 {
+			continue
+		}
+		if !authenticated || !gw.listedUser(gw.MyConfig.CommandUserIDs, msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAdmin returns true if the sender of msg is one of the Admins of br. The
+// users of protocols whose user IDs can be chosen by anyone are never admins.
+func isAdmin(br *bridge.Bridge, msg *config.Message) bool {
+	if _, ok := bridgemap.AuthenticatedUserIDs[br.Protocol]; !ok {
+		return false
+	}
+	return msg.UserID != "" && slices.Contains(br.GetStringSlice("Admins"), msg.UserID)
+}
+
+// replyCommand sends reply to the sender of the command msg, natively for the
+// commands received natively.
+func (r *Router) replyCommand(br *bridge.Bridge, msg *config.Message, reply string) error {
+	if commander, ok := br.Bridger.(bridge.CommandBridger); ok && msg.Event == config.EventCommand {
+		return commander.ReplyCommand(*msg, reply)
+	}
+	// the reply is flagged as a bot message, eg. for the matrix notices
+	_, err := sendWithTimeout(br, config.Message{
+		Text:     reply,
+		Channel:  msg.Channel,
+		Account:  br.Account,
+		Protocol: br.Protocol,
+		ParentID: msg.ID,
+		Extra:    map[string][]any{config.ExtraBot: {true}},
+	})
+	return err
+}
+
+// registerCommands declares the control commands to the platform of account,
+// when it has Commands enabled and can receive them natively.
+func (r *Router) registerCommands(account string) {
+	br := r.getBridge(account)
+	commander, ok := br.Bridger.(bridge.CommandBridger)
+	if !ok || !br.GetBool("Commands") {
+		return
+	}
+
+	infos := []bridge.CommandInfo{}
+	for _, cmd := range sortedCommands() {
+		infos = append(infos, bridge.CommandInfo{Name: cmd.Name, Usage: cmd.Usage, Help: cmd.Help})
+	}
+	if err := commander.RegisterCommands(infos); err != nil {
+		r.logger.Errorf("Registering the commands of %s failed: %s", account, err)
+	}
+}
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// commandBridger records the replies to the native commands.
+type commandBridger struct {
+	joinBridger
+
+	replies chan string
+}
+
+func (b *commandBridger) RegisterCommands(commands []bridge.CommandInfo) error {
+	return nil
+}
+
+func (b *commandBridger) ReplyCommand(cmd config.Message, reply string) error {
+	b.replies <- reply
+	return nil
+}
+
+func TestCommands(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	irc := r.getBridge(ircTestAccount)
+	commander := &commandBridger{joinBridger: joinBridger{Bridger: irc.Bridger}, replies: make(chan string, 1)}
+	irc.Bridger = commander
+
+	// Commands are disabled by default
+	msg := &config.Message{Text: "!bridge help", Account: ircTestAccount, Channel: "#main"}
+	assert.False(t, r.handleCommand(msg))
+	assert.True(t, r.handleCommand(&config.Message{Event: config.EventCommand, Text: "help", Account: ircTestAccount}))
+
+	irc.SetBool("Commands", true)
+	defer irc.SetBool("Commands", false)
+	assert.False(t, r.handleCommand(&config.Message{Text: "!bridgehelp", Account: ircTestAccount, Channel: "#main"}))
+
+	// The commands sent in channels are answered there
+	assert.True(t, r.handleCommand(msg))
+	assert.Eventually(t, func() bool {
+		return slices.Equal(commander.getEvents(), []string{"send #main"})
+	}, time.Second, 10*time.Millisecond)
+
+	// The native commands are answered natively, without prefix
+	assert.True(t, r.handleCommand(&config.Message{Event: config.EventCommand, Text: "queues", Account: ircTestAccount}))
+	assert.Equal(t, "No queued messages", <-commander.replies)
+
+	sender := &config.Message{UserID: "alice@example.com"}
+	assert.Equal(t, "Unknown command bogus, see help", r.runCommand(irc, sender, "bogus"))
+	assert.Equal(t, "Command drop is restricted to the admins", r.runCommand(irc, sender, "drop "+ircTestAccount))
+	// anyone can take the ident@host of an admin on irc
+	irc.SetStringSlice("Admins", []string{"alice@example.com"})
+	defer irc.SetStringSlice("Admins", nil)
+	assert.Equal(t, "Command drop is restricted to the admins", r.runCommand(irc, sender, "drop "+ircTestAccount))
+
+	tg := r.getBridge(tgTestAccount)
+	tg.SetStringSlice("Admins", []string{"12345"})
+	defer tg.SetStringSlice("Admins", nil)
+	admin := &config.Message{UserID: "12345", Account: tgTestAccount}
+	assert.Equal(t, "irc.zzz: 0 messages dropped", r.runCommand(tg, admin, "drop "+ircTestAccount))
+	assert.Equal(t, "drop failed: an account is required", r.runCommand(tg, admin, "drop"))
+
+	// the errors of the bridges are shown to the admins only
+	r.setBridgeStatus(ircTestAccount, BridgeRetrying, errors.New("connection refused"))
+	assert.Contains(t, r.runCommand(tg, admin, "status"), "irc.zzz: retrying (connection refused)")
+	status := r.runCommand(irc, &config.Message{Account: ircTestAccount}, "status")
+	assert.Contains(t, status, "irc.zzz: retrying")
+	assert.NotContains(t, status, "connection refused")
+	assert.Contains(t, r.runCommand(irc, sender, ""), "drop <account> (admin): discard the messages queued for account")
+}
+
+// linkBridger links the messages to example.com.
+type linkBridger struct {
+	bridge.Bridger
+}
+
+func (b *linkBridger) Permalink(channel string, id string) (string, error) {
+	if id == "gone" {
+		return "", errors.New("unknown message")
+	}
+	return "https://example.com/" + channel + "/" + id, nil
+}
+
+func TestLink(t *testing.T) {
+	r, gw := newTestGateway()
+	irc, slack, tg := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount], gw.Bridges[tgTestAccount]
+	irc.Bridger, slack.Bridger = &linkBridger{Bridger: irc.Bridger}, &linkBridger{Bridger: slack.Bridger}
+	gw.Messages.Add("telegram 1", []*BrMsgID{
+		{irc, "irc a", "#main" + ircTestAccount},
+		{slack, "slack b", "irc" + slackTestAccount},
+	})
+	gw.Messages.Add("telegram 2", []*BrMsgID{
+		{slack, "slack gone", "irc" + slackTestAccount},
+	})
+
+	assert.Equal(t, "irc.zzz #main: https://example.com/#main/a\nslack.zzz irc: https://example.com/irc/b",
+		r.runCommand(tg, &config.Message{Account: tgTestAccount}, "link 1"))
+	// from a copy, by replying to it
+	assert.Equal(t, "slack.zzz irc: https://example.com/irc/b",
+		r.runCommand(irc, &config.Message{Account: ircTestAccount, ParentID: "a"}, "link"))
+	assert.Equal(t, "No links to the copies of message 2", r.runCommand(tg, &config.Message{Account: tgTestAccount}, "link 2"))
+	assert.Equal(t, "No links to the copies of message 3", r.runCommand(tg, &config.Message{Account: tgTestAccount}, "link 3"))
+	assert.Equal(t, "link failed: a message id, or a reply to the message, is required", r.runCommand(tg, &config.Message{Account: tgTestAccount}, "link"))
+}
+
+func TestGatewayCommands(t *testing.T) {
+	r, gw := newTestGateway()
+	irc, tg := gw.Bridges[ircTestAccount], gw.Bridges[tgTestAccount]
+	msg := &config.Message{Text: "!bridge version", Account: ircTestAccount, Channel: "#main"}
+	_, ok := r.commandText(irc, msg)
+	assert.False(t, ok)
+
+	// the gateway enables the commands in its channels only
+	gw.MyConfig.Commands = true
+	text, ok := r.commandText(irc, msg)
+	assert.True(t, ok)
+	assert.Equal(t, "version", text)
+	_, ok = r.commandText(irc, &config.Message{Text: "!bridge version", Account: ircTestAccount, Channel: "#other"})
+	assert.False(t, ok)
+	assert.Contains(t, r.runCommand(irc, msg, text), "matterbridge ")
+
+	// CommandUserIDs restricts them to the listed users, never on irc
+	gw.MyConfig.CommandUserIDs = []string{tgTestAccount + "/12345"}
+	alice := &config.Message{UserID: "12345", Account: tgTestAccount, Channel: "-1111111111111"}
+	bob := &config.Message{UserID: "67890", Account: tgTestAccount, Channel: "-1111111111111"}
+	assert.Contains(t, r.runCommand(tg, alice, "version"), "matterbridge ")
+	assert.Equal(t, "The commands are restricted in this channel", r.runCommand(tg, bob, "version"))
+	assert.Equal(t, "The commands are restricted in this channel", r.runCommand(irc, &config.Message{UserID: "12345", Account: ircTestAccount, Channel: "#main"}, "version"))
+	// the admins can run them anyway
+	tg.SetStringSlice("Admins", []string{"67890"})
+	defer tg.SetStringSlice("Admins", nil)
+	assert.Contains(t, r.runCommand(tg, bob, "version"), "matterbridge ")
+	assert.Equal(t, "Command mute is restricted to the admins", r.runCommand(tg, alice, "mute "+ircTestAccount))
+
+	// the messages from and to a muted account aren't relayed
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	r.markBridgeStarted(ircTestAccount)
+	assert.Equal(t, "irc.zzz: muted, send unmute irc.zzz to relay its messages again", r.runCommand(tg, bob, "mute "+ircTestAccount))
+	assert.Equal(t, "irc.zzz is already muted", r.runCommand(tg, bob, "mute "+ircTestAccount))
+	assert.Equal(t, "mute failed: unknown account irc.bogus", r.runCommand(tg, bob, "mute irc.bogus"))
+	assert.Contains(t, r.runCommand(tg, bob, "status"), "irc.zzz: connected (muted)")
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "hello", Account: ircTestAccount, Channel: "#main"}))
+	relayed := &config.Message{Text: "hello", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram", Gateway: "bridge"}
+	r.relayMessage(gw, relayed)
+	assert.Empty(t, flaky.sent)
+
+	assert.Equal(t, "irc.zzz: unmuted", r.runCommand(tg, bob, "unmute "+ircTestAccount))
+	assert.Equal(t, "irc.zzz isn't muted", r.runCommand(tg, bob, "unmute "+ircTestAccount))
+	assert.False(t, gw.ignoreMessage(&config.Message{Text: "hello", Account: ircTestAccount, Channel: "#main"}))
+	r.relayMessage(gw, relayed)
+	assert.Len(t, flaky.sent, 1)
+}
@@ -0,0 +1,21 @@
+package gateway
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogBuffer(t *testing.T) {
+	logs := newLogBuffer()
+	for i := range recentLogSize + 2 {
+		require.NoError(t, logs.Fire(&logrus.Entry{Message: strconv.Itoa(i), Data: logrus.Fields{"prefix": "test"}}))
+	}
+	lines := logs.Lines()
+	require.Len(t, lines, recentLogSize)
+	assert.Contains(t, lines[0], "test: 2")
+	assert.Contains(t, lines[recentLogSize-1], "test: "+strconv.Itoa(recentLogSize+1))
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// eventTemplateSetting returns the setting of the template of the text of the
+// event of msg, empty for the events without one. The other join/leave events
+// (eg. irc quits) are leaves.
+func eventTemplateSetting(msg *config.Message) string {
+	switch msg.Event {
+	case config.EventJoin:
+		return "JoinTemplate"
+	case config.EventLeave, config.EventJoinLeave:
+		return "LeaveTemplate"
+	case config.EventTopicChange:
+		return "TopicTemplate"
+	}
+	return ""
+}
+
+// applyEventTemplate replaces the text of the join, leave and topic change
+// events sent to dest by the template of dest for the event, if it has one.
+// rmsg is the message as received, msg the one being sent. The text sent is
+// the template alone, without the nick.
+func (gw *Gateway) applyEventTemplate(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge) {
+	setting := eventTemplateSetting(msg)
+	if setting == "" {
+		return
+	}
+	// the topic changes set the topic of the channels with SyncTopic
+	if msg.Event == config.EventTopicChange && dest.GetBool("SyncTopic") {
+		return
+	}
+	template := dest.GetString(setting)
+	if template == "" {
+		return
+	}
+
+	name, label := "", ""
+	if br, ok := gw.Bridges[rmsg.Account]; ok {
+		name, label = br.Name, br.GetString("Label")
+	}
+	msg.Text = strings.NewReplacer(
+		"{NICK}", rmsg.Username,
+		"{USERID}", rmsg.UserID,
+		"{CHANNEL}", rmsg.Channel,
+		"{PROTOCOL}", getProtocol(rmsg),
+		"{BRIDGE}", name,
+		"{LABEL}", label,
+		"{GATEWAY}", gw.Name,
+		"{TEXT}", rmsg.Text,
+	).Replace(template)
+	msg.Username = ""
+}
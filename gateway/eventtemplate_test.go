@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTemplate(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+JoinTemplate="→ {NICK} joined {CHANNEL} on {PROTOCOL}"
+TopicTemplate="{NICK} {TEXT} ({GATEWAY})"
+[slack.zzz]
+server=""
+Label="work"
+LeaveTemplate="← {NICK} ({USERID}) left {LABEL}"
+TopicTemplate="ignored"
+SyncTopic=true
+
+[[gateway]]
+name="bridge"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`))
+	gw := r.Gateways["bridge"]
+	irc, slack := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount]
+	apply := func(rmsg config.Message, dest *bridge.Bridge) config.Message {
+		msg := rmsg
+		msg.Username = "[formatted] "
+		gw.applyEventTemplate(&rmsg, &msg, dest)
+		return msg
+	}
+
+	join := config.Message{Event: config.EventJoin, Username: "alice", UserID: "U1", Text: "joins", Account: slackTestAccount, Channel: "main"}
+	msg := apply(join, irc)
+	assert.Equal(t, "→ alice joined main on slack", msg.Text)
+	assert.Empty(t, msg.Username)
+	msg = apply(config.Message{Event: config.EventTopicChange, Username: "alice", Text: "changed the topic to hello", Account: slackTestAccount, Channel: "main"}, irc)
+	assert.Equal(t, "alice changed the topic to hello (bridge)", msg.Text)
+
+	// the events without a template are left as is
+	msg = apply(config.Message{Event: config.EventLeave, Username: "bob", Text: "parts", Account: slackTestAccount, Channel: "main"}, irc)
+	assert.Equal(t, "parts", msg.Text)
+	assert.Equal(t, "[formatted] ", msg.Username)
+	msg = apply(config.Message{Username: "bob", Text: "hello", Account: slackTestAccount, Channel: "main"}, irc)
+	assert.Equal(t, "hello", msg.Text)
+
+	// the quits are leaves, the labels are the ones of the source
+	msg = apply(config.Message{Event: config.EventJoinLeave, Username: "bob", UserID: "bob@host", Text: "quits", Account: ircTestAccount, Channel: "#main"}, slack)
+	assert.Equal(t, "← bob (bob@host) left ", msg.Text)
+
+	// the topic is synced as is
+	msg = apply(config.Message{Event: config.EventTopicChange, Username: "bob", Text: "hello", Account: ircTestAccount, Channel: "#main"}, slack)
+	assert.Equal(t, "hello", msg.Text)
+}
@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+	"github.com/sirupsen/logrus"
+)
+
+var testconfig = []byte(`
+[irc.freenode]
+server=""
+[mattermost.test]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+	`)
+
+var testconfig2 = []byte(`
+[irc.freenode]
+server=""
+[mattermost.test]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.in]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.out]]
+    account="slack.test"
+    channel="testing"
+[[gateway]]
+    name = "bridge2"
+    enable=true
+
+    [[gateway.in]]
+    account = "irc.freenode"
+    channel = "#wimtesting2"
+
+    [[gateway.out]]
+    account = "discord.test"
+    channel = "general2"
+	`)
+
+var testconfig3 = []byte(`
+[irc.zzz]
+server=""
+[telegram.zzz]
+server=""
+[slack.zzz]
+server=""
+[[gateway]]
+name="bridge"
+enable=true
+
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+
+    [[gateway.inout]]
+    account="telegram.zzz"
+    channel="-1111111111111"
+
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="irc"
+
+[[gateway]]
+name="announcements"
+enable=true
+
+    [[gateway.in]]
+    account="telegram.zzz"
+    channel="-2222222222222"
+
+    [[gateway.out]]
+    account="irc.zzz"
+    channel="#main"
+
+    [[gateway.out]]
+    account="irc.zzz"
+    channel="#main-help"
+
+    [[gateway.out]]
+    account="telegram.zzz"
+    channel="--333333333333"
+
+    [[gateway.out]]
+    account="slack.zzz"
+    channel="general"
+
+[[gateway]]
+name="bridge2"
+enable=true
+
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main-help"
+
+    [[gateway.inout]]
+    account="telegram.zzz"
+    channel="--444444444444"
+
+
+[[gateway]]
+name="bridge3"
+enable=true
+
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main-telegram"
+
+    [[gateway.inout]]
+    account="telegram.zzz"
+    channel="--333333333333"
+`)
+
+const (
+	ircTestAccount   = "irc.zzz"
+	tgTestAccount    = "telegram.zzz"
+	slackTestAccount = "slack.zzz"
+)
+
+func maketestRouter(input []byte) *Router {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, input)
+	r, err := NewRouter(logger, cfg, bridgemap.FullMap)
+	if err != nil {
+		fmt.Println(err)
+	}
+	return r
+}
+
+// newTestGateway returns the router of testconfig3 and its gateway "bridge",
+// which relays irc.zzz, telegram.zzz and slack.zzz.
+func newTestGateway() (*Router, *Gateway) {
+	r := maketestRouter(testconfig3)
+	return r, r.Gateways["bridge"]
+}
@@ -1,36 +1,51 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
-	"github.com/d5/tengo/v2"
-	"github.com/d5/tengo/v2/stdlib"
 	lru "github.com/hashicorp/golang-lru"
-	"github.com/kyokomi/emoji/v2"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
-	"github.com/matterbridge-org/matterbridge/internal"
+	"github.com/matterbridge-org/matterbridge/gateway/samechannel"
 	"github.com/sirupsen/logrus"
 )
 
 type Gateway struct {
 	config.Config
 
-	Router         *Router
-	MyConfig       *config.Gateway
-	Bridges        map[string]*bridge.Bridge
+	Router   *Router
+	MyConfig *config.Gateway
+	Bridges  map[string]*bridge.Bridge
+	// Channels is replaced when a channel pattern matches, see channels
 	Channels       map[string]*config.ChannelInfo
 	ChannelOptions map[string]config.ChannelOptions
 	Message        chan config.Message
 	Name           string
 	Messages       *lru.Cache
 
+	channelsMu sync.RWMutex
+	// channelPatterns are the channels of MyConfig with wildcards
+	channelPatterns []*channelPattern
+	// media handles the files of the messages, see processFiles
+	media mediaPool
+	// onboarding records where the OnboardingNotice was posted
+	onboarding *onboarding
+	// limiter holds the rate of the users and channels, see admitMessage
+	limiter *rateLimiter
+	// reconnectDelay is the time between the disconnection of a bridge and
+	// its reconnection
+	reconnectDelay time.Duration
+
 	logger *logrus.Entry
 }
 
@@ -40,9 +55,20 @@ type BrMsgID struct {
 	ChannelID string
 }
 
+// errProtocolDisabled is returned by AddBridge for the accounts of the
+// protocols listed in DisabledProtocols.
+var errProtocolDisabled = errors.New("protocol is disabled")
+
+// defaultReconnectDelay is the time between the disconnection of a bridge and
+// its reconnection.
+const defaultReconnectDelay = 5 * time.Second
+
 const apiProtocol = "api"
 const ircProtocol = "irc"
 
+// stripNickRE matches everything StripNick removes from a nick.
+var stripNickRE = regexp.MustCompile("[^a-zA-Z0-9]+")
+
 // AddBridge sets up a new bridge on startup.
 //
 // It's added in the gateway object with the specified configuration, and is
@@ -52,43 +78,114 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 	if br == nil {
 		gw.checkConfig(cfg)
 		br = bridge.New(cfg)
+		factory, err := gw.Router.bridgeFactory(br.Protocol)
+		if errors.Is(err, errProtocolDisabled) {
+			return err
+		}
+		if err != nil {
+			return gw.setupFailed(cfg, fmt.Errorf("%w, used by account %s in gateway %s", err, cfg.Account, gw.Name))
+		}
 		br.Config = gw.Router.Config
 		br.General = &gw.BridgeValues().General
+		br.Store = gw.Router.store
 		br.Log = gw.logger.WithFields(logrus.Fields{"prefix": br.Protocol})
 
 		// Instantiate bridge's HTTP client
 		http_client, err := br.NewHttpClient(br.GetString("http_proxy"))
 		if err != nil {
-			br.Log.Fatalf("config failure for account %s, HTTP settings incorrect", br.Account)
+			return gw.setupFailed(cfg, fmt.Errorf("config failure for account %s, HTTP settings incorrect: %w", br.Account, err))
 		}
 
 		br.HttpClient = http_client
 
+		budget := bridgemap.APIRateBudgets[br.Protocol]
+		if br.IsKeySet("APIRateBudget") {
+			budget = br.GetInt("APIRateBudget")
+		}
+		br.Budget = bridge.NewAPIBudget(budget)
+
 		brconfig := &bridge.Config{
 			Remote: gw.Message,
 			Bridge: br,
 		}
-		// add the actual bridger for this protocol to this bridge using the bridgeMap
-		if _, ok := gw.Router.BridgeMap[br.Protocol]; !ok {
-			gw.logger.Fatalf("Incorrect protocol %s specified in gateway configuration %s, exiting.", br.Protocol, cfg.Account)
+		br.Bridger = factory(brconfig)
+		if translator, ok := br.Bridger.(bridge.MentionTranslator); ok {
+			account := br.Account
+			translator.SetMentionNames(func() map[string]string { return gw.Router.mentionNames(account) })
 		}
-		br.Bridger = gw.Router.BridgeMap[br.Protocol](brconfig)
 	}
 	gw.mapChannelsToBridge(br)
 	gw.Bridges[cfg.Account] = br
+	gw.Router.registerBridge(gw.Name, br)
 	return nil
 }
 
+// setupFailed returns err for an optional account, so that the gateway runs
+// without it, and exits otherwise.
+func (gw *Gateway) setupFailed(cfg *config.Bridge, err error) error {
+	if !cfg.Optional {
+		gw.logger.Fatalf("%s, exiting.", err)
+	}
+	return err
+}
+
+// bridgeFactory returns the factory of protocol from the bridgeMap, unless
+// the protocol is listed in DisabledProtocols or wasn't compiled in.
+func (r *Router) bridgeFactory(protocol string) (bridge.Factory, error) {
+	for _, disabled := range r.BridgeValues().General.DisabledProtocols {
+		if strings.EqualFold(disabled, protocol) {
+			return nil, errProtocolDisabled
+		}
+	}
+	if factory, ok := r.BridgeMap[protocol]; ok {
+		return factory, nil
+	}
+	if tag, ok := bridgemap.BuildTags[protocol]; ok {
+		return nil, fmt.Errorf("protocol %s isn't available, this matterbridge was built with the %s tag (build it without -tags %s)", protocol, tag, tag)
+	}
+	return nil, fmt.Errorf("incorrect protocol %s", protocol)
+}
+
 // AddConfig associates a new configuration with the gateway object.
 func (gw *Gateway) AddConfig(cfg *config.Gateway) error {
 	gw.Name = cfg.Name
 	gw.MyConfig = cfg
+	switch strings.ToLower(cfg.BotMessages) {
+	case "", config.BotMessagesTag, config.BotMessagesRelay, config.BotMessagesDrop:
+	default:
+		gw.logger.Warnf("Unknown BotMessages %q for gateway %s, bot messages will be tagged", cfg.BotMessages, cfg.Name)
+	}
+	switch strings.ToLower(cfg.EphemeralMessages) {
+	case "", config.EphemeralMessagesTag, config.EphemeralMessagesDrop, config.EphemeralMessagesExpire:
+	default:
+		gw.logger.Warnf("Unknown EphemeralMessages %q for gateway %s, ephemeral messages will be tagged", cfg.EphemeralMessages, cfg.Name)
+	}
+	switch strings.ToLower(cfg.RateLimitAction) {
+	case "", config.RateLimitDrop, config.RateLimitQueue, config.RateLimitCollapse:
+	default:
+		gw.logger.Warnf("Unknown RateLimitAction %q for gateway %s, messages over the rate limits will be dropped", cfg.RateLimitAction, cfg.Name)
+	}
+	gw.checkLongMessages()
+	gw.checkPastes()
 	if err := gw.mapChannels(); err != nil {
 		gw.logger.Errorf("mapChannels() failed: %s", err)
 	}
 	for _, br := range append(gw.MyConfig.In, append(gw.MyConfig.InOut, gw.MyConfig.Out...)...) {
 		br := br // scopelint
+		// An account can be listed several times in a gateway (other channels
+		// or directions), it still only needs to be added once.
+		if _, ok := gw.Bridges[br.Account]; ok {
+			continue
+		}
 		err := gw.AddBridge(&br)
+		if errors.Is(err, errProtocolDisabled) {
+			gw.logger.Warnf("Account %s of gateway %s is not started, its protocol is in DisabledProtocols", br.Account, gw.Name)
+			continue
+		}
+		if err != nil && br.Optional {
+			gw.logger.Errorf("Optional account %s of gateway %s is not started: %s", br.Account, gw.Name, err)
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -137,6 +234,10 @@ func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
 		Config:   r.Config,
 		Messages: cache,
 		logger:   logger,
+
+		onboarding:     newOnboarding(),
+		limiter:        newRateLimiter(),
+		reconnectDelay: defaultReconnectDelay,
 	}
 	err := gw.AddConfig(cfg)
 	if err != nil {
@@ -166,8 +267,10 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 		}
 	}
 
-	// Too noisy to log like other events
+	// Too noisy to log like other events. Only format the message when it will
+	// actually be logged, as %#v of a whole message is costly.
 	debugSendMessage := ""
+	debugEnabled := gw.logger.Logger.IsLevelEnabled(logrus.DebugLevel)
 
 	switch msg.Event {
 	case config.EventNoticeIRC: // Only send irc notices to IRC
@@ -179,7 +282,9 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 	case config.EventFileDelete: // exclude file delete event as the msg ID here is the native file ID that needs to be deleted
 		break
 	default:
-		debugSendMessage = fmt.Sprintf("=> Sending %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
+		if debugEnabled {
+			debugSendMessage = fmt.Sprintf("=> Sending %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
+		}
 		msg.ID = gw.getDestMsgID(rmsg.Protocol+" "+rmsg.ID, dest, channel)
 	}
 
@@ -189,6 +294,7 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 		msg.Channel = channel.Name
 	}
 
+	gw.defaultNick(&msg)
 	gw.modifyAvatar(&msg, dest)
 	errNick := gw.modifyUsername(&msg, dest)
 
@@ -198,6 +304,7 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 		// We either switched to a fallback nick, or are dropping the message.
 		return "", errNick
 	}
+	gw.applyEventTemplate(rmsg, &msg, dest)
 
 	msg.ParentID = gw.getDestMsgID(canonicalParentMsgID, dest, channel)
 	if msg.ParentID == "" {
@@ -210,6 +317,32 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 		msg.ParentID = config.ParentIDNotFound
 	}
 
+	dedupCaption(&msg, dest)
+
+	if dest.GetBool("EmojiShortcodes") {
+		msg.Text = helper.EmojiToShortcodes(msg.Text)
+	}
+
+	if fwd, ok := msg.GetForward(); ok {
+		_, blockquote := bridgemap.BlockquoteSupport[dest.Protocol]
+		msg.Text = helper.FormatForward(msg.Text, fwd, blockquote)
+	}
+
+	// "||" is only a spoiler in messages of protocols which have spoilers
+	if _, ok := bridgemap.SpoilerSupport[getProtocol(rmsg)]; ok {
+		if _, ok := bridgemap.SpoilerSupport[dest.Protocol]; !ok {
+			msg.Text = helper.HideSpoilers(msg.Text, dest.GetString("SpoilerFormat"))
+		}
+	}
+
+	if gw.ephemeralTagged(&msg) {
+		msg.Text = dest.GetString("EphemeralTag") + msg.Text
+	}
+
+	gw.uploadPaste(&msg, dest, channel)
+
+	announceFallback(&msg, dest)
+
 	drop, err := gw.modifyOutMessageTengo(rmsg, &msg, dest)
 	if err != nil {
 		gw.logger.Errorf("modifySendMessageTengo: %s", err)
@@ -230,11 +363,25 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 		gw.Router.MattermostPlugin <- msg
 	}
 
-	defer func(t time.Time) {
-		gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, time.Since(t))
-	}(time.Now())
+	if debugEnabled {
+		defer func(t time.Time) {
+			gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, time.Since(t))
+		}(time.Now())
+	}
+
+	// channels joined lazily are joined before their first message, ahead of
+	// the channels joined in the background
+	if dest.GetBool("LazyJoin") {
+		if err := dest.JoinChannelByID(channel.ID); err != nil {
+			gw.logger.Errorf("joining %s of %s failed: %s", channel.Name, dest.Account, err)
+		}
+	}
 
-	mID, err := dest.Send(msg)
+	canonicalID := ""
+	if rmsg.ID != "" {
+		canonicalID = rmsg.Protocol + " " + rmsg.ID
+	}
+	mID, err := gw.pacedSend(dest, msg, channel.ID, canonicalID)
 	if err != nil {
 		return mID, err
 	}
@@ -252,40 +399,96 @@ func (gw *Gateway) SendMessage( //nolint:gocyclo,funlen
 //
 // This is not triggered when config is reloaded from disk.
 func (gw *Gateway) checkConfig(cfg *config.Bridge) {
-	match := false
-	for _, key := range gw.Router.Config.Viper().AllKeys() {
-		if strings.HasPrefix(key, strings.ToLower(cfg.Account)) {
-			match = true
-			break
-		}
-	}
-	if !match {
+	if !gw.Router.accountConfigured(cfg.Account) {
 		gw.logger.Fatalf("Account %s defined in gateway %s but no configuration found, exiting.", cfg.Account, gw.Name)
 	}
 }
 
+// accountConfigured returns true if the configuration has a section for
+// account.
+func (r *Router) accountConfigured(account string) bool {
+	for _, key := range r.Config.Viper().AllKeys() {
+		if strings.HasPrefix(key, strings.ToLower(account)) {
+			return true
+		}
+	}
+	return false
+}
+
 func (gw *Gateway) mapChannelsToBridge(br *bridge.Bridge) {
-	for ID, channel := range gw.Channels {
+	br.Lock()
+	defer br.Unlock()
+	for ID, channel := range gw.channels() {
 		if br.Account == channel.Account {
 			br.Channels[ID] = *channel
 		}
 	}
 }
 
+// reconnectBridge reconnects br through the first gateway using it, see
+// Gateway.reconnectBridge.
+func (r *Router) reconnectBridge(br *bridge.Bridge) {
+	for _, gw := range r.sortedGateways() {
+		if _, ok := gw.Bridges[br.Account]; ok {
+			gw.reconnectBridge(br)
+			return
+		}
+	}
+}
+
+// reconnectBridge disconnects br and connects it again until it succeeds. A
+// bridge is reconnected once at a time: when it is already reconnecting, it
+// waits for the reconnection to end instead.
 func (gw *Gateway) reconnectBridge(br *bridge.Bridge) {
+	r := gw.Router
+	r.statusMu.Lock()
+	if done, ok := r.reconnecting[br.Account]; ok {
+		r.statusMu.Unlock()
+		gw.logger.Debugf("%s is already reconnecting", br.Account)
+		<-done
+		return
+	}
+	done := make(chan struct{})
+	r.reconnecting[br.Account] = done
+	r.statusMu.Unlock()
+	defer func() {
+		r.statusMu.Lock()
+		delete(r.reconnecting, br.Account)
+		r.statusMu.Unlock()
+		close(done)
+	}()
+
 	if err := br.Disconnect(); err != nil {
 		gw.logger.Errorf("Disconnect() %s failed: %s", br.Account, err)
 	}
-	time.Sleep(time.Second * 5)
+	if !r.sleep(gw.reconnectDelay) {
+		return
+	}
 RECONNECT:
 	gw.logger.Infof("Reconnecting %s", br.Account)
+	gw.Router.setBridgeStatus(br.Account, BridgeConnecting, nil)
 	err := br.Connect()
 	if err != nil {
+		err = classifyError(br, err)
+		if gw.Router.failOver(br.Account, err) {
+			gw.Router.setBridgeStatus(br.Account, BridgeRetrying, err)
+			goto RECONNECT
+		}
+		if bridge.ErrorClassOf(err) == bridge.ErrorAuth {
+			gw.Router.setBridgeStatus(br.Account, BridgeFailed, err)
+			gw.logger.Errorf("Reconnection failed: %s. Not trying again, check its credentials", err)
+			gw.Router.alert(br.Account, fmt.Sprintf("%s gave up reconnecting, its credentials were refused: %s", br.Account, err))
+			return
+		}
+		gw.Router.setBridgeStatus(br.Account, BridgeRetrying, err)
 		gw.logger.Errorf("Reconnection failed: %s. Trying again in 60 seconds", err)
-		time.Sleep(time.Second * 60)
+		if !r.sleep(time.Second * 60) {
+			return
+		}
 		goto RECONNECT
 	}
-	br.Joined = make(map[string]bool)
+	gw.Router.setBridgeStatus(br.Account, BridgeConnected, nil)
+	br.ResetJoined()
 	if err := br.JoinChannels(); err != nil {
 		gw.logger.Errorf("JoinChannels() %s failed: %s", br.Account, err)
 	}
@@ -296,17 +499,17 @@ func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
 		if isAPI(br.Account) {
 			br.Channel = apiProtocol
 		}
-		// make sure to lowercase irc channels in config #348
-		if strings.HasPrefix(br.Account, "irc.") {
+		// make sure to lowercase irc channels in config #348, but keep regex escapes like \W
+		if strings.HasPrefix(br.Account, "irc.") && !strings.HasPrefix(br.Channel, channelRegexPrefix) {
 			br.Channel = strings.ToLower(br.Channel)
 		}
-		if strings.HasPrefix(br.Account, "mattermost.") && strings.HasPrefix(br.Channel, "#") {
-			gw.logger.Errorf("Mattermost channels do not start with a #: remove the # in %s", br.Channel)
+		if err := checkChannelConfig(br); err != nil {
+			gw.logger.Error(err)
 			os.Exit(1)
 		}
-		if strings.HasPrefix(br.Account, "zulip.") && !strings.Contains(br.Channel, "/topic:") {
-			gw.logger.Errorf("Breaking change, since matterbridge 1.14.0 zulip channels need to specify the topic with channel/topic:mytopic in %s of %s", br.Channel, br.Account)
-			os.Exit(1)
+		if isChannelPattern(br.Channel) {
+			gw.addChannelPattern(br, direction)
+			continue
 		}
 		ID := br.Channel + br.Account
 		if _, ok := gw.Channels[ID]; !ok {
@@ -330,6 +533,25 @@ func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
 	}
 }
 
+// channels returns the channels of the gateway. The map must not be modified.
+func (gw *Gateway) channels() map[string]*config.ChannelInfo {
+	gw.channelsMu.RLock()
+	defer gw.channelsMu.RUnlock()
+	return gw.Channels
+}
+
+// checkChannelConfig returns an error for the channels which can't be relayed
+// as configured.
+func checkChannelConfig(br config.Bridge) error {
+	if strings.HasPrefix(br.Account, "mattermost.") && strings.HasPrefix(br.Channel, "#") {
+		return fmt.Errorf("Mattermost channels do not start with a #: remove the # in %s", br.Channel)
+	}
+	if strings.HasPrefix(br.Account, "zulip.") && !strings.Contains(br.Channel, "/topic:") {
+		return fmt.Errorf("Breaking change, since matterbridge 1.14.0 zulip channels need to specify the topic with channel/topic:mytopic in %s of %s", br.Channel, br.Account)
+	}
+	return nil
+}
+
 func (gw *Gateway) mapChannels() error {
 	gw.mapChannelConfig(gw.MyConfig.In, "in")
 	gw.mapChannelConfig(gw.MyConfig.Out, "out")
@@ -337,7 +559,7 @@ func (gw *Gateway) mapChannels() error {
 	return nil
 }
 
-func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []config.ChannelInfo {
+func (gw *Gateway) getDestChannel(msg *config.Message, dest *bridge.Bridge) []config.ChannelInfo {
 	var channels []config.ChannelInfo
 
 	// for messages received from the api check that the gateway is the specified one
@@ -349,7 +571,7 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 	if (msg.Event == config.EventJoinLeave || msg.Event == config.EventJoin || msg.Event == config.EventLeave) &&
 		getProtocol(msg) == "discord" &&
 		msg.Channel == "" {
-		for _, channel := range gw.Channels {
+		for _, channel := range gw.channels() {
 			if channel.Account == dest.Account && strings.Contains(channel.Direction, "out") &&
 				gw.validGatewayDest(msg) {
 				channels = append(channels, *channel)
@@ -359,28 +581,28 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 	}
 
 	// if source channel is in only, do nothing
-	for _, channel := range gw.Channels {
-		// lookup the channel from the message
-		if channel.ID == getChannelID(msg) {
-			// we only have destinations if the original message is from an "in" (sending) channel
-			if !strings.Contains(channel.Direction, "in") {
-				return channels
-			}
-			continue
-		}
+	srcChannel, ok := gw.channels()[getChannelID(msg)]
+	if !ok {
+		return channels
 	}
-	for _, channel := range gw.Channels {
-		if _, ok := gw.Channels[getChannelID(msg)]; !ok {
-			continue
-		}
-
+	// we only have destinations if the original message is from an "in" (sending) channel
+	if !strings.Contains(srcChannel.Direction, "in") {
+		return channels
+	}
+	for _, channel := range gw.channels() {
 		// do samechannelgateway logic
 		if channel.SameChannel[msg.Gateway] {
-			if msg.Channel == channel.Name && msg.Account != dest.Account {
+			if channel.Account == dest.Account && msg.Account != dest.Account &&
+				gw.sameChannelKey(msg.Account, msg.Channel) == gw.sameChannelKey(channel.Account, channel.Name) {
 				channels = append(channels, *channel)
 			}
 			continue
 		}
+		// channels matched by a pattern only receive messages from the channels
+		// they are paired with
+		if channel.PatternKey != "" && channel.PatternKey != srcChannel.PatternKey {
+			continue
+		}
 		if strings.Contains(channel.Direction, "out") && channel.Account == dest.Account && gw.validGatewayDest(msg) {
 			channels = append(channels, *channel)
 		}
@@ -388,6 +610,19 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 	return channels
 }
 
+// sameChannelKey returns the name used to match channel of account with the
+// channels of other bridges in a samechannelgateway.
+func (gw *Gateway) sameChannelKey(account string, channel string) string {
+	br, ok := gw.Bridges[account]
+	if !ok {
+		return channel
+	}
+	if resolver, ok := br.Bridger.(bridge.ChannelNameResolver); ok {
+		channel = resolver.ResolveChannelName(channel)
+	}
+	return samechannel.Key(br.Protocol, channel)
+}
+
 func (gw *Gateway) getDestMsgID(msgID string, dest *bridge.Bridge, channel *config.ChannelInfo) string {
 	if res, ok := gw.Messages.Get(msgID); ok {
 		IDs := res.([]*BrMsgID)
@@ -429,13 +664,127 @@ func (gw *Gateway) ignoreMessage(msg *config.Message) bool {
 
 	igNicks := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreNicks"))
 	igMessages := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreMessages"))
-	if gw.ignoreTextEmpty(msg) || gw.ignoreText(msg.Username, igNicks) || gw.ignoreText(msg.Text, igMessages) || gw.ignoreFilesComment(msg.Extra, igMessages) {
+	if msg.IsBot() && gw.botMessages() == config.BotMessagesDrop {
+		gw.logger.Debugf("ignoring bot message from %s on %s", msg.Username, msg.Account)
+		gw.Router.auditMessage(auditDrop, auditBotMessage, gw, msg, "")
+		return true
+	}
+
+	if _, ok := msg.Ephemeral(); ok && gw.ephemeralMessages() == config.EphemeralMessagesDrop {
+		gw.logger.Debugf("ignoring ephemeral message from %s on %s", msg.Username, msg.Account)
+		gw.Router.auditMessage(auditDrop, auditEphemeralMessage, gw, msg, "")
+		return true
+	}
+
+	// the copies of expired messages are only deleted in the gateway which
+	// relayed them
+	if expired := msg.Extra[config.ExtraExpired]; len(expired) > 0 && expired[0] != gw.Name {
+		return true
+	}
+
+	if msg.UserID != "" && slices.Contains(gw.Bridges[msg.Account].GetStringSlice("IgnoreUserIDs"), msg.UserID) {
+		gw.logger.Debugf("ignoring message from user %s on %s", msg.UserID, msg.Account)
+		gw.Router.auditMessage(auditDrop, auditIgnoredUserID, gw, msg, "")
+		return true
+	}
+
+	if gw.Router.isMuted(msg.Account) {
+		gw.logger.Debugf("ignoring message from %s, which is muted", msg.Account)
+		gw.Router.auditMessage(auditDrop, auditMuted, gw, msg, "")
+		return true
+	}
+
+	if gw.Router.optedOut(msg.Account, msg.UserID) {
+		gw.logger.Debugf("ignoring message from user %s on %s, who opted out", msg.UserID, msg.Account)
+		gw.Router.auditMessage(auditDrop, auditOptedOut, gw, msg, "")
+		return true
+	}
+
+	if gw.ignoreTextEmpty(msg) {
+		return true
+	}
+	if gw.ignoreText(msg.Username, igNicks) {
+		gw.Router.auditMessage(auditDrop, auditIgnoredNick, gw, msg, "")
+		return true
+	}
+	if gw.ignoreText(msg.Text, igMessages) || gw.ignoreFilesComment(msg.Extra, igMessages) {
+		gw.Router.auditMessage(auditDrop, auditIgnoredMessage, gw, msg, "")
 		return true
 	}
 
 	return false
 }
 
+// botMessages returns the BotMessages setting of the gateway.
+func (gw *Gateway) botMessages() string {
+	switch policy := strings.ToLower(gw.MyConfig.BotMessages); policy {
+	case config.BotMessagesRelay, config.BotMessagesDrop:
+		return policy
+	default:
+		return config.BotMessagesTag
+	}
+}
+
+// ephemeralMessages returns the EphemeralMessages setting of the gateway.
+func (gw *Gateway) ephemeralMessages() string {
+	switch policy := strings.ToLower(gw.MyConfig.EphemeralMessages); policy {
+	case config.EphemeralMessagesDrop, config.EphemeralMessagesExpire:
+		return policy
+	default:
+		return config.EphemeralMessagesTag
+	}
+}
+
+// ephemeralTagged returns true if the copies of msg are tagged as ephemeral:
+// with EphemeralMessages="tag", or "expire" when msg has no lifetime.
+func (gw *Gateway) ephemeralTagged(msg *config.Message) bool {
+	ttl, ok := msg.Ephemeral()
+	if !ok {
+		return false
+	}
+	switch gw.ephemeralMessages() {
+	case config.EphemeralMessagesTag:
+		return true
+	case config.EphemeralMessagesExpire:
+		return ttl <= 0
+	}
+	return false
+}
+
+// expireMessage deletes the copies of the ephemeral message msg when it
+// disappears on its platform, with EphemeralMessages="expire". The deletions
+// go through the router like the deletions of the bridges, and are lost on
+// restart or Stop.
+func (gw *Gateway) expireMessage(msg *config.Message) {
+	ttl, ok := msg.Ephemeral()
+	if !ok || ttl <= 0 || msg.ID == "" || gw.ephemeralMessages() != config.EphemeralMessagesExpire {
+		return
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+
+	del := config.Message{
+		Account: msg.Account,
+		Channel: msg.Channel,
+		ID:      msg.ID,
+		Event:   config.EventMsgDelete,
+		Text:    config.EventMsgDelete,
+		Extra:   map[string][]interface{}{config.ExtraExpired: {gw.Name}},
+	}
+	gw.logger.Debugf("%s from %s will be deleted in %s", msg.ID, msg.Account, ttl)
+	r := gw.Router
+	r.run(func() {
+		if !r.sleep(ttl) {
+			return
+		}
+		select {
+		case r.Message <- del:
+		case <-r.stop:
+		}
+	})
+}
+
 // ignoreFilesComment returns true if we need to ignore a file with matched comment.
 func (gw *Gateway) ignoreFilesComment(extra map[string][]interface{}, igMessages []string) bool {
 	if extra == nil {
@@ -453,6 +802,49 @@ func (gw *Gateway) ignoreFilesComment(extra map[string][]interface{}, igMessages
 	return false
 }
 
+// nickRules returns the naming rules of dest, the rules of its protocol
+// overridden by its settings.
+func nickRules(dest *bridge.Bridge) helper.NickRules {
+	rules := bridgemap.NickRules[dest.Protocol]
+	if dest.IsKeySet("NickStrip") {
+		rules.Strip = dest.GetStringSlice("NickStrip")
+	}
+	if dest.IsKeySet("NickDisallowedChars") {
+		rules.Disallowed = dest.GetString("NickDisallowedChars")
+	}
+	if dest.IsKeySet("NickReplacement") {
+		rules.Replacement = dest.GetString("NickReplacement")
+	}
+	if dest.IsKeySet("NickMaxLength") {
+		rules.MaxLength = dest.GetInt("NickMaxLength")
+	}
+	return rules
+}
+
+// defaultTimestampFormat is the TimestampFormat used when it isn't set.
+const defaultTimestampFormat = "15:04"
+
+// formatTimestamp renders the send time of a message for dest, in its
+// Timezone and TimestampFormat.
+func (gw *Gateway) formatTimestamp(ts time.Time, dest *bridge.Bridge) string {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	if name := dest.GetString("Timezone"); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			gw.logger.Errorf("Timezone %q of %s is invalid: %s", name, dest.Account, err)
+		} else {
+			ts = ts.In(loc)
+		}
+	}
+	format := dest.GetString("TimestampFormat")
+	if format == "" {
+		format = defaultTimestampFormat
+	}
+	return ts.Format(format)
+}
+
 func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) error { //nolint:gocyclo,funlen
 	// fix for upstream issue #2043 was written by github user adbenitez
 	// this prevents StripNick (and now also Colornicks) from being applied to the original msg,
@@ -464,8 +856,7 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) erro
 	// such would be obvious to the other local users on that channel, but from any remote bridge's point of view,
 	// the two users would have the same nick.  Perhaps we can log a warning when there is a collision like that?
 	if dest.GetBool("StripNick") { // Sanitize nick so that it contains nothing but alphanumeric characters
-		re := regexp.MustCompile("[^a-zA-Z0-9]+")
-		msg.Username = re.ReplaceAllString(msg.Username, "")
+		msg.Username = stripNickRE.ReplaceAllString(msg.Username, "")
 	} else if dest.Protocol == ircProtocol && !dest.GetBool("UseRelayMsg") && dest.GetBool("Colornicks") {
 		// Colornicks is currently only available for IRC, but it's not compatible with Relaymsg.
 		// If we didn't strip the nick, then we'll swap any spaces with NBSP's.
@@ -480,10 +871,9 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) erro
 	for _, outer := range br.GetStringSlice2D("ReplaceNicks") {
 		search := outer[0]
 		replace := outer[1]
-		// TODO move compile to bridge init somewhere
-		re, err := regexp.Compile(search)
+		re, err := config.CompileRegex(search)
 		if err != nil {
-			gw.logger.Errorf("regexp in %s failed: %s", msg.Account, err)
+			// already reported when loading the configuration
 			break
 		}
 		msg.Username = re.ReplaceAllString(msg.Username, replace)
@@ -513,13 +903,21 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) erro
 	nick = strings.ReplaceAll(nick, "{NICK}", msg.Username)
 	nick = strings.ReplaceAll(nick, "{USERID}", msg.UserID)
 	nick = strings.ReplaceAll(nick, "{CHANNEL}", msg.Channel)
+	botTag := ""
+	if msg.IsBot() && gw.botMessages() == config.BotMessagesTag {
+		botTag = dest.GetString("BotTag")
+	}
+	nick = strings.ReplaceAll(nick, "{BOT}", botTag)
+	if strings.Contains(nick, "{TIMESTAMP}") {
+		nick = strings.ReplaceAll(nick, "{TIMESTAMP}", gw.formatTimestamp(msg.Timestamp, dest))
+	}
 	tengoNick, err := gw.modifyUsernameTengo(msg, br)
 	if err != nil {
 		gw.logger.Errorf("modifyUsernameTengo error: %s", err)
 	}
 	nick = strings.ReplaceAll(nick, "{TENGO}", tengoNick)
 
-	msg.Username = nick
+	msg.Username = helper.ApplyNickRules(nick, nickRules(dest))
 
 	_, ok := bridgemap.SanitizeNickSupport[dest.Protocol] // irc only, for now.  other bridges can be added to gateway/bridgemap/ files
 	if !ok {
@@ -544,6 +942,7 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) erro
 			collisioncheck = strings.ReplaceAll(collisioncheck, "{NICK}", "")
 			collisioncheck = strings.ReplaceAll(collisioncheck, "{USERID}", "")
 			collisioncheck = strings.ReplaceAll(collisioncheck, "{CHANNEL}", "")
+			collisioncheck = strings.ReplaceAll(collisioncheck, "{BOT}", "")
 			collisioncheck = strings.ReplaceAll(collisioncheck, "{TENGO}", "")
 		}
 
@@ -586,6 +985,59 @@ func (gw *Gateway) modifyAvatar(msg *config.Message, dest *bridge.Bridge) {
 	if msg.Avatar == "" {
 		msg.Avatar = iconurl
 	}
+	// the avatars guessed with UseLocalAvatar come before the default
+	if msg.Avatar == "" && !slices.ContainsFunc(dest.GetStringSlice("UseLocalAvatar"), func(source string) bool {
+		return source == msg.Protocol || source == msg.Account
+	}) {
+		msg.Avatar = gw.MyConfig.DefaultAvatarURL
+	}
+}
+
+// defaultNick sets the DefaultNick of the gateway as the nick of the messages
+// of users without nick.
+func (gw *Gateway) defaultNick(msg *config.Message) {
+	if msg.Username != "" || gw.MyConfig.DefaultNick == "" {
+		return
+	}
+	switch msg.Event {
+	case "", config.EventUserAction:
+		msg.Username = gw.MyConfig.DefaultNick
+	}
+}
+
+// priorityMessage returns true if msg bypasses the rate limits of the
+// gateway: the announcements, and the messages of the PriorityUserIDs of the
+// gateway and of the Admins of their account.
+func (gw *Gateway) priorityMessage(msg *config.Message) bool {
+	if msg.Event == config.EventAnnounce {
+		return true
+	}
+	if gw.listedUser(gw.MyConfig.PriorityUserIDs, msg) {
+		return true
+	}
+	br := gw.Bridges[msg.Account]
+	return br != nil && isAdmin(br, msg)
+}
+
+// listedUser returns true if the sender of msg is one of entries, given as
+// "userid" for every account of the gateway or "account/userid".
+func (gw *Gateway) listedUser(entries []string, msg *config.Message) bool {
+	if msg.UserID == "" {
+		return false
+	}
+	for _, entry := range entries {
+		account, userID, ok := strings.Cut(entry, "/")
+		if _, known := gw.Bridges[account]; ok && known {
+			if account == msg.Account && userID == msg.UserID {
+				return true
+			}
+			continue
+		}
+		if entry == msg.UserID {
+			return true
+		}
+	}
+	return false
 }
 
 func (gw *Gateway) modifyMessage(msg *config.Message) {
@@ -593,7 +1045,7 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 		gw.logger.Warnf("General TengoModifyMessage=%s is deprecated and will be removed in v1.20.0, please move to Tengo InMessage=%s", gw.BridgeValues().General.TengoModifyMessage, gw.BridgeValues().General.TengoModifyMessage)
 	}
 
-	if err := modifyInMessageTengo(gw.BridgeValues().General.TengoModifyMessage, msg); err != nil {
+	if err := gw.modifyInMessageTengo(gw.BridgeValues().General.TengoModifyMessage, msg); err != nil {
 		gw.logger.Errorf("TengoModifyMessage failed: %s", err)
 	}
 
@@ -605,23 +1057,21 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 		}
 	}
 
-	if err := modifyInMessageTengo(inMessage, msg); err != nil {
+	if err := gw.modifyInMessageTengo(inMessage, msg); err != nil {
 		gw.logger.Errorf("Tengo.Message failed: %s", err)
 	}
 
-	// replace :emoji: to unicode
-	emoji.ReplacePadding = ""
-	msg.Text = emoji.Sprint(msg.Text)
+	// replace :emoji: of any platform to unicode
+	msg.Text = helper.EmojiToUnicode(msg.Text)
 
 	br := gw.Bridges[msg.Account]
 	// loop to replace messages
 	for _, outer := range br.GetStringSlice2D("ReplaceMessages") {
 		search := outer[0]
 		replace := outer[1]
-		// TODO move compile to bridge init somewhere
-		re, err := regexp.Compile(search)
+		re, err := config.CompileRegex(search)
 		if err != nil {
-			gw.logger.Errorf("regexp in %s failed: %s", msg.Account, err)
+			// already reported when loading the configuration
 			break
 		}
 		msg.Text = re.ReplaceAllString(msg.Text, replace)
@@ -653,10 +1103,9 @@ func (gw *Gateway) ignoreText(text string, input []string) bool {
 		if entry == "" {
 			continue
 		}
-		// TODO do not compile regexps everytime
-		re, err := regexp.Compile(entry)
+		re, err := config.CompileRegex(entry)
 		if err != nil {
-			gw.logger.Errorf("incorrect regexp %s", entry)
+			// already reported when loading the configuration
 			continue
 		}
 		if re.MatchString(text) {
@@ -672,29 +1121,22 @@ func getProtocol(msg *config.Message) string {
 	return p[0]
 }
 
-func modifyInMessageTengo(filename string, msg *config.Message) error {
+// modifyInMessageTengo runs the InMessage script filename on msg.
+func (gw *Gateway) modifyInMessageTengo(filename string, msg *config.Message) error {
 	if filename == "" {
 		return nil
 	}
 
-	res, err := os.ReadFile(filename) //nolint:gosec
-	if err != nil {
-		return err
-	}
-	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("msgAccount", msg.Account)
-	_ = s.Add("msgChannel", msg.Channel)
-	c, err := s.Compile()
+	c, err := gw.Router.tengo.run(filename, false, []tengoVar{
+		{"msgText", msg.Text},
+		{"msgUsername", msg.Username},
+		{"msgUserID", msg.UserID},
+		{"msgAccount", msg.Account},
+		{"msgChannel", msg.Channel},
+	})
 	if err != nil {
 		return err
 	}
-	if err := c.Run(); err != nil {
-		return err
-	}
 	msg.Text = c.Get("msgText").String()
 	msg.Username = c.Get("msgUsername").String()
 	return nil
@@ -706,86 +1148,58 @@ func (gw *Gateway) modifyUsernameTengo(msg *config.Message, br *bridge.Bridge) (
 		return "", nil
 	}
 
-	res, err := os.ReadFile(filename) //nolint:gosec
-	if err != nil {
-		return "", err
-	}
-	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("result", "")
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("nick", msg.Username)
-	_ = s.Add("msgAccount", msg.Account)
-	_ = s.Add("msgChannel", msg.Channel)
-	_ = s.Add("channel", msg.Channel)
-	_ = s.Add("msgProtocol", msg.Protocol)
-	_ = s.Add("remoteAccount", br.Account)
-	_ = s.Add("msgEvent", msg.Event)
-	_ = s.Add("label", br.GetString("Label"))
-	_ = s.Add("protocol", br.Protocol)
-	_ = s.Add("bridge", br.Name)
-	_ = s.Add("gateway", gw.Name)
-	c, err := s.Compile()
+	c, err := gw.Router.tengo.run(filename, false, []tengoVar{
+		{"result", ""},
+		{"msgText", msg.Text},
+		{"msgUsername", msg.Username},
+		{"msgUserID", msg.UserID},
+		{"nick", msg.Username},
+		{"msgAccount", msg.Account},
+		{"msgChannel", msg.Channel},
+		{"channel", msg.Channel},
+		{"msgProtocol", msg.Protocol},
+		{"remoteAccount", br.Account},
+		{"msgEvent", msg.Event},
+		{"label", br.GetString("Label")},
+		{"protocol", br.Protocol},
+		{"bridge", br.Name},
+		{"gateway", gw.Name},
+	})
 	if err != nil {
 		return "", err
 	}
-	if err := c.Run(); err != nil {
-		return "", err
-	}
 	return c.Get("result").String(), nil
 }
 
 func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Message, br *bridge.Bridge) (bool, error) {
 	filename := gw.BridgeValues().Tengo.OutMessage
-	var (
-		res  []byte
-		err  error
-		drop bool
-	)
-
-	if filename == "" {
-		res, err = internal.Asset("tengo/outmessage.tengo")
-		if err != nil {
-			return drop, err
-		}
-	} else {
-		res, err = os.ReadFile(filename) //nolint:gosec
-		if err != nil {
-			return drop, err
-		}
-	}
-
-	s := tengo.NewScript(res)
-
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("inAccount", origmsg.Account)
-	_ = s.Add("inProtocol", origmsg.Protocol)
-	_ = s.Add("inChannel", origmsg.Channel)
-	_ = s.Add("inGateway", origmsg.Gateway)
-	_ = s.Add("inEvent", origmsg.Event)
-	_ = s.Add("outAccount", br.Account)
-	_ = s.Add("outProtocol", br.Protocol)
-	_ = s.Add("outChannel", msg.Channel)
-	_ = s.Add("outGateway", gw.Name)
-	_ = s.Add("outEvent", msg.Event)
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("msgDrop", drop)
-	c, err := s.Compile()
+	// the default script is compiled in
+	asset := filename == ""
+	if asset {
+		filename = "tengo/outmessage.tengo"
+	}
+
+	c, err := gw.Router.tengo.run(filename, asset, []tengoVar{
+		{"inAccount", origmsg.Account},
+		{"inProtocol", origmsg.Protocol},
+		{"inChannel", origmsg.Channel},
+		{"inGateway", origmsg.Gateway},
+		{"inEvent", origmsg.Event},
+		{"outAccount", br.Account},
+		{"outProtocol", br.Protocol},
+		{"outChannel", msg.Channel},
+		{"outGateway", gw.Name},
+		{"outEvent", msg.Event},
+		{"msgText", msg.Text},
+		{"msgUsername", msg.Username},
+		{"msgUserID", msg.UserID},
+		{"msgDrop", false},
+	})
 	if err != nil {
-		return drop, err
+		return false, err
 	}
 
-	if err := c.Run(); err != nil {
-		return drop, err
-	}
-
-	drop = c.Get("msgDrop").Bool()
 	msg.Text = c.Get("msgText").String()
 	msg.Username = c.Get("msgUsername").String()
-
-	return drop, nil
+	return c.Get("msgDrop").Bool(), nil
 }
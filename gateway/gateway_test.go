@@ -1,191 +1,618 @@
 package gateway
 
 import (
-	"fmt"
+	"errors"
 	"io"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
-var testconfig = []byte(`
+func TestNewRouter(t *testing.T) {
+	r := maketestRouter(testconfig)
+	assert.Equal(t, 1, len(r.Gateways))
+	assert.Equal(t, 3, len(r.Gateways["bridge1"].Bridges))
+	assert.Equal(t, 3, len(r.Gateways["bridge1"].Channels))
+	r = maketestRouter(testconfig2)
+	assert.Equal(t, 2, len(r.Gateways))
+	assert.Equal(t, 3, len(r.Gateways["bridge1"].Bridges))
+	assert.Equal(t, 2, len(r.Gateways["bridge2"].Bridges))
+	assert.Equal(t, 3, len(r.Gateways["bridge1"].Channels))
+	assert.Equal(t, 2, len(r.Gateways["bridge2"].Channels))
+	assert.Equal(t, &config.ChannelInfo{
+		Name:        "general",
+		Direction:   "inout",
+		ID:          "generaldiscord.test",
+		Account:     "discord.test",
+		SameChannel: map[string]bool{"bridge1": false},
+	}, r.Gateways["bridge1"].Channels["generaldiscord.test"])
+}
+
+func TestMsgAck(t *testing.T) {
+	r, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	slack := gw.Bridges[slackTestAccount]
+	gw.Messages.Add("telegram 1", []*BrMsgID{
+		{irc, "irc dummy1", "#main" + ircTestAccount},
+		{slack, "slack 1.2", "general" + slackTestAccount},
+	})
+	gw.Messages.Add("telegram 2", []*BrMsgID{
+		{irc, "irc dummy2", "#main" + ircTestAccount},
+	})
+
+	ack := func(id string, ack config.MsgAck) bool {
+		return r.handleEventMsgAck(&config.Message{
+			Event:   config.EventMsgAck,
+			Account: ircTestAccount,
+			ID:      id,
+			Extra:   map[string][]interface{}{config.EventMsgAck: {ack}},
+		})
+	}
+
+	assert.False(t, r.handleEventMsgAck(&config.Message{Text: "hello", Account: ircTestAccount}))
+
+	v, _ := gw.Messages.Peek("telegram 1")
+	sent := v.([]*BrMsgID)
+	assert.True(t, ack("dummy1", config.MsgAck{RemoteID: "real1"}))
+	// the IDs read before aren't changed
+	assert.Equal(t, "irc dummy1", sent[0].ID)
+	assert.Equal(t, "real1", gw.getDestMsgID("telegram 1", irc, &config.ChannelInfo{ID: "#main" + ircTestAccount}))
+	assert.Equal(t, "telegram 1", gw.FindCanonicalMsgID("irc", "real1"))
+
+	assert.True(t, ack("dummy2", config.MsgAck{Err: errors.New("rejected")}))
+	assert.Equal(t, "", gw.getDestMsgID("telegram 2", irc, &config.ChannelInfo{ID: "#main" + ircTestAccount}))
+}
+
+func TestSendSpoilers(t *testing.T) {
+	_, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	send := func(account string, channel string) {
+		msg := &config.Message{Text: "it was ||Bruce Willis||", Account: account, Channel: channel, Protocol: strings.Split(account, ".")[0]}
+		_, err := gw.SendMessage(msg, irc, gw.Channels["#main"+ircTestAccount], "")
+		require.NoError(t, err)
+	}
+
+	send(tgTestAccount, "-1111111111111")
+	irc.SetString("SpoilerFormat", helper.SpoilerFormatRot13)
+	defer irc.SetString("SpoilerFormat", "")
+	send(tgTestAccount, "-1111111111111")
+	// "||" isn't a spoiler on slack
+	send(slackTestAccount, "irc")
+	assert.Equal(t, []string{
+		"it was " + helper.SpoilerHidden,
+		"it was [spoiler: Oehpr Jvyyvf]",
+		"it was ||Bruce Willis||",
+	}, flaky.sent)
+}
+
+func TestSendForward(t *testing.T) {
+	_, gw := newTestGateway()
+	irc, slack := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount]
+	ircFlaky, slackFlaky := &flakyBridger{Bridger: irc.Bridger}, &flakyBridger{Bridger: slack.Bridger}
+	irc.Bridger, slack.Bridger = ircFlaky, slackFlaky
+
+	msg := &config.Message{Text: "breaking\nnews", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram"}
+	msg.SetForward(config.Forward{From: "alice", Channel: "News"})
+	_, err := gw.SendMessage(msg, irc, gw.Channels["#main"+ircTestAccount], "")
+	require.NoError(t, err)
+	_, err = gw.SendMessage(msg, slack, gw.Channels["irc"+slackTestAccount], "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Forwarded from alice in News: breaking\nnews"}, ircFlaky.sent)
+	assert.Equal(t, []string{"Forwarded from alice in News:\n> breaking\n> news"}, slackFlaky.sent)
+	assert.Equal(t, "breaking\nnews", msg.Text)
+}
+
+func TestReactionNotice(t *testing.T) {
+	_, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	reaction := func(removed bool) *config.Message {
+		msg := &config.Message{Event: config.EventReaction, Text: ":thumbsup:", Username: "alice", Account: slackTestAccount, Channel: "irc", Protocol: "slack", Gateway: "bridge", ParentID: "1234.5678"}
+		if removed {
+			msg.MarkReactionRemoved()
+		}
+		return msg
+	}
+
+	// irc can't react, the reactions are dropped by default
+	gw.handleMessage(reaction(false), irc)
+	assert.Empty(t, flaky.sent)
+
+	irc.SetBool("ShowReactions", true)
+	defer irc.SetBool("ShowReactions", false)
+	msg := reaction(false)
+	gw.handleMessage(msg, irc)
+	gw.handleMessage(reaction(true), irc)
+	assert.Equal(t, []string{"reacted with 👍"}, flaky.sent)
+	assert.Equal(t, config.EventReaction, msg.Event, "the other bridges get the reaction")
+}
+
+func TestFileOnlyMessages(t *testing.T) {
+	_, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	defer irc.SetString("FileOnlyMessages", "")
+	file := func(names ...string) *config.Message {
+		msg := &config.Message{Username: "alice", Account: slackTestAccount, Channel: "irc", Protocol: "slack", Gateway: "bridge", Extra: map[string][]interface{}{}}
+		for _, name := range names {
+			msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{Name: name})
+		}
+		return msg
+	}
+
+	// the files are sent as they are by default
+	gw.handleMessage(file("cat.jpg"), irc)
+	assert.Equal(t, []string{""}, flaky.sent)
+
+	irc.SetString("FileOnlyMessages", "describe")
+	msg := file("cat.jpg")
+	gw.handleMessage(msg, irc)
+	gw.handleMessage(file("cat.jpg", "dog.jpg"), irc)
+	assert.Equal(t, []string{"", "sent a file: cat.jpg", "sent files: cat.jpg, dog.jpg"}, flaky.sent)
+	assert.Empty(t, msg.Text, "the other bridges get the message as it is")
+
+	irc.SetString("FileOnlyMessages", "skip")
+	gw.handleMessage(file("cat.jpg"), irc)
+	withText := file("cat.jpg")
+	withText.Text = "look"
+	gw.handleMessage(withText, irc)
+	assert.Equal(t, []string{"", "sent a file: cat.jpg", "sent files: cat.jpg, dog.jpg", "look"}, flaky.sent)
+}
+
+// joinBridger records the channels joined and the messages sent, and blocks
+// the joins until gate is closed when it is set.
+type joinBridger struct {
+	bridge.Bridger
+
+	mu     sync.Mutex
+	events []string
+	gate   chan struct{}
+}
+
+func (b *joinBridger) JoinChannel(channel config.ChannelInfo) error {
+	if b.gate != nil {
+		<-b.gate
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, "join "+channel.Name)
+	return nil
+}
+
+func (b *joinBridger) Send(msg config.Message) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, "send "+msg.Channel)
+	return "", nil
+}
+
+func (b *joinBridger) getEvents() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.events...)
+}
+
+// reconnectBridger counts its disconnections, and connects once gate is
+// closed.
+type reconnectBridger struct {
+	joinBridger
+
+	disconnects atomic.Int32
+	gate        chan struct{}
+}
+
+func (b *reconnectBridger) Disconnect() error {
+	b.disconnects.Add(1)
+	return nil
+}
+
+func (b *reconnectBridger) Connect() error {
+	<-b.gate
+	return nil
+}
+
+func TestReconnectBridgeOnce(t *testing.T) {
+	_, gw := newTestGateway()
+	gw.reconnectDelay = 0
+	irc := gw.Bridges[ircTestAccount]
+	reconnecter := &reconnectBridger{joinBridger: joinBridger{Bridger: irc.Bridger}, gate: make(chan struct{})}
+	irc.Bridger = reconnecter
+
+	// the concurrent reconnections wait for the running one
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gw.reconnectBridge(irc)
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		return reconnecter.disconnects.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(reconnecter.gate)
+	wg.Wait()
+	assert.Equal(t, int32(1), reconnecter.disconnects.Load())
+
+	// and the next one reconnects again
+	gw.reconnectBridge(irc)
+	assert.Equal(t, int32(2), reconnecter.disconnects.Load())
+}
+
+func TestLazyJoin(t *testing.T) {
+	_, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	joiner := &joinBridger{Bridger: irc.Bridger}
+	irc.Bridger = joiner
+	irc.SetBool("LazyJoin", true)
+	defer irc.SetBool("LazyJoin", false)
+
+	// A channel is joined before the first message sent to it
+	msg := &config.Message{Text: "hello", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram"}
+	for range 2 {
+		_, err := gw.SendMessage(msg, irc, gw.Channels["#main"+ircTestAccount], "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"join #main", "send #main", "send #main"}, joiner.getEvents())
+
+	// The other channels are joined in the background
+	joiner.gate = make(chan struct{})
+	require.NoError(t, irc.JoinChannels())
+	close(joiner.gate)
+	assert.Eventually(t, func() bool {
+		return len(joiner.getEvents()) == 5
+	}, time.Second, 10*time.Millisecond)
+	irc.WaitJoins()
+	joins := joiner.getEvents()[3:]
+	sort.Strings(joins)
+	assert.Equal(t, []string{"join #main-help", "join #main-telegram"}, joins)
+}
+
+func TestGetDestChannelSameChannel(t *testing.T) {
+	r := maketestRouter([]byte(`
 [irc.freenode]
 server=""
-[mattermost.test]
-server=""
 [discord.test]
 server=""
 [slack.test]
 server=""
 
-[[gateway]]
-    name = "bridge1"
-    enable=true
+[[samechannelgateway]]
+    name = "same"
+    enable = true
+    accounts = [ "irc.freenode","discord.test","slack.test" ]
+    channels = [ "Dev","ops" ]
+	`))
+	gw := r.Gateways["same"]
+	msg := &config.Message{Text: "test", Channel: "#dev", Account: "irc.freenode", Gateway: "same", Protocol: "irc"}
 
-    [[gateway.inout]]
-    account = "irc.freenode"
-    channel = "#wimtesting"
+	channels := gw.getDestChannel(msg, gw.Bridges["discord.test"])
+	assert.Len(t, channels, 1)
+	assert.Equal(t, "Devdiscord.test", channels[0].ID)
 
-    [[gateway.inout]]
-    account = "discord.test"
-    channel = "general"
+	channels = gw.getDestChannel(msg, gw.Bridges["slack.test"])
+	assert.Len(t, channels, 1)
+	assert.Equal(t, "Devslack.test", channels[0].ID)
 
-    [[gateway.inout]]
-    account="slack.test"
-    channel="testing"
-	`)
+	assert.Empty(t, gw.getDestChannel(msg, gw.Bridges["irc.freenode"]))
+}
 
-var testconfig2 = []byte(`
-[irc.freenode]
-server=""
-[mattermost.test]
-server=""
-[discord.test]
+func TestDisabledProtocols(t *testing.T) {
+	r := maketestRouter([]byte(`
+[general]
+DisabledProtocols=["Telegram"]
+` + string(testconfig3)))
+	gw := r.Gateways["bridge"]
+	assert.Contains(t, gw.Bridges, ircTestAccount)
+	assert.Contains(t, gw.Bridges, slackTestAccount)
+	assert.NotContains(t, gw.Bridges, tgTestAccount)
+	assert.NotContains(t, r.sortedAccounts(), tgTestAccount)
+
+	r.BridgeMap = map[string]bridge.Factory{"irc": bridgemap.FullMap["irc"]}
+	_, err := r.bridgeFactory("irc")
+	assert.NoError(t, err)
+	_, err = r.bridgeFactory("whatsapp")
+	assert.EqualError(t, err, "protocol whatsapp isn't available, this matterbridge was built with the nowhatsappmulti tag (build it without -tags nowhatsappmulti)")
+	_, err = r.bridgeFactory("unknown")
+	assert.EqualError(t, err, "incorrect protocol unknown")
+}
+
+func TestBotMessages(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
 server=""
-[slack.test]
+RemoteNickFormat="{BOT}<{NICK}> "
+[slack.zzz]
 server=""
 
 [[gateway]]
-    name = "bridge1"
-    enable=true
-
-    [[gateway.in]]
-    account = "irc.freenode"
-    channel = "#wimtesting"
+name="tagged"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
 
+[[gateway]]
+name="relayed"
+enable=true
+BotMessages="relay"
     [[gateway.inout]]
-    account = "discord.test"
-    channel = "general"
+    account="irc.zzz"
+    channel="#relay"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="relay"
 
-    [[gateway.out]]
-    account="slack.test"
-    channel="testing"
 [[gateway]]
-    name = "bridge2"
-    enable=true
+name="dropped"
+enable=true
+BotMessages="drop"
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#drop"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="drop"
+`))
+	irc := r.getBridge(ircTestAccount)
+	nick := func(gwName string, bot bool) string {
+		msg := &config.Message{Text: "hi", Username: "deploybot", Account: slackTestAccount, Channel: "main"}
+		if bot {
+			msg.MarkBot()
+		}
+		assert.NoError(t, r.Gateways[gwName].modifyUsername(msg, irc))
+		return msg.Username
+	}
 
-    [[gateway.in]]
-    account = "irc.freenode"
-    channel = "#wimtesting2"
+	assert.Equal(t, "[bot] <deploybot> ", nick("tagged", true))
+	assert.Equal(t, "<deploybot> ", nick("tagged", false))
+	assert.Equal(t, "<deploybot> ", nick("relayed", true))
 
-    [[gateway.out]]
-    account = "discord.test"
-    channel = "general2"
-	`)
+	msg := &config.Message{Text: "hi", Username: "deploybot", Account: slackTestAccount, Channel: "drop"}
+	assert.False(t, r.Gateways["dropped"].ignoreMessage(msg))
+	msg.MarkBot()
+	assert.True(t, r.Gateways["dropped"].ignoreMessage(msg))
+	assert.False(t, r.Gateways["relayed"].ignoreMessage(msg))
+}
 
-var testconfig3 = []byte(`
+func TestEphemeralMessages(t *testing.T) {
+	r := maketestRouter([]byte(`
 [irc.zzz]
 server=""
-[telegram.zzz]
-server=""
+RemoteNickFormat=""
 [slack.zzz]
 server=""
+
 [[gateway]]
-name="bridge"
+name="tagged"
 enable=true
-
     [[gateway.inout]]
     account="irc.zzz"
     channel="#main"
-
     [[gateway.inout]]
-    account="telegram.zzz"
-    channel="-1111111111111"
+    account="slack.zzz"
+    channel="main"
 
+[[gateway]]
+name="dropped"
+enable=true
+EphemeralMessages="drop"
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#drop"
     [[gateway.inout]]
     account="slack.zzz"
-    channel="irc"
+    channel="drop"
 
 [[gateway]]
-name="announcements"
+name="expired"
 enable=true
-
-    [[gateway.in]]
-    account="telegram.zzz"
-    channel="-2222222222222"
-
-    [[gateway.out]]
+EphemeralMessages="expire"
+    [[gateway.inout]]
     account="irc.zzz"
-    channel="#main"
+    channel="#expire"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="expire"
+`))
+	irc := r.getBridge(ircTestAccount)
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	send := func(gwName string, channel string, ttl time.Duration) {
+		msg := &config.Message{Text: "hi", ID: "1", Account: slackTestAccount, Channel: channel, Protocol: "slack"}
+		msg.MarkEphemeral(ttl)
+		_, err := r.Gateways[gwName].SendMessage(msg, irc, r.Gateways[gwName].Channels[channel+ircTestAccount], "")
+		require.NoError(t, err)
+	}
 
-    [[gateway.out]]
-    account="irc.zzz"
-    channel="#main-help"
+	send("tagged", "#main", time.Hour)
+	send("expired", "#expire", time.Hour)
+	// messages which disappear without a known lifetime are tagged
+	send("expired", "#expire", 0)
+	assert.Equal(t, []string{"[disappearing] hi", "hi", "[disappearing] hi"}, flaky.sent)
+
+	msg := &config.Message{Text: "hi", Account: slackTestAccount, Channel: "drop"}
+	assert.False(t, r.Gateways["dropped"].ignoreMessage(msg))
+	msg.MarkEphemeral(time.Hour)
+	assert.True(t, r.Gateways["dropped"].ignoreMessage(msg))
+	assert.False(t, r.Gateways["tagged"].ignoreMessage(msg))
+
+	// the copies are deleted through the router, in their gateway only
+	msg = &config.Message{Text: "hi", ID: "1", Account: slackTestAccount, Channel: "expire"}
+	msg.MarkEphemeral(time.Millisecond)
+	r.Gateways["tagged"].expireMessage(msg)
+	r.Gateways["expired"].expireMessage(msg)
+	select {
+	case del := <-r.Message:
+		assert.Equal(t, config.EventMsgDelete, del.Event)
+		assert.Equal(t, "1", del.ID)
+		assert.False(t, r.Gateways["expired"].ignoreMessage(&del))
+		assert.True(t, r.Gateways["tagged"].ignoreMessage(&del))
+	case <-time.After(time.Second):
+		t.Fatal("the message wasn't deleted")
+	}
+	select {
+	case del := <-r.Message:
+		t.Fatalf("unexpected deletion %#v", del)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
 
-    [[gateway.out]]
-    account="telegram.zzz"
-    channel="--333333333333"
+func TestIgnoreUserIDs(t *testing.T) {
+	_, gw := newTestGateway()
+	gw.Bridges[ircTestAccount].SetStringSlice("IgnoreUserIDs", []string{"spammer@example.com"})
+	defer gw.Bridges[ircTestAccount].SetStringSlice("IgnoreUserIDs", nil)
+
+	msg := &config.Message{Text: "hi", Username: "friend", UserID: "spammer@example.com", Account: ircTestAccount, Channel: "#main"}
+	assert.True(t, gw.ignoreMessage(msg))
+	msg.UserID = "friend@example.com"
+	assert.False(t, gw.ignoreMessage(msg))
+	msg.UserID = ""
+	assert.False(t, gw.ignoreMessage(msg))
+}
 
-    [[gateway.out]]
-    account="slack.zzz"
-    channel="general"
+func TestNickRules(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+RemoteNickFormat="[{PROTOCOL}] {NICK}"
+NickStrip=["irc"]
+NickDisallowedChars="@"
+NickReplacement="-"
+NickMaxLength=13
 
 [[gateway]]
-name="bridge2"
+name="main"
 enable=true
-
     [[gateway.inout]]
     account="irc.zzz"
-    channel="#main-help"
-
+    channel="#main"
     [[gateway.inout]]
-    account="telegram.zzz"
-    channel="--444444444444"
+    account="slack.zzz"
+    channel="main"
+`))
+	slack := r.getBridge(slackTestAccount)
+	nick := func(username string) string {
+		msg := &config.Message{Text: "hi", Username: username, Account: ircTestAccount, Channel: "#main"}
+		assert.NoError(t, r.Gateways["main"].modifyUsername(msg, slack))
+		return msg.Username
+	}
 
+	// The rules apply to the rendered nick
+	assert.Equal(t, "[] alice-home", nick("alice@home"))
+	assert.Equal(t, "[] fan", nick("IRCfan"))
+	assert.Equal(t, nick("a_rather_long_nick"), nick("a_rather_long_nick"))
+	assert.Equal(t, "[] a_rather_l", nick("a_rather_long_nick"))
+}
+
+func TestTimestampFormat(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+RemoteNickFormat="[{TIMESTAMP}] <{NICK}> "
+Timezone="Asia/Tokyo"
+[slack.zzz]
+server=""
+RemoteNickFormat="{TIMESTAMP} {NICK}: "
+TimestampFormat="Jan 2 15:04 MST"
+Timezone="America/New_York"
 
 [[gateway]]
-name="bridge3"
+name="main"
 enable=true
-
     [[gateway.inout]]
     account="irc.zzz"
-    channel="#main-telegram"
-
+    channel="#main"
     [[gateway.inout]]
-    account="telegram.zzz"
-    channel="--333333333333"
-`)
-
-const (
-	ircTestAccount   = "irc.zzz"
-	tgTestAccount    = "telegram.zzz"
-	slackTestAccount = "slack.zzz"
-)
-
-func maketestRouter(input []byte) *Router {
-	logger := logrus.New()
-	logger.SetOutput(io.Discard)
-	cfg := config.NewConfigFromString(logger, input)
-	r, err := NewRouter(logger, cfg, bridgemap.FullMap)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return r
+    account="slack.zzz"
+    channel="main"
+`))
+	sent := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	gw := r.Gateways["main"]
+
+	msg := &config.Message{Text: "hi", Username: "alice", Account: slackTestAccount, Channel: "main", Timestamp: sent}
+	assert.NoError(t, gw.modifyUsername(msg, r.getBridge(ircTestAccount)))
+	assert.Equal(t, "[21:30] <alice> ", msg.Username)
+
+	msg = &config.Message{Text: "hi", Username: "bob", Account: ircTestAccount, Channel: "#main", Timestamp: sent}
+	assert.NoError(t, gw.modifyUsername(msg, r.getBridge(slackTestAccount)))
+	assert.Equal(t, "Mar 1 07:30 EST bob: ", msg.Username)
 }
 
-func TestNewRouter(t *testing.T) {
-	r := maketestRouter(testconfig)
-	assert.Equal(t, 1, len(r.Gateways))
-	assert.Equal(t, 3, len(r.Gateways["bridge1"].Bridges))
-	assert.Equal(t, 3, len(r.Gateways["bridge1"].Channels))
-	r = maketestRouter(testconfig2)
-	assert.Equal(t, 2, len(r.Gateways))
-	assert.Equal(t, 3, len(r.Gateways["bridge1"].Bridges))
-	assert.Equal(t, 2, len(r.Gateways["bridge2"].Bridges))
-	assert.Equal(t, 3, len(r.Gateways["bridge1"].Channels))
-	assert.Equal(t, 2, len(r.Gateways["bridge2"].Channels))
-	assert.Equal(t, &config.ChannelInfo{
-		Name:        "general",
-		Direction:   "inout",
-		ID:          "generaldiscord.test",
-		Account:     "discord.test",
-		SameChannel: map[string]bool{"bridge1": false},
-	}, r.Gateways["bridge1"].Channels["generaldiscord.test"])
+func TestDefaultAvatarAndNick(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+RemoteNickFormat="{NICK}"
+[discord.zzz]
+server=""
+UseLocalAvatar=["irc"]
+
+[[gateway]]
+name="main"
+enable=true
+DefaultAvatarURL="https://example.com/anonymous.png"
+DefaultNick="anonymous"
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+    [[gateway.inout]]
+    account="discord.zzz"
+    channel="main"
+`))
+	gw := r.Gateways["main"]
+	slack := r.getBridge(slackTestAccount)
+
+	msg := &config.Message{Text: "hi", Account: ircTestAccount, Protocol: "irc", Channel: "#main"}
+	gw.defaultNick(msg)
+	gw.modifyAvatar(msg, slack)
+	assert.NoError(t, gw.modifyUsername(msg, slack))
+	assert.Equal(t, "anonymous", msg.Username)
+	assert.Equal(t, "https://example.com/anonymous.png", msg.Avatar)
+
+	// discord guesses the avatar first
+	msg = &config.Message{Text: "hi", Username: "alice", Account: ircTestAccount, Protocol: "irc", Channel: "#main"}
+	gw.modifyAvatar(msg, r.getBridge("discord.zzz"))
+	assert.Empty(t, msg.Avatar)
+
+	msg = &config.Message{Text: "hi", Username: "alice", Avatar: "https://example.com/alice.png", Account: ircTestAccount, Protocol: "irc", Channel: "#main"}
+	gw.defaultNick(msg)
+	gw.modifyAvatar(msg, slack)
+	assert.Equal(t, "alice", msg.Username)
+	assert.Equal(t, "https://example.com/alice.png", msg.Avatar)
+
+	msg = &config.Message{Event: config.EventTopicChange, Account: ircTestAccount, Channel: "#main"}
+	gw.defaultNick(msg)
+	assert.Empty(t, msg.Username)
 }
 
 func TestGetDestChannel(t *testing.T) {
@@ -201,7 +628,7 @@ func TestGetDestChannel(t *testing.T) {
 				ID:          "generaldiscord.test",
 				SameChannel: map[string]bool{"bridge1": false},
 				Options:     config.ChannelOptions{Key: ""},
-			}}, r.Gateways["bridge1"].getDestChannel(msg, *br))
+			}}, r.Gateways["bridge1"].getDestChannel(msg, br))
 		case "slack.test":
 			assert.Equal(t, []config.ChannelInfo{{
 				Name:        "testing",
@@ -210,9 +637,9 @@ func TestGetDestChannel(t *testing.T) {
 				ID:          "testingslack.test",
 				SameChannel: map[string]bool{"bridge1": false},
 				Options:     config.ChannelOptions{Key: ""},
-			}}, r.Gateways["bridge1"].getDestChannel(msg, *br))
+			}}, r.Gateways["bridge1"].getDestChannel(msg, br))
 		case "irc.freenode":
-			assert.Equal(t, []config.ChannelInfo(nil), r.Gateways["bridge1"].getDestChannel(msg, *br))
+			assert.Equal(t, []config.ChannelInfo(nil), r.Gateways["bridge1"].getDestChannel(msg, br))
 		}
 	}
 }
@@ -231,7 +658,7 @@ func TestGetDestChannelAdvanced(t *testing.T) {
 	for _, gw := range r.Gateways {
 		for _, br := range gw.Bridges {
 			for _, msg := range msgs {
-				channels := gw.getDestChannel(msg, *br)
+				channels := gw.getDestChannel(msg, br)
 				if gw.Name != msg.Gateway {
 					assert.Equal(t, []config.ChannelInfo(nil), channels)
 					continue
@@ -499,11 +926,26 @@ func (s *ignoreTestSuite) TestIgnoreNicks() {
 }
 
 func BenchmarkTengo(b *testing.B) {
+	_, gw := newTestGateway()
 	msg := &config.Message{Username: "user", Text: "blah testing", Account: "protocol.account", Channel: "mychannel"}
 	for n := 0; n < b.N; n++ {
-		err := modifyInMessageTengo("bench.tengo", msg)
+		err := gw.modifyInMessageTengo("bench.tengo", msg)
 		if err != nil {
 			return
 		}
 	}
 }
+
+func BenchmarkTextMessage(b *testing.B) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	dest := gw.Bridges["slack.test"]
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		msg := &config.Message{Username: "user", Text: "blah testing", Account: "irc.freenode", Channel: "#wimtesting", Protocol: "irc"}
+		gw.modifyMessage(msg)
+		if len(gw.getDestChannel(msg, dest)) != 1 {
+			b.Fatal("expected one destination channel")
+		}
+	}
+}
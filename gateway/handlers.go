@@ -2,14 +2,16 @@ package gateway
 
 import (
 	"crypto/sha1" //nolint:gosec
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
 	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
 )
 
@@ -18,7 +20,13 @@ func (r *Router) handleEventFailure(msg *config.Message) {
 	if msg.Event != config.EventFailure {
 		return
 	}
-	for _, gw := range r.Gateways {
+	// the bridges give the error when their credentials were refused
+	for _, f := range msg.Extra[config.EventFailure] {
+		if err, ok := f.(error); ok {
+			r.failOver(msg.Account, err)
+		}
+	}
+	for _, gw := range r.sortedGateways() {
 		for _, br := range gw.Bridges {
 			if msg.Account == br.Account {
 				go gw.reconnectBridge(br)
@@ -50,66 +58,119 @@ func (r *Router) handleEventRejoinChannels(msg *config.Message) {
 	if msg.Event != config.EventRejoinChannels {
 		return
 	}
-	for _, gw := range r.Gateways {
-		for _, br := range gw.Bridges {
-			if msg.Account == br.Account {
-				br.Joined = make(map[string]bool)
-				if err := br.JoinChannels(); err != nil {
-					r.logger.Errorf("channel join failed for %s: %s", msg.Account, err)
-				}
-			}
+	// The bridge instance is shared between gateways, only rejoin once.
+	br := r.getBridge(msg.Account)
+	if br == nil {
+		return
+	}
+	br.ResetJoined()
+	if err := br.JoinChannels(); err != nil {
+		r.logger.Errorf("channel join failed for %s: %s", msg.Account, err)
+	}
+}
+
+// handleEventMsgAck replaces the provisional ID of a message sent asynchronously
+// with its real remote ID in the message cache of every gateway, or forgets it
+// when the bridge reports it was never delivered. Returns true if msg was an ack.
+func (r *Router) handleEventMsgAck(msg *config.Message) bool {
+	if msg.Event != config.EventMsgAck {
+		return false
+	}
+	br := r.getBridge(msg.Account)
+	if br == nil || len(msg.Extra[config.EventMsgAck]) == 0 {
+		return true
+	}
+	ack, ok := msg.Extra[config.EventMsgAck][0].(config.MsgAck)
+	if !ok {
+		r.logger.Errorf("msg.Extra[%s] is not a MsgAck: %#v", config.EventMsgAck, msg.Extra[config.EventMsgAck][0])
+		return true
+	}
+
+	provisionalID := br.Protocol + " " + msg.ID
+	// the provisional ID is removed when the message wasn't delivered
+	remoteID := ""
+	if ack.Err != nil {
+		r.logger.Warnf("message %s to %s on %s was not delivered: %s", msg.ID, msg.Channel, msg.Account, ack.Err)
+	} else {
+		r.logger.Debugf("message %s to %s on %s acknowledged as %s", msg.ID, msg.Channel, msg.Account, ack.RemoteID)
+		remoteID = br.Protocol + " " + ack.RemoteID
+	}
+
+	for _, gw := range r.sortedGateways() {
+		for _, key := range gw.Messages.Keys() {
+			canonicalID, _ := key.(string)
+			gw.replaceMsgID(canonicalID, msg.Account, provisionalID, remoteID)
 		}
 	}
+	return true
 }
 
 // handleFiles uploads or places all files on the given msg to the MediaServer and
-// adds the new URL of the file on the MediaServer onto the given msg.
+// adds the new URL of the file on the MediaServer onto the given msg. The files
+// are handled by the media pool of the gateway, see processFiles.
 func (gw *Gateway) handleFiles(msg *config.Message) {
-	reg := regexp.MustCompile("[^a-zA-Z0-9]+")
-
-	// If we don't have a attachfield or we don't have a mediaserver configured return
-	if msg.Extra == nil || gw.BridgeValues().General.MediaDownloadPath == "" {
+	if !gw.hasFilesToHandle(msg) {
 		return
 	}
+	msg.Extra["file"] = gw.processFiles(msg.Extra["file"])
+}
 
-	// If we don't have files, nothing to upload.
-	if len(msg.Extra["file"]) == 0 {
-		return
+// hasFilesToHandle returns true if msg has files and a mediaserver is
+// configured.
+func (gw *Gateway) hasFilesToHandle(msg *config.Message) bool {
+	return msg.Extra != nil && len(msg.Extra["file"]) > 0 && gw.BridgeValues().General.MediaDownloadPath != ""
+}
+
+// handleFile places fi on the MediaServer, and returns it with its URL and SHA.
+func (gw *Gateway) handleFile(fi config.FileInfo) (config.FileInfo, error) {
+	sha1sum, contentType, err := fileDigest(fi)
+	if err != nil {
+		return fi, fmt.Errorf("reading %s failed: %s", fi.Name, err)
 	}
+	placed := fi
+	placed.Name = helper.SanitizeFileName(fi.Name, contentType)
 
-	for i, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		ext := filepath.Ext(fi.Name)
-		fi.Name = fi.Name[0 : len(fi.Name)-len(ext)]
-		fi.Name = reg.ReplaceAllString(fi.Name, "_")
-		fi.Name += ext
+	// Use MediaServerPath. Place the file on the current filesystem.
+	if err := gw.handleFilesLocal(&placed, sha1sum); err != nil {
+		return fi, err
+	}
 
-		sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
+	// Download URL.
+	durl := helper.MediaServerURL(gw.BridgeValues().General.MediaServerDownload, sha1sum, placed.Name)
 
-		// Use MediaServerPath. Place the file on the current filesystem.
-		err := gw.handleFilesLocal(&fi)
-		if err != nil {
-			gw.logger.Error(err)
-			continue
-		}
+	gw.logger.Debugf("mediaserver download URL = %s", durl)
 
-		// Download URL.
-		durl := gw.BridgeValues().General.MediaServerDownload + "/" + sha1sum + "/" + fi.Name
+	// We uploaded/placed the file successfully. Add the SHA and URL.
+	fi.URL = durl
+	fi.SHA = sha1sum
+	return fi, nil
+}
 
-		gw.logger.Debugf("mediaserver download URL = %s", durl)
+// fileDigest returns the short SHA-1 used in the media server URL of the file
+// and its content type, streaming the spooled files.
+func fileDigest(fi config.FileInfo) (string, string, error) {
+	r, err := fi.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
 
-		// We uploaded/placed the file successfully. Add the SHA and URL.
-		extra := msg.Extra["file"][i].(config.FileInfo)
-		extra.URL = durl
-		extra.SHA = sha1sum
-		msg.Extra["file"][i] = extra
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	h := sha1.New() //nolint:gosec
+	h.Write(head[:n])
+	if _, err := io.Copy(h, r); err != nil {
+		return "", "", err
 	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:8], http.DetectContentType(head[:n]), nil
 }
 
 // handleFilesLocal use MediaServerPath configuration, places the file on the current filesystem.
 // Returns error on failure.
-func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
+func (gw *Gateway) handleFilesLocal(fi *config.FileInfo, sha1sum string) error {
 	dir := gw.BridgeValues().General.MediaDownloadPath + "/" + sha1sum
 	err := os.Mkdir(dir, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
@@ -119,7 +180,19 @@ func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
 	path := dir + "/" + fi.Name
 	gw.logger.Debugf("mediaserver path placing file: %s", path)
 
-	err = os.WriteFile(path, *fi.Data, os.ModePerm) //nolint:gosec
+	r, err := fi.Open()
+	if err != nil {
+		return fmt.Errorf("mediaserver path failed, could not read: %s %#v", err, err)
+	}
+	defer r.Close()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("mediaserver path failed, could not writefile: %s %#v", err, err)
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return fmt.Errorf("mediaserver path failed, could not writefile: %s %#v", err, err)
 	}
@@ -144,10 +217,47 @@ func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 		if !dest.GetBool("ShowTopicChange") && !dest.GetBool("SyncTopic") {
 			return true
 		}
+	case config.EventMsgPin:
+		// only relay pinned messages when configured
+		if !dest.GetBool("ShowPins") {
+			return true
+		}
 	}
 	return false
 }
 
+// reactionNotice returns the text message relaying the reaction rmsg to the
+// bridges which can't react, a reply to the message reacted to with
+// PreserveThreading.
+func reactionNotice(rmsg *config.Message) *config.Message {
+	notice := *rmsg
+	notice.Event = ""
+	notice.Text = "reacted with " + helper.EmojiToUnicode(rmsg.Text)
+	notice.Extra = nil
+	return &notice
+}
+
+// fileNotice returns rmsg, a message with files but no text, with a text
+// telling about its files.
+func fileNotice(rmsg *config.Message) *config.Message {
+	names := []string{}
+	for _, f := range rmsg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Name != "" {
+			names = append(names, fi.Name)
+		}
+	}
+	notice := *rmsg
+	switch len(names) {
+	case 0:
+		notice.Text = "sent a file"
+	case 1:
+		notice.Text = "sent a file: " + names[0]
+	default:
+		notice.Text = "sent files: " + strings.Join(names, ", ")
+	}
+	return &notice
+}
+
 // handleMessage makes sure the message get sent to the correct bridge/channels.
 // Returns an array of msg ID's
 func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*BrMsgID {
@@ -161,6 +271,31 @@ func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*B
 		}
 	}
 
+	// Same for reactions, which only make sense on bridges that can attach
+	// them to an existing message, the others get a short notice with
+	// ShowReactions.
+	if rmsg.Event == config.EventReaction {
+		if _, ok := bridgemap.ReactionSupport[dest.Protocol]; !ok {
+			if !dest.GetBool("ShowReactions") || rmsg.ReactionRemoved() {
+				return nil
+			}
+			rmsg = reactionNotice(rmsg)
+		}
+	}
+
+	// the messages with files and no text are sent with their files only
+	// (the default), with a text describing them or not at all, whatever the
+	// bridge does with an empty text
+	if rmsg.Event == "" && rmsg.Text == "" && len(rmsg.Extra["file"]) > 0 {
+		switch strings.ToLower(dest.GetString("FileOnlyMessages")) {
+		case config.FileOnlySkip:
+			gw.Router.auditMessage(auditDrop, auditFileOnly, gw, rmsg, dest.Account)
+			return nil
+		case config.FileOnlyDescribe:
+			rmsg = fileNotice(rmsg)
+		}
+	}
+
 	// if we have an attached file, or other info
 	if rmsg.Extra != nil && len(rmsg.Extra[config.EventFileFailureSize]) != 0 && rmsg.Text == "" {
 		return brMsgIDs
@@ -176,18 +311,22 @@ func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*B
 		return brMsgIDs
 	}
 
-	// Get the ID of the parent message in thread
+	// Get the ID of the parent message in thread, or the message being reacted to
 	var canonicalParentMsgID string
-	if rmsg.ParentID != "" && dest.GetBool("PreserveThreading") {
+	if rmsg.ParentID != "" && (dest.GetBool("PreserveThreading") || rmsg.Event == config.EventReaction) {
 		canonicalParentMsgID = gw.FindCanonicalMsgID(rmsg.Protocol, rmsg.ParentID)
 	}
 
-	channels := gw.getDestChannel(rmsg, *dest)
+	channels := gw.getDestChannel(rmsg, dest)
+	if redirect, ok := rmsg.Redirect(); ok {
+		channels = redirectChannels(channels, redirect)
+	}
 	for idx := range channels {
 		channel := &channels[idx]
 		msgID, err := gw.SendMessage(rmsg, dest, channel, canonicalParentMsgID)
 		if err != nil {
 			gw.logger.Errorf("SendMessage failed: %s", err)
+			gw.Router.auditMessage(auditDrop, auditSendFailed, gw, rmsg, dest.Account)
 			continue
 		}
 		if msgID == "" {
@@ -217,12 +356,12 @@ func (gw *Gateway) handleExtractNicks(msg *config.Message) {
 // and replaces username with this result.
 // returns error if the regexp doesn't compile.
 func extractNick(search, extract, username, text string) (string, string, error) {
-	re, err := regexp.Compile(search)
+	re, err := config.CompileRegex(search)
 	if err != nil {
 		return username, text, err
 	}
 	if re.MatchString(username) {
-		re, err = regexp.Compile(extract)
+		re, err = config.CompileRegex(extract)
 		if err != nil {
 			return username, text, err
 		}
@@ -73,3 +73,29 @@ func TestExtractNick(t *testing.T) {
 	}
 
 }
+
+func TestDedupCaption(t *testing.T) {
+	photo := config.FileInfo{Name: "photo.jpg", Comment: "look at this"}
+	other := config.FileInfo{Name: "other.jpg"}
+	for _, protocol := range []string{"discord", "irc", "matrix", "mattermost", "slack", "telegram", "whatsapp", "xmpp", "zulip"} {
+		msg := config.Message{Text: "look at this ", Extra: map[string][]any{"file": {photo, other}}}
+		dedupCaption(&msg, &bridge.Bridge{Protocol: protocol})
+		assert.Empty(t, msg.Text, protocol)
+		assert.Equal(t, "look at this", msg.Extra["file"][0].(config.FileInfo).Comment, protocol)
+	}
+
+	for _, protocol := range []string{"api", "mastodon", "msteams", "mumble"} {
+		extra := map[string][]any{"file": {photo, other}}
+		msg := config.Message{Text: "look at this", Extra: extra}
+		dedupCaption(&msg, &bridge.Bridge{Protocol: protocol})
+		assert.Equal(t, "look at this", msg.Text, protocol)
+		assert.Empty(t, msg.Extra["file"][0].(config.FileInfo).Comment, protocol)
+		assert.Equal(t, other, msg.Extra["file"][1], protocol)
+		// the other destinations still get the comment
+		assert.Equal(t, photo, extra["file"][0], protocol)
+	}
+
+	msg := config.Message{Text: "and another thing", Extra: map[string][]any{"file": {photo}}}
+	dedupCaption(&msg, &bridge.Bridge{Protocol: "matrix"})
+	assert.Equal(t, "and another thing", msg.Text)
+}
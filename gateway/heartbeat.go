@@ -0,0 +1,213 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+)
+
+const (
+	// defaultHeartbeatTimeout is how long a probe may take, unless
+	// HeartbeatTimeout is set.
+	defaultHeartbeatTimeout = 10 * time.Second
+	// defaultHeartbeatFailures is the number of failed heartbeats in a row
+	// after which HeartbeatAction is taken, unless HeartbeatFailures is set.
+	defaultHeartbeatFailures = 3
+)
+
+// HeartbeatAction values, a bridge missing its heartbeats is reconnected
+// unless it is set to heartbeatAlert.
+const (
+	heartbeatReconnect = "reconnect"
+	heartbeatAlert     = "alert"
+)
+
+var errHeartbeatTimeout = errors.New("no answer")
+
+// startHeartbeats probes the bridges with HeartbeatInterval set, and gives the
+// heartbeats to the bridges showing them.
+func (r *Router) startHeartbeats() {
+	for _, account := range r.sortedAccounts() {
+		br := r.getBridge(account)
+		if reporter, ok := br.Bridger.(bridge.HeartbeatReporter); ok {
+			reporter.SetHeartbeats(r.Heartbeats)
+		}
+		if _, ok := br.Bridger.(bridge.Heartbeater); !ok || br.GetInt("HeartbeatInterval") <= 0 {
+			continue
+		}
+		if action := br.GetString("HeartbeatAction"); action != "" && action != heartbeatReconnect && action != heartbeatAlert {
+			r.logger.Warnf("%s: unknown HeartbeatAction %q, reconnecting the bridge when it misses its heartbeats", account, action)
+		}
+		r.run(func() { r.runHeartbeats(account) })
+	}
+}
+
+// runHeartbeats probes the bridge of account every HeartbeatInterval while it
+// is connected, until HeartbeatInterval is unset.
+func (r *Router) runHeartbeats(account string) {
+	br := r.getBridge(account)
+	for {
+		interval := br.GetInt("HeartbeatInterval")
+		if interval <= 0 {
+			return
+		}
+		if !r.sleep(time.Duration(interval) * time.Second) {
+			return
+		}
+		// the bridges being (re)connected are probed once they are back
+		hb, ok := br.Bridger.(bridge.Heartbeater)
+		if !ok || !r.bridgeConnected(account) {
+			continue
+		}
+
+		timeout := time.Duration(br.GetInt("HeartbeatTimeout")) * time.Second
+		if timeout <= 0 {
+			timeout = defaultHeartbeatTimeout
+		}
+		threshold := heartbeatThreshold(br)
+		alive := probe(timeout, func(context.Context) error { return hb.Alive() })
+		reachable := probe(timeout, hb.Ping)
+		before, after := r.recordHeartbeat(account, alive, reachable)
+		switch {
+		case after == threshold:
+			r.heartbeatsMissed(br, threshold, heartbeatError(alive, reachable))
+		case after == 0 && before >= threshold:
+			r.logger.Infof("%s answers its heartbeats again", account)
+			if br.GetString("HeartbeatAction") == heartbeatAlert {
+				r.alert(account, account+" answers its heartbeats again")
+			}
+		}
+	}
+}
+
+// heartbeatThreshold returns the number of failed heartbeats in a row after
+// which the HeartbeatAction of br is taken.
+func heartbeatThreshold(br *bridge.Bridge) int {
+	if threshold := br.GetInt("HeartbeatFailures"); threshold > 0 {
+		return threshold
+	}
+	return defaultHeartbeatFailures
+}
+
+// probe calls fn, giving up when it doesn't return within timeout. Like in
+// sendWithTimeout, a probe which never returns is left running.
+func probe(timeout time.Duration, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w after %s", errHeartbeatTimeout, timeout)
+	}
+}
+
+// heartbeatError returns the reason of a failed heartbeat, the alive probe
+// first.
+func heartbeatError(alive error, reachable error) error {
+	if alive != nil {
+		return fmt.Errorf("bridge not alive: %w", alive)
+	}
+	if reachable != nil {
+		return fmt.Errorf("server unreachable: %w", reachable)
+	}
+	return nil
+}
+
+// recordHeartbeat records the probes of account, and returns the number of
+// failed heartbeats in a row before and after them.
+func (r *Router) recordHeartbeat(account string, alive error, reachable error) (int, int) {
+	now := time.Now()
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	hb, ok := r.heartbeats[account]
+	if !ok {
+		hb = &bridge.Heartbeat{Account: account}
+		r.heartbeats[account] = hb
+	}
+	if alive == nil {
+		hb.LastAlive = now
+	}
+	if reachable == nil {
+		hb.LastReachable = now
+	}
+	before := hb.Failures
+	if err := heartbeatError(alive, reachable); err != nil {
+		hb.Failures++
+		hb.LastError = err.Error()
+	} else {
+		hb.Failures = 0
+		hb.LastError = ""
+	}
+	return before, hb.Failures
+}
+
+// heartbeatsMissed takes the HeartbeatAction of br, which missed threshold
+// heartbeats in a row.
+func (r *Router) heartbeatsMissed(br *bridge.Bridge, threshold int, err error) {
+	if br.GetString("HeartbeatAction") == heartbeatAlert {
+		r.logger.Errorf("%s missed %d heartbeats in a row (last error: %s)", br.Account, threshold, err)
+		r.alert(br.Account, fmt.Sprintf("%s missed %d heartbeats in a row (%s)", br.Account, threshold, err))
+		return
+	}
+
+	r.logger.Warnf("%s missed %d heartbeats in a row (last error: %s), reconnecting", br.Account, threshold, err)
+	r.alert(br.Account, fmt.Sprintf("%s missed %d heartbeats in a row (%s) and is reconnecting", br.Account, threshold, err))
+	r.reconnectBridge(br)
+	// the failures are counted again, so that a bridge still not answering
+	// is reconnected again
+	r.statusMu.Lock()
+	if hb, ok := r.heartbeats[br.Account]; ok {
+		hb.Failures = 0
+	}
+	r.statusMu.Unlock()
+}
+
+// bridgeConnected returns true if the bridge of account started and isn't
+// being reconnected.
+func (r *Router) bridgeConnected(account string) bool {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	status, ok := r.status[account]
+	return ok && status.State == BridgeConnected && r.started[account]
+}
+
+// heartbeat returns the last heartbeats of account, nil if it isn't probed.
+func (r *Router) heartbeat(account string) *bridge.Heartbeat {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	hb, ok := r.heartbeats[account]
+	if !ok {
+		return nil
+	}
+	heartbeat := *hb
+	return &heartbeat
+}
+
+// Heartbeats returns the last heartbeats of the probed bridges, ordered by
+// account.
+func (r *Router) Heartbeats() []bridge.Heartbeat {
+	r.statusMu.Lock()
+	heartbeats := make([]bridge.Heartbeat, 0, len(r.heartbeats))
+	for _, hb := range r.heartbeats {
+		heartbeats = append(heartbeats, *hb)
+	}
+	r.statusMu.Unlock()
+
+	sort.Slice(heartbeats, func(i, j int) bool {
+		return heartbeats[i].Account < heartbeats[j].Account
+	})
+	return heartbeats
+}
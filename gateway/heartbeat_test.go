@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeats(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	for _, account := range r.sortedAccounts() {
+		r.markBridgeStarted(account)
+	}
+	assert.Empty(t, r.Heartbeats())
+
+	// a probe which doesn't return in time fails
+	err := probe(10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	require.ErrorIs(t, err, errHeartbeatTimeout)
+
+	before, after := r.recordHeartbeat(tgTestAccount, nil, nil)
+	assert.Equal(t, 0, before)
+	assert.Equal(t, 0, after)
+	for i := 1; i <= defaultHeartbeatFailures; i++ {
+		_, after = r.recordHeartbeat(ircTestAccount, nil, errors.New("connection refused"))
+		assert.Equal(t, i, after)
+		if i < defaultHeartbeatFailures {
+			assert.Equal(t, HealthOK, r.Health().Status)
+		}
+	}
+
+	// the bridge missing its heartbeats degrades the health
+	h := r.Health()
+	assert.Equal(t, HealthDegraded, h.Status)
+	irc := h.Bridges[0]
+	require.NotNil(t, irc.Heartbeat)
+	assert.Equal(t, []string{"missed 3 heartbeats: server unreachable: connection refused"}, irc.Warnings)
+	assert.False(t, irc.Heartbeat.LastAlive.IsZero())
+	assert.True(t, irc.Heartbeat.LastReachable.IsZero())
+	assert.Nil(t, h.Bridges[1].Heartbeat)
+
+	heartbeats := r.Heartbeats()
+	require.Len(t, heartbeats, 2)
+	assert.Equal(t, ircTestAccount, heartbeats[0].Account)
+	assert.Equal(t, tgTestAccount, heartbeats[1].Account)
+	assert.Empty(t, heartbeats[1].LastError)
+
+	// back
+	before, after = r.recordHeartbeat(ircTestAccount, nil, nil)
+	assert.Equal(t, defaultHeartbeatFailures, before)
+	assert.Equal(t, 0, after)
+	assert.Equal(t, HealthOK, r.Health().Status)
+	assert.False(t, r.heartbeat(ircTestAccount).LastReachable.IsZero())
+}
@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// highlightNamespace is where the highlights subscribed with the highlight
+// control command are kept in the storage, by account and user ID.
+const highlightNamespace = "highlights"
+
+// highlight is a [[highlight]] subscription, or one of the highlight control
+// command: messages matching one of its keywords are forwarded privately to
+// its target.
+type highlight struct {
+	config.Highlight
+
+	// subscribed highlights were set with the highlight command, their
+	// target is the user ID of the subscriber
+	subscribed bool
+	re         *regexp.Regexp
+}
+
+// newHighlight compiles the keywords of entry.
+func newHighlight(entry config.Highlight) (*highlight, error) {
+	keywords := make([]string, 0, len(entry.Keywords))
+	for _, keyword := range entry.Keywords {
+		keywords = append(keywords, regexp.QuoteMeta(keyword))
+	}
+	// Keywords can start or end with punctuation (eg. "@nick"), so \b can't be used
+	re, err := regexp.Compile(`(?i)(?:^|\W)(?:` + strings.Join(keywords, "|") + `)(?:$|\W)`)
+	if err != nil {
+		return nil, err
+	}
+	return &highlight{Highlight: entry, re: re}, nil
+}
+
+// newHighlights checks the [[highlight]] subscriptions of the configuration.
+// Their accounts have to be used by a gateway.
+func (r *Router) newHighlights() ([]*highlight, error) {
+	var highlights []*highlight
+	for _, entry := range r.BridgeValues().Highlight {
+		if entry.Target == "" || len(entry.Keywords) == 0 {
+			return nil, fmt.Errorf("highlight for %s needs a target and keywords", entry.Account)
+		}
+		if _, ok := r.bridges[entry.Account]; !ok {
+			return nil, fmt.Errorf("highlight for %s: account %s is not used by any gateway", entry.Target, entry.Account)
+		}
+		h, err := newHighlight(entry)
+		if err != nil {
+			return nil, err
+		}
+		highlights = append(highlights, h)
+	}
+	return highlights, nil
+}
+
+// subscribeHighlights sets the keywords forwarded to the user userID of
+// account, replacing their previous subscription, and keeps them in the
+// storage when there is one. Without keywords the subscription is removed.
+func (r *Router) subscribeHighlights(account string, userID string, keywords []string) error {
+	var h *highlight
+	if len(keywords) > 0 {
+		var err error
+		if h, err = newHighlight(config.Highlight{Account: account, Target: userID, Keywords: keywords}); err != nil {
+			return err
+		}
+		h.subscribed = true
+	}
+
+	r.highlightsMu.Lock()
+	defer r.highlightsMu.Unlock()
+
+	r.highlights = slices.DeleteFunc(slices.Clone(r.highlights), func(other *highlight) bool {
+		return other.subscribed && other.Account == account && other.Target == userID
+	})
+	if h != nil {
+		r.highlights = append(r.highlights, h)
+	}
+	if r.store == nil {
+		return nil
+	}
+	key := identityKey(account, userID)
+	if h == nil {
+		return r.store.Delete(highlightNamespace, key)
+	}
+	data, err := json.Marshal(keywords)
+	if err != nil {
+		return err
+	}
+	return r.store.Put(highlightNamespace, key, data)
+}
+
+// subscribedHighlights returns the keywords subscribed by the user userID of
+// account with the highlight command.
+func (r *Router) subscribedHighlights(account string, userID string) []string {
+	r.highlightsMu.RLock()
+	defer r.highlightsMu.RUnlock()
+
+	for _, h := range r.highlights {
+		if h.subscribed && h.Account == account && h.Target == userID {
+			return h.Keywords
+		}
+	}
+	return nil
+}
+
+// loadHighlights reads the highlights subscribed with the highlight command
+// kept in the storage. Those of the accounts not used anymore are skipped.
+func (r *Router) loadHighlights() error {
+	if r.store == nil {
+		return nil
+	}
+	keys, err := r.store.Keys(highlightNamespace)
+	if err != nil {
+		return fmt.Errorf("reading the highlights failed: %w", err)
+	}
+
+	r.highlightsMu.Lock()
+	defer r.highlightsMu.Unlock()
+	loaded := 0
+	for _, key := range keys {
+		account, userID, _ := strings.Cut(key, " ")
+		if r.getBridge(account) == nil {
+			continue
+		}
+		var keywords []string
+		data, err := r.store.Get(highlightNamespace, key)
+		if err != nil || json.Unmarshal(data, &keywords) != nil || len(keywords) == 0 {
+			continue
+		}
+		h, err := newHighlight(config.Highlight{Account: account, Target: userID, Keywords: keywords})
+		if err != nil {
+			continue
+		}
+		h.subscribed = true
+		r.highlights = append(r.highlights, h)
+		loaded++
+	}
+	if loaded > 0 {
+		r.logger.Infof("Loaded %d highlight subscriptions", loaded)
+	}
+	return nil
+}
+
+// matches returns true if the text of msg has to be forwarded to the target
+// of h, when it is relayed by a gateway of its scope.
+func (h *highlight) matches(msg *config.Message) bool {
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	// Don't loop on the private conversation with the target
+	if msg.Account == h.Account && msg.Channel == h.Target {
+		return false
+	}
+	return h.re.MatchString(msg.Text)
+}
+
+// inScope returns true if the messages relayed by gw are checked by h.
+func (h *highlight) inScope(gw *Gateway) bool {
+	return len(h.Gateways) == 0 || slices.Contains(h.Gateways, gw.Name)
+}
+
+// matchingHighlights returns the highlights whose keywords msg contains. The
+// messages of the targets themselves, on any of their linked accounts, aren't
+// forwarded to them.
+func (r *Router) matchingHighlights(msg *config.Message) []*highlight {
+	r.highlightsMu.RLock()
+	defer r.highlightsMu.RUnlock()
+
+	var matching []*highlight
+	for _, h := range r.highlights {
+		if h.matches(msg) && !r.sameUser(msg.Account, msg.UserID, h.Account, h.Target) {
+			matching = append(matching, h)
+		}
+	}
+	return matching
+}
+
+// forwardHighlights forwards msg, which gw doesn't ignore, to the targets of
+// the highlights of its scope. The highlights not forwarded are returned.
+func (r *Router) forwardHighlights(gw *Gateway, msg *config.Message, highlights []*highlight) []*highlight {
+	if len(highlights) == 0 {
+		return nil
+	}
+	if _, ok := gw.channels()[getChannelID(msg)]; !ok {
+		return highlights
+	}
+
+	var remaining []*highlight
+	for _, h := range highlights {
+		if !h.inScope(gw) {
+			remaining = append(remaining, h)
+			continue
+		}
+
+		dest, target := r.highlightTarget(h)
+		if dest == nil {
+			continue
+		}
+
+		r.logger.Debugf("Forwarding highlight from %s (%s) to %s on %s", msg.Channel, msg.Account, target, dest.Account)
+		_, err := sendWithTimeout(dest, config.Message{
+			Text:     fmt.Sprintf("%s in %s (%s): %s", msg.Username, msg.Channel, msg.Account, msg.Text),
+			Channel:  target,
+			Account:  dest.Account,
+			Protocol: dest.Protocol,
+			Extra:    make(map[string][]any),
+		})
+		if err != nil {
+			r.logger.Errorf("Forwarding highlight to %s on %s failed: %s", target, dest.Account, err)
+		}
+	}
+	return remaining
+}
+
+// highlightTarget returns the bridge and the target to forward the highlights
+// of h to: its own, or the user linked to its target on another account (see
+// the identity command) while its bridge isn't connected.
+func (r *Router) highlightTarget(h *highlight) (*bridge.Bridge, string) {
+	if r.bridgeConnected(h.Account) {
+		return r.getBridge(h.Account), h.Target
+	}
+	users := r.linkedUsers(h.Account, h.Target)
+	accounts := make([]string, 0, len(users))
+	for account := range users {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		if account != h.Account && r.bridgeConnected(account) {
+			return r.getBridge(account), users[account]
+		}
+	}
+	// the bridge can still send while it is reconnected
+	if r.bridgeStarted(h.Account) {
+		return r.getBridge(h.Account), h.Target
+	}
+	return nil, ""
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:  "highlight",
+		Usage: "[keyword...|off]",
+		Help:  "forward you the messages containing one of the keywords privately, on this network or the ones linked with identity",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			br := r.getBridge(msg.Account)
+			if br == nil {
+				return "", fmt.Errorf("unknown account %s", msg.Account)
+			}
+			userID, ok := verifiedUser(br, msg)
+			if !ok {
+				return "", fmt.Errorf("the users of %s can't be told apart, ask an admin to add a [[highlight]] for you", br.Protocol)
+			}
+			switch {
+			case len(args) == 0:
+				keywords := r.subscribedHighlights(br.Account, userID)
+				if len(keywords) == 0 {
+					return "You have no highlights, send highlight <keyword...> to subscribe", nil
+				}
+				return "Your highlights: " + strings.Join(keywords, ", "), nil
+			case len(args) == 1 && strings.EqualFold(args[0], "off"):
+				if len(r.subscribedHighlights(br.Account, userID)) == 0 {
+					return "", errors.New("you have no highlights")
+				}
+				if err := r.subscribeHighlights(br.Account, userID, nil); err != nil {
+					return "", err
+				}
+				return "Your highlights are removed", nil
+			}
+			if err := r.subscribeHighlights(br.Account, userID, args); err != nil {
+				return "", err
+			}
+			r.logger.Infof("%s (%s) on %s subscribed the highlights %s", msg.Username, userID, br.Account, strings.Join(args, ", "))
+			return fmt.Sprintf("The messages containing %s are forwarded to you on %s", strings.Join(args, ", "), br.Account), nil
+		},
+	})
+}
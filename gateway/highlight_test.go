@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dmBridger records the messages sent to target.
+type dmBridger struct {
+	bridge.Bridger
+
+	target string
+	sent   []config.Message
+}
+
+func (b *dmBridger) Send(msg config.Message) (string, error) {
+	if msg.Channel == b.target {
+		b.sent = append(b.sent, msg)
+	}
+	return "", nil
+}
+
+func TestHighlights(t *testing.T) {
+	r := maketestRouter([]byte(string(testconfig3) + `
+[[highlight]]
+account="irc.zzz"
+target="alice"
+keywords=["deploy","@alice"]
+gateways=["bridge"]
+`))
+	irc := r.getBridge(ircTestAccount)
+	dm := &dmBridger{Bridger: irc.Bridger, target: "alice"}
+	irc.Bridger = dm
+	r.markBridgeStarted(ircTestAccount)
+	r.getBridge(slackTestAccount).SetString("IgnoreNicks", "carol")
+
+	for _, msg := range []config.Message{
+		{Text: "Deploy is done", Username: "bob", Channel: "-1111111111111", Account: tgTestAccount},
+		{Text: "ping @alice!", Username: "bob", Channel: "irc", Account: slackTestAccount},
+		{Text: "redeployed", Username: "bob", Channel: "-1111111111111", Account: tgTestAccount},
+		// not in the gateways of the highlight
+		{Text: "deploy", Username: "bob", Channel: "--444444444444", Account: tgTestAccount},
+		{Text: "deploy", Username: "bob", Channel: "alice", Account: ircTestAccount},
+		{Text: "deploy", Event: config.EventJoinLeave, Channel: "-1111111111111", Account: tgTestAccount},
+		// ignored by the gateway
+		{Text: "deploy", Username: "carol", Channel: "irc", Account: slackTestAccount},
+	} {
+		msg.Protocol = r.getBridge(msg.Account).Protocol
+		r.routeMessage(msg, r.sortedGateways(), false, r.matchingHighlights(&msg))
+	}
+	assert.Equal(t, []config.Message{
+		{Text: "bob in -1111111111111 (telegram.zzz): Deploy is done", Channel: "alice", Account: ircTestAccount, Protocol: "irc", Extra: map[string][]any{}},
+		{Text: "bob in irc (slack.zzz): ping @alice!", Channel: "alice", Account: ircTestAccount, Protocol: "irc", Extra: map[string][]any{}},
+	}, dm.sent)
+
+	// the subscribers of the highlight command get them on the accounts
+	// linked to theirs while theirs isn't connected
+	store := storage.NewMemory()
+	r.store = store
+	tg := r.getBridge(tgTestAccount)
+	tgDM := &dmBridger{Bridger: tg.Bridger, target: "12345"}
+	tg.Bridger = tgDM
+	r.markBridgeStarted(tgTestAccount)
+	bob := &config.Message{UserID: "U1", Account: slackTestAccount}
+	slack := r.getBridge(slackTestAccount)
+	assert.Equal(t, "You have no highlights, send highlight <keyword...> to subscribe", r.runCommand(slack, bob, "highlight"))
+	assert.Equal(t, "The messages containing release, @bob are forwarded to you on slack.zzz", r.runCommand(slack, bob, "highlight release @bob"))
+	assert.Equal(t, "Your highlights: release, @bob", r.runCommand(slack, bob, "highlight"))
+	code, err := r.startLink(slackTestAccount, "U1", time.Now())
+	require.NoError(t, err)
+	_, err = r.confirmLink(tgTestAccount, "12345", code, time.Now())
+	require.NoError(t, err)
+	for _, msg := range []config.Message{
+		{Text: "release is out", Username: "carol", UserID: "carol", Channel: "#main", Account: ircTestAccount},
+		// their own messages aren't forwarded to them
+		{Text: "release is out", Username: "bob", UserID: "12345", Channel: "-1111111111111", Account: tgTestAccount},
+	} {
+		msg.Protocol = r.getBridge(msg.Account).Protocol
+		r.routeMessage(msg, r.sortedGateways(), false, r.matchingHighlights(&msg))
+	}
+	assert.Equal(t, []config.Message{
+		{Text: "carol in #main (irc.zzz): release is out", Channel: "12345", Account: tgTestAccount, Protocol: "telegram", Extra: map[string][]any{}},
+	}, tgDM.sent)
+
+	// the subscriptions are kept across restarts
+	restarted := maketestRouter(testconfig3)
+	restarted.store = store
+	require.NoError(t, restarted.loadHighlights())
+	assert.Equal(t, []string{"release", "@bob"}, restarted.subscribedHighlights(slackTestAccount, "U1"))
+	assert.Equal(t, "Your highlights are removed", restarted.runCommand(slack, bob, "highlight off"))
+	keys, err := store.Keys(highlightNamespace)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.Equal(t, "highlight failed: the users of irc can't be told apart, ask an admin to add a [[highlight]] for you", r.runCommand(r.getBridge(ircTestAccount), &config.Message{UserID: "bob@example.com", Account: ircTestAccount}, "highlight deploy"))
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	_, err = NewRouter(logger, config.NewConfigFromString(logger, []byte(string(testconfig3)+`
+[[highlight]]
+account="irc.unknown"
+target="alice"
+keywords=["deploy"]
+`)), bridgemap.FullMap)
+	assert.Error(t, err)
+}
@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+const (
+	// identityNamespace is where the identity map is kept in the storage:
+	// the identity of the linked users, by account and user ID.
+	identityNamespace = "identities"
+	// identityLinkTTL is how long the code given by "identity link" can
+	// be sent from the other account.
+	identityLinkTTL = 10 * time.Minute
+)
+
+// identities is the identity map: the users of several networks who linked
+// their accounts with the identity control command, and are then known as the
+// same person, eg. to forward their highlights on another network.
+type identities struct {
+	sync.RWMutex
+
+	// ids holds the identity of the linked users, by identityKey
+	ids map[string]string
+	// codes holds the users waiting for the confirmation of a link, by
+	// code
+	codes map[string]pendingLink
+}
+
+// pendingLink is a link started by the user key, until expires.
+type pendingLink struct {
+	key     string
+	expires time.Time
+}
+
+func newIdentities() *identities {
+	return &identities{ids: make(map[string]string), codes: make(map[string]pendingLink)}
+}
+
+// identityKey is the key of the user userID of account.
+func identityKey(account string, userID string) string {
+	return account + " " + userID
+}
+
+// linkedUsers returns the user IDs of the user userID of account on the
+// accounts it is linked to, by account, itself included.
+func (r *Router) linkedUsers(account string, userID string) map[string]string {
+	users := map[string]string{account: userID}
+	if userID == "" {
+		return users
+	}
+	r.identities.RLock()
+	defer r.identities.RUnlock()
+
+	id, ok := r.identities.ids[identityKey(account, userID)]
+	if !ok {
+		return users
+	}
+	for key, other := range r.identities.ids {
+		if other == id {
+			linkedAccount, linkedUserID, _ := strings.Cut(key, " ")
+			users[linkedAccount] = linkedUserID
+		}
+	}
+	return users
+}
+
+// mentionNames returns the user IDs of the users of account linked in the
+// identity map, by the names they can be mentioned with from the other
+// networks: their user ID, and the last nick they were seen with on every
+// account they are linked to, account included.
+func (r *Router) mentionNames(account string) map[string]string {
+	r.identities.RLock()
+	defer r.identities.RUnlock()
+
+	// the user IDs on account, by identity
+	users := make(map[string]string)
+	for key, id := range r.identities.ids {
+		if linkedAccount, userID, _ := strings.Cut(key, " "); linkedAccount == account {
+			users[id] = userID
+		}
+	}
+	names := make(map[string]string, len(users))
+	for key, id := range r.identities.ids {
+		userID, ok := users[id]
+		if !ok {
+			continue
+		}
+		names[userID] = userID
+		linkedAccount, linkedUserID, _ := strings.Cut(key, " ")
+		if seen, ok := r.seen.Get("id:" + linkedUserID); ok && seen.(lastSeen).Account == linkedAccount {
+			names[seen.(lastSeen).Username] = userID
+		}
+	}
+	return names
+}
+
+// sameUser returns true if the user userID of account is the user other of
+// otherAccount, or is linked to them.
+func (r *Router) sameUser(account string, userID string, otherAccount string, other string) bool {
+	if userID == "" || other == "" {
+		return false
+	}
+	linked, ok := r.linkedUsers(account, userID)[otherAccount]
+	return ok && linked == other
+}
+
+// startLink returns the code the user userID of account has to send from the
+// account to link.
+func (r *Router) startLink(account string, userID string, now time.Time) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(buf)
+
+	r.identities.Lock()
+	defer r.identities.Unlock()
+	for c, pending := range r.identities.codes {
+		if now.After(pending.expires) {
+			delete(r.identities.codes, c)
+		}
+	}
+	r.identities.codes[code] = pendingLink{key: identityKey(account, userID), expires: now.Add(identityLinkTTL)}
+	return code, nil
+}
+
+// confirmLink links the user userID of account with the user who got code,
+// and with the users linked to them, and keeps the identity map in the
+// storage when there is one. It returns the key of the user who got code.
+func (r *Router) confirmLink(account string, userID string, code string, now time.Time) (string, error) {
+	key := identityKey(account, userID)
+
+	r.identities.Lock()
+	defer r.identities.Unlock()
+
+	pending, ok := r.identities.codes[code]
+	if !ok || now.After(pending.expires) {
+		return "", errors.New("unknown or expired code, send identity link from your other account to get a new one")
+	}
+	if first, _, _ := strings.Cut(pending.key, " "); first == account {
+		return "", fmt.Errorf("send the code from another account than %s", account)
+	}
+	id, ok := r.identities.ids[pending.key]
+	if !ok {
+		id = pending.key
+	}
+	// the users linked to the sender join the identity as well, which
+	// has a single user per account
+	members := r.identities.members(id, pending.key)
+	changed := r.identities.members(r.identities.ids[key], key)
+	for k := range changed {
+		if _, ok := members[k]; ok {
+			continue
+		}
+		linkedAccount, _, _ := strings.Cut(k, " ")
+		for member := range members {
+			if strings.HasPrefix(member, linkedAccount+" ") {
+				return "", fmt.Errorf("%s is already linked to you, send identity unlink from it first", k)
+			}
+		}
+	}
+	delete(r.identities.codes, code)
+
+	for k := range members {
+		changed[k] = struct{}{}
+	}
+	for k := range changed {
+		r.identities.ids[k] = id
+		if r.store == nil {
+			continue
+		}
+		if err := r.store.Put(identityNamespace, k, []byte(id)); err != nil {
+			return "", err
+		}
+	}
+	return pending.key, nil
+}
+
+// members returns the keys of the users of the identity id, and key. The
+// identities must be locked.
+func (ids *identities) members(id string, key string) map[string]struct{} {
+	members := map[string]struct{}{key: {}}
+	if id == "" {
+		return members
+	}
+	for k, other := range ids.ids {
+		if other == id {
+			members[k] = struct{}{}
+		}
+	}
+	return members
+}
+
+// unlink removes the user userID of account from the identity map.
+func (r *Router) unlink(account string, userID string) (bool, error) {
+	key := identityKey(account, userID)
+
+	r.identities.Lock()
+	defer r.identities.Unlock()
+
+	if _, ok := r.identities.ids[key]; !ok {
+		return false, nil
+	}
+	delete(r.identities.ids, key)
+	if r.store == nil {
+		return true, nil
+	}
+	return true, r.store.Delete(identityNamespace, key)
+}
+
+// loadIdentities reads the identity map kept in the storage.
+func (r *Router) loadIdentities() error {
+	if r.store == nil {
+		return nil
+	}
+	keys, err := r.store.Keys(identityNamespace)
+	if err != nil {
+		return fmt.Errorf("reading the identity map failed: %w", err)
+	}
+
+	r.identities.Lock()
+	defer r.identities.Unlock()
+	for _, key := range keys {
+		if data, err := r.store.Get(identityNamespace, key); err == nil {
+			r.identities.ids[key] = string(data)
+		}
+	}
+	if len(keys) > 0 {
+		r.logger.Infof("Loaded %d linked users", len(keys))
+	}
+	return nil
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:  "identity",
+		Usage: "[link|<code>|unlink]",
+		Help:  "link your accounts on several networks: send identity link, then the code from the other network; without argument, list them",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			br := r.getBridge(msg.Account)
+			if br == nil {
+				return "", fmt.Errorf("unknown account %s", msg.Account)
+			}
+			userID, ok := verifiedUser(br, msg)
+			if !ok {
+				return "", fmt.Errorf("the users of %s can't be told apart, their accounts can't be linked", br.Protocol)
+			}
+			switch {
+			case len(args) == 0:
+				users := r.linkedUsers(br.Account, userID)
+				if len(users) == 1 {
+					return "Your account isn't linked, send identity link to link it", nil
+				}
+				lines := make([]string, 0, len(users))
+				for account, id := range users {
+					lines = append(lines, identityKey(account, id))
+				}
+				sort.Strings(lines)
+				return strings.Join(lines, "\n"), nil
+			case len(args) > 1:
+				return "", errors.New("too many arguments")
+			case strings.EqualFold(args[0], "link"):
+				code, err := r.startLink(br.Account, userID, time.Now())
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Send identity %s from your account on the other network within %s", code, identityLinkTTL), nil
+			case strings.EqualFold(args[0], "unlink"):
+				unlinked, err := r.unlink(br.Account, userID)
+				if err != nil {
+					return "", err
+				}
+				if !unlinked {
+					return "Your account isn't linked", nil
+				}
+				return "Unlinked", nil
+			}
+			linked, err := r.confirmLink(br.Account, userID, args[0], time.Now())
+			if err != nil {
+				return "", err
+			}
+			r.logger.Infof("%s (%s) on %s linked to %s", msg.Username, userID, br.Account, linked)
+			return fmt.Sprintf("Linked to %s", linked), nil
+		},
+	})
+}
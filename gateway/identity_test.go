@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentity(t *testing.T) {
+	store := storage.NewMemory()
+	r := maketestRouter(testconfig3)
+	r.store = store
+	tg := r.getBridge(tgTestAccount)
+	slack := r.getBridge(slackTestAccount)
+	irc := r.getBridge(ircTestAccount)
+	alice := &config.Message{UserID: "12345", Account: tgTestAccount}
+	aliceSlack := &config.Message{UserID: "U1", Account: slackTestAccount}
+
+	assert.Equal(t, "Your account isn't linked, send identity link to link it", r.runCommand(tg, alice, "identity"))
+	reply := r.runCommand(tg, alice, "identity link")
+	code, ok := strings.CutPrefix(reply, "Send identity ")
+	require.True(t, ok, reply)
+	code, _, _ = strings.Cut(code, " ")
+
+	// the code has to be sent from another account
+	assert.Equal(t, "identity failed: send the code from another account than telegram.zzz", r.runCommand(tg, &config.Message{UserID: "67890", Account: tgTestAccount}, "identity "+code))
+	// anyone can take the ident@host of someone else on irc
+	assert.Equal(t, "identity failed: the users of irc can't be told apart, their accounts can't be linked", r.runCommand(irc, &config.Message{UserID: "alice@example.com", Account: ircTestAccount}, "identity "+code))
+	assert.Equal(t, "Linked to telegram.zzz 12345", r.runCommand(slack, aliceSlack, "identity "+code))
+	assert.Equal(t, "identity failed: unknown or expired code, send identity link from your other account to get a new one", r.runCommand(slack, aliceSlack, "identity "+code))
+	assert.Equal(t, "slack.zzz U1\ntelegram.zzz 12345", r.runCommand(slack, aliceSlack, "identity"))
+	assert.True(t, r.sameUser(slackTestAccount, "U1", tgTestAccount, "12345"))
+	assert.False(t, r.sameUser(slackTestAccount, "U2", tgTestAccount, "12345"))
+
+	// an identity has a single user per account
+	code, err := r.startLink(tgTestAccount, "67890", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "identity failed: telegram.zzz 12345 is already linked to you, send identity unlink from it first", r.runCommand(slack, aliceSlack, "identity "+code))
+
+	// the codes expire
+	code, err = r.startLink(tgTestAccount, "67890", time.Now())
+	require.NoError(t, err)
+	_, err = r.confirmLink(slackTestAccount, "U2", code, time.Now().Add(identityLinkTTL+time.Second))
+	assert.Error(t, err)
+
+	// the identity map is kept across restarts
+	restarted := maketestRouter(testconfig3)
+	restarted.store = store
+	require.NoError(t, restarted.loadIdentities())
+	assert.Equal(t, map[string]string{slackTestAccount: "U1", tgTestAccount: "12345"}, restarted.linkedUsers(tgTestAccount, "12345"))
+	assert.Equal(t, "Unlinked", restarted.runCommand(tg, alice, "identity unlink"))
+	assert.Equal(t, map[string]string{slackTestAccount: "U1"}, restarted.linkedUsers(slackTestAccount, "U1"))
+	keys, err := store.Keys(identityNamespace)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"slack.zzz U1"}, keys)
+}
+
+func TestMentionNames(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	code, err := r.startLink(tgTestAccount, "12345", time.Now())
+	require.NoError(t, err)
+	_, err = r.confirmLink(slackTestAccount, "U1", code, time.Now())
+	require.NoError(t, err)
+	r.recordSeen(&config.Message{Username: "alice", UserID: "U1", Account: slackTestAccount})
+	// the nicks seen on another account than the linked one aren't theirs
+	r.recordSeen(&config.Message{Username: "bob", UserID: "12345", Account: ircTestAccount})
+
+	assert.Equal(t, map[string]string{"12345": "12345", "alice": "12345"}, r.mentionNames(tgTestAccount))
+	assert.Equal(t, map[string]string{"U1": "U1", "alice": "U1"}, r.mentionNames(slackTestAccount))
+	assert.Empty(t, r.mentionNames(ircTestAccount))
+}
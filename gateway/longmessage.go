@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+const (
+	// defaultLongMessagePreview is the length of the preview of long messages,
+	// when the gateway has no LongMessagePreview.
+	defaultLongMessagePreview = 500
+	// longMessageFile is the name of the file the full text is stored in.
+	longMessageFile = "message.txt"
+)
+
+// checkLongMessages warns when the gateway summarizes long messages but can't,
+// because no media server is configured.
+func (gw *Gateway) checkLongMessages() {
+	if gw.MyConfig.LongMessageLength > 0 && gw.BridgeValues().General.MediaDownloadPath == "" {
+		gw.logger.Warnf("Gateway %s has LongMessageLength but no MediaDownloadPath is configured, long messages will not be summarized", gw.Name)
+	}
+}
+
+// longMessageLength returns the LongMessageLength of the account of dest, or
+// else of the gateway.
+func (gw *Gateway) longMessageLength(dest *bridge.Bridge) int {
+	if dest.IsKeySet("LongMessageLength") {
+		return dest.GetInt("LongMessageLength")
+	}
+	return gw.MyConfig.LongMessageLength
+}
+
+// isLongMessage returns true if msg is longer than the LongMessageLength of
+// dest, and is summarized for it.
+func (gw *Gateway) isLongMessage(msg *config.Message, dest *bridge.Bridge) bool {
+	limit := gw.longMessageLength(dest)
+	if limit <= 0 || gw.BridgeValues().General.MediaDownloadPath == "" {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	return utf8.RuneCountInString(msg.Text) > limit
+}
+
+// summarizeLongMessage returns a preview of the text of msg and a link to the
+// full text, stored on the media server. Returns the text as is if it can't be
+// stored.
+func (gw *Gateway) summarizeLongMessage(msg *config.Message) string {
+
+	data := []byte(msg.Text)
+	fi := config.FileInfo{Name: longMessageFile, Data: &data}
+	sha1sum := fmt.Sprintf("%x", sha1.Sum(data))[:8] //nolint:gosec
+	if err := gw.handleFilesLocal(&fi, sha1sum); err != nil {
+		gw.logger.Errorf("Failed to store the long message of %s: %s", msg.Username, err)
+		return msg.Text
+	}
+	url := helper.MediaServerURL(gw.BridgeValues().General.MediaServerDownload, sha1sum, fi.Name)
+
+	previewLength := gw.MyConfig.LongMessagePreview
+	if previewLength <= 0 {
+		previewLength = defaultLongMessagePreview
+	}
+	gw.logger.Debugf("Summarizing message of %d characters from %s, full text at %s", utf8.RuneCountInString(msg.Text), msg.Username, url)
+	return previewText(msg.Text, previewLength) + "… (full message: " + url + ")"
+}
+
+// previewText returns the beginning of text, at most length characters, cut at
+// a word boundary when there is one in the second half of the preview.
+func previewText(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	runes := []rune(text)[:length]
+	for i := len(runes) - 1; i > length/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			runes = runes[:i]
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes), unicode.IsSpace)
+}
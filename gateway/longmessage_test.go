@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongMessages(t *testing.T) {
+	dir := t.TempDir()
+	r := maketestRouter([]byte(`
+[general]
+MediaDownloadPath="` + dir + `"
+MediaServerDownload="https://example.com/media/"
+[irc.zzz]
+server=""
+LongMessageLength=100
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="summarized"
+enable=true
+LongMessageLength=20
+LongMessagePreview=10
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+
+[[gateway]]
+name="relayed"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#other"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="other"
+`))
+	text := "the quick brown fox jumps over the lazy dog"
+	summarized, relayed := r.Gateways["summarized"], r.Gateways["relayed"]
+	irc, slack := summarized.Bridges[ircTestAccount], summarized.Bridges[slackTestAccount]
+	msg := &config.Message{Text: text, Username: "user", Account: slackTestAccount, Channel: "main"}
+	assert.False(t, relayed.isLongMessage(msg, slack))
+	// the LongMessageLength of the account comes before the one of the gateway
+	assert.True(t, summarized.isLongMessage(msg, slack))
+	assert.False(t, summarized.isLongMessage(msg, irc))
+	assert.True(t, relayed.isLongMessage(&config.Message{Text: strings.Repeat("a", 101)}, irc))
+
+	sha1sum := fmt.Sprintf("%x", sha1.Sum([]byte(text)))[:8] //nolint:gosec
+	assert.Equal(t, "the quick… (full message: https://example.com/media/"+sha1sum+"/message.txt)", summarized.summarizeLongMessage(msg))
+	assert.Equal(t, text, msg.Text)
+	data, err := os.ReadFile(filepath.Join(dir, sha1sum, "message.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, text, string(data))
+
+	short := &config.Message{Text: "short enough", Username: "user", Account: slackTestAccount, Channel: "main"}
+	assert.False(t, summarized.isLongMessage(short, slack))
+	join := &config.Message{Text: text, Event: config.EventJoinLeave, Account: slackTestAccount, Channel: "main"}
+	assert.False(t, summarized.isLongMessage(join, slack))
+}
+
+func TestPreviewText(t *testing.T) {
+	assert.Equal(t, "short", previewText("short", 10))
+	assert.Equal(t, "the quick", previewText("the quick brown fox", 12))
+	assert.Equal(t, "abcdefghij", previewText("abcdefghijklmnop", 10))
+	assert.Equal(t, "ééééé", previewText("éééééé", 5))
+}
@@ -0,0 +1,190 @@
+package gateway
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+const (
+	// defaultMediaWorkers is the number of files of a gateway handled at the
+	// same time, unless MediaWorkers is set.
+	defaultMediaWorkers = 4
+	// defaultMediaTimeout is how long a message waits for its files, unless
+	// MediaTimeout is set.
+	defaultMediaTimeout = time.Minute
+)
+
+// mediaPool handles the files of the messages of a gateway with a bounded
+// number of workers. The messages wait for their files in the background,
+// and are relayed with the files handled in time when it takes too long.
+type mediaPool struct {
+	startOnce sync.Once
+	jobs      chan mediaJob
+
+	queued    atomic.Int64
+	busy      atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+	timedOut  atomic.Int64
+	// nanos is the total processing time of the files
+	nanos atomic.Int64
+}
+
+// mediaJob is a file of a message waiting for a worker.
+type mediaJob struct {
+	index   int
+	fi      config.FileInfo
+	results chan<- mediaResult
+}
+
+type mediaResult struct {
+	index int
+	fi    config.FileInfo
+	err   error
+}
+
+// MediaStats are the counters of the media pool of a gateway.
+type MediaStats struct {
+	Gateway string `json:"gateway"`
+	// Queued is the number of files waiting for a worker
+	Queued    int64   `json:"queued"`
+	Busy      int64   `json:"busy"`
+	Processed int64   `json:"processed"`
+	Failed    int64   `json:"failed"`
+	TimedOut  int64   `json:"timed_out"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// mediaWorkers returns the number of workers of the media pool.
+func (gw *Gateway) mediaWorkers() int {
+	if workers := gw.BridgeValues().General.MediaWorkers; workers > 0 {
+		return workers
+	}
+	return defaultMediaWorkers
+}
+
+// mediaTimeout returns how long a message waits for its files.
+func (gw *Gateway) mediaTimeout() time.Duration {
+	if timeout := gw.BridgeValues().General.MediaTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultMediaTimeout
+}
+
+// startMediaPool starts the workers on the first message with files, the
+// gateways without media server don't need them.
+func (gw *Gateway) startMediaPool() {
+	gw.media.startOnce.Do(func() {
+		workers := gw.mediaWorkers()
+		gw.media.jobs = make(chan mediaJob)
+		for i := 0; i < workers; i++ {
+			go gw.mediaWorker()
+		}
+	})
+}
+
+func (gw *Gateway) mediaWorker() {
+	for job := range gw.media.jobs {
+		gw.media.queued.Add(-1)
+		gw.media.busy.Add(1)
+		start := time.Now()
+		fi, err := gw.handleFile(job.fi)
+		gw.media.nanos.Add(int64(time.Since(start)))
+		gw.media.busy.Add(-1)
+		gw.media.processed.Add(1)
+		if err != nil {
+			gw.media.failed.Add(1)
+		}
+		job.results <- mediaResult{index: job.index, fi: fi, err: err}
+	}
+}
+
+// processFiles runs the files through the workers, and returns them with the
+// results received before the timeout. The files not handled in time are
+// returned unchanged.
+func (gw *Gateway) processFiles(files []interface{}) []interface{} {
+	gw.startMediaPool()
+
+	resolved := make([]interface{}, len(files))
+	copy(resolved, files)
+	// buffered for all the files, so that the workers never block on the
+	// messages which timed out
+	results := make(chan mediaResult, len(files))
+	timeout := time.NewTimer(gw.mediaTimeout())
+	defer timeout.Stop()
+
+	pending := 0
+	for i, f := range files {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		gw.media.queued.Add(1)
+		select {
+		case gw.media.jobs <- mediaJob{index: i, fi: fi, results: results}:
+			pending++
+		case <-timeout.C:
+			gw.media.queued.Add(-1)
+			return gw.mediaTimedOut(resolved, results, pending+len(files)-i)
+		}
+	}
+	for ; pending > 0; pending-- {
+		select {
+		case res := <-results:
+			gw.applyResult(resolved, res)
+		case <-timeout.C:
+			return gw.mediaTimedOut(resolved, results, pending)
+		}
+	}
+	return resolved
+}
+
+func (gw *Gateway) applyResult(resolved []interface{}, res mediaResult) {
+	if res.err != nil {
+		gw.logger.Error(res.err)
+		return
+	}
+	resolved[res.index] = res.fi
+}
+
+// mediaTimedOut adds the results already received to the files, of which
+// pending were waiting when the timeout fired.
+func (gw *Gateway) mediaTimedOut(resolved []interface{}, results chan mediaResult, pending int) []interface{} {
+	for {
+		select {
+		case res := <-results:
+			gw.applyResult(resolved, res)
+			pending--
+		default:
+			gw.media.timedOut.Add(int64(pending))
+			gw.logger.Warnf("Timed out waiting for %d files, relaying the message without their media server URL", pending)
+			return resolved
+		}
+	}
+}
+
+// mediaStats returns the counters of the media pool of the gateway.
+func (gw *Gateway) mediaStats() MediaStats {
+	return MediaStats{
+		Gateway:   gw.Name,
+		Queued:    gw.media.queued.Load(),
+		Busy:      gw.media.busy.Load(),
+		Processed: gw.media.processed.Load(),
+		Failed:    gw.media.failed.Load(),
+		TimedOut:  gw.media.timedOut.Load(),
+		Seconds:   time.Duration(gw.media.nanos.Load()).Seconds(),
+	}
+}
+
+// MediaStats returns the counters of the media pools of the gateways, ordered
+// by gateway.
+func (r *Router) MediaStats() []MediaStats {
+	stats := []MediaStats{}
+	for _, gw := range r.sortedGateways() {
+		stats = append(stats, gw.mediaStats())
+	}
+	return stats
+}
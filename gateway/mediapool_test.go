@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaPool(t *testing.T) {
+	dir := t.TempDir()
+	r := maketestRouter([]byte(`
+[general]
+MediaDownloadPath="` + dir + `"
+MediaServerDownload="https://example.com/media/"
+MediaWorkers=2
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="media"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`))
+	gw := r.Gateways["media"]
+	assert.Equal(t, 2, gw.mediaWorkers())
+	assert.Equal(t, defaultMediaTimeout, gw.mediaTimeout())
+
+	var files []interface{}
+	for i := range 5 {
+		data := []byte(fmt.Sprintf("file %d", i))
+		files = append(files, config.FileInfo{Name: fmt.Sprintf("file%d.txt", i), Data: &data})
+	}
+	msg := &config.Message{Text: "files", Account: slackTestAccount, Channel: "main", Extra: map[string][]interface{}{"file": files}}
+	require.True(t, gw.hasFilesToHandle(msg))
+	gw.handleFiles(msg)
+	for i, f := range msg.Extra["file"] {
+		fi := f.(config.FileInfo)
+		sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
+		assert.Equal(t, sha1sum, fi.SHA)
+		assert.Equal(t, fmt.Sprintf("https://example.com/media/%s/file%d.txt", sha1sum, i), fi.URL)
+		assert.FileExists(t, filepath.Join(dir, sha1sum, fi.Name))
+	}
+	stats := r.MediaStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "media", stats[0].Gateway)
+	assert.Equal(t, int64(5), stats[0].Processed)
+	assert.Zero(t, stats[0].Failed)
+	assert.Zero(t, stats[0].Queued)
+
+	// the results received when the timeout fires are kept
+	resolved := []interface{}{config.FileInfo{Name: "a"}, config.FileInfo{Name: "b"}}
+	results := make(chan mediaResult, 2)
+	results <- mediaResult{index: 1, fi: config.FileInfo{Name: "b", URL: "https://example.com/media/b"}}
+	resolved = gw.mediaTimedOut(resolved, results, 2)
+	assert.Equal(t, []interface{}{config.FileInfo{Name: "a"}, config.FileInfo{Name: "b", URL: "https://example.com/media/b"}}, resolved)
+	assert.Equal(t, int64(1), gw.mediaStats().TimedOut)
+
+	var metrics bytes.Buffer
+	writeMediaMetrics(&metrics, r.MediaStats())
+	assert.Contains(t, metrics.String(), "# TYPE matterbridge_media_files_total counter\n")
+	assert.Contains(t, metrics.String(), `matterbridge_media_files_total{gateway="media"} 5`)
+	assert.Contains(t, metrics.String(), `matterbridge_media_timeouts_total{gateway="media"} 1`)
+}
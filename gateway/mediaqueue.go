@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+)
+
+// mediaRateWindow is the period MediaRateLimit applies to.
+const mediaRateWindow = time.Minute
+
+// mediaQueue paces the messages with files sent to one channel.
+//
+// Messages with files are sent right away as long as the channel is under its
+// MediaRateLimit. Past it they are queued and sent in order when the limit
+// allows it, with the messages sent to the channel after them, so that their
+// replies and captions don't arrive first. The messages rate limited by the
+// bridge wait in it to be sent again the same way. On the protocols sending
+// albums, the files queued one after the other by a sender are sent as one
+// album, as long as the limit allows it.
+type mediaQueue struct {
+	sync.Mutex
+
+	// sent holds the send time of each file in the last mediaRateWindow
+	sent    []time.Time
+	queue   []queuedMessage
+	running bool
+}
+
+// mediaRateLimit returns the number of files which can be sent to a channel of
+// dest per minute, 0 for no limit.
+func mediaRateLimit(dest *bridge.Bridge) int {
+	return dest.GetInt("MediaRateLimit")
+}
+
+// getMediaQueue returns the media queue of the channel channelID.
+func (r *Router) getMediaQueue(channelID string) *mediaQueue {
+	r.Lock()
+	defer r.Unlock()
+
+	queue, ok := r.mediaQueues[channelID]
+	if !ok {
+		queue = &mediaQueue{}
+		r.mediaQueues[channelID] = queue
+	}
+	return queue
+}
+
+// pendingMediaQueue returns the media queue of the channel channelID while it
+// sends queued messages, nil otherwise.
+func (r *Router) pendingMediaQueue(channelID string) *mediaQueue {
+	r.Lock()
+	queue, ok := r.mediaQueues[channelID]
+	r.Unlock()
+	if !ok {
+		return nil
+	}
+
+	queue.Lock()
+	defer queue.Unlock()
+	if !queue.running {
+		return nil
+	}
+	return queue
+}
+
+// delay returns how long to wait before files can be sent. A message with more
+// files than limit is sent once nothing was sent in the last window. The queue
+// must be locked.
+func (q *mediaQueue) delay(now time.Time, files int, limit int) time.Duration {
+	for len(q.sent) > 0 && now.Sub(q.sent[0]) >= mediaRateWindow {
+		q.sent = q.sent[1:]
+	}
+
+	over := len(q.sent) + files - limit
+	if limit <= 0 || over <= 0 || len(q.sent) == 0 {
+		return 0
+	}
+	if over > len(q.sent) {
+		over = len(q.sent)
+	}
+	return q.sent[over-1].Add(mediaRateWindow).Sub(now)
+}
+
+// record adds files sent at now. The queue must be locked.
+func (q *mediaQueue) record(now time.Time, files int) {
+	for i := 0; i < files; i++ {
+		q.sent = append(q.sent, now)
+	}
+}
+
+// pacedSend sends msg to dest, queueing it when it has files and the channel
+// is over its MediaRateLimit, or when messages are queued for the channel. An
+// empty ID is returned for queued messages.
+func (gw *Gateway) pacedSend(dest *bridge.Bridge, msg config.Message, channelID string, canonicalID string) (string, error) {
+	files := len(msg.Extra["file"])
+	limit := mediaRateLimit(dest)
+	var q *mediaQueue
+	switch {
+	case msg.Event == config.EventUserTyping:
+		// typing notifications are useless once the queue is sent
+	case files > 0 && limit > 0:
+		q = gw.Router.getMediaQueue(channelID)
+	default:
+		q = gw.Router.pendingMediaQueue(channelID)
+	}
+	if q == nil {
+		return gw.guardedSend(dest, msg, channelID, canonicalID, true)
+	}
+
+	q.Lock()
+	now := time.Now()
+	priority := gw.priorityMessage(&msg)
+	if priority || !q.running && q.delay(now, files, limit) == 0 {
+		if priority {
+			gw.logger.Debugf("priority message from %s bypasses the MediaRateLimit of %s on %s", msg.UserID, msg.Channel, dest.Account)
+		}
+		// the files of the priority messages count against the limit too
+		q.record(now, files)
+		q.Unlock()
+		return gw.guardedSend(dest, msg, channelID, canonicalID, true)
+	}
+
+	if len(q.queue) >= maxQueuedMessages {
+		gw.logger.Warnf("Too many files queued for %s on %s, dropping the oldest message", msg.Channel, dest.Account)
+		dropped := q.queue[0]
+		gw.Router.auditMessage(auditDrop, auditMediaRateLimit, dropped.gw, &dropped.msg, dest.Account)
+		gw.Router.releaseFiles(dropped.files)
+		q.queue = q.queue[1:]
+	}
+	q.queue = append(q.queue, queuedMessage{gw: gw, msg: msg, channelID: channelID, canonicalID: canonicalID, files: gw.Router.holdFiles(&msg)})
+	gw.logger.Debugf("%s on %s is over its MediaRateLimit or has queued files, queued message (%d queued)", msg.Channel, dest.Account, len(q.queue))
+	q.start(gw, dest)
+	q.Unlock()
+	return "", nil
+}
+
+// retry queues queued, a rate limited message, to be sent again before the
+// other queued messages.
+func (q *mediaQueue) retry(gw *Gateway, dest *bridge.Bridge, queued queuedMessage) {
+	queued.files = gw.Router.holdFiles(&queued.msg)
+	q.Lock()
+	defer q.Unlock()
+	q.queue = append([]queuedMessage{queued}, q.queue...)
+	q.start(gw, dest)
+}
+
+// albumPart returns true if part, queued right after first, can be sent in
+// the same album: files without text of the same sender, none of them being
+// sent again after a rate limit.
+func albumPart(first *queuedMessage, part *queuedMessage) bool {
+	return part.gw == first.gw && part.msg.Event == "" && part.msg.Text == "" && len(part.msg.Extra["file"]) > 0 &&
+		part.msg.Account == first.msg.Account && part.msg.UserID == first.msg.UserID && part.msg.Username == first.msg.Username &&
+		part.msg.ParentID == first.msg.ParentID && !first.retried && !part.retried
+}
+
+// addAlbumPart adds the files of part to the message of queued, which holds
+// its spool files from then on. Only the ID of the first message of an album
+// is recorded.
+func (queued *queuedMessage) addAlbumPart(part queuedMessage) {
+	// the message can be shared with the other destinations
+	extra := maps.Clone(queued.msg.Extra)
+	extra["file"] = append(slices.Clone(queued.msg.Extra["file"]), part.msg.Extra["file"]...)
+	queued.msg.Extra = extra
+	queued.files = append(queued.files, part.files...)
+}
+
+// start runs the queue unless it is running. The queue must be locked.
+func (q *mediaQueue) start(gw *Gateway, dest *bridge.Bridge) {
+	if !q.running {
+		q.running = true
+		go gw.runMediaQueue(dest, q)
+	}
+}
+
+// runMediaQueue sends the messages of q in order when the rate limit allows
+// it, until the queue is empty.
+func (gw *Gateway) runMediaQueue(dest *bridge.Bridge, q *mediaQueue) {
+	for {
+		q.Lock()
+		if len(q.queue) == 0 {
+			q.running = false
+			q.Unlock()
+			return
+		}
+		next := q.queue[0]
+		files := len(next.msg.Extra["file"])
+		now := time.Now()
+		// the limit is read again as it is reloadable
+		limit := mediaRateLimit(dest)
+		delay := max(q.delay(now, files, limit), next.notBefore.Sub(now))
+		if delay <= 0 {
+			q.queue = q.queue[1:]
+			if _, ok := bridgemap.AlbumSupport[dest.Protocol]; ok && files > 0 {
+				for len(q.queue) > 0 && albumPart(&next, &q.queue[0]) {
+					more := len(q.queue[0].msg.Extra["file"])
+					if limit > 0 && files+more > limit || q.delay(now, files+more, limit) > 0 {
+						break
+					}
+					next.addAlbumPart(q.queue[0])
+					files += more
+					q.queue = q.queue[1:]
+				}
+			}
+			q.record(now, files)
+		}
+		q.Unlock()
+
+		if delay > 0 {
+			time.Sleep(delay)
+			continue
+		}
+
+		mID, err := next.gw.guardedSend(dest, next.msg, next.channelID, next.canonicalID, !next.retried)
+		gw.Router.releaseFiles(next.files)
+		if err != nil {
+			gw.logger.Errorf("Sending queued message to %s failed: %s", dest.Account, err)
+			continue
+		}
+		next.recordID(dest, mID)
+	}
+}
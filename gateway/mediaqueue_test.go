@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaQueueDelay(t *testing.T) {
+	now := time.Now()
+	q := &mediaQueue{}
+	assert.Equal(t, time.Duration(0), q.delay(now, 5, 2))
+
+	q.record(now.Add(-90*time.Second), 1)
+	q.record(now.Add(-50*time.Second), 1)
+	q.record(now.Add(-20*time.Second), 1)
+	assert.Equal(t, 10*time.Second, q.delay(now, 1, 2))
+	assert.Len(t, q.sent, 2)
+	assert.Equal(t, 40*time.Second, q.delay(now, 2, 2))
+	assert.Equal(t, 40*time.Second, q.delay(now, 5, 2))
+	assert.Equal(t, time.Duration(0), q.delay(now, 1, 3))
+}
+
+func TestPacedSend(t *testing.T) {
+	r, gw := newTestGateway()
+	tg := gw.Bridges[tgTestAccount]
+	flaky := &flakyBridger{Bridger: tg.Bridger}
+	tg.Bridger = flaky
+	channelID := "-1111111111111" + tgTestAccount
+	send := func(text string, files int) string {
+		msg := config.Message{Text: text, Extra: make(map[string][]any)}
+		for i := 0; i < files; i++ {
+			msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{Name: "image.png"})
+		}
+		mID, err := gw.pacedSend(tg, msg, channelID, "")
+		assert.NoError(t, err)
+		return mID
+	}
+
+	// Pacing is opt-in, for telegram too
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "id-image0", send("image0", 1))
+	}
+	assert.Empty(t, r.mediaQueues)
+
+	tg.SetInt("MediaRateLimit", 2)
+	defer tg.SetInt("MediaRateLimit", 0)
+	flaky.sent = nil
+	assert.Equal(t, "id-image1", send("image1", 1))
+	assert.Equal(t, "id-text1", send("text1", 0))
+	assert.Equal(t, "id-image2", send("image2", 1))
+	assert.Equal(t, "", send("image3", 1))
+	// Text messages wait for the files queued before them
+	assert.Equal(t, "", send("text2", 0))
+	assert.Equal(t, "", send("image4", 1))
+
+	q := r.getMediaQueue(channelID)
+	q.Lock()
+	var queued []string
+	for _, m := range q.queue {
+		queued = append(queued, m.msg.Text)
+	}
+	assert.Equal(t, []string{"image3", "text2", "image4"}, queued)
+	// Stop the queue, it would only send the next file in a minute
+	q.queue = nil
+	q.Unlock()
+	assert.Equal(t, []string{"image1", "text1", "image2"}, flaky.sent)
+}
+
+func TestPriorityMessages(t *testing.T) {
+	r, gw := newTestGateway()
+	tg := gw.Bridges[tgTestAccount]
+	flaky := &flakyBridger{Bridger: tg.Bridger}
+	tg.Bridger = flaky
+	tg.SetInt("MediaRateLimit", 1)
+	defer tg.SetInt("MediaRateLimit", 0)
+	gw.MyConfig.PriorityUserIDs = []string{"12345", ircTestAccount + "/alice@home.example"}
+	defer func() { gw.MyConfig.PriorityUserIDs = nil }()
+
+	assert.True(t, gw.priorityMessage(&config.Message{UserID: "12345", Account: tgTestAccount}))
+	assert.True(t, gw.priorityMessage(&config.Message{UserID: "alice@home.example", Account: ircTestAccount}))
+	assert.False(t, gw.priorityMessage(&config.Message{UserID: "alice@home.example", Account: tgTestAccount}))
+	assert.False(t, gw.priorityMessage(&config.Message{UserID: "67890", Account: tgTestAccount}))
+	assert.True(t, gw.priorityMessage(&config.Message{Event: config.EventAnnounce, Account: tgTestAccount}))
+
+	channelID := "-1111111111111" + tgTestAccount
+	send := func(text string, userID string) string {
+		msg := config.Message{Text: text, UserID: userID, Account: tgTestAccount, Extra: map[string][]any{
+			"file": {config.FileInfo{Name: "image.png"}},
+		}}
+		mID, err := gw.pacedSend(tg, msg, channelID, "")
+		assert.NoError(t, err)
+		return mID
+	}
+
+	assert.Equal(t, "id-image1", send("image1", "67890"))
+	assert.Equal(t, "", send("image2", "67890"))
+	// The priority messages skip the queue
+	assert.Equal(t, "id-emergency", send("emergency", "12345"))
+
+	q := r.getMediaQueue(channelID)
+	q.Lock()
+	assert.Len(t, q.queue, 1)
+	assert.Len(t, q.sent, 2)
+	q.queue = nil
+	q.Unlock()
+	assert.Equal(t, []string{"image1", "emergency"}, flaky.sent)
+}
+
+func TestMediaAlbums(t *testing.T) {
+	_, gw := newTestGateway()
+	message := func(text string, userID string, files int) queuedMessage {
+		msg := config.Message{Text: text, UserID: userID, Channel: "album", Account: slackTestAccount, Extra: make(map[string][]any)}
+		for i := 0; i < files; i++ {
+			msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{Name: "image.png"})
+		}
+		return queuedMessage{gw: gw, msg: msg}
+	}
+	first := message("holidays", "U1", 1)
+	run := func(account string) []config.Message {
+		dest := gw.Bridges[account]
+		dm := &dmBridger{Bridger: dest.Bridger, target: "album"}
+		dest.Bridger = dm
+		q := &mediaQueue{running: true, queue: []queuedMessage{
+			first,
+			message("", "U1", 1),
+			message("", "U1", 2),
+			message("", "U2", 1),
+			message("done", "U1", 0),
+		}}
+		gw.runMediaQueue(dest, q)
+		return dm.sent
+	}
+
+	// the files following the first message of the sender are sent with it
+	sent := run(tgTestAccount)
+	require.Len(t, sent, 3)
+	assert.Equal(t, "holidays", sent[0].Text)
+	assert.Len(t, sent[0].Extra["file"], 4)
+	assert.Len(t, sent[1].Extra["file"], 1)
+	assert.Equal(t, "done", sent[2].Text)
+	assert.Len(t, first.msg.Extra["file"], 1)
+
+	// but not to the protocols without albums
+	assert.Len(t, run(ircTestAccount), 5)
+}
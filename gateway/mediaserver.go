@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// mediaRetentionInterval is the time between two cleanups of the media
+// server, the first one runs on startup.
+const mediaRetentionInterval = time.Hour
+
+// mediaFile is a file placed on the media server, in MediaDownloadPath/<sha>.
+type mediaFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// startMediaRetention removes the files of the media server older than
+// MediaRetentionDays, and the oldest ones when they take more than
+// MediaRetentionSize bytes, on startup and every mediaRetentionInterval. The
+// settings are read before every cleanup.
+func (r *Router) startMediaRetention() {
+	dir := r.BridgeValues().General.MediaDownloadPath
+	if dir == "" {
+		return
+	}
+	r.run(func() {
+		for {
+			r.cleanMediaServer(dir)
+			if !r.sleep(mediaRetentionInterval) {
+				return
+			}
+		}
+	})
+}
+
+func (r *Router) cleanMediaServer(dir string) {
+	general := r.BridgeValues().General
+	if general.MediaRetentionDays <= 0 && general.MediaRetentionSize <= 0 {
+		return
+	}
+	maxAge := time.Duration(general.MediaRetentionDays) * 24 * time.Hour
+	removed, freed, err := cleanMediaDir(dir, maxAge, int64(general.MediaRetentionSize), time.Now())
+	if err != nil {
+		r.logger.Errorf("Cleaning the media server in %s failed: %s", dir, err)
+	}
+	if removed > 0 {
+		r.logger.Infof("Removed %d files (%d bytes) from the media server in %s", removed, freed, dir)
+	}
+}
+
+// cleanMediaDir removes the files in the subdirectories of dir older than
+// maxAge at now, then the oldest ones until the others take at most maxSize
+// bytes, and the subdirectories left empty. A maxAge or maxSize of 0 keeps the
+// files. Returns the number of files removed and their size.
+func cleanMediaDir(dir string, maxAge time.Duration, maxSize int64, now time.Time) (int, int64, error) {
+	files, err := listMediaFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	// oldest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+
+	removed, freed := 0, int64(0)
+	for _, file := range files {
+		expired := maxAge > 0 && now.Sub(file.modTime) > maxAge
+		if !expired && (maxSize <= 0 || total <= maxSize) {
+			break
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return removed, freed, fmt.Errorf("removing %s failed: %w", file.path, err)
+		}
+		// the directory of the SHA is removed once empty
+		_ = os.Remove(filepath.Dir(file.path))
+		removed++
+		freed += file.size
+		total -= file.size
+	}
+	return removed, freed, nil
+}
+
+// listMediaFiles returns the files placed in the subdirectories of dir by
+// handleFilesLocal.
+func listMediaFiles(dir string) ([]mediaFile, error) {
+	dir = filepath.Clean(dir)
+	var files []mediaFile
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && filepath.Dir(path) != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(filepath.Dir(path)) != dir {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil //nolint:nilerr // removed meanwhile
+		}
+		files = append(files, mediaFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
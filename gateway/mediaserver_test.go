@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanMediaDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	place := func(sha string, name string, size int, age time.Duration) string {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, sha), 0o755))
+		path := filepath.Join(dir, sha, name)
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0o600))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		return path
+	}
+	expired := place("aaaaaaaa", "old.png", 10, 40*24*time.Hour)
+	older := place("bbbbbbbb", "older.png", 30, 2*time.Hour)
+	recent := place("cccccccc", "recent.png", 30, time.Hour)
+	kept := place("cccccccc", "paste.txt", 10, time.Minute)
+	// not placed by the media server
+	other := filepath.Join(dir, "index.html")
+	require.NoError(t, os.WriteFile(other, make([]byte, 100), 0o600))
+
+	removed, freed, err := cleanMediaDir(dir, 0, 0, now)
+	require.NoError(t, err)
+	assert.Zero(t, removed, "no retention")
+	assert.Zero(t, freed)
+
+	removed, freed, err = cleanMediaDir(dir, 30*24*time.Hour, 0, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(10), freed)
+	assert.NoFileExists(t, expired)
+	assert.NoDirExists(t, filepath.Join(dir, "aaaaaaaa"))
+
+	removed, freed, err = cleanMediaDir(dir, 30*24*time.Hour, 15, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed, "the oldest files are removed first")
+	assert.Equal(t, int64(60), freed)
+	assert.NoFileExists(t, older)
+	assert.NoFileExists(t, recent)
+	assert.FileExists(t, kept)
+	assert.DirExists(t, filepath.Join(dir, "cccccccc"))
+	assert.FileExists(t, other)
+}
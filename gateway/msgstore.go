@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultMessageStoreDays is how long the IDs of the relayed messages
+	// are kept in the StorageBackend, unless MessageStoreDays is set.
+	defaultMessageStoreDays = 7
+	// messageStorePruneInterval is the time between the removals of the
+	// expired IDs from the StorageBackend.
+	messageStorePruneInterval = time.Hour
+	// messageStoreNamespace is the prefix of the namespaces of the IDs of
+	// the gateways, followed by their name.
+	messageStoreNamespace = "messages/"
+)
+
+// storedMsgIDs are the IDs of the copies of a message, as kept in the
+// StorageBackend so that edits, deletes and replies keep working after a
+// restart.
+type storedMsgIDs struct {
+	At  time.Time     `json:"at"`
+	IDs []storedMsgID `json:"ids"`
+}
+
+// storedMsgID is a BrMsgID, with the account of its bridge.
+type storedMsgID struct {
+	Account   string `json:"account"`
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// messageStoreTTL returns how long the IDs of the messages are kept.
+func (r *Router) messageStoreTTL() time.Duration {
+	days := r.BridgeValues().General.MessageStoreDays
+	if days <= 0 {
+		days = defaultMessageStoreDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// setMsgIDs sets the IDs of the copies of the message key, and keeps them in
+// the StorageBackend when it is set.
+func (gw *Gateway) setMsgIDs(key string, ids []*BrMsgID) {
+	gw.Messages.Add(key, ids)
+	gw.saveMsgIDs(key, ids)
+}
+
+// addMsgIDs adds ids to the IDs of the source message canonicalID, as
+// relayMessage does for the bridges it sends to itself, for the copies sent
+// later: by the outboxes, and the queues of the breakers and the media.
+func (gw *Gateway) addMsgIDs(canonicalID string, ids []*BrMsgID) {
+	if canonicalID == "" || len(ids) == 0 {
+		return
+	}
+	gw.Router.msgIDsMu.Lock()
+	defer gw.Router.msgIDsMu.Unlock()
+
+	v, _ := gw.Messages.Get(canonicalID)
+	known, _ := v.([]*BrMsgID)
+	gw.setMsgIDs(canonicalID, append(known, ids...))
+}
+
+// replaceMsgID replaces the ID old of account among the IDs of the source
+// message canonicalID with id, or removes it when id is empty. It returns
+// false when the message has no such ID.
+func (gw *Gateway) replaceMsgID(canonicalID string, account string, old string, id string) bool {
+	gw.Router.msgIDsMu.Lock()
+	defer gw.Router.msgIDsMu.Unlock()
+
+	v, _ := gw.Messages.Peek(canonicalID)
+	ids, _ := v.([]*BrMsgID)
+	for i, known := range ids {
+		if known.br.Account != account || known.ID != old {
+			continue
+		}
+		// the IDs may be read meanwhile, they are replaced rather than
+		// changed
+		replaced := append(ids[:i:i], ids[i+1:]...)
+		if id != "" {
+			replaced = slices.Clone(ids)
+			replaced[i] = &BrMsgID{known.br, id, known.ChannelID}
+		}
+		gw.setMsgIDs(canonicalID, replaced)
+		return true
+	}
+	return false
+}
+
+// saveMsgIDs keeps the IDs of the copies of the message key in the
+// StorageBackend, when it is set.
+func (gw *Gateway) saveMsgIDs(key string, ids []*BrMsgID) {
+	store := gw.Router.store
+	if store == nil {
+		return
+	}
+	stored := storedMsgIDs{At: time.Now(), IDs: make([]storedMsgID, 0, len(ids))}
+	for _, id := range ids {
+		stored.IDs = append(stored.IDs, storedMsgID{Account: id.br.Account, ID: id.ID, ChannelID: id.ChannelID})
+	}
+	data, err := json.Marshal(stored)
+	if err == nil {
+		err = store.Put(messageStoreNamespace+gw.Name, key, data)
+	}
+	if err != nil {
+		gw.logger.Errorf("Keeping the IDs of %s failed: %s", key, err)
+	}
+}
+
+// loadMessageStore restores the IDs of the messages relayed before a restart,
+// the most recent ones last so that they are kept by the caches, and removes
+// the expired ones.
+func (r *Router) loadMessageStore(now time.Time) {
+	if r.store == nil {
+		return
+	}
+	ttl := r.messageStoreTTL()
+	for _, gw := range r.sortedGateways() {
+		type loaded struct {
+			key    string
+			stored storedMsgIDs
+		}
+		var messages []loaded
+		r.pruneMessageStore(gw, now.Add(-ttl), func(key string, stored storedMsgIDs) {
+			messages = append(messages, loaded{key, stored})
+		})
+		sort.SliceStable(messages, func(i, j int) bool {
+			return messages[i].stored.At.Before(messages[j].stored.At)
+		})
+		for _, message := range messages {
+			ids := make([]*BrMsgID, 0, len(message.stored.IDs))
+			for _, id := range message.stored.IDs {
+				// the accounts removed from the gateway are skipped
+				if br, ok := gw.Bridges[id.Account]; ok {
+					ids = append(ids, &BrMsgID{br, id.ID, id.ChannelID})
+				}
+			}
+			gw.Messages.Add(message.key, ids)
+		}
+		if len(messages) > 0 {
+			r.logger.Infof("Restored the IDs of %d messages of gateway %s", len(messages), gw.Name)
+		}
+	}
+}
+
+// pruneMessageStore removes the IDs of the messages of gw stored before
+// oldest, and calls keep with the others when it isn't nil.
+func (r *Router) pruneMessageStore(gw *Gateway, oldest time.Time, keep func(key string, stored storedMsgIDs)) {
+	namespace := messageStoreNamespace + gw.Name
+	keys, err := r.store.Keys(namespace)
+	if err != nil {
+		r.logger.Errorf("Reading the message IDs of gateway %s failed: %s", gw.Name, err)
+		return
+	}
+	for _, key := range keys {
+		data, err := r.store.Get(namespace, key)
+		if err != nil {
+			continue
+		}
+		var stored storedMsgIDs
+		if err := json.Unmarshal(data, &stored); err != nil || stored.At.Before(oldest) {
+			if err := r.store.Delete(namespace, key); err != nil {
+				r.logger.Errorf("Removing the IDs of %s failed: %s", key, err)
+			}
+			continue
+		}
+		if keep != nil {
+			keep(key, stored)
+		}
+	}
+}
+
+// startMessageStore restores the IDs of the messages kept in the
+// StorageBackend, and removes the expired ones every messageStorePruneInterval.
+func (r *Router) startMessageStore() {
+	if r.store == nil {
+		return
+	}
+	r.loadMessageStore(time.Now())
+	r.run(func() {
+		for r.sleep(messageStorePruneInterval) {
+			oldest := time.Now().Add(-r.messageStoreTTL())
+			for _, gw := range r.sortedGateways() {
+				r.pruneMessageStore(gw, oldest, nil)
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	r, gw := newTestGateway()
+	store, err := storage.Open(storage.BackendSQLite, path)
+	require.NoError(t, err)
+	r.store = store
+	irc := gw.Bridges[ircTestAccount]
+	gw.setMsgIDs("telegram 1", []*BrMsgID{{irc, "irc 10", "#main" + ircTestAccount}})
+
+	// an expired message and one of an account removed since
+	old, err := json.Marshal(storedMsgIDs{At: time.Now().Add(-8 * 24 * time.Hour), IDs: []storedMsgID{{Account: ircTestAccount, ID: "irc 9"}}})
+	require.NoError(t, err)
+	require.NoError(t, r.store.Put(messageStoreNamespace+gw.Name, "telegram 0", old))
+	removed, err := json.Marshal(storedMsgIDs{At: time.Now(), IDs: []storedMsgID{{Account: "irc.removed", ID: "irc 11"}}})
+	require.NoError(t, err)
+	require.NoError(t, r.store.Put(messageStoreNamespace+gw.Name, "telegram 2", removed))
+	require.NoError(t, r.store.Close())
+
+	// the IDs are restored after a restart
+	restarted, gw := newTestGateway()
+	restarted.store, err = storage.Open(storage.BackendSQLite, path)
+	require.NoError(t, err)
+	defer restarted.store.Close()
+	restarted.loadMessageStore(time.Now())
+	assert.Equal(t, "telegram 1", gw.FindCanonicalMsgID("irc", "10"))
+	v, ok := gw.Messages.Get("telegram 1")
+	require.True(t, ok)
+	ids, _ := v.([]*BrMsgID)
+	require.Len(t, ids, 1)
+	assert.Equal(t, gw.Bridges[ircTestAccount], ids[0].br)
+	assert.Equal(t, "#main"+ircTestAccount, ids[0].ChannelID)
+	v, _ = gw.Messages.Get("telegram 2")
+	assert.Empty(t, v)
+
+	// the expired IDs are removed
+	assert.False(t, gw.Messages.Contains("telegram 0"))
+	_, err = restarted.store.Get(messageStoreNamespace+gw.Name, "telegram 0")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestAddMsgIDs(t *testing.T) {
+	_, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	gw.setMsgIDs("telegram 1", []*BrMsgID{{irc, "irc 0", "#main" + ircTestAccount}})
+
+	// the outboxes and the queues add their IDs concurrently
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gw.addMsgIDs("telegram 1", []*BrMsgID{{irc, "irc " + strconv.Itoa(i), "#main" + ircTestAccount}})
+		}()
+	}
+	wg.Wait()
+	v, _ := gw.Messages.Get("telegram 1")
+	assert.Len(t, v.([]*BrMsgID), 11)
+
+	assert.True(t, gw.replaceMsgID("telegram 1", ircTestAccount, "irc 0", ""))
+	assert.False(t, gw.replaceMsgID("telegram 1", ircTestAccount, "irc 0", "irc 12"))
+	v, _ = gw.Messages.Get("telegram 1")
+	assert.Len(t, v.([]*BrMsgID), 10)
+}
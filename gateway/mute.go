@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/version"
+)
+
+// setMuted mutes account, or unmutes it: the messages of a muted account
+// aren't relayed, nor sent to it, until it is unmuted or matterbridge
+// restarts. Returns false if account was already in that state.
+func (r *Router) setMuted(account string, muted bool) bool {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	if r.muted[account] == muted {
+		return false
+	}
+	if muted {
+		r.muted[account] = true
+	} else {
+		delete(r.muted, account)
+	}
+	return true
+}
+
+// isMuted returns true if account is muted.
+func (r *Router) isMuted(account string) bool {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return r.muted[account]
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:  "mute",
+		Usage: "<account>",
+		Help:  "stop relaying the messages from and to account",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errCommandAccount
+			}
+			if r.getBridge(args[0]) == nil {
+				return "", fmt.Errorf("unknown account %s", args[0])
+			}
+			if !r.setMuted(args[0], true) {
+				return fmt.Sprintf("%s is already muted", args[0]), nil
+			}
+			r.logger.Infof("%s muted by %s (%s) on %s", args[0], msg.Username, msg.UserID, msg.Account)
+			return fmt.Sprintf("%s: muted, send unmute %s to relay its messages again", args[0], args[0]), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "unmute",
+		Usage: "<account>",
+		Help:  "relay the messages from and to account again",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errCommandAccount
+			}
+			if !r.setMuted(args[0], false) {
+				return fmt.Sprintf("%s isn't muted", args[0]), nil
+			}
+			r.logger.Infof("%s unmuted by %s (%s) on %s", args[0], msg.Username, msg.UserID, msg.Account)
+			return fmt.Sprintf("%s: unmuted", args[0]), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name: "version",
+		Help: "show the version of matterbridge",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			if version.Release == "" {
+				return "", errors.New("unknown version")
+			}
+			if version.GitHash == "" {
+				return "matterbridge " + version.Release, nil
+			}
+			return fmt.Sprintf("matterbridge %s %s", version.Release, version.GitHash), nil
+		},
+	})
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// defaultOnboardingInterval is the minimum time between two notices in a
+// channel to the users joining it, unless OnboardingInterval is set.
+const defaultOnboardingInterval = 10 * time.Minute
+
+// onboarding records where the OnboardingNotice of a gateway was posted, so
+// that it is posted once in each channel and once for each joining user.
+type onboarding struct {
+	sync.Mutex
+
+	// posted maps the channel IDs to the time of their last notice
+	posted map[string]time.Time
+	// users are the channel IDs and user IDs of the users greeted with it
+	users map[string]bool
+}
+
+func newOnboarding() *onboarding {
+	return &onboarding{posted: make(map[string]time.Time), users: make(map[string]bool)}
+}
+
+// onboardingNotice returns the notice posted in channel, the one of its
+// options or else the one of the gateway. It is empty for the channels the
+// messages aren't relayed from.
+func (gw *Gateway) onboardingNotice(channel *config.ChannelInfo) string {
+	if !strings.Contains(channel.Direction, "in") || isAPI(channel.Account) {
+		return ""
+	}
+	if channel.Options.OnboardingNotice != "" {
+		return channel.Options.OnboardingNotice
+	}
+	return gw.MyConfig.OnboardingNotice
+}
+
+// postOnboardingNotices posts the OnboardingNotice of the gateways in the
+// channels of account which it wasn't posted in yet, once the bridge joined
+// them.
+func (r *Router) postOnboardingNotices(account string) {
+	for _, gw := range r.sortedGateways() {
+		gw.postOnboardingNotices(account)
+	}
+}
+
+// postOnboardingNotices posts the notices in the background, the channels
+// are looked up right away.
+func (gw *Gateway) postOnboardingNotices(account string) {
+	br, ok := gw.Bridges[account]
+	if !ok {
+		return
+	}
+	var channels []*config.ChannelInfo
+	gw.onboarding.Lock()
+	for _, channel := range gw.channels() {
+		if channel.Account != account || gw.onboardingNotice(channel) == "" {
+			continue
+		}
+		if _, posted := gw.onboarding.posted[channel.ID]; !posted {
+			gw.onboarding.posted[channel.ID] = time.Now()
+			channels = append(channels, channel)
+		}
+	}
+	gw.onboarding.Unlock()
+
+	go func() {
+		for _, channel := range channels {
+			gw.sendOnboardingNotice(br, channel, gw.onboardingNotice(channel))
+		}
+	}()
+}
+
+// handleOnboardingJoin posts the OnboardingNotice of the gateways with
+// OnboardingJoins for the user joining with msg, unless they were greeted
+// already or the notice was posted in the channel less than
+// OnboardingInterval ago.
+func (r *Router) handleOnboardingJoin(msg *config.Message) {
+	if msg.Event != config.EventJoin {
+		return
+	}
+	user := msg.UserID
+	if user == "" {
+		user = msg.Username
+	}
+	for _, gw := range r.sortedGateways() {
+		if !gw.MyConfig.OnboardingJoins {
+			continue
+		}
+		channel, ok := gw.channels()[getChannelID(msg)]
+		if !ok {
+			continue
+		}
+		notice := gw.onboardingNotice(channel)
+		if notice == "" || !gw.onboarding.greet(channel.ID, user, gw.onboardingInterval(), time.Now()) {
+			continue
+		}
+		br := gw.Bridges[channel.Account]
+		go gw.sendOnboardingNotice(br, channel, notice)
+	}
+}
+
+func (gw *Gateway) onboardingInterval() time.Duration {
+	if gw.MyConfig.OnboardingInterval > 0 {
+		return time.Duration(gw.MyConfig.OnboardingInterval) * time.Second
+	}
+	return defaultOnboardingInterval
+}
+
+// greet returns true if the notice is to be posted in channel for user at
+// now, and records it.
+func (o *onboarding) greet(channel string, user string, interval time.Duration, now time.Time) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if user == "" || o.users[channel+" "+user] {
+		return false
+	}
+	if last, ok := o.posted[channel]; ok && now.Sub(last) < interval {
+		return false
+	}
+	o.users[channel+" "+user] = true
+	o.posted[channel] = now
+	return true
+}
+
+// sendOnboardingNotice posts notice in channel of br, as a notice on irc. The
+// notice isn't relayed to the other channels.
+func (gw *Gateway) sendOnboardingNotice(br *bridge.Bridge, channel *config.ChannelInfo, notice string) {
+	msg := config.Message{
+		Text:     notice,
+		Channel:  channel.Name,
+		Account:  br.Account,
+		Protocol: br.Protocol,
+		Extra:    make(map[string][]any),
+	}
+	if br.Protocol == ircProtocol {
+		msg.Event = config.EventNoticeIRC
+	}
+	gw.logger.Debugf("Posting the onboarding notice of gateway %s in %s of %s", gw.Name, channel.Name, br.Account)
+	if _, err := sendWithTimeout(br, msg); err != nil {
+		gw.logger.Errorf("Posting the onboarding notice in %s of %s failed: %s", channel.Name, br.Account, err)
+	}
+}
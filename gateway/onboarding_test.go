@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnboarding(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="bridge"
+enable=true
+OnboardingNotice="Messages are relayed"
+OnboardingJoins=true
+OnboardingInterval=60
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+        [gateway.inout.options]
+        OnboardingNotice="Messages are relayed to irc"
+    [[gateway.out]]
+    account="slack.zzz"
+    channel="news"
+`))
+	gw := r.Gateways["bridge"]
+	irc, slack := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount]
+	ircEvents, slackEvents := &eventBridger{Bridger: irc.Bridger}, &eventBridger{Bridger: slack.Bridger}
+	irc.Bridger, slack.Bridger = ircEvents, slackEvents
+	sent := func(b *eventBridger) []config.Message {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return append([]config.Message{}, b.msgs...)
+	}
+
+	// the notice is posted once in the channels the messages are relayed from
+	r.postOnboardingNotices(ircTestAccount)
+	r.postOnboardingNotices(slackTestAccount)
+	r.postOnboardingNotices(slackTestAccount)
+	require.Eventually(t, func() bool { return len(sent(ircEvents)) == 1 && len(sent(slackEvents)) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, config.Message{Text: "Messages are relayed", Channel: "#main", Account: ircTestAccount, Protocol: "irc", Event: config.EventNoticeIRC, Extra: map[string][]any{}}, sent(ircEvents)[0])
+	assert.Equal(t, "main", sent(slackEvents)[0].Channel)
+	assert.Equal(t, "Messages are relayed to irc", sent(slackEvents)[0].Text)
+	assert.Equal(t, "", sent(slackEvents)[0].Event)
+
+	// the joining users are greeted once, at most every OnboardingInterval
+	o := newOnboarding()
+	now := time.Now()
+	assert.True(t, o.greet("#main", "alice", time.Minute, now))
+	assert.False(t, o.greet("#main", "bob", time.Minute, now.Add(30*time.Second)))
+	assert.True(t, o.greet("#main", "bob", time.Minute, now.Add(2*time.Minute)))
+	assert.True(t, o.greet("#other", "alice", time.Minute, now.Add(2*time.Minute)))
+	assert.False(t, o.greet("#main", "alice", time.Minute, now.Add(time.Hour)))
+
+	gw.onboarding.posted = map[string]time.Time{}
+	r.handleOnboardingJoin(&config.Message{Event: config.EventJoin, Username: "carol", Channel: "main", Account: slackTestAccount})
+	r.handleOnboardingJoin(&config.Message{Event: config.EventJoin, Username: "dave", Channel: "news", Account: slackTestAccount})
+	r.handleOnboardingJoin(&config.Message{Event: config.EventLeave, Username: "erin", Channel: "#main", Account: ircTestAccount})
+	require.Eventually(t, func() bool { return len(sent(slackEvents)) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "main", sent(slackEvents)[1].Channel)
+	assert.Len(t, sent(ircEvents), 1)
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/bridgemap"
+)
+
+// optOutNamespace is where the opt-outs are kept in the storage, by account
+// and user ID.
+const optOutNamespace = "optout"
+
+// optOuts holds the users who opted out of the bridging with the optout
+// control command: their messages are not relayed from their network.
+type optOuts struct {
+	sync.RWMutex
+
+	// users holds when the users opted out, by optOutKey
+	users map[string]time.Time
+}
+
+func newOptOuts() *optOuts {
+	return &optOuts{users: make(map[string]time.Time)}
+}
+
+// optOutKey is the key of the user userID of account.
+func optOutKey(account string, userID string) string {
+	return account + " " + userID
+}
+
+// optedOut returns true if the user userID of account opted out.
+func (r *Router) optedOut(account string, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	r.optOuts.RLock()
+	defer r.optOuts.RUnlock()
+
+	_, ok := r.optOuts.users[optOutKey(account, userID)]
+	return ok
+}
+
+// setOptOut records that the user userID of account opted out, or back in,
+// and keeps it in the storage when there is one.
+func (r *Router) setOptOut(account string, userID string, out bool) error {
+	key := optOutKey(account, userID)
+	now := time.Now()
+
+	r.optOuts.Lock()
+	defer r.optOuts.Unlock()
+
+	if out {
+		r.optOuts.users[key] = now
+	} else {
+		delete(r.optOuts.users, key)
+	}
+	if r.store == nil {
+		return nil
+	}
+	if !out {
+		return r.store.Delete(optOutNamespace, key)
+	}
+	return r.store.Put(optOutNamespace, key, []byte(now.Format(time.RFC3339)))
+}
+
+// loadOptOuts reads the opt-outs kept in the storage.
+func (r *Router) loadOptOuts() error {
+	if r.store == nil {
+		return nil
+	}
+	keys, err := r.store.Keys(optOutNamespace)
+	if err != nil {
+		return fmt.Errorf("reading the opt-outs failed: %w", err)
+	}
+
+	r.optOuts.Lock()
+	defer r.optOuts.Unlock()
+	for _, key := range keys {
+		var at time.Time
+		if data, err := r.store.Get(optOutNamespace, key); err == nil {
+			at, _ = time.Parse(time.RFC3339, string(data))
+		}
+		r.optOuts.users[key] = at
+	}
+	if len(keys) > 0 {
+		r.logger.Infof("Loaded %d opted out users", len(keys))
+	}
+	return nil
+}
+
+// OptedOutUsers returns the users who opted out, as "account user ID", sorted.
+func (r *Router) OptedOutUsers() []string {
+	r.optOuts.RLock()
+	defer r.optOuts.RUnlock()
+
+	users := make([]string, 0, len(r.optOuts.users))
+	for key := range r.optOuts.users {
+		users = append(users, key)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// optOutUser returns the user ID of the sender of msg, who can opt out only on
+// the protocols whose user IDs can't be chosen by anyone, so that nobody opts
+// out someone else.
+func optOutUser(br *bridge.Bridge, msg *config.Message) (string, error) {
+	userID, ok := verifiedUser(br, msg)
+	if !ok {
+		return "", fmt.Errorf("the users of %s can't be told apart, ask an admin to add you to IgnoreUserIDs", br.Protocol)
+	}
+	return userID, nil
+}
+
+// verifiedUser returns the user ID of the sender of msg, on the protocols
+// whose user IDs can't be chosen by anyone.
+func verifiedUser(br *bridge.Bridge, msg *config.Message) (string, bool) {
+	if _, ok := bridgemap.AuthenticatedUserIDs[br.Protocol]; !ok || msg.UserID == "" {
+		return "", false
+	}
+	return msg.UserID, true
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "optout",
+		Help: "stop relaying your messages from this network to the others",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			br := r.getBridge(msg.Account)
+			if br == nil {
+				return "", fmt.Errorf("unknown account %s", msg.Account)
+			}
+			userID, err := optOutUser(br, msg)
+			if err != nil {
+				return "", err
+			}
+			if err := r.setOptOut(br.Account, userID, true); err != nil {
+				return "", err
+			}
+			r.logger.Infof("%s (%s) on %s opted out", msg.Username, userID, br.Account)
+			return fmt.Sprintf("Your messages on %s are no longer relayed to the other networks, send optin to relay them again", br.Account), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "optin",
+		Usage: "[account user id]",
+		Help:  "relay your messages again after optout, or those of a user for the admins",
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			br := r.getBridge(msg.Account)
+			if br == nil {
+				return "", fmt.Errorf("unknown account %s", msg.Account)
+			}
+			account, userID := br.Account, ""
+			switch {
+			case len(args) == 0:
+				id, err := optOutUser(br, msg)
+				if err != nil {
+					return "", err
+				}
+				userID = id
+			case !isAdmin(br, msg):
+				return "", errors.New("only the admins can opt in other users")
+			case len(args) == 2:
+				account, userID = args[0], args[1]
+			default:
+				return "", errors.New("an account and a user id are required")
+			}
+			if !r.optedOut(account, userID) {
+				return fmt.Sprintf("%s on %s didn't opt out", userID, account), nil
+			}
+			if err := r.setOptOut(account, userID, false); err != nil {
+				return "", err
+			}
+			r.logger.Infof("%s on %s opted in by %s (%s)", userID, account, msg.Username, msg.UserID)
+			return fmt.Sprintf("The messages of %s on %s are relayed again", userID, account), nil
+		},
+	})
+	RegisterCommand(&Command{
+		Name:  "optouts",
+		Help:  "list the users who opted out",
+		Admin: true,
+		Run: func(r *Router, msg *config.Message, args []string) (string, error) {
+			users := r.OptedOutUsers()
+			if len(users) == 0 {
+				return "No user opted out", nil
+			}
+			return strings.Join(users, "\n"), nil
+		},
+	})
+}
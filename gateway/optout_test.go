@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptOut(t *testing.T) {
+	store := storage.NewMemory()
+	r, gw := newTestGateway()
+	r.store = store
+	tg := r.getBridge(tgTestAccount)
+	irc := r.getBridge(ircTestAccount)
+	alice := &config.Message{Text: "hello", Username: "alice", UserID: "12345", Account: tgTestAccount, Channel: "-1111111111111", Gateway: "bridge"}
+
+	assert.False(t, gw.ignoreMessage(alice))
+	assert.Equal(t, "Your messages on telegram.zzz are no longer relayed to the other networks, send optin to relay them again", r.runCommand(tg, alice, "optout"))
+	assert.True(t, gw.ignoreMessage(alice))
+	// the user ID of the other accounts is another user
+	assert.False(t, r.optedOut(ircTestAccount, "12345"))
+	// anyone can take the ident@host of someone else on irc
+	assert.Equal(t, "optout failed: the users of irc can't be told apart, ask an admin to add you to IgnoreUserIDs", r.runCommand(irc, &config.Message{UserID: "bob@example.com", Account: ircTestAccount}, "optout"))
+
+	// the opt-outs are kept across restarts
+	restarted, gw := newTestGateway()
+	restarted.store = store
+	require.NoError(t, restarted.loadOptOuts())
+	assert.True(t, gw.ignoreMessage(alice))
+
+	// the admins can opt users back in
+	bob := &config.Message{UserID: "67890", Account: tgTestAccount}
+	assert.Equal(t, "Command optouts is restricted to the admins", restarted.runCommand(tg, bob, "optouts"))
+	assert.Equal(t, "optin failed: only the admins can opt in other users", restarted.runCommand(tg, bob, "optin telegram.zzz 12345"))
+	tg.SetStringSlice("Admins", []string{"67890"})
+	assert.Equal(t, "telegram.zzz 12345", restarted.runCommand(tg, bob, "optouts"))
+	assert.Equal(t, "The messages of 12345 on telegram.zzz are relayed again", restarted.runCommand(tg, bob, "optin telegram.zzz 12345"))
+	assert.Equal(t, "12345 on telegram.zzz didn't opt out", restarted.runCommand(tg, alice, "optin"))
+	assert.False(t, gw.ignoreMessage(alice))
+	keys, err := store.Keys(optOutNamespace)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+const (
+	// defaultSendStallTimeout is how long a send to an AsyncSend bridge may
+	// take before the bridge is restarted, unless SendStallTimeout is set.
+	defaultSendStallTimeout = 120 * time.Second
+	// outboxCheckInterval is the time between the checks of the outboxes.
+	outboxCheckInterval = 10 * time.Second
+)
+
+// outbox holds the messages to send to an AsyncSend bridge.
+//
+// The messages are sent in order by a goroutine of their own, started when the
+// first one is pushed and running until the outbox is empty, so that a bridge
+// which hangs stalls its own messages only, not the router relaying to the
+// other bridges. The outboxes are watched by superviseOutboxes.
+type outbox struct {
+	sync.Mutex
+
+	queue   []outboxMessage
+	running bool
+	// busySince is the start of the send in progress, zero when idle
+	busySince time.Time
+	// restarted is set once the bridge was restarted for the send in
+	// progress
+	restarted bool
+}
+
+// outboxMessage is a message relayed by gw, with the key of the source message
+// in gw.Messages to record its IDs, empty when they aren't recorded (edits,
+// ...).
+type outboxMessage struct {
+	gw          *Gateway
+	dest        *bridge.Bridge
+	msg         config.Message
+	canonicalID string
+	// files are the spool files of msg held until it is sent
+	files []string
+}
+
+// getOutbox returns the outbox of the bridge for account, which is shared by
+// all gateways like the bridge itself.
+func (r *Router) getOutbox(account string) *outbox {
+	r.Lock()
+	defer r.Unlock()
+
+	box, ok := r.outboxes[account]
+	if !ok {
+		box = &outbox{}
+		r.outboxes[account] = box
+	}
+	return box
+}
+
+// push adds queued to the outbox of its destination, dropping the oldest
+// message when full, and starts sending them unless it is running.
+func (box *outbox) push(queued outboxMessage) {
+	dest := queued.dest
+	box.Lock()
+	defer box.Unlock()
+
+	// Typing notifications are useless once the messages before them are sent
+	if queued.msg.Event == config.EventUserTyping && (box.running || len(box.queue) > 0) {
+		return
+	}
+	if len(box.queue) >= maxQueuedMessages {
+		dropped := box.queue[0]
+		dropped.gw.logger.Warnf("Too many messages waiting to be sent to %s, dropping the oldest one", dest.Account)
+		dropped.gw.Router.auditMessage(auditDrop, auditQueueFull, dropped.gw, &dropped.msg, dest.Account)
+		dropped.gw.Router.releaseFiles(dropped.files)
+		box.queue = box.queue[1:]
+	}
+	queued.files = queued.gw.Router.holdFiles(&queued.msg)
+	box.queue = append(box.queue, queued)
+	if !box.running {
+		box.running = true
+		go box.run(dest)
+	}
+}
+
+// run sends the messages of the outbox to dest in order, until it is empty.
+func (box *outbox) run(dest *bridge.Bridge) {
+	for {
+		box.Lock()
+		if len(box.queue) == 0 {
+			box.running = false
+			box.busySince = time.Time{}
+			box.Unlock()
+			return
+		}
+		next := box.queue[0]
+		box.queue = box.queue[1:]
+		box.busySince = time.Now()
+		box.restarted = false
+		box.Unlock()
+
+		ids := next.gw.handleMessage(&next.msg, dest)
+		next.gw.addMsgIDs(next.canonicalID, ids)
+		next.gw.Router.releaseFiles(next.files)
+	}
+}
+
+// stalled returns true when the send in progress started stallTimeout before
+// now and the bridge wasn't restarted for it yet, and marks it restarted.
+func (box *outbox) stalled(now time.Time, stallTimeout time.Duration) bool {
+	box.Lock()
+	defer box.Unlock()
+
+	if box.busySince.IsZero() || box.restarted || now.Sub(box.busySince) < stallTimeout {
+		return false
+	}
+	box.restarted = true
+	return true
+}
+
+// sendStallTimeout returns how long a send to br may take before it is
+// restarted.
+func sendStallTimeout(br *bridge.Bridge) time.Duration {
+	if timeout := br.GetInt("SendStallTimeout"); timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultSendStallTimeout
+}
+
+// checkOutboxes restarts the AsyncSend bridges which have been sending the
+// same message for longer than their SendStallTimeout at now.
+func (r *Router) checkOutboxes(now time.Time) {
+	for _, account := range r.sortedAccounts() {
+		r.RLock()
+		box, ok := r.outboxes[account]
+		r.RUnlock()
+		br := r.getBridge(account)
+		if !ok || br == nil || !box.stalled(now, sendStallTimeout(br)) {
+			continue
+		}
+		r.logger.Warnf("%s has been sending a message for more than %s, restarting it", account, sendStallTimeout(br))
+		go func() {
+			r.alert(account, fmt.Sprintf("%s has been sending a message for more than %s and is restarting", account, sendStallTimeout(br)))
+			r.reconnectBridge(br)
+		}()
+	}
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	r, gw := newTestGateway()
+	irc := gw.Bridges[ircTestAccount]
+	hung := &flakyBridger{Bridger: irc.Bridger, block: make(chan struct{})}
+	irc.Bridger = hung
+	irc.SetBool("AsyncSend", true)
+	defer irc.SetBool("AsyncSend", false)
+	slack := gw.Bridges[slackTestAccount]
+	flaky := &flakyBridger{Bridger: slack.Bridger}
+	slack.Bridger = flaky
+	for _, account := range []string{ircTestAccount, slackTestAccount} {
+		r.markBridgeStarted(account)
+	}
+
+	msg := &config.Message{Text: "hello", ID: "1", Username: "alice", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram", Gateway: "bridge"}
+	r.relayMessage(gw, msg)
+	r.relayMessage(gw, &config.Message{Event: config.EventUserTyping, Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram", Gateway: "bridge"})
+
+	// the hung irc bridge doesn't hold the messages to slack
+	assert.Equal(t, []string{"hello", ""}, flaky.sent)
+	v, _ := gw.Messages.Get("telegram 1")
+	assert.Len(t, v, 1)
+
+	box := r.getOutbox(ircTestAccount)
+	assert.Eventually(t, func() bool {
+		box.Lock()
+		defer box.Unlock()
+		return !box.busySince.IsZero()
+	}, time.Second, 10*time.Millisecond)
+	now := time.Now()
+	assert.False(t, box.stalled(now, time.Minute))
+	assert.True(t, box.stalled(now.Add(2*time.Minute), time.Minute))
+	assert.False(t, box.stalled(now.Add(3*time.Minute), time.Minute), "restarted once for the same message")
+
+	close(hung.block)
+	assert.Eventually(t, func() bool {
+		v, _ := gw.Messages.Get("telegram 1")
+		ids, _ := v.([]*BrMsgID)
+		return len(ids) == 2
+	}, time.Second, 10*time.Millisecond)
+	box.Lock()
+	defer box.Unlock()
+	assert.Empty(t, box.queue, "the typing notification is dropped")
+	assert.Equal(t, []string{"hello"}, hung.sent)
+}
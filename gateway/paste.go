@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+const (
+	// pastePreviewLines is the number of lines of a paste sent inline.
+	pastePreviewLines = 2
+	// pasteFile is the name of the file a paste is stored in.
+	pasteFile = "paste.txt"
+)
+
+// checkPastes warns when irc channels of the gateway upload the pastes but
+// can't, because no media server is configured.
+func (gw *Gateway) checkPastes() {
+	if gw.BridgeValues().General.MediaDownloadPath != "" {
+		return
+	}
+	for _, br := range append(gw.MyConfig.In, append(gw.MyConfig.InOut, gw.MyConfig.Out...)...) {
+		lines, _ := gw.GetInt(br.Account + ".PasteLines")
+		if strings.HasPrefix(br.Account, ircProtocol+".") && (br.Options.PasteLines > 0 || lines > 0 && br.Options.PasteLines == 0) {
+			gw.logger.Warnf("Gateway %s has PasteLines for %s but no MediaDownloadPath is configured, pastes will be sent line by line", gw.Name, br.Account)
+			return
+		}
+	}
+}
+
+// pasteLines returns the number of lines above which the messages sent to
+// channel of dest are uploaded, 0 when they aren't. The PasteLines of the
+// channel options override the one of the account, a negative one disables it.
+func pasteLines(dest *bridge.Bridge, channel *config.ChannelInfo) int {
+	if dest.Protocol != ircProtocol {
+		return 0
+	}
+	lines := channel.Options.PasteLines
+	if lines == 0 {
+		lines = dest.GetInt("PasteLines")
+	}
+	return max(lines, 0)
+}
+
+// uploadPaste replaces the text of a message with more lines than the
+// PasteLines of its channel by its first lines and a link to the full text,
+// stored on the media server, so that a paste isn't sent line by line to
+// irc. Returns false if msg is left as is.
+func (gw *Gateway) uploadPaste(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	limit := pasteLines(dest, channel)
+	if limit <= 0 || gw.BridgeValues().General.MediaDownloadPath == "" {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	lines := strings.Split(strings.TrimRight(msg.Text, "\n"), "\n")
+	if len(lines) <= limit {
+		return false
+	}
+
+	data := []byte(msg.Text)
+	fi := config.FileInfo{Name: pasteFile, Data: &data}
+	sha1sum := fmt.Sprintf("%x", sha1.Sum(data))[:8] //nolint:gosec
+	if err := gw.handleFilesLocal(&fi, sha1sum); err != nil {
+		gw.logger.Errorf("Failed to store the paste of %s: %s", msg.Username, err)
+		return false
+	}
+	url := helper.MediaServerURL(gw.BridgeValues().General.MediaServerDownload, sha1sum, fi.Name)
+
+	gw.logger.Debugf("Uploading paste of %d lines from %s to %s, full text at %s", len(lines), msg.Username, dest.Account, url)
+	msg.Text = fmt.Sprintf("%s … (%d lines: %s)", strings.Join(lines[:pastePreviewLines], "\n"), len(lines), url)
+	return true
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPaste(t *testing.T) {
+	dir := t.TempDir()
+	r := maketestRouter([]byte(`
+[general]
+MediaDownloadPath="` + dir + `"
+MediaServerDownload="https://example.com/media/"
+[irc.zzz]
+server=""
+PasteLines=3
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="bridge"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#code"
+        [gateway.inout.options]
+        PasteLines=5
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#all"
+        [gateway.inout.options]
+        PasteLines=-1
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`))
+	gw := r.Gateways["bridge"]
+	irc, slack := gw.Bridges[ircTestAccount], gw.Bridges[slackTestAccount]
+	text := "func main() {\n\tfmt.Println(\"hello\")\n\treturn\n}\n"
+	upload := func(dest *bridge.Bridge, channel string, text string) (bool, string) {
+		msg := &config.Message{Text: text, Username: "user", Account: slackTestAccount, Channel: "main"}
+		ok := gw.uploadPaste(msg, dest, gw.Channels[channel+dest.Account])
+		return ok, msg.Text
+	}
+
+	ok, sent := upload(irc, "#main", text)
+	require.True(t, ok)
+	sha1sum := fmt.Sprintf("%x", sha1.Sum([]byte(text)))[:8] //nolint:gosec
+	assert.Equal(t, "func main() {\n\tfmt.Println(\"hello\") … (4 lines: https://example.com/media/"+sha1sum+"/paste.txt)", sent)
+	data, err := os.ReadFile(filepath.Join(dir, sha1sum, "paste.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, text, string(data))
+
+	// short enough, or overridden by the channel options
+	ok, sent = upload(irc, "#main", "one\ntwo\nthree\n")
+	assert.False(t, ok)
+	assert.Equal(t, "one\ntwo\nthree\n", sent)
+	ok, _ = upload(irc, "#code", text)
+	assert.False(t, ok)
+	ok, _ = upload(irc, "#all", text)
+	assert.False(t, ok)
+	// only irc sends the lines one by one
+	ok, _ = upload(slack, "main", text)
+	assert.False(t, ok)
+}
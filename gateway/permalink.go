@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+)
+
+// permalinks returns the links to the copies of the message id of account
+// relayed by the gateways, as "account channel: url", for the bridges which
+// implement bridge.Permalinker. id is either the ID of the original message
+// or of one of its copies.
+func (r *Router) permalinks(account string, id string) []string {
+	br := r.getBridge(account)
+	if br == nil {
+		return nil
+	}
+	links := []string{}
+	linked := make(map[string]bool)
+	for _, gw := range r.sortedGateways() {
+		canonical := gw.FindCanonicalMsgID(br.Protocol, id)
+		if canonical == "" {
+			continue
+		}
+		v, ok := gw.Messages.Peek(canonical)
+		if !ok {
+			continue
+		}
+		ids, _ := v.([]*BrMsgID)
+		for _, copied := range ids {
+			mID := strings.TrimPrefix(copied.ID, copied.br.Protocol+" ")
+			channel, ok := gw.channels()[copied.ChannelID]
+			if !ok || linked[copied.ChannelID+" "+mID] || copied.br.Account == account && mID == id {
+				continue
+			}
+			linker, ok := copied.br.Bridger.(bridge.Permalinker)
+			if !ok {
+				continue
+			}
+			linked[copied.ChannelID+" "+mID] = true
+
+			url, err := linker.Permalink(channel.Name, mID)
+			if err != nil {
+				r.logger.Warnf("Getting the link to message %s in %s of %s failed: %s", mID, channel.Name, copied.br.Account, err)
+				continue
+			}
+			links = append(links, fmt.Sprintf("%s %s: %s", copied.br.Account, channel.Name, url))
+		}
+	}
+	return links
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// queueStoreNamespace is the prefix of the namespaces of the messages queued
+// for the unhealthy bridges, followed by their account.
+const queueStoreNamespace = "queues/"
+
+func init() {
+	// the types of the values of Message.Extra which can be queued
+	gob.Register(config.FileInfo{})
+	gob.Register(config.Forward{})
+	gob.Register(time.Duration(0))
+}
+
+// storedQueuedMessage is a queuedMessage as kept in the StorageBackend, so
+// that the messages queued for an unhealthy bridge are sent after a restart.
+type storedQueuedMessage struct {
+	Gateway     string
+	Msg         config.Message
+	ChannelID   string
+	CanonicalID string
+}
+
+// storeQueued keeps queued in the queue of account in the StorageBackend, and
+// sets its key. The messages which can't be encoded are queued in memory only.
+func (r *Router) storeQueued(account string, queued *queuedMessage) {
+	if r.store == nil {
+		return
+	}
+	msg, err := withFileData(queued.msg)
+	if err != nil {
+		r.logger.Errorf("Keeping the message queued for %s failed, it is lost on restart: %s", account, err)
+		return
+	}
+	var buf bytes.Buffer
+	stored := storedQueuedMessage{Gateway: queued.gw.Name, Msg: msg, ChannelID: queued.channelID, CanonicalID: queued.canonicalID}
+	if err := gob.NewEncoder(&buf).Encode(stored); err != nil {
+		r.logger.Errorf("Keeping the message queued for %s failed, it is lost on restart: %s", account, err)
+		return
+	}
+	// the keys sort in the order of the queue, also after a restart
+	key := fmt.Sprintf("%020d", r.queueSeq.Add(1))
+	if err := r.store.Put(queueStoreNamespace+account, key, buf.Bytes()); err != nil {
+		r.logger.Errorf("Keeping the message queued for %s failed, it is lost on restart: %s", account, err)
+		return
+	}
+	queued.storeKey = key
+}
+
+// unstoreQueued removes queued from the queue of account in the
+// StorageBackend, once it is sent or dropped.
+func (r *Router) unstoreQueued(account string, queued *queuedMessage) {
+	if r.store == nil || queued.storeKey == "" {
+		return
+	}
+	if err := r.store.Delete(queueStoreNamespace+account, queued.storeKey); err != nil {
+		r.logger.Errorf("Removing the message queued for %s failed: %s", account, err)
+	}
+}
+
+// withFileData returns a copy of msg whose spool files are read in memory, as
+// the spool files are removed on restart.
+func withFileData(msg config.Message) (config.Message, error) {
+	if len(spooledFiles(&msg)) == 0 {
+		return msg, nil
+	}
+	extra := make(map[string][]interface{}, len(msg.Extra))
+	for key, values := range msg.Extra {
+		extra[key] = values
+	}
+	files := make([]interface{}, 0, len(msg.Extra["file"]))
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Path != "" {
+			data, err := fi.Bytes()
+			if err != nil {
+				return msg, err
+			}
+			fi.Data = &data
+			fi.Path = ""
+			f = fi
+		}
+		files = append(files, f)
+	}
+	extra["file"] = files
+	msg.Extra = extra
+	return msg, nil
+}
+
+// loadQueues restores the messages queued for the unhealthy bridges before a
+// restart, and returns the accounts which have some. The messages of the
+// gateways or accounts removed from the configuration are dropped.
+func (r *Router) loadQueues() []string {
+	if r.store == nil {
+		return nil
+	}
+	// the keys of the new messages follow the restored ones
+	r.queueSeq.Store(time.Now().UnixNano())
+
+	var accounts []string
+	for _, account := range r.sortedAccounts() {
+		namespace := queueStoreNamespace + account
+		keys, err := r.store.Keys(namespace)
+		if err != nil {
+			r.logger.Errorf("Reading the messages queued for %s failed: %s", account, err)
+			continue
+		}
+		var queue []queuedMessage
+		for _, key := range keys {
+			queued, ok := r.loadQueued(account, namespace, key)
+			if !ok {
+				if err := r.store.Delete(namespace, key); err != nil {
+					r.logger.Errorf("Removing the message queued for %s failed: %s", account, err)
+				}
+				continue
+			}
+			queue = append(queue, queued)
+		}
+		if len(queue) == 0 {
+			continue
+		}
+		breaker := r.getBreaker(account)
+		breaker.Lock()
+		breaker.queue = append(queue, breaker.queue...)
+		breaker.Unlock()
+		r.logger.Infof("Restored %d messages queued for %s", len(queue), account)
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// loadQueued decodes the message key of the queue of account.
+func (r *Router) loadQueued(account string, namespace string, key string) (queuedMessage, bool) {
+	data, err := r.store.Get(namespace, key)
+	if err != nil {
+		return queuedMessage{}, false
+	}
+	var stored storedQueuedMessage
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stored); err != nil {
+		r.logger.Errorf("Dropping the unreadable message queued for %s: %s", account, err)
+		return queuedMessage{}, false
+	}
+	r.RLock()
+	gw, ok := r.Gateways[stored.Gateway]
+	r.RUnlock()
+	if !ok || gw.Bridges[account] == nil {
+		r.logger.Warnf("Dropping the message queued for %s by gateway %s, which doesn't relay to it anymore", account, stored.Gateway)
+		return queuedMessage{}, false
+	}
+	return queuedMessage{
+		gw:          gw,
+		msg:         stored.Msg,
+		channelID:   stored.ChannelID,
+		canonicalID: stored.CanonicalID,
+		storeKey:    key,
+	}, true
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	r, gw := newTestGateway()
+	store, err := storage.Open(storage.BackendBolt, path)
+	require.NoError(t, err)
+	r.store = store
+	irc := gw.Bridges[ircTestAccount]
+	breaker := r.getBreaker(ircTestAccount)
+
+	spooled := filepath.Join(t.TempDir(), "matterbridge-1")
+	require.NoError(t, os.WriteFile(spooled, []byte("image"), 0o600))
+	file := config.Message{Text: "file", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "cat.png", Path: spooled, Size: 5}},
+	}}
+	breaker.Lock()
+	breaker.enqueue(gw, irc, config.Message{Text: "dropped"}, "#main"+ircTestAccount, "")
+	breaker.enqueue(gw, irc, file, "#main"+ircTestAccount, "telegram 1")
+	breaker.enqueue(gw, irc, config.Message{Text: "last"}, "#main"+ircTestAccount, "")
+	breaker.Unlock()
+	_, err = r.DropQueue(ircTestAccount)
+	require.NoError(t, err)
+	// the spool file of the dropped message was removed
+	require.NoError(t, os.WriteFile(spooled, []byte("image"), 0o600))
+	breaker.Lock()
+	breaker.enqueue(gw, irc, file, "#main"+ircTestAccount, "telegram 1")
+	breaker.enqueue(gw, irc, config.Message{Text: "last"}, "#main"+ircTestAccount, "")
+	breaker.Unlock()
+	// an unreadable message
+	require.NoError(t, r.store.Put(queueStoreNamespace+ircTestAccount, "0", []byte("unreadable")))
+	require.NoError(t, r.store.Close())
+	// the spool files are removed on restart
+	require.NoError(t, os.Remove(spooled))
+
+	// the queue is restored after a restart, with the data of the spool files
+	restarted, gw := newTestGateway()
+	restarted.store, err = storage.Open(storage.BackendBolt, path)
+	require.NoError(t, err)
+	defer restarted.store.Close()
+	assert.Equal(t, []string{ircTestAccount}, restarted.loadQueues())
+	breaker = restarted.getBreaker(ircTestAccount)
+	require.Len(t, breaker.queue, 2)
+	assert.Equal(t, gw, breaker.queue[0].gw)
+	assert.Equal(t, "telegram 1", breaker.queue[0].canonicalID)
+	fi, ok := breaker.queue[0].msg.Extra["file"][0].(config.FileInfo)
+	require.True(t, ok)
+	data, err := fi.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(data))
+	assert.Equal(t, "last", breaker.queue[1].msg.Text)
+
+	// and removed from the store once sent
+	irc = gw.Bridges[ircTestAccount]
+	flaky := &flakyBridger{Bridger: irc.Bridger}
+	irc.Bridger = flaky
+	_, err = restarted.ReplayQueue(ircTestAccount)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"file", "last"}, flaky.sent)
+	keys, err := restarted.store.Keys(queueStoreNamespace + ircTestAccount)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,345 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// rateLimitBuckets is the number of users and channels whose rate is kept by
+// a gateway, the least recently seen are forgotten.
+const rateLimitBuckets = 10000
+
+// minRateLimitWait is the shortest delay between two attempts to release the
+// queued messages.
+const minRateLimitWait = 10 * time.Millisecond
+
+// tokenBucket is the rate of a user or a channel: a token is taken by every
+// message and they come back at the rate of the limit, up to its burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill, rate being in tokens
+// per minute.
+func (b *tokenBucket) refill(now time.Time, rate int, burst int) {
+	b.tokens += now.Sub(b.last).Minutes() * float64(rate)
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// wait returns how long until the next token.
+func (b *tokenBucket) wait(rate int) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / float64(rate) * float64(time.Minute))
+}
+
+// rateLimiter holds the rate of the users and channels of a gateway, and the
+// messages over their UserRateLimit or ChannelRateLimit.
+type rateLimiter struct {
+	sync.Mutex
+
+	buckets *lru.Cache
+	// queue holds the messages waiting for their turn, with RateLimitAction
+	// queue
+	queue   []limitedMessage
+	running bool
+	// suppressed counts the messages dropped by limited key, with
+	// RateLimitAction collapse
+	suppressed map[string]*suppressedMessages
+}
+
+// limitedMessage is a queued message with the limits it waits for.
+type limitedMessage struct {
+	msg    config.Message
+	limits []rateLimit
+	// files are the spool files of msg held until it is released
+	files []string
+}
+
+// suppressedMessages are the messages of a user or channel collapsed in one
+// summary.
+type suppressedMessages struct {
+	count int
+	msg   config.Message
+}
+
+func newRateLimiter() *rateLimiter {
+	buckets, _ := lru.New(rateLimitBuckets)
+	return &rateLimiter{
+		buckets:    buckets,
+		suppressed: make(map[string]*suppressedMessages),
+	}
+}
+
+// rateLimit is a limit of the gateway applying to a message.
+type rateLimit struct {
+	key  string
+	rate int
+}
+
+// rateLimits returns the limits applying to msg.
+func (gw *Gateway) rateLimits(msg *config.Message) []rateLimit {
+	var limits []rateLimit
+	if rate := gw.MyConfig.UserRateLimit; rate > 0 {
+		user := msg.UserID
+		if user == "" {
+			user = msg.Username
+		}
+		limits = append(limits, rateLimit{"user " + msg.Account + " " + msg.Channel + " " + user, rate})
+	}
+	if rate := gw.MyConfig.ChannelRateLimit; rate > 0 {
+		limits = append(limits, rateLimit{"channel " + msg.Account + " " + msg.Channel, rate})
+	}
+	return limits
+}
+
+// rateLimited returns true if msg counts against the rate limits: the new
+// messages and actions of the users, not their edits nor the priority
+// messages.
+func (gw *Gateway) rateLimited(msg *config.Message) bool {
+	switch msg.Event {
+	case "", config.EventUserAction:
+	default:
+		return false
+	}
+	if msg.ID != "" && gw.FindCanonicalMsgID(msg.Protocol, msg.ID) != "" {
+		return false
+	}
+	return !gw.priorityMessage(msg)
+}
+
+func (gw *Gateway) rateLimitBurst(rate int) int {
+	if burst := gw.MyConfig.RateLimitBurst; burst > 0 {
+		return burst
+	}
+	return rate
+}
+
+// bucket returns the refilled bucket of limit, new ones being full.
+func (gw *Gateway) bucket(limit rateLimit, now time.Time) *tokenBucket {
+	burst := gw.rateLimitBurst(limit.rate)
+	if v, ok := gw.limiter.buckets.Get(limit.key); ok {
+		b := v.(*tokenBucket)
+		b.refill(now, limit.rate, burst)
+		return b
+	}
+	b := &tokenBucket{tokens: float64(burst), last: now}
+	gw.limiter.buckets.Add(limit.key, b)
+	return b
+}
+
+// takeTokens takes a token of every limit if they all have one. Otherwise it
+// returns the limit to wait for and how long. The limiter must be locked.
+func (gw *Gateway) takeTokens(limits []rateLimit, now time.Time) (*rateLimit, time.Duration) {
+	buckets := make([]*tokenBucket, len(limits))
+	for i, limit := range limits {
+		buckets[i] = gw.bucket(limit, now)
+		if wait := buckets[i].wait(limit.rate); wait > 0 {
+			return &limits[i], wait
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil, 0
+}
+
+// admitMessage returns true if msg is within the UserRateLimit and the
+// ChannelRateLimit of the gateway. The messages over the limits are dropped,
+// queued or collapsed depending on the RateLimitAction.
+func (gw *Gateway) admitMessage(msg *config.Message) bool {
+	limits := gw.rateLimits(msg)
+	if len(limits) == 0 || !gw.rateLimited(msg) {
+		return true
+	}
+
+	l := gw.limiter
+	l.Lock()
+	defer l.Unlock()
+
+	action := strings.ToLower(gw.MyConfig.RateLimitAction)
+	// the messages don't overtake the queued ones of their user or channel
+	if action == config.RateLimitQueue && l.queued(limits) {
+		gw.queueMessage(msg, limits)
+		return false
+	}
+	limit, wait := gw.takeTokens(limits, time.Now())
+	if limit == nil {
+		return true
+	}
+
+	switch action {
+	case config.RateLimitQueue:
+		gw.queueMessage(msg, limits)
+	case config.RateLimitCollapse:
+		gw.logger.Debugf("%s is over its rate limit on gateway %s, collapsing message", limit.key, gw.Name)
+		gw.Router.auditMessage(auditDrop, auditRateLimit, gw, msg, "")
+		gw.collapseMessage(msg, limit, wait)
+	default:
+		gw.logger.Debugf("%s is over its rate limit on gateway %s, dropping message", limit.key, gw.Name)
+		gw.Router.auditMessage(auditDrop, auditRateLimit, gw, msg, "")
+	}
+	return false
+}
+
+// queued returns true if messages under one of limits are queued. The
+// limiter must be locked.
+func (l *rateLimiter) queued(limits []rateLimit) bool {
+	for _, queued := range l.queue {
+		for _, limit := range limits {
+			if sharesLimit(queued.limits, limit.key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sharesLimit(limits []rateLimit, key string) bool {
+	for _, limit := range limits {
+		if limit.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// queueMessage queues msg until its limits allow it, dropping the oldest
+// message when maxQueuedMessages are queued. The limiter must be locked.
+func (gw *Gateway) queueMessage(msg *config.Message, limits []rateLimit) {
+	l := gw.limiter
+	if len(l.queue) >= maxQueuedMessages {
+		gw.logger.Warnf("Too many messages over the rate limits of gateway %s, dropping the oldest message", gw.Name)
+		dropped := l.queue[0]
+		gw.Router.auditMessage(auditDrop, auditRateLimit, gw, &dropped.msg, "")
+		gw.Router.releaseFiles(dropped.files)
+		l.queue = l.queue[1:]
+	}
+	l.queue = append(l.queue, limitedMessage{msg: *msg, limits: limits, files: gw.Router.holdFiles(msg)})
+	gw.logger.Debugf("%s is over the rate limits of gateway %s, queued message (%d queued)", msg.Channel, gw.Name, len(l.queue))
+	if !l.running {
+		l.running = true
+		gw.Router.run(gw.runRateLimitQueue)
+	}
+}
+
+// runRateLimitQueue releases the queued messages when their limits allow it,
+// in order for every user and channel, until the queue is empty or the
+// router is stopped.
+func (gw *Gateway) runRateLimitQueue() {
+	l := gw.limiter
+	r := gw.Router
+	for {
+		l.Lock()
+		if len(l.queue) == 0 {
+			l.running = false
+			l.Unlock()
+			return
+		}
+		next, wait := gw.nextReleased(time.Now())
+		l.Unlock()
+
+		if next == nil {
+			if !r.sleep(max(wait, minRateLimitWait)) {
+				return
+			}
+			continue
+		}
+		select {
+		case r.released <- resolvedMessage{msg: next.msg, gateways: []*Gateway{gw}, files: next.files}:
+		case <-r.stop:
+			r.releaseFiles(next.files)
+			return
+		}
+	}
+}
+
+// nextReleased removes from the queue the first message allowed by its
+// limits, whose user and channel have no message queued before it. Otherwise
+// it returns how long to wait for one. The limiter must be locked.
+func (gw *Gateway) nextReleased(now time.Time) (*limitedMessage, time.Duration) {
+	l := gw.limiter
+	var (
+		blocked = make(map[string]bool)
+		wait    time.Duration
+	)
+	for i, queued := range l.queue {
+		ahead := false
+		for _, limit := range queued.limits {
+			ahead = ahead || blocked[limit.key]
+			blocked[limit.key] = true
+		}
+		if ahead {
+			continue
+		}
+		limit, w := gw.takeTokens(queued.limits, now)
+		if limit == nil {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return &queued, 0
+		}
+		if wait == 0 || w < wait {
+			wait = w
+		}
+	}
+	return nil, wait
+}
+
+// collapseMessage counts msg in the summary of the messages suppressed by
+// limit, which is released once limit allows a message again. The limiter
+// must be locked.
+func (gw *Gateway) collapseMessage(msg *config.Message, limit *rateLimit, wait time.Duration) {
+	l := gw.limiter
+	if s, ok := l.suppressed[limit.key]; ok {
+		s.count++
+		return
+	}
+	l.suppressed[limit.key] = &suppressedMessages{count: 1, msg: *msg}
+	key := limit.key
+	r := gw.Router
+	r.run(func() {
+		if !r.sleep(wait) {
+			return
+		}
+		l.Lock()
+		s := l.suppressed[key]
+		delete(l.suppressed, key)
+		l.Unlock()
+		select {
+		case r.released <- resolvedMessage{msg: s.summary(strings.HasPrefix(key, "channel ")), gateways: []*Gateway{gw}}:
+		case <-r.stop:
+		}
+	})
+}
+
+// summary returns the message telling how many messages were suppressed, from
+// their user, or from nobody when the channel was limited.
+func (s *suppressedMessages) summary(channel bool) config.Message {
+	msg := config.Message{
+		Text:      fmt.Sprintf("(%d messages suppressed by the rate limit)", s.count),
+		Channel:   s.msg.Channel,
+		Username:  s.msg.Username,
+		UserID:    s.msg.UserID,
+		Avatar:    s.msg.Avatar,
+		Account:   s.msg.Account,
+		Protocol:  s.msg.Protocol,
+		Gateway:   s.msg.Gateway,
+		Timestamp: time.Now(),
+	}
+	if s.count == 1 {
+		msg.Text = "(1 message suppressed by the rate limit)"
+	}
+	if channel {
+		msg.Username, msg.UserID, msg.Avatar = "", "", ""
+	}
+	return msg
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{last: now}
+	assert.Equal(t, 30*time.Second, b.wait(2))
+	b.refill(now.Add(15*time.Second), 2, 3)
+	assert.InDelta(t, 0.5, b.tokens, 0.001)
+	b.refill(now.Add(time.Hour), 2, 3)
+	assert.Equal(t, 3.0, b.tokens)
+	assert.Equal(t, time.Duration(0), b.wait(2))
+}
+
+func rateLimitedGateway(action string) *Gateway {
+	_, gw := newTestGateway()
+	gw.MyConfig.UserRateLimit = 600
+	gw.MyConfig.ChannelRateLimit = 1200
+	gw.MyConfig.RateLimitBurst = 1
+	gw.MyConfig.RateLimitAction = action
+	return gw
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	gw := rateLimitedGateway("")
+	msg := func(text string, userID string) *config.Message {
+		return &config.Message{Text: text, UserID: userID, Account: ircTestAccount, Channel: "#test"}
+	}
+
+	assert.True(t, gw.admitMessage(msg("first", "alice")))
+	assert.False(t, gw.admitMessage(msg("second", "alice")))
+	// the channel has its own limit
+	assert.True(t, gw.admitMessage(&config.Message{Text: "other", UserID: "alice", Account: ircTestAccount, Channel: "#other"}))
+	assert.False(t, gw.admitMessage(msg("bob", "bob")))
+	// events and priority messages aren't limited
+	assert.True(t, gw.admitMessage(&config.Message{Event: config.EventJoinLeave, Account: ircTestAccount, Channel: "#test"}))
+	gw.MyConfig.PriorityUserIDs = []string{"alice"}
+	assert.True(t, gw.admitMessage(msg("priority", "alice")))
+	gw.MyConfig.PriorityUserIDs = nil
+
+	time.Sleep(110 * time.Millisecond)
+	assert.True(t, gw.admitMessage(msg("third", "alice")))
+}
+
+func TestRateLimitQueue(t *testing.T) {
+	gw := rateLimitedGateway(config.RateLimitQueue)
+	msg := func(text string, userID string) *config.Message {
+		return &config.Message{Text: text, UserID: userID, Account: ircTestAccount, Channel: "#test"}
+	}
+
+	assert.True(t, gw.admitMessage(msg("first", "alice")))
+	assert.False(t, gw.admitMessage(msg("second", "alice")))
+	assert.False(t, gw.admitMessage(msg("third", "alice")))
+
+	var released []string
+	for len(released) < 2 {
+		select {
+		case res := <-gw.Router.released:
+			assert.Equal(t, []*Gateway{gw}, res.gateways)
+			released = append(released, res.msg.Text)
+		case <-time.After(time.Second):
+			t.Fatal("the queued messages were not released")
+		}
+	}
+	assert.Equal(t, []string{"second", "third"}, released)
+}
+
+func TestRateLimitCollapse(t *testing.T) {
+	gw := rateLimitedGateway(config.RateLimitCollapse)
+	gw.MyConfig.ChannelRateLimit = 0
+	msg := func(text string) *config.Message {
+		return &config.Message{Text: text, Username: "alice", UserID: "alice", Account: ircTestAccount, Channel: "#test"}
+	}
+
+	assert.True(t, gw.admitMessage(msg("first")))
+	assert.False(t, gw.admitMessage(msg("second")))
+	assert.False(t, gw.admitMessage(msg("third")))
+
+	select {
+	case res := <-gw.Router.released:
+		assert.Equal(t, "(2 messages suppressed by the rate limit)", res.msg.Text)
+		assert.Equal(t, "alice", res.msg.Username)
+		assert.Equal(t, "#test", res.msg.Channel)
+	case <-time.After(time.Second):
+		t.Fatal("the summary was not released")
+	}
+	assert.True(t, gw.admitMessage(msg("fourth")))
+}
@@ -0,0 +1,275 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway/samechannel"
+)
+
+// enabledGateways returns the enabled gateways of gwconfigs, or an error when
+// one of them has no name or the name of another one.
+func (r *Router) enabledGateways(gwconfigs []config.Gateway) ([]config.Gateway, error) {
+	enabled := []config.Gateway{}
+	names := make(map[string]bool)
+	for _, entry := range gwconfigs {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("%s", "Gateway without name found")
+		}
+		if !entry.Enable {
+			r.logger.Warnf("Gateway %s defined but disabled", entry.Name)
+			continue
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("Gateway with name %s already exists", entry.Name)
+		}
+		names[entry.Name] = true
+		enabled = append(enabled, entry)
+	}
+	return enabled, nil
+}
+
+// requestReload makes the router apply the gateways of the configuration once
+// it was reloaded, see reloadGateways.
+func (r *Router) requestReload() {
+	select {
+	case r.reload <- struct{}{}:
+	default:
+		// a reload is already pending, it reads the latest configuration
+	}
+}
+
+// reloadGateways reads the gateways of the reloaded configuration and applies
+// them, or keeps the current ones when they are invalid.
+func (r *Router) reloadGateways() {
+	// the scripts may have been edited too
+	r.tengo.reset()
+	values, err := r.ReadBridgeValues()
+	if err != nil {
+		r.logger.Errorf("Reloading the gateways failed, keeping the current ones: %s", err)
+		return
+	}
+	gwconfigs, err := r.enabledGateways(append(samechannel.Gateways(r.Config, values.SameChannelGateway), values.Gateway...))
+	if err == nil {
+		err = r.checkNewAccounts(gwconfigs)
+	}
+	if err != nil {
+		r.logger.Errorf("Reloading the gateways failed, keeping the current ones: %s", err)
+		return
+	}
+	r.applyGateways(gwconfigs)
+}
+
+// checkNewAccounts returns an error for the channels of gwconfigs which can't
+// be relayed and for their accounts which aren't bridged yet and can't be set
+// up, which would stop matterbridge.
+func (r *Router) checkNewAccounts(gwconfigs []config.Gateway) error {
+	for _, entry := range gwconfigs {
+		for _, cfg := range append(entry.In, append(entry.InOut, entry.Out...)...) {
+			if err := checkChannelConfig(cfg); err != nil {
+				return err
+			}
+			if r.getBridge(cfg.Account) != nil {
+				continue
+			}
+			if !r.accountConfigured(cfg.Account) {
+				return fmt.Errorf("account %s defined in gateway %s but no configuration found", cfg.Account, entry.Name)
+			}
+			// optional accounts which can't be set up are left out
+			if cfg.Optional {
+				continue
+			}
+			if err := r.checkBridgeSetup(cfg); err != nil {
+				return fmt.Errorf("%w, used by account %s in gateway %s", err, cfg.Account, entry.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkBridgeSetup returns the error AddBridge would fail with for the new
+// account of cfg. The accounts of the DisabledProtocols are left out, not
+// failing.
+func (r *Router) checkBridgeSetup(cfg config.Bridge) error {
+	if protocol, name, _ := strings.Cut(cfg.Account, "."); protocol == "" || name == "" || strings.Contains(name, ".") {
+		return errors.New("incorrect account name")
+	}
+	br := bridge.New(&cfg)
+	if _, err := r.bridgeFactory(br.Protocol); errors.Is(err, errProtocolDisabled) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	br.Config = r.Config
+	br.General = &r.BridgeValues().General
+	br.Log = r.logger
+	if _, err := br.NewHttpClient(br.GetString("http_proxy")); err != nil {
+		return fmt.Errorf("HTTP settings incorrect: %w", err)
+	}
+	return nil
+}
+
+// applyGateways replaces the gateways of the router by those of gwconfigs.
+// The unchanged gateways are kept as they are, the others are set up again
+// with the message IDs of the gateway they replace. The bridges of the
+// accounts still used are kept connected and join their new channels, those
+// of the accounts no longer used are disconnected and the new accounts are
+// started. The bridges stay in the channels removed until they restart.
+func (r *Router) applyGateways(gwconfigs []config.Gateway) {
+	// the new gateways add their channels to the bridges they share
+	previous := make(map[string]*bridge.Bridge)
+	joined := make(map[string]map[string]config.ChannelInfo)
+	for _, account := range r.sortedAccounts() {
+		br := r.getBridge(account)
+		previous[account] = br
+		br.RLock()
+		joined[account] = maps.Clone(br.Channels)
+		br.RUnlock()
+	}
+
+	gateways := make(map[string]*Gateway)
+	order := []string{}
+	changed := []*Gateway{}
+	for i := range gwconfigs {
+		entry := &gwconfigs[i]
+		order = append(order, entry.Name)
+		old, ok := r.Gateways[entry.Name]
+		if ok && reflect.DeepEqual(*old.MyConfig, *entry) {
+			gateways[entry.Name] = old
+			continue
+		}
+		gw := New(r.logger.Logger, entry, r)
+		if ok {
+			gw.Messages, gw.onboarding, gw.limiter = old.Messages, old.onboarding, old.limiter
+			for account := range old.Bridges {
+				if _, used := gw.Bridges[account]; !used {
+					r.unregisterBridge(old.Name, account)
+				}
+			}
+			r.logger.Infof("Gateway %s changed, reloaded", entry.Name)
+		} else {
+			r.logger.Infof("Gateway %s added", entry.Name)
+		}
+		gateways[entry.Name] = gw
+		changed = append(changed, gw)
+	}
+	for name, old := range r.Gateways {
+		if _, ok := gateways[name]; ok {
+			continue
+		}
+		for account := range old.Bridges {
+			r.unregisterBridge(name, account)
+		}
+		r.logger.Infof("Gateway %s removed", name)
+	}
+	sort.Strings(order)
+	r.Lock()
+	r.Gateways = gateways
+	r.gatewayOrder = order
+	r.Unlock()
+
+	for _, account := range r.sortedAccounts() {
+		br := r.getBridge(account)
+		if _, ok := previous[account]; !ok {
+			r.run(func() { r.startAddedBridge(account) })
+			continue
+		}
+		started := r.bridgeStarted(account)
+		if started {
+			r.discoverMu.Lock()
+			for _, gw := range changed {
+				if _, ok := gw.Bridges[account]; ok {
+					gw.discoverPatternChannels(br)
+				}
+			}
+			r.discoverMu.Unlock()
+		}
+		// the bridges which didn't start yet join them when they do
+		if r.remapChannels(br, joined[account]) && started {
+			go r.joinAddedChannels(br)
+		}
+	}
+	for account, br := range previous {
+		if r.getBridge(account) != nil {
+			continue
+		}
+		r.logger.Infof("%s is no longer used by the gateways, disconnecting it", account)
+		r.forgetBridgeStatus(account)
+		go func(br *bridge.Bridge) {
+			if err := br.Disconnect(); err != nil {
+				r.logger.Errorf("Disconnecting %s failed: %s", br.Account, err)
+			}
+		}(br)
+	}
+}
+
+// remapChannels sets the channels of br to those of the gateways, and returns
+// true if some of them weren't in before.
+func (r *Router) remapChannels(br *bridge.Bridge, before map[string]config.ChannelInfo) bool {
+	channels := make(map[string]config.ChannelInfo)
+	for _, gw := range r.sortedGateways() {
+		for ID, channel := range gw.channels() {
+			if channel.Account == br.Account {
+				channels[ID] = *channel
+			}
+		}
+	}
+	added := false
+	for ID := range channels {
+		if _, ok := before[ID]; !ok {
+			added = true
+		}
+	}
+	for ID, channel := range before {
+		if _, ok := channels[ID]; !ok {
+			r.logger.Infof("%s no longer relays %s, it stays in the channel until it restarts", br.Account, channel.Name)
+		}
+	}
+	br.Lock()
+	br.Channels = channels
+	br.Unlock()
+	return added
+}
+
+// joinAddedChannels joins the channels added to br by a reload.
+func (r *Router) joinAddedChannels(br *bridge.Bridge) {
+	if err := br.JoinChannels(); err != nil {
+		r.logger.Errorf("Bridge %s failed to join channel: %s", br.Account, err)
+		return
+	}
+	r.logger.Infof("%s joined its new channels", br.Account)
+	r.postOnboardingNotices(br.Account)
+}
+
+// startAddedBridge starts the bridge of an account added by a reload, like the
+// bridges started with the router.
+func (r *Router) startAddedBridge(account string) {
+	err := r.startBridge(account)
+	if err != nil && r.failOver(account, err) {
+		err = r.startBridge(account)
+	}
+	var joinErr *joinError
+	switch {
+	case err == nil:
+	case errors.As(err, &joinErr):
+		r.logger.Error(err)
+		r.markBridgeStarted(account)
+	default:
+		r.retryBridge(account, err)
+	}
+}
+
+// forgetBridgeStatus removes the status of an account no longer used.
+func (r *Router) forgetBridgeStatus(account string) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	delete(r.status, account)
+	delete(r.started, account)
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// disconnectBridger records its disconnection.
+type disconnectBridger struct {
+	bridge.Bridger
+
+	disconnected chan struct{}
+}
+
+func (b *disconnectBridger) Disconnect() error {
+	close(b.disconnected)
+	return nil
+}
+
+func TestApplyGateways(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	irc, slack := r.getBridge(ircTestAccount), r.getBridge(slackTestAccount)
+	disconnecter := &disconnectBridger{Bridger: slack.Bridger, disconnected: make(chan struct{})}
+	slack.Bridger = disconnecter
+	r.setBridgeStatus(slackTestAccount, BridgeConnected, nil)
+	bridge2 := r.Gateways["bridge2"]
+	r.Gateways["bridge"].Messages.Add("telegram 1", []*BrMsgID{{irc, "irc 10", "#main" + ircTestAccount}})
+
+	// bridge relays a new irc channel and no longer slack, bridge3 and the
+	// slack channel of announcements are removed
+	gwconfigs := []config.Gateway{
+		{Name: "announcements", Enable: true, In: []config.Bridge{{Account: tgTestAccount, Channel: "-2222222222222"}}, Out: []config.Bridge{{Account: ircTestAccount, Channel: "#main"}}},
+		{Name: "bridge", Enable: true, InOut: []config.Bridge{{Account: ircTestAccount, Channel: "#main"}, {Account: ircTestAccount, Channel: "#new"}, {Account: tgTestAccount, Channel: "-1111111111111"}}},
+		*bridge2.MyConfig,
+	}
+	require.NoError(t, r.checkNewAccounts(gwconfigs))
+	r.applyGateways(gwconfigs)
+
+	assert.Equal(t, []string{"announcements", "bridge", "bridge2"}, r.gatewayOrder)
+	assert.Same(t, bridge2, r.Gateways["bridge2"])
+	assert.Same(t, irc, r.getBridge(ircTestAccount))
+	assert.Contains(t, irc.Channels, "#new"+ircTestAccount)
+	assert.NotContains(t, irc.Channels, "#main-telegram"+ircTestAccount)
+	assert.Contains(t, irc.Channels, "#main-help"+ircTestAccount)
+	// the IDs of the messages relayed before are kept
+	assert.Equal(t, "telegram 1", r.Gateways["bridge"].FindCanonicalMsgID("irc", "10"))
+
+	assert.Nil(t, r.getBridge(slackTestAccount))
+	select {
+	case <-disconnecter.disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("slack.zzz wasn't disconnected")
+	}
+	for _, status := range r.BridgeStatus() {
+		assert.NotEqual(t, slackTestAccount, status.Account)
+	}
+
+	// the accounts without configuration and the duplicate gateways are refused
+	assert.EqualError(t, r.checkNewAccounts([]config.Gateway{{Name: "other", InOut: []config.Bridge{{Account: "xmpp.zzz", Channel: "main"}}}}),
+		"account xmpp.zzz defined in gateway other but no configuration found")
+	_, err := r.enabledGateways([]config.Gateway{{Name: "bridge", Enable: true}, {Name: "bridge", Enable: true}})
+	assert.EqualError(t, err, "Gateway with name bridge already exists")
+
+	// and so are those which AddBridge would exit for, unless optional
+	r.Config.Viper().Set("xmpp.proxied.http_proxy", "://proxy")
+	defer r.Config.Viper().Set("xmpp.proxied.http_proxy", "")
+	other := []config.Gateway{{Name: "other", InOut: []config.Bridge{{Account: "xmpp.proxied", Channel: "main"}}}}
+	assert.ErrorContains(t, r.checkNewAccounts(other), "HTTP settings incorrect")
+	other[0].InOut[0].Optional = true
+	assert.NoError(t, r.checkNewAccounts(other))
+	other[0].InOut[0].Optional = false
+	r.BridgeValues().General.DisabledProtocols = []string{"xmpp"}
+	assert.NoError(t, r.checkNewAccounts(other))
+	assert.EqualError(t, r.checkNewAccounts([]config.Gateway{{Name: "other", InOut: []config.Bridge{{Account: "mattermost.zzz", Channel: "#town-square"}}}}),
+		"Mattermost channels do not start with a #: remove the # in #town-square")
+}
@@ -1,12 +1,17 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/matterbridge-org/matterbridge/bridge"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
 	"github.com/matterbridge-org/matterbridge/gateway/samechannel"
 	"github.com/sirupsen/logrus"
 )
@@ -20,6 +25,72 @@ type Router struct {
 	Message          chan config.Message
 	MattermostPlugin chan config.Message
 
+	// bridges holds the single bridge instance of every account, which is
+	// shared by all the gateways listed for that account in bridgeOwners.
+	bridges      map[string]*bridge.Bridge
+	bridgeOwners map[string][]string
+	gatewayOrder []string
+	breakers     map[string]*sendBreaker
+	mediaQueues  map[string]*mediaQueue
+	outboxes     map[string]*outbox
+	// msgIDsMu guards the IDs changed after relayMessage recorded them, see
+	// addMsgIDs and replaceMsgID
+	msgIDsMu sync.Mutex
+	// highlightsMu guards highlights, which the highlight command changes
+	highlightsMu sync.RWMutex
+	highlights   []*highlight
+	identities   *identities
+	schedule     *scheduler
+	// store keeps the state across restarts, nil without StorageBackend
+	store   storage.Store
+	optOuts *optOuts
+	audit   *auditLog
+	traffic *traffic
+	seen    *lru.Cache
+	// logs keeps the last log entries for the diagnostic bundle
+	logs *logBuffer
+	// resolved receives the messages whose files were handled, see
+	// resolveFiles
+	resolved chan resolvedMessage
+	// sources holds the messages received while an earlier message of
+	// their source waits for its files, see holdMessage
+	sources map[string][]heldMessage
+	// released receives the messages held by the rate limits of a gateway,
+	// see admitMessage
+	released chan resolvedMessage
+	// reload is signaled when the configuration was reloaded, see
+	// reloadGateways
+	reload chan struct{}
+	// spool counts the references to the spool files, see holdFiles
+	spool spoolRefs
+	// tengo holds the compiled scripts of the [tengo] section
+	tengo tengoScripts
+	// queueSeq orders the messages queued in the StorageBackend, see
+	// storeQueued
+	queueSeq atomic.Int64
+	// reloadHooks unregisters the config.OnReload hooks of the router, see
+	// onReload
+	reloadHooks []func()
+	// stop is closed by Stop, which waits for the goroutines of running
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+
+	// status holds the connection status of every account, started the
+	// accounts which connected at least once.
+	statusMu sync.Mutex
+	status   map[string]*BridgeStatus
+	started  map[string]bool
+	// heartbeats holds the last heartbeats of the probed accounts
+	heartbeats map[string]*bridge.Heartbeat
+	// standby holds the accounts which switched to their Standby settings
+	standby map[string]bool
+	// muted holds the accounts muted with the mute command
+	muted map[string]bool
+	// reconnecting holds the accounts being reconnected, closed once they are
+	reconnecting map[string]chan struct{}
+	discoverMu   sync.Mutex
+
 	logger *logrus.Entry
 }
 
@@ -27,6 +98,8 @@ type Router struct {
 // sets up all required gateways.
 func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[string]bridge.Factory) (*Router, error) {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "router"})
+	seen, _ := lru.New(seenSize)
+	general := cfg.BridgeValues().General
 
 	r := &Router{
 		Config:           cfg,
@@ -34,25 +107,58 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 		Message:          make(chan config.Message),
 		MattermostPlugin: make(chan config.Message),
 		Gateways:         make(map[string]*Gateway),
+		bridges:          make(map[string]*bridge.Bridge),
+		bridgeOwners:     make(map[string][]string),
+		breakers:         make(map[string]*sendBreaker),
+		mediaQueues:      make(map[string]*mediaQueue),
+		outboxes:         make(map[string]*outbox),
+		status:           make(map[string]*BridgeStatus),
+		started:          make(map[string]bool),
+		heartbeats:       make(map[string]*bridge.Heartbeat),
+		standby:          make(map[string]bool),
+		muted:            make(map[string]bool),
+		reconnecting:     make(map[string]chan struct{}),
+		schedule:         newScheduler(),
+		optOuts:          newOptOuts(),
+		identities:       newIdentities(),
+		seen:             seen,
+		traffic:          newTraffic(general.MessageSamples, general.AuditLogHashContent),
+		resolved:         make(chan resolvedMessage),
+		sources:          make(map[string][]heldMessage),
+		released:         make(chan resolvedMessage),
+		reload:           make(chan struct{}, 1),
+		stop:             make(chan struct{}),
+		logs:             newLogBuffer(),
 		logger:           logger,
 	}
+	rootLogger.AddHook(r.logs)
+	if general.StorageBackend != "" {
+		store, err := storage.Open(general.StorageBackend, general.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		r.store = store
+		r.logger.Infof("Keeping the state in the %s storage", general.StorageBackend)
+	}
 	sgw := samechannel.New(cfg)
 	gwconfigs := append(sgw.GetConfig(), cfg.BridgeValues().Gateway...)
 
+	gwconfigs, err := r.enabledGateways(gwconfigs)
+	if err != nil {
+		return nil, err
+	}
 	for idx := range gwconfigs {
 		entry := &gwconfigs[idx]
-		if entry.Name == "" {
-			return nil, fmt.Errorf("%s", "Gateway without name found")
-		}
-		if !entry.Enable {
-			r.logger.Warnf("Gateway %s defined but disabled", entry.Name)
-			continue
-		}
-		if _, ok := r.Gateways[entry.Name]; ok {
-			return nil, fmt.Errorf("Gateway with name %s already exists", entry.Name)
-		}
 		r.Gateways[entry.Name] = New(rootLogger, entry, r)
+		r.gatewayOrder = append(r.gatewayOrder, entry.Name)
+	}
+	sort.Strings(r.gatewayOrder)
+
+	highlights, err := r.newHighlights()
+	if err != nil {
+		return nil, err
 	}
+	r.highlights = highlights
 	return r, nil
 }
 
@@ -65,114 +171,426 @@ func (r *Router) Start() error {
 		r.logger.Fatal("MediaServerUpload config option has been deprecated. You should either remove this option from your configuration, or help us document it.")
 	}
 
-	m := make(map[string]*bridge.Bridge)
 	if len(r.Gateways) == 0 {
 		return fmt.Errorf("no [[gateway]] configured. See https://github.com/42wim/matterbridge/wiki/How-to-create-your-config for more info")
 	}
-	for _, gw := range r.Gateways {
+	for _, gw := range r.sortedGateways() {
 		r.logger.Infof("Parsing gateway %s", gw.Name)
 		if len(gw.Bridges) == 0 {
 			return fmt.Errorf("no bridges configured for gateway %s. See https://github.com/42wim/matterbridge/wiki/How-to-create-your-config for more info", gw.Name)
 		}
-		for _, br := range gw.Bridges {
-			m[br.Account] = br
-		}
 	}
-	for _, br := range m {
-		r.logger.Infof("Starting bridge: %s ", br.Account)
-		err := br.Connect()
-		if err != nil {
-			e := fmt.Errorf("Bridge %s failed to start: %v", br.Account, err)
-			if r.disableBridge(br, e) {
-				continue
-			}
-			return e
+	if err := r.prepareSpool(); err != nil {
+		return err
+	}
+	r.startMediaRetention()
+	if err := r.prepareAuditLog(); err != nil {
+		return err
+	}
+	r.startMessageStore()
+	restored := r.loadQueues()
+	r.onReload(func(string) {
+		r.resetStandby()
+		r.requestReload()
+	})
+	// Every account is connected and joined exactly once, no matter how many
+	// gateways it is used in.
+	errs := r.startBridges()
+	for _, account := range r.sortedAccounts() {
+		err, ok := errs[account]
+		if !ok {
+			continue
 		}
-		err = br.JoinChannels()
-		if err != nil {
-			e := fmt.Errorf("Bridge %s failed to join channel: %v", br.Account, err)
-			if r.disableBridge(br, e) {
-				continue
-			}
-			return e
+		if !r.BridgeValues().General.IgnoreFailureOnStart && !r.bridgeOptional(account) {
+			return err
+		}
+		// The bridge joined some of its channels, relay what we can
+		var joinErr *joinError
+		if errors.As(err, &joinErr) {
+			r.logger.Error(err)
+			r.markBridgeStarted(account)
+			continue
 		}
+		r.run(func() { r.retryBridge(account, err) })
 	}
-	// remove unused bridges
-	for _, gw := range r.Gateways {
-		for i, br := range gw.Bridges {
-			if br.Bridger == nil {
-				r.logger.Errorf("removing failed bridge %s", i)
-				delete(gw.Bridges, i)
-			}
+	// the queues of the bridges still retrying are sent with their next
+	// message
+	for _, account := range restored {
+		if r.bridgeStarted(account) {
+			r.run(func() { _, _ = r.ReplayQueue(account) })
 		}
 	}
-	go r.handleReceive()
+	if err := r.loadSchedule(); err != nil {
+		return err
+	}
+	if err := r.loadOptOuts(); err != nil {
+		return err
+	}
+	if err := r.loadIdentities(); err != nil {
+		return err
+	}
+	if err := r.loadHighlights(); err != nil {
+		return err
+	}
+	r.startHeartbeats()
+	r.run(r.handleReceive)
+	r.run(r.runScheduler)
+	r.run(r.superviseOutboxes)
+	if r.BridgeValues().General.AdminListen != "" {
+		r.run(r.serveAdmin)
+	}
 	//go r.updateChannelMembers()
 	return nil
 }
 
-// disableBridge returns true and empties a bridge if we have IgnoreFailureOnStart configured
-// otherwise returns false
-func (r *Router) disableBridge(br *bridge.Bridge, err error) bool {
-	if r.BridgeValues().General.IgnoreFailureOnStart {
-		r.logger.Error(err)
-		// setting this bridge empty
-		*br = bridge.Bridge{
-			Log: br.Log,
+// Stop stops routing the messages and the background tasks started by Start,
+// and waits for them to return. The bridges stay connected.
+func (r *Router) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.Lock()
+		for _, unregister := range r.reloadHooks {
+			unregister()
 		}
+		r.reloadHooks = nil
+		r.Unlock()
+	})
+	r.running.Wait()
+}
+
+// onReload registers fn with config.OnReload until the router is stopped.
+func (r *Router) onReload(fn func(name string)) {
+	unregister := config.OnReload(fn)
+	r.Lock()
+	defer r.Unlock()
+	r.reloadHooks = append(r.reloadHooks, unregister)
+}
+
+// run runs fn in a goroutine which Stop waits for. fn must return once r.stop
+// is closed.
+func (r *Router) run(fn func()) {
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		fn()
+	}()
+}
+
+// sleep waits for d, returns false if the router was stopped meanwhile.
+func (r *Router) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
 		return true
+	case <-r.stop:
+		return false
 	}
-	return false
 }
 
 func (r *Router) getBridge(account string) *bridge.Bridge {
-	for _, gw := range r.Gateways {
-		if br, ok := gw.Bridges[account]; ok {
-			return br
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.bridges[account]
+}
+
+// registerBridge records that gateway gwName uses the shared bridge instance br.
+//
+// Registering the same gateway twice for an account is a no-op, so an account
+// listed several times in a gateway still counts as a single reference.
+func (r *Router) registerBridge(gwName string, br *bridge.Bridge) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.bridges[br.Account] = br
+	for _, owner := range r.bridgeOwners[br.Account] {
+		if owner == gwName {
+			return
 		}
 	}
-	return nil
+	r.bridgeOwners[br.Account] = append(r.bridgeOwners[br.Account], gwName)
+	sort.Strings(r.bridgeOwners[br.Account])
+}
+
+// unregisterBridge drops the reference of gateway gwName on account, and
+// forgets about the bridge instance when no gateway uses it anymore.
+func (r *Router) unregisterBridge(gwName string, account string) {
+	r.Lock()
+	defer r.Unlock()
+
+	owners := r.bridgeOwners[account]
+	for i, owner := range owners {
+		if owner == gwName {
+			owners = append(owners[:i], owners[i+1:]...)
+			break
+		}
+	}
+	if len(owners) == 0 {
+		delete(r.bridgeOwners, account)
+		delete(r.bridges, account)
+		return
+	}
+	r.bridgeOwners[account] = owners
+}
+
+// bridgeRefs returns the number of gateways sharing the bridge for account.
+func (r *Router) bridgeRefs(account string) int {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.bridgeOwners[account])
+}
+
+// sortedAccounts returns the accounts of all bridge instances in a stable order.
+func (r *Router) sortedAccounts() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	accounts := make([]string, 0, len(r.bridges))
+	for account := range r.bridges {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// sortedGateways returns the gateways ordered by name, so that messages are
+// always routed through them in the same order.
+func (r *Router) sortedGateways() []*Gateway {
+	r.RLock()
+	defer r.RUnlock()
+
+	gws := make([]*Gateway, 0, len(r.gatewayOrder))
+	for _, name := range r.gatewayOrder {
+		if gw, ok := r.Gateways[name]; ok {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+// ownerGateways returns the gateways using the bridge of account. The
+// gateways added by a reload aren't returned until they replace the others.
+func (r *Router) ownerGateways(account string) []*Gateway {
+	r.RLock()
+	defer r.RUnlock()
+
+	gws := []*Gateway{}
+	for _, owner := range r.bridgeOwners[account] {
+		if gw, ok := r.Gateways[owner]; ok {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+// resolvedMessage is a message whose files were handled by the media pool of
+// the first of gateways, waiting to be relayed by it and the gateways after it.
+type resolvedMessage struct {
+	msg      config.Message
+	gateways []*Gateway
+	// highlights are those matched by msg, which aren't forwarded yet
+	highlights []*highlight
+	// source is the key of the source whose next messages wait for msg,
+	// empty when they don't
+	source string
+	// files are the spool files of msg held until it is relayed
+	files []string
 }
 
 func (r *Router) handleReceive() {
-	for msg := range r.Message {
-		msg := msg // scopelint
-		r.handleEventGetChannelMembers(&msg)
-		r.handleEventFailure(&msg)
-		r.handleEventRejoinChannels(&msg)
-
-		// Set message protocol based on the account it came from
-		msg.Protocol = r.getBridge(msg.Account).Protocol
-
-		filesHandled := false
-		for _, gw := range r.Gateways {
-			// record all the message ID's of the different bridges
-			var msgIDs []*BrMsgID
-			if gw.ignoreMessage(&msg) {
-				continue
-			}
+	for {
+		select {
+		case msg := <-r.Message:
+			r.receiveMessage(msg)
+		case res := <-r.resolved:
+			r.handleResolved(res)
+		case res := <-r.released:
+			r.relayReleased(res.gateways[0], res.msg)
+			r.releaseFiles(res.files)
+		case <-r.reload:
+			r.reloadGateways()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Router) receiveMessage(msg config.Message) {
+	// the queues keeping msg take their own reference to its files
+	defer r.releaseFiles(r.holdFiles(&msg))
+
+	if r.handleEventBridgeStarted(&msg) {
+		return
+	}
+	r.handleEventGetChannelMembers(&msg)
+	r.handleEventFailure(&msg)
+	r.handleEventRejoinChannels(&msg)
+	if r.handleEventMsgAck(&msg) {
+		return
+	}
+	if r.handleCommand(&msg) {
+		return
+	}
+
+	// Set message protocol based on the account it came from
+	msg.Protocol = r.getBridge(msg.Account).Protocol
+	r.recordSeen(&msg)
+	r.handleOnboardingJoin(&msg)
+	r.auditFilesTooLarge(&msg)
+	highlights := r.matchingHighlights(&msg)
+	// the messages of a source wait for the files of the previous ones
+	if r.holdMessage(&msg, highlights) {
+		return
+	}
+	r.routeMessage(msg, r.sortedGateways(), false, highlights)
+}
+
+// handleResolved relays res once its files are handled, and the messages of
+// its source received meanwhile.
+func (r *Router) handleResolved(res resolvedMessage) {
+	defer r.releaseFiles(res.files)
+	r.relayMessage(res.gateways[0], &res.msg)
+	r.routeMessage(res.msg, res.gateways[1:], true, res.highlights)
+	if res.source != "" {
+		r.releaseSource(res.source)
+	}
+}
+
+// routeMessage relays msg through gateways. The files are handled once, by
+// the first gateway relaying the message: the message waits for them in the
+// background while the router relays the messages of the other sources, those
+// of its own source are held until it is relayed. The highlights are
+// forwarded by the first gateway of their scope which doesn't ignore msg.
+func (r *Router) routeMessage(msg config.Message, gateways []*Gateway, filesHandled bool, highlights []*highlight) {
+	for i, gw := range gateways {
+		gw.handlePatternChannels(&msg)
+		if gw.ignoreMessage(&msg) {
+			continue
+		}
+		highlights = r.forwardHighlights(gw, &msg, highlights)
+		// the bridges set the send time when their network tells it
+		if msg.Timestamp.IsZero() {
 			msg.Timestamp = time.Now()
-			gw.modifyMessage(&msg)
-			if !filesHandled {
-				gw.handleFiles(&msg)
-				filesHandled = true
-			}
-			for _, br := range gw.Bridges {
-				msgIDs = append(msgIDs, gw.handleMessage(&msg, br)...)
+		}
+		if !gw.admitMessage(&msg) {
+			continue
+		}
+		gw.modifyMessage(&msg)
+		if !filesHandled {
+			filesHandled = true
+			if gw.hasFilesToHandle(&msg) {
+				source := sourceKey(&msg)
+				r.waitForFiles(source)
+				r.resolveFiles(resolvedMessage{msg: msg, gateways: gateways[i:], highlights: highlights, source: source, files: r.holdFiles(&msg)})
+				return
 			}
+		}
+		r.relayMessage(gw, &msg)
+	}
+}
 
-			if msg.ID != "" {
-				_, exists := gw.Messages.Get(msg.Protocol + " " + msg.ID)
+// resolveFiles handles in the background the files of the message of res with
+// the media pool of the first of its gateways, and hands it back to the
+// router. The message is dropped when the router is stopped meanwhile.
+func (r *Router) resolveFiles(res resolvedMessage) {
+	r.run(func() {
+		res.gateways[0].handleFiles(&res.msg)
+		select {
+		case r.resolved <- res:
+		case <-r.stop:
+			r.releaseFiles(res.files)
+		}
+	})
+}
 
-				// Only add the message ID if it doesn't already exist
-				//
-				// For some bridges we always add/update the message ID.
-				// This is necessary as msgIDs will change if a bridge returns
-				// a different ID in response to edits.
-				if !exists {
-					gw.Messages.Add(msg.Protocol+" "+msg.ID, msgIDs)
-				}
+// relayReleased relays msg through gw only, once released by its rate limits.
+func (r *Router) relayReleased(gw *Gateway, msg config.Message) {
+	gw.modifyMessage(&msg)
+	if gw.hasFilesToHandle(&msg) {
+		r.resolveFiles(resolvedMessage{msg: msg, gateways: []*Gateway{gw}, files: r.holdFiles(&msg)})
+		return
+	}
+	r.relayMessage(gw, &msg)
+}
+
+// relayMessage sends msg to the bridges of gw, once changed by the script of
+// gw, and records the IDs of the relayed messages.
+func (r *Router) relayMessage(gw *Gateway, msg *config.Message) {
+	scripted := gw.runScript(msg)
+	if scripted == nil {
+		gw.logger.Debugf("Script of gateway %s dropping %#v", gw.Name, msg)
+		r.auditMessage(auditDrop, auditScript, gw, msg, "")
+		return
+	}
+	msg = scripted
+	// record all the message ID's of the different bridges
+	var msgIDs []*BrMsgID
+	// the messages to the AsyncSend bridges are sent by their outbox, once
+	// the IDs of the others are recorded
+	var outboxed []outboxMessage
+	canonicalID := msg.Protocol + " " + msg.ID
+	_, exists := gw.Messages.Get(canonicalID)
+	// the text is summarized for the destinations it is too long for only,
+	// and stored once
+	var summary string
+	for _, br := range gw.Bridges {
+		if !r.bridgeStarted(br.Account) {
+			continue
+		}
+		if r.isMuted(br.Account) {
+			r.auditMessage(auditDrop, auditMuted, gw, msg, br.Account)
+			continue
+		}
+		sent := *msg
+		if gw.isLongMessage(msg, br) {
+			if summary == "" {
+				summary = gw.summarizeLongMessage(msg)
 			}
+			sent.Text = summary
+		}
+		if br.GetBool("AsyncSend") {
+			queued := outboxMessage{gw: gw, dest: br, msg: sent}
+			if msg.ID != "" && !exists {
+				queued.canonicalID = canonicalID
+			}
+			outboxed = append(outboxed, queued)
+			continue
+		}
+		msgIDs = append(msgIDs, gw.handleMessage(&sent, br)...)
+	}
+	r.recordTraffic(trafficRelay, "", gw, msg, "")
+	r.auditModeration(gw, msg)
+
+	if msg.ID != "" {
+		// Only add the message ID if it doesn't already exist
+		//
+		// For some bridges we always add/update the message ID.
+		// This is necessary as msgIDs will change if a bridge returns
+		// a different ID in response to edits.
+		if !exists {
+			gw.setMsgIDs(canonicalID, msgIDs)
+		}
+	}
+	for _, queued := range outboxed {
+		r.getOutbox(queued.dest.Account).push(queued)
+	}
+	gw.expireMessage(msg)
+}
+
+// superviseOutboxes checks every outboxCheckInterval that the AsyncSend
+// bridges drain their outbox, see checkOutboxes.
+func (r *Router) superviseOutboxes() {
+	ticker := time.NewTicker(outboxCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.checkOutboxes(now)
+		case <-r.stop:
+			return
 		}
 	}
 }
@@ -181,20 +599,24 @@ func (r *Router) handleReceive() {
 func (r *Router) updateChannelMembers() {
 	// TODO sleep a minute because slack can take a while
 	// fix this by having actually connectionDone events send to the router
-	time.Sleep(time.Minute)
+	if !r.sleep(time.Minute) {
+		return
+	}
 	for {
-		for _, gw := range r.Gateways {
+		for _, gw := range r.sortedGateways() {
 			for _, br := range gw.Bridges {
 				// only for slack now
 				if br.Protocol != "slack" {
 					continue
 				}
 				r.logger.Debugf("sending %s to %s", config.EventGetChannelMembers, br.Account)
-				if _, err := br.Send(config.Message{Event: config.EventGetChannelMembers}); err != nil {
+				if _, err := br.SendInOrder(config.Message{Event: config.EventGetChannelMembers}); err != nil {
 					r.logger.Errorf("updateChannelMembers: %s", err)
 				}
 			}
 		}
-		time.Sleep(time.Minute)
+		if !r.sleep(time.Minute) {
+			return
+		}
 	}
 }
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedBridges(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	for _, account := range []string{ircTestAccount, tgTestAccount} {
+		br := r.getBridge(account)
+		for _, gw := range r.Gateways {
+			if gwbr, ok := gw.Bridges[account]; ok {
+				assert.Same(t, br, gwbr, "%s should use the shared instance of %s", gw.Name, account)
+			}
+		}
+	}
+	assert.Equal(t, 4, r.bridgeRefs(ircTestAccount))
+	assert.Equal(t, 4, r.bridgeRefs(tgTestAccount))
+	assert.Equal(t, 2, r.bridgeRefs(slackTestAccount))
+	assert.Equal(t, []string{"announcements", "bridge"}, r.bridgeOwners[slackTestAccount])
+	assert.Equal(t, []string{ircTestAccount, slackTestAccount, tgTestAccount}, r.sortedAccounts())
+
+	// irc.zzz is listed twice in the announcements gateway but is only one reference
+	r.unregisterBridge("announcements", ircTestAccount)
+	assert.Equal(t, 3, r.bridgeRefs(ircTestAccount))
+	r.unregisterBridge("announcements", slackTestAccount)
+	r.unregisterBridge("bridge", slackTestAccount)
+	assert.Nil(t, r.getBridge(slackTestAccount))
+}
+
+func TestStopPending(t *testing.T) {
+	r, gw := newTestGateway()
+	gw.MyConfig.EphemeralMessages = config.EphemeralMessagesExpire
+
+	// a message whose files are handled while nothing receives it anymore,
+	// and the expiry of an ephemeral one
+	msg, path := spooledMessage(t, "video.mp4")
+	r.resolveFiles(resolvedMessage{msg: msg, gateways: []*Gateway{gw}, files: r.holdFiles(&msg)})
+	ephemeral := &config.Message{Text: "hi", ID: "1", Account: slackTestAccount, Channel: "irc"}
+	ephemeral.MarkEphemeral(time.Hour)
+	gw.expireMessage(ephemeral)
+
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop didn't return, goroutines are still running")
+	}
+	assert.NoFileExists(t, path)
+	assert.Empty(t, r.Message)
+}
+
+func TestStopReloadHooks(t *testing.T) {
+	r, _ := newTestGateway()
+	r.onReload(func(string) {})
+	r.onReload(func(string) {})
+	assert.Len(t, r.reloadHooks, 2)
+
+	r.Stop()
+	assert.Empty(t, r.reloadHooks)
+}
@@ -0,0 +1,127 @@
+package samechannel
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// Normalizer converts the channel names of a samechannelgateway for a protocol
+// whose channel names don't follow the plain "name" form, eg. "#name" on IRC
+// or "#name:server" on Matrix.
+type Normalizer interface {
+	// Channel returns the channel to join on account for a configured name.
+	Channel(cfg config.Config, account string, name string) string
+	// Key returns the name used to match channels of different protocols.
+	Key(name string) string
+}
+
+var normalizers = map[string]Normalizer{
+	"irc":        ircNormalizer{},
+	"matrix":     matrixNormalizer{},
+	"discord":    nameNormalizer{},
+	"slack":      nameNormalizer{},
+	"mattermost": nameNormalizer{},
+}
+
+// Register sets the normalizer of protocol, replacing the existing one.
+func Register(protocol string, normalizer Normalizer) {
+	normalizers[protocol] = normalizer
+}
+
+// Channel returns the channel to join on account for a configured name.
+// Names are left untouched for protocols without a normalizer.
+func Channel(cfg config.Config, account string, name string) string {
+	if normalizer, ok := normalizers[protocolOf(account)]; ok {
+		return normalizer.Channel(cfg, account, name)
+	}
+	return name
+}
+
+// Key returns the name used to match a channel of protocol with the channels of
+// other protocols. Names are compared as is for protocols without a normalizer.
+func Key(protocol string, name string) string {
+	if normalizer, ok := normalizers[protocol]; ok {
+		return normalizer.Key(name)
+	}
+	return name
+}
+
+func protocolOf(account string) string {
+	protocol, _, _ := strings.Cut(account, ".")
+	return protocol
+}
+
+// nameNormalizer is used by protocols with case insensitive channel names,
+// which may be written with a leading #.
+type nameNormalizer struct{}
+
+func (nameNormalizer) Channel(cfg config.Config, account string, name string) string {
+	if strings.HasPrefix(name, "ID:") {
+		return name
+	}
+	return strings.TrimPrefix(name, "#")
+}
+
+func (nameNormalizer) Key(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "#"))
+}
+
+// ircNormalizer adds the # prefix IRC channels need. Keys are case folded,
+// channel names are lowercased by the gateway already.
+type ircNormalizer struct{}
+
+func (ircNormalizer) Channel(cfg config.Config, account string, name string) string {
+	if name == "" || strings.ContainsRune("#&+!", rune(name[0])) {
+		return name
+	}
+	return "#" + name
+}
+
+func (ircNormalizer) Key(name string) string {
+	return strings.ToLower(strings.TrimLeft(name, "#&+!"))
+}
+
+// matrixNormalizer turns names into room aliases on the server of the account,
+// and matches aliases by their local part. Room IDs are resolved to their
+// canonical alias by the bridge.
+type matrixNormalizer struct{}
+
+func (matrixNormalizer) Channel(cfg config.Config, account string, name string) string {
+	if strings.HasPrefix(name, "!") || strings.Contains(name, ":") {
+		return name
+	}
+	server := matrixServerName(cfg, account)
+	if server == "" {
+		return name
+	}
+	return "#" + strings.TrimPrefix(name, "#") + ":" + server
+}
+
+func (matrixNormalizer) Key(name string) string {
+	if strings.HasPrefix(name, "!") {
+		return name
+	}
+	local, _, _ := strings.Cut(strings.TrimPrefix(name, "#"), ":")
+	return strings.ToLower(local)
+}
+
+// matrixServerName returns the server name of the matrix account, from its
+// MxID or Login when they are full user IDs, or else from its Server URL.
+func matrixServerName(cfg config.Config, account string) string {
+	for _, key := range []string{"MxID", "Login"} {
+		if userID, _ := cfg.GetString(account + "." + key); strings.HasPrefix(userID, "@") {
+			if _, server, ok := strings.Cut(userID, ":"); ok {
+				return server
+			}
+		}
+	}
+
+	server, _ := cfg.GetString(account + ".Server")
+	u, err := url.Parse(server)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -13,13 +13,17 @@ func New(cfg config.Config) *SameChannelGateway {
 }
 
 func (sgw *SameChannelGateway) GetConfig() []config.Gateway {
+	return Gateways(sgw.Config, sgw.BridgeValues().SameChannelGateway)
+}
+
+// Gateways returns the gateways of the samechannelgateways sgws.
+func Gateways(cfg config.Config, sgws []config.SameChannelGateway) []config.Gateway {
 	var gwconfigs []config.Gateway
-	cfg := sgw.Config
-	for _, gw := range cfg.BridgeValues().SameChannelGateway {
+	for _, gw := range sgws {
 		gwconfig := config.Gateway{Name: gw.Name, Enable: gw.Enable}
 		for _, account := range gw.Accounts {
 			for _, channel := range gw.Channels {
-				gwconfig.InOut = append(gwconfig.InOut, config.Bridge{Account: account, Channel: channel, SameChannel: true})
+				gwconfig.InOut = append(gwconfig.InOut, config.Bridge{Account: account, Channel: Channel(cfg, account, channel), SameChannel: true})
 			}
 		}
 		gwconfigs = append(gwconfigs, gwconfig)
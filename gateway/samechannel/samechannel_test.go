@@ -75,3 +75,47 @@ func TestGetConfig(t *testing.T) {
 	configs := sgw.GetConfig()
 	assert.Equal(t, []config.Gateway{expectedConfig}, configs)
 }
+
+const testConfigProtocols = `
+[irc.libera]
+[matrix.test]
+Server = "https://matrix.example.org"
+Login = "@bot:example.org"
+[discord.test]
+
+[[samechannelgateway]]
+   enable = true
+   name = "blah"
+      accounts = [ "irc.libera","matrix.test","discord.test" ]
+      channels = [ "testing","#Dev" ]
+`
+
+func TestGetConfigNormalized(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg := config.NewConfigFromString(logger, []byte(testConfigProtocols))
+	configs := New(cfg).GetConfig()
+
+	var channels []string
+	for _, br := range configs[0].InOut {
+		channels = append(channels, br.Account+" "+br.Channel)
+	}
+	assert.Equal(t, []string{
+		"irc.libera #testing",
+		"irc.libera #Dev",
+		"matrix.test #testing:example.org",
+		"matrix.test #Dev:example.org",
+		"discord.test testing",
+		"discord.test Dev",
+	}, channels)
+}
+
+func TestKey(t *testing.T) {
+	for _, name := range []string{"#dev", "#Dev"} {
+		assert.Equal(t, "dev", Key("irc", name))
+	}
+	assert.Equal(t, "dev", Key("matrix", "#dev:example.org"))
+	assert.Equal(t, "!room:example.org", Key("matrix", "!room:example.org"))
+	assert.Equal(t, "dev", Key("discord", "Dev"))
+	assert.Equal(t, "Dev", Key("telegram", "Dev"))
+}
@@ -0,0 +1,324 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+)
+
+// scheduleLayout is the layout of the times of the scheduled messages in the
+// replies of the control commands.
+const scheduleLayout = "2006-01-02 15:04 MST"
+
+// scheduleRetryDelay is how long the scheduled messages which could not be
+// sent are postponed.
+var scheduleRetryDelay = time.Minute
+
+// scheduleNamespace and scheduleKey are where the scheduled messages are
+// kept in the storage without ScheduleFile.
+const (
+	scheduleNamespace = "schedule"
+	scheduleKey       = "messages"
+)
+
+// ScheduledMessage is a message sent at At in Channel of Account, and relayed
+// from there like the messages received in the channel.
+type ScheduledMessage struct {
+	ID       string    `json:"id"`
+	At       time.Time `json:"at"`
+	Account  string    `json:"account"`
+	Channel  string    `json:"channel"`
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+}
+
+// scheduler holds the scheduled messages, stored in ScheduleFile when it is
+// set so that they survive restarts.
+type scheduler struct {
+	sync.Mutex
+
+	// messages are ordered by delivery time
+	messages []ScheduledMessage
+	nextID   int
+	// wake interrupts the wait for the next message when the messages change
+	wake chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{nextID: 1, wake: make(chan struct{}, 1)}
+}
+
+// Schedule adds msg to the messages to send, and returns it with its ID. The
+// messages scheduled in the past are sent at once.
+func (r *Router) Schedule(msg ScheduledMessage) (ScheduledMessage, error) {
+	if r.getBridge(msg.Account) == nil {
+		return msg, fmt.Errorf("unknown account %s", msg.Account)
+	}
+	if !r.channelBridged(msg.Account, msg.Channel) {
+		return msg, fmt.Errorf("channel %s of %s isn't bridged", msg.Channel, msg.Account)
+	}
+	if msg.Text == "" {
+		return msg, errors.New("the message is empty")
+	}
+
+	s := r.schedule
+	s.Lock()
+	msg.ID = strconv.Itoa(s.nextID)
+	s.nextID++
+	s.messages = append(s.messages, msg)
+	sort.SliceStable(s.messages, func(i, j int) bool {
+		return s.messages[i].At.Before(s.messages[j].At)
+	})
+	err := r.saveSchedule()
+	s.Unlock()
+
+	r.logger.Infof("Scheduled message %s in %s of %s for %s", msg.ID, msg.Channel, msg.Account, msg.At.Format(scheduleLayout))
+	r.wakeScheduler()
+	return msg, err
+}
+
+// ScheduledMessages returns the messages to send, ordered by delivery time.
+func (r *Router) ScheduledMessages() []ScheduledMessage {
+	r.schedule.Lock()
+	defer r.schedule.Unlock()
+	return append([]ScheduledMessage{}, r.schedule.messages...)
+}
+
+// Unschedule cancels the scheduled message id.
+func (r *Router) Unschedule(id string) error {
+	s := r.schedule
+	s.Lock()
+	defer s.Unlock()
+	for i, msg := range s.messages {
+		if msg.ID == id {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			r.logger.Infof("Unscheduled message %s", id)
+			return r.saveSchedule()
+		}
+	}
+	return fmt.Errorf("unknown scheduled message %s", id)
+}
+
+// channelBridged returns true if channel of account is in one of the gateways.
+func (r *Router) channelBridged(account string, channel string) bool {
+	for _, gw := range r.sortedGateways() {
+		if _, ok := gw.channels()[channel+account]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) wakeScheduler() {
+	select {
+	case r.schedule.wake <- struct{}{}:
+	default:
+	}
+}
+
+// readSchedule returns the scheduled messages stored in ScheduleFile, or in the
+// storage without it, and where they were read. The data is nil when nothing
+// is stored.
+func (r *Router) readSchedule() ([]byte, string, error) {
+	if file := r.BridgeValues().General.ScheduleFile; file != "" {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, file, nil
+		}
+		return data, file, err
+	}
+	if r.store == nil {
+		return nil, "", nil
+	}
+	data, err := r.store.Get(scheduleNamespace, scheduleKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "the storage", nil
+	}
+	return data, "the storage", err
+}
+
+// loadSchedule reads the messages stored in ScheduleFile or in the storage.
+func (r *Router) loadSchedule() error {
+	data, file, err := r.readSchedule()
+	if data == nil || err != nil {
+		return err
+	}
+
+	s := r.schedule
+	s.Lock()
+	defer s.Unlock()
+	if err := json.Unmarshal(data, &s.messages); err != nil {
+		return fmt.Errorf("reading %s failed: %w", file, err)
+	}
+	sort.SliceStable(s.messages, func(i, j int) bool {
+		return s.messages[i].At.Before(s.messages[j].At)
+	})
+	for _, msg := range s.messages {
+		if id, err := strconv.Atoi(msg.ID); err == nil && id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	r.logger.Infof("Loaded %d scheduled messages from %s", len(s.messages), file)
+	return nil
+}
+
+// saveSchedule writes the scheduled messages to ScheduleFile when it is set,
+// or to the storage. It must be called with the scheduler locked.
+func (r *Router) saveSchedule() error {
+	file := r.BridgeValues().General.ScheduleFile
+	if file == "" && r.store == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.schedule.messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return r.store.Put(scheduleNamespace, scheduleKey, data)
+	}
+	// replace the file at once, a crash must not lose all the messages
+	if err := os.WriteFile(file+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// runScheduler sends the scheduled messages when they are due. They are kept in
+// ScheduleFile until they are sent, and postponed when they can't be.
+func (r *Router) runScheduler() {
+	timer := time.NewTimer(0)
+	for {
+		s := r.schedule
+		s.Lock()
+		due := []ScheduledMessage{}
+		for _, msg := range s.messages {
+			if msg.At.After(time.Now()) {
+				break
+			}
+			due = append(due, msg)
+		}
+		s.Unlock()
+
+		for _, msg := range due {
+			if err := r.sendScheduled(msg); err != nil {
+				r.logger.Errorf("Sending scheduled message %s failed: %s. Trying again in %s", msg.ID, err, scheduleRetryDelay)
+				r.reschedule(msg.ID, time.Now().Add(scheduleRetryDelay))
+				continue
+			}
+			r.reschedule(msg.ID, time.Time{})
+		}
+
+		s.Lock()
+		wait := time.Hour
+		if len(s.messages) > 0 {
+			wait = time.Until(s.messages[0].At)
+		}
+		s.Unlock()
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-r.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// reschedule moves the scheduled message id to at, or removes it when at is
+// zero, and saves the scheduled messages.
+func (r *Router) reschedule(id string, at time.Time) {
+	s := r.schedule
+	s.Lock()
+	defer s.Unlock()
+	for i, msg := range s.messages {
+		if msg.ID != id {
+			continue
+		}
+		s.messages = append(s.messages[:i], s.messages[i+1:]...)
+		if !at.IsZero() {
+			msg.At = at
+			s.messages = append(s.messages, msg)
+			sort.SliceStable(s.messages, func(i, j int) bool {
+				return s.messages[i].At.Before(s.messages[j].At)
+			})
+		}
+		if err := r.saveSchedule(); err != nil {
+			r.logger.Errorf("Saving the scheduled messages failed: %s", err)
+		}
+		return
+	}
+}
+
+// sendScheduled sends msg in its channel and relays it to the channels bridged
+// with it, once it was sent. The messages of unknown accounts are dropped.
+func (r *Router) sendScheduled(msg ScheduledMessage) error {
+	br := r.getBridge(msg.Account)
+	if br == nil {
+		r.logger.Errorf("Dropping scheduled message %s of unknown account %s", msg.ID, msg.Account)
+		return nil
+	}
+	if !r.bridgeStarted(msg.Account) {
+		return fmt.Errorf("%s is not connected", msg.Account)
+	}
+	r.logger.Infof("Sending scheduled message %s in %s of %s", msg.ID, msg.Channel, msg.Account)
+
+	_, err := sendWithTimeout(br, config.Message{
+		Text:     msg.Text,
+		Channel:  msg.Channel,
+		Account:  msg.Account,
+		Protocol: br.Protocol,
+		Extra:    make(map[string][]any),
+	})
+	if err != nil {
+		return err
+	}
+	r.Message <- config.Message{
+		Text:     msg.Text,
+		Channel:  msg.Channel,
+		Username: msg.Username,
+		Account:  msg.Account,
+		Extra:    make(map[string][]any),
+	}
+	return nil
+}
+
+// parseScheduleTime returns the time of the args of the schedule command,
+// relative to now, and the remaining args. The time is either a duration
+// ("30m", "2h"), a time of the day ("18:00", today or tomorrow) or a date and
+// time ("2026-10-20 18:00").
+func parseScheduleTime(args []string, now time.Time) (time.Time, []string, error) {
+	if len(args) == 0 {
+		return time.Time{}, nil, errors.New("a time is required")
+	}
+	if d, err := time.ParseDuration(args[0]); err == nil {
+		if d <= 0 {
+			return time.Time{}, nil, fmt.Errorf("%s is not in the future", args[0])
+		}
+		return now.Add(d), args[1:], nil
+	}
+	if t, err := time.ParseInLocation("15:04", args[0], now.Location()); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, args[1:], nil
+	}
+	if len(args) > 1 {
+		if at, err := time.ParseInLocation("2006-01-02 15:04", args[0]+" "+args[1], now.Location()); err == nil {
+			if !at.After(now) {
+				return time.Time{}, nil, fmt.Errorf("%s %s is not in the future", args[0], args[1])
+			}
+			return at, args[2:], nil
+		}
+	}
+	return time.Time{}, nil, fmt.Errorf("invalid time %s, use 30m, 18:00 or 2006-01-02 18:00", args[0])
+}
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	file := filepath.Join(t.TempDir(), "schedule.json")
+	r.BridgeValues().General.ScheduleFile = file
+	irc := r.getBridge(ircTestAccount)
+	joiner := &joinBridger{Bridger: irc.Bridger}
+	irc.Bridger = joiner
+
+	_, err := r.Schedule(ScheduledMessage{Account: "irc.unknown", Channel: "#main", Text: "hello"})
+	assert.EqualError(t, err, "unknown account irc.unknown")
+	_, err = r.Schedule(ScheduledMessage{Account: ircTestAccount, Channel: "#other", Text: "hello"})
+	assert.EqualError(t, err, "channel #other of irc.zzz isn't bridged")
+
+	tg := r.getBridge(tgTestAccount)
+	sender := &config.Message{Account: tgTestAccount, Channel: "-1111111111111", Username: "alice", UserID: "12345"}
+	tg.SetStringSlice("Admins", []string{"12345"})
+	assert.Contains(t, r.runCommand(tg, sender, "schedule 1h Meeting starts"), "Scheduled message 1 for ")
+	assert.Equal(t, "schedule failed: invalid time soon, use 30m, 18:00 or 2006-01-02 18:00", r.runCommand(tg, sender, "schedule soon hello"))
+	later, err := r.Schedule(ScheduledMessage{At: time.Now().Add(2 * time.Hour), Account: ircTestAccount, Channel: "#main", Text: "later"})
+	require.NoError(t, err)
+	assert.Equal(t, "2", later.ID)
+
+	// The scheduled messages are stored in ScheduleFile
+	loaded := maketestRouter(testconfig3)
+	loaded.BridgeValues().General.ScheduleFile = file
+	require.NoError(t, loaded.loadSchedule())
+	scheduled := loaded.ScheduledMessages()
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, "Meeting starts", scheduled[0].Text)
+	assert.Equal(t, "alice", scheduled[0].Username)
+	assert.Equal(t, 3, loaded.schedule.nextID)
+
+	assert.Equal(t, "Unscheduled message 2", r.runCommand(tg, sender, "unschedule 2"))
+	assert.Equal(t, "unschedule failed: unknown scheduled message 2", r.runCommand(tg, sender, "unschedule 2"))
+	require.NoError(t, loaded.loadSchedule())
+	assert.Len(t, loaded.ScheduledMessages(), 1)
+
+	// Due messages are kept until they are sent
+	defer func(delay time.Duration) { scheduleRetryDelay = delay }(scheduleRetryDelay)
+	scheduleRetryDelay = 50 * time.Millisecond
+	go r.runScheduler()
+	_, err = r.Schedule(ScheduledMessage{At: time.Now(), Account: ircTestAccount, Channel: "#main", Username: "bob", Text: "now"})
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, joiner.getEvents())
+	require.NoError(t, loaded.loadSchedule())
+	assert.Len(t, loaded.ScheduledMessages(), 2, "an unsent message stays in ScheduleFile")
+
+	// and then sent in their channel and relayed
+	r.statusMu.Lock()
+	r.started[ircTestAccount] = true
+	r.statusMu.Unlock()
+	select {
+	case msg := <-r.Message:
+		assert.Equal(t, "now", msg.Text)
+		assert.Equal(t, "bob", msg.Username)
+		assert.Equal(t, "#main", msg.Channel)
+	case <-time.After(time.Second):
+		t.Fatal("scheduled message not relayed")
+	}
+	assert.Equal(t, []string{"send #main"}, joiner.getEvents())
+	assert.Eventually(t, func() bool {
+		return len(r.ScheduledMessages()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestScheduleStorage(t *testing.T) {
+	store := storage.NewMemory()
+	r := maketestRouter(testconfig3)
+	r.store = store
+	_, err := r.Schedule(ScheduledMessage{At: time.Now().Add(time.Hour), Account: ircTestAccount, Channel: "#main", Text: "later"})
+	require.NoError(t, err)
+
+	// without ScheduleFile the messages are kept in the storage
+	loaded := maketestRouter(testconfig3)
+	loaded.store = store
+	require.NoError(t, loaded.loadSchedule())
+	scheduled := loaded.ScheduledMessages()
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, "later", scheduled[0].Text)
+	assert.Equal(t, 2, loaded.schedule.nextID)
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		args []string
+		at   time.Time
+		err  string
+	}{
+		{args: []string{"30m", "hi"}, at: now.Add(30 * time.Minute)},
+		{args: []string{"18:00", "hi"}, at: time.Date(2026, 10, 15, 18, 0, 0, 0, time.UTC)},
+		{args: []string{"09:30", "hi"}, at: time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)},
+		{args: []string{"2026-10-20", "18:00", "hi"}, at: time.Date(2026, 10, 20, 18, 0, 0, 0, time.UTC)},
+		{args: []string{"2026-10-01", "18:00", "hi"}, err: "2026-10-01 18:00 is not in the future"},
+		{args: []string{"-5m", "hi"}, err: "-5m is not in the future"},
+		{args: []string{}, err: "a time is required"},
+	} {
+		at, rest, err := parseScheduleTime(test.args, now)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.args)
+			continue
+		}
+		require.NoError(t, err, test.args)
+		assert.Equal(t, test.at, at, test.args)
+		assert.Equal(t, []string{"hi"}, rest, test.args)
+	}
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"os"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// scriptSource returns the Script of the gateway, or the content of its
+// ScriptFile, read again for every message so that it can be edited on the fly.
+// It returns nil when the gateway has none.
+func (gw *Gateway) scriptSource() ([]byte, error) {
+	if gw.MyConfig == nil {
+		return nil, nil
+	}
+	if gw.MyConfig.Script != "" {
+		return []byte(gw.MyConfig.Script), nil
+	}
+	if gw.MyConfig.ScriptFile == "" {
+		return nil, nil
+	}
+	return os.ReadFile(gw.MyConfig.ScriptFile)
+}
+
+// runScript runs the Script or ScriptFile of the gateway on msg, before it is
+// relayed to the bridges of the gateway. The script can change msgText and
+// msgUsername, drop the message by setting msgDrop, and relay it to the
+// channels named msgRedirect of the gateway only. The changes are made to a
+// copy of msg, which the other gateways don't see.
+//
+// It returns nil when the script drops the message, and msg itself when the
+// gateway has no script or when it fails.
+func (gw *Gateway) runScript(msg *config.Message) *config.Message {
+	source, err := gw.scriptSource()
+	if err != nil {
+		gw.logger.Errorf("Reading the script of gateway %s failed: %s", gw.Name, err)
+		return msg
+	}
+	if source == nil {
+		return msg
+	}
+
+	s := tengo.NewScript(source)
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	_ = s.Add("msgText", msg.Text)
+	_ = s.Add("msgUsername", msg.Username)
+	_ = s.Add("msgUserID", msg.UserID)
+	_ = s.Add("msgAccount", msg.Account)
+	_ = s.Add("msgChannel", msg.Channel)
+	_ = s.Add("msgProtocol", msg.Protocol)
+	_ = s.Add("msgEvent", msg.Event)
+	_ = s.Add("gateway", gw.Name)
+	_ = s.Add("msgDrop", false)
+	_ = s.Add("msgRedirect", "")
+	c, err := s.Compile()
+	if err == nil {
+		err = c.Run()
+	}
+	if err != nil {
+		gw.logger.Errorf("Script of gateway %s failed: %s", gw.Name, err)
+		return msg
+	}
+
+	if c.Get("msgDrop").Bool() {
+		return nil
+	}
+	scripted := *msg
+	scripted.Text = c.Get("msgText").String()
+	scripted.Username = c.Get("msgUsername").String()
+	if redirect := c.Get("msgRedirect").String(); redirect != "" {
+		scripted.SetRedirect(redirect)
+	}
+	return &scripted
+}
+
+// redirectChannels returns the channels named redirect, a message redirected
+// by a script isn't relayed to the others.
+func redirectChannels(channels []config.ChannelInfo, redirect string) []config.ChannelInfo {
+	var redirected []config.ChannelInfo
+	for _, channel := range channels {
+		if channel.Name == redirect {
+			redirected = append(redirected, channel)
+		}
+	}
+	return redirected
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScript(t *testing.T) {
+	r, gw := newTestGateway()
+	irc := &flakyBridger{Bridger: gw.Bridges[ircTestAccount].Bridger}
+	gw.Bridges[ircTestAccount].Bridger = irc
+	slack := &flakyBridger{Bridger: gw.Bridges[slackTestAccount].Bridger}
+	gw.Bridges[slackTestAccount].Bridger = slack
+	for _, account := range []string{ircTestAccount, slackTestAccount} {
+		r.markBridgeStarted(account)
+	}
+	gw.MyConfig.Script = `
+text := import("text")
+if text.has_prefix(msgText, "spam") {
+	msgDrop = true
+} else if text.has_prefix(msgText, "irc:") {
+	msgText = text.trim_prefix(msgText, "irc:")
+	msgRedirect = "#main"
+} else {
+	msgUsername = msgUsername + " (" + msgProtocol + ")"
+}
+`
+	relay := func(text string) {
+		r.relayMessage(gw, &config.Message{Text: text, Username: "alice", Account: tgTestAccount, Channel: "-1111111111111", Protocol: "telegram", Gateway: "bridge"})
+	}
+
+	relay("spam and eggs")
+	assert.Empty(t, irc.sent)
+	assert.Empty(t, slack.sent)
+
+	relay("irc:only for irc")
+	assert.Equal(t, []string{"only for irc"}, irc.sent)
+	assert.Empty(t, slack.sent)
+
+	msg := &config.Message{Text: "hello", Username: "alice", Protocol: "telegram"}
+	assert.Equal(t, "alice (telegram)", gw.runScript(msg).Username)
+	assert.Equal(t, "alice", msg.Username, "the other gateways don't see the changes")
+
+	// the messages are relayed untouched when the script fails
+	gw.MyConfig.Script = "msgText = msgText +"
+	assert.Equal(t, msg, gw.runScript(msg))
+}
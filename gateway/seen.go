@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// seenSize is the number of nicks and user IDs whose last message is
+// remembered by the seen command.
+const seenSize = 10000
+
+// lastSeen is when and where a user last spoke.
+type lastSeen struct {
+	Username string
+	Account  string
+	Channel  string
+	At       time.Time
+}
+
+// recordSeen remembers when and where the sender of msg spoke, by nick and by
+// user ID.
+func (r *Router) recordSeen(msg *config.Message) {
+	if msg.Username == "" || msg.Event != "" && msg.Event != config.EventUserAction || r.optedOut(msg.Account, msg.UserID) {
+		return
+	}
+	seen := lastSeen{Username: msg.Username, Account: msg.Account, Channel: msg.Channel, At: msg.Timestamp}
+	if seen.At.IsZero() {
+		seen.At = time.Now()
+	}
+	r.seen.Add(strings.ToLower(msg.Username), seen)
+	if msg.UserID != "" {
+		r.seen.Add("id:"+msg.UserID, seen)
+	}
+}
+
+// findSeen returns when and where who, a nick or a user ID, last spoke since
+// matterbridge started.
+func (r *Router) findSeen(who string) (lastSeen, bool) {
+	for _, key := range []string{strings.ToLower(who), "id:" + who} {
+		if seen, ok := r.seen.Get(key); ok {
+			return seen.(lastSeen), true
+		}
+	}
+	return lastSeen{}, false
+}
+
+// formatAgo returns d as a short "... ago".
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "less than a minute ago"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh%02dm ago", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+}
@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeen(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	irc := r.getBridge(ircTestAccount)
+	at := time.Now().Add(-90 * time.Minute)
+
+	r.recordSeen(&config.Message{Text: "hi", Username: "Alice", UserID: "12345", Account: tgTestAccount, Channel: "-1111111111111", Timestamp: at})
+	r.recordSeen(&config.Message{Event: config.EventJoinLeave, Username: "bob", Account: ircTestAccount, Channel: "#main"})
+
+	want := "Alice was last seen 1h30m ago (" + at.Format(scheduleLayout) + ") in -1111111111111 on telegram.zzz"
+	assert.Equal(t, want, r.runCommand(irc, &config.Message{}, "seen alice"))
+	assert.Equal(t, want, r.runCommand(irc, &config.Message{}, "seen 12345"))
+	assert.Equal(t, "bob hasn't been seen", r.runCommand(irc, &config.Message{}, "seen bob"))
+	assert.Equal(t, "seen failed: a nick or user id is required", r.runCommand(irc, &config.Message{}, "seen"))
+}
+
+func TestFormatAgo(t *testing.T) {
+	assert.Equal(t, "less than a minute ago", formatAgo(30*time.Second))
+	assert.Equal(t, "5m ago", formatAgo(5*time.Minute+10*time.Second))
+	assert.Equal(t, "26h05m ago", formatAgo(26*time.Hour+5*time.Minute))
+	assert.Equal(t, "3 days ago", formatAgo(80*time.Hour))
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"slices"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+// heldMessage is a message received while an earlier message of its source
+// waits for its files, with the highlights it matched.
+type heldMessage struct {
+	msg        config.Message
+	highlights []*highlight
+	// files are the spool files of msg held until it is routed
+	files []string
+}
+
+// sourceKey returns the key of the source of msg, the channel of an account,
+// whose messages are relayed in order.
+func sourceKey(msg *config.Message) string {
+	return msg.Account + " " + msg.Channel
+}
+
+// waitForFiles holds the next messages of the source key until the files of
+// the message being handled in the background are, see releaseSource.
+//
+// The sources are only used by the goroutine of handleReceive.
+func (r *Router) waitForFiles(key string) {
+	if _, waiting := r.sources[key]; !waiting {
+		r.sources[key] = nil
+	}
+}
+
+// holdMessage holds msg when an earlier message of its source waits for its
+// files, and returns true if it did. The messages held are ordered by their
+// timestamp, the bridges which receive the messages of a channel concurrently
+// can pass them out of order, and the oldest one is dropped when there are too
+// many.
+func (r *Router) holdMessage(msg *config.Message, highlights []*highlight) bool {
+	key := sourceKey(msg)
+	held, waiting := r.sources[key]
+	if !waiting {
+		return false
+	}
+	if len(held) >= maxQueuedMessages {
+		r.logger.Warnf("Too many messages of %s waiting for an earlier one, dropping the oldest one", key)
+		r.auditMessage(auditDrop, auditQueueFull, nil, &held[0].msg, "")
+		r.releaseFiles(held[0].files)
+		held = held[1:]
+	}
+	i := len(held)
+	for i > 0 && !msg.Timestamp.IsZero() && msg.Timestamp.Before(held[i-1].msg.Timestamp) {
+		i--
+	}
+	r.sources[key] = slices.Insert(held, i, heldMessage{msg: *msg, highlights: highlights, files: r.holdFiles(msg)})
+	return true
+}
+
+// releaseSource routes the messages held for the source key once the files of
+// the message it waited for are handled, in order, holding the next ones again
+// when one of them has files to handle in turn.
+func (r *Router) releaseSource(key string) {
+	held := r.sources[key]
+	delete(r.sources, key)
+	for _, next := range held {
+		if !r.holdMessage(&next.msg, next.highlights) {
+			r.routeMessage(next.msg, r.sortedGateways(), false, next.highlights)
+		}
+		r.releaseFiles(next.files)
+	}
+}
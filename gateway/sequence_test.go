@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceOrdering(t *testing.T) {
+	r := maketestRouter([]byte(`
+[general]
+MediaDownloadPath="` + t.TempDir() + `"
+MediaServerDownload="https://example.com/media/"
+[irc.zzz]
+server=""
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="ordered"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`))
+	slack := r.Gateways["ordered"].Bridges[slackTestAccount]
+	flaky := &flakyBridger{Bridger: slack.Bridger}
+	slack.Bridger = flaky
+	r.markBridgeStarted(slackTestAccount)
+
+	now := time.Now()
+	message := func(text string, offset time.Duration, withFile bool) config.Message {
+		msg := config.Message{Text: text, Username: "alice", Account: ircTestAccount, Channel: "#main", Timestamp: now.Add(offset)}
+		if withFile {
+			data := []byte(text)
+			msg.Extra = map[string][]interface{}{"file": {config.FileInfo{Name: text + ".txt", Data: &data}}}
+		}
+		return msg
+	}
+
+	// the messages sent while the files of the first one are handled wait
+	// for it, in the order they were written
+	r.receiveMessage(message("photo", 0, true))
+	r.receiveMessage(message("second", 2*time.Second, false))
+	r.receiveMessage(message("first", time.Second, false))
+	r.receiveMessage(message("document", 3*time.Second, true))
+	r.receiveMessage(message("third", 4*time.Second, false))
+	assert.Empty(t, flaky.sent)
+
+	r.handleResolved(<-r.resolved)
+	assert.Equal(t, []string{"photo", "first", "second"}, flaky.sent)
+	assert.Len(t, r.sources[sourceKey(&config.Message{Account: ircTestAccount, Channel: "#main"})], 1)
+
+	r.handleResolved(<-r.resolved)
+	assert.Equal(t, []string{"photo", "first", "second", "document", "third"}, flaky.sent)
+	assert.Empty(t, r.sources)
+
+	// without files to wait for the messages are relayed at once
+	r.receiveMessage(message("fourth", 5*time.Second, false))
+	assert.Equal(t, "fourth", flaky.sent[len(flaky.sent)-1])
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/bridge/helper"
+)
+
+// spoolRefs counts the references to the spool files. A reference is held by
+// the router while it handles a message, and by every queue keeping a copy of
+// it (held sources, rate limits, outboxes, breakers and media queues). A file
+// is removed once the last one is released, when every destination sent or
+// dropped its message.
+type spoolRefs struct {
+	sync.Mutex
+	refs map[string]int
+}
+
+// prepareSpool creates the spool directory in MediaSpoolPath, and removes the
+// files a previous run left in it. The other files of MediaSpoolPath are left
+// alone.
+func (r *Router) prepareSpool() error {
+	path := r.BridgeValues().General.MediaSpoolPath
+	if path == "" {
+		return nil
+	}
+	dir := helper.SpoolDir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("creating the MediaSpoolPath directory failed: %w", err)
+	}
+	removed, err := helper.CleanSpool(dir, 0)
+	if err != nil {
+		return fmt.Errorf("cleaning the MediaSpoolPath directory failed: %w", err)
+	}
+	if removed > 0 {
+		r.logger.Infof("Removed %d files left in %s", removed, dir)
+	}
+	return nil
+}
+
+// spooledFiles returns the spool files of the files of msg.
+func spooledFiles(msg *config.Message) []string {
+	var paths []string
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Path != "" {
+			paths = append(paths, fi.Path)
+		}
+	}
+	return paths
+}
+
+// holdFiles takes a reference to the spool files of msg, and returns them to
+// be released with releaseFiles. They are returned rather than read again from
+// msg on release, as the bridges can change the files of the messages they
+// send.
+func (r *Router) holdFiles(msg *config.Message) []string {
+	paths := spooledFiles(msg)
+	if len(paths) == 0 {
+		return nil
+	}
+	r.spool.Lock()
+	defer r.spool.Unlock()
+	if r.spool.refs == nil {
+		r.spool.refs = make(map[string]int)
+	}
+	for _, path := range paths {
+		r.spool.refs[path]++
+	}
+	return paths
+}
+
+// releaseFiles releases the references taken by holdFiles, and removes the
+// files which aren't referenced anymore.
+func (r *Router) releaseFiles(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	r.spool.Lock()
+	defer r.spool.Unlock()
+	for _, path := range paths {
+		r.spool.refs[path]--
+		if r.spool.refs[path] > 0 {
+			continue
+		}
+		delete(r.spool.refs, path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			r.logger.Errorf("Removing the spool file %s failed: %s", path, err)
+			continue
+		}
+		r.logger.Debugf("Removed the spool file %s, relayed to every destination", path)
+	}
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spooledMessage(t *testing.T, name string) (config.Message, string) {
+	path := filepath.Join(t.TempDir(), "matterbridge-"+name)
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+	msg := config.Message{Text: name, Extra: map[string][]any{
+		"file": {config.FileInfo{Name: name, Path: path}, config.FileInfo{Name: "small.png"}},
+	}}
+	return msg, path
+}
+
+func TestSpoolRefs(t *testing.T) {
+	r, _ := newTestGateway()
+	msg, path := spooledMessage(t, "video.mp4")
+
+	first := r.holdFiles(&msg)
+	second := r.holdFiles(&msg)
+	assert.Equal(t, []string{path}, first)
+	r.releaseFiles(first)
+	assert.FileExists(t, path)
+	r.releaseFiles(second)
+	assert.NoFileExists(t, path)
+	assert.Empty(t, r.spool.refs)
+
+	// the messages without spooled files hold nothing
+	assert.Nil(t, r.holdFiles(&config.Message{Text: "hello"}))
+}
+
+func TestSpoolQueuedFile(t *testing.T) {
+	r, gw := newTestGateway()
+	tg := gw.Bridges[tgTestAccount]
+	tg.Bridger = &flakyBridger{Bridger: tg.Bridger}
+	msg, path := spooledMessage(t, "video.mp4")
+
+	// the file outlives the handling of the message while it is queued
+	files := r.holdFiles(&msg)
+	breaker := r.getBreaker(tg.Account)
+	breaker.Lock()
+	breaker.enqueue(gw, tg, msg, "-1111111111111"+tgTestAccount, "")
+	queue := breaker.queue
+	breaker.queue = nil
+	breaker.Unlock()
+	r.releaseFiles(files)
+	assert.FileExists(t, path)
+
+	// and is removed once sent
+	assert.True(t, gw.flushQueue(tg, breaker, queue))
+	assert.NoFileExists(t, path)
+
+	// or dropped
+	msg, path = spooledMessage(t, "audio.ogg")
+	breaker.Lock()
+	breaker.enqueue(gw, tg, msg, "-1111111111111"+tgTestAccount, "")
+	breaker.Unlock()
+	assert.FileExists(t, path)
+	_, err := r.DropQueue(tg.Account)
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+}
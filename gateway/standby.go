@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+)
+
+// standbySettings returns the Standby settings of account, which replace its
+// settings when its credentials are refused.
+func (r *Router) standbySettings(account string) map[string]any {
+	settings, ok := r.GetStringMap(account + ".standby")
+	if !ok {
+		return nil
+	}
+	return settings
+}
+
+// canFailOver returns true if err shows that the credentials of account were
+// refused (banned nick, revoked token, ...), and account has Standby settings
+// it doesn't use yet.
+func (r *Router) canFailOver(account string, err error) bool {
+	if bridge.ErrorClassOf(err) != bridge.ErrorAuth || len(r.standbySettings(account)) == 0 {
+		return false
+	}
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return !r.standby[account]
+}
+
+// failOver switches account to its Standby settings when err shows that its
+// credentials were refused, and alerts the moderators. The standby settings
+// are kept until the configuration is reloaded or matterbridge restarts.
+// Returns true if the bridge has to connect again with them.
+func (r *Router) failOver(account string, err error) bool {
+	br := r.getBridge(account)
+	settings := r.standbySettings(account)
+	if br == nil || bridge.ErrorClassOf(err) != bridge.ErrorAuth || len(settings) == 0 {
+		return false
+	}
+	r.statusMu.Lock()
+	if r.standby[account] {
+		r.statusMu.Unlock()
+		return false
+	}
+	r.standby[account] = true
+	r.statusMu.Unlock()
+
+	br.UseCredentials(settings)
+	r.logger.Warnf("%s: %s, switching to its standby credentials", account, err)
+	r.run(func() {
+		r.alert(account, fmt.Sprintf("%s was refused by its server (%s) and switched to its standby credentials", account, err))
+	})
+	return true
+}
+
+// resetStandby goes back to the credentials of the configuration for the
+// accounts using their standby ones, when the configuration is reloaded: they
+// are used from the next connection of the bridges.
+func (r *Router) resetStandby() {
+	r.statusMu.Lock()
+	accounts := r.standby
+	r.standby = make(map[string]bool)
+	r.statusMu.Unlock()
+	for account := range accounts {
+		if br := r.getBridge(account); br != nil {
+			br.ResetCredentials()
+			r.logger.Infof("%s will use the credentials of the configuration again", account)
+		}
+	}
+}
+
+// onStandby returns true if account uses its Standby settings.
+func (r *Router) onStandby(account string) bool {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return r.standby[account]
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandby(t *testing.T) {
+	r := maketestRouter([]byte(`
+[irc.zzz]
+server=""
+Nick="bridgebot"
+    [irc.zzz.standby]
+    Nick="sparebot"
+    NickServPassword="secret"
+[slack.zzz]
+server=""
+
+[[gateway]]
+name="main"
+enable=true
+    [[gateway.inout]]
+    account="irc.zzz"
+    channel="#main"
+    [[gateway.inout]]
+    account="slack.zzz"
+    channel="main"
+`))
+	defer r.Stop()
+	irc := r.getBridge(ircTestAccount)
+	r.setBridgeStatus(ircTestAccount, BridgeConnected, nil)
+	refused := bridge.NewError(bridge.ErrorAuth, errors.New("Closing Link: (K-Lined)"))
+
+	assert.False(t, r.failOver(ircTestAccount, errors.New("connection reset")))
+	assert.False(t, r.failOver(slackTestAccount, refused))
+	assert.True(t, r.canFailOver(ircTestAccount, refused))
+	assert.True(t, r.failOver(ircTestAccount, refused))
+	assert.Equal(t, "sparebot", irc.GetString("Nick"))
+	assert.Equal(t, "secret", irc.GetString("NickServPassword"))
+	assert.True(t, r.BridgeStatus()[0].Standby)
+
+	// the standby credentials are only used once
+	assert.False(t, r.canFailOver(ircTestAccount, refused))
+	assert.False(t, r.failOver(ircTestAccount, refused))
+
+	// and aren't written to the configuration, which takes over again once
+	// reloaded
+	nick, _ := r.GetString(ircTestAccount + ".Nick")
+	assert.Equal(t, "bridgebot", nick)
+	r.resetStandby()
+	assert.Equal(t, "bridgebot", irc.GetString("Nick"))
+	assert.Empty(t, irc.GetString("NickServPassword"))
+	assert.False(t, r.BridgeStatus()[0].Standby)
+	assert.True(t, r.failOver(ircTestAccount, refused))
+}
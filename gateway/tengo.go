@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"os"
+	"sync"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+	"github.com/matterbridge-org/matterbridge/internal"
+)
+
+// tengoVar is a global variable of a tengo script.
+type tengoVar struct {
+	name  string
+	value interface{}
+}
+
+// tengoScripts holds the scripts of the [tengo] section, compiled the first
+// time they run and until the configuration is reloaded rather than for every
+// message.
+type tengoScripts struct {
+	sync.Mutex
+	compiled map[string]*tengo.Compiled
+}
+
+// run runs the script of the file filename, or of the asset of matterbridge
+// when asset is true, with the variables vars. It returns the script which
+// ran, to read its variables.
+func (t *tengoScripts) run(filename string, asset bool, vars []tengoVar) (*tengo.Compiled, error) {
+	compiled, err := t.compile(filename, asset, vars)
+	if err != nil {
+		return nil, err
+	}
+	c := compiled.Clone()
+	for _, v := range vars {
+		if err := c.Set(v.name, v.value); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Run(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compile returns the script of filename compiled with the variables vars
+// declared, compiling it the first time.
+func (t *tengoScripts) compile(filename string, asset bool, vars []tengoVar) (*tengo.Compiled, error) {
+	key := filename
+	if asset {
+		key = "asset:" + filename
+	}
+	t.Lock()
+	defer t.Unlock()
+	if c, ok := t.compiled[key]; ok {
+		return c, nil
+	}
+
+	var (
+		source []byte
+		err    error
+	)
+	if asset {
+		source, err = internal.Asset(filename)
+	} else {
+		source, err = os.ReadFile(filename) //nolint:gosec
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := tengo.NewScript(source)
+	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	for _, v := range vars {
+		if err := s.Add(v.name, v.value); err != nil {
+			return nil, err
+		}
+	}
+	c, err := s.Compile()
+	if err != nil {
+		return nil, err
+	}
+	if t.compiled == nil {
+		t.compiled = make(map[string]*tengo.Compiled)
+	}
+	t.compiled[key] = c
+	return c, nil
+}
+
+// reset forgets the compiled scripts, which are read again from their files
+// the next time they run.
+func (t *tengoScripts) reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.compiled = nil
+}
@@ -0,0 +1,48 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTengoScripts(t *testing.T) {
+	r, gw := newTestGateway()
+	path := filepath.Join(t.TempDir(), "outmessage.tengo")
+	require.NoError(t, os.WriteFile(path, []byte(`msgText = outProtocol + ": " + msgText`), 0o600))
+	gw.BridgeValues().Tengo.OutMessage = path
+	defer func() { gw.BridgeValues().Tengo.OutMessage = "" }()
+	irc := gw.Bridges[ircTestAccount]
+
+	// the script is compiled once and runs on every message concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := &config.Message{Text: "hello"}
+			drop, err := gw.modifyOutMessageTengo(&config.Message{}, msg, irc)
+			assert.NoError(t, err)
+			assert.False(t, drop)
+			assert.Equal(t, "irc: hello", msg.Text)
+		}()
+	}
+	wg.Wait()
+
+	// the edits of the script apply once the configuration is reloaded
+	require.NoError(t, os.WriteFile(path, []byte(`msgDrop = msgText == "spam"`), 0o600))
+	msg := &config.Message{Text: "spam"}
+	drop, err := gw.modifyOutMessageTengo(&config.Message{}, msg, irc)
+	require.NoError(t, err)
+	assert.False(t, drop)
+	r.tengo.reset()
+	msg = &config.Message{Text: "spam"}
+	drop, err = gw.modifyOutMessageTengo(&config.Message{}, msg, irc)
+	require.NoError(t, err)
+	assert.True(t, drop)
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+)
+
+const (
+	// defaultMessageSamples is the number of messages relayed or dropped last
+	// kept for the admin API, unless MessageSamples is set.
+	defaultMessageSamples = 100
+	// previewLength is the number of characters of the texts kept in the
+	// samples.
+	previewLength = 20
+)
+
+// trafficRelay is the action of the samples of the relayed messages, the
+// dropped ones use the action and reasons of the audit log.
+const trafficRelay = "relay"
+
+// MessageSample is a message relayed or dropped by the router, with the
+// beginning of its text only.
+type MessageSample struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+	Gateway string    `json:"gateway,omitempty"`
+	// Account and Channel are where the message comes from, Dest the account
+	// it was sent to
+	Account   string `json:"account"`
+	Channel   string `json:"channel"`
+	Dest      string `json:"dest,omitempty"`
+	Username  string `json:"username,omitempty"`
+	MessageID string `json:"msgid,omitempty"`
+	Preview   string `json:"preview,omitempty"`
+}
+
+// ChannelTraffic counts the messages of a channel relayed or dropped by a
+// gateway.
+type ChannelTraffic struct {
+	Gateway string `json:"gateway"`
+	Account string `json:"account"`
+	Channel string `json:"channel"`
+	Relayed int64  `json:"relayed"`
+	// Dropped are the dropped messages by reason
+	Dropped map[string]int64 `json:"dropped"`
+}
+
+// traffic holds the counters of the channels and the last messages relayed or
+// dropped, for the admin API.
+type traffic struct {
+	sync.Mutex
+	channels map[string]*ChannelTraffic
+	// samples is a ring of the last messages, next the index of the next one
+	samples []MessageSample
+	next    int
+	full    bool
+	// noPreview is set with AuditLogHashContent
+	noPreview bool
+}
+
+func newTraffic(size int, noPreview bool) *traffic {
+	if size <= 0 {
+		size = defaultMessageSamples
+	}
+	return &traffic{
+		channels:  make(map[string]*ChannelTraffic),
+		samples:   make([]MessageSample, size),
+		noPreview: noPreview,
+	}
+}
+
+// record counts s and keeps it as the last sample.
+func (t *traffic) record(s MessageSample, text string) {
+	if !t.noPreview {
+		s.Preview = preview(text)
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	key := s.Gateway + "\x00" + s.Account + "\x00" + s.Channel
+	ct, ok := t.channels[key]
+	if !ok {
+		ct = &ChannelTraffic{Gateway: s.Gateway, Account: s.Account, Channel: s.Channel, Dropped: make(map[string]int64)}
+		t.channels[key] = ct
+	}
+	if s.Action == trafficRelay {
+		ct.Relayed++
+	} else {
+		ct.Dropped[s.Reason]++
+	}
+
+	t.samples[t.next] = s
+	t.next = (t.next + 1) % len(t.samples)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// preview returns the beginning of text on one line.
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= previewLength {
+		return text
+	}
+	return string(runes[:previewLength]) + "…"
+}
+
+// recordTraffic counts msg of gw as relayed, or dropped for reason, and keeps
+// it as a sample.
+func (r *Router) recordTraffic(action string, reason string, gw *Gateway, msg *config.Message, dest string) {
+	if r == nil || r.traffic == nil {
+		return
+	}
+	s := MessageSample{
+		Time:      time.Now(),
+		Action:    action,
+		Reason:    reason,
+		Account:   msg.Account,
+		Channel:   msg.Channel,
+		Dest:      dest,
+		Username:  msg.Username,
+		MessageID: msg.ID,
+	}
+	if gw != nil {
+		s.Gateway = gw.Name
+	}
+	r.traffic.record(s, msg.Text)
+}
+
+// MessageSamples returns the last messages relayed or dropped, newest first,
+// of gateway, channel and with action when they aren't empty.
+func (r *Router) MessageSamples(gateway string, channel string, action string) []MessageSample {
+	t := r.traffic
+	t.Lock()
+	defer t.Unlock()
+
+	samples := []MessageSample{}
+	n := t.next
+	if t.full {
+		n = len(t.samples)
+	}
+	for i := 1; i <= n; i++ {
+		s := t.samples[(t.next-i+len(t.samples))%len(t.samples)]
+		if gateway != "" && s.Gateway != gateway || channel != "" && s.Channel != channel || action != "" && s.Action != action {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// ChannelTraffic returns the counters of the channels, ordered by gateway,
+// account and channel.
+func (r *Router) ChannelTraffic() []ChannelTraffic {
+	t := r.traffic
+	t.Lock()
+	defer t.Unlock()
+
+	channels := make([]ChannelTraffic, 0, len(t.channels))
+	for _, ct := range t.channels {
+		c := *ct
+		c.Dropped = make(map[string]int64, len(ct.Dropped))
+		for reason, n := range ct.Dropped {
+			c.Dropped[reason] = n
+		}
+		channels = append(channels, c)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+		if a.Gateway != b.Gateway {
+			return a.Gateway < b.Gateway
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Channel < b.Channel
+	})
+	return channels
+}
+
+// writeTrafficMetrics writes the counters of the channels in the Prometheus
+// text format.
+func writeTrafficMetrics(w io.Writer, channels []ChannelTraffic) {
+	const relayed, dropped = "matterbridge_messages_relayed_total", "matterbridge_messages_dropped_total"
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", relayed, "Messages of the channel relayed by the gateway.", relayed)
+	for _, c := range channels {
+		fmt.Fprintf(w, "%s{gateway=%q,account=%q,channel=%q} %d\n", relayed, c.Gateway, c.Account, c.Channel, c.Relayed)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", dropped, "Messages of the channel dropped by the gateway, by reason.", dropped)
+	for _, c := range channels {
+		reasons := make([]string, 0, len(c.Dropped))
+		for reason := range c.Dropped {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "%s{gateway=%q,account=%q,channel=%q,reason=%q} %d\n", dropped, c.Gateway, c.Account, c.Channel, reason, c.Dropped[reason])
+		}
+	}
+}
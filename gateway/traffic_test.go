@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageSamples(t *testing.T) {
+	r := maketestRouter(testconfig3)
+	r.traffic = newTraffic(2, false)
+	gw := r.Gateways["bridge"]
+	gw.Bridges[ircTestAccount].SetString("IgnoreNicks", "spammer")
+	defer gw.Bridges[ircTestAccount].SetString("IgnoreNicks", "")
+
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "buy now, cheap watches and more", Username: "spammer", Account: ircTestAccount, Channel: "#main"}))
+	r.recordTraffic(trafficRelay, "", gw, &config.Message{Text: "hello", Username: "alice", Account: ircTestAccount, Channel: "#main"}, "")
+	r.recordTraffic(trafficRelay, "", gw, &config.Message{Text: "hi", Username: "bob", Account: ircTestAccount, Channel: "#other"}, "")
+
+	// the oldest sample is replaced, the counters are kept
+	samples := r.MessageSamples("", "", "")
+	require.Len(t, samples, 2)
+	assert.Equal(t, "bob", samples[0].Username)
+	assert.Equal(t, "hello", samples[1].Preview)
+	assert.Equal(t, []MessageSample{samples[1]}, r.MessageSamples("bridge", "#main", ""))
+	assert.Empty(t, r.MessageSamples("", "", auditDrop))
+
+	assert.Equal(t, []ChannelTraffic{
+		{Gateway: "bridge", Account: ircTestAccount, Channel: "#main", Relayed: 1, Dropped: map[string]int64{auditIgnoredNick: 1}},
+		{Gateway: "bridge", Account: ircTestAccount, Channel: "#other", Relayed: 1, Dropped: map[string]int64{}},
+	}, r.ChannelTraffic())
+	assert.Equal(t, "buy now, cheap watch…", preview("buy now,\ncheap watches and more"))
+
+	server := httptest.NewServer(r.adminHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `matterbridge_messages_relayed_total{gateway="bridge",account="irc.zzz",channel="#main"} 1`)
+	assert.Contains(t, string(body), `matterbridge_messages_dropped_total{gateway="bridge",account="irc.zzz",channel="#main",reason="ignored nick"} 1`)
+
+	// the samples aren't served without AdminToken
+	resp, err = http.Get(server.URL + "/api/messages")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
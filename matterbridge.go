@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/google/gops/agent"
 	"github.com/matterbridge-org/matterbridge/bridge/config"
@@ -22,6 +25,7 @@ var (
 	flagVersion = flag.Bool("version", false, "show version")
 	flagGops    = flag.Bool("gops", false, "enable gops agent")
 	flagNoColor = flag.Bool("nocolor", false, "disable colored logs")
+	flagHealth  = flag.Bool("healthcheck", false, "check the health of the running matterbridge (see AdminListen) and exit")
 )
 
 func main() {
@@ -34,6 +38,37 @@ func main() {
 	rootLogger := setupLogger()
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "main"})
 
+	if flag.Arg(0) == "migrate-config" {
+		if err := migrateConfig(logger, *flagConfig); err != nil {
+			logger.Fatalf("Migrating configuration failed: %s", err)
+		}
+		return
+	}
+
+	if *flagHealth {
+		if err := runHealthcheck(config.NewConfig(rootLogger, *flagConfig), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "queue" {
+		if err := runQueueCommand(config.NewConfig(rootLogger, *flagConfig), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "diagnostics" {
+		if err := runDiagnosticsCommand(config.NewConfig(rootLogger, *flagConfig), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *flagGops {
 		if err := agent.Listen(agent.Options{}); err != nil {
 			logger.Errorf("Failed to start gops agent: %#v", err)
@@ -67,7 +102,56 @@ func main() {
 		logger.Fatalf("Starting gateway failed: %s", err)
 	}
 	logger.Printf("Gateway(s) started successfully. Now relaying messages")
-	select {}
+	reloadOnHangup(logger, cfg)
+}
+
+// reloadOnHangup reloads the configuration on SIGHUP, like when its file
+// changes.
+func reloadOnHangup(logger *logrus.Entry, cfg config.Config) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		logger.Info("SIGHUP received, reloading the configuration")
+		if err := cfg.Reload(); err != nil {
+			logger.Errorf("Reloading the configuration failed: %s", err)
+		}
+	}
+}
+
+// migrateConfig rewrites the configuration file with deprecated options
+// replaced by their current equivalent. The original file is kept with a
+// .bak suffix.
+func migrateConfig(logger *logrus.Entry, cfgfile string) error {
+	if ext := filepath.Ext(cfgfile); ext != ".toml" && ext != "" {
+		return fmt.Errorf("only TOML configuration files can be migrated, not %s", cfgfile)
+	}
+
+	input, err := os.ReadFile(cfgfile) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	output, changes := config.MigrateTOML(input)
+	if len(changes) == 0 {
+		logger.Infof("%s is up to date, nothing to migrate", cfgfile)
+		return nil
+	}
+
+	for _, change := range changes {
+		logger.Info(change)
+	}
+
+	if err := os.WriteFile(cfgfile+".bak", input, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(cfgfile, output, 0o600); err != nil {
+		return err
+	}
+
+	logger.Infof("Migrated %s, the previous version was saved as %s.bak", cfgfile, cfgfile)
+
+	return nil
 }
 
 func setupLogger() *logrus.Logger {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matterbridge-org/matterbridge/bridge/config"
+	"github.com/matterbridge-org/matterbridge/gateway"
+)
+
+const queueUsage = `usage: matterbridge [-conf matterbridge.toml] queue <command>
+
+Commands:
+  list [account]    list the messages queued for the bridges which failed to send
+  replay <account>  send the messages queued for account now
+  drop <account>    discard the messages queued for account
+
+The commands talk to the admin API of the running matterbridge, see AdminListen.`
+
+// maxQueueTextLength is the length of the text of the messages shown by
+// "queue list".
+const maxQueueTextLength = 60
+
+// queueCommand runs the queue subcommand with args, writing its output to out.
+func queueCommand(client *adminClient, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(queueUsage)
+	}
+	switch args[0] {
+	case "list":
+		path := "/api/queues"
+		if len(args) > 1 {
+			path += "?account=" + url.QueryEscape(args[1])
+		}
+		var queues []gateway.Queue
+		if err := client.do(http.MethodGet, path, &queues); err != nil {
+			return err
+		}
+		printQueues(out, queues)
+		return nil
+	case "replay", "drop":
+		if len(args) != 2 {
+			return errors.New(queueUsage)
+		}
+		method, path := http.MethodPost, "/api/queues/"+url.PathEscape(args[1])+"/replay"
+		if args[0] == "drop" {
+			method, path = http.MethodDelete, "/api/queues/"+url.PathEscape(args[1])
+		}
+		var result gateway.QueueResult
+		if err := client.do(method, path, &result); err != nil {
+			return err
+		}
+		verb := "sent"
+		if args[0] == "drop" {
+			verb = "dropped"
+		}
+		fmt.Fprintf(out, "%s: %d messages %s, %d still queued\n", result.Account, result.Processed, verb, result.Remaining)
+		return nil
+	default:
+		return errors.New(queueUsage)
+	}
+}
+
+func printQueues(out io.Writer, queues []gateway.Queue) {
+	if len(queues) == 0 {
+		fmt.Fprintln(out, "No messages queued")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, queue := range queues {
+		state := "healthy"
+		if queue.Unhealthy {
+			state = "unhealthy"
+		}
+		fmt.Fprintf(w, "%s (%s, %d failures): %d messages queued\n", queue.Account, state, queue.Failures, len(queue.Messages))
+		for i, msg := range queue.Messages {
+			text := strings.ReplaceAll(msg.Text, "\n", " ")
+			if runes := []rune(text); len(runes) > maxQueueTextLength {
+				text = string(runes[:maxQueueTextLength]) + "…"
+			}
+			if msg.Files > 0 {
+				text += fmt.Sprintf(" [%d files]", msg.Files)
+			}
+			fmt.Fprintf(w, "  %d\t%s\t%s/%s\t%s\t%s\n", i+1, msg.Timestamp.Format(time.DateTime), msg.Gateway, msg.Channel, strings.TrimSpace(msg.Username), text)
+		}
+	}
+	w.Flush()
+}
+
+func runQueueCommand(cfg config.Config, args []string) error {
+	client, err := newAdminClient(cfg)
+	if err != nil {
+		return err
+	}
+	return queueCommand(client, args, os.Stdout)
+}